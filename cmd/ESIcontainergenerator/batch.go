@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+)
+
+// watchInterval is how often -watch polls the input for changes
+const watchInterval = 500 * time.Millisecond
+
+// browserJSONSuffix names the browser JSON written for each configuration of a directory.
+// Such files are skipped when the directory is read, so they may be written next to the inputs.
+const browserJSONSuffix = ".browser.json"
+
+// outputOptions are the generation settings shared by every input
+type outputOptions struct {
+	// output and outputJSON are files for a single input and directories for a directory input
+	output       string
+	outputJSON   string
+	batch        bool
+	validateOnly bool
+	target       string
+	esiConfig    esi.ESIConfig
+}
+
+// paths returns the output and browser JSON files of an input
+func (o outputOptions) paths(inputFile string) (string, string) {
+	baseName := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+	extension := ".html"
+	if o.target != esi.TargetAkamai {
+		extension = ".js"
+	}
+
+	if !o.batch {
+		if o.output == "" {
+			return baseName + extension, o.outputJSON
+		}
+		return o.output, o.outputJSON
+	}
+
+	outputDir := o.output
+	if outputDir == "" {
+		outputDir = filepath.Dir(inputFile)
+	}
+	outputJSON := ""
+	if o.outputJSON != "" {
+		outputJSON = filepath.Join(o.outputJSON, baseName+browserJSONSuffix)
+	}
+	return filepath.Join(outputDir, baseName+extension), outputJSON
+}
+
+// generationResult is the outcome of generating a single configuration
+type generationResult struct {
+	input         string
+	output        string
+	outputJSON    string
+	pixels        int
+	dir           int
+	frm           int
+	script        int
+	img           int
+	browserPixels int
+	macros        *esi.MacroAnalysis
+	err           error
+}
+
+// invalidConfigError lists the validation errors of a configuration
+type invalidConfigError struct {
+	file string
+	errs []esi.ValidationError
+}
+
+func (e *invalidConfigError) Error() string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "%s is invalid:", e.file)
+	for _, validationError := range e.errs {
+		fmt.Fprintf(&message, "\n   - %s", validationError.Error())
+	}
+	return message.String()
+}
+
+// loadConfig reads, validates and parses a configuration file
+func loadConfig(inputFile string) (esi.ContainerConfig, error) {
+	var config esi.ContainerConfig
+
+	inputData, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return config, fmt.Errorf("reading input file: %w", err)
+	}
+	if validationErrors := esi.ValidateConfig(inputData); len(validationErrors) > 0 {
+		return config, &invalidConfigError{file: inputFile, errs: validationErrors}
+	}
+	if err := json.Unmarshal(inputData, &config); err != nil {
+		return config, fmt.Errorf("parsing JSON: %w", err)
+	}
+	return config, nil
+}
+
+// generateFile generates and writes the outputs of a parsed configuration
+func generateFile(inputFile string, config esi.ContainerConfig, options outputOptions) (*generationResult, error) {
+	result := &generationResult{input: inputFile, pixels: len(config.Pixels)}
+	for _, pixel := range config.Pixels {
+		switch pixel.TYPE {
+		case "dir":
+			result.dir++
+		case "frm":
+			result.frm++
+		case "script":
+			result.script++
+		case "img":
+			result.img++
+		}
+	}
+
+	macros, err := esi.AnalyzeMacros(config, options.esiConfig)
+	if err != nil {
+		return nil, err
+	}
+	result.macros = macros
+
+	var output string
+	var browserConfig esi.ContainerConfig
+	switch options.target {
+	case esi.TargetAkamai:
+		esiContent, akamaiBrowserConfig, err := esi.ProcessContainerConfig(config, options.esiConfig)
+		if err != nil {
+			return nil, err
+		}
+		output = generateHTMLContent(esiContent, macros)
+		// Fail before anything is written when the container would break at the edge
+		if err := esi.CheckContainerOutput(output, options.esiConfig); err != nil {
+			return nil, err
+		}
+		browserConfig = akamaiBrowserConfig
+	default:
+		script, scriptBrowserConfig, err := esi.GenerateEdgeScript(config, options.esiConfig, options.target)
+		if err != nil {
+			return nil, err
+		}
+		output = script
+		browserConfig = scriptBrowserConfig
+	}
+
+	result.output, result.outputJSON = options.paths(inputFile)
+	if err := ioutil.WriteFile(result.output, []byte(output), 0644); err != nil {
+		return nil, fmt.Errorf("error writing output file: %w", err)
+	}
+	if result.outputJSON != "" {
+		if err := generateBrowserJSON(esi.BuildBrowserManifest(browserConfig, options.esiConfig), result.outputJSON); err != nil {
+			return nil, err
+		}
+		result.browserPixels = len(browserConfig.Pixels)
+	}
+	return result, nil
+}
+
+// generateInput loads and generates a configuration, recording any failure in the result
+func generateInput(inputFile string, options outputOptions) *generationResult {
+	config, err := loadConfig(inputFile)
+	if err != nil {
+		return &generationResult{input: inputFile, err: err}
+	}
+	if options.validateOnly {
+		return &generationResult{input: inputFile, pixels: len(config.Pixels)}
+	}
+
+	result, err := generateFile(inputFile, config, options)
+	if err != nil {
+		return &generationResult{input: inputFile, pixels: len(config.Pixels), err: err}
+	}
+	return result
+}
+
+// generateInputs generates each configuration in turn
+func generateInputs(inputFiles []string, options outputOptions) []*generationResult {
+	results := make([]*generationResult, 0, len(inputFiles))
+	for _, inputFile := range inputFiles {
+		results = append(results, generateInput(inputFile, options))
+	}
+	return results
+}
+
+// configFiles returns the JSON configurations of a directory, skipping generated browser JSON
+func configFiles(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		if !strings.HasSuffix(match, browserJSONSuffix) {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// prepareOutputDirs creates the output directories of a directory input
+func prepareOutputDirs(options outputOptions) error {
+	for _, dir := range []string{options.output, options.outputJSON} {
+		if dir == "" || options.validateOnly {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating output directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// printSummary prints a table of generation results followed by their errors
+func printSummary(results []*generationResult) {
+	writeSummary(os.Stdout, os.Stderr, results)
+}
+
+// writeSummary writes the table of generation results to out and their errors to errOut
+func writeSummary(out, errOut io.Writer, results []*generationResult) {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "INPUT\tPIXELS\tDIR\tFRM\tSCRIPT\tIMG\tFUNCTIONS\tOUTPUT\tSTATUS")
+
+	failures := 0
+	for _, result := range results {
+		status := "✅"
+		output := result.output
+		if result.err != nil {
+			status = "❌"
+			failures++
+		}
+		if output == "" {
+			output = "-"
+		}
+		functions := "-"
+		if result.macros != nil && len(result.macros.Functions) > 0 {
+			functions = strings.Join(result.macros.Functions, ",")
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n", result.input, result.pixels, result.dir, result.frm, result.script, result.img, functions, output, status)
+	}
+	writer.Flush()
+
+	fmt.Fprintf(out, "\n📊 %d configurations, %d succeeded, %d failed\n", len(results), len(results)-failures, failures)
+	for _, result := range results {
+		if _, invalid := result.err.(*invalidConfigError); invalid {
+			fmt.Fprintf(errOut, "❌ %v\n", result.err)
+		} else if result.err != nil {
+			fmt.Fprintf(errOut, "❌ %s: %v\n", result.input, result.err)
+		}
+	}
+}
+
+// failed reports whether any result failed
+func failed(results []*generationResult) bool {
+	for _, result := range results {
+		if result.err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// inputModTimes returns the modification time of the input file, or of every
+// configuration in an input directory
+func inputModTimes(input string, batch bool) map[string]time.Time {
+	files := []string{input}
+	if batch {
+		files, _ = configFiles(input)
+	}
+
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+// watchInputs polls the input until interrupted, or until stop is closed, and calls
+// regenerate with the configurations that were added or modified since the last poll
+func watchInputs(input string, batch bool, stop <-chan struct{}, regenerate func(changed []string)) {
+	fmt.Printf("\n👀 Watching %s for changes (Ctrl+C to stop)\n", input)
+
+	modTimes := inputModTimes(input, batch)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(watchInterval):
+		}
+
+		current := inputModTimes(input, batch)
+		var changed []string
+		for file, modTime := range current {
+			if previous, seen := modTimes[file]; !seen || !previous.Equal(modTime) {
+				changed = append(changed, file)
+			}
+		}
+		modTimes = current
+
+		if len(changed) > 0 {
+			sort.Strings(changed)
+			fmt.Printf("\n🔄 %s changed at %s\n", strings.Join(changed, ", "), time.Now().Format("15:04:05"))
+			regenerate(changed)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	validConfig   = `{"pixels": [{"ID": "a", "URL": "https://example.com/a.gif", "TYPE": "dir"}, {"ID": "b", "URL": "https://example.com/b.js", "TYPE": "script"}]}`
+	invalidConfig = `{"pixels": [{"ID": "a", "URL": "https://example.com/a", "CONDITIONS": {"frequencyPeriod": "week"}}]}`
+)
+
+// writeConfigs writes the named configurations to a new directory
+func writeConfigs(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestOutputOptions_Paths(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    outputOptions
+		output     string
+		outputJSON string
+	}{
+		{"single input defaults to its name", outputOptions{target: esi.TargetAkamai}, "site.html", ""},
+		{"single input with outputs", outputOptions{target: esi.TargetAkamai, output: "out.html", outputJSON: "browser.json"}, "out.html", "browser.json"},
+		{"script targets write js", outputOptions{target: esi.TargetFastly}, "site.js", ""},
+		{"batch writes next to the input", outputOptions{target: esi.TargetAkamai, batch: true}, filepath.Join("configs", "site.html"), ""},
+		{"batch with output directories", outputOptions{target: esi.TargetCloudflare, batch: true, output: "out", outputJSON: "json"},
+			filepath.Join("out", "site.js"), filepath.Join("json", "site"+browserJSONSuffix)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, outputJSON := test.options.paths(filepath.Join("configs", "site.json"))
+			assert.Equal(t, test.output, output)
+			assert.Equal(t, test.outputJSON, outputJSON)
+		})
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	dir := writeConfigs(t, map[string]string{
+		"b.json":                validConfig,
+		"a.json":                validConfig,
+		"a" + browserJSONSuffix: `{}`,
+		"notes.txt":             "not a configuration",
+	})
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nested", "c.json"), []byte(validConfig), 0o644))
+
+	files, err := configFiles(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")}, files)
+}
+
+func TestGenerateInputs_MixedBatch(t *testing.T) {
+	dir := writeConfigs(t, map[string]string{"good.json": validConfig, "bad.json": invalidConfig})
+	options := outputOptions{target: esi.TargetAkamai, batch: true, output: filepath.Join(dir, "out"), outputJSON: filepath.Join(dir, "json")}
+	require.NoError(t, prepareOutputDirs(options))
+	files, err := configFiles(dir)
+	require.NoError(t, err)
+
+	results := generateInputs(files, options)
+	require.Len(t, results, 2)
+	assert.True(t, failed(results))
+	assert.False(t, failed(results[1:]))
+
+	bad, good := results[0], results[1]
+	var invalid *invalidConfigError
+	assert.ErrorAs(t, bad.err, &invalid)
+	require.NoError(t, good.err)
+	assert.Equal(t, 2, good.pixels)
+	assert.Equal(t, 1, good.dir)
+	assert.Equal(t, 1, good.browserPixels)
+	assert.FileExists(t, filepath.Join(dir, "out", "good.html"))
+	assert.FileExists(t, filepath.Join(dir, "json", "good"+browserJSONSuffix))
+	assert.NoFileExists(t, filepath.Join(dir, "out", "bad.html"))
+
+	var out, errOut bytes.Buffer
+	writeSummary(&out, &errOut, results)
+	assert.Contains(t, out.String(), "2 configurations, 1 succeeded, 1 failed")
+	assert.Regexp(t, `good\.json\s+2\s+1\s+0\s+1\s+0\s+`, out.String())
+	assert.Contains(t, errOut.String(), "bad.json is invalid:")
+	assert.Contains(t, errOut.String(), "frequencyPeriod")
+	assert.NotContains(t, errOut.String(), "good.json")
+
+	// Validating alone writes nothing
+	options.validateOnly = true
+	options.output = filepath.Join(dir, "validated")
+	results = generateInputs(files, options)
+	assert.True(t, failed(results))
+	assert.NoDirExists(t, options.output)
+}
+
+func TestWatchInputs_RegeneratesChangedFiles(t *testing.T) {
+	dir := writeConfigs(t, map[string]string{"a.json": validConfig, "b.json": validConfig})
+	modTimes := inputModTimes(dir, true)
+	require.Len(t, modTimes, 2)
+
+	stop := make(chan struct{})
+	changes := make(chan []string, 1)
+	done := make(chan struct{})
+	go func() {
+		watchInputs(dir, true, stop, func(changed []string) { changes <- changed })
+		close(done)
+	}()
+
+	// Let the watcher take its first snapshot before touching the file
+	time.Sleep(watchInterval / 2)
+	changedAt := modTimes[filepath.Join(dir, "b.json")].Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "b.json"), changedAt, changedAt))
+
+	select {
+	case changed := <-changes:
+		assert.Equal(t, []string{filepath.Join(dir, "b.json")}, changed)
+	case <-time.After(5 * watchInterval):
+		t.Fatal("the changed configuration was not regenerated")
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * watchInterval):
+		t.Fatal("the watcher did not stop")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -14,11 +15,30 @@ import (
 
 func main() {
 	// Define command line flags
-	inputFile := flag.String("input", "", "Input JSON configuration file")
-	outputFile := flag.String("output", "", "Output HTML file (default: input_name.html)")
+	inputFile := flag.String("input", "", "Input JSON configuration file, or a directory of configurations to generate together")
+	outputFile := flag.String("output", "", "Output file (default: input_name.html, or input_name.js for script targets); a directory for directory inputs")
 	browserVars := flag.Bool("browser-vars", false, "Use browser-like ESI variable substitution")
 	maxWait := flag.Int("maxwait", 0, "Maximum wait time for ESI includes (default: 0 for fire-and-forget)")
-	outputJSON := flag.String("output-json", "", "Output JSON file for browser-executed pixels (frm/script types)")
+	outputJSON := flag.String("output-json", "", "Output JSON file for browser-executed pixels (frm/script/img types); a directory for directory inputs")
+	browserTimeout := flag.Int("browser-timeout", esi.DefaultBrowserTimeout, "Milliseconds browser pixels may run before the loader removes them")
+	browserLoader := flag.String("browser-loader", "", "Write the JavaScript loader executing the browser JSON to this file")
+	conditionMode := flag.String("conditions", esi.ConditionModeRuntime, "Condition evaluation: runtime (esi:choose at the edge) or static (at generation time)")
+	staticContextFile := flag.String("static-context", "", "JSON request context used to evaluate conditions in static mode")
+	geoHeaderPrefix := flag.String("geo-header-prefix", "", "Prefix of the geo override headers, e.g. X-Emulator-Geo-, that set the country of static and simulated requests")
+	hashAlgorithm := flag.String("hash-algorithm", esi.HashMD5, "Cookie hash and suu algorithm: md5, sha1 or sha256 (sha variants need static mode)")
+	hashSalt := flag.String("hash-salt", "", "Default salt for hpr/hpo cookie hash macros without their own salt")
+	maxIncludes := flag.Int("max-includes", esi.DefaultContainerIncludeBudget, "Include budget a request to the generated container must stay within")
+	maxConcurrent := flag.Int("max-concurrent", 0, "Maximum independent beacons per batch (default: 0 for no limit)")
+	frequencyEndpoint := flag.String("frequency-endpoint", "", "Emulator /frequency URL that frequency capped pixels report fires to")
+	target := flag.String("target", esi.TargetAkamai, "Output target: akamai (ESI HTML), fastly (Compute JavaScript) or cloudflare (Worker module)")
+	simulate := flag.Bool("simulate", false, "Dry-run the container against a request and report which beacons fire, without writing output files")
+	simulateRequestFile := flag.String("simulate-request", "", "JSON request context for -simulate (default: the -static-context file)")
+	mockEndpointsFile := flag.String("mock-endpoints", "", "JSON file mapping beacon hosts to mocked responses for -simulate")
+	simulateReportFile := flag.String("simulate-report", "", "Write the -simulate report as JSON to this file")
+	partnersFile := flag.String("partners", "", "JSON file with custom partner templates to register")
+	diffFile := flag.String("diff", "", "Previous JSON configuration to compare the input against; prints a change report and the generated ESI diff")
+	watch := flag.Bool("watch", false, "Regenerate the outputs whenever the input changes")
+	validateOnly := flag.Bool("validate-only", false, "Validate the input configuration and exit without generating")
 	showHelp := flag.Bool("help", false, "Show help information")
 
 	flag.Parse()
@@ -33,83 +53,287 @@ func main() {
 		log.Fatal("Error: Input file is required. Use -input flag to specify the JSON configuration file.")
 	}
 
-	// Read input JSON file
-	inputData, err := ioutil.ReadFile(*inputFile)
-	if err != nil {
-		log.Fatalf("Error reading input file: %v", err)
+	// Register custom partner templates before validation, which checks partner references
+	if *partnersFile != "" {
+		partnerData, err := ioutil.ReadFile(*partnersFile)
+		if err != nil {
+			log.Fatalf("Error reading partners file: %v", err)
+		}
+		var partners []esi.PartnerTemplate
+		if err := json.Unmarshal(partnerData, &partners); err != nil {
+			log.Fatalf("Error parsing partners JSON: %v", err)
+		}
+		for _, partner := range partners {
+			if err := esi.RegisterPartner(partner); err != nil {
+				log.Fatalf("Error registering partner: %v", err)
+			}
+		}
 	}
 
-	// Parse JSON configuration
-	var config esi.ContainerConfig
-	if err := json.Unmarshal(inputData, &config); err != nil {
-		log.Fatalf("Error parsing JSON: %v", err)
+	if *conditionMode != esi.ConditionModeRuntime && *conditionMode != esi.ConditionModeStatic {
+		log.Fatalf("Error: -conditions must be %q or %q", esi.ConditionModeRuntime, esi.ConditionModeStatic)
 	}
 
 	// Create ESI configuration
 	esiConfig := esi.ESIConfig{
-		BrowserVars: *browserVars,
-		MaxWait:     *maxWait,
+		BrowserVars:   *browserVars,
+		MaxWait:       *maxWait,
+		ConditionMode: *conditionMode,
+		Geo:           esi.EmulatorGeoProvider{HeaderPrefix: *geoHeaderPrefix},
+		Hashing: esi.HashConfig{
+			Algorithm: *hashAlgorithm,
+			Salt:      *hashSalt,
+		},
+		MaxConcurrentBeacons: *maxConcurrent,
+		FrequencyEndpoint:    *frequencyEndpoint,
+		MaxIncludes:          *maxIncludes,
+		BrowserTimeout:       *browserTimeout,
+	}
+
+	// Read the static condition context if provided
+	if *staticContextFile != "" {
+		contextData, err := ioutil.ReadFile(*staticContextFile)
+		if err != nil {
+			log.Fatalf("Error reading static context file: %v", err)
+		}
+		var staticContext esi.ConditionContext
+		if err := json.Unmarshal(contextData, &staticContext); err != nil {
+			log.Fatalf("Error parsing static context JSON: %v", err)
+		}
+		esiConfig.StaticContext = &staticContext
 	}
 
-	// Process the configuration
-	esiContent, browserConfig, err := esi.ProcessContainerConfig(config, esiConfig)
+	if *target != esi.TargetAkamai && *target != esi.TargetFastly && *target != esi.TargetCloudflare {
+		log.Fatalf("Error: -target must be %q, %q or %q", esi.TargetAkamai, esi.TargetFastly, esi.TargetCloudflare)
+	}
+
+	inputInfo, err := os.Stat(*inputFile)
 	if err != nil {
-		log.Fatalf("Error processing configuration: %v", err)
+		log.Fatalf("Error reading input file: %v", err)
+	}
+	options := outputOptions{
+		output:       *outputFile,
+		outputJSON:   *outputJSON,
+		batch:        inputInfo.IsDir(),
+		validateOnly: *validateOnly,
+		target:       *target,
+		esiConfig:    esiConfig,
 	}
 
-	// Generate output filename if not provided
-	if *outputFile == "" {
-		baseName := strings.TrimSuffix(filepath.Base(*inputFile), filepath.Ext(*inputFile))
-		*outputFile = baseName + ".html"
+	// The loader is the same for every configuration. A single input's loader fetches
+	// its browser JSON, assumed to be served next to it.
+	if *browserLoader != "" && !*validateOnly {
+		manifestURL := ""
+		if !options.batch && *outputJSON != "" {
+			manifestURL = filepath.Base(*outputJSON)
+		}
+		if err := ioutil.WriteFile(*browserLoader, []byte(esi.GenerateBrowserLoader(manifestURL)), 0644); err != nil {
+			log.Fatalf("Error writing browser loader: %v", err)
+		}
+		fmt.Printf("✅ Generated browser loader: %s\n", *browserLoader)
 	}
 
-	// Generate the complete HTML content
-	htmlContent := generateHTMLContent(esiContent, esiConfig)
+	// A directory input generates every configuration in it and prints a summary
+	if options.batch {
+		if *simulate || *diffFile != "" {
+			log.Fatal("Error: -simulate and -diff need a single -input file")
+		}
+		if err := prepareOutputDirs(options); err != nil {
+			log.Fatal(err)
+		}
+		files, err := configFiles(*inputFile)
+		if err != nil {
+			log.Fatalf("Error reading input directory: %v", err)
+		}
+
+		results := generateInputs(files, options)
+		printSummary(results)
+		if *watch {
+			watchInputs(*inputFile, true, nil, func(changed []string) {
+				printSummary(generateInputs(changed, options))
+			})
+		}
+		if failed(results) {
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Write HTML output
-	if err := ioutil.WriteFile(*outputFile, []byte(htmlContent), 0644); err != nil {
-		log.Fatalf("Error writing HTML output file: %v", err)
+	// Validate and parse the configuration before generating anything
+	config, err := loadConfig(*inputFile)
+	if err != nil {
+		if _, invalid := err.(*invalidConfigError); invalid {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		log.Fatalf("Error %v", err)
+	}
+	if *validateOnly {
+		fmt.Printf("✅ %s is valid\n", *inputFile)
+		return
 	}
 
-	fmt.Printf("✅ Generated HTML file: %s\n", *outputFile)
-	fmt.Printf("📊 Processed %d pixels:\n", len(config.Pixels))
+	// Report the changes from a previous configuration instead of generating
+	if *diffFile != "" {
+		previousData, err := ioutil.ReadFile(*diffFile)
+		if err != nil {
+			log.Fatalf("Error reading diff file: %v", err)
+		}
+		var previous esi.ContainerConfig
+		if err := json.Unmarshal(previousData, &previous); err != nil {
+			log.Fatalf("Error parsing diff JSON: %v", err)
+		}
 
-	// Count pixel types
-	dirCount := 0
-	frmCount := 0
-	scriptCount := 0
-	for _, pixel := range config.Pixels {
-		switch pixel.TYPE {
-		case "dir":
-			dirCount++
-		case "frm":
-			frmCount++
-		case "script":
-			scriptCount++
+		diff, err := esi.DiffConfigs(previous, config, esiConfig)
+		if err != nil {
+			log.Fatalf("Error comparing configurations: %v", err)
 		}
+		fmt.Printf("🔍 Changes from %s to %s:\n", *diffFile, *inputFile)
+		fmt.Print(diff.Report())
+		return
 	}
 
-	fmt.Printf("   - %d 'dir' pixels → ESI includes\n", dirCount)
-	fmt.Printf("   - %d 'frm' pixels → Browser execution\n", frmCount)
-	fmt.Printf("   - %d 'script' pixels → Browser execution\n", scriptCount)
+	// Dry-run the container instead of generating it
+	if *simulate {
+		requestContext := esiConfig.StaticContext
+		if *simulateRequestFile != "" {
+			requestData, err := ioutil.ReadFile(*simulateRequestFile)
+			if err != nil {
+				log.Fatalf("Error reading simulation request file: %v", err)
+			}
+			requestContext = &esi.ConditionContext{}
+			if err := json.Unmarshal(requestData, requestContext); err != nil {
+				log.Fatalf("Error parsing simulation request JSON: %v", err)
+			}
+		}
+
+		simulationOptions := esi.NewSimulationOptions(requestContext, esiConfig.Geo)
+		if *mockEndpointsFile != "" {
+			endpointData, err := ioutil.ReadFile(*mockEndpointsFile)
+			if err != nil {
+				log.Fatalf("Error reading mock endpoints file: %v", err)
+			}
+			if err := json.Unmarshal(endpointData, &simulationOptions.Endpoints); err != nil {
+				log.Fatalf("Error parsing mock endpoints JSON: %v", err)
+			}
+		}
 
-	// Generate browser JSON file if requested
-	if *outputJSON != "" {
-		if err := generateBrowserJSON(browserConfig, *outputJSON); err != nil {
-			log.Fatalf("Error writing browser JSON file: %v", err)
+		report, err := esi.SimulateContainer(config, esiConfig, simulationOptions)
+		if err != nil {
+			log.Fatalf("Error simulating configuration: %v", err)
 		}
-		fmt.Printf("✅ Generated browser JSON file: %s\n", *outputJSON)
-		fmt.Printf("📋 Browser JSON contains %d pixels for client-side execution\n", len(browserConfig.Pixels))
+		printSimulationReport(report)
+
+		if *simulateReportFile != "" {
+			reportData, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				log.Fatalf("Error encoding simulation report: %v", err)
+			}
+			if err := ioutil.WriteFile(*simulateReportFile, reportData, 0644); err != nil {
+				log.Fatalf("Error writing simulation report: %v", err)
+			}
+			fmt.Printf("✅ Generated simulation report: %s\n", *simulateReportFile)
+		}
+		return
+	}
+
+	// Process the configuration for the selected target
+	result, err := generateFile(*inputFile, config, options)
+	if err != nil {
+		log.Fatalf("Error processing configuration: %v", err)
+	}
+
+	if *target == esi.TargetAkamai {
+		fmt.Printf("✅ Generated HTML file: %s\n", result.output)
+	} else {
+		fmt.Printf("✅ Generated %s script: %s\n", *target, result.output)
+	}
+	fmt.Printf("📊 Processed %d pixels:\n", result.pixels)
+	fmt.Printf("   - %d 'dir' pixels → Server-side beacons\n", result.dir)
+	fmt.Printf("   - %d 'frm' pixels → Browser execution\n", result.frm)
+	fmt.Printf("   - %d 'script' pixels → Browser execution\n", result.script)
+	fmt.Printf("   - %d 'img' pixels → Browser execution\n", result.img)
+	printMacroAnalysis(result.macros)
+
+	if result.outputJSON != "" {
+		fmt.Printf("✅ Generated browser JSON file: %s\n", result.outputJSON)
+		fmt.Printf("📋 Browser JSON contains %d pixels for client-side execution\n", result.browserPixels)
 	}
 
 	// Show configuration details
 	fmt.Printf("\n🔧 Configuration:\n")
+	fmt.Printf("   - Target: %s\n", *target)
 	fmt.Printf("   - Browser variables: %t\n", esiConfig.BrowserVars)
 	fmt.Printf("   - Max wait time: %d\n", esiConfig.MaxWait)
 	fmt.Printf("   - Fire-and-forget: %t\n", esiConfig.MaxWait == 0)
+	fmt.Printf("   - Condition evaluation: %s\n", esiConfig.ConditionMode)
+	fmt.Printf("   - Hash algorithm: %s\n", esiConfig.Hashing.Algorithm)
+	fmt.Printf("   - Max concurrent beacons: %d\n", esiConfig.MaxConcurrentBeacons)
+	fmt.Printf("   - Include budget: %d\n", esiConfig.MaxIncludes)
+	if esiConfig.FrequencyEndpoint != "" {
+		fmt.Printf("   - Frequency endpoint: %s\n", esiConfig.FrequencyEndpoint)
+	}
+
+	if *watch {
+		watchInputs(*inputFile, false, nil, func(changed []string) {
+			printSummary(generateInputs(changed, options))
+		})
+	}
 }
 
-func generateHTMLContent(esiContent string, config esi.ESIConfig) string {
+// printMacroAnalysis prints the macros of each server-side pixel and the ESI functions
+// the container includes for them
+func printMacroAnalysis(analysis *esi.MacroAnalysis) {
+	fmt.Printf("\n🧩 Macros:\n")
+	for _, pixel := range analysis.Pixels {
+		macros := "none"
+		if len(pixel.Macros) > 0 {
+			macros = "~~" + strings.Join(pixel.Macros, "~~, ~~") + "~~"
+		}
+		fmt.Printf("   - %s: %s", pixel.ID, macros)
+		if len(pixel.Functions) > 0 {
+			fmt.Printf(" (functions: %s)", strings.Join(pixel.Functions, ", "))
+		}
+		fmt.Println()
+	}
+	if len(analysis.Functions) == 0 {
+		fmt.Printf("   ESI functions: none needed\n")
+	} else {
+		fmt.Printf("   ESI functions: %s\n", strings.Join(analysis.Functions, ", "))
+	}
+}
+
+// printSimulationReport prints the outcome of every simulated beacon and the totals
+func printSimulationReport(report *esi.SimulationReport) {
+	icons := map[string]string{
+		esi.BeaconFired:      "✅",
+		esi.BeaconFailed:     "❌",
+		esi.BeaconSuppressed: "🚫",
+		esi.BeaconSkipped:    "⏭️",
+	}
+
+	fmt.Printf("🧪 Simulated %d beacons:\n", len(report.Beacons))
+	for _, beacon := range report.Beacons {
+		fmt.Printf("   %s [batch %d] %s: %s", icons[beacon.Outcome], beacon.Batch, beacon.ID, beacon.Outcome)
+		if beacon.Reason != "" {
+			fmt.Printf(" (%s)", beacon.Reason)
+		}
+		fmt.Println()
+		if beacon.URL != "" {
+			fmt.Printf("        %s %s (%dms)\n", beacon.Method, beacon.URL, beacon.LatencyMS)
+		}
+		if beacon.Body != "" {
+			fmt.Printf("        body: %s\n", beacon.Body)
+		}
+	}
+
+	fmt.Printf("\n📊 %d fired, %d failed, %d suppressed, %d skipped\n", report.Fired, report.Failed, report.Suppressed, report.Skipped)
+	fmt.Printf("⏱️  Latency: %dms sequential, ~%dms at the edge\n", report.TotalLatencyMS, report.ParallelLatencyMS)
+}
+
+// generateHTMLContent wraps the ESI content in an HTML page, preceded by the ESI
+// functions the pixels' macros need
+func generateHTMLContent(esiContent string, analysis *esi.MacroAnalysis) string {
 	var html strings.Builder
 
 	html.WriteString("<!DOCTYPE html>\n")
@@ -119,9 +343,10 @@ func generateHTMLContent(esiContent string, config esi.ESIConfig) string {
 	html.WriteString("    <title>ESI Container Generated Content</title>\n")
 	html.WriteString("</head>\n")
 	html.WriteString("<body>\n")
-	html.WriteString("    <!-- ESI Functions for Advanced Macro Processing -->\n")
-	html.WriteString(esi.GenerateESIFunctions())
-	html.WriteString("\n\n")
+	if preamble := analysis.Preamble(); preamble != "" {
+		html.WriteString(preamble)
+		html.WriteString("\n\n")
+	}
 	html.WriteString("    <!-- Generated ESI Content -->\n")
 	html.WriteString(esiContent)
 	html.WriteString("\n</body>\n")
@@ -130,9 +355,10 @@ func generateHTMLContent(esiContent string, config esi.ESIConfig) string {
 	return html.String()
 }
 
-func generateBrowserJSON(config esi.ContainerConfig, outputFile string) error {
+// generateBrowserJSON writes the browser JSON of the browser-executed pixels
+func generateBrowserJSON(manifest esi.BrowserManifest, outputFile string) error {
 	// Convert to JSON
-	jsonData, err := json.MarshalIndent(config, "", "  ")
+	jsonData, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
 		return fmt.Errorf("error marshaling browser config to JSON: %w", err)
 	}
@@ -150,24 +376,62 @@ func printHelp() {
 	fmt.Println("=======================")
 	fmt.Println()
 	fmt.Println("Converts JSON partner beacon configurations into ESI includes for server-side execution.")
-	fmt.Println("Filters 'frm' and 'script' type pixels for browser execution.")
+	fmt.Println("Filters 'frm', 'script' and 'img' type pixels for browser execution.")
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  ESIcontainergenerator -input config.json [options]")
 	fmt.Println()
 	fmt.Println("Required Flags:")
 	fmt.Println("  -input string")
-	fmt.Println("        Input JSON configuration file")
+	fmt.Println("        Input JSON configuration file, or a directory of configurations to generate together")
 	fmt.Println()
 	fmt.Println("Optional Flags:")
 	fmt.Println("  -output string")
-	fmt.Println("        Output HTML file (default: input_name.html)")
+	fmt.Println("        Output file (default: input_name.html, or input_name.js for script targets)")
 	fmt.Println("  -output-json string")
-	fmt.Println("        Output JSON file for browser-executed pixels (frm/script types)")
+	fmt.Println("        Output JSON file for browser-executed pixels (frm/script/img types)")
+	fmt.Println("  -browser-timeout int")
+	fmt.Println("        Milliseconds browser pixels may run before the loader removes them (default: 5000)")
+	fmt.Println("  -browser-loader string")
+	fmt.Println("        Write the JavaScript loader executing the browser JSON to this file")
 	fmt.Println("  -browser-vars")
 	fmt.Println("        Use browser-like ESI variable substitution")
 	fmt.Println("  -maxwait int")
 	fmt.Println("        Maximum wait time for ESI includes (default: 0 for fire-and-forget)")
+	fmt.Println("  -conditions string")
+	fmt.Println("        Condition evaluation: runtime (esi:choose at the edge) or static (default: runtime)")
+	fmt.Println("  -static-context string")
+	fmt.Println("        JSON request context used to evaluate conditions in static mode")
+	fmt.Println("  -geo-header-prefix string")
+	fmt.Println("        Prefix of the geo override headers, e.g. X-Emulator-Geo-, that set the country of static and simulated requests")
+	fmt.Println("  -hash-algorithm string")
+	fmt.Println("        Cookie hash and suu algorithm: md5, sha1 or sha256 (default: md5; sha variants need static mode)")
+	fmt.Println("  -hash-salt string")
+	fmt.Println("        Default salt for hpr/hpo cookie hash macros without their own salt")
+	fmt.Println("  -max-includes int")
+	fmt.Println("        Include budget a request to the generated container must stay within (default: 256)")
+	fmt.Println("  -max-concurrent int")
+	fmt.Println("        Maximum independent beacons per batch (default: 0 for no limit)")
+	fmt.Println("  -frequency-endpoint string")
+	fmt.Println("        Emulator /frequency URL that frequency capped pixels report fires to")
+	fmt.Println("  -target string")
+	fmt.Println("        Output target: akamai (ESI HTML), fastly (Compute JavaScript) or cloudflare (Worker module) (default: akamai)")
+	fmt.Println("  -simulate")
+	fmt.Println("        Dry-run the container against a request and report which beacons fire, without writing output files")
+	fmt.Println("  -simulate-request string")
+	fmt.Println("        JSON request context for -simulate (default: the -static-context file)")
+	fmt.Println("  -mock-endpoints string")
+	fmt.Println("        JSON file mapping beacon hosts to mocked responses for -simulate")
+	fmt.Println("  -simulate-report string")
+	fmt.Println("        Write the -simulate report as JSON to this file")
+	fmt.Println("  -partners string")
+	fmt.Println("        JSON file with custom partner templates to register")
+	fmt.Println("  -diff string")
+	fmt.Println("        Previous JSON configuration to compare the input against; prints a change report and the generated ESI diff")
+	fmt.Println("  -watch")
+	fmt.Println("        Regenerate the outputs whenever the input changes")
+	fmt.Println("  -validate-only")
+	fmt.Println("        Validate the input configuration and exit without generating")
 	fmt.Println("  -help")
 	fmt.Println("        Show this help information")
 	fmt.Println()
@@ -181,12 +445,25 @@ func printHelp() {
 	fmt.Println("  # With browser variables")
 	fmt.Println("  ESIcontainergenerator -input partner_beacons.json -browser-vars")
 	fmt.Println()
+	fmt.Println("  # Evaluate conditions at generation time")
+	fmt.Println("  ESIcontainergenerator -input partner_beacons.json -conditions static -static-context request.json")
+	fmt.Println()
+	fmt.Println("  # Generate every configuration of a directory and regenerate on changes")
+	fmt.Println("  ESIcontainergenerator -input configs/ -output out/ -output-json out/ -watch")
+	fmt.Println()
+	fmt.Println("  # Review a partner's pixel update")
+	fmt.Println("  ESIcontainergenerator -input partner_beacons.json -diff partner_beacons.previous.json")
+	fmt.Println()
 	fmt.Println("Features:")
 	fmt.Println("  ✅ Converts 'dir' type pixels to ESI includes")
-	fmt.Println("  ✅ Filters 'frm' and 'script' pixels for browser execution")
+	fmt.Println("  ✅ Filters 'frm', 'script' and 'img' pixels for browser execution")
+	fmt.Println("  ✅ Browser JSON with execution instructions and a JavaScript loader")
 	fmt.Println("  ✅ Supports advanced macro substitution")
 	fmt.Println("  ✅ Generates fingerprint IDs (suu)")
+	fmt.Println("  ✅ Emits only the ESI functions the macros need")
 	fmt.Println("  ✅ Handles cookie hashing (hpr/hpo)")
 	fmt.Println("  ✅ URL decoding support")
 	fmt.Println("  ✅ Fire-and-forget execution (MAXWAIT=0)")
+	fmt.Println("  ✅ Checks the generated ESI parses and stays within the include budget")
+	fmt.Println("  ✅ Country, consent, frequency cap and key/value conditions")
 }
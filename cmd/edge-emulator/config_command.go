@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/edge-computing/emulator-suite/internal/config"
+)
+
+// configCommand runs the config subcommands and returns the exit code
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "Usage: edge-emulator config validate [config-file]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	file := flags.String("config", "", "YAML or JSON configuration file to validate")
+	flags.Parse(args[1:])
+	if flags.NArg() > 0 {
+		*file = flags.Arg(0)
+	}
+
+	cfg, err := config.LoadWithFile(*file)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	source := "defaults and environment"
+	if *file != "" {
+		source = *file + " with environment overrides"
+	}
+	fmt.Printf("✅ Configuration from %s is valid\n", source)
+	printConfig(cfg)
+	return 0
+}
+
+// printConfig prints the effective settings
+func printConfig(cfg *config.Config) {
+	fmt.Printf("  server:           %s (mode %s, debug %t)\n", cfg.GetAddress(), cfg.EmulatorMode, cfg.Debug)
+	fmt.Printf("  limits:           body %d bytes, response %d bytes\n", cfg.MaxBodySize, cfg.MaxResponseSize)
+	fmt.Printf("  cors origins:     %v\n", cfg.CORSAllowedOrigins)
+	fmt.Printf("  esi:              mode %s, max includes %d, max depth %d, output %s\n", cfg.ESIMode, cfg.ESIMaxIncludes, cfg.ESIMaxDepth, cfg.ESIOutput)
+	fmt.Printf("  cache:            enabled %t, ttl %ds\n", cfg.CacheEnabled, cfg.CacheTTL)
+	if cfg.PropertyFile != "" {
+		fmt.Printf("  property file:    %s\n", cfg.PropertyFile)
+	}
+	if cfg.FastlyVCLFile != "" {
+		fmt.Printf("  fastly vcl:       %s\n", cfg.FastlyVCLFile)
+	}
+	if cfg.ESIHistoryDB != "" {
+		fmt.Printf("  history db:       %s\n", cfg.ESIHistoryDB)
+	}
+	if cfg.HasTemplates() {
+		fmt.Printf("  templates:        %s%s (pins %v)\n", cfg.ESITemplatesDir, cfg.ESITemplatesURL, cfg.ESITemplatePins)
+	}
+	if cfg.ExamplesDir != "" {
+		fmt.Printf("  examples dir:     %s\n", cfg.ExamplesDir)
+	}
+	fmt.Printf("  logging:          level %s, format %s\n", cfg.LogLevel, cfg.LogFormat)
+	if len(cfg.LogComponentLevels) > 0 {
+		fmt.Printf("  component levels: %v\n", cfg.LogComponentLevels)
+	}
+}
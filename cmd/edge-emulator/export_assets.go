@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/edge-computing/emulator-suite/internal/config"
+	"github.com/edge-computing/emulator-suite/pkg/server"
+)
+
+// defaultConfigFile is the name the default configuration is exported under
+const defaultConfigFile = "emulator.yaml"
+
+// exportAssets writes the built-in examples, fragments and default configuration to
+// dir, laid out so dir can be passed to -examples-dir and the configuration to -config.
+// Existing files are left alone so customized copies are never overwritten.
+func exportAssets(dir string) ([]string, error) {
+	var written []string
+	write := func(name string, data []byte) error {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if _, err := os.Stat(target); err == nil {
+			fmt.Printf("⏭️  %s exists, skipped\n", target)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		written = append(written, target)
+		return nil
+	}
+
+	assets := server.Assets()
+	err := fs.WalkDir(assets, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(assets, name)
+		if err != nil {
+			return err
+		}
+		return write(name, data)
+	})
+	if err == nil {
+		err = write(defaultConfigFile, config.DefaultFile)
+	}
+	if err != nil {
+		return written, fmt.Errorf("exporting assets: %w", err)
+	}
+	return written, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/internal/config"
+	"github.com/edge-computing/emulator-suite/pkg/client"
+)
+
+// healthcheckCommand probes a running emulator and returns the exit code: 0 when
+// healthy, 1 otherwise. It needs no curl, so images can use it as their HEALTHCHECK.
+func healthcheckCommand(args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:"+strconv.Itoa(config.DefaultPort), "Emulator URL to probe")
+	live := flags.Bool("live", false, "Probe /livez instead of the component readiness at /health")
+	timeout := flags.Duration("timeout", 3*time.Second, "Time to wait for the response")
+	flags.Parse(args)
+
+	c := client.New(*url).WithHTTPClient(&http.Client{Timeout: *timeout})
+	probe := c.Health
+	if *live {
+		probe = c.Live
+	}
+
+	status, err := probe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%v\n", status["status"])
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/history"
+)
+
+// historyCommand reports the run history recorded in ESI_HISTORY_DB and returns the
+// exit code: a block per template and mode with a line per emulator version, so
+// processing time trends show across versions and template changes
+func historyCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	db := flags.String("db", os.Getenv("ESI_HISTORY_DB"), "History database (default: $ESI_HISTORY_DB)")
+	version := flags.String("version", "", "Only runs of this emulator version")
+	mode := flags.String("mode", "", "Only runs in this ESI mode")
+	template := flags.String("template", "", "Only runs of templates whose hash starts with this")
+	since := flags.Duration("since", 0, "Only runs of the last duration, such as 168h")
+	asJSON := flags.Bool("json", false, "Print the summaries as JSON")
+	flags.Parse(args)
+
+	if *db == "" {
+		fmt.Fprintln(os.Stderr, "Usage: edge-emulator history -db history.db [-version v] [-mode m] [-template hash] [-since 168h] [-json]")
+		return 2
+	}
+	if _, err := os.Stat(*db); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	store, err := history.Open(*db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	filter := history.Filter{Version: *version, Mode: *mode, TemplateHash: *template}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+	summaries, err := store.Summaries(filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(summaries)
+		return 0
+	}
+	fmt.Fprint(out, history.Report(summaries))
+	return 0
+}
@@ -3,18 +3,23 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/edge-computing/emulator-suite/internal/config"
 	"github.com/edge-computing/emulator-suite/internal/utils"
 	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/fastly"
+	"github.com/edge-computing/emulator-suite/pkg/history"
 	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
 	"github.com/edge-computing/emulator-suite/pkg/server"
+	"github.com/edge-computing/emulator-suite/pkg/templates"
 	"github.com/gin-gonic/gin"
 )
 
@@ -24,16 +29,30 @@ var (
 	BuildTime = "unknown"
 	GitCommit = "unknown"
 
-	// Command line flags
-	port        = flag.Int("port", 3000, "Port to run the server on")
-	mode        = flag.String("mode", "integrated", "Emulator mode: esi, property-manager, integrated")
-	esiMode     = flag.String("esi-mode", "akamai", "ESI mode: fastly, akamai, w3c, development")
+	// Command line flags; only flags given on the command line override the configuration
+	configFile  = flag.String("config", "", "YAML or JSON configuration file (defaults < file < environment < flags)")
+	port        = flag.Int("port", config.DefaultPort, "Port to run the server on")
+	mode        = flag.String("mode", config.DefaultEmulatorMode, "Emulator mode: esi, property-manager, integrated")
+	esiMode     = flag.String("esi-mode", config.DefaultESIMode, "ESI mode: fastly, akamai, w3c, development")
 	debug       = flag.Bool("debug", false, "Enable debug mode")
+	examplesDir = flag.String("examples-dir", "", "Directory with examples/ and fragments/ to serve alongside the built-in ones")
+	exportDir   = flag.String("export-assets", "", "Write the built-in examples, fragments and default configuration to this directory and exit")
+	logFormat   = flag.String("log-format", config.DefaultLogFormat, "Log output format: text, json")
 	showHelp    = flag.Bool("help", false, "Show help information")
 	showVersion = flag.Bool("version", false, "Show version information")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheckCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(historyCommand(os.Args[2:], os.Stdout))
+	}
+
 	flag.Parse()
 
 	// Handle help and version flags
@@ -47,17 +66,25 @@ func main() {
 		return
 	}
 
+	if *exportDir != "" {
+		written, err := exportAssets(*exportDir)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Printf("✅ Exported %d files to %s\n", len(written), *exportDir)
+		return
+	}
+
 	fmt.Printf("Starting Edge Computing Emulator Suite v%s\n", Version)
-	fmt.Printf("Flags: mode=%s, esi-mode=%s, port=%d, debug=%t\n", *mode, *esiMode, *port, *debug)
 
-	// Load configuration
-	cfg := config.Load()
+	// Load configuration: defaults, the configuration file, then environment variables
+	cfg, err := config.LoadWithFile(*configFile)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
 
-	// Override with command line flags
-	cfg.Port = *port
-	cfg.EmulatorMode = *mode
-	cfg.ESIMode = *esiMode
-	cfg.Debug = *debug
+	// Override with the command line flags that were given
+	applyFlags(cfg)
 
 	fmt.Printf("Configuration: mode=%s, port=%d, debug=%t\n", cfg.EmulatorMode, cfg.Port, cfg.Debug)
 
@@ -67,7 +94,10 @@ func main() {
 	}
 
 	// Set up logging
-	logger := utils.NewLogger(cfg.LogLevel, cfg.Debug, "edge-emulator")
+	logger, err := newLogger(cfg)
+	if err != nil {
+		log.Fatalf("Logging error: %v", err)
+	}
 	defer logger.Close()
 
 	logger.Info("Starting Edge Computing Emulator Suite v%s", Version)
@@ -83,7 +113,6 @@ func main() {
 
 	// Initialize the appropriate emulator
 	var emulator interface{}
-	var err error
 
 	switch cfg.EmulatorMode {
 	case "esi":
@@ -105,31 +134,93 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Set up processors based on emulator type
+	opts := serverOptions(emulator, cfg, logger)
+	if cfg.FastlyVCLFile != "" {
+		option, err := fastlyOption(cfg, emulator, logger)
+		if err != nil {
+			logger.Error("Failed to load Fastly VCL: %v", err)
+			os.Exit(1)
+		}
+		opts = append(opts, option)
+	}
+	if cfg.ESIHistoryDB != "" {
+		store, err := openHistory(cfg, emulator, logger)
+		if err != nil {
+			logger.Error("Failed to open the run history: %v", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		opts = append(opts, server.WithHistory(store))
+	}
+	if cfg.HasTemplates() {
+		opts = append(opts, templatesOption(cfg, logger))
+	}
+
+	// Serve runtime log level changes and route access logs through the server component
+	serverLogger := logger.Component("server")
+	opts = append(opts, server.WithLogLevels(logger), server.WithAccessLog(serverLogger.Info))
+	opts = append(opts, readinessChecks(cfg, emulator)...)
+	if cfg.AccessLog != "" {
+		accessLog, err := openAccessLog(cfg.AccessLog)
+		if err != nil {
+			logger.Error("Failed to open access log: %v", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithEdgeAccessLog(accessLog))
+	}
+	followESILogLevel(cfg, logger, emulator)
+
+	// Load the on-disk example library
+	if cfg.ExamplesDir != "" {
+		library, err := server.NewLibrary(cfg.ExamplesDir)
+		if err != nil {
+			logger.Error("Failed to load examples: %v", err)
+			os.Exit(1)
+		}
+		for _, problem := range library.Errors() {
+			logger.Warn("Skipping example file: %s", problem)
+		}
+		opts = append(opts, server.WithLibrary(library))
+		logger.Info("Loaded %d examples and %d fragments from %s",
+			len(library.Examples()), len(library.Fragments()), cfg.ExamplesDir)
+	}
+
 	// Create and configure the server
 	srv := server.New(server.Config{
 		Port:  cfg.Port,
 		Debug: cfg.Debug,
 		Mode:  cfg.EmulatorMode,
-	})
 
-	// Set up processors based on emulator type
-	setupProcessors(srv, emulator, cfg, logger)
+		MaxBodySize:     cfg.MaxBodySize,
+		MaxResponseSize: cfg.MaxResponseSize,
 
-	// Add integrated endpoint for integrated mode
-	if cfg.EmulatorMode == "integrated" {
-		if integrated, ok := emulator.(*IntegratedEmulator); ok {
-			setupIntegratedRoutes(srv, integrated, logger)
-		}
-	}
+		FragmentSigningKey: cfg.URLSigningKey,
+		EarlyHints:         cfg.ESIEarlyHints,
+
+		Listen: server.ListenConfig{
+			Interfaces: cfg.ListenInterfaces,
+			UnixSocket: cfg.UnixSocket,
+			ReusePort:  cfg.ReusePort,
+		},
+
+		CORS: server.CORSConfig{
+			AllowedOrigins:   cfg.CORSAllowedOrigins,
+			AllowedMethods:   cfg.CORSAllowedMethods,
+			AllowedHeaders:   cfg.CORSAllowedHeaders,
+			AllowCredentials: cfg.CORSAllowCredentials,
+			MaxAge:           cfg.CORSMaxAge,
+		},
+	}, opts...)
 
 	fmt.Printf("Server configured, starting on port %d...\n", cfg.Port)
 
 	// Start the server
 	go func() {
-		logger.Info("Server starting on %s", cfg.GetAddress())
+		serverLogger.Info("Server starting on %s", cfg.GetAddress())
 		fmt.Printf("Server starting on %s\n", cfg.GetAddress())
 		if err := srv.Start(); err != nil && err != http.ErrServerClosed {
-			logger.Error("Server failed to start: %v", err)
+			serverLogger.Error("Server failed to start: %v", err)
 			fmt.Printf("Server failed to start: %v\n", err)
 			os.Exit(1)
 		}
@@ -151,53 +242,188 @@ func main() {
 	fmt.Println("Server exited")
 }
 
-// initializeESIEmulator initializes the ESI emulator for standalone use
-func initializeESIEmulator(cfg *config.Config, logger *utils.Logger) (*esi.Processor, error) {
+// applyFlags overrides the configuration with the command line flags that were given
+func applyFlags(cfg *config.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = *port
+		case "mode":
+			cfg.EmulatorMode = *mode
+		case "esi-mode":
+			cfg.ESIMode = *esiMode
+		case "debug":
+			cfg.Debug = *debug
+		case "examples-dir":
+			cfg.ExamplesDir = *examplesDir
+		case "log-format":
+			cfg.LogFormat = *logFormat
+		}
+	})
+}
+
+// newLogger creates the logger with the configured format and component levels
+func newLogger(cfg *config.Config) (*utils.Logger, error) {
+	logger := utils.NewLogger(cfg.LogLevel, cfg.Debug, "edge-emulator")
+	if cfg.LogFormat != "" {
+		if err := logger.SetFormat(cfg.LogFormat); err != nil {
+			return nil, err
+		}
+	}
+	for component, level := range cfg.LogComponentLevels {
+		if err := logger.SetComponentLevel(component, level); err != nil {
+			return nil, fmt.Errorf("log level of %s: %w", component, err)
+		}
+	}
+	return logger, nil
+}
+
+// openAccessLog opens the edge access log destination: stdout, or a file appended to
+func openAccessLog(destination string) (io.Writer, error) {
+	if destination == "stdout" {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// followESILogLevel turns the ESI processor's debug output on while the esi
+// component logs at debug level, so it can be enabled on a live instance
+// through /admin/log-levels. The -debug flag keeps it on regardless.
+func followESILogLevel(cfg *config.Config, logger *utils.Logger, emulator interface{}) {
+	var processor *esi.Processor
+	switch emulator := emulator.(type) {
+	case *esi.Processor:
+		processor = emulator
+	case *IntegratedEmulator:
+		processor = emulator.ESIProcessor
+	}
+	if processor == nil {
+		return
+	}
+
+	esiLogger := logger.Component("esi")
+	update := func() {
+		processor.SetDebug(cfg.Debug || esiLogger.Enabled(utils.LogLevelDebug))
+	}
+	update()
+	logger.OnLevelChange(func(string, utils.LogLevel) { update() })
+}
+
+// readinessChecks returns the readiness checks of the configured dependencies
+func readinessChecks(cfg *config.Config, emulator interface{}) []server.Option {
+	var pm *propertymanager.PropertyManager
+	switch emulator := emulator.(type) {
+	case *propertymanager.PropertyManager:
+		pm = emulator
+	case *IntegratedEmulator:
+		pm = emulator.PropertyManager
+	}
+	if pm == nil || cfg.PropertyFile == "" {
+		return nil
+	}
+
+	return []server.Option{server.WithReadinessCheck("propertyRules", func() error {
+		if pm.Property == nil {
+			return fmt.Errorf("property rules from %s are not loaded", cfg.PropertyFile)
+		}
+		return nil
+	})}
+}
+
+// esiProcessorConfig returns the ESI processor configuration; zero limits select the defaults
+func esiProcessorConfig(cfg *config.Config) esi.Config {
 	esiConfig := esi.Config{
 		Mode:        cfg.ESIMode,
 		Debug:       cfg.Debug,
-		MaxIncludes: 256,
-		MaxDepth:    5,
+		MaxIncludes: cfg.ESIMaxIncludes,
+		MaxDepth:    cfg.ESIMaxDepth,
 		Cache: esi.CacheConfig{
-			Enabled: true,
-			TTL:     300, // 5 minutes
+			Enabled: cfg.CacheEnabled,
+			TTL:     cfg.CacheTTL,
 		},
+		RequestIDHeader:     cfg.RequestIDHeader,
+		SlowIncludeMS:       cfg.ESISlowIncludeMS,
+		StatsWindows:        cfg.ESIStatsWindows,
+		MaxProcessingTime:   time.Duration(cfg.ESIMaxProcessingMS) * time.Millisecond,
+		Faults:              cfg.ESIFaults,
+		Output:              cfg.ESIOutput,
+		PreloadHints:        cfg.ESIPreloadHints,
+		TemplateCacheSize:   cfg.ESITemplateCacheSize,
+		GeoHeaderPrefix:     cfg.GeoHeaderPrefix,
+		ProcessContentTypes: cfg.ESIProcessContentTypes,
+		Sanitize:            cfg.ESISanitize,
+		TLS:                 cfg.ESITLS,
+		Resolve:             cfg.ESIResolve,
+		Rewrites:            cfg.ESIRewrites,
+		Experiments:         cfg.Experiments,
+		EdgeData:            cfg.EdgeData(),
+		ErrorPage:           cfg.ESIErrorPage,
+		URLSigning: esi.URLSigningConfig{
+			Key: cfg.URLSigningKey,
+			TTL: time.Duration(cfg.URLSigningTTL) * time.Second,
+		},
+	}
+	if esiConfig.MaxIncludes == 0 {
+		esiConfig.MaxIncludes = config.DefaultESIMaxIncludes
 	}
+	if esiConfig.MaxDepth == 0 {
+		esiConfig.MaxDepth = config.DefaultESIMaxDepth
+	}
+	return esiConfig
+}
 
-	processor := esi.NewProcessor(esiConfig)
-	logger.Info("ESI Emulator initialized in %s mode (standalone)", cfg.ESIMode)
+// newPropertyManager creates the Property Manager, loading the configured property file
+func newPropertyManager(cfg *config.Config, logger *utils.Logger) (*propertymanager.PropertyManager, error) {
+	pm := propertymanager.NewPropertyManager(cfg.Debug)
+	pm.GeoHeaderPrefix = cfg.GeoHeaderPrefix
+	pm.MaxBodyInspectBytes = cfg.PMMaxBodyInspectBytes
+	pm.TrustedProxies = cfg.PMTrustedProxies
+	pm.Experiments = cfg.Experiments
+	pm.TemplateDir = filepath.Dir(cfg.PropertyFile)
+	if cfg.PropertyFile == "" {
+		return pm, nil
+	}
+
+	if err := pm.LoadPropertyFile(cfg.PropertyFile); err != nil {
+		return nil, fmt.Errorf("loading property file %s: %w", cfg.PropertyFile, err)
+	}
+	logger.Component("propertymanager").Info("Loaded property configuration from %s", cfg.PropertyFile)
+	return pm, nil
+}
+
+// initializeESIEmulator initializes the ESI emulator for standalone use
+func initializeESIEmulator(cfg *config.Config, logger *utils.Logger) (*esi.Processor, error) {
+	processor := esi.NewProcessor(esiProcessorConfig(cfg))
+	esiLogger := logger.Component("esi")
+	esiLogger.Info("ESI Emulator initialized in %s mode (standalone)", cfg.ESIMode)
 
 	// Log supported features for the mode
 	features := processor.GetFeatures()
-	logger.Info("ESI Features enabled: %+v", features)
+	esiLogger.Info("ESI Features enabled: %+v", features)
 
 	return processor, nil
 }
 
 // initializePropertyManagerEmulator initializes the Property Manager emulator for standalone use
 func initializePropertyManagerEmulator(cfg *config.Config, logger *utils.Logger) (*propertymanager.PropertyManager, error) {
-	pm := propertymanager.NewPropertyManager(cfg.Debug)
-	logger.Info("Property Manager Emulator initialized (standalone)")
+	pm, err := newPropertyManager(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Component("propertymanager").Info("Property Manager Emulator initialized (standalone)")
 	return pm, nil
 }
 
 // initializeIntegratedEmulator initializes both Property Manager and ESI emulators for integrated use
 func initializeIntegratedEmulator(cfg *config.Config, logger *utils.Logger) (*IntegratedEmulator, error) {
 	// Initialize ESI processor
-	esiConfig := esi.Config{
-		Mode:        cfg.ESIMode,
-		Debug:       cfg.Debug,
-		MaxIncludes: 256,
-		MaxDepth:    5,
-		Cache: esi.CacheConfig{
-			Enabled: true,
-			TTL:     300, // 5 minutes
-		},
-	}
-	esiProcessor := esi.NewProcessor(esiConfig)
+	esiProcessor := esi.NewProcessor(esiProcessorConfig(cfg))
 
 	// Initialize Property Manager
-	pm := propertymanager.NewPropertyManager(cfg.Debug)
+	pm, err := newPropertyManager(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create integrated emulator
 	integrated := &IntegratedEmulator{
@@ -213,33 +439,75 @@ func initializeIntegratedEmulator(cfg *config.Config, logger *utils.Logger) (*In
 	return integrated, nil
 }
 
-// setupProcessors sets up the appropriate processors for the server
-func setupProcessors(srv *server.Server, emulator interface{}, cfg *config.Config, logger *utils.Logger) {
+// serverOptions returns the server options for the initialized emulator
+func serverOptions(emulator interface{}, cfg *config.Config, logger *utils.Logger) []server.Option {
 	switch cfg.EmulatorMode {
 	case "esi":
 		if processor, ok := emulator.(*esi.Processor); ok {
-			srv.SetESIProcessor(processor)
 			logger.Info("ESI routes configured (standalone mode)")
+			return []server.Option{server.WithESI(processor)}
 		}
 	case "property-manager":
 		if pm, ok := emulator.(*propertymanager.PropertyManager); ok {
-			srv.SetPropertyManagerProcessor(pm)
 			logger.Info("Property Manager routes configured (standalone mode)")
+			return []server.Option{server.WithPropertyManager(pm)}
 		}
 	case "integrated":
 		if integrated, ok := emulator.(*IntegratedEmulator); ok {
-			srv.SetESIProcessor(integrated.ESIProcessor)
-			srv.SetPropertyManagerProcessor(integrated.PropertyManager)
 			logger.Info("Integrated routes configured (both processors available)")
+			logger.Info("Integrated processing endpoint available at /integrated/process")
+			return []server.Option{server.WithIntegrated(integrated.ESIProcessor, integrated.PropertyManager)}
 		}
 	}
+	return nil
 }
 
-// setupIntegratedRoutes adds integrated processing endpoints
-func setupIntegratedRoutes(srv *server.Server, integrated *IntegratedEmulator, logger *utils.Logger) {
-	// Get the router from the server (we'll need to add a method to access it)
-	// For now, we'll add the integrated endpoint through the server's existing structure
-	logger.Info("Integrated processing endpoint available at /integrated/process")
+// fastlyOption loads the configured Fastly VCL and serves it with the ESI processor
+func fastlyOption(cfg *config.Config, emulator interface{}, logger *utils.Logger) (server.Option, error) {
+	processor, ok := emulator.(*esi.Processor)
+	if !ok {
+		return nil, fmt.Errorf("the Fastly VCL needs the esi emulator mode")
+	}
+	vcl, err := fastly.ParseFile(cfg.FastlyVCLFile)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Loaded Fastly VCL from %s with %d backends; endpoint available at /fastly/process", cfg.FastlyVCLFile, len(vcl.Backends))
+	return server.WithFastly(processor, vcl), nil
+}
+
+// openHistory opens the run history database and records every page the ESI
+// processor processes in it, under the emulator's version
+func openHistory(cfg *config.Config, emulator interface{}, logger *utils.Logger) (*history.Store, error) {
+	var processor *esi.Processor
+	switch emulator := emulator.(type) {
+	case *esi.Processor:
+		processor = emulator
+	case *IntegratedEmulator:
+		processor = emulator.ESIProcessor
+	}
+	if processor == nil {
+		return nil, fmt.Errorf("the run history needs an ESI processor")
+	}
+
+	store, err := history.Open(cfg.ESIHistoryDB)
+	if err != nil {
+		return nil, err
+	}
+	processor.OnAfterRun(store.Recorder(Version))
+	logger.Info("Recording processing runs in %s; history available at /history", cfg.ESIHistoryDB)
+	return store, nil
+}
+
+// templatesOption serves /process/by-name with the configured template store
+func templatesOption(cfg *config.Config, logger *utils.Logger) server.Option {
+	storeConfig := cfg.Templates()
+	source := storeConfig.Dir
+	if storeConfig.URL != "" {
+		source = storeConfig.URL
+	}
+	logger.Info("Loading templates from %s with %d pins; endpoint available at /process/by-name", source, len(storeConfig.Pins))
+	return server.WithTemplates(templates.NewStore(storeConfig.Source(), storeConfig))
 }
 
 // IntegratedEmulator combines Property Manager and ESI processing
@@ -254,142 +522,18 @@ type IntegratedEmulator struct {
 func (ie *IntegratedEmulator) ProcessIntegratedRequest(req *http.Request, html string) (*IntegratedResponse, error) {
 	ie.Logger.Debug("Processing integrated request: %s %s", req.Method, req.URL.Path)
 
-	// Step 1: Property Manager processes the request
-	pmResult, err := ie.PropertyManager.ProcessRequest(req)
+	result, err := server.ProcessIntegrated(ie.PropertyManager, ie.ESIProcessor, req, nil, html)
 	if err != nil {
 		ie.Logger.Error("Property Manager processing failed: %v", err)
 		return nil, err
 	}
 
-	ie.Logger.Debug("Property Manager processed request, matched rules: %v", pmResult.MatchedRules)
-
-	// Step 2: Create ESI context from Property Manager result
-	esiContext := ie.createESIContext(req, pmResult)
-
-	// Step 3: Process ESI content if enabled
-	var processedHTML string
-	if ie.isESIEnabled(pmResult) {
-		ie.Logger.Debug("ESI processing enabled, processing content")
-		processedHTML, err = ie.ESIProcessor.Process(html, esiContext)
-		if err != nil {
-			ie.Logger.Error("ESI processing failed: %v", err)
-			// Continue with original HTML if ESI fails
-			processedHTML = html
-		}
-	} else {
-		ie.Logger.Debug("ESI processing disabled, using original content")
-		processedHTML = html
+	ie.Logger.Debug("Property Manager processed request, matched rules: %v", result.PropertyManagerResult.MatchedRules)
+	if result.ESIError != nil {
+		ie.Logger.Error("ESI processing failed: %v", result.ESIError)
 	}
 
-	// Step 4: Property Manager processes response behaviors
-	responseResult, err := ie.processResponseBehaviors(pmResult, processedHTML)
-	if err != nil {
-		ie.Logger.Error("Response behavior processing failed: %v", err)
-		return nil, err
-	}
-
-	return &IntegratedResponse{
-		PropertyManagerResult: pmResult,
-		ResponseResult:        responseResult,
-		ProcessedHTML:         processedHTML,
-		ESIEnabled:            ie.isESIEnabled(pmResult),
-	}, nil
-}
-
-// createESIContext creates an ESI processing context from Property Manager result
-func (ie *IntegratedEmulator) createESIContext(req *http.Request, pmResult *propertymanager.RuleResult) esi.ProcessContext {
-	// Start with request headers
-	headers := make(map[string]string)
-	for key, values := range req.Header {
-		if len(values) > 0 {
-			headers[key] = values[0]
-		}
-	}
-
-	// Apply Property Manager header modifications
-	for key, value := range pmResult.ModifiedHeaders {
-		headers[key] = value
-	}
-
-	// Remove headers that were removed by Property Manager
-	for _, removedHeader := range pmResult.RemovedHeaders {
-		delete(headers, removedHeader)
-	}
-
-	// Extract cookies
-	cookies := make(map[string]string)
-	if cookieHeader := req.Header.Get("Cookie"); cookieHeader != "" {
-		// Simple cookie parsing - in production, use proper cookie parsing
-		cookiePairs := strings.Split(cookieHeader, ";")
-		for _, pair := range cookiePairs {
-			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
-			if len(parts) == 2 {
-				cookies[parts[0]] = parts[1]
-			}
-		}
-	}
-
-	// Add Property Manager variables to headers for ESI access
-	for key, value := range pmResult.Variables {
-		headers["X-PM-"+key] = value
-	}
-
-	return esi.ProcessContext{
-		BaseURL: fmt.Sprintf("%s://%s", getScheme(req), req.Host),
-		Headers: headers,
-		Cookies: cookies,
-		Depth:   0,
-	}
-}
-
-// isESIEnabled checks if ESI processing is enabled based on Property Manager result
-func (ie *IntegratedEmulator) isESIEnabled(pmResult *propertymanager.RuleResult) bool {
-	// Check if ESI behavior was executed
-	for _, behavior := range pmResult.ExecutedBehaviors {
-		if behavior == "esi" {
-			return true
-		}
-	}
-	return false
-}
-
-// processResponseBehaviors processes Property Manager response behaviors
-func (ie *IntegratedEmulator) processResponseBehaviors(pmResult *propertymanager.RuleResult, html string) (*propertymanager.RuleResult, error) {
-	// Create a mock response context for response behavior processing
-	// In a real implementation, this would be more sophisticated
-	responseResult := &propertymanager.RuleResult{
-		MatchedRules:              pmResult.MatchedRules,
-		ExecutedBehaviors:         pmResult.ExecutedBehaviors,
-		ModifiedHeaders:           make(map[string]string),
-		RemovedHeaders:            []string{},
-		Variables:                 make(map[string]string),
-		Errors:                    []string{},
-		CacheSettings:             make(map[string]interface{}),
-		CompressionSettings:       make(map[string]interface{}),
-		ImageOptimizationSettings: make(map[string]interface{}),
-	}
-
-	// Copy modified headers from request processing
-	for key, value := range pmResult.ModifiedHeaders {
-		responseResult.ModifiedHeaders[key] = value
-	}
-
-	// Apply response-specific behaviors
-	// This is where you would process response behaviors like compression, caching, etc.
-	ie.Logger.Debug("Processing response behaviors")
-
-	return responseResult, nil
-}
-
-// getScheme returns the scheme (http/https) for a request
-func getScheme(req *http.Request) string {
-	if req.TLS != nil {
-		return "https"
-	}
-	if scheme := req.Header.Get("X-Forwarded-Proto"); scheme != "" {
-		return scheme
-	}
-	return "http"
+	return result, nil
 }
 
 // showHelpInfo displays help information
@@ -399,6 +543,9 @@ func showHelpInfo() {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  edge-emulator [flags]")
+	fmt.Println("  edge-emulator config validate [config-file]")
+	fmt.Println("  edge-emulator healthcheck [-url http://localhost:3000] [-live]")
+	fmt.Println("  edge-emulator history [-db history.db] [-version v] [-mode m] [-template hash] [-since 168h] [-json]")
 	fmt.Println()
 	fmt.Println("Flags:")
 	flag.PrintDefaults()
@@ -426,17 +573,70 @@ func showHelpInfo() {
 	fmt.Println("    - Full edge workflow simulation")
 	fmt.Println("    - Production-like testing")
 	fmt.Println()
+	fmt.Println("Configuration Precedence:")
+	fmt.Println("  defaults < -config file < environment variables < command line flags")
+	fmt.Println("  Only flags given on the command line override the other layers.")
+	fmt.Println()
 	fmt.Println("Environment Variables:")
 	fmt.Println("  EMULATOR_MODE      Set to 'esi', 'property-manager', or 'integrated'")
 	fmt.Println("  ESI_MODE           Set to 'fastly', 'akamai', 'w3c', or 'development'")
 	fmt.Println("  PORT               Server port (default: 3000)")
+	fmt.Println("  LISTEN_INTERFACES  Comma-separated interfaces or IPs to listen on, e.g. lo0,192.168.1.10 (default: all)")
+	fmt.Println("  UNIX_SOCKET        Listen on this unix domain socket instead of TCP")
+	fmt.Println("  REUSE_PORT         Set SO_REUSEPORT so several emulators can share the port (default: false)")
 	fmt.Println("  DEBUG              Enable debug mode")
 	fmt.Println("  LOG_LEVEL          Set log level (debug, info, warn, error)")
+	fmt.Println("  LOG_FORMAT         Log output format: text or json (default: text)")
+	fmt.Println("  LOG_COMPONENT_LEVELS   Per-component levels, e.g. esi=debug,server=warn")
+	fmt.Println("  ACCESS_LOG         Write a combined-format access log with edge fields to this file or stdout")
+	fmt.Println("  ESI_MAX_INCLUDES   Maximum includes per request (default: 256)")
+	fmt.Println("  ESI_MAX_DEPTH      Maximum include depth (default: 5)")
+	fmt.Println("  REQUEST_ID_HEADER  Header include requests carry the request ID in (default: X-Request-ID)")
+	fmt.Println("  ESI_SLOW_INCLUDE_MS    Include fetch time sampled as slow in /stats (default: 100)")
+	fmt.Println("  ESI_STATS_WINDOWS      Report rolling 1m and 5m windows in /stats (default: false)")
+	fmt.Println("  ESI_MAX_PROCESSING_MS  Wall-clock budget per page; later includes use the cache, alt or except (default: 0, off)")
+	fmt.Println("  ESI_OUTPUT             Output formatting: preserve, collapse, minify (default: preserve)")
+	fmt.Println("  ESI_PRELOAD_HINTS      Include URLs, the first of each page, sent in a Link rel=preload header (default: 0, off)")
+	fmt.Println("  ESI_EARLY_HINTS        Also send the preload links as 103 Early Hints before processing documents (default: false)")
+	fmt.Println("  ESI_TEMPLATE_CACHE_SIZE    Parsed templates kept by content hash, negative disables (default: 256)")
+	fmt.Println("  ESI_PROCESS_CONTENT_TYPES  Comma-separated media types processed, e.g. text/html,text/* (default: text/html,application/xhtml+xml)")
+	fmt.Println("  ESI_SANITIZE_HOSTS  Comma-separated untrusted fragment hosts to strip scripts from, * for all")
+	fmt.Println("  ESI_RESOLVE        Connect include hosts to other addresses, e.g. www.example.com=127.0.0.1:8080")
+	fmt.Println("  ESI_EDGE_DATA_URL  HTTP JSON endpoint of $(EDGE_DATA{key}) and esi:lookup, e.g. http://localhost:9000/kv/{key}")
+	fmt.Println("  ESI_EDGE_DATA_FILE JSON object file of $(EDGE_DATA{key}) and esi:lookup")
+	fmt.Println("  ESI_EDGE_DATA_TIMEOUT_MS  Edge data lookup timeout (default: 1000)")
+	fmt.Println("  ESI_EDGE_DATA_TTL  Seconds edge data values are cached, negative disables (default: 60)")
+	fmt.Println("  ESI_TEMPLATES_DIR  Directory of <name>/<version>.html templates processed at /process/by-name")
+	fmt.Println("  ESI_TEMPLATES_URL  HTTP template repository, e.g. http://localhost:9000/{name}/{version}")
+	fmt.Println("  ESI_TEMPLATE_PINS  Versions served for references without one, e.g. checkout=v42,cart=v7")
+	fmt.Println("  ESI_TEMPLATES_TIMEOUT_MS  Template fetch timeout (default: 5000)")
+	fmt.Println("  ESI_TEMPLATES_TTL  Seconds the latest version of a template is cached, negative disables (default: 60)")
+	fmt.Println("  ESI_ERROR_PAGE_FAILURE_PERCENT  Serve the error page when more than this % of includes fail")
+	fmt.Println("  ESI_ERROR_PAGE_STATUS  Status of the error page (default: 503)")
+	fmt.Println("  FASTLY_VCL_FILE    Fastly VCL run for /fastly/process and every include (esi mode, fastly ESI mode)")
+	fmt.Println("  ESI_HISTORY_DB     SQLite database recording every processed page, served at /history")
+	fmt.Println("  CACHE_ENABLED      Enable the fragment cache (default: true)")
+	fmt.Println("  CACHE_TTL          Fragment cache TTL in seconds (default: 300)")
+	fmt.Println("  PROPERTY_FILE      Property Manager XML property to load at startup")
+	fmt.Println("  PM_MAX_BODY_INSPECT_BYTES  Request body bytes the body criteria inspect (default: 65536)")
+	fmt.Println("  PM_TRUSTED_PROXIES  Comma-separated proxy IPs or CIDRs whose X-Forwarded-For hops are trusted")
+	fmt.Println("  GEO_HEADER_PREFIX  Enable geo override headers with this prefix, e.g. X-Emulator-Geo-")
+	fmt.Println("  URL_SIGNING_KEY    Key of the sign_url ESI function; /fragments then only serves signed URLs")
+	fmt.Println("  URL_SIGNING_TTL    Seconds signed URLs stay valid (default: 300)")
+	fmt.Println("  EXAMPLES_DIR       Directory with examples/ and fragments/ (same as -examples-dir)")
+	fmt.Println("  CORS_ALLOWED_ORIGINS   Comma-separated origins, e.g. https://app.example.com,*.corp.example (default: *)")
+	fmt.Println("  CORS_ALLOWED_METHODS   Comma-separated methods allowed for cross-origin requests")
+	fmt.Println("  CORS_ALLOWED_HEADERS   Comma-separated request headers allowed for cross-origin requests")
+	fmt.Println("  CORS_ALLOW_CREDENTIALS Allow cookies and authorization headers on cross-origin requests")
+	fmt.Println("  CORS_MAX_AGE           Seconds browsers may cache preflight responses")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  # Standalone ESI for Fastly")
 	fmt.Println("  edge-emulator -mode=esi -esi-mode=fastly")
 	fmt.Println()
+	fmt.Println("  # Fastly ESI driven by a VCL snippet")
+	fmt.Println("  FASTLY_VCL_FILE=service.vcl edge-emulator -mode=esi -esi-mode=fastly")
+	fmt.Println()
 	fmt.Println("  # Standalone ESI for development")
 	fmt.Println("  edge-emulator -mode=esi -esi-mode=development -debug")
 	fmt.Println()
@@ -448,6 +648,22 @@ func showHelpInfo() {
 	fmt.Println()
 	fmt.Println("  # Environment variable configuration")
 	fmt.Println("  EMULATOR_MODE=integrated ESI_MODE=akamai edge-emulator")
+	fmt.Println()
+	fmt.Println("  # Configuration file, with a flag overriding its port")
+	fmt.Println("  edge-emulator config validate emulator.yaml")
+	fmt.Println("  edge-emulator -config emulator.yaml -port 8080")
+	fmt.Println()
+	fmt.Println("  # Record processing runs, then compare them across versions")
+	fmt.Println("  ESI_HISTORY_DB=history.db edge-emulator -mode=esi")
+	fmt.Println("  edge-emulator history -db history.db -since 168h")
+	fmt.Println()
+	fmt.Println("  # Process templates by name, pinning checkout to v42")
+	fmt.Println("  ESI_TEMPLATES_DIR=templates ESI_TEMPLATE_PINS=checkout=v42 edge-emulator -mode=esi")
+	fmt.Println("  curl -X POST localhost:3000/process/by-name -d '{\"template\":\"checkout\",\"context\":{}}'")
+	fmt.Println()
+	fmt.Println("  # JSON logs, then ESI debug output on the running instance")
+	fmt.Println("  edge-emulator -log-format=json")
+	fmt.Println("  curl -X PUT localhost:3000/admin/log-levels -d '{\"component\":\"esi\",\"level\":\"debug\"}'")
 }
 
 // showVersionInfo displays version information
@@ -458,9 +674,4 @@ func showVersionInfo() {
 }
 
 // IntegratedResponse represents the result of integrated processing
-type IntegratedResponse struct {
-	PropertyManagerResult *propertymanager.RuleResult `json:"propertyManager"`
-	ResponseResult        *propertymanager.RuleResult `json:"response"`
-	ProcessedHTML         string                      `json:"processedHtml"`
-	ESIEnabled            bool                        `json:"esiEnabled"`
-}
+type IntegratedResponse = server.IntegratedResult
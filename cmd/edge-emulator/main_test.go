@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/edge-computing/emulator-suite/internal/config"
 	"github.com/edge-computing/emulator-suite/internal/utils"
 	"github.com/edge-computing/emulator-suite/pkg/esi"
 	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+	"github.com/edge-computing/emulator-suite/pkg/server"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -366,18 +369,10 @@ func TestErrorHandling(t *testing.T) {
 
 // TestESIEnabledDetection tests ESI enabled detection
 func TestESIEnabledDetection(t *testing.T) {
-	cfg := &config.Config{
-		ESIMode: "akamai",
-		Debug:   true,
-	}
-	logger := utils.NewLogger("info", true, "test")
-
-	integrated, err := initializeIntegratedEmulator(cfg, logger)
-	require.NoError(t, err)
-
 	tests := []struct {
 		name              string
 		executedBehaviors []string
+		settings          *propertymanager.ESISettings
 		expected          bool
 	}{
 		{
@@ -395,15 +390,28 @@ func TestESIEnabledDetection(t *testing.T) {
 			executedBehaviors: []string{},
 			expected:          false,
 		},
+		{
+			name:              "ESI behavior disabled",
+			executedBehaviors: []string{"esi"},
+			settings:          &propertymanager.ESISettings{ContentTypes: propertymanager.DefaultESIContentTypes},
+			expected:          false,
+		},
+		{
+			name:              "ESI behavior enabled",
+			executedBehaviors: []string{"esi"},
+			settings:          &propertymanager.ESISettings{Enabled: true, ContentTypes: propertymanager.DefaultESIContentTypes},
+			expected:          true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			pmResult := &propertymanager.RuleResult{
 				ExecutedBehaviors: tt.executedBehaviors,
+				ESI:               tt.settings,
 			}
 
-			result := integrated.isESIEnabled(pmResult)
+			result := server.ESIEnabled(pmResult, nil)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
@@ -411,15 +419,6 @@ func TestESIEnabledDetection(t *testing.T) {
 
 // TestESIContextCreation tests ESI context creation from Property Manager result
 func TestESIContextCreation(t *testing.T) {
-	cfg := &config.Config{
-		ESIMode: "akamai",
-		Debug:   true,
-	}
-	logger := utils.NewLogger("info", true, "test")
-
-	integrated, err := initializeIntegratedEmulator(cfg, logger)
-	require.NoError(t, err)
-
 	// Create a proper HTTP request
 	req, err := http.NewRequest("GET", "http://example.com/test", nil)
 	require.NoError(t, err)
@@ -441,7 +440,7 @@ func TestESIContextCreation(t *testing.T) {
 	}
 
 	// Test ESI context creation
-	esiContext := integrated.createESIContext(req, pmResult)
+	esiContext := server.NewESIContext(req, pmResult)
 
 	// Verify the context
 	assert.Equal(t, "http://example.com", esiContext.BaseURL)
@@ -452,12 +451,49 @@ func TestESIContextCreation(t *testing.T) {
 	assert.Equal(t, "abc123", esiContext.Cookies["session"])
 	assert.Equal(t, "test", esiContext.Cookies["user"])
 	assert.Equal(t, 0, esiContext.Depth)
+	assert.False(t, esiContext.NoCache)
+
+	// A no-store property bypasses the fragment cache
+	pmResult.CacheSettings = map[string]interface{}{"bypass": true}
+	assert.True(t, server.NewESIContext(req, pmResult).NoCache)
 
 	// Verify removed headers are not present
 	_, exists := esiContext.Headers["X-Removed-Header"]
 	assert.False(t, exists)
 }
 
+// TestProcessIntegratedRequest tests that the binary uses the shared integrated workflow
+func TestProcessIntegratedRequest(t *testing.T) {
+	cfg := &config.Config{
+		ESIMode: "akamai",
+		Debug:   true,
+	}
+	logger := utils.NewLogger("info", true, "test")
+
+	integrated, err := initializeIntegratedEmulator(cfg, logger)
+	require.NoError(t, err)
+
+	integrated.PropertyManager.Property = &propertymanager.Property{
+		Rules: propertymanager.Rules{Rule: []propertymanager.Rule{
+			{
+				Name:      "enable-esi",
+				Criteria:  []propertymanager.Criterion{{Name: "path", Option: "starts_with", Value: "/"}},
+				Behaviors: []propertymanager.Behavior{{Name: "esi"}},
+			},
+		}},
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/page", nil)
+	require.NoError(t, err)
+
+	response, err := integrated.ProcessIntegratedRequest(req, "<p>Page</p><esi:remove>hidden</esi:remove>")
+	require.NoError(t, err)
+	assert.True(t, response.ESIEnabled)
+	assert.Contains(t, response.ProcessedHTML, "<p>Page</p>")
+	assert.NotContains(t, response.ProcessedHTML, "hidden")
+	assert.NotNil(t, response.ResponseResult)
+}
+
 // TestPerformance tests basic performance characteristics
 func TestPerformance(t *testing.T) {
 	cfg := &config.Config{
@@ -508,6 +544,130 @@ func TestEdgeCases(t *testing.T) {
 
 	result, err := integrated.ESIProcessor.Process("", context)
 	require.NoError(t, err)
-	// Empty HTML gets wrapped in HTML structure by the processor
-	assert.Contains(t, result, "<html>")
+	// Empty HTML has no ESI markup and passes through untouched
+	assert.Empty(t, result)
+}
+
+// TestFollowESILogLevel tests toggling ESI debug output through the esi log level
+func TestFollowESILogLevel(t *testing.T) {
+	cfg := &config.Config{ESIMode: "akamai", LogLevel: "info", LogFormat: "json"}
+	logger, err := newLogger(cfg)
+	require.NoError(t, err)
+
+	processor, err := initializeESIEmulator(cfg, logger)
+	require.NoError(t, err)
+	followESILogLevel(cfg, logger, processor)
+	assert.False(t, processor.GetConfig().Debug)
+
+	require.NoError(t, logger.SetComponentLevel("esi", "debug"))
+	assert.True(t, processor.GetConfig().Debug)
+
+	require.NoError(t, logger.SetComponentLevel("server", "error"))
+	assert.True(t, processor.GetConfig().Debug, "other components leave ESI debug output alone")
+
+	require.NoError(t, logger.SetComponentLevel("esi", ""))
+	assert.False(t, processor.GetConfig().Debug)
+
+	cfg.LogComponentLevels = map[string]string{"esi": "verbose"}
+	_, err = newLogger(cfg)
+	assert.ErrorContains(t, err, "log level of esi")
+}
+
+// TestReadinessChecks tests the property rules readiness check
+func TestReadinessChecks(t *testing.T) {
+	pm := propertymanager.NewPropertyManager(false)
+	assert.Empty(t, readinessChecks(&config.Config{}, pm), "no check without a property file")
+
+	opts := readinessChecks(&config.Config{EmulatorMode: "property-manager", PropertyFile: "property.xml"}, pm)
+	require.Len(t, opts, 1)
+
+	srv := server.New(server.Config{Mode: "property-manager"}, append(opts, server.WithPropertyManager(pm))...)
+	ready, components := srv.Readiness()
+	assert.False(t, ready)
+	assert.Contains(t, components["propertyRules"].Message, "property.xml")
+
+	require.NoError(t, pm.LoadProperty([]byte(`<property><rules></rules></property>`)))
+	ready, _ = srv.Readiness()
+	assert.True(t, ready)
+}
+
+// TestFastlyOption tests loading the Fastly VCL for the ESI emulator
+func TestFastlyOption(t *testing.T) {
+	cfg := &config.Config{EmulatorMode: "esi", ESIMode: "fastly", LogLevel: "info", LogFormat: "json"}
+	logger, err := newLogger(cfg)
+	require.NoError(t, err)
+	processor, err := initializeESIEmulator(cfg, logger)
+	require.NoError(t, err)
+
+	cfg.FastlyVCLFile = filepath.Join(t.TempDir(), "service.vcl")
+	require.NoError(t, os.WriteFile(cfg.FastlyVCLFile, []byte(`sub vcl_fetch { esi; }`), 0o644))
+	option, err := fastlyOption(cfg, processor, logger)
+	require.NoError(t, err)
+	assert.NotNil(t, option)
+
+	require.NoError(t, os.WriteFile(cfg.FastlyVCLFile, []byte(`sub vcl_fetch { esi }`), 0o644))
+	_, err = fastlyOption(cfg, processor, logger)
+	assert.ErrorContains(t, err, "service.vcl: line 1")
+
+	_, err = fastlyOption(cfg, propertymanager.NewPropertyManager(false), logger)
+	assert.ErrorContains(t, err, "esi emulator mode")
+}
+
+// TestOpenHistory tests recording the integrated emulator's runs and reporting them
+func TestOpenHistory(t *testing.T) {
+	cfg := &config.Config{ESIMode: "akamai", ESIMaxDepth: 2, ESIMaxIncludes: 10}
+	logger := utils.NewLogger("info", false, "test")
+	integrated, err := initializeIntegratedEmulator(cfg, logger)
+	require.NoError(t, err)
+
+	cfg.ESIHistoryDB = filepath.Join(t.TempDir(), "history.db")
+	store, err := openHistory(cfg, integrated, logger)
+	require.NoError(t, err)
+	_, err = integrated.ESIProcessor.Process("<p>$(HTTP_HOST)</p>", esi.ProcessContext{})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, historyCommand([]string{"-db", cfg.ESIHistoryDB, "-mode", "akamai"}, &out))
+	assert.Contains(t, out.String(), "(akamai)\n  "+Version)
+	assert.Contains(t, out.String(), "1 runs")
+
+	out.Reset()
+	assert.Equal(t, 0, historyCommand([]string{"-db", cfg.ESIHistoryDB, "-version", "0.0.1"}, &out))
+	assert.Equal(t, "No runs recorded\n", out.String())
+	assert.Equal(t, 1, historyCommand([]string{"-db", filepath.Join(t.TempDir(), "missing.db")}, &out))
+
+	_, err = openHistory(cfg, propertymanager.NewPropertyManager(false), logger)
+	assert.ErrorContains(t, err, "needs an ESI processor")
+}
+
+// TestExportAssets tests that the exported assets are a library and configuration the
+// emulator reads back
+func TestExportAssets(t *testing.T) {
+	dir := t.TempDir()
+
+	written, err := exportAssets(dir)
+	require.NoError(t, err)
+	assert.Contains(t, written, filepath.Join(dir, "examples", "basic-include.html"))
+	assert.Contains(t, written, filepath.Join(dir, "fragments", "header.html"))
+
+	library, err := server.NewLibrary(dir)
+	require.NoError(t, err)
+	assert.Empty(t, library.Errors())
+	assert.Equal(t, "Basic Include", library.Examples()["basic-include"].Name)
+	assert.Contains(t, library.Fragments()["header"], "Dynamic Header Content")
+
+	cfg, err := config.LoadWithFile(filepath.Join(dir, "emulator.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, config.DefaultPort, cfg.Port)
+
+	// Customized files are kept on a second export
+	custom := filepath.Join(dir, "fragments", "header.html")
+	require.NoError(t, os.WriteFile(custom, []byte("<header>Custom</header>"), 0644))
+	written, err = exportAssets(dir)
+	require.NoError(t, err)
+	assert.Empty(t, written)
+	content, err := os.ReadFile(custom)
+	require.NoError(t, err)
+	assert.Equal(t, "<header>Custom</header>", string(content))
 }
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/client"
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+)
+
+// benchCase is a template replayed with one of the request contexts
+type benchCase struct {
+	name     string
+	template string
+	context  esi.ProcessContext
+}
+
+// cacheCounts are the fragment cache hits and misses of a target
+type cacheCounts struct {
+	hits   int64
+	misses int64
+}
+
+// benchTarget processes ESI content, either in process or through the emulator API
+type benchTarget interface {
+	process(template string, context esi.ProcessContext) error
+	cacheCounts() (cacheCounts, error)
+}
+
+// localTarget processes with an in-process processor
+type localTarget struct {
+	processor *esi.Processor
+}
+
+func (t *localTarget) process(template string, context esi.ProcessContext) error {
+	_, err := t.processor.Process(template, context)
+	return err
+}
+
+func (t *localTarget) cacheCounts() (cacheCounts, error) {
+	stats := t.processor.GetStats()
+	return cacheCounts{hits: stats.CacheHits, misses: stats.CacheMiss}, nil
+}
+
+// endpointTarget processes through POST /process of a running emulator
+type endpointTarget struct {
+	client *client.Client
+}
+
+func (t *endpointTarget) process(template string, context esi.ProcessContext) error {
+	_, err := t.client.Process(template, &context)
+	return err
+}
+
+func (t *endpointTarget) cacheCounts() (cacheCounts, error) {
+	response, err := t.client.Stats()
+	if err != nil {
+		return cacheCounts{}, err
+	}
+	stats, _ := response["stats"].(map[string]interface{})
+	hits, _ := stats["cacheHits"].(float64)
+	misses, _ := stats["cacheMiss"].(float64)
+	return cacheCounts{hits: int64(hits), misses: int64(misses)}, nil
+}
+
+// benchOptions configures runBench
+type benchOptions struct {
+	rps         int
+	duration    time.Duration
+	requests    int // stops the run early when reached; 0 for no limit
+	concurrency int
+}
+
+// latencyStats summarizes the processing times of a set of requests
+type latencyStats struct {
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"errorRate"`
+	P50MS     float64 `json:"p50Ms"`
+	P95MS     float64 `json:"p95Ms"`
+	P99MS     float64 `json:"p99Ms"`
+	MaxMS     float64 `json:"maxMs"`
+}
+
+// benchReport is the outcome of a bench run
+type benchReport struct {
+	Duration      string                  `json:"duration"`
+	AchievedRPS   float64                 `json:"achievedRps"`
+	Total         latencyStats            `json:"total"`
+	Templates     map[string]latencyStats `json:"templates"`
+	CacheHits     int64                   `json:"cacheHits"`
+	CacheMisses   int64                   `json:"cacheMisses"`
+	CacheHitRatio float64                 `json:"cacheHitRatio"`
+	names         []string
+}
+
+// benchSample is the outcome of a single request
+type benchSample struct {
+	name    string
+	latency time.Duration
+	err     error
+}
+
+// runBench replays the cases round-robin at options.rps until the duration elapses or
+// the request limit is reached. Requests are sent by options.concurrency workers, so a
+// slow target lowers the achieved rate rather than queueing requests without bound.
+func runBench(target benchTarget, cases []benchCase, options benchOptions) (*benchReport, error) {
+	before, err := target.cacheCounts()
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache statistics: %w", err)
+	}
+
+	jobs := make(chan benchCase)
+	samples := make(chan benchSample, options.concurrency)
+	var workers sync.WaitGroup
+	for i := 0; i < options.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				start := time.Now()
+				err := target.process(job.template, job.context)
+				samples <- benchSample{name: job.name, latency: time.Since(start), err: err}
+			}
+		}()
+	}
+
+	collected := make(chan []benchSample)
+	go func() {
+		var all []benchSample
+		for sample := range samples {
+			all = append(all, sample)
+		}
+		collected <- all
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(time.Second / time.Duration(options.rps))
+	deadline := time.After(options.duration)
+send:
+	for sent := 0; options.requests == 0 || sent < options.requests; sent++ {
+		select {
+		case <-deadline:
+			break send
+		case <-ticker.C:
+			// All workers may be busy; the deadline still ends the run
+			select {
+			case jobs <- cases[sent%len(cases)]:
+			case <-deadline:
+				break send
+			}
+		}
+	}
+	ticker.Stop()
+	close(jobs)
+	workers.Wait()
+	elapsed := time.Since(start)
+	close(samples)
+	all := <-collected
+
+	after, err := target.cacheCounts()
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache statistics: %w", err)
+	}
+
+	report := &benchReport{
+		Duration:    elapsed.Round(time.Millisecond).String(),
+		AchievedRPS: float64(len(all)) / elapsed.Seconds(),
+		Templates:   make(map[string]latencyStats),
+		CacheHits:   after.hits - before.hits,
+		CacheMisses: after.misses - before.misses,
+	}
+	if lookups := report.CacheHits + report.CacheMisses; lookups > 0 {
+		report.CacheHitRatio = float64(report.CacheHits) / float64(lookups)
+	}
+
+	byName := make(map[string][]benchSample)
+	for _, sample := range all {
+		byName[sample.name] = append(byName[sample.name], sample)
+	}
+	for name, samples := range byName {
+		report.Templates[name] = summarize(samples)
+		report.names = append(report.names, name)
+	}
+	sort.Strings(report.names)
+	report.Total = summarize(all)
+	return report, nil
+}
+
+// summarize computes the latency percentiles and error rate of samples
+func summarize(samples []benchSample) latencyStats {
+	stats := latencyStats{Requests: len(samples)}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	latencies := make([]time.Duration, len(samples))
+	for i, sample := range samples {
+		latencies[i] = sample.latency
+		if sample.err != nil {
+			stats.Errors++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	stats.ErrorRate = float64(stats.Errors) / float64(len(samples))
+	stats.P50MS = milliseconds(percentile(latencies, 50))
+	stats.P95MS = milliseconds(percentile(latencies, 95))
+	stats.P99MS = milliseconds(percentile(latencies, 99))
+	stats.MaxMS = milliseconds(latencies[len(latencies)-1])
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// print writes the report as a table
+func (r *benchReport) print(w io.Writer) {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TEMPLATE\tREQUESTS\tERRORS\tP50\tP95\tP99\tMAX")
+	row := func(name string, stats latencyStats) {
+		fmt.Fprintf(writer, "%s\t%d\t%d (%.1f%%)\t%.2fms\t%.2fms\t%.2fms\t%.2fms\n",
+			name, stats.Requests, stats.Errors, stats.ErrorRate*100, stats.P50MS, stats.P95MS, stats.P99MS, stats.MaxMS)
+	}
+	for _, name := range r.names {
+		row(name, r.Templates[name])
+	}
+	row("TOTAL", r.Total)
+	writer.Flush()
+
+	fmt.Fprintf(w, "\n📊 %d requests in %s (%.1f req/s)\n", r.Total.Requests, r.Duration, r.AchievedRPS)
+	fmt.Fprintf(w, "💾 Cache: %d hits, %d misses (%.1f%% hit ratio)\n", r.CacheHits, r.CacheMisses, r.CacheHitRatio*100)
+}
+
+// bench replays templates against the processor and returns the exit code: 1 when any request failed
+func bench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	rps := flags.Int("rps", 50, "Requests per second to send")
+	duration := flags.Duration("duration", 10*time.Second, "How long to run")
+	requests := flags.Int("requests", 0, "Stop after this many requests (0 for no limit)")
+	concurrency := flags.Int("concurrency", 8, "Maximum requests in flight")
+	mode := flags.String("mode", "akamai", "Processor mode for local runs: fastly, akamai, w3c or development")
+	baseURL := flags.String("base-url", "", "Base URL relative includes are fetched from in local runs")
+	cacheTTL := flags.Int("cache-ttl", 300, "Fragment cache TTL in seconds for local runs (0 disables the cache)")
+	endpoint := flags.String("endpoint", "", "Emulator URL (e.g. http://localhost:3000) to send requests to instead of processing locally")
+	contextsFile := flags.String("contexts", "", "JSON array of request contexts to replay each template with")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: esi bench [options] template.html...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *rps <= 0 || *concurrency <= 0 || *duration <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -rps, -concurrency and -duration must be positive")
+		return 2
+	}
+	if !validMode(*mode) {
+		fmt.Fprintf(os.Stderr, "Error: -mode must be one of %v\n", modes)
+		return 2
+	}
+
+	contexts := []esi.ProcessContext{{}}
+	if *contextsFile != "" {
+		contextData, err := ioutil.ReadFile(*contextsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading contexts file: %v\n", err)
+			return 2
+		}
+		if err := json.Unmarshal(contextData, &contexts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing contexts JSON: %v\n", err)
+			return 2
+		}
+		if len(contexts) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: the contexts file has no contexts")
+			return 2
+		}
+	}
+
+	var cases []benchCase
+	for _, file := range flags.Args() {
+		template, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading template: %v\n", err)
+			return 2
+		}
+		for _, context := range contexts {
+			if context.Headers == nil {
+				context.Headers = map[string]string{}
+			}
+			if context.Cookies == nil {
+				context.Cookies = map[string]string{}
+			}
+			if context.BaseURL == "" {
+				context.BaseURL = *baseURL
+			}
+			cases = append(cases, benchCase{name: filepath.Base(file), template: string(template), context: context})
+		}
+	}
+
+	var target benchTarget
+	if *endpoint != "" {
+		target = &endpointTarget{client: client.New(*endpoint)}
+	} else {
+		target = &localTarget{processor: esi.NewProcessor(esi.Config{
+			Mode:        *mode,
+			MaxIncludes: 256,
+			MaxDepth:    5,
+			BaseURL:     *baseURL,
+			Cache:       esi.CacheConfig{Enabled: *cacheTTL > 0, TTL: *cacheTTL},
+		})}
+	}
+
+	report, err := runBench(target, cases, benchOptions{rps: *rps, duration: *duration, requests: *requests, concurrency: *concurrency})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if *jsonOutput {
+		encoded, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding report: %v\n", err)
+			return 2
+		}
+		fmt.Println(string(encoded))
+	} else {
+		report.print(os.Stdout)
+	}
+
+	if report.Total.Errors > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/client"
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, 50*time.Millisecond, percentile(latencies, 50))
+	assert.Equal(t, 95*time.Millisecond, percentile(latencies, 95))
+	assert.Equal(t, 99*time.Millisecond, percentile(latencies, 99))
+	assert.Equal(t, time.Millisecond, percentile(latencies[:1], 99))
+}
+
+func TestRunBench_Local(t *testing.T) {
+	fragments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<nav>menu</nav>"))
+	}))
+	defer fragments.Close()
+
+	target := &localTarget{processor: esi.NewProcessor(esi.Config{
+		Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, BaseURL: fragments.URL,
+		Cache: esi.CacheConfig{Enabled: true, TTL: 60},
+	})}
+	cases := []benchCase{
+		{name: "nav.html", template: `<esi:include src="/nav"></esi:include>`},
+		{name: "vars.html", template: `<esi:vars>$(HTTP_HOST)</esi:vars>`},
+	}
+
+	report, err := runBench(target, cases, benchOptions{rps: 1000, duration: 5 * time.Second, requests: 20, concurrency: 4})
+	require.NoError(t, err)
+
+	assert.Equal(t, 20, report.Total.Requests)
+	assert.Equal(t, 10, report.Templates["nav.html"].Requests)
+	assert.Equal(t, []string{"nav.html", "vars.html"}, report.names)
+	assert.Zero(t, report.Total.Errors)
+	assert.Equal(t, int64(10), report.CacheHits+report.CacheMisses)
+	assert.GreaterOrEqual(t, report.CacheHits, int64(6), "only concurrent first fetches miss")
+	assert.LessOrEqual(t, report.Total.P50MS, report.Total.P99MS)
+}
+
+func TestRunBench_Endpoint(t *testing.T) {
+	srv := server.New(server.Config{Mode: "esi"},
+		server.WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	target := &endpointTarget{client: client.New(ts.URL)}
+	report, err := runBench(target, []benchCase{{name: "page.html", template: "<p>page</p>"}},
+		benchOptions{rps: 1000, duration: 5 * time.Second, requests: 5, concurrency: 2})
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, report.Total.Requests)
+	assert.Zero(t, report.Total.Errors)
+}
+
+// slowTarget takes delay to process every request
+type slowTarget struct {
+	delay time.Duration
+}
+
+func (s slowTarget) process(string, esi.ProcessContext) error {
+	time.Sleep(s.delay)
+	return nil
+}
+
+func (slowTarget) cacheCounts() (cacheCounts, error) {
+	return cacheCounts{}, nil
+}
+
+func TestRunBench_DeadlineWithBusyWorkers(t *testing.T) {
+	// The only worker is busy past the deadline, so no second request is sent
+	report, err := runBench(slowTarget{delay: 300 * time.Millisecond}, []benchCase{{name: "page.html"}},
+		benchOptions{rps: 1000, duration: 50 * time.Millisecond, concurrency: 1})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, report.Total.Requests)
+}
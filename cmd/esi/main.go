@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+)
+
+// modes are the processor modes templates can be checked against
+var modes = esi.Modes()
+
+func main() {
+	if len(os.Args) < 2 {
+		printHelp()
+		os.Exit(2)
+	}
+
+	switch command := os.Args[1]; command {
+	case "lint":
+		os.Exit(lint(os.Args[2:]))
+	case "diff":
+		os.Exit(diff(os.Args[2:]))
+	case "features":
+		os.Exit(features(os.Args[2:]))
+	case "bench":
+		os.Exit(bench(os.Args[2:]))
+	case "prerender":
+		os.Exit(prerender(os.Args[2:]))
+	case "help", "-help", "--help", "-h":
+		printHelp()
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", command)
+		printHelp()
+		os.Exit(2)
+	}
+}
+
+// lint reports problems in templates and returns the exit code: 1 when any error was found
+func lint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	mode := flags.String("mode", "akamai", "Processor mode to check against: fastly, akamai, w3c or development")
+	maxIncludes := flags.Int("max-includes", 256, "Include budget per request (0 for no limit)")
+	jsonOutput := flags.Bool("json", false, "Print the issues as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: esi lint [options] template.html...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if !validMode(*mode) {
+		fmt.Fprintf(os.Stderr, "Error: -mode must be one of %v\n", modes)
+		return 2
+	}
+
+	config := esi.Config{Mode: *mode, MaxIncludes: *maxIncludes}
+	results := make(map[string][]esi.LintIssue, flags.NArg())
+	status := 0
+	for _, file := range flags.Args() {
+		template, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading template: %v\n", err)
+			return 2
+		}
+
+		issues := esi.Lint(string(template), config)
+		results[file] = issues
+		for _, issue := range issues {
+			if issue.Severity == esi.LintError {
+				status = 1
+			}
+			if !*jsonOutput {
+				fmt.Printf("%s:%s\n", file, issue)
+			}
+		}
+	}
+
+	if *jsonOutput {
+		encoded, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding issues: %v\n", err)
+			return 2
+		}
+		fmt.Println(string(encoded))
+	}
+	return status
+}
+
+// diff compares the output of a template under several modes and returns the exit
+// code: 1 when any mode's output differs from the first mode's
+func diff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	modeList := flags.String("modes", "fastly,akamai", "Comma-separated modes to compare; the first is the baseline")
+	includesFile := flags.String("includes", "", "JSON file mapping include URLs or paths to mocked content")
+	baseURL := flags.String("base-url", esi.DefaultComparisonBaseURL, "Base URL relative includes are resolved against")
+	jsonOutput := flags.Bool("json", false, "Print the outputs, diffs and feature usage as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: esi diff [options] template.html")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	compared := strings.Split(*modeList, ",")
+	for _, mode := range compared {
+		if !validMode(mode) {
+			fmt.Fprintf(os.Stderr, "Error: unknown mode %q, expected one of %v\n", mode, modes)
+			return 2
+		}
+	}
+
+	var includes map[string]string
+	if *includesFile != "" {
+		includeData, err := ioutil.ReadFile(*includesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading includes file: %v\n", err)
+			return 2
+		}
+		if err := json.Unmarshal(includeData, &includes); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing includes JSON: %v\n", err)
+			return 2
+		}
+	}
+
+	template, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading template: %v\n", err)
+		return 2
+	}
+
+	comparison, err := esi.CompareModes(string(template), esi.ModeComparisonOptions{
+		Modes:    compared,
+		Includes: includes,
+		BaseURL:  *baseURL,
+		Context:  esi.ProcessContext{Headers: map[string]string{}, Cookies: map[string]string{}},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if *jsonOutput {
+		encoded, err := json.MarshalIndent(comparison, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding comparison: %v\n", err)
+			return 2
+		}
+		fmt.Println(string(encoded))
+	} else {
+		fmt.Print(comparison.Report())
+	}
+
+	if comparison.Differs() {
+		return 1
+	}
+	return 0
+}
+
+// features reports the ESI features templates use and the modes that process them and
+// returns the exit code: 1 when a required mode does not process a feature in use
+func features(args []string) int {
+	flags := flag.NewFlagSet("features", flag.ExitOnError)
+	modeList := flags.String("modes", strings.Join(modes, ","), "Comma-separated modes to report support for")
+	requireList := flags.String("require", "", "Comma-separated modes that must process every feature used")
+	jsonOutput := flags.Bool("json", false, "Print the analysis as JSON, keyed by file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: esi features [options] template.html...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	analyzed := strings.Split(*modeList, ",")
+	var required []string
+	if *requireList != "" {
+		required = strings.Split(*requireList, ",")
+	}
+	for _, mode := range append(analyzed, required...) {
+		if !validMode(mode) {
+			fmt.Fprintf(os.Stderr, "Error: unknown mode %q, expected one of %v\n", mode, modes)
+			return 2
+		}
+	}
+	// Required modes are always part of the analysis
+	for _, mode := range required {
+		if !containsMode(analyzed, mode) {
+			analyzed = append(analyzed, mode)
+		}
+	}
+
+	results := make(map[string]*esi.FeatureAnalysis, flags.NArg())
+	status := 0
+	for _, file := range flags.Args() {
+		template, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading template: %v\n", err)
+			return 2
+		}
+
+		analysis := esi.AnalyzeFeatures(string(template), analyzed)
+		results[file] = analysis
+		if !*jsonOutput {
+			fmt.Printf("%s:\n%s", file, analysis.Report())
+		}
+		for _, mode := range required {
+			if unsupported := analysis.Unsupported(mode); len(unsupported) > 0 {
+				fmt.Fprintf(os.Stderr, "%s: %s does not support %s\n", file, mode, strings.Join(unsupported, ", "))
+				status = 1
+			}
+		}
+	}
+
+	if *jsonOutput {
+		encoded, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding analysis: %v\n", err)
+			return 2
+		}
+		fmt.Println(string(encoded))
+	}
+	return status
+}
+
+// validMode reports whether mode is a processor mode
+func validMode(mode string) bool {
+	for _, known := range modes {
+		if mode == known {
+			return true
+		}
+	}
+	return false
+}
+
+// containsMode reports whether mode is in list
+func containsMode(list []string, mode string) bool {
+	for _, listed := range list {
+		if listed == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func printHelp() {
+	fmt.Println("ESI Template Tool")
+	fmt.Println("=================")
+	fmt.Println()
+	fmt.Println("Checks ESI templates against the emulator's processor modes, benchmarks them and pre-renders their static includes.")
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Println("  esi <command> [options] template.html...")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  lint")
+	fmt.Println("        Report unknown elements for the mode, missing required attributes, unreachable")
+	fmt.Println("        branches, variables used before assignment and includes over the budget.")
+	fmt.Println("        Exits with status 1 when any error is found.")
+	fmt.Println("  diff")
+	fmt.Println("        Process a template under several modes with mocked includes and print a unified")
+	fmt.Println("        diff of the outputs and a feature usage report. Exits with status 1 when they differ.")
+	fmt.Println("  features")
+	fmt.Println("        Report the ESI elements, comment blocks and variables templates use, the lines they are")
+	fmt.Println("        on and the modes that do not process them. Exits with status 1 when a -require mode")
+	fmt.Println("        does not process a feature in use.")
+	fmt.Println("  bench")
+	fmt.Println("        Replay templates at a fixed rate against the local processor or a running emulator and")
+	fmt.Println("        report p50/p95/p99 processing times, error rates and the cache hit ratio.")
+	fmt.Println("        Exits with status 1 when any request fails.")
+	fmt.Println("  prerender")
+	fmt.Println("        Inline the static includes of a template, those marked cacheable with a long TTL, and")
+	fmt.Println("        leave the dynamic ones as ESI; prints the flattened template and a report of what was inlined.")
+	fmt.Println()
+	fmt.Println("Lint Flags:")
+	fmt.Println("  -mode string")
+	fmt.Println("        Processor mode to check against: fastly, akamai, w3c or development (default: akamai)")
+	fmt.Println("  -max-includes int")
+	fmt.Println("        Include budget per request, 0 for no limit (default: 256, as the emulator)")
+	fmt.Println("  -json")
+	fmt.Println("        Print the issues as JSON, keyed by file")
+	fmt.Println()
+	fmt.Println("Diff Flags:")
+	fmt.Println("  -modes string")
+	fmt.Println("        Comma-separated modes to compare; the first is the baseline (default: fastly,akamai)")
+	fmt.Println("  -includes string")
+	fmt.Println("        JSON file mapping include URLs or paths to mocked content; others render a placeholder")
+	fmt.Println("  -base-url string")
+	fmt.Println("        Base URL relative includes are resolved against (default: http://localhost)")
+	fmt.Println("  -json")
+	fmt.Println("        Print the outputs, diffs and feature usage as JSON")
+	fmt.Println()
+	fmt.Println("Features Flags:")
+	fmt.Println("  -modes string")
+	fmt.Println("        Comma-separated modes to report support for (default: fastly,akamai,w3c,development)")
+	fmt.Println("  -require string")
+	fmt.Println("        Comma-separated modes that must process every feature used, to gate CI")
+	fmt.Println("  -json")
+	fmt.Println("        Print the features, their lines and support per mode as JSON, keyed by file")
+	fmt.Println()
+	fmt.Println("Bench Flags:")
+	fmt.Println("  -rps int")
+	fmt.Println("        Requests per second to send (default: 50)")
+	fmt.Println("  -duration duration")
+	fmt.Println("        How long to run (default: 10s)")
+	fmt.Println("  -requests int")
+	fmt.Println("        Stop after this many requests (default: 0 for no limit)")
+	fmt.Println("  -concurrency int")
+	fmt.Println("        Maximum requests in flight (default: 8)")
+	fmt.Println("  -mode string")
+	fmt.Println("        Processor mode for local runs (default: akamai)")
+	fmt.Println("  -base-url string")
+	fmt.Println("        Base URL relative includes are fetched from in local runs")
+	fmt.Println("  -cache-ttl int")
+	fmt.Println("        Fragment cache TTL in seconds for local runs, 0 to disable (default: 300)")
+	fmt.Println("  -endpoint string")
+	fmt.Println("        Emulator URL to send requests to instead of processing locally")
+	fmt.Println("  -contexts string")
+	fmt.Println("        JSON array of request contexts to replay each template with")
+	fmt.Println("  -json")
+	fmt.Println("        Print the report as JSON")
+	fmt.Println()
+	fmt.Println("Prerender Flags:")
+	fmt.Println("  -base-url string")
+	fmt.Println("        Base URL relative includes are fetched from (default: http://localhost)")
+	fmt.Println("  -min-ttl duration")
+	fmt.Println("        Shortest TTL, from the ttl attribute or the fragment's Cache-Control, inlined (default: 1h)")
+	fmt.Println("  -includes string")
+	fmt.Println("        JSON file mapping include URLs or paths to mocked content; others stay dynamic")
+	fmt.Println("  -o string")
+	fmt.Println("        File to write the flattened template to (default: stdout)")
+	fmt.Println("  -report string")
+	fmt.Println("        File to write the JSON report to (default: a summary on stderr)")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  esi lint -mode fastly templates/*.html")
+	fmt.Println("  esi lint -json page.html")
+	fmt.Println("  esi diff -modes fastly,akamai -includes mocks.json page.html")
+	fmt.Println("  esi features -require fastly -json templates/*.html")
+	fmt.Println("  esi bench -rps 200 -duration 30s -base-url http://localhost:3000 templates/*.html")
+	fmt.Println("  esi prerender -base-url http://localhost:3000 -o dist/page.html -report inlined.json page.html")
+}
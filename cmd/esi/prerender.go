@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+)
+
+// prerender flattens the static includes of a template into it and returns the exit code
+func prerender(args []string) int {
+	flags := flag.NewFlagSet("prerender", flag.ExitOnError)
+	baseURL := flags.String("base-url", esi.DefaultComparisonBaseURL, "Base URL relative includes are fetched from")
+	minTTL := flags.Duration("min-ttl", esi.DefaultPrerenderMinTTL, "Shortest include TTL inlined")
+	includesFile := flags.String("includes", "", "JSON file mapping include URLs or paths to mocked content instead of fetching")
+	output := flags.String("o", "", "File to write the flattened template to (default: stdout)")
+	reportFile := flags.String("report", "", "File to write the JSON report to (default: a summary on stderr)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: esi prerender [options] template.html")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	if *minTTL <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -min-ttl must be positive")
+		return 2
+	}
+
+	var includes map[string]string
+	if *includesFile != "" {
+		includeData, err := ioutil.ReadFile(*includesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading includes file: %v\n", err)
+			return 2
+		}
+		if err := json.Unmarshal(includeData, &includes); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing includes JSON: %v\n", err)
+			return 2
+		}
+	}
+
+	template, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading template: %v\n", err)
+		return 2
+	}
+
+	flattened, report, err := esi.Prerender(string(template), esi.PrerenderOptions{
+		BaseURL:  *baseURL,
+		MinTTL:   *minTTL,
+		Includes: includes,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if *output != "" {
+		err = ioutil.WriteFile(*output, []byte(flattened), 0644)
+	} else {
+		_, err = io.WriteString(os.Stdout, flattened)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing template: %v\n", err)
+		return 2
+	}
+
+	if *reportFile == "" {
+		printPrerenderReport(os.Stderr, report)
+		return 0
+	}
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding report: %v\n", err)
+		return 2
+	}
+	if err := ioutil.WriteFile(*reportFile, append(encoded, '\n'), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		return 2
+	}
+	return 0
+}
+
+// printPrerenderReport writes a line per include and a summary
+func printPrerenderReport(w io.Writer, report *esi.PrerenderReport) {
+	for _, include := range report.Inlined {
+		fmt.Fprintf(w, "inlined  %s (line %d, depth %d, ttl %s, %d bytes)\n", include.Src, include.Line, include.Depth,
+			time.Duration(include.TTLSeconds)*time.Second, include.Size)
+	}
+	for _, include := range report.Dynamic {
+		fmt.Fprintf(w, "dynamic  %s (line %d, depth %d): %s\n", include.Src, include.Line, include.Depth, include.Reason)
+	}
+	fmt.Fprintf(w, "%d includes inlined, %d left dynamic\n", len(report.Inlined), len(report.Dynamic))
+}
@@ -1,9 +1,15 @@
 package config
 
 import (
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
+	"github.com/edge-computing/emulator-suite/pkg/templates"
 )
 
 // Config holds all configuration for the emulator suite
@@ -11,57 +17,257 @@ type Config struct {
 	// Server configuration
 	Port int
 	Host string
+	// Network interfaces or IP addresses to listen on; empty listens on all interfaces
+	ListenInterfaces []string
+	// Unix domain socket to listen on instead of TCP
+	UnixSocket string
+	// Set SO_REUSEPORT so several emulators can share the port
+	ReusePort bool
 
 	// Emulator configuration
 	EmulatorMode string
 	ESIMode      string
 	Debug        bool
 
-	// Logging configuration
-	LogLevel string
-	LogFile  string
+	// ESI processor configuration; zero selects the defaults
+	ESIMaxIncludes int
+	ESIMaxDepth    int
+
+	// Header include requests carry the request ID in; empty selects X-Request-ID
+	RequestIDHeader string
+
+	// Include fetch time in milliseconds from which includes are sampled as slow; zero selects 100
+	ESISlowIncludeMS int
+
+	// Keep rolling one- and five-minute windows of the processing counters in /stats
+	ESIStatsWindows bool
+
+	// Wall-clock budget of processing a page in milliseconds, after which no new includes
+	// are fetched; zero disables the budget
+	ESIMaxProcessingMS int
+
+	// Output formatting of processed pages: preserve, collapse or minify
+	ESIOutput string
+
+	// Include URLs, the first of each page, sent back in a Link rel=preload header; zero
+	// sends none. ESIEarlyHints also sends them in a 103 Early Hints response before
+	// processing documents.
+	ESIPreloadHints int
+	ESIEarlyHints   bool
+
+	// Fastly VCL run for /fastly/process and every include, in the esi emulator with the
+	// fastly ESI mode; empty serves no VCL
+	FastlyVCLFile string
+
+	// SQLite database recording every processed page for /history and the history
+	// command, in the esi and integrated emulators; empty records nothing
+	ESIHistoryDB string
+
+	// Parsed templates kept by content hash; zero selects 256 and a negative size disables the cache
+	ESITemplateCacheSize int
+
+	// Media types of the documents processed, such as text/html or text/*; empty selects
+	// text/html and application/xhtml+xml. Other documents pass through untouched.
+	ESIProcessContentTypes []string
+
+	// Fault injection for include fetches; only set from a configuration file
+	ESIFaults esi.FaultConfig
+
+	// Sanitizer policy for the fragments of untrusted hosts; the hosts can also be set
+	// from the environment
+	ESISanitize esi.SanitizeConfig
+	// TLS settings of include origins with a private PKI or mTLS; only set from a
+	// configuration file
+	ESITLS []esi.OriginTLS
+	// Addresses include fetches connect to instead of resolving their host or host:port,
+	// like curl's --resolve
+	ESIResolve map[string]string
+	// Rewrite rules of include URLs; only set from a configuration file
+	ESIRewrites []esi.RewriteRule
+	// Edge data read by $(EDGE_DATA{key}) and esi:lookup, from an HTTP JSON endpoint or
+	// a JSON file; zero timeout and TTL (seconds) select the defaults and a negative TTL
+	// disables caching
+	ESIEdgeDataURL       string
+	ESIEdgeDataFile      string
+	ESIEdgeDataTimeoutMS int
+	ESIEdgeDataTTL       int
+	// Error page served instead of pages whose includes fail past its threshold, or
+	// whose required includes fail; the body is only set from a configuration file
+	ESIErrorPage esi.ErrorPageConfig
+	// Template store served by /process/by-name, a directory or an HTTP repository of
+	// versioned templates, in the esi and integrated emulators. Pins are the versions
+	// served for references without one; zero timeout and TTL (seconds) select the
+	// defaults and a negative TTL looks the latest version up on every request.
+	ESITemplatesDir       string
+	ESITemplatesURL       string
+	ESITemplatePins       map[string]string
+	ESITemplatesTimeoutMS int
+	ESITemplatesTTL       int
+
+	// Property Manager configuration
+	PropertyFile string
+	// Bytes of a request body the body criteria inspect; zero selects the default
+	PMMaxBodyInspectBytes int
+	// IPs and CIDR ranges of the proxies whose X-Forwarded-For hops are trusted when
+	// resolving the client IP
+	PMTrustedProxies []string
+
+	// A/B experiments, shared by $(EXPERIMENT{name}) in ESI and the experiment criterion
+	// of Property Manager; only set from a configuration file
+	Experiments []experiment.Experiment
+
+	// Prefix of the request headers overriding the geo of ESI variables and Property Manager
+	// criteria, such as X-Emulator-Geo- for X-Emulator-Geo-Country; empty disables the override
+	GeoHeaderPrefix string
+
+	// Key shared by the sign_url ESI function and the /fragments endpoints, which then only
+	// serve signed URLs; empty disables signing. Signed URLs are valid for URLSigningTTL
+	// seconds, zero selecting the default.
+	URLSigningKey string
+	URLSigningTTL int
+
+	// Logging configuration; component levels override LogLevel for esi, propertymanager and server
+	LogLevel           string
+	LogFile            string
+	LogFormat          string
+	LogComponentLevels map[string]string
+	// Destination of the edge access log: a file path, or stdout; empty disables it
+	AccessLog string
 
 	// Performance configuration
 	MaxConcurrentRequests int
 	RequestTimeout        int
+	MaxBodySize           int64
+	MaxResponseSize       int64
 
 	// Cache configuration
 	CacheEnabled bool
 	CacheSize    int
 	CacheTTL     int
+
+	// Content configuration
+	ExamplesDir string
+
+	// CORS configuration
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           int
 }
 
 // Default configuration values
 const (
 	DefaultPort                  = 3000
 	DefaultHost                  = "localhost"
-	DefaultEmulatorMode          = "esi"
+	DefaultEmulatorMode          = "integrated"
 	DefaultESIMode               = "akamai"
+	DefaultESIMaxIncludes        = 256
+	DefaultESIMaxDepth           = 5
+	DefaultESIOutput             = "preserve"
 	DefaultLogLevel              = "info"
+	DefaultLogFormat             = "text"
 	DefaultMaxConcurrentRequests = 1000
 	DefaultRequestTimeout        = 30
+	DefaultMaxBodySize           = 10 << 20
+	DefaultMaxResponseSize       = 50 << 20
 	DefaultCacheSize             = 1000
-	DefaultCacheTTL              = 3600
+	DefaultCacheTTL              = 300
 )
 
+// LogComponents are the subsystems whose log level can be set separately
+var LogComponents = []string{"esi", "propertymanager", "server"}
+
 // Load loads configuration from environment variables and defaults
 func Load() *Config {
-	config := &Config{
-		Port:                  getEnvAsInt("PORT", DefaultPort),
-		Host:                  getEnvAsString("HOST", DefaultHost),
-		EmulatorMode:          getEnvAsString("EMULATOR_MODE", DefaultEmulatorMode),
-		ESIMode:               getEnvAsString("ESI_MODE", DefaultESIMode),
-		Debug:                 getEnvAsBool("DEBUG", false),
-		LogLevel:              getEnvAsString("LOG_LEVEL", DefaultLogLevel),
-		LogFile:               getEnvAsString("LOG_FILE", ""),
-		MaxConcurrentRequests: getEnvAsInt("MAX_CONCURRENT_REQUESTS", DefaultMaxConcurrentRequests),
-		RequestTimeout:        getEnvAsInt("REQUEST_TIMEOUT", DefaultRequestTimeout),
-		CacheEnabled:          getEnvAsBool("CACHE_ENABLED", true),
-		CacheSize:             getEnvAsInt("CACHE_SIZE", DefaultCacheSize),
-		CacheTTL:              getEnvAsInt("CACHE_TTL", DefaultCacheTTL),
+	config := Defaults()
+	config.applyEnv()
+	return config
+}
+
+// Defaults returns the default configuration
+func Defaults() *Config {
+	return &Config{
+		Port:                  DefaultPort,
+		Host:                  DefaultHost,
+		EmulatorMode:          DefaultEmulatorMode,
+		ESIMode:               DefaultESIMode,
+		ESIMaxIncludes:        DefaultESIMaxIncludes,
+		ESIMaxDepth:           DefaultESIMaxDepth,
+		ESIOutput:             DefaultESIOutput,
+		LogLevel:              DefaultLogLevel,
+		LogFormat:             DefaultLogFormat,
+		MaxConcurrentRequests: DefaultMaxConcurrentRequests,
+		RequestTimeout:        DefaultRequestTimeout,
+		MaxBodySize:           DefaultMaxBodySize,
+		MaxResponseSize:       DefaultMaxResponseSize,
+		CacheEnabled:          true,
+		CacheSize:             DefaultCacheSize,
+		CacheTTL:              DefaultCacheTTL,
+		CORSAllowedOrigins:    []string{"*"},
 	}
+}
 
-	return config
+// applyEnv overrides the configuration with the environment variables that are set
+func (c *Config) applyEnv() {
+	c.Port = getEnvAsInt("PORT", c.Port)
+	c.Host = getEnvAsString("HOST", c.Host)
+	c.ListenInterfaces = getEnvAsStringSlice("LISTEN_INTERFACES", c.ListenInterfaces)
+	c.UnixSocket = getEnvAsString("UNIX_SOCKET", c.UnixSocket)
+	c.ReusePort = getEnvAsBool("REUSE_PORT", c.ReusePort)
+	c.EmulatorMode = getEnvAsString("EMULATOR_MODE", c.EmulatorMode)
+	c.ESIMode = getEnvAsString("ESI_MODE", c.ESIMode)
+	c.ESIMaxIncludes = getEnvAsInt("ESI_MAX_INCLUDES", c.ESIMaxIncludes)
+	c.ESIMaxDepth = getEnvAsInt("ESI_MAX_DEPTH", c.ESIMaxDepth)
+	c.RequestIDHeader = getEnvAsString("REQUEST_ID_HEADER", c.RequestIDHeader)
+	c.ESISlowIncludeMS = getEnvAsInt("ESI_SLOW_INCLUDE_MS", c.ESISlowIncludeMS)
+	c.ESIStatsWindows = getEnvAsBool("ESI_STATS_WINDOWS", c.ESIStatsWindows)
+	c.ESIMaxProcessingMS = getEnvAsInt("ESI_MAX_PROCESSING_MS", c.ESIMaxProcessingMS)
+	c.ESIOutput = getEnvAsString("ESI_OUTPUT", c.ESIOutput)
+	c.ESIPreloadHints = getEnvAsInt("ESI_PRELOAD_HINTS", c.ESIPreloadHints)
+	c.ESIEarlyHints = getEnvAsBool("ESI_EARLY_HINTS", c.ESIEarlyHints)
+	c.FastlyVCLFile = getEnvAsString("FASTLY_VCL_FILE", c.FastlyVCLFile)
+	c.ESIHistoryDB = getEnvAsString("ESI_HISTORY_DB", c.ESIHistoryDB)
+	c.ESITemplateCacheSize = getEnvAsInt("ESI_TEMPLATE_CACHE_SIZE", c.ESITemplateCacheSize)
+	c.ESIProcessContentTypes = getEnvAsStringSlice("ESI_PROCESS_CONTENT_TYPES", c.ESIProcessContentTypes)
+	c.ESISanitize.Hosts = getEnvAsStringSlice("ESI_SANITIZE_HOSTS", c.ESISanitize.Hosts)
+	c.ESIResolve = getEnvAsStringMap("ESI_RESOLVE", c.ESIResolve)
+	c.ESIEdgeDataURL = getEnvAsString("ESI_EDGE_DATA_URL", c.ESIEdgeDataURL)
+	c.ESIEdgeDataFile = getEnvAsString("ESI_EDGE_DATA_FILE", c.ESIEdgeDataFile)
+	c.ESIEdgeDataTimeoutMS = getEnvAsInt("ESI_EDGE_DATA_TIMEOUT_MS", c.ESIEdgeDataTimeoutMS)
+	c.ESIEdgeDataTTL = getEnvAsInt("ESI_EDGE_DATA_TTL", c.ESIEdgeDataTTL)
+	c.ESIErrorPage.FailurePercent = getEnvAsFloat("ESI_ERROR_PAGE_FAILURE_PERCENT", c.ESIErrorPage.FailurePercent)
+	c.ESIErrorPage.StatusCode = getEnvAsInt("ESI_ERROR_PAGE_STATUS", c.ESIErrorPage.StatusCode)
+	c.ESITemplatesDir = getEnvAsString("ESI_TEMPLATES_DIR", c.ESITemplatesDir)
+	c.ESITemplatesURL = getEnvAsString("ESI_TEMPLATES_URL", c.ESITemplatesURL)
+	c.ESITemplatePins = getEnvAsStringMap("ESI_TEMPLATE_PINS", c.ESITemplatePins)
+	c.ESITemplatesTimeoutMS = getEnvAsInt("ESI_TEMPLATES_TIMEOUT_MS", c.ESITemplatesTimeoutMS)
+	c.ESITemplatesTTL = getEnvAsInt("ESI_TEMPLATES_TTL", c.ESITemplatesTTL)
+	c.PropertyFile = getEnvAsString("PROPERTY_FILE", c.PropertyFile)
+	c.PMMaxBodyInspectBytes = getEnvAsInt("PM_MAX_BODY_INSPECT_BYTES", c.PMMaxBodyInspectBytes)
+	c.PMTrustedProxies = getEnvAsStringSlice("PM_TRUSTED_PROXIES", c.PMTrustedProxies)
+	c.GeoHeaderPrefix = getEnvAsString("GEO_HEADER_PREFIX", c.GeoHeaderPrefix)
+	c.URLSigningKey = getEnvAsString("URL_SIGNING_KEY", c.URLSigningKey)
+	c.URLSigningTTL = getEnvAsInt("URL_SIGNING_TTL", c.URLSigningTTL)
+	c.Debug = getEnvAsBool("DEBUG", c.Debug)
+	c.LogLevel = getEnvAsString("LOG_LEVEL", c.LogLevel)
+	c.LogFile = getEnvAsString("LOG_FILE", c.LogFile)
+	c.LogFormat = getEnvAsString("LOG_FORMAT", c.LogFormat)
+	c.LogComponentLevels = getEnvAsStringMap("LOG_COMPONENT_LEVELS", c.LogComponentLevels)
+	c.AccessLog = getEnvAsString("ACCESS_LOG", c.AccessLog)
+	c.MaxConcurrentRequests = getEnvAsInt("MAX_CONCURRENT_REQUESTS", c.MaxConcurrentRequests)
+	c.RequestTimeout = getEnvAsInt("REQUEST_TIMEOUT", c.RequestTimeout)
+	c.MaxBodySize = int64(getEnvAsInt("MAX_BODY_SIZE", int(c.MaxBodySize)))
+	c.MaxResponseSize = int64(getEnvAsInt("MAX_RESPONSE_SIZE", int(c.MaxResponseSize)))
+	c.CacheEnabled = getEnvAsBool("CACHE_ENABLED", c.CacheEnabled)
+	c.CacheSize = getEnvAsInt("CACHE_SIZE", c.CacheSize)
+	c.CacheTTL = getEnvAsInt("CACHE_TTL", c.CacheTTL)
+	c.ExamplesDir = getEnvAsString("EXAMPLES_DIR", c.ExamplesDir)
+	c.CORSAllowedOrigins = getEnvAsStringSlice("CORS_ALLOWED_ORIGINS", c.CORSAllowedOrigins)
+	c.CORSAllowedMethods = getEnvAsStringSlice("CORS_ALLOWED_METHODS", c.CORSAllowedMethods)
+	c.CORSAllowedHeaders = getEnvAsStringSlice("CORS_ALLOWED_HEADERS", c.CORSAllowedHeaders)
+	c.CORSAllowCredentials = getEnvAsBool("CORS_ALLOW_CREDENTIALS", c.CORSAllowCredentials)
+	c.CORSMaxAge = getEnvAsInt("CORS_MAX_AGE", c.CORSMaxAge)
 }
 
 // Validate validates the configuration
@@ -77,7 +283,7 @@ func (c *Config) Validate() error {
 	}
 
 	// Validate ESI mode
-	validESIModes := []string{"fastly", "akamai", "w3c", "development"}
+	validESIModes := esi.Modes()
 	if !contains(validESIModes, c.ESIMode) {
 		return &ConfigError{
 			Field:   "ESI_MODE",
@@ -86,6 +292,22 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.FastlyVCLFile != "" && (c.EmulatorMode != "esi" || c.ESIMode != "fastly") {
+		return &ConfigError{
+			Field:   "FASTLY_VCL_FILE",
+			Value:   c.FastlyVCLFile,
+			Message: "requires EMULATOR_MODE=esi and ESI_MODE=fastly",
+		}
+	}
+
+	if c.ESIHistoryDB != "" && c.EmulatorMode == "property-manager" {
+		return &ConfigError{
+			Field:   "ESI_HISTORY_DB",
+			Value:   c.ESIHistoryDB,
+			Message: "requires EMULATOR_MODE=esi or integrated",
+		}
+	}
+
 	// Validate port
 	if c.Port < 1 || c.Port > 65535 {
 		return &ConfigError{
@@ -95,6 +317,196 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	// Validate listening; a unix socket replaces the TCP listeners
+	if c.UnixSocket != "" && len(c.ListenInterfaces) > 0 {
+		return &ConfigError{
+			Field:   "UNIX_SOCKET",
+			Value:   c.UnixSocket,
+			Message: "cannot be combined with LISTEN_INTERFACES",
+		}
+	}
+	if c.UnixSocket != "" && c.ReusePort {
+		return &ConfigError{
+			Field:   "REUSE_PORT",
+			Value:   "true",
+			Message: "only applies to TCP, not UNIX_SOCKET",
+		}
+	}
+
+	// Validate ESI processor limits; zero selects the defaults
+	if c.ESIMaxIncludes < 0 {
+		return &ConfigError{
+			Field:   "ESI_MAX_INCLUDES",
+			Value:   strconv.Itoa(c.ESIMaxIncludes),
+			Message: "must not be negative",
+		}
+	}
+	if c.ESIMaxDepth < 0 {
+		return &ConfigError{
+			Field:   "ESI_MAX_DEPTH",
+			Value:   strconv.Itoa(c.ESIMaxDepth),
+			Message: "must not be negative",
+		}
+	}
+	if c.ESISlowIncludeMS < 0 {
+		return &ConfigError{
+			Field:   "ESI_SLOW_INCLUDE_MS",
+			Value:   strconv.Itoa(c.ESISlowIncludeMS),
+			Message: "must not be negative",
+		}
+	}
+	if c.ESIMaxProcessingMS < 0 {
+		return &ConfigError{
+			Field:   "ESI_MAX_PROCESSING_MS",
+			Value:   strconv.Itoa(c.ESIMaxProcessingMS),
+			Message: "must not be negative",
+		}
+	}
+	if c.ESIPreloadHints < 0 {
+		return &ConfigError{
+			Field:   "ESI_PRELOAD_HINTS",
+			Value:   strconv.Itoa(c.ESIPreloadHints),
+			Message: "must not be negative",
+		}
+	}
+	if c.ESIOutput != "" && !contains(esi.OutputModes, c.ESIOutput) {
+		return &ConfigError{
+			Field:   "ESI_OUTPUT",
+			Value:   c.ESIOutput,
+			Message: "must be one of: " + strings.Join(esi.OutputModes, ", "),
+		}
+	}
+	if err := esi.ValidateFaults(c.ESIFaults.Rules); err != nil {
+		return &ConfigError{
+			Field:   "esi.faults",
+			Value:   "",
+			Message: err.Error(),
+		}
+	}
+	if err := esi.ValidateOriginTLS(c.ESITLS); err != nil {
+		return &ConfigError{
+			Field:   "esi.tls",
+			Value:   "",
+			Message: err.Error(),
+		}
+	}
+	if err := esi.ValidateRewriteRules(c.ESIRewrites); err != nil {
+		return &ConfigError{
+			Field:   "esi.rewrites",
+			Value:   "",
+			Message: err.Error(),
+		}
+	}
+	if err := esi.ValidateResolve(c.ESIResolve); err != nil {
+		return &ConfigError{
+			Field:   "ESI_RESOLVE",
+			Value:   "",
+			Message: err.Error(),
+		}
+	}
+	if err := esi.ValidateEdgeData(c.EdgeData()); err != nil {
+		return &ConfigError{
+			Field:   "esi.edgeData",
+			Value:   "",
+			Message: err.Error(),
+		}
+	}
+	if err := esi.ValidateErrorPage(c.ESIErrorPage); err != nil {
+		return &ConfigError{
+			Field:   "esi.errorPage",
+			Value:   "",
+			Message: err.Error(),
+		}
+	}
+	if c.HasTemplates() {
+		if c.EmulatorMode == "property-manager" {
+			return &ConfigError{
+				Field:   "esi.templates",
+				Value:   "",
+				Message: "requires EMULATOR_MODE=esi or integrated",
+			}
+		}
+		if err := c.Templates().Validate(); err != nil {
+			return &ConfigError{
+				Field:   "esi.templates",
+				Value:   "",
+				Message: err.Error(),
+			}
+		}
+	}
+	if err := experiment.Validate(c.Experiments); err != nil {
+		return &ConfigError{
+			Field:   "experiments",
+			Value:   "",
+			Message: err.Error(),
+		}
+	}
+	if c.PMMaxBodyInspectBytes < 0 {
+		return &ConfigError{
+			Field:   "PM_MAX_BODY_INSPECT_BYTES",
+			Value:   strconv.Itoa(c.PMMaxBodyInspectBytes),
+			Message: "must not be negative",
+		}
+	}
+	for _, proxy := range c.PMTrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return &ConfigError{
+				Field:   "PM_TRUSTED_PROXIES",
+				Value:   proxy,
+				Message: "must be an IP address or CIDR range",
+			}
+		}
+	}
+	if c.URLSigningTTL < 0 {
+		return &ConfigError{
+			Field:   "URL_SIGNING_TTL",
+			Value:   strconv.Itoa(c.URLSigningTTL),
+			Message: "must not be negative",
+		}
+	}
+	if c.CacheTTL < 0 {
+		return &ConfigError{
+			Field:   "CACHE_TTL",
+			Value:   strconv.Itoa(c.CacheTTL),
+			Message: "must not be negative",
+		}
+	}
+
+	// Validate size limits; zero selects the server defaults
+	if c.MaxBodySize < 0 {
+		return &ConfigError{
+			Field:   "MAX_BODY_SIZE",
+			Value:   strconv.FormatInt(c.MaxBodySize, 10),
+			Message: "must not be negative",
+		}
+	}
+	if c.MaxResponseSize < 0 {
+		return &ConfigError{
+			Field:   "MAX_RESPONSE_SIZE",
+			Value:   strconv.FormatInt(c.MaxResponseSize, 10),
+			Message: "must not be negative",
+		}
+	}
+
+	// Validate CORS origins
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "*.") && !strings.Contains(origin, "://") {
+			return &ConfigError{
+				Field:   "CORS_ALLOWED_ORIGINS",
+				Value:   origin,
+				Message: "must be *, *.domain or a scheme://host origin",
+			}
+		}
+	}
+	// Credentials would let any site read responses with the user's cookies
+	if c.CORSAllowCredentials && (len(c.CORSAllowedOrigins) == 0 || contains(c.CORSAllowedOrigins, "*")) {
+		return &ConfigError{
+			Field:   "CORS_ALLOW_CREDENTIALS",
+			Value:   "true",
+			Message: "requires CORS_ALLOWED_ORIGINS to list origins rather than *",
+		}
+	}
+
 	// Validate log level
 	validLogLevels := []string{"debug", "info", "warn", "error"}
 	if !contains(validLogLevels, c.LogLevel) {
@@ -104,15 +516,73 @@ func (c *Config) Validate() error {
 			Message: "must be one of: " + strings.Join(validLogLevels, ", "),
 		}
 	}
+	for component, level := range c.LogComponentLevels {
+		if !contains(LogComponents, component) {
+			return &ConfigError{
+				Field:   "LOG_COMPONENT_LEVELS",
+				Value:   component,
+				Message: "component must be one of: " + strings.Join(LogComponents, ", "),
+			}
+		}
+		if !contains(validLogLevels, level) {
+			return &ConfigError{
+				Field:   "LOG_COMPONENT_LEVELS",
+				Value:   component + "=" + level,
+				Message: "level must be one of: " + strings.Join(validLogLevels, ", "),
+			}
+		}
+	}
+
+	// Validate log format; empty selects text
+	validLogFormats := []string{"text", "json"}
+	if c.LogFormat != "" && !contains(validLogFormats, c.LogFormat) {
+		return &ConfigError{
+			Field:   "LOG_FORMAT",
+			Value:   c.LogFormat,
+			Message: "must be one of: " + strings.Join(validLogFormats, ", "),
+		}
+	}
 
 	return nil
 }
 
 // GetAddress returns the full address string for the server
 func (c *Config) GetAddress() string {
+	if c.UnixSocket != "" {
+		return "unix:" + c.UnixSocket
+	}
+	if len(c.ListenInterfaces) > 0 {
+		return strings.Join(c.ListenInterfaces, ",") + ":" + strconv.Itoa(c.Port)
+	}
 	return c.Host + ":" + strconv.Itoa(c.Port)
 }
 
+// EdgeData returns the ESI edge data configuration
+func (c *Config) EdgeData() esi.EdgeDataConfig {
+	return esi.EdgeDataConfig{
+		URL:     c.ESIEdgeDataURL,
+		File:    c.ESIEdgeDataFile,
+		Timeout: time.Duration(c.ESIEdgeDataTimeoutMS) * time.Millisecond,
+		TTL:     time.Duration(c.ESIEdgeDataTTL) * time.Second,
+	}
+}
+
+// Templates returns the template store configuration
+func (c *Config) Templates() templates.Config {
+	return templates.Config{
+		Dir:     c.ESITemplatesDir,
+		URL:     c.ESITemplatesURL,
+		Pins:    c.ESITemplatePins,
+		Timeout: time.Duration(c.ESITemplatesTimeoutMS) * time.Millisecond,
+		TTL:     time.Duration(c.ESITemplatesTTL) * time.Second,
+	}
+}
+
+// HasTemplates returns true if a template store is configured
+func (c *Config) HasTemplates() bool {
+	return c.ESITemplatesDir != "" || c.ESITemplatesURL != ""
+}
+
 // IsESIMode returns true if the emulator is in ESI mode
 func (c *Config) IsESIMode() bool {
 	return c.EmulatorMode == "esi"
@@ -150,6 +620,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		return strings.ToLower(value) == "true" || value == "1"
@@ -157,6 +636,34 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getEnvAsStringSlice(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return defaultValue
+}
+
+// getEnvAsStringMap parses comma-separated key=value pairs such as esi=debug,server=warn
+func getEnvAsStringMap(key string, defaultValue map[string]string) map[string]string {
+	if value := os.Getenv(key); value != "" {
+		items := make(map[string]string)
+		for _, pair := range strings.Split(value, ",") {
+			name, item, _ := strings.Cut(pair, "=")
+			if name = strings.TrimSpace(name); name != "" {
+				items[name] = strings.TrimSpace(item)
+			}
+		}
+		return items
+	}
+	return defaultValue
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
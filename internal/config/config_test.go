@@ -0,0 +1,463 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
+	"github.com/edge-computing/emulator-suite/pkg/templates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadWithFile_Precedence(t *testing.T) {
+	path := writeFile(t, "emulator.yaml", `
+server:
+  port: 4000
+  mode: esi
+  cors:
+    allowedOrigins: [https://app.example.com]
+esi:
+  mode: fastly
+  maxIncludes: 32
+cache:
+  ttl: 60
+propertyManager:
+  propertyFile: property.xml
+`)
+	t.Setenv("ESI_MODE", "w3c")
+
+	cfg, err := LoadWithFile(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, 4000, cfg.Port, "file overrides defaults")
+	assert.Equal(t, "esi", cfg.EmulatorMode)
+	assert.Equal(t, "w3c", cfg.ESIMode, "environment overrides file")
+	assert.Equal(t, 32, cfg.ESIMaxIncludes)
+	assert.Equal(t, DefaultESIMaxDepth, cfg.ESIMaxDepth, "settings missing from the file keep their defaults")
+	assert.Equal(t, 60, cfg.CacheTTL)
+	assert.True(t, cfg.CacheEnabled)
+	assert.Equal(t, []string{"https://app.example.com"}, cfg.CORSAllowedOrigins)
+	assert.Equal(t, "property.xml", cfg.PropertyFile)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoadWithFile_JSON(t *testing.T) {
+	path := writeFile(t, "emulator.json", `{"server": {"debug": true}, "cache": {"enabled": false}, "logging": {"level": "debug"}}`)
+
+	cfg, err := LoadWithFile(path)
+	require.NoError(t, err)
+	assert.True(t, cfg.Debug)
+	assert.False(t, cfg.CacheEnabled)
+	assert.Equal(t, "debug", cfg.LogLevel)
+}
+
+func TestLoadWithFile_Errors(t *testing.T) {
+	_, err := LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  mod: fastly\n"))
+	assert.ErrorContains(t, err, "field mod not found")
+
+	_, err = LoadWithFile(writeFile(t, "emulator.json", `{"esi": {"mod": "fastly"}}`))
+	assert.ErrorContains(t, err, `unknown field "mod"`)
+
+	_, err = LoadWithFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "reading config file")
+}
+
+func TestLoadWithFile_Empty(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", ""))
+	require.NoError(t, err)
+	assert.Equal(t, Defaults(), cfg)
+
+	cfg, err = LoadWithFile("")
+	require.NoError(t, err)
+	assert.Equal(t, Load(), cfg)
+}
+
+func TestLoadWithFile_CORSCredentials(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "server:\n  cors:\n    allowCredentials: true\n"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, cfg.Validate(), "CORS_ALLOW_CREDENTIALS")
+
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", `
+server:
+  cors:
+    allowedOrigins: [https://app.example.com, "*.corp.example"]
+    allowCredentials: true
+`))
+	require.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoadWithFile_Logging(t *testing.T) {
+	path := writeFile(t, "emulator.yaml", `
+logging:
+  format: json
+  components:
+    esi: debug
+    server: warn
+  accessLog: stdout
+`)
+	cfg, err := LoadWithFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "json", cfg.LogFormat)
+	assert.Equal(t, "stdout", cfg.AccessLog)
+	assert.Equal(t, map[string]string{"esi": "debug", "server": "warn"}, cfg.LogComponentLevels)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("LOG_COMPONENT_LEVELS", "propertymanager=error, esi=info")
+	cfg, err = LoadWithFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"propertymanager": "error", "esi": "info"}, cfg.LogComponentLevels, "environment replaces the file levels")
+
+	cfg.LogComponentLevels = map[string]string{"cache": "debug"}
+	assert.ErrorContains(t, cfg.Validate(), "component must be one of")
+
+	cfg.LogComponentLevels = map[string]string{"esi": "verbose"}
+	assert.ErrorContains(t, cfg.Validate(), "level must be one of")
+
+	cfg.LogComponentLevels = nil
+	cfg.LogFormat = "xml"
+	assert.ErrorContains(t, cfg.Validate(), "LOG_FORMAT")
+}
+
+func TestLoadWithFile_Faults(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", `
+esi:
+  faults:
+    seed: 42
+    rules:
+      - host: fragments.example.com
+        percent: 50
+        latencyMs: 200
+      - host: "*"
+        percent: 10
+        status: 503
+`))
+	require.NoError(t, err)
+	assert.Equal(t, esi.FaultConfig{
+		Seed: 42,
+		Rules: []esi.FaultRule{
+			{Host: "fragments.example.com", Percent: 50, LatencyMS: 200},
+			{Host: "*", Percent: 10, Status: 503},
+		},
+	}, cfg.ESIFaults)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.ESIFaults.Rules[0].Percent = 150
+	assert.ErrorContains(t, cfg.Validate(), "esi.faults")
+}
+
+func TestLoadWithFile_Sanitize(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", `
+esi:
+  sanitize:
+    hosts: [partner.example.com]
+    elements: [script, iframe, style]
+    allowScriptUrls: true
+`))
+	require.NoError(t, err)
+	assert.Equal(t, esi.SanitizeConfig{
+		Hosts:           []string{"partner.example.com"},
+		Elements:        []string{"script", "iframe", "style"},
+		AllowScriptURLs: true,
+	}, cfg.ESISanitize)
+
+	t.Setenv("ESI_SANITIZE_HOSTS", "*")
+	cfg, err = LoadWithFile("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*"}, cfg.ESISanitize.Hosts)
+}
+
+func TestLoadWithFile_TLS(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", `
+esi:
+  tls:
+    - host: internal.example:8443
+      insecureSkipVerify: true
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []esi.OriginTLS{{Host: "internal.example:8443", InsecureSkipVerify: true}}, cfg.ESITLS)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.ESITLS = []esi.OriginTLS{{Host: "internal.example", CAFile: "missing.pem"}}
+	assert.ErrorContains(t, cfg.Validate(), "esi.tls")
+}
+
+func TestLoadWithFile_Resolve(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  resolve:\n    www.example.com: 127.0.0.1:8080\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"www.example.com": "127.0.0.1:8080"}, cfg.ESIResolve)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("ESI_RESOLVE", "api.example.com:443=10.0.0.5, www.example.com=")
+	cfg, err = LoadWithFile("")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"api.example.com:443": "10.0.0.5", "www.example.com": ""}, cfg.ESIResolve)
+	assert.ErrorContains(t, cfg.Validate(), "ESI_RESOLVE")
+}
+
+func TestLoadWithFile_Rewrites(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", `
+esi:
+  rewrites:
+    - host: beacon.partner.com
+      to: http://localhost:3000
+    - match: /v1/
+      replace: /v2/
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []esi.RewriteRule{
+		{Host: "beacon.partner.com", To: "http://localhost:3000"},
+		{Match: "/v1/", Replace: "/v2/"},
+	}, cfg.ESIRewrites)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.ESIRewrites = []esi.RewriteRule{{Match: "("}}
+	assert.ErrorContains(t, cfg.Validate(), "esi.rewrites")
+}
+
+func TestLoadWithFile_Experiments(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", `
+experiments:
+  - name: checkout
+    stickyKey: cookie:uid
+    variants:
+      - name: control
+        weight: 90
+      - name: one-page
+        weight: 10
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []experiment.Experiment{{
+		Name:      "checkout",
+		StickyKey: "cookie:uid",
+		Variants:  []experiment.Variant{{Name: "control", Weight: 90}, {Name: "one-page", Weight: 10}},
+	}}, cfg.Experiments)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Experiments[0].StickyKey = "session"
+	assert.ErrorContains(t, cfg.Validate(), "experiments")
+}
+
+func TestLoadWithFile_EdgeData(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", `
+esi:
+  edgeData:
+    url: https://kv.example.com/{key}
+    timeoutMs: 250
+    ttl: 30
+`))
+	require.NoError(t, err)
+	assert.Equal(t, esi.EdgeDataConfig{
+		URL:     "https://kv.example.com/{key}",
+		Timeout: 250 * time.Millisecond,
+		TTL:     30 * time.Second,
+	}, cfg.EdgeData())
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("ESI_EDGE_DATA_FILE", "/tmp/edge.json")
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  edgeData:\n    url: https://kv.example.com\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/edge.json", cfg.ESIEdgeDataFile)
+	assert.ErrorContains(t, cfg.Validate(), "esi.edgeData")
+}
+
+func TestLoadWithFile_Templates(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", `
+esi:
+  templates:
+    dir: `+dir+`
+    pins: {checkout: v42}
+    timeoutMs: 500
+    ttl: 10
+`))
+	require.NoError(t, err)
+	assert.Equal(t, templates.Config{
+		Dir:     dir,
+		Pins:    map[string]string{"checkout": "v42"},
+		Timeout: 500 * time.Millisecond,
+		TTL:     10 * time.Second,
+	}, cfg.Templates())
+	assert.True(t, cfg.HasTemplates())
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("ESI_TEMPLATE_PINS", "checkout=v41,cart=v7")
+	t.Setenv("ESI_TEMPLATES_URL", "https://templates.example.com/{name}/{version}")
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  templates:\n    dir: "+dir+"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"checkout": "v41", "cart": "v7"}, cfg.ESITemplatePins)
+	assert.ErrorContains(t, cfg.Validate(), "esi.templates")
+}
+
+func TestLoadWithFile_ErrorPage(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", `
+esi:
+  errorPage:
+    failurePercent: 25
+    statusCode: 502
+    body: <h1>Down for maintenance</h1>
+`))
+	require.NoError(t, err)
+	assert.Equal(t, esi.ErrorPageConfig{FailurePercent: 25, StatusCode: 502, Body: "<h1>Down for maintenance</h1>"}, cfg.ESIErrorPage)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("ESI_ERROR_PAGE_FAILURE_PERCENT", "12.5")
+	t.Setenv("ESI_ERROR_PAGE_STATUS", "200")
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  errorPage:\n    failurePercent: 25\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, cfg.ESIErrorPage.FailurePercent)
+	assert.ErrorContains(t, cfg.Validate(), "esi.errorPage")
+}
+
+func TestLoadWithFile_Output(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  output: minify\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "minify", cfg.ESIOutput)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.ESIOutput = "compress"
+	assert.ErrorContains(t, cfg.Validate(), "ESI_OUTPUT")
+}
+
+func TestLoadWithFile_PreloadHints(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  preloadHints: 4\n  earlyHints: true\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, cfg.ESIPreloadHints)
+	assert.True(t, cfg.ESIEarlyHints)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("ESI_PRELOAD_HINTS", "-1")
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  preloadHints: 4\n"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, cfg.Validate(), "ESI_PRELOAD_HINTS")
+}
+
+func TestLoadWithFile_FastlyVCLFile(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "server:\n  mode: esi\nesi:\n  mode: fastly\n  fastlyVclFile: service.vcl\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "service.vcl", cfg.FastlyVCLFile)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("ESI_MODE", "akamai")
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "server:\n  mode: esi\nesi:\n  fastlyVclFile: service.vcl\n"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, cfg.Validate(), "FASTLY_VCL_FILE")
+}
+
+func TestLoadWithFile_HistoryDB(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  historyDb: history.db\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "history.db", cfg.ESIHistoryDB)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("EMULATOR_MODE", "property-manager")
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  historyDb: history.db\n"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, cfg.Validate(), "ESI_HISTORY_DB")
+}
+
+func TestLoadWithFile_TemplateCacheSize(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  templateCacheSize: 32\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 32, cfg.ESITemplateCacheSize)
+
+	t.Setenv("ESI_TEMPLATE_CACHE_SIZE", "-1")
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  templateCacheSize: 32\n"))
+	require.NoError(t, err)
+	assert.Equal(t, -1, cfg.ESITemplateCacheSize)
+}
+
+func TestLoadWithFile_ProcessContentTypes(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "esi:\n  processContentTypes: [text/html, text/xml]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"text/html", "text/xml"}, cfg.ESIProcessContentTypes)
+
+	t.Setenv("ESI_PROCESS_CONTENT_TYPES", "text/*, application/xhtml+xml")
+	cfg, err = LoadWithFile("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"text/*", "application/xhtml+xml"}, cfg.ESIProcessContentTypes)
+}
+
+func TestLoadWithFile_GeoHeaderPrefix(t *testing.T) {
+	cfg, err := LoadWithFile("")
+	require.NoError(t, err)
+	assert.Empty(t, cfg.GeoHeaderPrefix)
+
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "server:\n  geoHeaderPrefix: X-Emulator-Geo-\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "X-Emulator-Geo-", cfg.GeoHeaderPrefix)
+
+	t.Setenv("GEO_HEADER_PREFIX", "X-Test-Geo-")
+	cfg, err = LoadWithFile(writeFile(t, "emulator.yaml", "server:\n  geoHeaderPrefix: X-Emulator-Geo-\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "X-Test-Geo-", cfg.GeoHeaderPrefix)
+}
+
+func TestLoadWithFile_URLSigning(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "server:\n  urlSigningKey: secret\n  urlSigningTtl: 60\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", cfg.URLSigningKey)
+	assert.Equal(t, 60, cfg.URLSigningTTL)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("URL_SIGNING_KEY", "rotated")
+	cfg, err = LoadWithFile("")
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", cfg.URLSigningKey)
+
+	cfg.URLSigningTTL = -1
+	assert.ErrorContains(t, cfg.Validate(), "URL_SIGNING_TTL")
+}
+
+func TestLoadWithFile_TrustedProxies(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "propertyManager:\n  trustedProxies: [10.0.0.0/8, 192.0.2.1]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.1"}, cfg.PMTrustedProxies)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("PM_TRUSTED_PROXIES", "172.16.0.0/12")
+	cfg, err = LoadWithFile("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"172.16.0.0/12"}, cfg.PMTrustedProxies)
+
+	cfg.PMTrustedProxies = []string{"proxy.internal"}
+	assert.ErrorContains(t, cfg.Validate(), "PM_TRUSTED_PROXIES")
+}
+
+func TestLoadWithFile_Listen(t *testing.T) {
+	cfg, err := LoadWithFile(writeFile(t, "emulator.yaml", "server:\n  listenInterfaces: [lo, 127.0.0.1]\n  reusePort: true\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lo", "127.0.0.1"}, cfg.ListenInterfaces)
+	assert.True(t, cfg.ReusePort)
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, "lo,127.0.0.1:3000", cfg.GetAddress())
+
+	t.Setenv("UNIX_SOCKET", "/tmp/emulator.sock")
+	t.Setenv("REUSE_PORT", "false")
+	cfg, err = LoadWithFile("")
+	require.NoError(t, err)
+	assert.Equal(t, "unix:/tmp/emulator.sock", cfg.GetAddress())
+	assert.NoError(t, cfg.Validate())
+
+	cfg.ListenInterfaces = []string{"lo"}
+	assert.ErrorContains(t, cfg.Validate(), "UNIX_SOCKET")
+	cfg.ListenInterfaces = nil
+	cfg.ReusePort = true
+	assert.ErrorContains(t, cfg.Validate(), "REUSE_PORT")
+}
+
+func TestDefaultFile_MatchesDefaults(t *testing.T) {
+	config := &Config{}
+	require.NoError(t, config.applyFileData("emulator.yaml", DefaultFile))
+	assert.Equal(t, Defaults(), config)
+}
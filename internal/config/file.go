@@ -0,0 +1,414 @@
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is a configuration file holding the built-in defaults, with comments, for
+// users to start their own from
+//
+//go:embed emulator.yaml
+var DefaultFile []byte
+
+// fileConfig is the layout of a configuration file. Fields are pointers so that
+// settings missing from the file keep their default values.
+type fileConfig struct {
+	Server          *serverSection          `yaml:"server" json:"server"`
+	ESI             *esiSection             `yaml:"esi" json:"esi"`
+	Cache           *cacheSection           `yaml:"cache" json:"cache"`
+	PropertyManager *propertyManagerSection `yaml:"propertyManager" json:"propertyManager"`
+	Logging         *loggingSection         `yaml:"logging" json:"logging"`
+	Experiments     []experimentRow         `yaml:"experiments" json:"experiments"`
+}
+
+// experimentRow is an A/B experiment of a configuration file
+type experimentRow struct {
+	Name      string       `yaml:"name" json:"name"`
+	StickyKey string       `yaml:"stickyKey" json:"stickyKey"`
+	Variants  []variantRow `yaml:"variants" json:"variants"`
+}
+
+// variantRow is a variant of an experiment of a configuration file
+type variantRow struct {
+	Name   string `yaml:"name" json:"name"`
+	Weight int    `yaml:"weight" json:"weight"`
+}
+
+// serverSection holds the server settings of a configuration file
+type serverSection struct {
+	Host                  *string      `yaml:"host" json:"host"`
+	Port                  *int         `yaml:"port" json:"port"`
+	ListenInterfaces      []string     `yaml:"listenInterfaces" json:"listenInterfaces"`
+	UnixSocket            *string      `yaml:"unixSocket" json:"unixSocket"`
+	ReusePort             *bool        `yaml:"reusePort" json:"reusePort"`
+	Mode                  *string      `yaml:"mode" json:"mode"`
+	Debug                 *bool        `yaml:"debug" json:"debug"`
+	MaxConcurrentRequests *int         `yaml:"maxConcurrentRequests" json:"maxConcurrentRequests"`
+	RequestTimeout        *int         `yaml:"requestTimeout" json:"requestTimeout"`
+	MaxBodySize           *int64       `yaml:"maxBodySize" json:"maxBodySize"`
+	MaxResponseSize       *int64       `yaml:"maxResponseSize" json:"maxResponseSize"`
+	ExamplesDir           *string      `yaml:"examplesDir" json:"examplesDir"`
+	GeoHeaderPrefix       *string      `yaml:"geoHeaderPrefix" json:"geoHeaderPrefix"`
+	URLSigningKey         *string      `yaml:"urlSigningKey" json:"urlSigningKey"`
+	URLSigningTTL         *int         `yaml:"urlSigningTtl" json:"urlSigningTtl"`
+	CORS                  *corsSection `yaml:"cors" json:"cors"`
+}
+
+// corsSection holds the CORS settings of a configuration file
+type corsSection struct {
+	AllowedOrigins   []string `yaml:"allowedOrigins" json:"allowedOrigins"`
+	AllowedMethods   []string `yaml:"allowedMethods" json:"allowedMethods"`
+	AllowedHeaders   []string `yaml:"allowedHeaders" json:"allowedHeaders"`
+	AllowCredentials *bool    `yaml:"allowCredentials" json:"allowCredentials"`
+	MaxAge           *int     `yaml:"maxAge" json:"maxAge"`
+}
+
+// esiSection holds the ESI processor settings of a configuration file
+type esiSection struct {
+	Mode                *string           `yaml:"mode" json:"mode"`
+	MaxIncludes         *int              `yaml:"maxIncludes" json:"maxIncludes"`
+	MaxDepth            *int              `yaml:"maxDepth" json:"maxDepth"`
+	RequestIDHeader     *string           `yaml:"requestIdHeader" json:"requestIdHeader"`
+	SlowIncludeMS       *int              `yaml:"slowIncludeMs" json:"slowIncludeMs"`
+	StatsWindows        *bool             `yaml:"statsWindows" json:"statsWindows"`
+	MaxProcessingMS     *int              `yaml:"maxProcessingMs" json:"maxProcessingMs"`
+	Output              *string           `yaml:"output" json:"output"`
+	PreloadHints        *int              `yaml:"preloadHints" json:"preloadHints"`
+	EarlyHints          *bool             `yaml:"earlyHints" json:"earlyHints"`
+	FastlyVCLFile       *string           `yaml:"fastlyVclFile" json:"fastlyVclFile"`
+	HistoryDB           *string           `yaml:"historyDb" json:"historyDb"`
+	TemplateCacheSize   *int              `yaml:"templateCacheSize" json:"templateCacheSize"`
+	ProcessContentTypes []string          `yaml:"processContentTypes" json:"processContentTypes"`
+	Faults              *faultSection     `yaml:"faults" json:"faults"`
+	Sanitize            *sanitizeSection  `yaml:"sanitize" json:"sanitize"`
+	TLS                 []originTLSRow    `yaml:"tls" json:"tls"`
+	Resolve             map[string]string `yaml:"resolve" json:"resolve"`
+	Rewrites            []rewriteRuleRow  `yaml:"rewrites" json:"rewrites"`
+	EdgeData            *edgeDataSection  `yaml:"edgeData" json:"edgeData"`
+	ErrorPage           *errorPageSection `yaml:"errorPage" json:"errorPage"`
+	Templates           *templatesSection `yaml:"templates" json:"templates"`
+}
+
+// templatesSection is the template store of a configuration file
+type templatesSection struct {
+	Dir       *string           `yaml:"dir" json:"dir"`
+	URL       *string           `yaml:"url" json:"url"`
+	Pins      map[string]string `yaml:"pins" json:"pins"`
+	TimeoutMS *int              `yaml:"timeoutMs" json:"timeoutMs"`
+	TTL       *int              `yaml:"ttl" json:"ttl"`
+}
+
+// errorPageSection is the include failure policy of a configuration file
+type errorPageSection struct {
+	FailurePercent *float64 `yaml:"failurePercent" json:"failurePercent"`
+	StatusCode     *int     `yaml:"statusCode" json:"statusCode"`
+	Body           *string  `yaml:"body" json:"body"`
+}
+
+// edgeDataSection is the edge data source of a configuration file
+type edgeDataSection struct {
+	URL       *string `yaml:"url" json:"url"`
+	File      *string `yaml:"file" json:"file"`
+	TimeoutMS *int    `yaml:"timeoutMs" json:"timeoutMs"`
+	TTL       *int    `yaml:"ttl" json:"ttl"`
+}
+
+// rewriteRuleRow is an include URL rewrite rule of a configuration file
+type rewriteRuleRow struct {
+	Match   string `yaml:"match" json:"match"`
+	Replace string `yaml:"replace" json:"replace"`
+	Host    string `yaml:"host" json:"host"`
+	To      string `yaml:"to" json:"to"`
+}
+
+// originTLSRow holds the TLS settings of an include origin in a configuration file
+type originTLSRow struct {
+	Host               string `yaml:"host" json:"host"`
+	CAFile             string `yaml:"caFile" json:"caFile"`
+	CertFile           string `yaml:"certFile" json:"certFile"`
+	KeyFile            string `yaml:"keyFile" json:"keyFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify"`
+}
+
+// sanitizeSection holds the untrusted fragment sanitizer settings of a configuration file
+type sanitizeSection struct {
+	Hosts              []string `yaml:"hosts" json:"hosts"`
+	Elements           []string `yaml:"elements" json:"elements"`
+	AllowEventHandlers bool     `yaml:"allowEventHandlers" json:"allowEventHandlers"`
+	AllowScriptURLs    bool     `yaml:"allowScriptUrls" json:"allowScriptUrls"`
+}
+
+// faultSection holds the include fault injection settings of a configuration file
+type faultSection struct {
+	Seed  int64          `yaml:"seed" json:"seed"`
+	Rules []faultRuleRow `yaml:"rules" json:"rules"`
+}
+
+// faultRuleRow is a fault rule of a configuration file
+type faultRuleRow struct {
+	Host      string  `yaml:"host" json:"host"`
+	Percent   float64 `yaml:"percent" json:"percent"`
+	LatencyMS int     `yaml:"latencyMs" json:"latencyMs"`
+	Drop      bool    `yaml:"drop" json:"drop"`
+	Status    int     `yaml:"status" json:"status"`
+}
+
+// cacheSection holds the fragment cache settings of a configuration file
+type cacheSection struct {
+	Enabled *bool `yaml:"enabled" json:"enabled"`
+	Size    *int  `yaml:"size" json:"size"`
+	TTL     *int  `yaml:"ttl" json:"ttl"`
+}
+
+// propertyManagerSection holds the Property Manager settings of a configuration file
+type propertyManagerSection struct {
+	PropertyFile        *string  `yaml:"propertyFile" json:"propertyFile"`
+	MaxBodyInspectBytes *int     `yaml:"maxBodyInspectBytes" json:"maxBodyInspectBytes"`
+	TrustedProxies      []string `yaml:"trustedProxies" json:"trustedProxies"`
+}
+
+// loggingSection holds the logging settings of a configuration file
+type loggingSection struct {
+	Level      *string           `yaml:"level" json:"level"`
+	File       *string           `yaml:"file" json:"file"`
+	Format     *string           `yaml:"format" json:"format"`
+	Components map[string]string `yaml:"components" json:"components"`
+	AccessLog  *string           `yaml:"accessLog" json:"accessLog"`
+}
+
+// LoadWithFile loads configuration with layered precedence: defaults, then the
+// configuration file (skipped when path is empty), then environment variables.
+// Command line flags are applied on top by the caller.
+func LoadWithFile(path string) (*Config, error) {
+	config := Defaults()
+	if path != "" {
+		if err := config.applyFile(path); err != nil {
+			return nil, err
+		}
+	}
+	config.applyEnv()
+	return config, nil
+}
+
+// applyFile overrides the configuration with the settings of a YAML or JSON file.
+// Files ending in .json are read as JSON, anything else as YAML; unknown keys are errors.
+func (c *Config) applyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	return c.applyFileData(path, data)
+}
+
+// applyFileData overrides the configuration with the settings of a file's content;
+// path only selects the format and names the file in errors
+func (c *Config) applyFileData(path string, data []byte) error {
+	var file fileConfig
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&file); errors.Is(err, io.EOF) {
+			err = nil // An empty file keeps the defaults
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	if server := file.Server; server != nil {
+		setString(&c.Host, server.Host)
+		setInt(&c.Port, server.Port)
+		if server.ListenInterfaces != nil {
+			c.ListenInterfaces = server.ListenInterfaces
+		}
+		setString(&c.UnixSocket, server.UnixSocket)
+		setBool(&c.ReusePort, server.ReusePort)
+		setString(&c.EmulatorMode, server.Mode)
+		setBool(&c.Debug, server.Debug)
+		setInt(&c.MaxConcurrentRequests, server.MaxConcurrentRequests)
+		setInt(&c.RequestTimeout, server.RequestTimeout)
+		setInt64(&c.MaxBodySize, server.MaxBodySize)
+		setInt64(&c.MaxResponseSize, server.MaxResponseSize)
+		setString(&c.ExamplesDir, server.ExamplesDir)
+		setString(&c.GeoHeaderPrefix, server.GeoHeaderPrefix)
+		setString(&c.URLSigningKey, server.URLSigningKey)
+		setInt(&c.URLSigningTTL, server.URLSigningTTL)
+		if cors := server.CORS; cors != nil {
+			if cors.AllowedOrigins != nil {
+				c.CORSAllowedOrigins = cors.AllowedOrigins
+			}
+			if cors.AllowedMethods != nil {
+				c.CORSAllowedMethods = cors.AllowedMethods
+			}
+			if cors.AllowedHeaders != nil {
+				c.CORSAllowedHeaders = cors.AllowedHeaders
+			}
+			setBool(&c.CORSAllowCredentials, cors.AllowCredentials)
+			setInt(&c.CORSMaxAge, cors.MaxAge)
+		}
+	}
+	if section := file.ESI; section != nil {
+		setString(&c.ESIMode, section.Mode)
+		setInt(&c.ESIMaxIncludes, section.MaxIncludes)
+		setInt(&c.ESIMaxDepth, section.MaxDepth)
+		setString(&c.RequestIDHeader, section.RequestIDHeader)
+		setInt(&c.ESISlowIncludeMS, section.SlowIncludeMS)
+		setBool(&c.ESIStatsWindows, section.StatsWindows)
+		setInt(&c.ESIMaxProcessingMS, section.MaxProcessingMS)
+		setString(&c.ESIOutput, section.Output)
+		setInt(&c.ESIPreloadHints, section.PreloadHints)
+		setBool(&c.ESIEarlyHints, section.EarlyHints)
+		setString(&c.FastlyVCLFile, section.FastlyVCLFile)
+		setString(&c.ESIHistoryDB, section.HistoryDB)
+		setInt(&c.ESITemplateCacheSize, section.TemplateCacheSize)
+		if section.ProcessContentTypes != nil {
+			c.ESIProcessContentTypes = section.ProcessContentTypes
+		}
+		if faults := section.Faults; faults != nil {
+			c.ESIFaults.Seed = faults.Seed
+			c.ESIFaults.Rules = nil
+			for _, rule := range faults.Rules {
+				c.ESIFaults.Rules = append(c.ESIFaults.Rules, esiFaultRule(rule))
+			}
+		}
+		if section.Rewrites != nil {
+			c.ESIRewrites = nil
+			for _, rule := range section.Rewrites {
+				c.ESIRewrites = append(c.ESIRewrites, esi.RewriteRule(rule))
+			}
+		}
+		if section.Resolve != nil {
+			c.ESIResolve = section.Resolve
+		}
+		if section.TLS != nil {
+			c.ESITLS = nil
+			for _, origin := range section.TLS {
+				c.ESITLS = append(c.ESITLS, esi.OriginTLS(origin))
+			}
+		}
+		if edgeData := section.EdgeData; edgeData != nil {
+			setString(&c.ESIEdgeDataURL, edgeData.URL)
+			setString(&c.ESIEdgeDataFile, edgeData.File)
+			setInt(&c.ESIEdgeDataTimeoutMS, edgeData.TimeoutMS)
+			setInt(&c.ESIEdgeDataTTL, edgeData.TTL)
+		}
+		if store := section.Templates; store != nil {
+			setString(&c.ESITemplatesDir, store.Dir)
+			setString(&c.ESITemplatesURL, store.URL)
+			if store.Pins != nil {
+				c.ESITemplatePins = store.Pins
+			}
+			setInt(&c.ESITemplatesTimeoutMS, store.TimeoutMS)
+			setInt(&c.ESITemplatesTTL, store.TTL)
+		}
+		if errorPage := section.ErrorPage; errorPage != nil {
+			setFloat64(&c.ESIErrorPage.FailurePercent, errorPage.FailurePercent)
+			setInt(&c.ESIErrorPage.StatusCode, errorPage.StatusCode)
+			setString(&c.ESIErrorPage.Body, errorPage.Body)
+		}
+		if sanitize := section.Sanitize; sanitize != nil {
+			c.ESISanitize = esi.SanitizeConfig{
+				Hosts:              sanitize.Hosts,
+				Elements:           sanitize.Elements,
+				AllowEventHandlers: sanitize.AllowEventHandlers,
+				AllowScriptURLs:    sanitize.AllowScriptURLs,
+			}
+		}
+	}
+	if cache := file.Cache; cache != nil {
+		setBool(&c.CacheEnabled, cache.Enabled)
+		setInt(&c.CacheSize, cache.Size)
+		setInt(&c.CacheTTL, cache.TTL)
+	}
+	if propertyManager := file.PropertyManager; propertyManager != nil {
+		setString(&c.PropertyFile, propertyManager.PropertyFile)
+		setInt(&c.PMMaxBodyInspectBytes, propertyManager.MaxBodyInspectBytes)
+		if propertyManager.TrustedProxies != nil {
+			c.PMTrustedProxies = propertyManager.TrustedProxies
+		}
+	}
+	if logging := file.Logging; logging != nil {
+		setString(&c.LogLevel, logging.Level)
+		setString(&c.LogFile, logging.File)
+		setString(&c.LogFormat, logging.Format)
+		if logging.Components != nil {
+			c.LogComponentLevels = logging.Components
+		}
+		setString(&c.AccessLog, logging.AccessLog)
+	}
+	if file.Experiments != nil {
+		c.Experiments = nil
+		for _, row := range file.Experiments {
+			c.Experiments = append(c.Experiments, fileExperiment(row))
+		}
+	}
+	return nil
+}
+
+// fileExperiment converts an experiment of a configuration file
+func fileExperiment(row experimentRow) experiment.Experiment {
+	converted := experiment.Experiment{Name: row.Name, StickyKey: row.StickyKey}
+	for _, variant := range row.Variants {
+		converted.Variants = append(converted.Variants, experiment.Variant(variant))
+	}
+	return converted
+}
+
+// esiFaultRule converts a fault rule of a configuration file
+func esiFaultRule(rule faultRuleRow) esi.FaultRule {
+	return esi.FaultRule{
+		Host:      rule.Host,
+		Percent:   rule.Percent,
+		LatencyMS: rule.LatencyMS,
+		Drop:      rule.Drop,
+		Status:    rule.Status,
+	}
+}
+
+// Helper functions for optional file settings
+func setString(target *string, value *string) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setInt(target *int, value *int) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setInt64(target *int64, value *int64) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setFloat64(target *float64, value *float64) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setBool(target *bool, value *bool) {
+	if value != nil {
+		*target = *value
+	}
+}
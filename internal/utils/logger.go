@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,6 +21,15 @@ const (
 	LogLevelError
 )
 
+// Log output formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// DefaultComponent names the level used by loggers without a component level of their own
+const DefaultComponent = "default"
+
 // String returns the string representation of the log level
 func (l LogLevel) String() string {
 	switch l {
@@ -34,21 +46,43 @@ func (l LogLevel) String() string {
 	}
 }
 
+// logSettings holds the levels, format and output shared by a logger and
+// every logger derived from it, so they can be changed at runtime
+type logSettings struct {
+	mutex      sync.RWMutex
+	level      LogLevel
+	components map[string]LogLevel
+	format     string
+	output     *log.Logger
+	listeners  []func(component string, level LogLevel)
+}
+
 // Logger provides structured logging functionality
 type Logger struct {
-	level   LogLevel
-	debug   bool
-	prefix  string
-	logger  *log.Logger
-	logFile *os.File
+	settings  *logSettings
+	debug     bool
+	prefix    string
+	component string
+	logFile   *os.File
+}
+
+// logEntry is a line of JSON output
+type logEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Component string `json:"component,omitempty"`
+	Logger    string `json:"logger,omitempty"`
+	Message   string `json:"message"`
 }
 
 // NewLogger creates a new logger instance
 func NewLogger(level string, debug bool, prefix string) *Logger {
-	logLevel := parseLogLevel(level)
-
 	logger := &Logger{
-		level:  logLevel,
+		settings: &logSettings{
+			level:      parseLogLevel(level),
+			components: make(map[string]LogLevel),
+			format:     LogFormatText,
+		},
 		debug:  debug,
 		prefix: prefix,
 	}
@@ -63,28 +97,69 @@ func NewLogger(level string, debug bool, prefix string) *Logger {
 func (l *Logger) setupOutput() {
 	// For now, use standard output
 	// In a production environment, you might want to use a proper logging library
-	l.logger = log.New(os.Stdout, "", log.LstdFlags)
+	l.SetOutput(os.Stdout)
+}
+
+// SetOutput redirects the output of the logger and every logger derived from it
+func (l *Logger) SetOutput(w io.Writer) {
+	l.settings.mutex.Lock()
+	defer l.settings.mutex.Unlock()
+	l.settings.output = log.New(w, "", 0)
+}
+
+// SetFormat selects text or json output for the logger and every logger derived from it
+func (l *Logger) SetFormat(format string) error {
+	format = strings.ToLower(format)
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+
+	l.settings.mutex.Lock()
+	defer l.settings.mutex.Unlock()
+	l.settings.format = format
+	return nil
 }
 
 // parseLogLevel parses a string log level into LogLevel
 func parseLogLevel(level string) LogLevel {
+	logLevel, err := ParseLogLevel(level)
+	if err != nil {
+		return LogLevelInfo
+	}
+	return logLevel
+}
+
+// ParseLogLevel parses a string log level, rejecting unknown levels
+func ParseLogLevel(level string) (LogLevel, error) {
 	switch strings.ToLower(level) {
 	case "debug":
-		return LogLevelDebug
+		return LogLevelDebug, nil
 	case "info":
-		return LogLevelInfo
+		return LogLevelInfo, nil
 	case "warn", "warning":
-		return LogLevelWarn
+		return LogLevelWarn, nil
 	case "error":
-		return LogLevelError
+		return LogLevelError, nil
 	default:
-		return LogLevelInfo
+		return LogLevelInfo, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
 	}
 }
 
 // shouldLog determines if a message should be logged based on the current log level
 func (l *Logger) shouldLog(level LogLevel) bool {
-	return level >= l.level
+	return l.Enabled(level)
+}
+
+// Enabled reports whether messages at level are logged, using the component
+// level when one is set and the default level otherwise
+func (l *Logger) Enabled(level LogLevel) bool {
+	l.settings.mutex.RLock()
+	defer l.settings.mutex.RUnlock()
+
+	if componentLevel, exists := l.settings.components[l.component]; exists && l.component != "" {
+		return level >= componentLevel
+	}
+	return level >= l.settings.level
 }
 
 // formatMessage formats a log message with timestamp, level, and prefix
@@ -98,36 +173,54 @@ func (l *Logger) formatMessage(level LogLevel, message string) string {
 	return fmt.Sprintf("[%s] [%s] %s", timestamp, levelStr, message)
 }
 
+// write logs a message in the configured format
+func (l *Logger) write(level LogLevel, format string, args []interface{}) {
+	if !l.shouldLog(level) {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+
+	l.settings.mutex.RLock()
+	output, jsonFormat := l.settings.output, l.settings.format == LogFormatJSON
+	l.settings.mutex.RUnlock()
+
+	if !jsonFormat {
+		output.Print(l.formatMessage(level, message))
+		return
+	}
+
+	line, err := json.Marshal(logEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     strings.ToLower(level.String()),
+		Component: l.component,
+		Logger:    l.prefix,
+		Message:   message,
+	})
+	if err != nil {
+		output.Print(l.formatMessage(level, message))
+		return
+	}
+	output.Print(string(line))
+}
+
 // Debug logs a debug message
 func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.shouldLog(LogLevelDebug) {
-		message := fmt.Sprintf(format, args...)
-		l.logger.Print(l.formatMessage(LogLevelDebug, message))
-	}
+	l.write(LogLevelDebug, format, args)
 }
 
 // Info logs an info message
 func (l *Logger) Info(format string, args ...interface{}) {
-	if l.shouldLog(LogLevelInfo) {
-		message := fmt.Sprintf(format, args...)
-		l.logger.Print(l.formatMessage(LogLevelInfo, message))
-	}
+	l.write(LogLevelInfo, format, args)
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, args ...interface{}) {
-	if l.shouldLog(LogLevelWarn) {
-		message := fmt.Sprintf(format, args...)
-		l.logger.Print(l.formatMessage(LogLevelWarn, message))
-	}
+	l.write(LogLevelWarn, format, args)
 }
 
 // Error logs an error message
 func (l *Logger) Error(format string, args ...interface{}) {
-	if l.shouldLog(LogLevelError) {
-		message := fmt.Sprintf(format, args...)
-		l.logger.Print(l.formatMessage(LogLevelError, message))
-	}
+	l.write(LogLevelError, format, args)
 }
 
 // Debugf logs a debug message with formatting (alias for Debug)
@@ -158,9 +251,80 @@ func (l *Logger) Close() error {
 	return nil
 }
 
-// SetLevel sets the log level
+// SetLevel sets the default log level
 func (l *Logger) SetLevel(level string) {
-	l.level = parseLogLevel(level)
+	logLevel := parseLogLevel(level)
+	l.settings.mutex.Lock()
+	l.settings.level = logLevel
+	l.settings.mutex.Unlock()
+	l.notify(DefaultComponent, logLevel)
+}
+
+// SetComponentLevel sets the log level of a component at runtime. The component
+// "default" sets the default level; an empty level removes the component level
+// so the component follows the default again.
+func (l *Logger) SetComponentLevel(component, level string) error {
+	if component == "" {
+		return fmt.Errorf("component is required")
+	}
+
+	l.settings.mutex.Lock()
+	var logLevel LogLevel
+	switch {
+	case level == "" && component == DefaultComponent:
+		l.settings.mutex.Unlock()
+		return fmt.Errorf("the default level cannot be removed")
+	case level == "":
+		delete(l.settings.components, component)
+		logLevel = l.settings.level
+	default:
+		parsed, err := ParseLogLevel(level)
+		if err != nil {
+			l.settings.mutex.Unlock()
+			return err
+		}
+		logLevel = parsed
+		if component == DefaultComponent {
+			l.settings.level = parsed
+		} else {
+			l.settings.components[component] = parsed
+		}
+	}
+	l.settings.mutex.Unlock()
+
+	l.notify(component, logLevel)
+	return nil
+}
+
+// Levels returns the default level and the component levels by name, in lower case
+func (l *Logger) Levels() map[string]string {
+	l.settings.mutex.RLock()
+	defer l.settings.mutex.RUnlock()
+
+	levels := map[string]string{DefaultComponent: strings.ToLower(l.settings.level.String())}
+	for component, level := range l.settings.components {
+		levels[component] = strings.ToLower(level.String())
+	}
+	return levels
+}
+
+// OnLevelChange registers a function called after a level is changed with
+// SetLevel or SetComponentLevel, with the component and its effective level
+func (l *Logger) OnLevelChange(listener func(component string, level LogLevel)) {
+	l.settings.mutex.Lock()
+	defer l.settings.mutex.Unlock()
+	l.settings.listeners = append(l.settings.listeners, listener)
+}
+
+// notify calls the level change listeners
+func (l *Logger) notify(component string, level LogLevel) {
+	l.settings.mutex.RLock()
+	listeners := append([]func(string, LogLevel){}, l.settings.listeners...)
+	l.settings.mutex.RUnlock()
+
+	for _, listener := range listeners {
+		listener(component, level)
+	}
 }
 
 // IsDebug returns true if debug logging is enabled
@@ -178,10 +342,18 @@ func (l *Logger) WithPrefix(prefix string) *Logger {
 	}
 
 	return &Logger{
-		level:   l.level,
-		debug:   l.debug,
-		prefix:  newPrefix,
-		logger:  l.logger,
-		logFile: l.logFile,
+		settings:  l.settings,
+		debug:     l.debug,
+		prefix:    newPrefix,
+		component: l.component,
+		logFile:   l.logFile,
 	}
 }
+
+// Component creates a logger for a subsystem such as esi, propertymanager or server.
+// Its messages carry the component name and follow the component's level once one is set.
+func (l *Logger) Component(name string) *Logger {
+	logger := l.WithPrefix(name)
+	logger.component = name
+	return logger
+}
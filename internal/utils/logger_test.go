@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_JSONFormat(t *testing.T) {
+	var output bytes.Buffer
+	logger := NewLogger("info", false, "edge-emulator")
+	logger.SetOutput(&output)
+	require.NoError(t, logger.SetFormat("json"))
+	assert.Error(t, logger.SetFormat("xml"))
+
+	logger.Component("esi").Warn("include %s failed", "/header")
+	logger.Debug("not logged")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 1)
+
+	var entry map[string]string
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "esi", entry["component"])
+	assert.Equal(t, "edge-emulator.esi", entry["logger"])
+	assert.Equal(t, "include /header failed", entry["message"])
+	assert.NotEmpty(t, entry["time"])
+}
+
+func TestLogger_ComponentLevels(t *testing.T) {
+	logger := NewLogger("info", false, "test")
+	esiLogger := logger.Component("esi")
+	serverLogger := logger.Component("server")
+
+	var changes []string
+	logger.OnLevelChange(func(component string, level LogLevel) {
+		changes = append(changes, component+"="+level.String())
+	})
+
+	require.NoError(t, logger.SetComponentLevel("esi", "debug"))
+	assert.True(t, esiLogger.Enabled(LogLevelDebug))
+	assert.False(t, serverLogger.Enabled(LogLevelDebug))
+	assert.False(t, logger.Enabled(LogLevelDebug))
+
+	require.NoError(t, logger.SetComponentLevel("default", "error"))
+	assert.False(t, serverLogger.Enabled(LogLevelWarn), "components without a level follow the default")
+	assert.True(t, esiLogger.Enabled(LogLevelDebug))
+
+	require.NoError(t, logger.SetComponentLevel("esi", ""))
+	assert.False(t, esiLogger.Enabled(LogLevelWarn))
+	assert.Equal(t, map[string]string{"default": "error"}, logger.Levels())
+
+	assert.Error(t, logger.SetComponentLevel("esi", "verbose"))
+	assert.Error(t, logger.SetComponentLevel("default", ""))
+	assert.Equal(t, []string{"esi=DEBUG", "default=ERROR", "esi=ERROR"}, changes)
+}
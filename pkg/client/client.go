@@ -0,0 +1,609 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/history"
+	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+	"github.com/edge-computing/emulator-suite/pkg/server"
+)
+
+// Client is a typed HTTP client for the emulator API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	session    string // Sent as the server.SessionHeader of every request, when set
+	gzip       bool   // Request bodies are sent gzip-compressed
+}
+
+// APIError is returned when the emulator responds with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Response   server.ErrorResponse
+}
+
+func (e *APIError) Error() string {
+	if e.Response.Message != "" {
+		return fmt.Sprintf("emulator error (HTTP %d): %s: %s", e.StatusCode, e.Response.Error, e.Response.Message)
+	}
+	return fmt.Sprintf("emulator error (HTTP %d): %s", e.StatusCode, e.Response.Error)
+}
+
+// New creates a new client for the emulator at baseURL (e.g. http://localhost:3000).
+// Redirects returned by the emulator are reported rather than followed.
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client
+func (c *Client) WithHTTPClient(httpClient *http.Client) *Client {
+	c.httpClient = httpClient
+	return c
+}
+
+// WithSession returns a copy of the client whose requests belong to the session id, so
+// the cookies and variables of each page carry over to the next
+func (c *Client) WithSession(id string) *Client {
+	clone := *c
+	clone.session = id
+	return &clone
+}
+
+// WithGzip returns a copy of the client that sends request bodies gzip-compressed, as
+// for multi-megabyte templates
+func (c *Client) WithGzip() *Client {
+	clone := *c
+	clone.gzip = true
+	return &clone
+}
+
+// Process sends ESI content to POST /process
+func (c *Client) Process(html string, context *esi.ProcessContext) (*server.ProcessResponse, error) {
+	var resp server.ProcessResponse
+	req := server.ProcessRequest{HTML: html, Context: context}
+	if err := c.doJSON(http.MethodPost, "/process", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ProcessByName processes the template referenced as name@version, or as name for
+// the pinned or latest version, with POST /process/by-name
+func (c *Client) ProcessByName(template string, context *esi.ProcessContext) (*server.ProcessResponse, error) {
+	var resp server.ProcessResponse
+	req := server.ProcessByNameRequest{Template: template, Context: context}
+	if err := c.doJSON(http.MethodPost, "/process/by-name", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RawResponse is the HTTP response of POST /process?raw=true
+type RawResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+// ProcessRaw sends ESI content to POST /process?raw=true and returns the raw HTTP response.
+// Redirects set by ESI built-ins are returned rather than followed.
+func (c *Client) ProcessRaw(html string, context *esi.ProcessContext) (*RawResponse, error) {
+	data, err := json.Marshal(server.ProcessRequest{HTML: html, Context: context})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/process?raw=true", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.doRaw(req)
+}
+
+// ProcessDocument sends a document to POST /process with contentType, which may name
+// the document's charset. The processed document is returned raw, encoded in the
+// document's own charset; documents of media types the server does not process come
+// back untouched.
+func (c *Client) ProcessDocument(document []byte, contentType string) (*RawResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/process", bytes.NewReader(document))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.doRaw(req)
+}
+
+// ProcessJSON sends a JSON document to POST /process/json and returns the assembled
+// document raw
+func (c *Client) ProcessJSON(document []byte) (*RawResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/process/json", bytes.NewReader(document))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.doRaw(req)
+}
+
+// Degradation sends ESI content to POST /process/degradation and returns the processed
+// output beside the output of a CDN without ESI
+func (c *Client) Degradation(html string, context *esi.ProcessContext) (*esi.DegradationReport, error) {
+	var report esi.DegradationReport
+	req := server.ProcessRequest{HTML: html, Context: context}
+	if err := c.doJSON(http.MethodPost, "/process/degradation", req, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// doRaw sends a POST /process request and returns the raw HTTP response without
+// following redirects
+func (c *Client) doRaw(req *http.Request) (*RawResponse, error) {
+	httpClient := *c.httpClient
+	httpClient.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	if c.session != "" {
+		req.Header.Set(server.SessionHeader, c.session)
+	}
+	if err := c.compress(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call POST %s: %w", req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return &RawResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       string(body),
+	}, nil
+}
+
+// ProcessPropertyManager sends rules and a context to POST /property-manager/process
+func (c *Client) ProcessPropertyManager(rules []propertymanager.Rule, context *propertymanager.HTTPContext) (*server.PropertyManagerResponse, error) {
+	var resp server.PropertyManagerResponse
+	req := server.PropertyManagerRequest{Rules: rules, Context: context}
+	if err := c.doJSON(http.MethodPost, "/property-manager/process", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ProcessIntegrated sends HTML and a context to POST /integrated/process
+func (c *Client) ProcessIntegrated(html string, context *propertymanager.HTTPContext) (*server.IntegratedProcessResponse, error) {
+	return c.ProcessIntegratedWithHeaders(html, nil, context)
+}
+
+// ProcessIntegratedWithHeaders runs a request through the integrated workflow with the
+// headers the origin sent with html, such as Content-Type and Edge-Control
+func (c *Client) ProcessIntegratedWithHeaders(html string, originHeaders map[string]string, context *propertymanager.HTTPContext) (*server.IntegratedProcessResponse, error) {
+	var resp server.IntegratedProcessResponse
+	req := server.IntegratedProcessRequest{HTML: html, Context: context, OriginHeaders: originHeaders}
+	if err := c.doJSON(http.MethodPost, "/integrated/process", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ProcessFastly sends a request to POST /fastly/process, running it through the
+// server's Fastly VCL and ESI
+func (c *Client) ProcessFastly(req server.FastlyProcessRequest) (*server.FastlyProcessResponse, error) {
+	var resp server.FastlyProcessResponse
+	if err := c.doJSON(http.MethodPost, "/fastly/process", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Info returns the server information from GET /
+func (c *Client) Info() (map[string]interface{}, error) {
+	var resp map[string]interface{}
+	if err := c.doJSON(http.MethodGet, "/", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Stats returns processing statistics from GET /stats
+func (c *Client) Stats() (map[string]interface{}, error) {
+	var resp map[string]interface{}
+	if err := c.doJSON(http.MethodGet, "/stats", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ResetStats zeroes the processing statistics via POST /stats/reset and returns the
+// totals collected up to the reset
+func (c *Client) ResetStats() (map[string]interface{}, error) {
+	var resp map[string]interface{}
+	if err := c.doJSON(http.MethodPost, "/stats/reset", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ClearCache clears the fragment cache via DELETE /cache
+func (c *Client) ClearCache() (map[string]interface{}, error) {
+	var resp map[string]interface{}
+	if err := c.doJSON(http.MethodDelete, "/cache", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Live returns the liveness status from GET /livez
+func (c *Client) Live() (map[string]interface{}, error) {
+	var resp map[string]interface{}
+	if err := c.doJSON(http.MethodGet, "/livez", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Health returns the component readiness from GET /health; an APIError with
+// status 503 is returned while a component is not ready
+func (c *Client) Health() (map[string]interface{}, error) {
+	var resp map[string]interface{}
+	if err := c.doJSON(http.MethodGet, "/health", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Metrics returns the per-route HTTP metrics from GET /metrics?format=json
+func (c *Client) Metrics() ([]server.RouteMetricsSnapshot, error) {
+	var resp struct {
+		Routes []server.RouteMetricsSnapshot `json:"routes"`
+	}
+	if err := c.doJSON(http.MethodGet, "/metrics?format=json", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Routes, nil
+}
+
+// Frequency returns the beacon fire counts from GET /frequency
+func (c *Client) Frequency() ([]server.FrequencyStats, error) {
+	var resp struct {
+		Pixels []server.FrequencyStats `json:"pixels"`
+	}
+	if err := c.doJSON(http.MethodGet, "/frequency", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Pixels, nil
+}
+
+// ResetFrequency clears the beacon fire counts with DELETE /frequency
+func (c *Client) ResetFrequency() error {
+	var resp map[string]interface{}
+	return c.doJSON(http.MethodDelete, "/frequency", nil, &resp)
+}
+
+// SinkCaptures returns the requests captured by the beacon sink that match filter,
+// oldest first, from GET /sink/captures
+func (c *Client) SinkCaptures(filter server.SinkFilter) ([]server.SinkCapture, error) {
+	query := url.Values{}
+	if filter.Method != "" {
+		query.Set("method", filter.Method)
+	}
+	if filter.Path != "" {
+		query.Set("path", filter.Path)
+	}
+	if filter.Contains != "" {
+		query.Set("contains", filter.Contains)
+	}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339Nano))
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	path := "/sink/captures"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var resp server.SinkCapturesResponse
+	if err := c.doJSON(http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Captures, nil
+}
+
+// History returns the recorded processing runs that match filter, newest first, and
+// their summaries, from GET /history
+func (c *Client) History(filter history.Filter) (*server.HistoryResponse, error) {
+	query := url.Values{}
+	if filter.Version != "" {
+		query.Set("version", filter.Version)
+	}
+	if filter.Mode != "" {
+		query.Set("mode", filter.Mode)
+	}
+	if filter.TemplateHash != "" {
+		query.Set("template", filter.TemplateHash)
+	}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339Nano))
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	path := "/history"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var resp server.HistoryResponse
+	if err := c.doJSON(http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AssertSink checks expectations against the beacon sink captures with POST /sink/assert
+func (c *Client) AssertSink(req server.SinkAssertRequest) (*server.SinkAssertResult, error) {
+	var resp server.SinkAssertResult
+	if err := c.doJSON(http.MethodPost, "/sink/assert", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ClearSink drops the beacon sink captures with DELETE /sink/captures
+func (c *Client) ClearSink() error {
+	var resp map[string]interface{}
+	return c.doJSON(http.MethodDelete, "/sink/captures", nil, &resp)
+}
+
+// Sessions returns the sessions kept by the server, by ID, from GET /sessions
+func (c *Client) Sessions() ([]server.Session, error) {
+	var resp []server.Session
+	if err := c.doJSON(http.MethodGet, "/sessions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Session returns a session's cookies and variables from GET /sessions/:id
+func (c *Client) Session(id string) (*server.Session, error) {
+	var resp server.Session
+	if err := c.doJSON(http.MethodGet, "/sessions/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PutSession replaces a session's cookies and variables with PUT /sessions/:id
+func (c *Client) PutSession(id string, update server.SessionUpdate) (*server.Session, error) {
+	var resp server.Session
+	if err := c.doJSON(http.MethodPut, "/sessions/"+url.PathEscape(id), update, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteSession drops a session with DELETE /sessions/:id
+func (c *Client) DeleteSession(id string) error {
+	var resp map[string]interface{}
+	return c.doJSON(http.MethodDelete, "/sessions/"+url.PathEscape(id), nil, &resp)
+}
+
+// LogLevels returns the default and per-component log levels from GET /admin/log-levels
+func (c *Client) LogLevels() (map[string]string, error) {
+	var resp struct {
+		Levels map[string]string `json:"levels"`
+	}
+	if err := c.doJSON(http.MethodGet, "/admin/log-levels", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Levels, nil
+}
+
+// SetLogLevel changes a component's log level with PUT /admin/log-levels and returns
+// the updated levels; an empty level makes the component follow the default again
+func (c *Client) SetLogLevel(component, level string) (map[string]string, error) {
+	var resp struct {
+		Levels map[string]string `json:"levels"`
+	}
+	req := server.LogLevelRequest{Component: component, Level: level}
+	if err := c.doJSON(http.MethodPut, "/admin/log-levels", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Levels, nil
+}
+
+// CacheEntries returns a page of the cached fragments from GET /cache/entries; a zero
+// limit selects the server's default page size
+func (c *Client) CacheEntries(offset, limit int) (*server.CacheEntriesResponse, error) {
+	query := url.Values{}
+	query.Set("offset", strconv.Itoa(offset))
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var resp server.CacheEntriesResponse
+	if err := c.doJSON(http.MethodGet, "/cache/entries?"+query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CacheEntry returns the fragment cached under key, its fragment URL, from
+// GET /cache/entries/:key
+func (c *Client) CacheEntry(key string) (*server.CacheEntryResponse, error) {
+	var resp server.CacheEntryResponse
+	if err := c.doJSON(http.MethodGet, "/cache/entries/"+url.PathEscape(key), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Purge invalidates cached fragments by URL or tag with POST /cache/purge
+func (c *Client) Purge(req esi.PurgeRequest) (*esi.PurgeResult, error) {
+	var resp esi.PurgeResult
+	if err := c.doJSON(http.MethodPost, "/cache/purge", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Faults returns the fault injection rules of include fetches from GET /admin/faults
+func (c *Client) Faults() ([]esi.FaultRule, error) {
+	var resp server.FaultsRequest
+	if err := c.doJSON(http.MethodGet, "/admin/faults", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Rules, nil
+}
+
+// SetFaults replaces the fault injection rules with PUT /admin/faults and returns
+// them; no rules turn fault injection off
+func (c *Client) SetFaults(rules []esi.FaultRule) ([]esi.FaultRule, error) {
+	var resp server.FaultsRequest
+	req := server.FaultsRequest{Rules: rules}
+	if err := c.doJSON(http.MethodPut, "/admin/faults", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Rules, nil
+}
+
+// OpenAPI returns the OpenAPI document from GET /openapi.json
+func (c *Client) OpenAPI() (map[string]interface{}, error) {
+	var resp map[string]interface{}
+	if err := c.doJSON(http.MethodGet, "/openapi.json", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Example returns a single example from GET /examples/:name
+func (c *Client) Example(name string) (*server.Example, error) {
+	var resp server.Example
+	if err := c.doJSON(http.MethodGet, "/examples/"+url.PathEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Fragment returns the raw HTML of a test fragment from GET /fragments/:name
+func (c *Client) Fragment(name string) (string, error) {
+	resp, err := c.do(http.MethodGet, "/fragments/"+url.PathEscape(name), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return string(body), nil
+}
+
+// doJSON performs a request with an optional JSON body and decodes the JSON response into out
+func (c *Client) doJSON(method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := c.do(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// do performs a request and converts non-2xx responses into an *APIError
+func (c *Client) do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.session != "" {
+		req.Header.Set(server.SessionHeader, c.session)
+	}
+	if err := c.compress(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s %s: %w", method, path, err)
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr.Response); err != nil || apiErr.Response.Error == "" {
+			apiErr.Response.Error = resp.Status
+		}
+		return nil, apiErr
+	}
+
+	return resp, nil
+}
+
+// compress gzips the body of req when the client sends compressed bodies
+func (c *Client) compress(req *http.Request) error {
+	if !c.gzip || req.Body == nil {
+		return nil
+	}
+	defer req.Body.Close()
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := io.Copy(writer, req.Body); err != nil {
+		return fmt.Errorf("failed to compress request: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress request: %w", err)
+	}
+
+	req.Body = io.NopCloser(&compressed)
+	req.ContentLength = int64(compressed.Len())
+	req.GetBody = nil
+	req.Header.Set("Content-Encoding", "gzip")
+	return nil
+}
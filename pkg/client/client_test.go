@@ -0,0 +1,1237 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/internal/utils"
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/fastly"
+	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+	"github.com/edge-computing/emulator-suite/pkg/server"
+	"github.com/edge-computing/emulator-suite/pkg/templates"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	srv := server.New(server.Config{Port: 0, Mode: "esi"},
+		server.WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})))
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClient_Process(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	resp, err := c.Process(`<p>Hello</p><esi:remove>gone</esi:remove>`, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Result, "<p>Hello</p>")
+	assert.NotContains(t, resp.Result, "gone")
+	assert.Equal(t, "esi", resp.Stats.Mode)
+}
+
+func TestClient_ProcessContextData(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	resp, err := c.Process(`<esi:vars><p>$(CTX{segment})/$(CTX{flags.newNav})</p></esi:vars>`, &esi.ProcessContext{
+		Data: map[string]interface{}{"segment": "vip", "flags": map[string]interface{}{"newNav": true}},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, resp.Result, "<p>vip/true</p>")
+}
+
+func TestClient_ProcessRaw(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	resp, err := c.ProcessRaw(`<p>Hello</p>`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	assert.Contains(t, resp.Body, "<p>Hello</p>")
+
+	resp, err = c.ProcessRaw(`<esi:function name="add_header" header="X-Test" value="yes"></esi:function>`+
+		`<esi:function name="set_redirect" location="/login" code="301"></esi:function>`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "/login", resp.Header.Get("Location"))
+	assert.Equal(t, "yes", resp.Header.Get("X-Test"))
+
+	resp, err = c.ProcessRaw(`<esi:function name="add_header" header="Set-Cookie" value="a=1"></esi:function>`+
+		`<esi:function name="add_header" header="set-cookie" value="b=2"></esi:function>`+
+		`<esi:function name="set_response_code" code="700"></esi:function>`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+}
+
+func TestClient_ErrorPage(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer origin.Close()
+
+	ts := newTestServer(t)
+	c := New(ts.URL)
+	page := `<p>Cart</p><esi:include src="` + origin.URL + `/cart" required="true" />`
+
+	resp, err := c.Process(page, nil)
+	require.NoError(t, err)
+	assert.Equal(t, esi.DefaultErrorPageBody, resp.Result)
+	assert.Equal(t, "required include "+origin.URL+"/cart failed", resp.ErrorPage)
+
+	raw, err := c.ProcessRaw(page, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, raw.StatusCode)
+	assert.Equal(t, "required include "+origin.URL+"/cart failed", raw.Header.Get("X-ESI-Error-Page"))
+}
+
+func TestClient_Degradation(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	report, err := c.Degradation("<p>Hello</p>\n<esi:remove><a href=\"/fallback\">Fallback</a></esi:remove>", nil)
+	require.NoError(t, err)
+	assert.NotContains(t, report.Processed, "Fallback")
+	assert.Contains(t, report.Unprocessed, `<a href="/fallback">Fallback</a>`)
+	assert.Contains(t, report.Diff, "+++ unprocessed")
+	require.Len(t, report.Notes, 1)
+	assert.Equal(t, "esi:remove", report.Notes[0].Feature)
+	assert.Equal(t, 2, report.Notes[0].Line)
+}
+
+func TestClient_IntegratedRedirectAndDeny(t *testing.T) {
+	pm := propertymanager.NewPropertyManager(false)
+	pm.Property = &propertymanager.Property{
+		Rules: propertymanager.Rules{Rule: []propertymanager.Rule{
+			{
+				Name:     "legacy",
+				Criteria: []propertymanager.Criterion{{Name: "path", Option: "equals", Value: "/old"}},
+				Behaviors: []propertymanager.Behavior{{Name: "redirect", Option: []propertymanager.BehaviorOption{
+					{Name: "destination", Value: "/new"},
+					{Name: "status_code", Value: "301"},
+				}}},
+			},
+			{
+				Name:     "admin",
+				Criteria: []propertymanager.Criterion{{Name: "path", Option: "starts_with", Value: "/admin"}},
+				Behaviors: []propertymanager.Behavior{
+					{Name: "access_control", Options: map[string]interface{}{"allowed_ips": "10.0.0.1"}},
+				},
+			},
+		}},
+	}
+
+	srv := server.New(server.Config{Mode: "integrated"},
+		server.WithIntegrated(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}), pm))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	c := New(ts.URL)
+
+	_, err := c.ProcessIntegrated("<p>page</p>", &propertymanager.HTTPContext{Method: "GET", Path: "/old"})
+	require.Error(t, err)
+	apiErr, ok := err.(*APIError)
+	require.True(t, ok)
+	assert.Equal(t, 301, apiErr.StatusCode)
+
+	_, err = c.ProcessIntegrated("<p>page</p>", &propertymanager.HTTPContext{Method: "GET", Path: "/admin/panel"})
+	require.Error(t, err)
+	apiErr, ok = err.(*APIError)
+	require.True(t, ok)
+	assert.Equal(t, 403, apiErr.StatusCode)
+
+	resp, err := c.ProcessIntegrated("<p>page</p>", &propertymanager.HTTPContext{Method: "GET", Path: "/home"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>page</p>", resp.ProcessedHTML)
+}
+
+func TestClient_IntegratedContentRewrite(t *testing.T) {
+	pm := propertymanager.NewPropertyManager(false)
+	pm.Property = &propertymanager.Property{
+		Rules: propertymanager.Rules{Rule: []propertymanager.Rule{{
+			Name: "links",
+			Behaviors: []propertymanager.Behavior{{Name: "rewrite_content", Option: []propertymanager.BehaviorOption{
+				{Name: "find", Value: "www.example.com"},
+				{Name: "replace", Value: "$(HTTP_HOST)"},
+			}}},
+		}}},
+	}
+
+	srv := server.New(server.Config{Mode: "integrated"},
+		server.WithIntegrated(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}), pm))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := New(ts.URL).ProcessIntegrated(`<a href="https://www.example.com/">home</a>`,
+		&propertymanager.HTTPContext{Method: "GET", Path: "/", Host: "staging.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, `<a href="https://staging.example.com/">home</a>`, resp.ProcessedHTML)
+	assert.Equal(t, 1, resp.ResponseResult.ContentReplacements)
+}
+
+func TestClient_IntegratedConstructedResponse(t *testing.T) {
+	pm := propertymanager.NewPropertyManager(false)
+	pm.Property = &propertymanager.Property{
+		Rules: propertymanager.Rules{Rule: []propertymanager.Rule{{
+			Name:     "maintenance",
+			Criteria: []propertymanager.Criterion{{Name: "path", Option: "starts_with", Value: "/shop"}},
+			Behaviors: []propertymanager.Behavior{{Name: "construct_response", Option: []propertymanager.BehaviorOption{
+				{Name: "status_code", Value: "503"},
+				{Name: "body", Value: "<h1>Down for maintenance: $(HTTP_PATH)</h1>"},
+				{Name: "header", Value: "Retry-After: 120"},
+			}}},
+		}}},
+	}
+
+	srv := server.New(server.Config{Mode: "integrated"},
+		server.WithIntegrated(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}), pm))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	body := `{"html": "<p>page</p>", "context": {"Method": "GET", "Path": "/shop/cart"}}`
+	resp, err := http.Post(ts.URL+"/integrated/process", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, "120", resp.Header.Get("Retry-After"))
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	assert.Equal(t, "<h1>Down for maintenance: /shop/cart</h1>", string(data))
+}
+
+func TestClient_IntegratedDownstreamCache(t *testing.T) {
+	pm := propertymanager.NewPropertyManager(false)
+	pm.Property = &propertymanager.Property{
+		Rules: propertymanager.Rules{Rule: []propertymanager.Rule{{
+			Name: "no-client-cache",
+			Behaviors: []propertymanager.Behavior{
+				{Name: "cache", Options: map[string]interface{}{"ttl": "3600"}},
+				{Name: "downstream_cache", Option: []propertymanager.BehaviorOption{{Name: "behavior", Value: "bust"}}},
+			},
+		}}},
+	}
+
+	srv := server.New(server.Config{Mode: "integrated"},
+		server.WithIntegrated(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}), pm))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := New(ts.URL).ProcessIntegrated("<p>page</p>", &propertymanager.HTTPContext{Method: "GET", Path: "/"})
+	require.NoError(t, err)
+	assert.Equal(t, "no-store, no-cache, max-age=0", resp.ResponseResult.ModifiedHeaders["Cache-Control"])
+	assert.Equal(t, "Thu, 01 Jan 1970 00:00:00 GMT", resp.ResponseResult.ModifiedHeaders["Expires"])
+	assert.Empty(t, resp.PropertyManagerResult.ModifiedHeaders["Cache-Control"])
+}
+
+func TestClient_IntegratedCacheAndCompression(t *testing.T) {
+	fetches := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+		w.Write([]byte("<p>fragment</p>"))
+	}))
+	defer origin.Close()
+
+	pm := propertymanager.NewPropertyManager(false)
+	pm.Property = &propertymanager.Property{
+		Rules: propertymanager.Rules{Rule: []propertymanager.Rule{{
+			Name: "private",
+			Behaviors: []propertymanager.Behavior{
+				{Name: "esi"},
+				{Name: "cache", Option: []propertymanager.BehaviorOption{{Name: "behavior", Value: "no_store"}}},
+				{Name: "gzip_response", Option: []propertymanager.BehaviorOption{{Name: "enabled", Value: "true"}}},
+			},
+		}}},
+	}
+
+	processor := esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5,
+		Cache: esi.CacheConfig{Enabled: true, TTL: 60}})
+	srv := server.New(server.Config{Mode: "integrated"}, server.WithIntegrated(processor, pm))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	html := `<esi:include src="` + origin.URL + `/fragment"/>`
+	for _, acceptEncoding := range []string{"gzip", "identity"} {
+		resp, err := New(ts.URL).ProcessIntegrated(html, &propertymanager.HTTPContext{
+			Method:  "GET",
+			Path:    "/",
+			Headers: map[string]string{"Accept-Encoding": acceptEncoding},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, resp.ProcessedHTML, "<p>fragment</p>")
+		assert.Equal(t, "Accept-Encoding", resp.ResponseResult.ModifiedHeaders["Vary"])
+		if acceptEncoding == "gzip" {
+			assert.Equal(t, "gzip", resp.ResponseResult.ModifiedHeaders["Content-Encoding"])
+		} else {
+			assert.Empty(t, resp.ResponseResult.ModifiedHeaders["Content-Encoding"])
+		}
+	}
+
+	// The no-store property kept the fragment out of the ESI cache
+	assert.Equal(t, 2, fetches)
+	assert.Equal(t, 0, processor.GetCacheSize())
+}
+
+func TestClient_IntegratedESIContentType(t *testing.T) {
+	pm := propertymanager.NewPropertyManager(false)
+	pm.Property = &propertymanager.Property{
+		Rules: propertymanager.Rules{Rule: []propertymanager.Rule{{
+			Name:      "esi",
+			Behaviors: []propertymanager.Behavior{{Name: "esi", Option: []propertymanager.BehaviorOption{{Name: "enable_via_http", Value: "true"}}}},
+		}}},
+	}
+
+	srv := server.New(server.Config{Mode: "integrated"},
+		server.WithIntegrated(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}), pm))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	c := New(ts.URL)
+	html := `<p>kept</p><esi:remove>removed</esi:remove>`
+	context := &propertymanager.HTTPContext{Method: "GET", Path: "/"}
+
+	resp, err := c.ProcessIntegratedWithHeaders(html, map[string]string{"Content-Type": "text/html", "Edge-Control": "dca=esi"}, context)
+	require.NoError(t, err)
+	assert.True(t, resp.ESIEnabled)
+	assert.NotContains(t, resp.ProcessedHTML, "removed")
+
+	resp, err = c.ProcessIntegratedWithHeaders(html, map[string]string{"Content-Type": "application/json", "Edge-Control": "dca=esi"}, context)
+	require.NoError(t, err)
+	assert.False(t, resp.ESIEnabled)
+	assert.Contains(t, resp.ESISkipReason, "application/json")
+	assert.Equal(t, html, resp.ProcessedHTML)
+
+	resp, err = c.ProcessIntegrated(html, context)
+	require.NoError(t, err)
+	assert.False(t, resp.ESIEnabled)
+	assert.Contains(t, resp.ESISkipReason, "Edge-Control")
+}
+
+func TestClient_ExampleAndFragment(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	example, err := c.Example("basic-include")
+	require.NoError(t, err)
+	assert.Equal(t, "Basic Include", example.Name)
+
+	fragment, err := c.Fragment("header")
+	require.NoError(t, err)
+	assert.Contains(t, fragment, "Dynamic Header Content")
+
+	// The served time is filled into fragments
+	fragment, err = c.Fragment("content")
+	require.NoError(t, err)
+	assert.Contains(t, fragment, "Generated at: 2")
+	assert.NotContains(t, fragment, "{{now}}")
+}
+
+func TestClient_APIError(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	_, err := c.Example("does-not-exist")
+	require.Error(t, err)
+
+	apiErr, ok := err.(*APIError)
+	require.True(t, ok)
+	assert.Equal(t, 404, apiErr.StatusCode)
+	assert.Equal(t, "Example not found", apiErr.Response.Error)
+}
+
+func TestClient_OpenAPI(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	spec, err := c.OpenAPI()
+	require.NoError(t, err)
+	assert.Equal(t, server.OpenAPIVersion, spec["openapi"])
+
+	paths, ok := spec["paths"].(map[string]interface{})
+	require.True(t, ok)
+	for _, path := range []string{"/process", "/property-manager/process", "/integrated/process", "/stats", "/health"} {
+		assert.Contains(t, paths, path)
+	}
+}
+
+func TestClient_Metrics(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	_, err := c.Process(`<p>Hello</p>`, nil)
+	require.NoError(t, err)
+	_, err = c.Example("does-not-exist")
+	require.Error(t, err)
+
+	routes, err := c.Metrics()
+	require.NoError(t, err)
+
+	byRoute := make(map[string]server.RouteMetricsSnapshot)
+	for _, route := range routes {
+		byRoute[route.Method+" "+route.Route] = route
+	}
+
+	process := byRoute["POST /process"]
+	assert.Equal(t, int64(1), process.Requests)
+	assert.Equal(t, "esi", process.Mode)
+	assert.Equal(t, int64(1), process.StatusCodes["200"])
+	assert.Equal(t, int64(1), process.Buckets["+Inf"])
+
+	example := byRoute["GET /examples/:name"]
+	assert.Equal(t, int64(1), example.StatusCodes["404"])
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `emulator_http_requests_total{method="POST",route="/process",mode="esi",status="200"} 1`)
+	assert.Contains(t, string(body), `emulator_http_request_duration_seconds_count{method="POST",route="/process",mode="esi"} 1`)
+}
+
+func TestClient_BodySizeLimit(t *testing.T) {
+	srv := server.New(server.Config{Mode: "esi", MaxBodySize: 64})
+	srv.SetESIProcessor(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	c := New(ts.URL)
+
+	_, err := c.Process("<p>small</p>", nil)
+	require.NoError(t, err)
+
+	_, err = c.Process(strings.Repeat("x", 128), nil)
+	require.Error(t, err)
+	apiErr, ok := err.(*APIError)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, apiErr.StatusCode)
+	assert.Equal(t, "Request too large", apiErr.Response.Error)
+
+	// Only streaming /process requests are limited per line; an NDJSON content type
+	// does not lift the limit elsewhere
+	for _, path := range []string{"/property-manager/process", "/integrated/process", "/sessions/s1"} {
+		method := http.MethodPost
+		if strings.HasPrefix(path, "/sessions") {
+			method = http.MethodPut
+		}
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(`{"html":"`+strings.Repeat("x", 128)+`"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, path)
+	}
+}
+
+func TestClient_GzipRequestBodies(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL).WithGzip()
+
+	template := `<p>Hello</p><esi:remove>gone</esi:remove>` + strings.Repeat("<p>filler</p>", 10000)
+	resp, err := c.Process(template, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Result, "<p>Hello</p>")
+	assert.NotContains(t, resp.Result, "gone")
+
+	raw, err := c.ProcessDocument([]byte(`<p>Doc</p><esi:remove>gone</esi:remove>`), "text/html; charset=utf-8")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, raw.StatusCode)
+	assert.Contains(t, raw.Body, "<p>Doc</p>")
+
+	// Other codings are rejected, and corrupt gzip bodies are invalid
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/process", strings.NewReader(`{"html":"<p>x</p>"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "br")
+	httpResp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, httpResp.StatusCode)
+	assert.Equal(t, "gzip", httpResp.Header.Get("Accept-Encoding"))
+
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Body = io.NopCloser(strings.NewReader(`{"html":"<p>x</p>"}`))
+	httpResp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, httpResp.StatusCode)
+}
+
+func TestClient_GzipBodySizeLimit(t *testing.T) {
+	srv := server.New(server.Config{Mode: "esi", MaxBodySize: 1024})
+	srv.SetESIProcessor(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// The compressed body fits the limit, the decompressed one does not
+	_, err := New(ts.URL).WithGzip().Process(strings.Repeat("x", 4096), nil)
+	require.Error(t, err)
+	apiErr, ok := err.(*APIError)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, apiErr.StatusCode)
+
+	// Compressed NDJSON keeps the limit outside the streaming /process handler
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write([]byte(`{"html":"` + strings.Repeat("x", 4096) + `"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/process/json", &compressed)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestClient_ProcessStream(t *testing.T) {
+	srv := server.New(server.Config{Mode: "esi", MaxBodySize: 64})
+	srv.SetESIProcessor(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// The whole stream exceeds MaxBodySize; only individual lines are limited
+	body := `{"html":"<p>one</p><esi:remove>x</esi:remove>"}` + "\n" +
+		`not json` + "\n" +
+		`{"html":"<p>three</p>"}` + "\n" +
+		`{"html":"` + strings.Repeat("x", 128) + `"}` + "\n"
+
+	resp, err := http.Post(ts.URL+"/process", "application/x-ndjson", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	var results []server.StreamResult
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		var result server.StreamResult
+		require.NoError(t, decoder.Decode(&result))
+		results = append(results, result)
+	}
+
+	require.Len(t, results, 4)
+	assert.Contains(t, results[0].Result, "<p>one</p>")
+	assert.NotContains(t, results[0].Result, "x")
+	assert.Contains(t, results[1].Error, "invalid request")
+	assert.Contains(t, results[2].Result, "<p>three</p>")
+	assert.Equal(t, 4, results[3].Line)
+	assert.Contains(t, results[3].Error, "exceeds the limit")
+}
+
+func TestClient_ExampleLibrary(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "examples"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "fragments"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "examples", "product.html"), []byte(
+		"---\nname: Product Page\ndescription: Team product page\nmodes: [akamai, w3c]\n---\n<esi:include src=\"/fragments/price\" />\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fragments", "price.html"), []byte("<span>$9.99</span>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "examples", "broken.html"), []byte("---\nname: Broken\n"), 0o644))
+
+	library, err := server.NewLibrary(dir)
+	require.NoError(t, err)
+	require.Len(t, library.Errors(), 1)
+
+	srv := server.New(server.Config{Mode: "esi"}, server.WithLibrary(library))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	c := New(ts.URL)
+
+	example, err := c.Example("product")
+	require.NoError(t, err)
+	assert.Equal(t, "Product Page", example.Name)
+	assert.Equal(t, "Team product page", example.Description)
+	assert.Equal(t, []string{"akamai", "w3c"}, example.Modes)
+	assert.Equal(t, "<esi:include src=\"/fragments/price\" />\n", example.HTML)
+
+	fragment, err := c.Fragment("price")
+	require.NoError(t, err)
+	assert.Equal(t, "<span>$9.99</span>", fragment)
+
+	// Built-in content remains available
+	_, err = c.Example("basic-include")
+	require.NoError(t, err)
+
+	// Changes on disk are picked up without a restart
+	time.Sleep(1100 * time.Millisecond)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fragments", "price.html"), []byte("<span>$7.99</span>"), 0o644))
+	fragment, err = c.Fragment("price")
+	require.NoError(t, err)
+	assert.Equal(t, "<span>$7.99</span>", fragment)
+
+	_, err = server.NewLibrary(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestClient_BeaconSink(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	// A fire-and-forget include reaches the sink while the page is processed
+	resp, err := c.Process(`<p>Page</p><esi:include src="`+ts.URL+`/sink/partner/pixel?campaign=spring" onerror="continue"/>`, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Result, "<p>Page</p>")
+
+	before := time.Now()
+	post, err := http.Post(ts.URL+"/sink/collect", "application/json", strings.NewReader(`{"event":"view"}`))
+	require.NoError(t, err)
+	post.Body.Close()
+	assert.Equal(t, http.StatusNoContent, post.StatusCode)
+
+	captures, err := c.SinkCaptures(server.SinkFilter{})
+	require.NoError(t, err)
+	require.Len(t, captures, 2)
+	assert.Equal(t, http.MethodGet, captures[0].Method)
+	assert.Equal(t, "/partner/pixel", captures[0].Path)
+	assert.Equal(t, "/sink/partner/pixel?campaign=spring", captures[0].URL)
+	assert.NotEmpty(t, captures[0].Headers["X-Request-Id"])
+	assert.Equal(t, `{"event":"view"}`, captures[1].Body)
+	assert.Equal(t, "application/json", captures[1].Headers["Content-Type"])
+	assert.Less(t, captures[0].ID, captures[1].ID)
+
+	captures, err = c.SinkCaptures(server.SinkFilter{Method: "post"})
+	require.NoError(t, err)
+	require.Len(t, captures, 1)
+	assert.Equal(t, "/collect", captures[0].Path)
+
+	captures, err = c.SinkCaptures(server.SinkFilter{Path: "/partner", Contains: "campaign=spring"})
+	require.NoError(t, err)
+	assert.Len(t, captures, 1)
+	captures, err = c.SinkCaptures(server.SinkFilter{Contains: `"view"`, Since: before})
+	require.NoError(t, err)
+	assert.Len(t, captures, 1)
+	captures, err = c.SinkCaptures(server.SinkFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, captures, 1)
+	assert.Equal(t, "/collect", captures[0].Path, "limit keeps the most recent")
+
+	_, err = c.do(http.MethodGet, "/sink/captures?limit=-1", nil)
+	assert.ErrorContains(t, err, "limit must be a non-negative integer")
+
+	require.NoError(t, c.ClearSink())
+	captures, err = c.SinkCaptures(server.SinkFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, captures)
+}
+
+func TestClient_AssertSink(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	userHash, err := esi.HashCookie("user-42", "salt", "hpr", esi.HashSHA256)
+	require.NoError(t, err)
+	for _, path := range []string{
+		"/sink/partner/view?campaign=spring&uid=" + userHash,
+		"/sink/partner/click?campaign=spring",
+		"/sink/partner/view?campaign=summer",
+	} {
+		resp, err := http.Get(ts.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	two, one := 2, 1
+	result, err := c.AssertSink(server.SinkAssertRequest{
+		Ordered: true,
+		Expectations: []server.SinkExpectation{
+			{Name: "views", URL: `^/sink/partner/view\?`, Count: &two},
+			{Name: "hashed user", Method: "GET", Params: map[string]server.ParamExpectation{
+				"campaign": {Equals: "spring"},
+				"uid":      {Hashed: "user-42", Salt: "salt", HashType: "hpr", Algorithm: esi.HashSHA256},
+			}, Count: &one},
+			{Name: "click after view", URL: "/click", Params: map[string]server.ParamExpectation{"campaign": {Matches: "^spr"}}},
+		},
+	})
+	require.NoError(t, err)
+	assert.True(t, result.Passed, "%+v", result.Results)
+	assert.Equal(t, []int64{1, 3}, result.Results[0].Captures)
+	assert.Equal(t, []int64{1}, result.Results[1].Captures)
+
+	result, err = c.AssertSink(server.SinkAssertRequest{
+		Ordered: true,
+		Expectations: []server.SinkExpectation{
+			{Name: "summer view", URL: "campaign=summer"},
+			{Name: "click", URL: "/click"},
+			{Name: "checkout", URL: "/checkout"},
+			{Name: "single view", URL: "/view", Count: &one},
+		},
+	})
+	require.NoError(t, err)
+	assert.False(t, result.Passed)
+	assert.True(t, result.Results[0].Passed)
+	assert.Equal(t, "first matching request came before summer view", result.Results[1].Message)
+	assert.Equal(t, "no matching request", result.Results[2].Message)
+	assert.Equal(t, "expected 1 matching requests, got 2", result.Results[3].Message)
+
+	_, err = c.AssertSink(server.SinkAssertRequest{Expectations: []server.SinkExpectation{{URL: "("}}})
+	assert.ErrorContains(t, err, "expectation 1: invalid url pattern")
+}
+
+func TestClient_Sessions(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := r.Cookie("user")
+		if err != nil {
+			w.Write([]byte("<p>Hello guest</p>"))
+			return
+		}
+		w.Write([]byte("<p>Hello " + user.Value + "</p>"))
+	}))
+	defer origin.Close()
+
+	ts := newTestServer(t)
+	c := New(ts.URL)
+	session := c.WithSession("checkout")
+	page := `<esi:include src="` + origin.URL + `/greeting" />`
+
+	// Logging in sets the user cookie and remembers the cart
+	resp, err := session.ProcessRaw(`<esi:function name="add_header" header="Set-Cookie" value="user=alice; Path=/"></esi:function>`+
+		`<esi:function name="add_header" header="Set-Cookie" value="theme=dark; Path=/"></esi:function>`+
+		`<esi:assign name="cart" value="3" /><p>Logged in</p>`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "checkout", resp.Header.Get(server.SessionHeader))
+
+	resp, err = session.ProcessRaw(page+`<esi:vars><p>Cart $(cart|0)</p></esi:vars>`, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Body, "<p>Hello alice</p>")
+	assert.Contains(t, resp.Body, "<p>Cart 3</p>")
+
+	document, err := session.ProcessDocument([]byte("<html><body>"+page+"</body></html>"), "text/html")
+	require.NoError(t, err)
+	assert.Contains(t, document.Body, "<p>Hello alice</p>")
+
+	// Requests outside the session, and cookies the request sends itself, win
+	resp, err = c.ProcessRaw(page, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Body, "<p>Hello guest</p>")
+	resp, err = session.ProcessRaw(page, &esi.ProcessContext{BaseURL: origin.URL, Cookies: map[string]string{"user": "bob"},
+		Headers: map[string]string{"Cookie": "user=bob"}})
+	require.NoError(t, err)
+	assert.Contains(t, resp.Body, "<p>Hello bob</p>")
+
+	state, err := c.Session("checkout")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user": "alice", "theme": "dark"}, state.Cookies)
+	assert.Equal(t, map[string]string{"cart": "3"}, state.Variables)
+	assert.Equal(t, 4, state.Requests)
+
+	// Logging out expires the cookie
+	_, err = session.ProcessRaw(`<esi:function name="add_header" header="Set-Cookie" value="user=; Max-Age=0"></esi:function>`, nil)
+	require.NoError(t, err)
+	resp, err = session.ProcessRaw(page, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Body, "<p>Hello guest</p>")
+
+	state, err = c.PutSession("returning", server.SessionUpdate{Cookies: map[string]string{"user": "carol"}})
+	require.NoError(t, err)
+	assert.Equal(t, "returning", state.ID)
+	resp, err = c.WithSession("returning").ProcessRaw(page, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Body, "<p>Hello carol</p>")
+
+	sessions, err := c.Sessions()
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "checkout", sessions[0].ID)
+
+	require.NoError(t, c.DeleteSession("checkout"))
+	_, err = c.Session("checkout")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+}
+
+func TestClient_Frequency(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar}
+
+	var results []server.FrequencyResult
+	for i := 0; i < 3; i++ {
+		resp, err := browser.Get(ts.URL + "/frequency/p1?cap=2&period=day")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result server.FrequencyResult
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		resp.Body.Close()
+		results = append(results, result)
+
+		cookie := resp.Cookies()[0]
+		assert.Equal(t, "fc_p1", cookie.Name)
+		assert.Greater(t, cookie.MaxAge, 0)
+	}
+
+	assert.Equal(t, 3, results[2].Count)
+	assert.False(t, results[1].Duplicate)
+	assert.True(t, results[2].Duplicate)
+
+	resp, err := http.Get(ts.URL + "/frequency/p2?period=week")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	stats, err := c.Frequency()
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.Equal(t, server.FrequencyStats{Pixel: "p1", Fires: 3, Duplicates: 1}, stats[0])
+
+	require.NoError(t, c.ResetFrequency())
+	stats, err = c.Frequency()
+	require.NoError(t, err)
+	assert.Empty(t, stats)
+}
+
+func TestClient_FrequencyCappedContainer(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer origin.Close()
+
+	ts := newTestServer(t)
+	c := New(ts.URL)
+	config := esi.ContainerConfig{Pixels: []esi.Pixel{{ID: "p1", TYPE: "dir", URL: origin.URL + "/p.gif",
+		CONDITIONS: &esi.PixelConditions{FrequencyCap: 1}}}}
+	container, _, err := esi.ProcessContainerConfig(config, esi.ESIConfig{FrequencyEndpoint: ts.URL + "/frequency"})
+	require.NoError(t, err)
+
+	// The first page fires the pixel and passes its frequency cookie on to the browser
+	session := c.WithSession("visitor")
+	resp, err := session.ProcessRaw(container, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Header.Get("Set-Cookie"), "fc_p1=1")
+	assert.Equal(t, int32(1), hits.Load())
+
+	// The cap is reached, so the next page suppresses it
+	_, err = session.ProcessRaw(container, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), hits.Load())
+
+	stats, err := c.Frequency()
+	require.NoError(t, err)
+	assert.Equal(t, []server.FrequencyStats{{Pixel: "p1", Fires: 1}}, stats)
+}
+
+func TestClient_LogLevels(t *testing.T) {
+	logger := utils.NewLogger("info", false, "test")
+	logger.SetOutput(io.Discard)
+	srv := server.New(server.Config{Port: 0, Mode: "esi"},
+		server.WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})),
+		server.WithLogLevels(logger))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	c := New(ts.URL)
+
+	levels, err := c.LogLevels()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"default": "info"}, levels)
+
+	levels, err = c.SetLogLevel("esi", "debug")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"default": "info", "esi": "debug"}, levels)
+	assert.True(t, logger.Component("esi").Enabled(utils.LogLevelDebug))
+	assert.False(t, logger.Component("server").Enabled(utils.LogLevelDebug))
+
+	_, err = c.SetLogLevel("esi", "verbose")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(*APIError).StatusCode)
+
+	levels, err = c.SetLogLevel("esi", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"default": "info"}, levels)
+
+	_, err = New(newTestServer(t).URL).LogLevels()
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, err.(*APIError).StatusCode)
+}
+
+func TestClient_ProcessFastly(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<nav>%s %s</nav>", r.URL.Path, r.Header.Get("X-Device"))
+	}))
+	defer origin.Close()
+
+	vcl, err := fastly.Parse(fmt.Sprintf(`
+backend F_origin { .host = "%s"; .port = "%s"; }
+sub vcl_recv {
+    if (req.url ~ "^/admin") {
+        error 403 "Forbidden";
+    }
+    set req.http.X-Device = "mobile";
+    set req.url = "/v2" + req.url;
+}
+sub vcl_fetch {
+    if (beresp.http.Content-Type ~ "text/html") {
+        esi;
+    }
+}
+sub vcl_deliver {
+    set resp.http.X-Served-By = "emulator";
+}`, origin.Listener.Addr().(*net.TCPAddr).IP, fmt.Sprint(origin.Listener.Addr().(*net.TCPAddr).Port)))
+	require.NoError(t, err)
+
+	processor := esi.NewProcessor(esi.Config{Mode: "fastly", MaxIncludes: 10, MaxDepth: 5})
+	srv := server.New(server.Config{Mode: "esi"}, server.WithFastly(processor, vcl))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	c := New(ts.URL)
+
+	page := `<esi:include src="/fragments/nav"/>`
+	resp, err := c.ProcessFastly(server.FastlyProcessRequest{
+		HTML:          page,
+		Context:       &propertymanager.HTTPContext{Method: "GET", Path: "/home", Host: "www.example.com"},
+		OriginHeaders: map[string]string{"Content-Type": "text/html"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, resp.ProcessedHTML, "<nav>/v2/fragments/nav mobile</nav>")
+	assert.Equal(t, "F_origin", resp.Fastly.Backend.Name)
+	assert.Equal(t, "/v2/home", resp.Fastly.URL)
+	assert.True(t, resp.Fastly.ESI)
+	assert.Equal(t, "emulator", resp.Fastly.ResponseHeaders["X-Served-By"])
+
+	// Without esi in vcl_fetch the page is served as is
+	resp, err = c.ProcessFastly(server.FastlyProcessRequest{
+		HTML:    page,
+		Context: &propertymanager.HTTPContext{Method: "GET", Path: "/home"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, page, resp.ProcessedHTML)
+	assert.False(t, resp.Fastly.ESI)
+
+	_, err = c.ProcessFastly(server.FastlyProcessRequest{
+		HTML:    page,
+		Context: &propertymanager.HTTPContext{Method: "GET", Path: "/admin"},
+	})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+	info, err := c.Info()
+	require.NoError(t, err)
+	assert.Contains(t, info["endpoints"], "/fastly/process")
+
+	// Servers without a VCL do not serve the endpoint
+	_, err = New(newTestServer(t).URL).ProcessFastly(server.FastlyProcessRequest{
+		HTML: page, Context: &propertymanager.HTTPContext{Method: "GET", Path: "/"}})
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+}
+
+func TestClient_ProcessByName(t *testing.T) {
+	dir := t.TempDir()
+	for version, content := range map[string]string{"v41": "<p>v41</p>", "v42": `<p>v42 <esi:vars>$(CTX{user})</esi:vars></p>`} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "checkout"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "checkout", version+".html"), []byte(content), 0o644))
+	}
+	store := templates.NewStore(templates.NewDirSource(dir), templates.Config{})
+	srv := server.New(server.Config{Mode: "esi"},
+		server.WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})),
+		server.WithTemplates(store))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	c := New(ts.URL)
+
+	resp, err := c.ProcessByName("checkout", &esi.ProcessContext{Data: map[string]interface{}{"user": "ada"}})
+	require.NoError(t, err)
+	assert.Contains(t, resp.Result, "<p>v42 ada</p>")
+	assert.Equal(t, "checkout@v42", resp.Template)
+
+	require.NoError(t, store.Pin("checkout", "v41"))
+	resp, err = c.ProcessByName("checkout", nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Result, "<p>v41</p>")
+	assert.Equal(t, "checkout@v41", resp.Template)
+
+	var apiErr *APIError
+	_, err = c.ProcessByName("checkout@v1", nil)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	_, err = c.ProcessByName("../checkout", nil)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	info, err := c.Info()
+	require.NoError(t, err)
+	assert.Contains(t, info["endpoints"], "/process/by-name")
+
+	// Servers without a template store do not serve the endpoint
+	_, err = New(newTestServer(t).URL).ProcessByName("checkout", nil)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+}
+
+func TestClient_Health(t *testing.T) {
+	c := New(newTestServer(t).URL)
+
+	health, err := c.Health()
+	require.NoError(t, err)
+	assert.Equal(t, "healthy", health["status"])
+	assert.Equal(t, map[string]interface{}{"esi": map[string]interface{}{"status": server.ComponentReady}}, health["components"])
+
+	geoReady := false
+	srv := server.New(server.Config{Port: 0, Mode: "integrated"},
+		server.WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})),
+		server.WithReadinessCheck("geo", func() error {
+			if !geoReady {
+				return fmt.Errorf("geo database is not open")
+			}
+			return nil
+		}))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	c = New(ts.URL)
+
+	_, err = c.Health()
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, err.(*APIError).StatusCode)
+
+	ready, components := srv.Readiness()
+	assert.False(t, ready)
+	assert.Equal(t, server.ComponentHealth{Status: server.ComponentNotReady, Message: "geo database is not open"}, components["geo"])
+	assert.Equal(t, server.ComponentNotReady, components["propertyManager"].Status, "integrated mode needs the Property Manager")
+	assert.Equal(t, server.ComponentReady, components["esi"].Status)
+
+	live, err := c.Live()
+	require.NoError(t, err, "liveness does not depend on readiness")
+	assert.Equal(t, "alive", live["status"])
+
+	geoReady = true
+	srv.SetPropertyManagerProcessor(propertymanager.NewPropertyManager(false))
+	ready, _ = srv.Readiness()
+	assert.True(t, ready)
+}
+
+func TestClient_Faults(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fragment"))
+	}))
+	t.Cleanup(origin.Close)
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	rules, err := c.Faults()
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	originHost := strings.TrimPrefix(origin.URL, "http://")
+	rules, err = c.SetFaults([]esi.FaultRule{{Host: originHost, Percent: 100, Status: 503}})
+	require.NoError(t, err)
+	assert.Len(t, rules, 1)
+
+	template := `<esi:include src="` + origin.URL + `/f" alt="` + origin.URL + `/f" onerror="continue"/>`
+	resp, err := c.Process(template, nil)
+	require.NoError(t, err)
+	assert.NotContains(t, resp.Result, "fragment")
+
+	_, err = c.SetFaults([]esi.FaultRule{{Host: originHost, Percent: 200}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(*APIError).StatusCode)
+
+	_, err = c.SetFaults(nil)
+	require.NoError(t, err)
+	resp, err = c.Process(template, nil)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Result, "fragment")
+}
+
+func TestClient_ProcessDocument(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+
+	// "\xe9" is é in ISO-8859-1
+	resp, err := c.ProcessDocument([]byte("<p>caf\xe9</p><esi:remove>gone</esi:remove>"), "text/html; charset=iso-8859-1")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=windows-1252", resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Body, "<p>caf\xe9</p>")
+	assert.NotContains(t, resp.Body, "gone")
+}
+
+func TestClient_ProcessDocumentPassThrough(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(ts.URL)
+	markup := "<p>a</p><esi:remove>kept</esi:remove>"
+
+	resp, err := c.ProcessDocument([]byte(markup), "application/xml; charset=utf-8")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, markup, resp.Body)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/process", strings.NewReader(markup))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/html")
+	req.Header.Set("Range", "bytes=0-9")
+	rangeResp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer rangeResp.Body.Close()
+	body, err := io.ReadAll(rangeResp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, markup, string(body))
+
+	stats, err := c.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), stats["stats"].(map[string]interface{})["passThrough"])
+}
+
+func TestClient_ResetStats(t *testing.T) {
+	srv := server.New(server.Config{Port: 0, Mode: "esi"},
+		server.WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, StatsWindows: true})))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	c := New(ts.URL)
+
+	_, err := c.Process(`<p>Hello</p>`, nil)
+	require.NoError(t, err)
+
+	stats, err := c.Stats()
+	require.NoError(t, err)
+	windows := stats["stats"].(map[string]interface{})["windows"].(map[string]interface{})
+	assert.Equal(t, float64(1), windows["1m"].(map[string]interface{})["requests"])
+	memory := stats["stats"].(map[string]interface{})["memory"].(map[string]interface{})
+	assert.Equal(t, float64(1), memory["requests"])
+
+	reset, err := c.ResetStats()
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), reset["stats"].(map[string]interface{})["requests"])
+
+	stats, err = c.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), stats["stats"].(map[string]interface{})["requests"])
+}
+
+func TestClient_CacheEntries(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+	}))
+	t.Cleanup(origin.Close)
+	srv := server.New(server.Config{Port: 0, Mode: "esi"},
+		server.WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5,
+			Cache: esi.CacheConfig{Enabled: true, TTL: 60}})))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	c := New(ts.URL)
+
+	template := `<esi:include src="` + origin.URL + `/a"/><esi:include src="` + origin.URL + `/b"/><esi:include src="` + origin.URL + `/a"/>`
+	_, err := c.Process(template, &esi.ProcessContext{Headers: map[string]string{"Accept-Language": "de"}})
+	require.NoError(t, err)
+
+	page, err := c.CacheEntries(0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, page.Total)
+	require.Len(t, page.Entries, 1)
+	first := page.Entries[0]
+	assert.Equal(t, origin.URL+"/a", first.Key)
+	assert.Equal(t, len("<p>/a</p>"), first.Size)
+	assert.Equal(t, int64(1), first.Hits)
+	assert.Equal(t, map[string]string{"Accept-Language": "de"}, first.Vary)
+	assert.InDelta(t, 60, first.TTLRemaining, 5)
+	assert.False(t, first.Expired)
+
+	page, err = c.CacheEntries(1, 0)
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, origin.URL+"/b", page.Entries[0].Key)
+	assert.Equal(t, server.DefaultCacheEntriesLimit, page.Limit)
+
+	entry, err := c.CacheEntry(origin.URL + "/b")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>/b</p>", entry.Content)
+	assert.Equal(t, int64(0), entry.Hits)
+
+	_, err = c.CacheEntry(origin.URL + "/missing")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, err.(*APIError).StatusCode)
+
+	_, err = c.CacheEntries(0, server.MaxCacheEntriesLimit+1)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(*APIError).StatusCode)
+}
+
+func TestClient_Purge(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Surrogate-Key", "page "+strings.TrimPrefix(r.URL.Path, "/"))
+		w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+	}))
+	t.Cleanup(origin.Close)
+	srv := server.New(server.Config{Port: 0, Mode: "esi"},
+		server.WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5,
+			Cache: esi.CacheConfig{Enabled: true, TTL: 60}})))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	c := New(ts.URL)
+
+	template := `<esi:include src="` + origin.URL + `/a"/><esi:include src="` + origin.URL + `/b"/>`
+	_, err := c.Process(template, nil)
+	require.NoError(t, err)
+
+	result, err := c.Purge(esi.PurgeRequest{Tags: []string{"a"}, Soft: true})
+	require.NoError(t, err)
+	assert.Equal(t, &esi.PurgeResult{Purged: 1, Keys: []string{origin.URL + "/a"}, Soft: true}, result)
+
+	entry, err := c.CacheEntry(origin.URL + "/a")
+	require.NoError(t, err)
+	assert.True(t, entry.SoftPurged)
+	assert.Equal(t, []string{"page", "a"}, entry.Tags)
+
+	result, err = c.Purge(esi.PurgeRequest{URLs: []string{origin.URL + "/b"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Purged)
+	_, err = c.CacheEntry(origin.URL + "/b")
+	require.Error(t, err)
+
+	_, err = c.Purge(esi.PurgeRequest{Soft: true})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(*APIError).StatusCode)
+}
+
+func TestClient_ProcessJSON(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"amount": 19.90}`))
+	}))
+	t.Cleanup(origin.Close)
+	c := New(newTestServer(t).URL)
+
+	resp, err := c.ProcessJSON([]byte(`{"id": 42, "price": {"$esi:include": "` + origin.URL + `/price"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"id":42,"price":{"amount":19.90}}`, resp.Body)
+
+	resp, err = c.ProcessJSON([]byte(`{"price": {"$esi:include": "` + origin.URL + `/missing", "x": 1`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = c.ProcessJSON([]byte(`{"price": {"$esi:include": "http://127.0.0.1:1/price"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
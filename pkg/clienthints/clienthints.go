@@ -0,0 +1,187 @@
+// Package clienthints describes the client device from its User-Agent client hints
+// (Sec-CH-UA, Sec-CH-UA-Mobile and Sec-CH-UA-Platform), falling back to parsing the
+// User-Agent header for the hints a browser does not send.
+package clienthints
+
+import (
+	"strings"
+)
+
+// Client hint request headers
+const (
+	HeaderUA         = "Sec-CH-UA"
+	HeaderUAMobile   = "Sec-CH-UA-Mobile"
+	HeaderUAPlatform = "Sec-CH-UA-Platform"
+)
+
+// AcceptCH is the Accept-CH response header value asking browsers for the hints
+// read by Parse
+const AcceptCH = HeaderUA + ", " + HeaderUAMobile + ", " + HeaderUAPlatform
+
+// Device describes the client device
+type Device struct {
+	Brand    string `json:"brand"`    // Browser brand, such as Google Chrome, Firefox or Safari
+	Version  string `json:"version"`  // Significant version of the brand, such as 120
+	Mobile   bool   `json:"mobile"`   // Whether the browser prefers a mobile experience
+	Platform string `json:"platform"` // Operating system, such as Windows, macOS, Android or iOS
+	// FromHints reports whether any of the fields were taken from client hints
+	FromHints bool `json:"fromHints"`
+}
+
+// Parse describes the device of a request; header returns a request header by name,
+// or an empty string when the request does not have it
+func Parse(header func(name string) string) Device {
+	var device Device
+	userAgent := header("User-Agent")
+
+	if brand, version, ok := parseBrands(header(HeaderUA)); ok {
+		device.Brand, device.Version, device.FromHints = brand, version, true
+	} else {
+		device.Brand, device.Version = userAgentBrand(userAgent)
+	}
+
+	switch strings.TrimSpace(header(HeaderUAMobile)) {
+	case "?1":
+		device.Mobile, device.FromHints = true, true
+	case "?0":
+		device.FromHints = true
+	default:
+		device.Mobile = strings.Contains(userAgent, "Mobi")
+	}
+
+	if platform := unquote(header(HeaderUAPlatform)); platform != "" {
+		device.Platform, device.FromHints = platform, true
+	} else {
+		device.Platform = userAgentPlatform(userAgent)
+	}
+	return device
+}
+
+// FromMap describes the device of a request whose headers are keyed by name in any case
+func FromMap(headers map[string]string) Device {
+	return Parse(func(name string) string {
+		if value, exists := headers[name]; exists {
+			return value
+		}
+		for key, value := range headers {
+			if strings.EqualFold(key, name) {
+				return value
+			}
+		}
+		return ""
+	})
+}
+
+// parseBrands returns the most specific brand of a Sec-CH-UA brand list such as
+// "Chromium";v="120", "Google Chrome";v="120", "Not_A Brand";v="8". GREASE brands
+// are skipped and Chromium is only chosen when no other brand is listed.
+func parseBrands(list string) (brand, version string, ok bool) {
+	for _, item := range splitList(list) {
+		name, params, _ := strings.Cut(item, ";")
+		name = unquote(name)
+		if name == "" || isGREASE(name) {
+			continue
+		}
+		if ok && name == "Chromium" {
+			continue
+		}
+
+		var v string
+		for _, param := range strings.Split(params, ";") {
+			if key, value, found := strings.Cut(strings.TrimSpace(param), "="); found && key == "v" {
+				v = unquote(value)
+			}
+		}
+		if !ok || brand == "Chromium" {
+			brand, version, ok = name, v, true
+		}
+	}
+	return brand, version, ok
+}
+
+// splitList splits a structured header list on the commas outside quoted strings
+func splitList(list string) []string {
+	var items []string
+	var quoted bool
+	start := 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				items = append(items, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(list[start:]); rest != "" {
+		items = append(items, rest)
+	}
+	return items
+}
+
+// isGREASE reports whether a brand is one of the made-up brands browsers add to keep
+// servers from depending on the order and content of the list
+func isGREASE(brand string) bool {
+	return strings.Contains(brand, "Not") && strings.Contains(brand, "Brand")
+}
+
+// unquote removes the quotes of a structured header string
+func unquote(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+	}
+	return value
+}
+
+// userAgentBrands are the browser tokens of User-Agent headers, most specific first
+var userAgentBrands = []struct {
+	token string
+	brand string
+}{
+	{"Edg/", "Microsoft Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Google Chrome"},
+	{"CriOS/", "Google Chrome"},
+	{"Version/", "Safari"},
+}
+
+// userAgentBrand returns the browser brand and significant version of a User-Agent
+func userAgentBrand(userAgent string) (string, string) {
+	for _, b := range userAgentBrands {
+		if _, after, found := strings.Cut(userAgent, b.token); found {
+			if b.brand == "Safari" && !strings.Contains(userAgent, "Safari/") {
+				continue
+			}
+			version, _, _ := strings.Cut(after, " ")
+			version, _, _ = strings.Cut(version, ".")
+			return b.brand, version
+		}
+	}
+	return "", ""
+}
+
+// userAgentPlatform returns the operating system of a User-Agent
+func userAgentPlatform(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "Windows"):
+		return "Windows"
+	case strings.Contains(userAgent, "Android"):
+		return "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return "iOS"
+	case strings.Contains(userAgent, "CrOS"):
+		return "Chrome OS"
+	case strings.Contains(userAgent, "Mac OS X"), strings.Contains(userAgent, "Macintosh"):
+		return "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		return "Linux"
+	}
+	return ""
+}
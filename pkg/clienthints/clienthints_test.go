@@ -0,0 +1,88 @@
+package clienthints
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	chromeWindows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	safariIPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"
+	firefoxLinux  = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	edgeAndroid   = "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36 EdgA/120.0.0.0 Edg/120.0.0.0"
+)
+
+func TestFromMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected Device
+	}{
+		{
+			name: "client hints",
+			headers: map[string]string{
+				"Sec-CH-UA":          `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+				"Sec-CH-UA-Mobile":   "?1",
+				"Sec-CH-UA-Platform": `"Android"`,
+				"User-Agent":         chromeWindows,
+			},
+			expected: Device{Brand: "Google Chrome", Version: "120", Mobile: true, Platform: "Android", FromHints: true},
+		},
+		{
+			name: "header names in any case",
+			headers: map[string]string{
+				"sec-ch-ua":          `"Microsoft Edge";v="119", "Chromium";v="119", "Not?A_Brand";v="24"`,
+				"sec-ch-ua-mobile":   "?0",
+				"sec-ch-ua-platform": `"Windows"`,
+			},
+			expected: Device{Brand: "Microsoft Edge", Version: "119", Platform: "Windows", FromHints: true},
+		},
+		{
+			name: "Chromium only",
+			headers: map[string]string{
+				"Sec-CH-UA": `"Chromium";v="118", "Not=A?Brand";v="99"`,
+			},
+			expected: Device{Brand: "Chromium", Version: "118", FromHints: true},
+		},
+		{
+			name: "missing hints fall back to the user agent",
+			headers: map[string]string{
+				"Sec-CH-UA-Mobile": "?0",
+				"User-Agent":       chromeWindows,
+			},
+			expected: Device{Brand: "Google Chrome", Version: "120", Platform: "Windows", FromHints: true},
+		},
+		{
+			name:     "Safari on iPhone",
+			headers:  map[string]string{"User-Agent": safariIPhone},
+			expected: Device{Brand: "Safari", Version: "17", Mobile: true, Platform: "iOS"},
+		},
+		{
+			name:     "Firefox on Linux",
+			headers:  map[string]string{"User-Agent": firefoxLinux},
+			expected: Device{Brand: "Firefox", Version: "121", Platform: "Linux"},
+		},
+		{
+			name:     "Edge on Android",
+			headers:  map[string]string{"User-Agent": edgeAndroid},
+			expected: Device{Brand: "Microsoft Edge", Version: "120", Mobile: true, Platform: "Android"},
+		},
+		{
+			name:     "no headers",
+			headers:  map[string]string{},
+			expected: Device{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, FromMap(tt.headers))
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{`"a, b";v="1"`, `"c";v="2"`}, splitList(`"a, b";v="1",  "c";v="2"`))
+	assert.Empty(t, splitList(""))
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/edge-computing/emulator-suite/pkg/clienthints"
 )
 
 // ProcessorInterface defines the interface needed by Akamai extensions
@@ -18,10 +19,19 @@ type ProcessorInterface interface {
 	GetESIVariable(varName, key string, context ProcessContext) string
 }
 
+// geoOverrideHeaders are the names, after the configured prefix, of the request headers
+// that override each geo component
+var geoOverrideHeaders = map[string]string{
+	"country_code": "Country",
+	"country_name": "Country-Name",
+	"region":       "Region",
+	"city":         "City",
+}
+
 // AkamaiExtensions contains Akamai-specific ESI extensions
 type AkamaiExtensions struct {
 	processor ProcessorInterface
-	variables map[string]string // Storage for assigned variables
+	variables map[string]string // Preset variables, visible to every page
 }
 
 // NewAkamaiExtensions creates a new Akamai extensions handler
@@ -32,213 +42,171 @@ func NewAkamaiExtensions(processor ProcessorInterface) *AkamaiExtensions {
 	}
 }
 
-// ProcessAkamaiExtensions processes Akamai-specific ESI elements
-func (a *AkamaiExtensions) ProcessAkamaiExtensions(doc *goquery.Document, context ProcessContext) error {
-	if a.processor.GetConfig().Debug {
-		fmt.Println("🔍 Processing Akamai ESI extensions...")
-	}
-
-	// Process esi:assign elements
-	if err := a.processAssign(doc, context); err != nil {
-		return err
+// assign handles an esi:assign element, storing its value for the elements that follow
+func (a *AkamaiExtensions) assign(s *goquery.Selection, context ProcessContext) {
+	name, nameExists := s.Attr("name")
+	if !nameExists || name == "" {
+		if a.processor.GetConfig().Debug {
+			fmt.Println("⚠️  esi:assign missing name attribute")
+		}
+		return
 	}
 
-	// Process esi:eval elements
-	if err := a.processEval(doc, context); err != nil {
-		return err
+	var value string
+	if v, valueExists := s.Attr("value"); valueExists {
+		// Direct value assignment
+		value = a.expandVariables(v, context)
+	} else {
+		// Use element content as value
+		value = a.expandVariables(s.Text(), context)
 	}
 
-	// Process esi:function elements
-	if err := a.processFunction(doc, context); err != nil {
-		return err
-	}
+	scope, _ := s.Attr("scope")
+	a.assignVariable(name, value, scope, context)
+}
 
-	// Process esi:dictionary elements
-	if err := a.processDictionary(doc, context); err != nil {
-		return err
+// assignVariable stores a variable in the scope named by scope, local or global, of the
+// page or fragment being executed
+func (a *AkamaiExtensions) assignVariable(name, value, scope string, context ProcessContext) {
+	variables := a.variables
+	if context.scope != nil {
+		variables = context.scope.variables
+		switch scope {
+		case "", ScopeLocal:
+		case ScopeGlobal:
+			variables = context.scope.page().variables
+		default:
+			if a.processor.GetConfig().Debug {
+				fmt.Printf("⚠️  esi:assign unknown scope %q, assigning %s locally\n", scope, name)
+			}
+		}
 	}
+	variables[name] = value
 
-	// Process esi:debug elements
-	if err := a.processDebug(doc, context); err != nil {
-		return err
+	if a.processor.GetConfig().Debug {
+		fmt.Printf("📝 Assigned variable %s = %s\n", name, value)
 	}
+}
 
-	// Process extended esi:include features
-	if err := a.processExtendedInclude(doc, context); err != nil {
-		return err
+// lookupVariable returns an assigned variable visible from the scope being executed,
+// or a preset variable
+func (a *AkamaiExtensions) lookupVariable(name string, context ProcessContext) (string, bool) {
+	if value, exists := context.scope.lookup(name); exists {
+		return value, true
 	}
-
-	return nil
+	value, exists := a.variables[name]
+	return value, exists
 }
 
-// processAssign handles esi:assign elements for variable assignment
-func (a *AkamaiExtensions) processAssign(doc *goquery.Document, context ProcessContext) error {
-	doc.Find("esi\\:assign, assign").Each(func(i int, s *goquery.Selection) {
-		name, nameExists := s.Attr("name")
-		value, valueExists := s.Attr("value")
-
-		if !nameExists || name == "" {
-			if a.processor.GetConfig().Debug {
-				fmt.Println("⚠️  esi:assign missing name attribute")
-			}
-			s.Remove()
-			return
-		}
-
-		if valueExists {
-			// Direct value assignment
-			expandedValue := a.expandVariables(value, context)
-			a.variables[name] = expandedValue
-		} else {
-			// Use element content as value
-			content := s.Text()
-			expandedValue := a.expandVariables(content, context)
-			a.variables[name] = expandedValue
-		}
-
+// eval handles an esi:eval element and returns the result of its expression
+func (a *AkamaiExtensions) eval(s *goquery.Selection, context ProcessContext) string {
+	expr, exists := s.Attr("expr")
+	if !exists || expr == "" {
 		if a.processor.GetConfig().Debug {
-			fmt.Printf("📝 Assigned variable %s = %s\n", name, a.variables[name])
+			fmt.Println("⚠️  esi:eval missing expr attribute")
 		}
+		return ""
+	}
 
-		s.Remove()
-	})
-
-	return nil
+	result := a.evaluateExpression(expr, context)
+	if a.processor.GetConfig().Debug {
+		fmt.Printf("🧮 Evaluated expression: %s = %s\n", expr, result)
+	}
+	return result
 }
 
-// processEval handles esi:eval elements for expression evaluation
-func (a *AkamaiExtensions) processEval(doc *goquery.Document, context ProcessContext) error {
-	doc.Find("esi\\:eval, eval").Each(func(i int, s *goquery.Selection) {
-		expr, exists := s.Attr("expr")
-		if !exists || expr == "" {
-			if a.processor.GetConfig().Debug {
-				fmt.Println("⚠️  esi:eval missing expr attribute")
-			}
-			s.Remove()
-			return
-		}
-
-		result := a.evaluateExpression(expr, context)
-		s.ReplaceWithHtml(result)
-
+// function handles an esi:function element and returns the result of the built-in function
+func (a *AkamaiExtensions) function(s *goquery.Selection, context ProcessContext) string {
+	name, nameExists := s.Attr("name")
+	if !nameExists || name == "" {
 		if a.processor.GetConfig().Debug {
-			fmt.Printf("🧮 Evaluated expression: %s = %s\n", expr, result)
+			fmt.Println("⚠️  esi:function missing name attribute")
 		}
-	})
+		return ""
+	}
 
-	return nil
+	result := a.executeFunction(name, s, context)
+	if a.processor.GetConfig().Debug {
+		fmt.Printf("⚙️  Executed function: %s = %s\n", name, result)
+	}
+	return result
 }
 
-// processFunction handles esi:function elements for built-in functions
-func (a *AkamaiExtensions) processFunction(doc *goquery.Document, context ProcessContext) error {
-	doc.Find("esi\\:function, function").Each(func(i int, s *goquery.Selection) {
-		name, nameExists := s.Attr("name")
-		if !nameExists || name == "" {
-			if a.processor.GetConfig().Debug {
-				fmt.Println("⚠️  esi:function missing name attribute")
-			}
-			s.Remove()
-			return
-		}
-
-		result := a.executeFunction(name, s, context)
-		s.ReplaceWithHtml(result)
+// dictionary handles an esi:dictionary element and returns the looked up value
+func (a *AkamaiExtensions) dictionary(s *goquery.Selection, context ProcessContext) string {
+	src, srcExists := s.Attr("src")
+	key, keyExists := s.Attr("key")
+	defaultVal, _ := s.Attr("default")
 
+	if !srcExists || !keyExists {
 		if a.processor.GetConfig().Debug {
-			fmt.Printf("⚙️  Executed function: %s = %s\n", name, result)
+			fmt.Println("⚠️  esi:dictionary missing src or key attribute")
 		}
-	})
+		return ""
+	}
 
-	return nil
+	result := a.dictionaryLookup(src, key, defaultVal, context)
+	if a.processor.GetConfig().Debug {
+		fmt.Printf("📚 Dictionary lookup: %s[%s] = %s\n", src, key, result)
+	}
+	return result
 }
 
-// processDictionary handles esi:dictionary elements for key-value lookups
-func (a *AkamaiExtensions) processDictionary(doc *goquery.Document, context ProcessContext) error {
-	doc.Find("esi\\:dictionary, dictionary").Each(func(i int, s *goquery.Selection) {
-		src, srcExists := s.Attr("src")
-		key, keyExists := s.Attr("key")
-		defaultVal, _ := s.Attr("default")
-
-		if !srcExists || !keyExists {
-			if a.processor.GetConfig().Debug {
-				fmt.Println("⚠️  esi:dictionary missing src or key attribute")
-			}
-			s.Remove()
-			return
-		}
-
-		result := a.dictionaryLookup(src, key, defaultVal, context)
-		s.ReplaceWithHtml(result)
+// debug handles an esi:debug element, returning a debug comment in debug mode and
+// nothing otherwise
+func (a *AkamaiExtensions) debug(s *goquery.Selection, context ProcessContext) string {
+	if !a.processor.GetConfig().Debug {
+		return ""
+	}
 
-		if a.processor.GetConfig().Debug {
-			fmt.Printf("📚 Dictionary lookup: %s[%s] = %s\n", src, key, result)
-		}
-	})
+	var debugOutput string
+	switch debugType, _ := s.Attr("type"); debugType {
+	case "vars":
+		debugOutput = a.generateVariableDebugOutput(context)
+	case "headers":
+		debugOutput = a.generateHeaderDebugOutput(context)
+	case "cookies":
+		debugOutput = a.generateCookieDebugOutput(context)
+	case "time":
+		debugOutput = time.Now().Format(time.RFC3339)
+	default:
+		debugOutput = a.expandVariables(s.Text(), context)
+	}
 
-	return nil
+	return fmt.Sprintf("<!-- ESI DEBUG: %s -->", debugOutput)
 }
 
-// processDebug handles esi:debug elements for development debugging
-func (a *AkamaiExtensions) processDebug(doc *goquery.Document, context ProcessContext) error {
-	doc.Find("esi\\:debug, debug").Each(func(i int, s *goquery.Selection) {
-		if !a.processor.GetConfig().Debug {
-			s.Remove()
-			return
-		}
+// extendedInclude logs the Akamai-specific attributes of an esi:include element
+func (a *AkamaiExtensions) extendedInclude(s *goquery.Selection) {
+	if !a.debugEnabled() {
+		return
+	}
 
-		debugType, _ := s.Attr("type")
-		content := s.Text()
-
-		var debugOutput string
-		switch debugType {
-		case "vars":
-			debugOutput = a.generateVariableDebugOutput(context)
-		case "headers":
-			debugOutput = a.generateHeaderDebugOutput(context)
-		case "cookies":
-			debugOutput = a.generateCookieDebugOutput(context)
-		case "time":
-			debugOutput = time.Now().Format(time.RFC3339)
-		default:
-			debugOutput = a.expandVariables(content, context)
-		}
+	// Handle timeout attribute (Akamai extension)
+	if timeout, exists := s.Attr("timeout"); exists {
+		fmt.Printf("⏱️  Include timeout: %s\n", timeout)
+		// TODO: Implement custom timeout handling
+	}
 
-		debugHtml := fmt.Sprintf("<!-- ESI DEBUG: %s -->", debugOutput)
-		s.ReplaceWithHtml(debugHtml)
-	})
+	// Handle cacheable attribute (Akamai extension)
+	if cacheable, exists := s.Attr("cacheable"); exists {
+		fmt.Printf("💾 Include cacheable: %s\n", cacheable)
+		// TODO: Implement cacheable directive
+	}
 
-	return nil
+	// Handle method attribute (Akamai extension); the request itself is made by the include
+	if method, exists := s.Attr("method"); exists && method != "GET" {
+		fmt.Printf("🌐 Include method: %s\n", method)
+	}
 }
 
-// processExtendedInclude handles extended esi:include features specific to Akamai
-func (a *AkamaiExtensions) processExtendedInclude(doc *goquery.Document, _ ProcessContext) error {
-	doc.Find("esi\\:include, include").Each(func(i int, s *goquery.Selection) {
-		// Handle timeout attribute (Akamai extension)
-		if timeout, exists := s.Attr("timeout"); exists {
-			if a.processor.GetConfig().Debug {
-				fmt.Printf("⏱️  Include timeout: %s\n", timeout)
-			}
-			// TODO: Implement custom timeout handling
-		}
-
-		// Handle cacheable attribute (Akamai extension)
-		if cacheable, exists := s.Attr("cacheable"); exists {
-			if a.processor.GetConfig().Debug {
-				fmt.Printf("💾 Include cacheable: %s\n", cacheable)
-			}
-			// TODO: Implement cacheable directive
-		}
-
-		// Handle method attribute (Akamai extension)
-		if method, exists := s.Attr("method"); exists && method != "GET" {
-			if a.processor.GetConfig().Debug {
-				fmt.Printf("🌐 Include method: %s\n", method)
-			}
-			// TODO: Implement POST/PUT support
-		}
-	})
-
-	return nil
+// debugEnabled reports whether the processor's debug output is on, without copying its
+// config when it is a *Processor
+func (a *AkamaiExtensions) debugEnabled() bool {
+	if processor, ok := a.processor.(*Processor); ok {
+		return processor.debugEnabled()
+	}
+	return a.processor.GetConfig().Debug
 }
 
 // expandVariables expands ESI variables in a string
@@ -264,7 +232,7 @@ func (a *AkamaiExtensions) expandVariables(input string, context ProcessContext)
 		}
 
 		// Check for assigned variables first
-		if val, exists := a.variables[varName]; exists {
+		if val, exists := a.lookupVariable(varName, context); exists {
 			return val
 		}
 
@@ -289,9 +257,9 @@ func (a *AkamaiExtensions) expandVariables(input string, context ProcessContext)
 }
 
 // getESIVariable returns the value of an ESI variable
-func (a *AkamaiExtensions) getESIVariable(varName, _ string, context ProcessContext) string {
+func (a *AkamaiExtensions) getESIVariable(varName, key string, context ProcessContext) string {
 	// Check for assigned variables first
-	if val, exists := a.variables[varName]; exists {
+	if val, exists := a.lookupVariable(varName, context); exists {
 		return val
 	}
 
@@ -306,13 +274,16 @@ func (a *AkamaiExtensions) getESIVariable(varName, _ string, context ProcessCont
 	case "GEO_CITY":
 		return a.getGeoVariable("city", context)
 	case "CLIENT_IP":
-		if ip, exists := context.Headers["X-Forwarded-For"]; exists {
-			return strings.Split(ip, ",")[0]
-		}
-		if ip, exists := context.Headers["X-Real-IP"]; exists {
-			return ip
-		}
-		return ""
+		return clientIP(context)
+	case "UA_BRAND", "UA_VERSION", "UA_MOBILE", "UA_PLATFORM":
+		return a.getDeviceVariable(varName, context)
+	case "EXPERIMENT":
+		return a.getExperimentVariable(key, context)
+	case "EDGE_DATA":
+		value, _ := a.edgeData(key)
+		return value
+	case "LAST_INCLUDE_STATUS", "INCLUDE_HDR":
+		return a.getIncludeVariable(varName, key, context)
 	default:
 		// Unknown variable - don't delegate to processor to avoid infinite recursion
 		if a.processor.GetConfig().Debug {
@@ -395,6 +366,12 @@ func (a *AkamaiExtensions) executeFunction(name string, s *goquery.Selection, co
 		}
 		return expanded
 
+	case "sign_url":
+		input, _ := s.Attr("input")
+		ttlValue, _ := s.Attr("ttl")
+		ttl, _ := parseESITTL(ttlValue)
+		return a.signURL(a.expandVariables(input, context), ttl)
+
 	case "strlen":
 		input, _ := s.Attr("input")
 		expanded := a.expandVariables(input, context)
@@ -441,6 +418,35 @@ func (a *AkamaiExtensions) executeFunction(name string, s *goquery.Selection, co
 		}
 		return time.Now().Format(format)
 
+	case "set_redirect":
+		location, _ := s.Attr("location")
+		code, _ := s.Attr("code")
+		statusCode, err := strconv.Atoi(code)
+		if err != nil || statusCode < 300 || statusCode > 399 {
+			statusCode = 302
+		}
+		if location != "" && context.Response != nil {
+			context.Response.SetRedirect(a.expandVariables(location, context), statusCode)
+		}
+		return ""
+
+	case "add_header":
+		headerName, _ := s.Attr("header")
+		value, _ := s.Attr("value")
+		if headerName != "" && context.Response != nil {
+			context.Response.AddHeader(headerName, a.expandVariables(value, context))
+		}
+		return ""
+
+	case "set_response_code":
+		code, _ := s.Attr("code")
+		// Codes outside 200-599 cannot be written as a response status and are ignored
+		statusCode, err := strconv.Atoi(code)
+		if err == nil && statusCode >= 200 && statusCode <= 599 && context.Response != nil {
+			context.Response.StatusCode = statusCode
+		}
+		return ""
+
 	default:
 		if a.processor.GetConfig().Debug {
 			fmt.Printf("⚠️  Unknown ESI function: %s\n", name)
@@ -527,7 +533,11 @@ func (a *AkamaiExtensions) getQueryParam(queryString, key string) string {
 	return values.Get(key)
 }
 
-func (a *AkamaiExtensions) getGeoVariable(component string, _ ProcessContext) string {
+func (a *AkamaiExtensions) getGeoVariable(component string, context ProcessContext) string {
+	if value := a.geoOverride(component, context); value != "" {
+		return value
+	}
+
 	// Simplified geo implementation - would integrate with real GeoIP service
 	switch component {
 	case "country_code":
@@ -543,11 +553,51 @@ func (a *AkamaiExtensions) getGeoVariable(component string, _ ProcessContext) st
 	}
 }
 
-func (a *AkamaiExtensions) generateVariableDebugOutput(_ ProcessContext) string {
+// getDeviceVariable returns a UA_* variable from the request's client hints, or from its
+// User-Agent for the hints it lacks. Responses reading them ask for the hints with Accept-CH.
+func (a *AkamaiExtensions) getDeviceVariable(varName string, context ProcessContext) string {
+	if context.Response != nil {
+		context.Response.SetHeader("Accept-CH", clienthints.AcceptCH)
+	}
+
+	device := clienthints.FromMap(context.Headers)
+	switch varName {
+	case "UA_BRAND":
+		return device.Brand
+	case "UA_VERSION":
+		return device.Version
+	case "UA_MOBILE":
+		return strconv.FormatBool(device.Mobile)
+	default:
+		return device.Platform
+	}
+}
+
+// geoOverride returns the geo component forced by a request header, when the
+// processor's GeoHeaderPrefix is set
+func (a *AkamaiExtensions) geoOverride(component string, context ProcessContext) string {
+	prefix := a.processor.GetConfig().GeoHeaderPrefix
+	if prefix == "" {
+		return ""
+	}
+	value := strings.TrimSpace(headerValue(context.Headers, prefix+geoOverrideHeaders[component]))
+	if component == "country_code" {
+		value = strings.ToUpper(value)
+	}
+	return value
+}
+
+func (a *AkamaiExtensions) generateVariableDebugOutput(context ProcessContext) string {
 	var output strings.Builder
 	output.WriteString("Variables: ")
 
+	variables := context.scope.visible()
 	for name, value := range a.variables {
+		if _, assigned := variables[name]; !assigned {
+			variables[name] = value
+		}
+	}
+	for name, value := range variables {
 		output.WriteString(fmt.Sprintf("%s=%s ", name, value))
 	}
 
@@ -66,9 +66,15 @@ func TestAkamaiExtensions_ProcessAssign(t *testing.T) {
 		},
 	}
 
+	// Assigned variables are kept in the page scope of each request
+	var scope *variableScope
+	processor.OnAfterProcess(func(result string, context ProcessContext) (string, error) {
+		scope = context.scope
+		return result, nil
+	})
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			processor.akamaiExt.variables = make(map[string]string) // Reset variables
 			context := ProcessContext{
 				Headers: map[string]string{
 					"Host": "example.com",
@@ -88,7 +94,7 @@ func TestAkamaiExtensions_ProcessAssign(t *testing.T) {
 
 			// Check assigned variables
 			for key, expectedValue := range tt.vars {
-				actualValue := processor.akamaiExt.variables[key]
+				actualValue, _ := scope.lookup(key)
 				assert.Equal(t, expectedValue, actualValue, "Variable %s should have value %s", key, expectedValue)
 			}
 		})
@@ -267,6 +273,51 @@ func TestAkamaiExtensions_ProcessFunction(t *testing.T) {
 	}
 }
 
+func TestAkamaiExtensions_ResponseFunctions(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", Debug: false})
+
+	context := ProcessContext{
+		Headers:  map[string]string{"Host": "example.com"},
+		Cookies:  make(map[string]string),
+		Response: NewResponseMeta(),
+	}
+
+	input := `<html><body>` +
+		`<esi:function name="add_header" header="X-Served-By" value="$(HTTP_HOST)"></esi:function>` +
+		`<esi:function name="set_redirect" location="https://$(HTTP_HOST)/new"></esi:function>` +
+		`<p>Content</p></body></html>`
+
+	result, err := processor.Process(input, context)
+	require.NoError(t, err)
+	assert.NotContains(t, result, "esi:function")
+	assert.Contains(t, result, "<p>Content</p>")
+
+	assert.Equal(t, 302, context.Response.StatusCode)
+	assert.Equal(t, "https://example.com/new", context.Response.Headers.Get("Location"))
+	assert.Equal(t, "example.com", context.Response.Headers.Get("X-Served-By"))
+
+	// Repeated headers are all kept, under their canonical name
+	context.Response = NewResponseMeta()
+	_, err = processor.Process(`<esi:function name="add_header" header="Set-Cookie" value="a=1"></esi:function>`+
+		`<esi:function name="add_header" header="set-cookie" value="b=2"></esi:function>`, context)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1", "b=2"}, context.Response.Headers.Values("Set-Cookie"))
+
+	// Only codes that can be written as a response status are set
+	for code, expected := range map[string]int{"404": 404, "200": 200, "599": 599, "99": 0, "600": 0, "1000": 0, "-1": 0} {
+		context.Response = NewResponseMeta()
+		_, err = processor.Process(`<esi:function name="set_response_code" code="`+code+`"></esi:function>`, context)
+		require.NoError(t, err)
+		assert.Equal(t, expected, context.Response.StatusCode, "code %s", code)
+	}
+
+	// Without a response collector the functions are no-ops
+	result, err = processor.Process(`<esi:function name="set_response_code" code="404"></esi:function><p>ok</p>`,
+		ProcessContext{Headers: map[string]string{}, Cookies: map[string]string{}})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>ok</p>")
+}
+
 func TestAkamaiExtensions_ProcessDictionary(t *testing.T) {
 	config := Config{Mode: "akamai", Debug: false}
 	processor := NewProcessor(config)
@@ -538,6 +589,75 @@ func TestAkamaiExtensions_ExpandVariables(t *testing.T) {
 	}
 }
 
+func TestAkamaiExtensions_GeoOverride(t *testing.T) {
+	input := `<esi:vars>$(GEO_COUNTRY_CODE)|$(GEO_COUNTRY_NAME)|$(GEO_REGION)|$(GEO_CITY)</esi:vars>`
+	context := ProcessContext{Headers: map[string]string{
+		"X-Emulator-Geo-Country": "de",
+		"X-Emulator-Geo-City":    "Berlin",
+	}}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+
+		result, err := processor.Process(input, context)
+		require.NoError(t, err)
+		assert.Contains(t, result, "US|United States|California|San Francisco")
+	})
+
+	t.Run("headers override their own component", func(t *testing.T) {
+		processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, GeoHeaderPrefix: "X-Emulator-Geo-"})
+
+		result, err := processor.Process(input, context)
+		require.NoError(t, err)
+		assert.Contains(t, result, "DE|United States|California|Berlin")
+	})
+
+	t.Run("header names are case-insensitive", func(t *testing.T) {
+		processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, GeoHeaderPrefix: "X-Emulator-Geo-"})
+
+		result, err := processor.Process(`<esi:choose><esi:when test="$(GEO_COUNTRY_CODE) == 'FR'">France</esi:when></esi:choose>`,
+			ProcessContext{Headers: map[string]string{"x-emulator-geo-country": "FR"}})
+		require.NoError(t, err)
+		assert.Contains(t, result, "France")
+	})
+}
+
+func TestAkamaiExtensions_DeviceVariables(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+	input := `<esi:choose><esi:when test="$(UA_MOBILE) == 'true'">Mobile</esi:when><esi:otherwise>Desktop</esi:otherwise></esi:choose>` +
+		`<esi:vars>$(UA_BRAND) $(UA_VERSION) on $(UA_PLATFORM)</esi:vars>`
+
+	t.Run("client hints", func(t *testing.T) {
+		context := ProcessContext{
+			Headers: map[string]string{
+				"Sec-Ch-Ua":          `"Chromium";v="120", "Google Chrome";v="120", "Not_A Brand";v="8"`,
+				"Sec-Ch-Ua-Mobile":   "?1",
+				"Sec-Ch-Ua-Platform": `"Android"`,
+			},
+			Response: NewResponseMeta(),
+		}
+
+		result, err := processor.Process(input, context)
+		require.NoError(t, err)
+		assert.Contains(t, result, "Mobile")
+		assert.Contains(t, result, "Google Chrome 120 on Android")
+		assert.Equal(t, "Sec-CH-UA, Sec-CH-UA-Mobile, Sec-CH-UA-Platform", context.Response.Headers.Get("Accept-CH"))
+	})
+
+	t.Run("user agent fallback", func(t *testing.T) {
+		context := ProcessContext{
+			Headers: map[string]string{
+				"User-Agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7; rv:121.0) Gecko/20100101 Firefox/121.0",
+			},
+		}
+
+		result, err := processor.Process(input, context)
+		require.NoError(t, err)
+		assert.Contains(t, result, "Desktop")
+		assert.Contains(t, result, "Firefox 121 on macOS")
+	})
+}
+
 func TestAkamaiExtensions_GetUserAgentComponent(t *testing.T) {
 	config := Config{Mode: "akamai", Debug: false}
 	processor := NewProcessor(config)
@@ -0,0 +1,199 @@
+package esi
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// NodeType identifies the kind of a template node
+type NodeType int
+
+// Template node types
+const (
+	TextNode         NodeType = iota // Markup outside ESI elements, kept as written
+	ElementNode                      // An ESI element
+	CommentBlockNode                 // An <!--esi ... --> block
+)
+
+// bareESIElements are the ESI elements also recognised without the esi: prefix
+var bareESIElements = map[string]bool{
+	"include": true, "choose": true, "when": true, "otherwise": true,
+	"try": true, "attempt": true, "except": true, "vars": true,
+	"comment": true, "remove": true, "assign": true, "eval": true,
+	"function": true, "dictionary": true, "debug": true, "lookup": true,
+	"parallel": true, "sequential": true,
+}
+
+// Node is a node of a parsed ESI template
+type Node struct {
+	Type     NodeType
+	Name     string           // Element name as written, such as esi:include
+	Attr     []html.Attribute // Element attributes, unescaped
+	Text     string           // Raw markup of text nodes and comment blocks
+	Line     int              // Line the node starts on, from 1
+	Children []*Node          // Content of elements and comment blocks
+
+	startTag string // Raw tags, to write out elements the mode does not process
+	endTag   string
+}
+
+// Template is an ESI template parsed once and executed for any number of requests.
+// Templates are not modified by execution and are safe for concurrent use.
+type Template struct {
+	Nodes []*Node
+}
+
+// ParseError is a template that cannot be tokenized
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseTemplate parses an ESI template. ESI elements, including those in <!--esi -->
+// blocks, become element nodes nested as written; all other markup is kept verbatim
+// in text nodes. Self-closing ESI elements are empty and unclosed ones run to the
+// end of their parent.
+func ParseTemplate(content string) (*Template, error) {
+	nodes, err := parseNodes(content, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Nodes: nodes}, nil
+}
+
+// parseNodes parses content that starts at line
+func parseNodes(content string, line int) ([]*Node, error) {
+	root := &Node{}
+	open := []*Node{root}
+	var text *Node // Text node being extended, and where its markup starts
+	var textStart, offset int
+
+	appendNode := func(n *Node) {
+		parent := open[len(open)-1]
+		parent.Children = append(parent.Children, n)
+	}
+
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if err := tokenizer.Err(); err != io.EOF {
+				return nil, &ParseError{Line: line, Err: err}
+			}
+			return root.Children, nil
+		}
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+
+		switch {
+		case (tokenType == html.StartTagToken || tokenType == html.SelfClosingTagToken) && isESIElement(token.Data):
+			node := &Node{Type: ElementNode, Name: token.Data, Attr: token.Attr, Line: line, startTag: raw}
+			appendNode(node)
+			if tokenType == html.StartTagToken {
+				open = append(open, node)
+			}
+			text = nil
+		case tokenType == html.EndTagToken && isESIElement(token.Data):
+			// Close the element and any ESI element left open inside it; stray end tags are dropped
+			for i := len(open) - 1; i > 0; i-- {
+				if open[i].Name == token.Data {
+					open[i].endTag = raw
+					open = open[:i]
+					break
+				}
+			}
+			text = nil
+		case tokenType == html.CommentToken && strings.HasPrefix(token.Data, "esi"):
+			inner := strings.TrimPrefix(token.Data, "esi")
+			trimmed := strings.TrimSpace(inner)
+			children, err := parseNodes(trimmed, line+strings.Count(inner[:strings.Index(inner, trimmed)], "\n"))
+			if err != nil {
+				return nil, err
+			}
+			appendNode(&Node{Type: CommentBlockNode, Text: raw, Line: line, Children: children})
+			text = nil
+		default:
+			if text == nil {
+				text = &Node{Type: TextNode, Line: line}
+				textStart = offset
+				appendNode(text)
+			}
+			text.Text = content[textStart : offset+len(raw)]
+		}
+
+		line += strings.Count(raw, "\n")
+		offset += len(raw)
+	}
+}
+
+// isESIElement reports whether a tag name is an ESI element
+func isESIElement(name string) bool {
+	return strings.HasPrefix(name, "esi:") || bareESIElements[name]
+}
+
+// Walk calls fn for every node in document order, descending into the children of
+// the nodes for which fn returns true
+func (t *Template) Walk(fn func(n *Node) bool) {
+	walkNodes(t.Nodes, fn)
+}
+
+func walkNodes(nodes []*Node, fn func(n *Node) bool) {
+	for _, n := range nodes {
+		if fn(n) {
+			walkNodes(n.Children, fn)
+		}
+	}
+}
+
+// ESIName returns the name of an element without its esi: prefix
+func (n *Node) ESIName() string {
+	return strings.TrimPrefix(n.Name, "esi:")
+}
+
+// GetAttr returns the value of an attribute and whether it is set
+func (n *Node) GetAttr(name string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// TextContent returns the text of the node and its descendants without markup
+func (n *Node) TextContent() string {
+	var text strings.Builder
+	walkNodes([]*Node{n}, func(node *Node) bool {
+		if node.Type == TextNode {
+			tokenizer := html.NewTokenizer(strings.NewReader(node.Text))
+			for tokenType := tokenizer.Next(); tokenType != html.ErrorToken; tokenType = tokenizer.Next() {
+				if tokenType == html.TextToken {
+					text.WriteString(tokenizer.Token().Data)
+				}
+			}
+		}
+		return node.Type == ElementNode || node == n
+	})
+	return text.String()
+}
+
+// selection returns the element as a goquery selection for the Akamai extension handlers
+func (n *Node) selection() *goquery.Selection {
+	element := &html.Node{Type: html.ElementNode, Data: n.Name, Attr: n.Attr}
+	if text := n.TextContent(); text != "" {
+		element.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	}
+	return goquery.NewDocumentFromNode(element).Selection
+}
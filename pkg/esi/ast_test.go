@@ -0,0 +1,101 @@
+package esi
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplate(t *testing.T) {
+	template, err := ParseTemplate("<p>Header</p>\n" +
+		"<esi:assign name=\"tier\" value=\"'gold'\" />\n" +
+		"<esi:choose>\n" +
+		"  <esi:when test=\"$(tier) == 'gold'\"><esi:include src=\"/gold\"/></esi:when>\n" +
+		"  <esi:otherwise>Standard</esi:otherwise>\n" +
+		"</esi:choose>\n" +
+		"<!--esi\n<esi:vars>$(HTTP_HOST)</esi:vars>\n-->")
+	require.NoError(t, err)
+
+	var elements []string
+	template.Walk(func(n *Node) bool {
+		switch n.Type {
+		case ElementNode:
+			elements = append(elements, fmt.Sprintf("%s@%d", n.Name, n.Line))
+		case CommentBlockNode:
+			elements = append(elements, fmt.Sprintf("<!--esi-->@%d", n.Line))
+		}
+		return true
+	})
+	assert.Equal(t, []string{
+		"esi:assign@2", "esi:choose@3", "esi:when@4", "esi:include@4", "esi:otherwise@5",
+		"<!--esi-->@7", "esi:vars@8",
+	}, elements)
+
+	require.Len(t, template.Nodes, 6)
+	assert.Equal(t, TextNode, template.Nodes[0].Type)
+	assert.Equal(t, "<p>Header</p>\n", template.Nodes[0].Text)
+
+	assign := template.Nodes[1]
+	assert.Equal(t, "assign", assign.ESIName())
+	assert.Empty(t, assign.Children)
+	value, ok := assign.GetAttr("value")
+	assert.True(t, ok)
+	assert.Equal(t, "'gold'", value)
+
+	when := template.Nodes[3].Children[1]
+	require.Len(t, when.Children, 1)
+	assert.Equal(t, "esi:include", when.Children[0].Name)
+}
+
+func TestParseTemplate_Malformed(t *testing.T) {
+	t.Run("unclosed elements run to the end of their parent", func(t *testing.T) {
+		template, err := ParseTemplate(`<esi:try><esi:attempt>a<esi:vars>b</esi:attempt><esi:except>c</esi:except></esi:try>d`)
+		require.NoError(t, err)
+
+		require.Len(t, template.Nodes, 2)
+		try := template.Nodes[0]
+		require.Len(t, try.Children, 2)
+		assert.Equal(t, "esi:attempt", try.Children[0].Name)
+		assert.Equal(t, "esi:vars", try.Children[0].Children[1].Name)
+		assert.Equal(t, "esi:except", try.Children[1].Name)
+		assert.Equal(t, "d", template.Nodes[1].Text)
+	})
+
+	t.Run("stray end tags are dropped", func(t *testing.T) {
+		template, err := ParseTemplate(`a</esi:choose>b`)
+		require.NoError(t, err)
+
+		require.Len(t, template.Nodes, 2)
+		assert.Equal(t, "a", template.Nodes[0].Text)
+		assert.Equal(t, "b", template.Nodes[1].Text)
+	})
+}
+
+func TestParseTemplate_KeepsMarkup(t *testing.T) {
+	markup := "<!DOCTYPE html>\n<html><head><script>if (a < b) { x = '<esi:include src=\"/no\"/>'; }</script></head>\n" +
+		"<body class=\"x\">Tom &amp; Jerry<!-- note --><br/></body></html>"
+
+	template, err := ParseTemplate(markup)
+	require.NoError(t, err)
+
+	require.Len(t, template.Nodes, 1)
+	assert.Equal(t, markup, template.Nodes[0].Text)
+}
+
+func TestNode_TextContent(t *testing.T) {
+	template, err := ParseTemplate(`<esi:assign name="x"><b>Tom</b> &amp; <esi:vars>Jerry</esi:vars></esi:assign>`)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Tom & Jerry", template.Nodes[0].TextContent())
+}
+
+func TestProcessor_IncludeErrorLine(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", Debug: true, MaxIncludes: 10, BaseURL: "http://127.0.0.1:1"})
+
+	result, err := processor.Process("<p>a</p>\n\n<esi:include src=\"/missing\" />", ProcessContext{})
+	require.NoError(t, err)
+
+	assert.Contains(t, result, "ESI include error at line 3")
+}
@@ -0,0 +1,76 @@
+package esi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBudgetExceeded is returned for includes that were not fetched, or whose fetch was
+// cut short, because the page's processing budget was spent
+var ErrBudgetExceeded = errors.New("processing budget exceeded")
+
+// processingBudget is the wall-clock time left for processing a page and its fragments.
+// Once the deadline passes no new include fetches are issued: includes are answered
+// from the fragment cache or fail, so alt and esi:except take over.
+type processingBudget struct {
+	deadline time.Time
+	exceeded atomic.Bool // An include was substituted because the budget was spent
+}
+
+// newBudget starts the processing budget of a page, or returns nil when
+// Config.MaxProcessingTime is zero
+func (p *Processor) newBudget(start time.Time) *processingBudget {
+	if p.config.MaxProcessingTime <= 0 {
+		return nil
+	}
+	return &processingBudget{deadline: start.Add(p.config.MaxProcessingTime)}
+}
+
+// spent reports whether the deadline has passed, flagging the page as truncated when
+// it has; a nil budget is never spent
+func (b *processingBudget) spent() bool {
+	if b == nil || time.Now().Before(b.deadline) {
+		return false
+	}
+	b.exceeded.Store(true)
+	return true
+}
+
+// truncated reports whether an include was substituted because the budget was spent
+func (b *processingBudget) truncated() bool {
+	return b != nil && b.exceeded.Load()
+}
+
+// fetchContext returns the context of an include fetch, cancelled at the deadline
+func (b *processingBudget) fetchContext() (context.Context, context.CancelFunc) {
+	if b == nil {
+		return context.Background(), func() {}
+	}
+	return context.WithDeadline(context.Background(), b.deadline)
+}
+
+// budgetError returns ErrBudgetExceeded when err was caused by the deadline passing
+// during the fetch of url
+func (b *processingBudget) budgetError(url string, err error) error {
+	if b == nil || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	b.exceeded.Store(true)
+	return fmt.Errorf("fetch of %s cut short: %w", url, ErrBudgetExceeded)
+}
+
+// recordTruncated counts a page truncated by its processing budget and flags its response
+func (p *Processor) recordTruncated(context ProcessContext) {
+	p.stats.truncated.Add(1)
+
+	if context.Response != nil {
+		context.Response.Truncated = true
+	}
+	if p.debugEnabled() {
+		fmt.Printf("⏱️  Processing budget of %s exceeded, includes substituted%s\n",
+			p.config.MaxProcessingTime, requestIDLabel(context))
+	}
+}
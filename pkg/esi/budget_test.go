@@ -0,0 +1,83 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessor_MaxProcessingTime(t *testing.T) {
+	var mutex sync.Mutex
+	fetched := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		fetched[r.URL.Path]++
+		mutex.Unlock()
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5,
+		Cache: CacheConfig{Enabled: true, TTL: 60}, MaxProcessingTime: 50 * time.Millisecond})
+
+	// Warm the cache with the fragment answered after the budget is spent
+	_, err := processor.Process(`<esi:include src="/cached"/>`, ProcessContext{BaseURL: server.URL})
+	require.NoError(t, err)
+
+	context := ProcessContext{BaseURL: server.URL, Response: NewResponseMeta()}
+	start := time.Now()
+	result, err := processor.Process(`<esi:include src="/slow" onerror="continue"/>`+
+		`<esi:include src="/cached"/>`+
+		`<esi:try><esi:attempt><esi:include src="/late"/></esi:attempt>`+
+		`<esi:except><p>fallback</p></esi:except></esi:try>`, context)
+	require.NoError(t, err)
+
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.NotContains(t, result, "<p>/slow</p>")
+	assert.Contains(t, result, "<p>/cached</p>")
+	assert.Contains(t, result, "<p>fallback</p>")
+	mutex.Lock()
+	assert.Zero(t, fetched["/late"])
+	assert.Equal(t, 1, fetched["/cached"])
+	mutex.Unlock()
+	assert.True(t, context.Response.Truncated)
+	assert.Equal(t, int64(1), processor.GetStats().Truncated)
+}
+
+func TestProcessor_MaxProcessingTimeNotSpent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>fragment</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, MaxProcessingTime: time.Second})
+	context := ProcessContext{BaseURL: server.URL, Response: NewResponseMeta()}
+	result, err := processor.Process(`<esi:include src="/a"/>`, context)
+	require.NoError(t, err)
+
+	assert.Contains(t, result, "<p>fragment</p>")
+	assert.False(t, context.Response.Truncated)
+	assert.Zero(t, processor.GetStats().Truncated)
+}
+
+func TestProcessingBudget_Spent(t *testing.T) {
+	var unlimited *processingBudget
+	assert.False(t, unlimited.spent())
+	assert.False(t, unlimited.truncated())
+
+	budget := &processingBudget{deadline: time.Now().Add(time.Hour)}
+	assert.False(t, budget.spent())
+	assert.False(t, budget.truncated())
+
+	budget = &processingBudget{deadline: time.Now().Add(-time.Millisecond)}
+	assert.True(t, budget.spent())
+	assert.True(t, budget.truncated())
+}
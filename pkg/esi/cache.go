@@ -0,0 +1,145 @@
+package esi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheEntryInfo describes a cached fragment without its content
+type CacheEntryInfo struct {
+	Key          string            `json:"key"`
+	Size         int               `json:"size"`         // Content size in bytes
+	TTLRemaining float64           `json:"ttlRemaining"` // Seconds until the entry expires, zero once expired
+	Expired      bool              `json:"expired"`
+	StoredAt     time.Time         `json:"storedAt"`
+	ExpiresAt    time.Time         `json:"expiresAt"`
+	Hits         int64             `json:"hits"`
+	Vary         map[string]string `json:"vary,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified string            `json:"lastModified,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	SoftPurged   bool              `json:"softPurged,omitempty"` // Marked stale by a soft purge
+}
+
+// GetCacheEntries describes the cached fragments ordered by key. Expired entries are
+// listed until they are replaced or the cache is cleared.
+func (p *Processor) GetCacheEntries() []CacheEntryInfo {
+	now := time.Now()
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	entries := make([]CacheEntryInfo, 0, len(p.cache))
+	for key, entry := range p.cache {
+		entries = append(entries, cacheEntryInfo(key, entry, now))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// GetCacheEntry describes the fragment cached under key and returns its content
+func (p *Processor) GetCacheEntry(key string) (CacheEntryInfo, string, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	entry, exists := p.cache[key]
+	if !exists {
+		return CacheEntryInfo{}, "", false
+	}
+	return cacheEntryInfo(key, entry, time.Now()), entry.Content, true
+}
+
+// cacheEntryInfo describes entry as of now
+func cacheEntryInfo(key string, entry CacheEntry, now time.Time) CacheEntryInfo {
+	info := CacheEntryInfo{
+		Key:       key,
+		Size:      len(entry.Content),
+		StoredAt:  entry.StoredAt,
+		ExpiresAt: entry.ExpiresAt,
+		Hits:      entry.Hits,
+		Vary:      entry.Vary,
+
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+		Tags:         entry.Tags,
+		SoftPurged:   entry.SoftPurged,
+	}
+	if remaining := entry.ExpiresAt.Sub(now); remaining > 0 {
+		info.TTLRemaining = remaining.Seconds()
+	} else {
+		info.Expired = true
+	}
+	return info
+}
+
+// cacheKey returns the key a fragment is cached under: its URL, followed by the headers
+// the include sets, such as setheader="X-User: $(HTTP_COOKIE{uid})". Headers set from
+// personalization variables then keep users from sharing a fragment whatever the
+// fragment's Vary header says.
+func cacheKey(resolvedURL string, headers map[string]string) string {
+	if len(headers) == 0 {
+		return resolvedURL
+	}
+	variant := make([]string, 0, len(headers))
+	for name, value := range headers {
+		variant = append(variant, http.CanonicalHeaderKey(name)+": "+value)
+	}
+	sort.Strings(variant)
+	return resolvedURL + " [" + strings.Join(variant, "; ") + "]"
+}
+
+// varyValues returns the values request had for the headers named by the Vary header
+// of response, or nil when the response does not vary
+func varyValues(response, request http.Header) map[string]string {
+	var values map[string]string
+	for _, vary := range response.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[name] = request.Get(name)
+		}
+	}
+	return values
+}
+
+// setValidators makes req a conditional request for the content of a stale entry
+func setValidators(req *http.Request, stale CacheEntry) {
+	if stale.ETag != "" {
+		req.Header.Set("If-None-Match", stale.ETag)
+	}
+	if stale.LastModified != "" {
+		req.Header.Set("If-Modified-Since", stale.LastModified)
+	}
+}
+
+// refreshCacheEntry renews a stale entry confirmed by a 304 Not Modified response
+// for another TTL and returns its content. Validators sent with the 304 replace the
+// stored ones.
+func (p *Processor) refreshCacheEntry(key string, stale CacheEntry, header http.Header) string {
+	stale.ExpiresAt = time.Now().Add(time.Duration(p.config.Cache.TTL) * time.Second)
+	stale.SoftPurged = false
+	if etag := header.Get("ETag"); etag != "" {
+		stale.ETag = etag
+	}
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		stale.LastModified = lastModified
+	}
+
+	p.mutex.Lock()
+	p.cache[key] = stale
+	p.mutex.Unlock()
+
+	p.stats.notModified.Add(1)
+	return stale.Content
+}
+
+// incrementRevalidations counts a conditional refetch of an expired entry
+func (p *Processor) incrementRevalidations() {
+	p.stats.revalidations.Add(1)
+}
@@ -0,0 +1,106 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache_Revalidation(t *testing.T) {
+	var conditional []string
+	bodies := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		w.Header().Set("Last-Modified", "Mon, 05 Oct 2026 10:00:00 GMT")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("ETag", `"v1"`)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		bodies++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("<p>fragment</p>"))
+	}))
+	defer server.Close()
+
+	// A zero TTL expires entries at once, so every later fetch revalidates
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Cache: CacheConfig{Enabled: true}})
+	template := `<esi:include src="` + server.URL + `/f"/>`
+	for i := 0; i < 3; i++ {
+		result, err := processor.Process(template, ProcessContext{})
+		require.NoError(t, err)
+		assert.Contains(t, result, "<p>fragment</p>")
+	}
+
+	assert.Equal(t, 1, bodies)
+	assert.Equal(t, []string{
+		"|",
+		`"v1"|Mon, 05 Oct 2026 10:00:00 GMT`,
+		`"v1"|Mon, 05 Oct 2026 10:00:00 GMT`,
+	}, conditional)
+
+	stats := processor.GetStats()
+	assert.Equal(t, int64(2), stats.Revalidations)
+	assert.Equal(t, int64(2), stats.NotModified)
+
+	info, _, exists := processor.GetCacheEntry(server.URL + "/f")
+	require.True(t, exists)
+	assert.Equal(t, `"v1"`, info.ETag)
+}
+
+func TestCache_RevalidationChangedContent(t *testing.T) {
+	version := "v1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"`+version+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"`+version+`"`)
+		w.Write([]byte("<p>" + version + "</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Cache: CacheConfig{Enabled: true}})
+	template := `<esi:include src="` + server.URL + `/f"/>`
+	_, err := processor.Process(template, ProcessContext{})
+	require.NoError(t, err)
+
+	version = "v2"
+	result, err := processor.Process(template, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>v2</p>")
+
+	stats := processor.GetStats()
+	assert.Equal(t, int64(1), stats.Revalidations)
+	assert.Equal(t, int64(0), stats.NotModified)
+}
+
+func TestCache_VariantKeys(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte("<p>Hello " + r.Header.Get("X-User") + "</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Cache: CacheConfig{Enabled: true, TTL: 60}})
+	template := `<esi:include src="` + server.URL + `/greeting" setheader="X-User: $(HTTP_COOKIE{uid})"/>`
+
+	for _, uid := range []string{"alice", "bob", "alice"} {
+		result, err := processor.Process(template, ProcessContext{Cookies: map[string]string{"uid": uid}})
+		require.NoError(t, err)
+		assert.Contains(t, result, "<p>Hello "+uid+"</p>")
+	}
+	assert.Equal(t, 2, fetches)
+
+	_, content, exists := processor.GetCacheEntry(server.URL + "/greeting [X-User: bob]")
+	require.True(t, exists)
+	assert.Equal(t, "<p>Hello bob</p>", content)
+
+	assert.Equal(t, "https://a/f", cacheKey("https://a/f", nil))
+	assert.Equal(t, "https://a/f [Accept-Language: de; X-User: 1]",
+		cacheKey("https://a/f", map[string]string{"x-user": "1", "Accept-Language": "de"}))
+}
@@ -0,0 +1,97 @@
+package esi
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// byteOrderMark is the byte order mark as it appears in decoded text
+const byteOrderMark = "\uFEFF"
+
+// DetectCharset returns the canonical name of an HTML document's charset, taken from
+// its byte order mark, the charset parameter of contentType or a meta declaration, in
+// that order. Undeclared documents are utf-8 when they are valid UTF-8 and
+// windows-1252 otherwise, as browsers assume.
+func DetectCharset(content []byte, contentType string) string {
+	_, name, _ := charset.DetermineEncoding(content, contentType)
+	return name
+}
+
+// DecodeHTML transcodes an HTML document to UTF-8 and returns it with the name of
+// its detected charset. A byte order mark is kept as U+FEFF.
+func DecodeHTML(content []byte, contentType string) (string, string, error) {
+	enc, name, _ := charset.DetermineEncoding(content, contentType)
+	if name == "utf-8" {
+		return string(content), name, nil
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return "", name, fmt.Errorf("failed to decode %s content: %w", name, err)
+	}
+	return string(decoded), name, nil
+}
+
+// EncodeHTML encodes UTF-8 HTML to the named charset. Characters the charset cannot
+// represent are written as numeric character references.
+func EncodeHTML(html, charsetName string) ([]byte, error) {
+	if charsetName == "" || strings.EqualFold(charsetName, "utf-8") {
+		return []byte(html), nil
+	}
+
+	enc, _ := charset.Lookup(charsetName)
+	if enc == nil {
+		return nil, fmt.Errorf("unsupported charset %q", charsetName)
+	}
+	encoded, err := encoding.HTMLEscapeUnsupported(enc.NewEncoder()).Bytes([]byte(html))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode content as %s: %w", charsetName, err)
+	}
+	return encoded, nil
+}
+
+// ProcessBytes processes an HTML document in any charset the browser would accept.
+// The document is transcoded to UTF-8 for processing and the output is encoded back
+// to the document's charset, which is returned alongside it. Documents ShouldProcess
+// rejects are returned untouched, with an empty charset.
+func (p *Processor) ProcessBytes(content []byte, contentType string, context ProcessContext) ([]byte, string, error) {
+	if !p.ShouldProcess(contentType, context) {
+		p.passThrough()
+		return content, "", nil
+	}
+
+	html, name, err := DecodeHTML(content, contentType)
+	if err != nil {
+		p.incrementErrors()
+		return content, name, err
+	}
+
+	bom := strings.HasPrefix(html, byteOrderMark)
+	result, err := p.Process(strings.TrimPrefix(html, byteOrderMark), context)
+	if err != nil {
+		return content, name, err
+	}
+	if bom {
+		result = byteOrderMark + result
+	}
+
+	output, err := EncodeHTML(result, name)
+	if err != nil {
+		p.incrementErrors()
+		return content, name, err
+	}
+	return output, name, nil
+}
+
+// decodeFragment transcodes a fetched include to UTF-8 using its Content-Type and
+// meta declaration, dropping any byte order mark so it can be inserted mid-page
+func decodeFragment(body []byte, contentType string) (string, error) {
+	content, _, err := DecodeHTML(body, contentType)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(content, byteOrderMark), nil
+}
@@ -0,0 +1,77 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		expected    string
+	}{
+		{name: "utf-8 bom", content: "\xef\xbb\xbf<p>a</p>", contentType: "text/html; charset=iso-8859-1", expected: "utf-8"},
+		{name: "content type", content: "<p>caf\xe9</p>", contentType: "text/html; charset=ISO-8859-1", expected: "windows-1252"},
+		{name: "meta charset", content: `<meta charset="shift_jis"><p>a</p>`, expected: "shift_jis"},
+		{name: "meta http-equiv", content: `<meta http-equiv="Content-Type" content="text/html; charset=euc-jp">`, expected: "euc-jp"},
+		{name: "undeclared utf-8", content: "<p>café</p>", expected: "utf-8"},
+		{name: "undeclared latin-1", content: "<p>caf\xe9</p>", expected: "windows-1252"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DetectCharset([]byte(tt.content), tt.contentType))
+		})
+	}
+}
+
+func TestProcessBytes_PreservesCharset(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+
+	// "caf\xe9" is café in ISO-8859-1
+	latin1 := []byte("<html><head><meta charset=\"iso-8859-1\"></head><body><p>caf\xe9</p><esi:remove>x</esi:remove></body></html>")
+	output, name, err := processor.ProcessBytes(latin1, "", ProcessContext{})
+	require.NoError(t, err)
+	assert.Equal(t, "windows-1252", name)
+	assert.Contains(t, string(output), "<p>caf\xe9</p>")
+	assert.NotContains(t, string(output), "esi:remove")
+
+	// "\x93\xfa\x96\x7b" is 日本 in Shift_JIS
+	sjis := []byte("<p>\x93\xfa\x96\x7b</p>")
+	output, name, err = processor.ProcessBytes(sjis, "text/html; charset=Shift_JIS", ProcessContext{})
+	require.NoError(t, err)
+	assert.Equal(t, "shift_jis", name)
+	assert.Contains(t, string(output), "<p>\x93\xfa\x96\x7b</p>")
+
+	output, _, err = processor.ProcessBytes([]byte("\xef\xbb\xbf<p>a</p><esi:comment text=\"x\"/>"), "", ProcessContext{})
+	require.NoError(t, err)
+	assert.Equal(t, "\xef\xbb\xbf<html><head></head><body><p>a</p></body></html>", string(output))
+}
+
+func TestEncodeHTML_Unsupported(t *testing.T) {
+	encoded, err := EncodeHTML("<p>日本 café</p>", "iso-8859-1")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>&#26085;&#26412; caf\xe9</p>", string(encoded))
+
+	_, err = EncodeHTML("<p>a</p>", "klingon")
+	assert.ErrorContains(t, err, "unsupported charset")
+}
+
+func TestProcessor_IncludeCharset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write([]byte("<span>na\xefve</span>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	result, err := processor.Process(`<esi:include src="`+server.URL+`/f"/>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<span>naïve</span>")
+}
@@ -0,0 +1,112 @@
+package esi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esigen"
+)
+
+// Body types of POST beacons
+const (
+	BodyTypeForm = "form"
+	BodyTypeJSON = "json"
+)
+
+// bodyContentTypes maps body types to the Content-Type sent with the beacon
+var bodyContentTypes = map[string]string{
+	BodyTypeForm: "application/x-www-form-urlencoded",
+	BodyTypeJSON: "application/json",
+}
+
+// beaconRequest is the request of a beacon after macro substitution
+type beaconRequest struct {
+	method  string
+	body    string
+	headers map[string]string
+}
+
+// buildBeaconRequest validates a pixel's method, body and headers and substitutes their
+// macros. POST beacons get a Content-Type for their BODY_TYPE unless they set one.
+func buildBeaconRequest(pixel Pixel, config ESIConfig) (beaconRequest, error) {
+	method := strings.ToUpper(pixel.METHOD)
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodPost {
+		return beaconRequest{}, fmt.Errorf("unsupported beacon method %q", pixel.METHOD)
+	}
+	if method == http.MethodGet && pixel.BODY != "" {
+		return beaconRequest{}, fmt.Errorf("GET beacons cannot have a BODY")
+	}
+
+	request := beaconRequest{method: method, headers: make(map[string]string, len(pixel.HEADERS)+1)}
+	for name, value := range pixel.HEADERS {
+		processed, err := processMacros(value, config)
+		if err != nil {
+			return beaconRequest{}, fmt.Errorf("error processing macros in header %s: %w", name, err)
+		}
+		request.headers[http.CanonicalHeaderKey(name)] = processed
+	}
+
+	if method == http.MethodPost {
+		bodyType := strings.ToLower(pixel.BODY_TYPE)
+		if bodyType == "" {
+			bodyType = BodyTypeForm
+		}
+		contentType, ok := bodyContentTypes[bodyType]
+		if !ok {
+			return beaconRequest{}, fmt.Errorf("unsupported BODY_TYPE %q", pixel.BODY_TYPE)
+		}
+		if _, exists := request.headers["Content-Type"]; !exists {
+			request.headers["Content-Type"] = contentType
+		}
+
+		if pixel.BODY != "" {
+			body, err := processMacros(pixel.BODY, config)
+			if err != nil {
+				return beaconRequest{}, fmt.Errorf("error processing macros in body: %w", err)
+			}
+			request.body = body
+		}
+	}
+
+	return request, nil
+}
+
+// beaconRequestAttributes returns the extended esi:include attributes of a pixel's request:
+// method, entity (the body template after macro substitution) and setheader.
+// GET beacons without headers have none.
+func beaconRequestAttributes(pixel Pixel, config ESIConfig) (esigen.Attributes, error) {
+	request, err := buildBeaconRequest(pixel, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes esigen.Attributes
+	if request.method == http.MethodPost {
+		attributes.Set("method", http.MethodPost)
+		if request.body != "" {
+			attributes.Set("entity", request.body)
+		}
+	}
+
+	if len(request.headers) > 0 {
+		names := make([]string, 0, len(request.headers))
+		for name := range request.headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		lines := make([]string, len(names))
+		for i, name := range names {
+			lines[i] = name + ": " + request.headers[name]
+		}
+		// One header per line, as expected by the processor's setheader handling
+		attributes.Set("setheader", strings.Join(lines, "\n"))
+	}
+
+	return attributes, nil
+}
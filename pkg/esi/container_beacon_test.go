@@ -0,0 +1,103 @@
+package esi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeaconRequestAttributes(t *testing.T) {
+	tests := []struct {
+		name        string
+		pixel       Pixel
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "plain GET beacon",
+			pixel:    Pixel{ID: "p1", URL: "https://example.com/p.gif"},
+			expected: "",
+		},
+		{
+			name:     "GET beacon with headers",
+			pixel:    Pixel{ID: "p1", URL: "https://example.com/p.gif", HEADERS: map[string]string{"x-partner": "acme"}},
+			expected: ` setheader="X-Partner: acme"`,
+		},
+		{
+			name:     "form POST beacon",
+			pixel:    Pixel{ID: "p1", URL: "https://example.com/collect", METHOD: "post", BODY: "evid=~~evid~~&t=~~r~~"},
+			expected: ` method="POST" entity="evid=$(PMUSER_EVID)&amp;t=$(TIME)" setheader="Content-Type: application/x-www-form-urlencoded"`,
+		},
+		{
+			name: "JSON POST beacon with headers",
+			pixel: Pixel{ID: "p1", URL: "https://example.com/collect", METHOD: "POST", BODY_TYPE: "json",
+				BODY: `{"uid":"~~c~uid~~"}`, HEADERS: map[string]string{"X-Country": "~~cc~~"}},
+			expected: ` method="POST" entity="{&quot;uid&quot;:&quot;$(HTTP_COOKIE{uid})&quot;}" setheader="Content-Type: application/json&#10;X-Country: $(GEO_COUNTRY)"`,
+		},
+		{
+			name:     "explicit content type wins",
+			pixel:    Pixel{ID: "p1", URL: "https://example.com/collect", METHOD: "POST", BODY_TYPE: "json", HEADERS: map[string]string{"Content-Type": "text/plain"}},
+			expected: ` method="POST" setheader="Content-Type: text/plain"`,
+		},
+		{
+			name:        "unsupported method",
+			pixel:       Pixel{ID: "p1", URL: "https://example.com/collect", METHOD: "PUT"},
+			expectError: true,
+		},
+		{
+			name:        "GET with body",
+			pixel:       Pixel{ID: "p1", URL: "https://example.com/collect", BODY: "a=b"},
+			expectError: true,
+		},
+		{
+			name:        "unsupported body type",
+			pixel:       Pixel{ID: "p1", URL: "https://example.com/collect", METHOD: "POST", BODY_TYPE: "xml"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attributes, err := beaconRequestAttributes(tt.pixel, ESIConfig{})
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, attributes.String())
+		})
+	}
+}
+
+func TestPOSTBeacon_ThroughProcessor(t *testing.T) {
+	var method, body, contentType, partner string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, body = r.Method, string(data)
+		contentType, partner = r.Header.Get("Content-Type"), r.Header.Get("X-Partner")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	pixel := Pixel{ID: "p1", TYPE: "dir", URL: server.URL + "/collect", METHOD: "POST", BODY_TYPE: "json",
+		BODY: `{"event":"view","uid":"~~c~uid~~"}`, HEADERS: map[string]string{"X-Partner": "~~c~partner~~"}}
+	include, err := generateESIInclude(pixel, ESIConfig{})
+	require.NoError(t, err)
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 3})
+	result, err := processor.Process("<html><body>"+include+"</body></html>", ProcessContext{
+		Headers: map[string]string{},
+		Cookies: map[string]string{"uid": "u-123", "partner": "acme"},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, result, "ok")
+	assert.Equal(t, http.MethodPost, method)
+	assert.Equal(t, `{"event":"view","uid":"u-123"}`, body)
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, "acme", partner)
+}
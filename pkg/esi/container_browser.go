@@ -0,0 +1,192 @@
+package esi
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Browser JSON schema: the manifest of browser-executed pixels and the version of its layout
+const (
+	BrowserSchema        = "esi-container/browser-pixels"
+	BrowserSchemaVersion = 1
+)
+
+// Injection methods of browser-executed pixels
+const (
+	InjectIframe = "iframe" // frm pixels: a hidden iframe
+	InjectScript = "script" // script pixels: their SCRIPT inline, or else a script loading the URL
+	InjectImage  = "img"    // img pixels: an image request
+)
+
+// DefaultBrowserTimeout is how long, in milliseconds, the loader lets a browser pixel
+// run before removing it
+const DefaultBrowserTimeout = 5000
+
+// browserInjection maps the browser pixel types to their injection method
+var browserInjection = map[string]string{
+	"frm":    InjectIframe,
+	"script": InjectScript,
+	"img":    InjectImage,
+}
+
+// BrowserManifest is the browser JSON: the pixels left for the browser, each with the
+// instructions the loader executes it with
+type BrowserManifest struct {
+	Schema  string         `json:"schema"`
+	Version int            `json:"version"`
+	Pixels  []BrowserPixel `json:"pixels"`
+}
+
+// BrowserPixel is a browser-executed pixel. The pixel's configuration is kept as is,
+// next to its execution instructions.
+type BrowserPixel struct {
+	Pixel
+	Execution BrowserExecution `json:"execution"`
+}
+
+// BrowserExecution tells the loader how and when to execute a pixel
+type BrowserExecution struct {
+	Inject string `json:"inject"`
+	// Priority orders the pixels, highest first; the manifest lists them in that order
+	Priority  int             `json:"priority"`
+	TimeoutMS int             `json:"timeoutMs"`
+	Consent   *BrowserConsent `json:"consent,omitempty"`
+}
+
+// BrowserConsent is the consent a pixel needs, checked by the loader before it fires.
+// TCF requirements only hold back the pixel where the page's CMP says TCF applies.
+type BrowserConsent struct {
+	// Cookie must have Value
+	Cookie      string `json:"cookie,omitempty"`
+	Value       string `json:"value,omitempty"`
+	TCFVendor   int    `json:"tcfVendor,omitempty"`
+	TCFPurposes []int  `json:"tcfPurposes,omitempty"`
+	// USPrivacy holds the pixel back when the us_privacy cookie opts out of sale
+	USPrivacy bool `json:"usPrivacy,omitempty"`
+}
+
+// BuildBrowserManifest builds the browser JSON of the browser-executed pixels returned
+// by ProcessContainerConfig or GenerateEdgeScript
+func BuildBrowserManifest(browserConfig ContainerConfig, esiConfig ESIConfig) BrowserManifest {
+	timeout := esiConfig.BrowserTimeout
+	if timeout <= 0 {
+		timeout = DefaultBrowserTimeout
+	}
+
+	manifest := BrowserManifest{Schema: BrowserSchema, Version: BrowserSchemaVersion, Pixels: []BrowserPixel{}}
+	for _, pixel := range browserConfig.Pixels {
+		manifest.Pixels = append(manifest.Pixels, BrowserPixel{
+			Pixel: pixel,
+			Execution: BrowserExecution{
+				Inject:    browserInjection[pixel.TYPE],
+				Priority:  pixel.PRIORITY,
+				TimeoutMS: timeout,
+				Consent:   browserConsent(pixel.CONDITIONS),
+			},
+		})
+	}
+	sort.SliceStable(manifest.Pixels, func(i, j int) bool {
+		return manifest.Pixels[i].Execution.Priority > manifest.Pixels[j].Execution.Priority
+	})
+	return manifest
+}
+
+// browserConsent returns the consent requirements of a pixel's conditions, if any
+func browserConsent(conditions *PixelConditions) *BrowserConsent {
+	if conditions == nil {
+		return nil
+	}
+	consent := &BrowserConsent{
+		TCFVendor:   conditions.TCFVendor,
+		TCFPurposes: conditions.TCFPurposes,
+		USPrivacy:   conditions.USPrivacy,
+	}
+	if conditions.Consent != "" {
+		consent.Cookie, consent.Value = ConsentCookie, conditions.Consent
+	}
+	if consent.Cookie == "" && consent.TCFVendor == 0 && len(consent.TCFPurposes) == 0 && !consent.USPrivacy {
+		return nil
+	}
+	return consent
+}
+
+// GenerateBrowserLoader generates the JavaScript loader executing a browser manifest.
+// It defines window.esiBrowserPixels.load(manifest, macros), which fires the pixels in
+// order once their consent holds and replaces ~~name~~ macros with the given values;
+// with a manifest URL the loader fetches and loads it right away.
+func GenerateBrowserLoader(manifestURL string) string {
+	loader := strings.Replace(browserLoader, "__SCHEMA_VERSION__", jsonString(BrowserSchemaVersion), 1)
+	loader = strings.Replace(loader, "__USP_COOKIE__", jsonString(USPrivacyCookie), 1)
+	if manifestURL != "" {
+		loader += "\nfetch(" + jsonString(manifestURL) + ").then(function (r) { return r.json(); }).then(function (m) { window.esiBrowserPixels.load(m); });\n"
+	}
+	return loader
+}
+
+// jsonString encodes a value as a JavaScript literal
+func jsonString(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// browserLoader is the loader of GenerateBrowserLoader
+const browserLoader = `(function () {
+  function cookie(name) {
+    var m = document.cookie.match(new RegExp("(?:^|; )" + name.replace(/[.*+?^${}()|[\]\\]/g, "\\$&") + "=([^;]*)"));
+    return m ? decodeURIComponent(m[1]) : "";
+  }
+
+  function expand(value, macros) {
+    return value.replace(/~~(.*?)~~/g, function (_, name) {
+      return encodeURIComponent(macros[name] == null ? "" : macros[name]);
+    });
+  }
+
+  // TCF requirements hold when the CMP is absent or says TCF does not apply
+  function consented(consent, done) {
+    if (!consent) return done(true);
+    if (consent.cookie && cookie(consent.cookie) !== consent.value) return done(false);
+    if (consent.usPrivacy && cookie(__USP_COOKIE__).charAt(2).toUpperCase() === "Y") return done(false);
+    if (!(consent.tcfVendor || (consent.tcfPurposes || []).length) || typeof window.__tcfapi !== "function") return done(true);
+    window.__tcfapi("getTCData", 2, function (data, ok) {
+      if (!ok || !data.gdprApplies) return done(true);
+      var allowed = !consent.tcfVendor || !!(data.vendor && data.vendor.consents[consent.tcfVendor]);
+      (consent.tcfPurposes || []).forEach(function (p) {
+        allowed = allowed && !!(data.purpose && data.purpose.consents[p]);
+      });
+      done(allowed);
+    });
+  }
+
+  function inject(pixel, macros) {
+    var run = pixel.execution, el;
+    if (run.inject === "img") {
+      el = new Image();
+      el.src = expand(pixel.URL, macros);
+      setTimeout(function () { el.src = ""; }, run.timeoutMs);
+      return;
+    }
+    if (run.inject === "iframe") {
+      el = document.createElement("iframe");
+      el.style.display = "none";
+      el.src = expand(pixel.URL, macros);
+    } else {
+      el = document.createElement("script");
+      if (pixel.SCRIPT) el.text = expand(pixel.SCRIPT, macros);
+      else { el.async = true; el.src = expand(pixel.URL, macros); }
+    }
+    document.body.appendChild(el);
+    setTimeout(function () { el.parentNode && el.parentNode.removeChild(el); }, run.timeoutMs);
+  }
+
+  window.esiBrowserPixels = {
+    load: function (manifest, macros) {
+      if (manifest.version !== __SCHEMA_VERSION__) throw new Error("unsupported browser pixel manifest version " + manifest.version);
+      manifest.pixels.forEach(function (pixel) {
+        consented(pixel.execution.consent, function (ok) { if (ok) inject(pixel, macros || {}); });
+      });
+    }
+  };
+})();
+`
@@ -0,0 +1,68 @@
+package esi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildBrowserManifest(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "beacon", URL: "https://example.com/p.gif"},
+		{ID: "frame", URL: "https://example.com/f.html?u=~~uu~~", TYPE: "frm"},
+		{ID: "tag", URL: "https://example.com/t.js", TYPE: "script", PRIORITY: 5,
+			CONDITIONS: &PixelConditions{Consent: "yes", TCFVendor: 42, TCFPurposes: []int{1, 3}, USPrivacy: true}},
+		{ID: "image", URL: "https://example.com/i.gif", TYPE: "img", CONDITIONS: &PixelConditions{Countries: []string{"US"}}},
+	}}
+
+	_, browserConfig, err := ProcessContainerConfig(config, ESIConfig{})
+	require.NoError(t, err)
+	manifest := BuildBrowserManifest(browserConfig, ESIConfig{BrowserTimeout: 2000})
+
+	assert.Equal(t, BrowserSchema, manifest.Schema)
+	assert.Equal(t, BrowserSchemaVersion, manifest.Version)
+	require.Len(t, manifest.Pixels, 3)
+
+	// Pixels are ordered by priority
+	tag := manifest.Pixels[0]
+	assert.Equal(t, "tag", tag.ID)
+	assert.Equal(t, BrowserExecution{Inject: InjectScript, Priority: 5, TimeoutMS: 2000, Consent: &BrowserConsent{
+		Cookie: ConsentCookie, Value: "yes", TCFVendor: 42, TCFPurposes: []int{1, 3}, USPrivacy: true,
+	}}, tag.Execution)
+
+	assert.Equal(t, "frame", manifest.Pixels[1].ID)
+	assert.Equal(t, InjectIframe, manifest.Pixels[1].Execution.Inject)
+	// Conditions other than consent are not browser instructions
+	assert.Equal(t, BrowserExecution{Inject: InjectImage, TimeoutMS: 2000}, manifest.Pixels[2].Execution)
+
+	// The pixel's configuration stays at the top level of each entry
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	var decoded struct {
+		Schema  string                   `json:"schema"`
+		Version int                      `json:"version"`
+		Pixels  []map[string]interface{} `json:"pixels"`
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, 1, decoded.Version)
+	assert.Equal(t, "https://example.com/f.html?u=~~uu~~", decoded.Pixels[1]["URL"])
+	assert.Equal(t, "iframe", decoded.Pixels[1]["execution"].(map[string]interface{})["inject"])
+
+	// The timeout defaults
+	manifest = BuildBrowserManifest(browserConfig, ESIConfig{})
+	assert.Equal(t, DefaultBrowserTimeout, manifest.Pixels[0].Execution.TimeoutMS)
+	assert.Empty(t, BuildBrowserManifest(ContainerConfig{}, ESIConfig{}).Pixels)
+}
+
+func TestGenerateBrowserLoader(t *testing.T) {
+	loader := GenerateBrowserLoader("")
+	assert.Contains(t, loader, "window.esiBrowserPixels")
+	assert.Contains(t, loader, "manifest.version !== 1")
+	assert.Contains(t, loader, `cookie("usprivacy")`)
+	assert.NotContains(t, loader, "fetch(")
+
+	loader = GenerateBrowserLoader("pixels/news.browser.json")
+	assert.Contains(t, loader, `fetch("pixels/news.browser.json")`)
+}
@@ -0,0 +1,145 @@
+package esi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// DefaultContainerIncludeBudget is the include budget generated containers are checked
+// against unless ESIConfig.MaxIncludes is set: the emulator's default MaxIncludes
+const DefaultContainerIncludeBudget = 256
+
+// esiParents are the elements ESI elements must be directly inside
+var esiParents = map[string]string{
+	"esi:when":      "esi:choose",
+	"esi:otherwise": "esi:choose",
+	"esi:attempt":   "esi:try",
+	"esi:except":    "esi:try",
+}
+
+// ContainerOutputError lists the problems that make generated output unfit to deploy
+type ContainerOutputError struct {
+	Problems []string
+}
+
+func (e *ContainerOutputError) Error() string {
+	return "generated output is invalid: " + strings.Join(e.Problems, "; ")
+}
+
+// CheckContainerOutput validates generated ESI before it is deployed. The output must
+// be well-formed: every ESI element closed in order, self-closing elements closed and
+// branches inside their esi:choose or esi:try. A request may fetch at most the include
+// budget, counting the includes of the branch with the most of each esi:choose. The
+// output is then processed in akamai mode with every include mocked, as the edge
+// would for a request without cookies or headers.
+func CheckContainerOutput(output string, esiConfig ESIConfig) error {
+	budget := esiConfig.MaxIncludes
+	if budget <= 0 {
+		budget = DefaultContainerIncludeBudget
+	}
+
+	problems := checkESIStructure(output)
+	if len(problems) == 0 {
+		parsed, err := ParseTemplate(output)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot parse output: %v", err))
+		} else if includes := worstCaseIncludes(parsed.Nodes); includes > budget {
+			problems = append(problems, fmt.Sprintf("a request may fetch %d includes, over the budget of %d", includes, budget))
+		}
+	}
+	for _, issue := range Lint(output, Config{Mode: "akamai"}) {
+		if issue.Rule == RuleMissingAttribute {
+			problems = append(problems, fmt.Sprintf("line %d: %s", issue.Line, issue.Message))
+		}
+	}
+
+	if len(problems) == 0 {
+		processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: budget, MaxDepth: 1, BaseURL: DefaultComparisonBaseURL})
+		processor.client = &http.Client{Transport: mockTransport(nil)}
+		context := ProcessContext{BaseURL: DefaultComparisonBaseURL, Headers: map[string]string{}, Cookies: map[string]string{}}
+		if _, err := processor.Process(output, context); err != nil {
+			problems = append(problems, fmt.Sprintf("processing failed: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ContainerOutputError{Problems: problems}
+	}
+	return nil
+}
+
+// checkESIStructure reports ESI elements that are not closed in order, start tags of
+// elements that must be self-closing and branches outside their parent. Markup in
+// comments is not ESI, so a comment ending early exposes what follows it.
+func checkESIStructure(output string) []string {
+	var problems []string
+	var open []string
+	line := 1
+
+	tokenizer := html.NewTokenizer(strings.NewReader(output))
+	for tokenType := tokenizer.Next(); tokenType != html.ErrorToken; tokenType = tokenizer.Next() {
+		raw := string(tokenizer.Raw())
+		name, _ := tokenizer.TagName()
+		element := string(name)
+
+		if strings.HasPrefix(element, "esi:") {
+			switch tokenType {
+			case html.StartTagToken, html.SelfClosingTagToken:
+				if parent, ok := esiParents[element]; ok && (len(open) == 0 || open[len(open)-1] != parent) {
+					problems = append(problems, fmt.Sprintf("line %d: <%s> is not directly inside <%s>", line, element, parent))
+				}
+				if tokenType == html.SelfClosingTagToken {
+					break
+				}
+				if element == "esi:include" || element == "esi:assign" || element == "esi:return" {
+					problems = append(problems, fmt.Sprintf("line %d: <%s> is not self-closing", line, element))
+					break
+				}
+				open = append(open, element)
+			case html.EndTagToken:
+				if len(open) == 0 || open[len(open)-1] != element {
+					problems = append(problems, fmt.Sprintf("line %d: unexpected </%s>", line, element))
+					break
+				}
+				open = open[:len(open)-1]
+			}
+		}
+		line += strings.Count(raw, "\n")
+	}
+
+	for _, element := range open {
+		problems = append(problems, fmt.Sprintf("<%s> is never closed", element))
+	}
+	return problems
+}
+
+// worstCaseIncludes counts the includes a single request may fetch: every include,
+// but of each esi:choose only the branch with the most
+func worstCaseIncludes(nodes []*Node) int {
+	count := 0
+	for _, n := range nodes {
+		if n.Type != ElementNode {
+			count += worstCaseIncludes(n.Children)
+			continue
+		}
+		switch n.Name {
+		case "esi:include":
+			count++
+		case "esi:remove":
+		case "esi:choose":
+			most := 0
+			for _, branch := range n.Children {
+				if branchIncludes := worstCaseIncludes(branch.Children); branchIncludes > most {
+					most = branchIncludes
+				}
+			}
+			count += most
+		default:
+			count += worstCaseIncludes(n.Children)
+		}
+	}
+	return count
+}
@@ -0,0 +1,66 @@
+package esi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckContainerOutput(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "p1", URL: "https://example.com/p.gif?u=~~c~uid~hpr~s~~", CONDITIONS: &PixelConditions{Countries: []string{"US", "CA"}}},
+		{ID: "p2", URL: "https://example.com/q.gif", AFTER: "p1"},
+	}}
+	content, _, err := ProcessContainerConfig(config, ESIConfig{})
+	require.NoError(t, err)
+	assert.NoError(t, CheckContainerOutput(GenerateESIFunctions()+content, ESIConfig{}))
+
+	// Only one branch of each esi:choose is fetched
+	assert.NoError(t, CheckContainerOutput(content, ESIConfig{MaxIncludes: 2}))
+	err = CheckContainerOutput(content, ESIConfig{MaxIncludes: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a request may fetch 2 includes, over the budget of 1")
+
+	tests := []struct {
+		name     string
+		output   string
+		expected []string
+	}{
+		{"unclosed element", "<esi:try><esi:attempt><esi:include src=\"/a\" /></esi:attempt>",
+			[]string{"<esi:try> is never closed"}},
+		{"comment ending early", "<!-- pixel a --><esi:choose> suppressed -->",
+			[]string{"<esi:choose> is never closed"}},
+		{"misnested", "<esi:choose>\n<esi:when test=\"1==1\"></esi:choose></esi:when>",
+			[]string{"line 2: unexpected </esi:choose>", "<esi:choose> is never closed"}},
+		{"include not self-closing", `<esi:include src="/a">`,
+			[]string{"line 1: <esi:include> is not self-closing"}},
+		{"branch outside choose", `<esi:when test="1==1">x</esi:when>`,
+			[]string{"line 1: <esi:when> is not directly inside <esi:choose>"}},
+		{"missing attribute", `<esi:include maxwait="0" />`,
+			[]string{"line 1: esi:include has no src attribute"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckContainerOutput(tt.output, ESIConfig{})
+			var outputErr *ContainerOutputError
+			require.ErrorAs(t, err, &outputErr)
+			assert.Equal(t, tt.expected, outputErr.Problems)
+		})
+	}
+}
+
+func TestCheckContainerOutput_DefaultBudget(t *testing.T) {
+	var pixels []Pixel
+	for i := 0; i <= DefaultContainerIncludeBudget; i++ {
+		pixels = append(pixels, Pixel{ID: fmt.Sprintf("p%d", i), URL: fmt.Sprintf("https://example.com/%d.gif", i)})
+	}
+	content, _, err := ProcessContainerConfig(ContainerConfig{Pixels: pixels}, ESIConfig{})
+	require.NoError(t, err)
+
+	err = CheckContainerOutput(content, ESIConfig{})
+	require.Error(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "a request may fetch 257 includes, over the budget of 256"))
+}
@@ -0,0 +1,368 @@
+package esi
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esigen"
+)
+
+// Condition evaluation modes for container generation
+const (
+	// ConditionModeRuntime wraps includes in esi:choose blocks evaluated at the edge
+	ConditionModeRuntime = "runtime"
+	// ConditionModeStatic evaluates conditions at generation time against a fixed context
+	ConditionModeStatic = "static"
+)
+
+// PixelConditions restricts when a pixel fires. All configured conditions must hold.
+type PixelConditions struct {
+	// Countries lists ISO country codes the pixel fires in
+	Countries []string `json:"countries,omitempty"`
+	// ExcludeCountries lists ISO country codes the pixel never fires in
+	ExcludeCountries []string `json:"excludeCountries,omitempty"`
+	// Consent is the value the consent cookie must have
+	Consent string `json:"consent,omitempty"`
+	// FrequencyCap is the maximum number of fires recorded in the pixel's frequency cookie
+	FrequencyCap int `json:"frequencyCap,omitempty"`
+	// FrequencyPeriod is the lifetime of the frequency cookie: session (default) or day
+	FrequencyPeriod string `json:"frequencyPeriod,omitempty"`
+	// SampleRate is the percentage of visitors the pixel fires for, picked deterministically
+	SampleRate int `json:"sampleRate,omitempty"`
+	// SampleKey is the cookie holding the stable ID sampled on; suu is used when unset
+	SampleKey string `json:"sampleKey,omitempty"`
+	// KeyValues are custom conditions keyed by "cookie:name", "header:name" or "query:name"
+	KeyValues map[string]string `json:"keyValues,omitempty"`
+	// TCFVendor is the IAB vendor ID that needs TCF consent when TCF applies
+	TCFVendor int `json:"tcfVendor,omitempty"`
+	// TCFPurposes are the TCF purposes that need consent when TCF applies
+	TCFPurposes []int `json:"tcfPurposes,omitempty"`
+	// USPrivacy suppresses the pixel when us_privacy signals an opt-out of sale
+	USPrivacy bool `json:"usPrivacy,omitempty"`
+}
+
+// ConditionContext is the request used to evaluate conditions at generation time
+type ConditionContext struct {
+	ClientIP string            `json:"clientIp,omitempty"`
+	Country  string            `json:"country,omitempty"`
+	Cookies  map[string]string `json:"cookies,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Query    map[string]string `json:"query,omitempty"`
+}
+
+// GeoProvider resolves the country of a client IP
+type GeoProvider interface {
+	CountryCode(ip string) string
+}
+
+// EmulatorGeoProvider resolves every IP to the country the emulator's GEO variables
+// report for the request of Context
+type EmulatorGeoProvider struct {
+	// Context is the request, whose GEO_COUNTRY_CODE variable or geo override header
+	// sets the country; conditions evaluate against their condition context's headers
+	// when it has none
+	Context ProcessContext
+	// HeaderPrefix is the GeoHeaderPrefix of the geo override headers; empty ignores them
+	HeaderPrefix string
+}
+
+// CountryCode returns the country of Context's GEO_COUNTRY_CODE variable or geo override
+// header, or US, the emulator's default, when it has neither
+func (g EmulatorGeoProvider) CountryCode(string) string {
+	if country := g.Context.Variables["GEO_COUNTRY_CODE"]; country != "" {
+		return strings.ToUpper(country)
+	}
+	if g.HeaderPrefix != "" {
+		if country := strings.TrimSpace(headerValue(g.Context.Headers, g.HeaderPrefix+geoOverrideHeaders["country_code"])); country != "" {
+			return strings.ToUpper(country)
+		}
+	}
+	return "US"
+}
+
+// ConsentCookie is the cookie holding the visitor's consent value
+const ConsentCookie = "consent"
+
+// FrequencyCookie returns the name of the cookie counting fires of a pixel
+func FrequencyCookie(pixelID string) string {
+	return "fc_" + pixelID
+}
+
+// conditionClause is a single condition: it holds when any of its runtime tests holds
+type conditionClause struct {
+	description string
+	tests       []string
+	static      func(ctx *ConditionContext, geo GeoProvider) bool
+}
+
+// buildConditionClauses converts a pixel's conditions and FIRE_EXPR into clauses
+func buildConditionClauses(pixel Pixel) []conditionClause {
+	var clauses []conditionClause
+
+	if conditions := pixel.CONDITIONS; conditions != nil {
+		if len(conditions.Countries) > 0 {
+			countries := upperAll(conditions.Countries)
+			tests := make([]string, 0, len(countries))
+			for _, country := range countries {
+				tests = append(tests, fmt.Sprintf("$(GEO_COUNTRY_CODE)=='%s'", country))
+			}
+			clauses = append(clauses, conditionClause{
+				description: "country in " + strings.Join(countries, ","),
+				tests:       tests,
+				static: func(ctx *ConditionContext, geo GeoProvider) bool {
+					return containsString(countries, ctx.country(geo))
+				},
+			})
+		}
+
+		for _, country := range upperAll(conditions.ExcludeCountries) {
+			country := country
+			clauses = append(clauses, conditionClause{
+				description: "country not " + country,
+				tests:       []string{fmt.Sprintf("$(GEO_COUNTRY_CODE)!='%s'", country)},
+				static: func(ctx *ConditionContext, geo GeoProvider) bool {
+					return ctx.country(geo) != country
+				},
+			})
+		}
+
+		if conditions.Consent != "" {
+			consent := conditions.Consent
+			clauses = append(clauses, conditionClause{
+				description: "consent is " + consent,
+				tests:       []string{fmt.Sprintf("$(HTTP_COOKIE{%s})=='%s'", ConsentCookie, consent)},
+				static: func(ctx *ConditionContext, _ GeoProvider) bool {
+					return ctx.Cookies[ConsentCookie] == consent
+				},
+			})
+		}
+
+		clauses = append(clauses, consentClauses(conditions)...)
+
+		if conditions.FrequencyCap > 0 {
+			clauses = append(clauses, frequencyCapClause(pixel.ID, conditions.FrequencyCap))
+		}
+
+		if conditions.SampleRate > 0 && conditions.SampleRate < 100 {
+			clauses = append(clauses, sampleClause(pixel))
+		}
+
+		keys := make([]string, 0, len(conditions.KeyValues))
+		for key := range conditions.KeyValues {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			clauses = append(clauses, keyValueClause(key, conditions.KeyValues[key]))
+		}
+	}
+
+	if pixel.FIRE_EXPR != "" {
+		expr := pixel.FIRE_EXPR
+		clauses = append(clauses, conditionClause{
+			description: "FIRE_EXPR " + expr,
+			tests:       []string{expr},
+			static: func(ctx *ConditionContext, _ GeoProvider) bool {
+				return evaluateStaticExpression(expr, ctx)
+			},
+		})
+	}
+
+	return clauses
+}
+
+// frequencyCapClause allows a pixel while its frequency cookie counts fewer than limit fires
+func frequencyCapClause(pixelID string, limit int) conditionClause {
+	cookie := FrequencyCookie(pixelID)
+
+	// The expression language only supports equality, so list every allowed count
+	tests := []string{fmt.Sprintf("$(HTTP_COOKIE{%s})==''", cookie)}
+	for count := 0; count < limit; count++ {
+		tests = append(tests, fmt.Sprintf("$(HTTP_COOKIE{%s})=='%d'", cookie, count))
+	}
+
+	return conditionClause{
+		description: fmt.Sprintf("fired fewer than %d times", limit),
+		tests:       tests,
+		static: func(ctx *ConditionContext, _ GeoProvider) bool {
+			value := ctx.Cookies[cookie]
+			if value == "" {
+				return true
+			}
+			count, err := strconv.Atoi(value)
+			return err == nil && count < limit
+		},
+	}
+}
+
+// frequencyInclude returns the include reporting a fire of a frequency capped pixel to
+// the emulator's frequency endpoint, which counts it and refreshes the pixel's cookie.
+// The cookie is set on the include's response, so the page passes it on to the browser
+// with add_header; the frequency conditions of its next request test it.
+func frequencyInclude(pixel Pixel, config ESIConfig) string {
+	conditions := pixel.CONDITIONS
+	if config.FrequencyEndpoint == "" || conditions == nil || conditions.FrequencyCap <= 0 {
+		return ""
+	}
+
+	period := conditions.FrequencyPeriod
+	if period == "" {
+		period = "session"
+	}
+
+	src := fmt.Sprintf("%s/%s?cap=%d&period=%s", strings.TrimRight(config.FrequencyEndpoint, "/"),
+		url.PathEscape(pixel.ID), conditions.FrequencyCap, url.QueryEscape(period))
+	include := esigen.Include(src).MaxWait(0).OnError(esigen.Continue)
+	setCookie := esigen.Function("add_header", esigen.Attribute{Name: "header", Value: "Set-Cookie"},
+		esigen.Attribute{Name: "value", Value: "$(INCLUDE_HDR{Set-Cookie})"})
+	return include.String() + esigen.Choose().When("$(LAST_INCLUDE_STATUS)=='200'", setCookie).String()
+}
+
+// keyValueClause builds a custom condition on a cookie, header or query parameter
+func keyValueClause(key, value string) conditionClause {
+	source, name, found := strings.Cut(key, ":")
+	if !found {
+		source, name = "cookie", key
+	}
+
+	var variable string
+	var lookup func(ctx *ConditionContext) string
+	switch strings.ToLower(source) {
+	case "header":
+		variable = headerVariable(name)
+		lookup = func(ctx *ConditionContext) string { return headerValue(ctx.Headers, name) }
+	case "query":
+		variable = "$(QUERY_STRING{" + name + "})"
+		lookup = func(ctx *ConditionContext) string { return ctx.Query[name] }
+	default:
+		variable = "$(HTTP_COOKIE{" + name + "})"
+		lookup = func(ctx *ConditionContext) string { return ctx.Cookies[name] }
+	}
+
+	return conditionClause{
+		description: fmt.Sprintf("%s is %s", key, value),
+		tests:       []string{fmt.Sprintf("%s=='%s'", variable, value)},
+		static: func(ctx *ConditionContext, _ GeoProvider) bool {
+			return lookup(ctx) == value
+		},
+	}
+}
+
+// evaluateConditions evaluates a pixel's conditions against a generation-time context.
+// It returns whether the pixel fires and, if not, the first condition that failed.
+func evaluateConditions(pixel Pixel, ctx *ConditionContext, geo GeoProvider) (bool, string) {
+	if ctx == nil {
+		ctx = &ConditionContext{}
+	}
+	if geo == nil {
+		geo = EmulatorGeoProvider{}
+	}
+
+	for _, clause := range buildConditionClauses(pixel) {
+		if !clause.static(ctx, geo) {
+			return false, clause.description
+		}
+	}
+	return true, ""
+}
+
+// wrapWithConditions wraps content in an esi:choose that requires every clause. Each
+// clause is evaluated once before it, by an esi:choose whose esi:when branches are its
+// alternatives and assign its flag (see conditionFlag) 1. The expression language only
+// supports a single comparison, so the flags are concatenated and compared at once.
+func wrapWithConditions(content string, clauses []conditionClause) string {
+	if len(clauses) == 0 {
+		return content
+	}
+
+	var wrapped strings.Builder
+	var flags, expected string
+	for i, clause := range clauses {
+		flag := conditionFlag(i)
+		choose := esigen.Choose()
+		for _, test := range clause.tests {
+			choose.When(test, esigen.Assign(flag, "1"))
+		}
+		wrapped.WriteString(choose.Otherwise(esigen.Assign(flag, "0")).String())
+		flags += "$(" + flag + ")"
+		expected += "1"
+	}
+	wrapped.WriteString(esigen.Choose().When(fmt.Sprintf("%s=='%s'", flags, expected), esigen.Raw(content)).String())
+	return wrapped.String()
+}
+
+// conditionFlag returns the variable holding whether the clause at index holds. Variable
+// names cannot contain digits, so the index is spelled with the letters a to j.
+func conditionFlag(index int) string {
+	return "c_" + strings.Map(func(r rune) rune {
+		return 'a' + (r - '0')
+	}, strconv.Itoa(index))
+}
+
+// country returns the context country, resolving it from the client IP when unset. An
+// EmulatorGeoProvider without a request reads the context's headers.
+func (ctx *ConditionContext) country(geo GeoProvider) string {
+	if ctx.Country != "" {
+		return strings.ToUpper(ctx.Country)
+	}
+	if emulator, ok := geo.(EmulatorGeoProvider); ok && emulator.Context.Headers == nil {
+		emulator.Context.Headers = ctx.Headers
+		geo = emulator
+	}
+	return strings.ToUpper(geo.CountryCode(ctx.ClientIP))
+}
+
+// evaluateStaticExpression evaluates a FIRE_EXPR with the ESI expression rules against ctx
+func evaluateStaticExpression(expr string, ctx *ConditionContext) bool {
+	processor := NewProcessor(Config{Mode: "akamai"})
+
+	context := ProcessContext{
+		Headers: make(map[string]string),
+		Cookies: ctx.Cookies,
+	}
+	for name, value := range ctx.Headers {
+		context.Headers[name] = value
+	}
+	if len(ctx.Query) > 0 {
+		pairs := make([]string, 0, len(ctx.Query))
+		for name, value := range ctx.Query {
+			pairs = append(pairs, name+"="+value)
+		}
+		sort.Strings(pairs)
+		context.Headers["Query-String"] = strings.Join(pairs, "&")
+	}
+
+	return processor.evaluateExpression(expr, context) == "true"
+}
+
+// headerValue looks up a header case-insensitively
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// upperAll returns the upper-cased values
+func upperAll(values []string) []string {
+	upper := make([]string, len(values))
+	for i, value := range values {
+		upper[i] = strings.ToUpper(strings.TrimSpace(value))
+	}
+	return upper
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
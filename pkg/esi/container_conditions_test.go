@@ -0,0 +1,294 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedGeoProvider string
+
+func (g fixedGeoProvider) CountryCode(string) string {
+	return string(g)
+}
+
+func TestWrapWithConditions_Runtime(t *testing.T) {
+	tests := []struct {
+		name          string
+		pixel         Pixel
+		shouldContain []string
+		chooseBlocks  int
+	}{
+		{
+			name:         "no conditions",
+			pixel:        Pixel{ID: "p1", URL: "https://example.com/p.gif", TYPE: "dir"},
+			chooseBlocks: 0,
+		},
+		{
+			name: "countries become alternative branches",
+			pixel: Pixel{ID: "p1", URL: "https://example.com/p.gif", TYPE: "dir",
+				CONDITIONS: &PixelConditions{Countries: []string{"us", "CA"}}},
+			shouldContain: []string{
+				`<esi:when test="$(GEO_COUNTRY_CODE)=='US'">`,
+				`<esi:when test="$(GEO_COUNTRY_CODE)=='CA'">`,
+			},
+			chooseBlocks: 2,
+		},
+		{
+			name: "each condition sets a flag",
+			pixel: Pixel{ID: "p1", URL: "https://example.com/p.gif", TYPE: "dir",
+				CONDITIONS: &PixelConditions{
+					ExcludeCountries: []string{"DE"},
+					Consent:          "yes",
+					KeyValues:        map[string]string{"header:X-Segment": "sports", "query:ref": "home"},
+				}},
+			shouldContain: []string{
+				`$(GEO_COUNTRY_CODE)!='DE'`,
+				`$(HTTP_COOKIE{consent})=='yes'`,
+				`$(HTTP_X_SEGMENT)=='sports'`,
+				`$(QUERY_STRING{ref})=='home'`,
+				`<esi:assign name="c_d" value="1" />`,
+				`<esi:when test="$(c_a)$(c_b)$(c_c)$(c_d)=='1111'">`,
+			},
+			chooseBlocks: 5,
+		},
+		{
+			name: "frequency cap enumerates allowed counts",
+			pixel: Pixel{ID: "p1", URL: "https://example.com/p.gif", TYPE: "dir",
+				CONDITIONS: &PixelConditions{FrequencyCap: 2}},
+			shouldContain: []string{
+				`$(HTTP_COOKIE{fc_p1})==''`,
+				`$(HTTP_COOKIE{fc_p1})=='0'`,
+				`$(HTTP_COOKIE{fc_p1})=='1'`,
+			},
+			chooseBlocks: 2,
+		},
+		{
+			name:          "fire expression is used as a test",
+			pixel:         Pixel{ID: "p1", URL: "https://example.com/p.gif", TYPE: "dir", FIRE_EXPR: "$(HTTP_COOKIE{seg})=='a'"},
+			shouldContain: []string{`<esi:when test="$(HTTP_COOKIE{seg})=='a'">`},
+			chooseBlocks:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			include, err := generateESIInclude(tt.pixel, ESIConfig{})
+			require.NoError(t, err)
+
+			for _, expected := range tt.shouldContain {
+				assert.Contains(t, include, expected)
+			}
+			assert.Equal(t, tt.chooseBlocks, strings.Count(include, "<esi:choose>"))
+			assert.Equal(t, 1, strings.Count(include, `<esi:include src="https://example.com/p.gif"`))
+		})
+	}
+}
+
+func TestWrapWithConditions_FrequencyCapNotExceeded(t *testing.T) {
+	include, err := generateESIInclude(Pixel{ID: "p1", URL: "https://example.com/p.gif", TYPE: "dir",
+		CONDITIONS: &PixelConditions{FrequencyCap: 2}}, ESIConfig{})
+	require.NoError(t, err)
+	assert.NotContains(t, include, `$(HTTP_COOKIE{fc_p1})=='2'`)
+}
+
+func TestWrapWithConditions_ThroughProcessor(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	pixel := Pixel{ID: "p1", URL: server.URL + "/p.gif", TYPE: "dir", FIRE_EXPR: "$(HTTP_COOKIE{seg})=='a'",
+		CONDITIONS: &PixelConditions{FrequencyCap: 2, KeyValues: map[string]string{"consent": "yes"}}}
+	include, err := generateESIInclude(pixel, ESIConfig{})
+	require.NoError(t, err)
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 3})
+
+	tests := []struct {
+		cookies map[string]string
+		fires   bool
+	}{
+		{map[string]string{"seg": "a", "consent": "yes"}, true},
+		{map[string]string{"seg": "a", "consent": "yes", "fc_p1": "1"}, true},
+		{map[string]string{"seg": "a", "consent": "yes", "fc_p1": "2"}, false},
+		{map[string]string{"seg": "b", "consent": "yes"}, false},
+		{map[string]string{"seg": "a"}, false},
+	}
+	for _, test := range tests {
+		hits.Store(0)
+		_, err := processor.Process(include, ProcessContext{Headers: map[string]string{}, Cookies: test.cookies})
+		require.NoError(t, err)
+		expected := int32(0)
+		if test.fires {
+			expected = 1
+		}
+		assert.Equal(t, expected, hits.Load(), "cookies %v", test.cookies)
+	}
+}
+
+func TestFrequencyInclude(t *testing.T) {
+	pixel := Pixel{ID: "p1", URL: "https://example.com/p.gif", TYPE: "dir",
+		CONDITIONS: &PixelConditions{FrequencyCap: 2, FrequencyPeriod: "day"}}
+
+	include, err := generateESIInclude(pixel, ESIConfig{FrequencyEndpoint: "http://localhost:3000/frequency/"})
+	require.NoError(t, err)
+	counter := `<esi:include src="http://localhost:3000/frequency/p1?cap=2&amp;period=day" maxwait="0" onerror="continue" />`
+	assert.Contains(t, include, counter)
+	// The counter fires only where the beacon does, and its cookie goes out with the page
+	assert.Equal(t, strings.Count(include, `src="https://example.com/p.gif"`), strings.Count(include, counter))
+	assert.Contains(t, include, counter+`<esi:choose><esi:when test="$(LAST_INCLUDE_STATUS)=='200'">`+
+		`<esi:function name="add_header" header="Set-Cookie" value="$(INCLUDE_HDR{Set-Cookie})" /></esi:when></esi:choose>`)
+
+	pixel.CONDITIONS.FrequencyPeriod = ""
+	include, err = generateESIInclude(pixel, ESIConfig{FrequencyEndpoint: "http://localhost:3000/frequency"})
+	require.NoError(t, err)
+	assert.Contains(t, include, "/frequency/p1?cap=2&amp;period=session")
+
+	include, err = generateESIInclude(pixel, ESIConfig{})
+	require.NoError(t, err)
+	assert.NotContains(t, include, "/frequency/")
+}
+
+func TestEvaluateConditions_Static(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions *PixelConditions
+		fireExpr   string
+		ctx        *ConditionContext
+		geo        GeoProvider
+		fires      bool
+		reason     string
+	}{
+		{
+			name:  "no conditions",
+			ctx:   &ConditionContext{},
+			fires: true,
+		},
+		{
+			name:       "country from geo provider",
+			conditions: &PixelConditions{Countries: []string{"GB"}},
+			ctx:        &ConditionContext{ClientIP: "203.0.113.1"},
+			geo:        fixedGeoProvider("GB"),
+			fires:      true,
+		},
+		{
+			name:       "emulator geo provider defaults to US",
+			conditions: &PixelConditions{Countries: []string{"GB"}},
+			ctx:        &ConditionContext{},
+			fires:      false,
+			reason:     "country in GB",
+		},
+		{
+			name:       "emulator geo provider reads the geo override header",
+			conditions: &PixelConditions{Countries: []string{"GB"}},
+			ctx:        &ConditionContext{Headers: map[string]string{"x-emulator-geo-country": "gb"}},
+			geo:        EmulatorGeoProvider{HeaderPrefix: "X-Emulator-Geo-"},
+			fires:      true,
+		},
+		{
+			name:       "geo override header needs a prefix",
+			conditions: &PixelConditions{Countries: []string{"GB"}},
+			ctx:        &ConditionContext{Headers: map[string]string{"X-Emulator-Geo-Country": "GB"}},
+			geo:        EmulatorGeoProvider{},
+			fires:      false,
+			reason:     "country in GB",
+		},
+		{
+			name:       "explicit country overrides geo provider",
+			conditions: &PixelConditions{ExcludeCountries: []string{"fr"}},
+			ctx:        &ConditionContext{Country: "fr"},
+			geo:        fixedGeoProvider("US"),
+			fires:      false,
+			reason:     "country not FR",
+		},
+		{
+			name:       "missing consent",
+			conditions: &PixelConditions{Consent: "yes"},
+			ctx:        &ConditionContext{Cookies: map[string]string{"consent": "no"}},
+			fires:      false,
+			reason:     "consent is yes",
+		},
+		{
+			name:       "under frequency cap",
+			conditions: &PixelConditions{FrequencyCap: 3},
+			ctx:        &ConditionContext{Cookies: map[string]string{"fc_p1": "2"}},
+			fires:      true,
+		},
+		{
+			name:       "frequency cap reached",
+			conditions: &PixelConditions{FrequencyCap: 3},
+			ctx:        &ConditionContext{Cookies: map[string]string{"fc_p1": "3"}},
+			fires:      false,
+			reason:     "fired fewer than 3 times",
+		},
+		{
+			name:       "header key/value matches case-insensitively",
+			conditions: &PixelConditions{KeyValues: map[string]string{"header:X-Segment": "sports"}},
+			ctx:        &ConditionContext{Headers: map[string]string{"x-segment": "sports"}},
+			fires:      true,
+		},
+		{
+			name:       "query key/value mismatch",
+			conditions: &PixelConditions{KeyValues: map[string]string{"query:ref": "home"}},
+			ctx:        &ConditionContext{Query: map[string]string{"ref": "search"}},
+			fires:      false,
+			reason:     "query:ref is home",
+		},
+		{
+			name:     "fire expression",
+			fireExpr: "$(HTTP_COOKIE{seg})=='a'",
+			ctx:      &ConditionContext{Cookies: map[string]string{"seg": "a"}},
+			fires:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pixel := Pixel{ID: "p1", TYPE: "dir", CONDITIONS: tt.conditions, FIRE_EXPR: tt.fireExpr}
+			fires, reason := evaluateConditions(pixel, tt.ctx, tt.geo)
+			assert.Equal(t, tt.fires, fires)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+func TestEmulatorGeoProvider_CountryCode(t *testing.T) {
+	assert.Equal(t, "US", EmulatorGeoProvider{}.CountryCode("203.0.113.1"))
+
+	geo := EmulatorGeoProvider{
+		Context:      ProcessContext{Headers: map[string]string{"X-Geo-Country": " de "}},
+		HeaderPrefix: "X-Geo-",
+	}
+	assert.Equal(t, "DE", geo.CountryCode("203.0.113.1"))
+
+	geo.Context.Variables = map[string]string{"GEO_COUNTRY_CODE": "jp"}
+	assert.Equal(t, "JP", geo.CountryCode("203.0.113.1"))
+}
+
+func TestProcessContainerConfig_StaticConditions(t *testing.T) {
+	config := ContainerConfig{
+		Pixels: []Pixel{
+			{ID: "us", URL: "https://example.com/us.gif", TYPE: "dir",
+				CONDITIONS: &PixelConditions{Countries: []string{"US"}}},
+			{ID: "eu", URL: "https://example.com/eu.gif", TYPE: "dir",
+				CONDITIONS: &PixelConditions{Countries: []string{"DE", "FR"}}},
+		},
+	}
+
+	esiContent, _, err := ProcessContainerConfig(config, ESIConfig{
+		ConditionMode: ConditionModeStatic,
+		StaticContext: &ConditionContext{},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, esiContent, "https://example.com/us.gif")
+	assert.NotContains(t, esiContent, "https://example.com/eu.gif")
+	assert.Contains(t, esiContent, "<!-- pixel eu suppressed: country in DE,FR -->")
+	assert.NotContains(t, esiContent, "<esi:choose>")
+}
@@ -0,0 +1,386 @@
+package esi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Cookies carrying privacy signals, as written by the browser tag's CMP integration
+const (
+	TCFCookie       = "euconsent-v2"
+	GPPCookie       = "__gpp"
+	USPrivacyCookie = "usprivacy"
+)
+
+// GPP section IDs understood by the consent module
+const (
+	GPPSectionTCFEU = 2
+	GPPSectionUSP   = 6
+)
+
+// Headers set by the edge consent decoder and tested by runtime conditions.
+// The edge decodes the consent cookies once per request (see ConsentSignals.Headers)
+// because ESI expressions cannot inspect the bit fields of a consent string.
+const (
+	TCFAppliesHeader       = "X-TCF-Applies"
+	TCFVendorHeaderPrefix  = "X-TCF-Vendor-"
+	TCFPurposeHeaderPrefix = "X-TCF-Purpose-"
+	USPrivacyOptOutHeader  = "X-US-Privacy-Opt-Out"
+)
+
+// TCFConsent is the decoded core segment of an IAB TCF v2 consent string
+type TCFConsent struct {
+	Version           int
+	CMPID             int
+	VendorListVersion int
+	PurposeConsents   map[int]bool
+	VendorConsents    map[int]bool
+}
+
+// USPrivacy is a decoded IAB CCPA us_privacy string such as "1YNN"
+type USPrivacy struct {
+	Version byte
+	Notice  byte
+	OptOut  byte
+	LSPA    byte
+}
+
+// OptedOut reports whether the user opted out of the sale of personal information
+func (u *USPrivacy) OptedOut() bool {
+	return u.OptOut == 'Y'
+}
+
+// GPPString is a decoded IAB Global Privacy Platform string
+type GPPString struct {
+	Version    int
+	SectionIDs []int
+	Sections   map[int]string
+}
+
+// ConsentSignals are the privacy signals of a request
+type ConsentSignals struct {
+	// TCFApplies is set when a TCF string was sent, directly or inside GPP.
+	// TCF is nil when that string could not be decoded, which grants no consent.
+	TCFApplies bool
+	TCF        *TCFConsent
+	USPrivacy  *USPrivacy
+}
+
+// DecodeConsent reads the TCF, GPP and us_privacy cookies of a request.
+// A TCF section inside GPP takes precedence over the standalone TCF cookie.
+func DecodeConsent(cookies map[string]string) ConsentSignals {
+	var signals ConsentSignals
+
+	tcfString := cookies[TCFCookie]
+	uspString := cookies[USPrivacyCookie]
+
+	if value := cookies[GPPCookie]; value != "" {
+		if gpp, err := ParseGPPString(value); err == nil {
+			if section, ok := gpp.Sections[GPPSectionTCFEU]; ok {
+				tcfString = section
+			}
+			if section, ok := gpp.Sections[GPPSectionUSP]; ok {
+				uspString = section
+			}
+		}
+	}
+
+	if tcfString != "" {
+		signals.TCFApplies = true
+		if tcf, err := ParseTCFString(tcfString); err == nil {
+			signals.TCF = tcf
+		}
+	}
+	if uspString != "" {
+		if usp, err := ParseUSPrivacy(uspString); err == nil {
+			signals.USPrivacy = usp
+		}
+	}
+
+	return signals
+}
+
+// VendorAllowed reports whether a vendor may fire given the TCF signal.
+// Requests without a TCF string are outside TCF scope and always allowed.
+func (s ConsentSignals) VendorAllowed(vendorID int, purposes []int) bool {
+	if !s.TCFApplies {
+		return true
+	}
+	if s.TCF == nil || !s.TCF.VendorConsents[vendorID] {
+		return false
+	}
+	for _, purpose := range purposes {
+		if !s.TCF.PurposeConsents[purpose] {
+			return false
+		}
+	}
+	return true
+}
+
+// OptedOut reports whether the us_privacy signal opts out of sale
+func (s ConsentSignals) OptedOut() bool {
+	return s.USPrivacy != nil && s.USPrivacy.OptedOut()
+}
+
+// Headers returns the decoded signals as the headers tested by runtime conditions.
+// Vendor headers are only produced for vendorIDs, since a TCF string may list thousands.
+func (s ConsentSignals) Headers(vendorIDs ...int) map[string]string {
+	headers := make(map[string]string)
+
+	if s.TCFApplies {
+		headers[TCFAppliesHeader] = "1"
+		if s.TCF != nil {
+			for purpose, consented := range s.TCF.PurposeConsents {
+				if consented {
+					headers[TCFPurposeHeaderPrefix+strconv.Itoa(purpose)] = "1"
+				}
+			}
+			for _, vendorID := range vendorIDs {
+				if s.TCF.VendorConsents[vendorID] {
+					headers[TCFVendorHeaderPrefix+strconv.Itoa(vendorID)] = "1"
+				}
+			}
+		}
+	}
+	if s.OptedOut() {
+		headers[USPrivacyOptOutHeader] = "1"
+	}
+
+	return headers
+}
+
+// consentClauses builds the TCF and us_privacy clauses of a pixel's conditions.
+// Each TCF requirement holds when TCF does not apply or the vendor/purpose consented.
+func consentClauses(conditions *PixelConditions) []conditionClause {
+	var clauses []conditionClause
+	notApplies := fmt.Sprintf("%s!='1'", headerVariable(TCFAppliesHeader))
+
+	if conditions.TCFVendor > 0 {
+		vendorID := conditions.TCFVendor
+		clauses = append(clauses, conditionClause{
+			description: fmt.Sprintf("TCF consent for vendor %d", vendorID),
+			tests: []string{
+				notApplies,
+				fmt.Sprintf("%s=='1'", headerVariable(TCFVendorHeaderPrefix+strconv.Itoa(vendorID))),
+			},
+			static: func(ctx *ConditionContext, _ GeoProvider) bool {
+				return DecodeConsent(ctx.Cookies).VendorAllowed(vendorID, nil)
+			},
+		})
+	}
+
+	purposes := append([]int(nil), conditions.TCFPurposes...)
+	sort.Ints(purposes)
+	for _, purpose := range purposes {
+		purpose := purpose
+		clauses = append(clauses, conditionClause{
+			description: fmt.Sprintf("TCF consent for purpose %d", purpose),
+			tests: []string{
+				notApplies,
+				fmt.Sprintf("%s=='1'", headerVariable(TCFPurposeHeaderPrefix+strconv.Itoa(purpose))),
+			},
+			static: func(ctx *ConditionContext, _ GeoProvider) bool {
+				signals := DecodeConsent(ctx.Cookies)
+				return !signals.TCFApplies || (signals.TCF != nil && signals.TCF.PurposeConsents[purpose])
+			},
+		})
+	}
+
+	if conditions.USPrivacy {
+		clauses = append(clauses, conditionClause{
+			description: "no us_privacy opt-out",
+			tests:       []string{fmt.Sprintf("%s!='1'", headerVariable(USPrivacyOptOutHeader))},
+			static: func(ctx *ConditionContext, _ GeoProvider) bool {
+				return !DecodeConsent(ctx.Cookies).OptedOut()
+			},
+		})
+	}
+
+	return clauses
+}
+
+// headerVariable returns the ESI variable for a request header
+func headerVariable(name string) string {
+	return "$(HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + ")"
+}
+
+// ParseTCFString decodes the core segment of a TCF v2 consent string
+func ParseTCFString(value string) (*TCFConsent, error) {
+	core, _, _ := strings.Cut(value, ".")
+	reader, err := newBitReader(core)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCF string: %w", err)
+	}
+
+	consent := &TCFConsent{
+		PurposeConsents: make(map[int]bool),
+		VendorConsents:  make(map[int]bool),
+	}
+
+	consent.Version = reader.int(6)
+	if consent.Version != 2 {
+		return nil, fmt.Errorf("invalid TCF string: unsupported version %d", consent.Version)
+	}
+	reader.skip(36 + 36) // Created, LastUpdated
+	consent.CMPID = reader.int(12)
+	reader.skip(12 + 6 + 12) // CmpVersion, ConsentScreen, ConsentLanguage
+	consent.VendorListVersion = reader.int(12)
+	reader.skip(6 + 1 + 1 + 12) // TcfPolicyVersion, IsServiceSpecific, UseNonStandardTexts, SpecialFeatureOptIns
+	for purpose := 1; purpose <= 24; purpose++ {
+		if reader.bool() {
+			consent.PurposeConsents[purpose] = true
+		}
+	}
+	reader.skip(24 + 1 + 12) // PurposesLITransparency, PurposeOneTreatment, PublisherCC
+
+	maxVendorID := reader.int(16)
+	if reader.bool() {
+		entries := reader.int(12)
+		for i := 0; i < entries; i++ {
+			isRange := reader.bool()
+			start := reader.int(16)
+			end := start
+			if isRange {
+				end = reader.int(16)
+			}
+			for vendorID := start; vendorID <= end && vendorID <= maxVendorID; vendorID++ {
+				consent.VendorConsents[vendorID] = true
+			}
+		}
+	} else {
+		for vendorID := 1; vendorID <= maxVendorID; vendorID++ {
+			if reader.bool() {
+				consent.VendorConsents[vendorID] = true
+			}
+		}
+	}
+
+	if reader.overflow {
+		return nil, fmt.Errorf("invalid TCF string: truncated core segment")
+	}
+	return consent, nil
+}
+
+// ParseUSPrivacy decodes a four character us_privacy string
+func ParseUSPrivacy(value string) (*USPrivacy, error) {
+	if len(value) != 4 || value[0] != '1' {
+		return nil, fmt.Errorf("invalid us_privacy string %q", value)
+	}
+	for _, c := range value[1:] {
+		if c != 'Y' && c != 'N' && c != '-' {
+			return nil, fmt.Errorf("invalid us_privacy string %q", value)
+		}
+	}
+	return &USPrivacy{Version: value[0], Notice: value[1], OptOut: value[2], LSPA: value[3]}, nil
+}
+
+// ParseGPPString decodes a GPP string's header and maps its sections to their IDs
+func ParseGPPString(value string) (*GPPString, error) {
+	parts := strings.Split(value, "~")
+	reader, err := newBitReader(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid GPP string: %w", err)
+	}
+
+	if headerType := reader.int(6); headerType != 3 {
+		return nil, fmt.Errorf("invalid GPP string: header type %d", headerType)
+	}
+	gpp := &GPPString{Version: reader.int(6), Sections: make(map[int]string)}
+
+	// Section IDs are a Fibonacci-encoded range of offsets from the previous ID
+	last := 0
+	entries := reader.int(12)
+	for i := 0; i < entries; i++ {
+		isRange := reader.bool()
+		start := last + reader.fibonacci()
+		end := start
+		if isRange {
+			end = start + reader.fibonacci()
+		}
+		for id := start; id <= end; id++ {
+			gpp.SectionIDs = append(gpp.SectionIDs, id)
+		}
+		last = end
+	}
+
+	if reader.overflow {
+		return nil, fmt.Errorf("invalid GPP string: truncated header")
+	}
+	if len(parts)-1 != len(gpp.SectionIDs) {
+		return nil, fmt.Errorf("invalid GPP string: %d sections for %d section IDs", len(parts)-1, len(gpp.SectionIDs))
+	}
+	for i, id := range gpp.SectionIDs {
+		gpp.Sections[id] = parts[i+1]
+	}
+	return gpp, nil
+}
+
+// bitReader reads big-endian bit fields from a base64url encoded string
+type bitReader struct {
+	data     []byte
+	pos      int
+	overflow bool
+}
+
+// newBitReader decodes a base64url string with or without padding
+func newBitReader(encoded string) (*bitReader, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, err
+	}
+	return &bitReader{data: data}, nil
+}
+
+// bool reads a single bit
+func (r *bitReader) bool() bool {
+	if r.pos >= len(r.data)*8 {
+		r.overflow = true
+		r.pos++
+		return false
+	}
+	bit := r.data[r.pos/8]>>(7-uint(r.pos%8))&1 == 1
+	r.pos++
+	return bit
+}
+
+// int reads an unsigned integer of the given width
+func (r *bitReader) int(bits int) int {
+	value := 0
+	for i := 0; i < bits; i++ {
+		value <<= 1
+		if r.bool() {
+			value |= 1
+		}
+	}
+	return value
+}
+
+// skip advances past bits without reading them
+func (r *bitReader) skip(bits int) {
+	r.pos += bits
+	if r.pos > len(r.data)*8 {
+		r.overflow = true
+	}
+}
+
+// fibonacci reads a Fibonacci-coded integer terminated by two consecutive 1 bits
+func (r *bitReader) fibonacci() int {
+	value := 0
+	previous, current := 1, 1
+	lastBit := false
+	for !r.overflow {
+		bit := r.bool()
+		if bit && lastBit {
+			return value
+		}
+		if bit {
+			value += current
+		}
+		previous, current = current, previous+current
+		lastBit = bit
+	}
+	return value
+}
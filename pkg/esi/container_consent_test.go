@@ -0,0 +1,207 @@
+package esi
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bitWriter builds bit fields for test consent strings
+type bitWriter struct {
+	bits []bool
+}
+
+func (w *bitWriter) int(value, width int) {
+	for i := width - 1; i >= 0; i-- {
+		w.bits = append(w.bits, value>>uint(i)&1 == 1)
+	}
+}
+
+func (w *bitWriter) String() string {
+	data := make([]byte, (len(w.bits)+7)/8)
+	for i, bit := range w.bits {
+		if bit {
+			data[i/8] |= 1 << (7 - uint(i%8))
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// buildTCFString encodes a TCF v2 core segment with a vendor bit field
+func buildTCFString(purposes, vendors []int) string {
+	w := &bitWriter{}
+	w.int(2, 6)      // Version
+	w.int(0, 36)     // Created
+	w.int(0, 36)     // LastUpdated
+	w.int(7, 12)     // CmpId
+	w.int(1, 12)     // CmpVersion
+	w.int(0, 6)      // ConsentScreen
+	w.int(0, 12)     // ConsentLanguage
+	w.int(42, 12)    // VendorListVersion
+	w.int(2, 6)      // TcfPolicyVersion
+	w.int(0, 1+1+12) // IsServiceSpecific, UseNonStandardTexts, SpecialFeatureOptIns
+	purposeBits := make([]int, 24)
+	for _, purpose := range purposes {
+		purposeBits[purpose-1] = 1
+	}
+	for _, bit := range purposeBits {
+		w.int(bit, 1)
+	}
+	w.int(0, 24+1+12) // PurposesLITransparency, PurposeOneTreatment, PublisherCC
+
+	maxVendorID := 0
+	for _, vendor := range vendors {
+		if vendor > maxVendorID {
+			maxVendorID = vendor
+		}
+	}
+	w.int(maxVendorID, 16)
+	w.int(0, 1) // bit field encoding
+	vendorBits := make([]int, maxVendorID)
+	for _, vendor := range vendors {
+		vendorBits[vendor-1] = 1
+	}
+	for _, bit := range vendorBits {
+		w.int(bit, 1)
+	}
+	return w.String()
+}
+
+func TestParseTCFString(t *testing.T) {
+	consent, err := ParseTCFString(buildTCFString([]int{1, 3}, []int{10, 755}) + ".YAAAAAAAAAAA")
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, consent.Version)
+	assert.Equal(t, 7, consent.CMPID)
+	assert.Equal(t, 42, consent.VendorListVersion)
+	assert.Equal(t, map[int]bool{1: true, 3: true}, consent.PurposeConsents)
+	assert.Equal(t, map[int]bool{10: true, 755: true}, consent.VendorConsents)
+}
+
+func TestParseTCFString_RangeEncoding(t *testing.T) {
+	w := &bitWriter{}
+	w.int(2, 6)
+	w.int(0, 207) // fields up to and including PublisherCC
+	w.int(20, 16) // MaxVendorId
+	w.int(1, 1)   // range encoding
+	w.int(2, 12)  // NumEntries
+	w.int(0, 1)   // single vendor
+	w.int(3, 16)
+	w.int(1, 1) // range
+	w.int(10, 16)
+	w.int(12, 16)
+
+	consent, err := ParseTCFString(w.String())
+	require.NoError(t, err)
+	assert.Equal(t, map[int]bool{3: true, 10: true, 11: true, 12: true}, consent.VendorConsents)
+}
+
+func TestParseTCFString_Invalid(t *testing.T) {
+	for _, value := range []string{"!!!", "CAAAAAAA", buildTCFString(nil, []int{5})[:20]} {
+		_, err := ParseTCFString(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseUSPrivacy(t *testing.T) {
+	usp, err := ParseUSPrivacy("1YYN")
+	require.NoError(t, err)
+	assert.True(t, usp.OptedOut())
+
+	usp, err = ParseUSPrivacy("1YN-")
+	require.NoError(t, err)
+	assert.False(t, usp.OptedOut())
+
+	for _, value := range []string{"", "1YN", "2YNN", "1XNN"} {
+		_, err := ParseUSPrivacy(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseGPPString(t *testing.T) {
+	tcf := buildTCFString([]int{1}, []int{755})
+
+	gpp, err := ParseGPPString("DBABMA~" + tcf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, gpp.Version)
+	assert.Equal(t, []int{2}, gpp.SectionIDs)
+	assert.Equal(t, tcf, gpp.Sections[GPPSectionTCFEU])
+
+	gpp, err = ParseGPPString("DBABTA~1YYN")
+	require.NoError(t, err)
+	assert.Equal(t, []int{6}, gpp.SectionIDs)
+	assert.Equal(t, "1YYN", gpp.Sections[GPPSectionUSP])
+
+	_, err = ParseGPPString("DBABMA")
+	assert.Error(t, err)
+}
+
+func TestDecodeConsent(t *testing.T) {
+	tcf := buildTCFString([]int{1, 2}, []int{755})
+
+	signals := DecodeConsent(map[string]string{})
+	assert.False(t, signals.TCFApplies)
+	assert.True(t, signals.VendorAllowed(755, []int{1}))
+	assert.Empty(t, signals.Headers(755))
+
+	signals = DecodeConsent(map[string]string{TCFCookie: tcf, USPrivacyCookie: "1YYN"})
+	assert.True(t, signals.VendorAllowed(755, []int{1, 2}))
+	assert.False(t, signals.VendorAllowed(755, []int{3}))
+	assert.False(t, signals.VendorAllowed(10, nil))
+	assert.True(t, signals.OptedOut())
+	assert.Equal(t, map[string]string{
+		"X-TCF-Applies":        "1",
+		"X-TCF-Purpose-1":      "1",
+		"X-TCF-Purpose-2":      "1",
+		"X-TCF-Vendor-755":     "1",
+		"X-US-Privacy-Opt-Out": "1",
+	}, signals.Headers(755, 10))
+
+	// An undecodable TCF string still means TCF applies, without consent
+	signals = DecodeConsent(map[string]string{TCFCookie: "garbage"})
+	assert.True(t, signals.TCFApplies)
+	assert.False(t, signals.VendorAllowed(755, nil))
+
+	// GPP sections take precedence over the standalone cookies
+	signals = DecodeConsent(map[string]string{GPPCookie: "DBABMA~" + tcf, TCFCookie: "garbage"})
+	assert.True(t, signals.VendorAllowed(755, nil))
+}
+
+func TestConsentConditions(t *testing.T) {
+	pixel := Pixel{ID: "p1", URL: "https://example.com/p.gif", TYPE: "dir",
+		CONDITIONS: &PixelConditions{TCFVendor: 755, TCFPurposes: []int{1}, USPrivacy: true}}
+
+	include, err := generateESIInclude(pixel, ESIConfig{})
+	require.NoError(t, err)
+	// The vendor and purpose clauses have two alternatives each, but the include appears once
+	assert.Equal(t, 1, strings.Count(include, "<esi:include"))
+	assert.Contains(t, include, `<esi:when test="$(HTTP_X_TCF_APPLIES)!='1'">`)
+	assert.Contains(t, include, `<esi:when test="$(HTTP_X_TCF_VENDOR_755)=='1'">`)
+	assert.Contains(t, include, `<esi:when test="$(HTTP_X_TCF_PURPOSE_1)=='1'">`)
+	assert.Contains(t, include, `<esi:when test="$(HTTP_X_US_PRIVACY_OPT_OUT)!='1'">`)
+
+	tests := []struct {
+		name    string
+		cookies map[string]string
+		fires   bool
+		reason  string
+	}{
+		{name: "no privacy signals", cookies: map[string]string{}, fires: true},
+		{name: "vendor and purpose consent", cookies: map[string]string{TCFCookie: buildTCFString([]int{1}, []int{755})}, fires: true},
+		{name: "vendor without consent", cookies: map[string]string{TCFCookie: buildTCFString([]int{1}, []int{10})}, fires: false, reason: "TCF consent for vendor 755"},
+		{name: "purpose without consent", cookies: map[string]string{TCFCookie: buildTCFString([]int{2}, []int{755})}, fires: false, reason: "TCF consent for purpose 1"},
+		{name: "us_privacy opt-out", cookies: map[string]string{USPrivacyCookie: "1YYN"}, fires: false, reason: "no us_privacy opt-out"},
+		{name: "us_privacy opt-out via GPP", cookies: map[string]string{GPPCookie: "DBABTA~1YYN"}, fires: false, reason: "no us_privacy opt-out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fires, reason := evaluateConditions(pixel, &ConditionContext{Cookies: tt.cookies}, nil)
+			assert.Equal(t, tt.fires, fires)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package esi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// FieldChange is a changed pixel property. URL query parameters are reported
+// separately as URL?name, nested properties as CONDITIONS.countries.
+type FieldChange struct {
+	Path string `json:"path"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// PixelChange lists the changed properties of a pixel present in both configs
+type PixelChange struct {
+	ID     string        `json:"id"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// ConfigDiff is the difference between two container configs
+type ConfigDiff struct {
+	Added   []string      `json:"added,omitempty"`
+	Removed []string      `json:"removed,omitempty"`
+	Changed []PixelChange `json:"changed,omitempty"`
+	// Reordered is set when the pixels both configs share appear in a different order
+	Reordered bool `json:"reordered,omitempty"`
+	// ESIDiff is the line diff of the ESI content generated from both configs
+	ESIDiff []string `json:"esiDiff,omitempty"`
+}
+
+// Empty reports whether the configs and their generated ESI content are identical
+func (d *ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && !d.Reordered && len(d.ESIDiff) == 0
+}
+
+// DiffConfigs compares two container configs pixel by pixel, matching pixels by ID,
+// and diffs the ESI content generated from each with esiConfig
+func DiffConfigs(oldConfig, newConfig ContainerConfig, esiConfig ESIConfig) (*ConfigDiff, error) {
+	diff := &ConfigDiff{}
+
+	oldPixels := make(map[string]Pixel, len(oldConfig.Pixels))
+	for _, pixel := range oldConfig.Pixels {
+		oldPixels[pixel.ID] = pixel
+	}
+	newPixels := make(map[string]Pixel, len(newConfig.Pixels))
+	for _, pixel := range newConfig.Pixels {
+		newPixels[pixel.ID] = pixel
+	}
+
+	var oldOrder, newOrder []string
+	for _, pixel := range oldConfig.Pixels {
+		if _, exists := newPixels[pixel.ID]; !exists {
+			diff.Removed = append(diff.Removed, pixel.ID)
+			continue
+		}
+		oldOrder = append(oldOrder, pixel.ID)
+	}
+	for _, pixel := range newConfig.Pixels {
+		oldPixel, exists := oldPixels[pixel.ID]
+		if !exists {
+			diff.Added = append(diff.Added, pixel.ID)
+			continue
+		}
+		newOrder = append(newOrder, pixel.ID)
+
+		fields, err := diffPixel(oldPixel, pixel)
+		if err != nil {
+			return nil, fmt.Errorf("error comparing pixel %s: %w", pixel.ID, err)
+		}
+		if len(fields) > 0 {
+			diff.Changed = append(diff.Changed, PixelChange{ID: pixel.ID, Fields: fields})
+		}
+	}
+	diff.Reordered = strings.Join(oldOrder, "\x00") != strings.Join(newOrder, "\x00")
+
+	oldContent, _, err := ProcessContainerConfig(oldConfig, esiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error generating old config: %w", err)
+	}
+	newContent, _, err := ProcessContainerConfig(newConfig, esiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error generating new config: %w", err)
+	}
+	diff.ESIDiff = diffLines(strings.Split(oldContent, "\n"), strings.Split(newContent, "\n"))
+
+	return diff, nil
+}
+
+// diffPixel returns the changed properties of a pixel, as they are written in the config
+func diffPixel(oldPixel, newPixel Pixel) ([]FieldChange, error) {
+	oldFields, err := flattenPixel(oldPixel)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flattenPixel(newPixel)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []FieldChange
+	for _, path := range unionKeys(oldFields, newFields) {
+		if path == "URL" {
+			changes = append(changes, diffURL(oldFields[path], newFields[path])...)
+			continue
+		}
+		if oldFields[path] != newFields[path] {
+			changes = append(changes, FieldChange{Path: path, Old: oldFields[path], New: newFields[path]})
+		}
+	}
+	return changes, nil
+}
+
+// flattenPixel maps the property paths of a pixel to their JSON values. Objects are
+// descended into; strings are unquoted and other values are kept as JSON.
+func flattenPixel(pixel Pixel) (map[string]string, error) {
+	data, err := json.Marshal(pixel)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	var flatten func(prefix string, value interface{})
+	flatten = func(prefix string, value interface{}) {
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			for name, nested := range typed {
+				flatten(strings.TrimPrefix(prefix+"."+name, "."), nested)
+			}
+		case string:
+			if typed != "" {
+				fields[prefix] = typed
+			}
+		case nil:
+		default:
+			encoded, _ := json.Marshal(typed)
+			fields[prefix] = string(encoded)
+		}
+	}
+	flatten("", object)
+	return fields, nil
+}
+
+// diffURL compares two beacon URLs, reporting query parameters individually
+func diffURL(oldURL, newURL string) []FieldChange {
+	if oldURL == newURL {
+		return nil
+	}
+
+	oldBase, oldQuery, _ := strings.Cut(oldURL, "?")
+	newBase, newQuery, _ := strings.Cut(newURL, "?")
+	oldParams, oldErr := url.ParseQuery(oldQuery)
+	newParams, newErr := url.ParseQuery(newQuery)
+	if oldErr != nil || newErr != nil {
+		return []FieldChange{{Path: "URL", Old: oldURL, New: newURL}}
+	}
+
+	var changes []FieldChange
+	if oldBase != newBase {
+		changes = append(changes, FieldChange{Path: "URL", Old: oldBase, New: newBase})
+	}
+	oldValues, newValues := joinParams(oldParams), joinParams(newParams)
+	for _, name := range unionKeys(oldValues, newValues) {
+		if oldValues[name] != newValues[name] {
+			changes = append(changes, FieldChange{Path: "URL?" + name, Old: oldValues[name], New: newValues[name]})
+		}
+	}
+	if len(changes) == 0 {
+		// Only the parameter order changed
+		changes = append(changes, FieldChange{Path: "URL", Old: oldURL, New: newURL})
+	}
+	return changes
+}
+
+// joinParams joins the values of repeated query parameters
+func joinParams(params url.Values) map[string]string {
+	joined := make(map[string]string, len(params))
+	for name, values := range params {
+		joined[name] = strings.Join(values, ",")
+	}
+	return joined
+}
+
+// unionKeys returns the sorted keys of both maps
+func unionKeys(a, b map[string]string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var keys []string
+	for _, m := range []map[string]string{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffLines returns the removed ("- ") and added ("+ ") lines turning a into b,
+// in order, based on their longest common subsequence
+func diffLines(a, b []string) []string {
+	var lines []string
+	for _, edit := range lineEdits(a, b) {
+		if !strings.HasPrefix(edit, "  ") {
+			lines = append(lines, edit)
+		}
+	}
+	return lines
+}
+
+// lineEdits returns the edit script turning a into b: every line of both, prefixed
+// with "  " when kept, "- " when removed or "+ " when added
+func lineEdits(a, b []string) []string {
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var edits []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, "  "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			edits = append(edits, "- "+a[i])
+			i++
+		default:
+			edits = append(edits, "+ "+b[j])
+			j++
+		}
+	}
+	return edits
+}
+
+// Report renders the diff as a human-readable change report
+func (d *ConfigDiff) Report() string {
+	if d.Empty() {
+		return "No changes\n"
+	}
+
+	var report strings.Builder
+	for _, id := range d.Added {
+		fmt.Fprintf(&report, "+ pixel %s added\n", id)
+	}
+	for _, id := range d.Removed {
+		fmt.Fprintf(&report, "- pixel %s removed\n", id)
+	}
+	for _, change := range d.Changed {
+		fmt.Fprintf(&report, "~ pixel %s changed:\n", change.ID)
+		for _, field := range change.Fields {
+			switch {
+			case field.Old == "":
+				fmt.Fprintf(&report, "    %s: added %s\n", field.Path, field.New)
+			case field.New == "":
+				fmt.Fprintf(&report, "    %s: removed %s\n", field.Path, field.Old)
+			default:
+				fmt.Fprintf(&report, "    %s: %s → %s\n", field.Path, field.Old, field.New)
+			}
+		}
+	}
+	if d.Reordered {
+		report.WriteString("~ pixel order changed\n")
+	}
+
+	if len(d.ESIDiff) > 0 {
+		report.WriteString("\nGenerated ESI:\n")
+		for _, line := range d.ESIDiff {
+			report.WriteString(line)
+			report.WriteString("\n")
+		}
+	}
+	return report.String()
+}
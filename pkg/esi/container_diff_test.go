@@ -0,0 +1,79 @@
+package esi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	oldConfig := ContainerConfig{Pixels: []Pixel{
+		{ID: "a", URL: "https://a.example/p.gif?u=~~uu~~&v=1"},
+		{ID: "b", URL: "https://b.example/p.gif", CONDITIONS: &PixelConditions{Countries: []string{"US"}}},
+		{ID: "gone", URL: "https://c.example/p.gif"},
+		{ID: "browser", URL: "https://d.example/f.html", TYPE: "frm"},
+	}}
+	newConfig := ContainerConfig{Pixels: []Pixel{
+		{ID: "a", URL: "https://a.example/p.gif?u=~~uu~~&v=2&cc=~~cc~~"},
+		{ID: "b", URL: "https://b2.example/p.gif", CONDITIONS: &PixelConditions{Countries: []string{"US", "CA"}, FrequencyCap: 2}},
+		{ID: "browser", URL: "https://d.example/f.html", TYPE: "frm"},
+		{ID: "new", URL: "https://e.example/p.gif"},
+	}}
+
+	diff, err := DiffConfigs(oldConfig, newConfig, ESIConfig{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"new"}, diff.Added)
+	assert.Equal(t, []string{"gone"}, diff.Removed)
+	assert.False(t, diff.Reordered)
+	assert.Equal(t, []PixelChange{
+		{ID: "a", Fields: []FieldChange{
+			{Path: "URL?cc", New: "~~cc~~"},
+			{Path: "URL?v", Old: "1", New: "2"},
+		}},
+		{ID: "b", Fields: []FieldChange{
+			{Path: "CONDITIONS.countries", Old: `["US"]`, New: `["US","CA"]`},
+			{Path: "CONDITIONS.frequencyCap", New: "2"},
+			{Path: "URL", Old: "https://b.example/p.gif", New: "https://b2.example/p.gif"},
+		}},
+	}, diff.Changed)
+
+	assert.Contains(t, diff.ESIDiff, `- <esi:include src="https://c.example/p.gif" maxwait="0" />`)
+	assert.Contains(t, diff.ESIDiff, `+ <esi:include src="https://e.example/p.gif" maxwait="0" />`)
+	for _, line := range diff.ESIDiff {
+		assert.NotContains(t, line, "d.example")
+	}
+
+	report := diff.Report()
+	assert.Contains(t, report, "+ pixel new added\n- pixel gone removed\n~ pixel a changed:\n    URL?cc: added ~~cc~~\n    URL?v: 1 → 2\n")
+	assert.Contains(t, report, "\nGenerated ESI:\n")
+}
+
+func TestDiffConfigs_Unchanged(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{{ID: "a", URL: "https://a.example/p.gif"}}}
+
+	diff, err := DiffConfigs(config, config, ESIConfig{})
+	require.NoError(t, err)
+	assert.True(t, diff.Empty())
+	assert.Equal(t, "No changes\n", diff.Report())
+}
+
+func TestDiffConfigs_Reordered(t *testing.T) {
+	a := Pixel{ID: "a", URL: "https://a.example/p.gif"}
+	b := Pixel{ID: "b", URL: "https://b.example/p.gif"}
+
+	diff, err := DiffConfigs(ContainerConfig{Pixels: []Pixel{a, b}}, ContainerConfig{Pixels: []Pixel{b, a}}, ESIConfig{})
+	require.NoError(t, err)
+	assert.True(t, diff.Reordered)
+	assert.Empty(t, diff.Changed)
+	assert.Equal(t, []string{
+		`- <esi:include src="https://a.example/p.gif" maxwait="0" />`,
+		`+ <esi:include src="https://a.example/p.gif" maxwait="0" />`,
+	}, diff.ESIDiff)
+}
+
+func TestDiffLines(t *testing.T) {
+	assert.Equal(t, []string{"- b", "+ x", "+ d"}, diffLines([]string{"a", "b", "c"}, []string{"a", "x", "c", "d"}))
+	assert.Empty(t, diffLines([]string{"a"}, []string{"a"}))
+}
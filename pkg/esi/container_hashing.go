@@ -0,0 +1,215 @@
+package esi
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esigen"
+)
+
+// Hash algorithms supported for cookie hashing and suu fingerprints
+const (
+	HashMD5    = "md5"
+	HashSHA1   = "sha1"
+	HashSHA256 = "sha256"
+)
+
+// HashConfig configures the hpr/hpo cookie hashing and suu fingerprint pipeline
+type HashConfig struct {
+	// Algorithm is md5 (default), sha1 or sha256. Runtime ESI functions only provide md5.
+	Algorithm string
+	// Salt is used by cookie hash macros that do not name their own salt
+	Salt string
+}
+
+// algorithm returns the configured algorithm, defaulting to md5
+func (h HashConfig) algorithm() string {
+	if h.Algorithm == "" {
+		return HashMD5
+	}
+	return strings.ToLower(h.Algorithm)
+}
+
+// Validate checks that the algorithm is supported in the given condition mode
+func (h HashConfig) Validate(mode string) error {
+	switch h.algorithm() {
+	case HashMD5:
+		return nil
+	case HashSHA1, HashSHA256:
+		if mode != ConditionModeStatic {
+			return fmt.Errorf("hash algorithm %s needs static mode: runtime ESI functions only provide md5", h.algorithm())
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported hash algorithm %q", h.Algorithm)
+	}
+}
+
+// HashHex returns the hex digest of value with the named algorithm
+func HashHex(algorithm, value string) (string, error) {
+	var hasher hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "", HashMD5:
+		hasher = md5.New()
+	case HashSHA1:
+		hasher = sha1.New()
+	case HashSHA256:
+		hasher = sha256.New()
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+	hasher.Write([]byte(value))
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// HashCookie hashes a cookie value: hpr prefixes the salt, hpo appends it
+func HashCookie(cookieValue, salt, hashType, algorithm string) (string, error) {
+	switch hashType {
+	case "hpr":
+		return HashHex(algorithm, salt+cookieValue)
+	case "hpo":
+		return HashHex(algorithm, cookieValue+salt)
+	default:
+		return HashHex(algorithm, cookieValue)
+	}
+}
+
+// SUU returns the suu fingerprint of a request: a digest of its client IP, Accept and User-Agent headers
+func SUU(ctx *ConditionContext, algorithm string) (string, error) {
+	return HashHex(algorithm, ctx.ClientIP+headerValue(ctx.Headers, "Accept")+headerValue(ctx.Headers, "User-Agent"))
+}
+
+// cookieHashMacro is a parsed ~~c~name~hpr|hpo~salt~~ macro
+type cookieHashMacro struct {
+	cookie   string
+	hashType string
+	salt     string
+}
+
+// parseCookieHashMacro recognises cookie macros with a hash directive; the salt is optional
+func parseCookieHashMacro(parts []string, config ESIConfig) (cookieHashMacro, bool) {
+	if len(parts) < 3 || parts[0] != "c" || (parts[2] != "hpr" && parts[2] != "hpo") {
+		return cookieHashMacro{}, false
+	}
+	macro := cookieHashMacro{cookie: parts[1], hashType: parts[2], salt: config.Hashing.Salt}
+	if len(parts) >= 4 && parts[3] != "" {
+		macro.salt = parts[3]
+	}
+	return macro, true
+}
+
+// variable returns the name of the ESI variable holding the runtime hash.
+// The salt is digested into the name so different salts never share a variable;
+// hex digits are mapped to letters because variable names may not contain digits.
+func (m cookieHashMacro) variable() string {
+	return fmt.Sprintf("%s_%s_%s", m.hashType, sanitizeVariableName(m.cookie), letterDigest(m.salt))
+}
+
+// letterDigest returns a short digest of value spelled with letters only
+func letterDigest(value string) string {
+	digest, _ := HashHex(HashMD5, value)
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return 'a' + (r - '0')
+		}
+		return 'k' + (r - 'a')
+	}, digest[:8])
+}
+
+// suuVariable is the ESI variable holding the runtime suu fingerprint
+const suuVariable = "suu"
+
+// staticHashValue resolves hash and suu macros against the static context at generation time
+func staticHashValue(parts []string, config ESIConfig) (string, bool, error) {
+	ctx := config.StaticContext
+	if ctx == nil {
+		ctx = &ConditionContext{}
+	}
+
+	if len(parts) == 1 && parts[0] == "suu" {
+		value, err := SUU(ctx, config.Hashing.algorithm())
+		return value, true, err
+	}
+	if macro, ok := parseCookieHashMacro(parts, config); ok {
+		value, err := HashCookie(ctx.Cookies[macro.cookie], macro.salt, macro.hashType, config.Hashing.algorithm())
+		return value, true, err
+	}
+	return "", false, nil
+}
+
+// hashVariable is an ESI variable computed at runtime by a function from GenerateESIFunctions
+type hashVariable struct {
+	name     string
+	function string
+	args     []string
+}
+
+// expression returns the ESI function call computing the variable
+func (v hashVariable) expression() string {
+	if v.function == transformFunction {
+		return transformExpression(v.args)
+	}
+	quoted := make([]string, len(v.args))
+	for i, arg := range v.args {
+		quoted[i] = "'" + arg + "'"
+	}
+	return fmt.Sprintf("$%s(%s)", v.function, strings.Join(quoted, ", "))
+}
+
+// hashVariables returns the variables computing the hash, suu and transformed macros of
+// a URL at runtime. In static mode hashes and suu are known at generation time, so only
+// transforms of runtime values remain.
+func hashVariables(urlStr string, config ESIConfig) []hashVariable {
+	var variables []hashVariable
+	seen := make(map[string]bool)
+	add := func(variable hashVariable) {
+		if !seen[variable.name] {
+			seen[variable.name] = true
+			variables = append(variables, variable)
+		}
+	}
+
+	for _, match := range macroPattern.FindAllStringSubmatch(urlStr, -1) {
+		base, _, err := parseMacroModifiers(match[1])
+		if err != nil {
+			continue
+		}
+		parts := splitMacro(base)
+
+		if config.ConditionMode != ConditionModeStatic {
+			if len(parts) == 1 && parts[0] == "suu" {
+				add(hashVariable{name: suuVariable, function: "generate_simple_suu"})
+			} else if macro, ok := parseCookieHashMacro(parts, config); ok {
+				add(hashVariable{name: macro.variable(), function: "cookie_hash", args: []string{macro.cookie, macro.hashType, macro.salt}})
+			}
+		}
+		if variable, ok := transformVariable(match[1], config); ok {
+			add(variable)
+		}
+	}
+	return variables
+}
+
+// hashAssignments returns the esi:assign elements computing the hash, suu and transformed
+// macros of a URL at runtime with the functions from GenerateESIFunctions
+func hashAssignments(urlStr string, config ESIConfig) []string {
+	var assignments []string
+	for _, variable := range hashVariables(urlStr, config) {
+		assignments = append(assignments, esigen.Assign(variable.name, variable.expression()).String())
+	}
+	return assignments
+}
+
+// variableNamePattern matches characters not allowed in ESI variable names
+var variableNamePattern = regexp.MustCompile(`[^A-Za-z_]`)
+
+// sanitizeVariableName replaces characters not allowed in ESI variable names
+func sanitizeVariableName(name string) string {
+	return variableNamePattern.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,102 @@
+package esi
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHashCookie(t *testing.T) {
+	tests := []struct {
+		name      string
+		hashType  string
+		algorithm string
+		expected  string
+	}{
+		{name: "hpr prefixes salt", hashType: "hpr", algorithm: HashMD5, expected: md5Hex("saltvalue")},
+		{name: "hpo appends salt", hashType: "hpo", algorithm: HashMD5, expected: md5Hex("valuesalt")},
+		{name: "default algorithm is md5", hashType: "hpr", expected: md5Hex("saltvalue")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashed, err := HashCookie("value", "salt", tt.hashType, tt.algorithm)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, hashed)
+		})
+	}
+
+	sum := sha256.Sum256([]byte("saltvalue"))
+	hashed, err := HashCookie("value", "salt", "hpr", HashSHA256)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), hashed)
+
+	_, err = HashCookie("value", "salt", "hpr", "crc32")
+	assert.Error(t, err)
+
+	// The md5 pipeline matches the existing helper
+	assert.Equal(t, GenerateCookieHash("value", "salt", "hpo"), md5Hex("valuesalt"))
+}
+
+func TestSUU(t *testing.T) {
+	ctx := &ConditionContext{
+		ClientIP: "203.0.113.1",
+		Headers:  map[string]string{"accept": "text/html", "User-Agent": "Mozilla/5.0"},
+	}
+	suu, err := SUU(ctx, HashMD5)
+	require.NoError(t, err)
+	assert.Equal(t, GenerateFingerprintID("203.0.113.1", "text/html", "Mozilla/5.0"), suu)
+}
+
+func TestHashMacros_Runtime(t *testing.T) {
+	pixel := Pixel{ID: "p1", TYPE: "dir",
+		URL: "https://example.com/p.gif?a=~~c~uid~hpr~s1~~&b=~~c~uid~hpo~~&c=~~c~uid~hpr~s1~~&f=~~suu~~"}
+
+	include, err := generateESIInclude(pixel, ESIConfig{Hashing: HashConfig{Salt: "default"}})
+	require.NoError(t, err)
+
+	hpr := cookieHashMacro{cookie: "uid", hashType: "hpr", salt: "s1"}.variable()
+	hpo := cookieHashMacro{cookie: "uid", hashType: "hpo", salt: "default"}.variable()
+	assert.Regexp(t, `^hpr_uid_[a-z]{8}$`, hpr)
+
+	assert.Contains(t, include, `<esi:assign name="`+hpr+`" value="$cookie_hash('uid', 'hpr', 's1')" />`)
+	assert.Contains(t, include, `<esi:assign name="`+hpo+`" value="$cookie_hash('uid', 'hpo', 'default')" />`)
+	assert.Contains(t, include, `<esi:assign name="suu" value="$generate_simple_suu()" />`)
+	assert.Contains(t, include, "a=$("+hpr+")&amp;b=$("+hpo+")&amp;c=$("+hpr+")&amp;f=$(suu)")
+	assert.Equal(t, 3, strings.Count(include, "<esi:assign"))
+}
+
+func TestHashMacros_Static(t *testing.T) {
+	pixel := Pixel{ID: "p1", TYPE: "dir", URL: "https://example.com/p.gif?a=~~c~uid~hpr~s1~~&f=~~suu~~"}
+	config := ESIConfig{
+		ConditionMode: ConditionModeStatic,
+		StaticContext: &ConditionContext{ClientIP: "203.0.113.1", Cookies: map[string]string{"uid": "abc"}},
+	}
+
+	include, err := generateESIInclude(pixel, config)
+	require.NoError(t, err)
+
+	suu, _ := SUU(config.StaticContext, HashMD5)
+	assert.Contains(t, include, "a="+md5Hex("s1abc")+"&amp;f="+suu)
+	assert.NotContains(t, include, "<esi:assign")
+}
+
+func TestHashConfig_Validate(t *testing.T) {
+	assert.NoError(t, HashConfig{}.Validate(ConditionModeRuntime))
+	assert.NoError(t, HashConfig{Algorithm: "SHA256"}.Validate(ConditionModeStatic))
+	assert.Error(t, HashConfig{Algorithm: HashSHA256}.Validate(ConditionModeRuntime))
+	assert.Error(t, HashConfig{Algorithm: "crc32"}.Validate(ConditionModeStatic))
+
+	_, _, err := ProcessContainerConfig(ContainerConfig{}, ESIConfig{Hashing: HashConfig{Algorithm: HashSHA1}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,214 @@
+package esi
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Macro modifiers transform the value of a macro: ~~c~uid|hash:sha256~~,
+// ~~dl:qs~page|urlencode~~ or ~~c~segment|default:none~~. Modifiers apply left to right.
+const (
+	ModifierDefault   = "default"
+	ModifierURLEncode = "urlencode"
+	ModifierHash      = "hash"
+)
+
+// transformFunction is the runtime function of variables holding a transformed macro
+const transformFunction = "transform"
+
+// errModifier marks invalid macro modifiers, which must fail generation
+var errModifier = errors.New("invalid macro modifier")
+
+// macroModifier is a parsed |name[:arg] modifier
+type macroModifier struct {
+	name string
+	arg  string
+}
+
+// String returns the modifier as written in a macro
+func (m macroModifier) String() string {
+	if m.arg == "" {
+		return m.name
+	}
+	return m.name + ":" + m.arg
+}
+
+// isFunction reports whether the modifier needs a runtime function. Defaults are
+// folded into the variable reference instead.
+func (m macroModifier) isFunction() bool {
+	return m.name != ModifierDefault
+}
+
+// parseModifier parses and checks a single modifier
+func parseModifier(raw string) (macroModifier, error) {
+	name, arg, _ := strings.Cut(raw, ":")
+	modifier := macroModifier{name: name, arg: arg}
+
+	switch name {
+	case ModifierDefault:
+		if strings.ContainsAny(arg, "')") {
+			return modifier, fmt.Errorf("default value %q may not contain ' or )", arg)
+		}
+	case ModifierURLEncode:
+		if arg != "" {
+			return modifier, fmt.Errorf("urlencode takes no argument")
+		}
+	case ModifierHash:
+		modifier.arg = strings.ToLower(arg)
+		if modifier.arg == "" {
+			modifier.arg = HashMD5
+		}
+		if _, err := HashHex(modifier.arg, ""); err != nil {
+			return modifier, err
+		}
+	default:
+		return modifier, fmt.Errorf("unknown modifier %q, must be one of %s, %s, %s", name, ModifierDefault, ModifierURLEncode, ModifierHash)
+	}
+	return modifier, nil
+}
+
+// parseMacroModifiers splits the modifiers off a macro. A default must come first,
+// since it replaces the empty value of the macro itself.
+func parseMacroModifiers(macro string) (string, []macroModifier, error) {
+	fields := strings.Split(macro, "|")
+	var modifiers []macroModifier
+	for i, raw := range fields[1:] {
+		modifier, err := parseModifier(raw)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w in ~~%s~~: %v", errModifier, macro, err)
+		}
+		if modifier.name == ModifierDefault && i > 0 {
+			return "", nil, fmt.Errorf("%w in ~~%s~~: default must be the first modifier", errModifier, macro)
+		}
+		modifiers = append(modifiers, modifier)
+	}
+	return fields[0], modifiers, nil
+}
+
+// applyModifiers transforms a value known at generation time
+func applyModifiers(value string, modifiers []macroModifier) (string, error) {
+	for _, modifier := range modifiers {
+		switch modifier.name {
+		case ModifierDefault:
+			if value == "" {
+				value = modifier.arg
+			}
+		case ModifierURLEncode:
+			value = url.QueryEscape(value)
+		case ModifierHash:
+			hashed, err := HashHex(modifier.arg, value)
+			if err != nil {
+				return "", err
+			}
+			value = hashed
+		}
+	}
+	return value, nil
+}
+
+// modifierExpression wraps an ESI variable reference in the expression applying the
+// modifiers at runtime. Runtime ESI functions only provide md5.
+func modifierExpression(reference string, modifiers []macroModifier) (string, error) {
+	expression := reference
+	for _, modifier := range modifiers {
+		switch modifier.name {
+		case ModifierDefault:
+			expression = strings.TrimSuffix(expression, ")") + "|'" + modifier.arg + "')"
+		case ModifierURLEncode:
+			expression = "$url_encode(" + expression + ")"
+		case ModifierHash:
+			if modifier.arg != HashMD5 {
+				return "", fmt.Errorf("modifier %s needs a value known at generation time: runtime ESI functions only provide md5", modifier)
+			}
+			expression = "$digest_md5_hex(" + expression + ")"
+		}
+	}
+	return expression, nil
+}
+
+// hasFunctionModifier reports whether any modifier needs a runtime function
+func hasFunctionModifier(modifiers []macroModifier) bool {
+	for _, modifier := range modifiers {
+		if modifier.isFunction() {
+			return true
+		}
+	}
+	return false
+}
+
+// transformVariableName returns the ESI variable holding a transformed macro
+func transformVariableName(macro string) string {
+	return "mx_" + letterDigest(macro)
+}
+
+// processModifiedMacro substitutes a macro with modifiers. Values known at generation
+// time are transformed directly; otherwise a default is folded into the variable
+// reference and function modifiers refer to a variable assigned before the include.
+func processModifiedMacro(macro, base string, modifiers []macroModifier, config ESIConfig) (string, error) {
+	parts := splitMacro(base)
+
+	if config.ConditionMode == ConditionModeStatic {
+		value, ok, err := staticHashValue(parts, config)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errHashing, err)
+		}
+		if ok {
+			transformed, err := applyModifiers(value, modifiers)
+			if err != nil {
+				return "", fmt.Errorf("%w in ~~%s~~: %v", errModifier, macro, err)
+			}
+			return transformed, nil
+		}
+	}
+
+	reference, err := processMacroParts(parts, config)
+	if err != nil {
+		return "", err
+	}
+	if !hasFunctionModifier(modifiers) {
+		return modifierExpression(reference, modifiers)
+	}
+	if _, err := modifierExpression(reference, modifiers); err != nil {
+		return "", fmt.Errorf("%w in ~~%s~~: %v", errModifier, macro, err)
+	}
+	return "$(" + transformVariableName(macro) + ")", nil
+}
+
+// transformVariable returns the runtime variable of a macro with function modifiers.
+// Its arguments are the variable reference of the macro followed by the modifiers.
+func transformVariable(macro string, config ESIConfig) (hashVariable, bool) {
+	base, modifiers, err := parseMacroModifiers(macro)
+	if err != nil || !hasFunctionModifier(modifiers) {
+		return hashVariable{}, false
+	}
+
+	parts := splitMacro(base)
+	if config.ConditionMode == ConditionModeStatic {
+		if _, ok, _ := staticHashValue(parts, config); ok {
+			return hashVariable{}, false
+		}
+	}
+	reference, err := processMacroParts(parts, config)
+	if err != nil {
+		return hashVariable{}, false
+	}
+
+	args := []string{reference}
+	for _, modifier := range modifiers {
+		args = append(args, modifier.String())
+	}
+	return hashVariable{name: transformVariableName(macro), function: transformFunction, args: args}, true
+}
+
+// transformExpression returns the ESI expression computing a transform variable
+func transformExpression(args []string) string {
+	var modifiers []macroModifier
+	for _, raw := range args[1:] {
+		modifier, _ := parseModifier(raw)
+		modifiers = append(modifiers, modifier)
+	}
+	expression, _ := modifierExpression(args[0], modifiers)
+	return expression
+}
@@ -0,0 +1,101 @@
+package esi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMacroModifiers(t *testing.T) {
+	base, modifiers, err := parseMacroModifiers("c~uid|default:anon|urlencode|hash:SHA256")
+	require.NoError(t, err)
+	assert.Equal(t, "c~uid", base)
+	assert.Equal(t, []macroModifier{{name: ModifierDefault, arg: "anon"}, {name: ModifierURLEncode}, {name: ModifierHash, arg: HashSHA256}}, modifiers)
+
+	_, modifiers, err = parseMacroModifiers("uu|hash")
+	require.NoError(t, err)
+	assert.Equal(t, []macroModifier{{name: ModifierHash, arg: HashMD5}}, modifiers)
+
+	tests := []struct {
+		macro    string
+		expected string
+	}{
+		{macro: "uu|upper", expected: `invalid macro modifier in ~~uu|upper~~: unknown modifier "upper", must be one of default, urlencode, hash`},
+		{macro: "uu|hash:crc32", expected: `invalid macro modifier in ~~uu|hash:crc32~~: unsupported hash algorithm "crc32"`},
+		{macro: "uu|urlencode:x", expected: "invalid macro modifier in ~~uu|urlencode:x~~: urlencode takes no argument"},
+		{macro: "uu|default:a)", expected: `invalid macro modifier in ~~uu|default:a)~~: default value "a)" may not contain ' or )`},
+		{macro: "uu|hash|default:x", expected: "invalid macro modifier in ~~uu|hash|default:x~~: default must be the first modifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.macro, func(t *testing.T) {
+			_, _, err := parseMacroModifiers(tt.macro)
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func TestModifiedMacros_Runtime(t *testing.T) {
+	pixel := Pixel{ID: "p1", URL: "https://example.com/p.gif?seg=~~c~seg|default:none~~&page=~~dl:qs~page|urlencode~~&u=~~c~uid~hpr~s|hash~~"}
+
+	include, err := generateESIInclude(pixel, ESIConfig{})
+	require.NoError(t, err)
+
+	hpr := cookieHashMacro{cookie: "uid", hashType: "hpr", salt: "s"}.variable()
+	page := transformVariableName("dl:qs~page|urlencode")
+	uid := transformVariableName("c~uid~hpr~s|hash")
+	assert.Contains(t, include, "seg=$(HTTP_COOKIE{seg}|'none')&amp;page=$("+page+")&amp;u=$("+uid+")")
+	assert.Contains(t, include, `<esi:assign name="`+page+`" value="$url_encode($(PMUSER_DECODED_QS_{page}))" />`)
+	assert.Contains(t, include, `<esi:assign name="`+hpr+`" value="$cookie_hash('uid', 'hpr', 's')" />`)
+	assert.Contains(t, include, `<esi:assign name="`+uid+`" value="$digest_md5_hex($(`+hpr+`))" />`)
+
+	_, err = generateESIInclude(Pixel{ID: "p2", URL: "https://example.com/?u=~~uu|hash:sha256~~"}, ESIConfig{})
+	assert.EqualError(t, err, "error processing macros in URL: invalid macro modifier in ~~uu|hash:sha256~~: modifier hash:sha256 needs a value known at generation time: runtime ESI functions only provide md5")
+
+	_, err = generateESIInclude(Pixel{ID: "p3", URL: "https://example.com/?u=~~uu|lower~~"}, ESIConfig{})
+	assert.Error(t, err)
+}
+
+func TestModifiedMacros_Static(t *testing.T) {
+	pixel := Pixel{ID: "p1", URL: "https://example.com/p.gif?u=~~c~uid~hpo~s|hash:sha256~~&c=~~cc|urlencode~~"}
+	config := ESIConfig{
+		ConditionMode: ConditionModeStatic,
+		StaticContext: &ConditionContext{Cookies: map[string]string{"uid": "abc"}},
+	}
+
+	include, err := generateESIInclude(pixel, config)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte(md5Hex("abcs")))
+	country := transformVariableName("cc|urlencode")
+	assert.Contains(t, include, "u="+hex.EncodeToString(sum[:])+"&amp;c=$("+country+")")
+	assert.Contains(t, include, `<esi:assign name="`+country+`" value="$url_encode($(GEO_COUNTRY))" />`)
+}
+
+func TestModifiedMacros_Simulation(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "p1", URL: "https://example.com/p.gif?seg=~~c~seg|default:none~~&ref=~~c~ref|urlencode~~&u=~~c~uid|default:anon|hash~~"},
+	}}
+	options := SimulationOptions{Context: ProcessContext{
+		Headers: map[string]string{},
+		Cookies: map[string]string{"ref": "a b&c"},
+	}}
+
+	report, err := SimulateContainer(config, ESIConfig{}, options)
+	require.NoError(t, err)
+	require.Len(t, report.Beacons, 1)
+	assert.Equal(t, "https://example.com/p.gif?seg=none&ref="+url.QueryEscape("a b&c")+"&u="+md5Hex("anon"), report.Beacons[0].URL)
+}
+
+func TestApplyModifiers(t *testing.T) {
+	value, err := applyModifiers("", []macroModifier{{name: ModifierDefault, arg: "a b"}, {name: ModifierURLEncode}})
+	require.NoError(t, err)
+	assert.Equal(t, "a+b", value)
+
+	value, err = applyModifiers("x", []macroModifier{{name: ModifierDefault, arg: "y"}, {name: ModifierHash, arg: HashMD5}})
+	require.NoError(t, err)
+	assert.Equal(t, md5Hex("x"), value)
+}
@@ -0,0 +1,221 @@
+package esi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PartnerTemplate holds the defaults pixels of a partner inherit through their partner field
+type PartnerTemplate struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// URL is the beacon URL pattern. {param} placeholders are filled from the
+	// pixel's partnerParams; macros are kept for generation.
+	URL      string            `json:"url"`
+	Type     string            `json:"type,omitempty"`
+	Method   string            `json:"method,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	BodyType string            `json:"bodyType,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// RequiredMacros are macros the pixel URL or body must keep, e.g. "uu"
+	RequiredMacros []string `json:"requiredMacros,omitempty"`
+	// TCFVendor and TCFPurposes become the consent conditions of pixels that set none
+	TCFVendor   int   `json:"tcfVendor,omitempty"`
+	TCFPurposes []int `json:"tcfPurposes,omitempty"`
+}
+
+// PartnerRegistry holds partner templates by lowercase name
+type PartnerRegistry struct {
+	partners map[string]PartnerTemplate
+	mutex    sync.RWMutex
+}
+
+// NewPartnerRegistry creates a registry with the built-in partner templates
+func NewPartnerRegistry() *PartnerRegistry {
+	registry := &PartnerRegistry{partners: make(map[string]PartnerTemplate)}
+	for _, template := range builtinPartners {
+		if err := registry.Register(template); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+// DefaultPartners is the registry used when ESIConfig.Partners is not set
+var DefaultPartners = NewPartnerRegistry()
+
+// RegisterPartner registers a custom partner template in DefaultPartners
+func RegisterPartner(template PartnerTemplate) error {
+	return DefaultPartners.Register(template)
+}
+
+// Register adds a partner template, replacing any template of the same name
+func (r *PartnerRegistry) Register(template PartnerTemplate) error {
+	name := strings.ToLower(strings.TrimSpace(template.Name))
+	if name == "" {
+		return fmt.Errorf("partner template needs a name")
+	}
+	if template.URL == "" {
+		return fmt.Errorf("partner %s needs a URL pattern", name)
+	}
+	if template.TCFVendor < 0 {
+		return fmt.Errorf("partner %s has a negative TCF vendor ID", name)
+	}
+	for _, macro := range template.RequiredMacros {
+		if !containsMacro(template.URL+template.Body, macro) {
+			return fmt.Errorf("partner %s requires macro ~~%s~~, which its own URL pattern lacks", name, macro)
+		}
+	}
+
+	template.Name = name
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.partners[name] = template
+	return nil
+}
+
+// Lookup returns the template of a partner
+func (r *PartnerRegistry) Lookup(name string) (PartnerTemplate, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	template, exists := r.partners[strings.ToLower(strings.TrimSpace(name))]
+	return template, exists
+}
+
+// Names returns the registered partner names in sorted order
+func (r *PartnerRegistry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(r.partners))
+	for name := range r.partners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// builtinPartners are the templates every registry starts with
+var builtinPartners = []PartnerTemplate{
+	{
+		Name:           "examplepartner",
+		Description:    "Cookie sync pixel keyed by account",
+		URL:            "https://sync.examplepartner.com/{account}/px.gif?uid=~~uu~~&cc=~~cc~~&r=~~r~~",
+		Type:           "dir",
+		RequiredMacros: []string{"uu"},
+		TCFVendor:      1001,
+		TCFPurposes:    []int{1},
+	},
+	{
+		Name:           "examplecollector",
+		Description:    "JSON event collector",
+		URL:            "https://collect.examplecollector.com/v1/events",
+		Type:           "dir",
+		Method:         "POST",
+		BodyType:       BodyTypeJSON,
+		Body:           `{"site":"{site}","event":"pageview","evid":"~~evid~~"}`,
+		RequiredMacros: []string{"evid"},
+		TCFVendor:      1002,
+		TCFPurposes:    []int{1, 7},
+	},
+}
+
+// partnerParamPattern matches {param} placeholders of partner URL patterns
+var partnerParamPattern = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// applyPartner fills the unset fields of a pixel from its partner template
+func applyPartner(pixel Pixel, registry *PartnerRegistry) (Pixel, error) {
+	if pixel.Partner == "" {
+		return pixel, nil
+	}
+	if registry == nil {
+		registry = DefaultPartners
+	}
+	template, exists := registry.Lookup(pixel.Partner)
+	if !exists {
+		return Pixel{}, fmt.Errorf("pixel %s references unknown partner %q (known: %s)", pixel.ID, pixel.Partner, strings.Join(registry.Names(), ", "))
+	}
+
+	fill := func(value string) (string, error) {
+		var missing []string
+		filled := partnerParamPattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+			name := placeholder[1 : len(placeholder)-1]
+			param, exists := pixel.PartnerParams[name]
+			if !exists {
+				missing = append(missing, name)
+			}
+			return param
+		})
+		if len(missing) > 0 {
+			return "", fmt.Errorf("pixel %s is missing partnerParams %s required by partner %s", pixel.ID, strings.Join(missing, ", "), template.Name)
+		}
+		return filled, nil
+	}
+
+	var err error
+	if pixel.URL == "" {
+		if pixel.URL, err = fill(template.URL); err != nil {
+			return Pixel{}, err
+		}
+	}
+	if pixel.BODY == "" && template.Body != "" {
+		if pixel.BODY, err = fill(template.Body); err != nil {
+			return Pixel{}, err
+		}
+	}
+	if pixel.TYPE == "" {
+		pixel.TYPE = template.Type
+	}
+	if pixel.METHOD == "" {
+		pixel.METHOD = template.Method
+	}
+	if pixel.BODY_TYPE == "" {
+		pixel.BODY_TYPE = template.BodyType
+	}
+	if len(template.Headers) > 0 {
+		headers := make(map[string]string, len(template.Headers)+len(pixel.HEADERS))
+		for name, value := range template.Headers {
+			headers[name] = value
+		}
+		for name, value := range pixel.HEADERS {
+			headers[name] = value
+		}
+		pixel.HEADERS = headers
+	}
+
+	// Copy the conditions so pixels never share them with the configuration
+	if template.TCFVendor > 0 {
+		conditions := PixelConditions{}
+		if pixel.CONDITIONS != nil {
+			conditions = *pixel.CONDITIONS
+		}
+		if conditions.TCFVendor == 0 {
+			conditions.TCFVendor = template.TCFVendor
+			if len(conditions.TCFPurposes) == 0 {
+				conditions.TCFPurposes = template.TCFPurposes
+			}
+		}
+		pixel.CONDITIONS = &conditions
+	}
+
+	for _, macro := range template.RequiredMacros {
+		if !containsMacro(pixel.URL+pixel.BODY, macro) {
+			return Pixel{}, fmt.Errorf("pixel %s drops macro ~~%s~~ required by partner %s", pixel.ID, macro, template.Name)
+		}
+	}
+
+	return pixel, nil
+}
+
+// containsMacro reports whether value uses the named macro, with or without parameters
+func containsMacro(value, name string) bool {
+	for _, match := range macroPattern.FindAllStringSubmatch(value, -1) {
+		base, _, _ := parseMacroModifiers(match[1])
+		if base == name || splitMacro(base)[0] == name {
+			return true
+		}
+	}
+	return false
+}
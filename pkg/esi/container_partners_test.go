@@ -0,0 +1,96 @@
+package esi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyPartner(t *testing.T) {
+	registry := NewPartnerRegistry()
+	require.NoError(t, registry.Register(PartnerTemplate{
+		Name:           "Acme",
+		URL:            "https://px.acme.com/{site}/b.gif?uid=~~uu~~",
+		Headers:        map[string]string{"X-Acme": "1", "X-Site": "default"},
+		RequiredMacros: []string{"uu"},
+		TCFVendor:      4242,
+		TCFPurposes:    []int{1},
+	}))
+
+	tests := []struct {
+		name        string
+		pixel       Pixel
+		expected    Pixel
+		expectedErr string
+	}{
+		{
+			name:  "inherits defaults",
+			pixel: Pixel{ID: "p1", Partner: "acme", PartnerParams: map[string]string{"site": "news"}, HEADERS: map[string]string{"X-Site": "news"}},
+			expected: Pixel{ID: "p1", Partner: "acme", PartnerParams: map[string]string{"site": "news"},
+				URL:        "https://px.acme.com/news/b.gif?uid=~~uu~~",
+				HEADERS:    map[string]string{"X-Acme": "1", "X-Site": "news"},
+				CONDITIONS: &PixelConditions{TCFVendor: 4242, TCFPurposes: []int{1}}},
+		},
+		{
+			name:  "pixel overrides keep their own vendor",
+			pixel: Pixel{ID: "p1", Partner: "ACME", URL: "https://px.acme.com/x.gif?u=~~uu~~", CONDITIONS: &PixelConditions{TCFVendor: 7, Countries: []string{"US"}}},
+			expected: Pixel{ID: "p1", Partner: "ACME", URL: "https://px.acme.com/x.gif?u=~~uu~~",
+				HEADERS:    map[string]string{"X-Acme": "1", "X-Site": "default"},
+				CONDITIONS: &PixelConditions{TCFVendor: 7, Countries: []string{"US"}}},
+		},
+		{
+			name:        "missing param",
+			pixel:       Pixel{ID: "p1", Partner: "acme"},
+			expectedErr: "pixel p1 is missing partnerParams site required by partner acme",
+		},
+		{
+			name:        "dropped macro",
+			pixel:       Pixel{ID: "p1", Partner: "acme", URL: "https://px.acme.com/x.gif"},
+			expectedErr: "pixel p1 drops macro ~~uu~~ required by partner acme",
+		},
+		{
+			name:        "unknown partner",
+			pixel:       Pixel{ID: "p1", Partner: "nobody"},
+			expectedErr: `pixel p1 references unknown partner "nobody" (known: acme, examplecollector, examplepartner)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pixel, err := applyPartner(tt.pixel, registry)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, pixel)
+		})
+	}
+}
+
+func TestPartnerRegistry_Register(t *testing.T) {
+	registry := NewPartnerRegistry()
+	assert.Error(t, registry.Register(PartnerTemplate{URL: "https://example.com"}))
+	assert.Error(t, registry.Register(PartnerTemplate{Name: "nourl"}))
+	assert.Error(t, registry.Register(PartnerTemplate{Name: "nomacro", URL: "https://example.com", RequiredMacros: []string{"uu"}}))
+
+	_, exists := DefaultPartners.Lookup("examplepartner")
+	assert.True(t, exists)
+}
+
+func TestProcessContainerConfig_Partners(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "sync", Partner: "examplepartner", PartnerParams: map[string]string{"account": "acme"}},
+		{ID: "events", Partner: "examplecollector", PartnerParams: map[string]string{"site": "news"}},
+	}}
+
+	esiContent, _, err := ProcessContainerConfig(config, ESIConfig{ConditionMode: ConditionModeStatic})
+	require.NoError(t, err)
+
+	// Without a TCF string TCF does not apply, so both partners fire
+	assert.Contains(t, esiContent, `src="https://sync.examplepartner.com/acme/px.gif?uid=$(PMUSER_UU)&amp;cc=$(GEO_COUNTRY)`)
+	assert.Contains(t, esiContent, `src="https://collect.examplecollector.com/v1/events" method="POST" entity="{&quot;site&quot;:&quot;news&quot;`)
+	assert.Equal(t, "examplepartner", config.Pixels[0].Partner)
+	assert.Nil(t, config.Pixels[0].CONDITIONS)
+}
@@ -0,0 +1,359 @@
+package esi
+
+import (
+	"sort"
+	"strings"
+)
+
+// esiFunction is a function of the ESI function library
+type esiFunction struct {
+	name     string
+	requires []string // Functions it calls, which come before it in the library
+	markup   string
+}
+
+// esiFunctions is the ESI function library for advanced macro processing, in the
+// order the functions are emitted
+var esiFunctions = []esiFunction{
+	// Fingerprint of every identifying request header
+	{
+		name: "generate_suu",
+		markup: `<esi:function name="generate_suu">
+    <!-- Comprehensive fingerprint generation similar to JavaScript Fingerprint2 -->
+    <esi:assign name="ip" value="$(CLIENT_IP)" />
+    <esi:assign name="ua" value="$(HTTP_USER_AGENT)" />
+    <esi:assign name="accept" value="$(HTTP_ACCEPT)" />
+    <esi:assign name="accept_lang" value="$(HTTP_ACCEPT_LANGUAGE)" />
+    <esi:assign name="accept_enc" value="$(HTTP_ACCEPT_ENCODING)" />
+    <esi:assign name="connection" value="$(HTTP_CONNECTION)" />
+    <esi:assign name="upgrade_insecure" value="$(HTTP_UPGRADE_INSECURE_REQUESTS)" />
+    
+    <!-- Build fingerprint components in order of importance -->
+    <esi:assign name="components" value="" />
+    
+    <esi:choose>
+        <esi:when test="$is_empty($(ip))">
+            <!-- Skip empty IP -->
+        </esi:when>
+        <esi:otherwise>
+            <esi:assign name="components" value="client_ip:$(ip)" />
+        </esi:otherwise>
+    </esi:choose>
+    
+    <esi:choose>
+        <esi:when test="$is_empty($(ua))">
+            <!-- Skip empty User Agent -->
+        </esi:when>
+        <esi:otherwise>
+            <esi:choose>
+                <esi:when test="$is_empty($(components))">
+                    <esi:assign name="components" value="user_agent:$(ua)" />
+                </esi:when>
+                <esi:otherwise>
+                    <esi:assign name="components" value="$(components)~~~user_agent:$(ua)" />
+                </esi:otherwise>
+            </esi:choose>
+        </esi:otherwise>
+    </esi:choose>
+    
+    <esi:choose>
+        <esi:when test="$is_empty($(accept))">
+            <!-- Skip empty Accept -->
+        </esi:when>
+        <esi:otherwise>
+            <esi:choose>
+                <esi:when test="$is_empty($(components))">
+                    <esi:assign name="components" value="accept:$(accept)" />
+                </esi:when>
+                <esi:otherwise>
+                    <esi:assign name="components" value="$(components)~~~accept:$(accept)" />
+                </esi:otherwise>
+            </esi:choose>
+        </esi:otherwise>
+    </esi:choose>
+    
+    <esi:choose>
+        <esi:when test="$is_empty($(accept_lang))">
+            <!-- Skip empty Accept Language -->
+        </esi:when>
+        <esi:otherwise>
+            <esi:choose>
+                <esi:when test="$is_empty($(components))">
+                    <esi:assign name="components" value="accept_language:$(accept_lang)" />
+                </esi:when>
+                <esi:otherwise>
+                    <esi:assign name="components" value="$(components)~~~accept_language:$(accept_lang)" />
+                </esi:otherwise>
+            </esi:choose>
+        </esi:otherwise>
+    </esi:choose>
+    
+    <esi:choose>
+        <esi:when test="$is_empty($(accept_enc))">
+            <!-- Skip empty Accept Encoding -->
+        </esi:when>
+        <esi:otherwise>
+            <esi:choose>
+                <esi:when test="$is_empty($(components))">
+                    <esi:assign name="components" value="accept_encoding:$(accept_enc)" />
+                </esi:when>
+                <esi:otherwise>
+                    <esi:assign name="components" value="$(components)~~~accept_encoding:$(accept_enc)" />
+                </esi:otherwise>
+            </esi:choose>
+        </esi:otherwise>
+    </esi:choose>
+    
+    <esi:choose>
+        <esi:when test="$is_empty($(connection))">
+            <!-- Skip empty Connection -->
+        </esi:when>
+        <esi:otherwise>
+            <esi:choose>
+                <esi:when test="$is_empty($(components))">
+                    <esi:assign name="components" value="connection:$(connection)" />
+                </esi:when>
+                <esi:otherwise>
+                    <esi:assign name="components" value="$(components)~~~connection:$(connection)" />
+                </esi:otherwise>
+            </esi:choose>
+        </esi:otherwise>
+    </esi:choose>
+    
+    <esi:choose>
+        <esi:when test="$is_empty($(upgrade_insecure))">
+            <!-- Skip empty Upgrade Insecure Requests -->
+        </esi:when>
+        <esi:otherwise>
+            <esi:choose>
+                <esi:when test="$is_empty($(components))">
+                    <esi:assign name="components" value="upgrade_insecure_requests:$(upgrade_insecure)" />
+                </esi:when>
+                <esi:otherwise>
+                    <esi:assign name="components" value="$(components)~~~upgrade_insecure_requests:$(upgrade_insecure)" />
+                </esi:otherwise>
+            </esi:choose>
+        </esi:otherwise>
+    </esi:choose>
+    
+    <!-- Generate MD5 hash of the combined components -->
+    <esi:return value="$digest_md5_hex($(components))" />
+</esi:function>`,
+	},
+	// suu fingerprint of the client IP, Accept and User-Agent, used by ~~suu~~
+	{
+		name: "generate_simple_suu",
+		markup: `<esi:function name="generate_simple_suu">
+    <!-- Simple fingerprint generation for backward compatibility -->
+    <esi:assign name="ip" value="$(CLIENT_IP)" />
+    <esi:assign name="accept" value="$(HTTP_ACCEPT)" />
+    <esi:assign name="ua" value="$(HTTP_USER_AGENT)" />
+    <esi:assign name="combined" value="$(ip)$(accept)$(ua)" />
+    <esi:return value="$digest_md5_hex($(combined))" />
+</esi:function>`,
+	},
+	// hpr/hpo cookie hashes, used by ~~c~name~hpr|hpo~salt~~
+	{
+		name: "cookie_hash",
+		markup: `<esi:function name="cookie_hash">
+    <esi:assign name="cookie_name" value="$(ARGS{0})" />
+    <esi:assign name="hash_type" value="$(ARGS{1})" />
+    <esi:assign name="salt" value="$(ARGS{2})" />
+    <esi:assign name="cookie_value" value="$(HTTP_COOKIE{$(cookie_name)})" />
+    
+    <esi:choose>
+        <esi:when test="$(hash_type)=='hpr'">
+            <esi:return value="$digest_md5_hex($(salt)$(cookie_value))" />
+        </esi:when>
+        <esi:when test="$(hash_type)=='hpo'">
+            <esi:return value="$digest_md5_hex($(cookie_value)$(salt))" />
+        </esi:when>
+        <esi:otherwise>
+            <esi:return value="$(cookie_value)" />
+        </esi:otherwise>
+    </esi:choose>
+</esi:function>`,
+	},
+	// Sampling decision of pixels with a sample rate
+	{
+		name:     "sample_bucket",
+		requires: []string{"generate_simple_suu"},
+		markup: `<esi:function name="sample_bucket">
+    <!-- Puts the stable ID, the cookie ARGS{0} or else suu, in a bucket from 0 to 99 salted with the pixel ID -->
+    <esi:assign name="stable_id" value="$(HTTP_COOKIE{$(ARGS{0})})" />
+    <esi:choose>
+        <esi:when test="$is_empty($(stable_id))">
+            <esi:assign name="stable_id" value="$generate_simple_suu()" />
+        </esi:when>
+    </esi:choose>
+    <esi:assign name="digest" value="$digest_md5_hex($(stable_id)$(ARGS{1}))" />
+    <esi:assign name="bucket" value="$int($substr($(digest), 0, 4), 16) % 100" />
+    <esi:choose>
+        <esi:when test="$(bucket) < $int($(ARGS{2}))">
+            <esi:return value="in" />
+        </esi:when>
+        <esi:otherwise>
+            <esi:return value="out" />
+        </esi:otherwise>
+    </esi:choose>
+</esi:function>`,
+	},
+	// URL decoding of a value
+	{
+		name: "url_decode",
+		markup: `<esi:function name="url_decode">
+    <esi:assign name="encoded" value="$(ARGS{0})" />
+    <esi:return value="$url_decode($(encoded))" />
+</esi:function>`,
+	},
+	// URL decoding of a query parameter
+	{
+		name: "query_param_decode",
+		markup: `<esi:function name="query_param_decode">
+    <esi:assign name="param_name" value="$(ARGS{0})" />
+    <esi:assign name="param_value" value="$(QUERY_STRING{$(param_name)})" />
+    <esi:return value="$url_decode($(param_value))" />
+</esi:function>`,
+	},
+	// Default for an empty value
+	{
+		name: "default_value",
+		markup: `<esi:function name="default_value">
+    <esi:assign name="value" value="$(ARGS{0})" />
+    <esi:assign name="default" value="$(ARGS{1})" />
+    <esi:choose>
+        <esi:when test="$is_empty($(value))">
+            <esi:return value="$(default)" />
+        </esi:when>
+        <esi:otherwise>
+            <esi:return value="$(value)" />
+        </esi:otherwise>
+    </esi:choose>
+</esi:function>`,
+	},
+}
+
+// esiFunctionsComment introduces the function library in generated output
+const esiFunctionsComment = "<!-- ESI Functions for Advanced Macro Processing -->"
+
+// GenerateESIFunctions generates the whole ESI function library for advanced macro
+// processing. Containers only need the functions their macros use, see MacroAnalysis.
+func GenerateESIFunctions() string {
+	names := make([]string, len(esiFunctions))
+	for i, function := range esiFunctions {
+		names[i] = function.name
+	}
+	return renderESIFunctions(names)
+}
+
+// renderESIFunctions renders the named functions of the library in library order
+func renderESIFunctions(names []string) string {
+	parts := []string{esiFunctionsComment}
+	for _, function := range esiFunctions {
+		if containsString(names, function.name) {
+			parts = append(parts, function.markup)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// PixelMacros lists the macros of a pixel and the ESI functions computing them at runtime
+type PixelMacros struct {
+	ID        string   `json:"id"`
+	Macros    []string `json:"macros,omitempty"`
+	Functions []string `json:"functions,omitempty"`
+}
+
+// MacroAnalysis reports the macros of the server-side pixels of a configuration and
+// the ESI functions the generated container needs
+type MacroAnalysis struct {
+	Pixels []PixelMacros `json:"pixels"`
+	// Functions are the library functions needed, with the functions they call
+	Functions []string `json:"functions"`
+}
+
+// AnalyzeMacros analyzes the macros of a configuration's server-side pixels, after
+// partner templates are applied. A pixel needs runtime functions for its hash and suu
+// macros and its sample rate, unless static mode resolves them at generation time;
+// transform modifiers only use built-in functions.
+func AnalyzeMacros(config ContainerConfig, esiConfig ESIConfig) (*MacroAnalysis, error) {
+	roots, _, err := prepareContainer(config, esiConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &MacroAnalysis{Pixels: []PixelMacros{}}
+	needed := make(map[string]bool)
+	var visit func(nodes []*beaconNode)
+	visit = func(nodes []*beaconNode) {
+		for _, node := range nodes {
+			pixel := analyzePixelMacros(node.pixel, esiConfig)
+			for _, function := range pixel.Functions {
+				needed[function] = true
+			}
+			analysis.Pixels = append(analysis.Pixels, pixel)
+			visit(node.dependents)
+		}
+	}
+	visit(roots)
+
+	// Functions called by the needed ones are needed too; walking the library backwards
+	// reaches them after their callers
+	for i := len(esiFunctions) - 1; i >= 0; i-- {
+		if needed[esiFunctions[i].name] {
+			for _, required := range esiFunctions[i].requires {
+				needed[required] = true
+			}
+		}
+	}
+	analysis.Functions = []string{}
+	for _, function := range esiFunctions {
+		if needed[function.name] {
+			analysis.Functions = append(analysis.Functions, function.name)
+		}
+	}
+	return analysis, nil
+}
+
+// analyzePixelMacros lists the macros of a pixel's URL, body and headers and the
+// functions of the variables assigned before its include
+func analyzePixelMacros(pixel Pixel, config ESIConfig) PixelMacros {
+	text := pixel.URL + " " + pixel.BODY
+	names := make([]string, 0, len(pixel.HEADERS))
+	for name := range pixel.HEADERS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		text += " " + pixel.HEADERS[name]
+	}
+
+	macros := PixelMacros{ID: pixel.ID}
+	for _, match := range macroPattern.FindAllStringSubmatch(text, -1) {
+		if !containsString(macros.Macros, match[1]) {
+			macros.Macros = append(macros.Macros, match[1])
+		}
+	}
+
+	variables := hashVariables(pixel.URL+" "+pixel.BODY, config)
+	if config.ConditionMode != ConditionModeStatic {
+		if variable, ok := sampleVariable(pixel); ok {
+			variables = append(variables, variable)
+		}
+	}
+	for _, variable := range variables {
+		if variable.function != transformFunction && !containsString(macros.Functions, variable.function) {
+			macros.Functions = append(macros.Functions, variable.function)
+		}
+	}
+	return macros
+}
+
+// Preamble returns the ESI functions the container needs, or nothing when no pixel
+// uses them
+func (a *MacroAnalysis) Preamble() string {
+	if len(a.Functions) == 0 {
+		return ""
+	}
+	return renderESIFunctions(a.Functions)
+}
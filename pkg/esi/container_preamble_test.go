@@ -0,0 +1,60 @@
+package esi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeMacros(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "plain", URL: "https://example.com/p.gif?cc=~~cc~~&r=~~r~~"},
+		{ID: "hashed", URL: "https://example.com/h.gif?u=~~c~uid~hpr~s1~~&p=~~dl:qs~page|urlencode~~", AFTER: "plain"},
+		{ID: "sampled", URL: "https://example.com/s.gif", HEADERS: map[string]string{"X-Id": "~~evid~~"},
+			CONDITIONS: &PixelConditions{SampleRate: 10}},
+		{ID: "browser", URL: "https://example.com/f.html?suu=~~suu~~", TYPE: "frm"},
+	}}
+
+	analysis, err := AnalyzeMacros(config, ESIConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, []PixelMacros{
+		{ID: "plain", Macros: []string{"cc", "r"}},
+		{ID: "hashed", Macros: []string{"c~uid~hpr~s1", "dl:qs~page|urlencode"}, Functions: []string{"cookie_hash"}},
+		{ID: "sampled", Macros: []string{"evid"}, Functions: []string{"sample_bucket"}},
+	}, analysis.Pixels)
+	// sample_bucket calls generate_simple_suu; browser pixels are not generated
+	assert.Equal(t, []string{"generate_simple_suu", "cookie_hash", "sample_bucket"}, analysis.Functions)
+
+	preamble := analysis.Preamble()
+	assert.True(t, strings.HasPrefix(preamble, "<!-- ESI Functions for Advanced Macro Processing -->"))
+	for _, function := range []string{"generate_simple_suu", "cookie_hash", "sample_bucket"} {
+		assert.Contains(t, preamble, `<esi:function name="`+function+`">`)
+	}
+	assert.NotContains(t, preamble, `<esi:function name="generate_suu">`)
+	assert.NotContains(t, preamble, `<esi:function name="default_value">`)
+
+	// Static mode resolves hashes and samples at generation time
+	analysis, err = AnalyzeMacros(config, ESIConfig{ConditionMode: ConditionModeStatic})
+	require.NoError(t, err)
+	assert.Empty(t, analysis.Functions)
+	assert.Empty(t, analysis.Preamble())
+}
+
+func TestAnalyzeMacros_NoMacros(t *testing.T) {
+	analysis, err := AnalyzeMacros(ContainerConfig{Pixels: []Pixel{{ID: "p1", URL: "https://example.com/p.gif"}}}, ESIConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, []PixelMacros{{ID: "p1"}}, analysis.Pixels)
+	assert.Empty(t, analysis.Preamble())
+
+	_, err = AnalyzeMacros(ContainerConfig{Pixels: []Pixel{{ID: "p1", URL: "https://example.com/p.gif", Partner: "unknown"}}}, ESIConfig{})
+	assert.Error(t, err)
+}
+
+func TestGenerateESIFunctions(t *testing.T) {
+	functions := GenerateESIFunctions()
+	for _, function := range esiFunctions {
+		assert.Contains(t, functions, `<esi:function name="`+function.name+`">`)
+	}
+}
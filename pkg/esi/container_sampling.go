@@ -0,0 +1,71 @@
+package esi
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/edge-computing/emulator-suite/pkg/esigen"
+)
+
+// sampleFunction is the runtime function assigning whether a request is in a pixel's sample
+const sampleFunction = "sample_bucket"
+
+// SampleBucket returns the sampling bucket, 0 to 99, of a stable ID. The pixel ID salts
+// the digest so pixels sample independently.
+func SampleBucket(stableID, pixelID string) int {
+	digest, _ := HashHex(HashMD5, stableID+pixelID)
+	bucket, _ := strconv.ParseUint(digest[:4], 16, 32)
+	return int(bucket % 100)
+}
+
+// sampleStableID returns the stable ID of a request: the sample cookie, or the suu
+// fingerprint when the pixel names no cookie or the cookie is unset
+func sampleStableID(ctx *ConditionContext, cookie string) string {
+	if value := ctx.Cookies[cookie]; cookie != "" && value != "" {
+		return value
+	}
+	suu, _ := SUU(ctx, HashMD5)
+	return suu
+}
+
+// sampleVariableName returns the ESI variable holding whether a request is in a pixel's sample
+func sampleVariableName(pixelID string) string {
+	return "sample_" + letterDigest(pixelID)
+}
+
+// sampleVariable returns the runtime variable of a sampled pixel. It is assigned before
+// the pixel's conditions, which test it.
+func sampleVariable(pixel Pixel) (hashVariable, bool) {
+	conditions := pixel.CONDITIONS
+	if conditions == nil || conditions.SampleRate <= 0 || conditions.SampleRate >= 100 {
+		return hashVariable{}, false
+	}
+	return hashVariable{
+		name:     sampleVariableName(pixel.ID),
+		function: sampleFunction,
+		args:     []string{conditions.SampleKey, pixel.ID, strconv.Itoa(conditions.SampleRate)},
+	}, true
+}
+
+// sampleClause allows a pixel for the requests whose stable ID falls in its first rate buckets
+func sampleClause(pixel Pixel) conditionClause {
+	rate := pixel.CONDITIONS.SampleRate
+	cookie := pixel.CONDITIONS.SampleKey
+
+	return conditionClause{
+		description: fmt.Sprintf("in the %d%% sample", rate),
+		tests:       []string{fmt.Sprintf("$(%s)=='in'", sampleVariableName(pixel.ID))},
+		static: func(ctx *ConditionContext, _ GeoProvider) bool {
+			return SampleBucket(sampleStableID(ctx, cookie), pixel.ID) < rate
+		},
+	}
+}
+
+// sampleAssignment returns the esi:assign computing whether a request is in a pixel's sample
+func sampleAssignment(pixel Pixel) string {
+	variable, ok := sampleVariable(pixel)
+	if !ok {
+		return ""
+	}
+	return esigen.Assign(variable.name, variable.expression()).String()
+}
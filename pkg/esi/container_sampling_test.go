@@ -0,0 +1,82 @@
+package esi
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleBucket(t *testing.T) {
+	assert.Equal(t, SampleBucket("user-1", "sync"), SampleBucket("user-1", "sync"))
+
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if SampleBucket(fmt.Sprintf("user-%d", i), "sync") < 25 {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 2500, sampled, 200)
+}
+
+func TestSampleConditions_Runtime(t *testing.T) {
+	pixel := Pixel{ID: "p1", URL: "https://example.com/p.gif", CONDITIONS: &PixelConditions{Countries: []string{"US"}, SampleRate: 10, SampleKey: "uid"}}
+
+	include, err := generateESIInclude(pixel, ESIConfig{})
+	require.NoError(t, err)
+
+	variable := sampleVariableName("p1")
+	assert.Equal(t, `<esi:assign name="`+variable+`" value="$sample_bucket('uid', 'p1', '10')" />`+
+		`<esi:choose><esi:when test="$(GEO_COUNTRY_CODE)=='US'"><esi:assign name="c_a" value="1" /></esi:when>`+
+		`<esi:otherwise><esi:assign name="c_a" value="0" /></esi:otherwise></esi:choose>`+
+		`<esi:choose><esi:when test="$(`+variable+`)=='in'"><esi:assign name="c_b" value="1" /></esi:when>`+
+		`<esi:otherwise><esi:assign name="c_b" value="0" /></esi:otherwise></esi:choose>`+
+		`<esi:choose><esi:when test="$(c_a)$(c_b)=='11'"><esi:include src="https://example.com/p.gif" maxwait="0" /></esi:when></esi:choose>`, include)
+
+	// A full sample needs no condition
+	pixel.CONDITIONS.SampleRate = 100
+	include, err = generateESIInclude(pixel, ESIConfig{})
+	require.NoError(t, err)
+	assert.NotContains(t, include, "sample")
+}
+
+func TestSampleConditions_Static(t *testing.T) {
+	pixel := Pixel{ID: "p1", CONDITIONS: &PixelConditions{SampleRate: 30, SampleKey: "uid"}}
+
+	for i := 0; i < 20; i++ {
+		uid := fmt.Sprintf("user-%d", i)
+		fires, reason := evaluateConditions(pixel, &ConditionContext{Cookies: map[string]string{"uid": uid}}, nil)
+		assert.Equal(t, SampleBucket(uid, "p1") < 30, fires, uid)
+		if !fires {
+			assert.Equal(t, "in the 30% sample", reason)
+		}
+	}
+
+	// Without the cookie, the suu fingerprint is sampled
+	ctx := &ConditionContext{ClientIP: "203.0.113.1", Headers: map[string]string{"User-Agent": "Mozilla/5.0"}}
+	suu, _ := SUU(ctx, HashMD5)
+	fires, _ := evaluateConditions(pixel, ctx, nil)
+	assert.Equal(t, SampleBucket(suu, "p1") < 30, fires)
+}
+
+func TestSampleConditions_Simulation(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "p1", URL: "https://example.com/p.gif", CONDITIONS: &PixelConditions{SampleRate: 50, SampleKey: "uid"}},
+	}}
+
+	for i := 0; i < 20; i++ {
+		uid := fmt.Sprintf("user-%d", i)
+		options := SimulationOptions{Context: ProcessContext{Headers: map[string]string{}, Cookies: map[string]string{"uid": uid}}}
+
+		report, err := SimulateContainer(config, ESIConfig{}, options)
+		require.NoError(t, err)
+		require.Len(t, report.Beacons, 1)
+
+		expected := BeaconSuppressed
+		if SampleBucket(uid, "p1") < 50 {
+			expected = BeaconFired
+		}
+		assert.Equal(t, expected, report.Beacons[0].Outcome, uid)
+	}
+}
@@ -0,0 +1,139 @@
+package esi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esigen"
+)
+
+// beaconNode is a dir pixel together with the pixels that fire after it
+type beaconNode struct {
+	pixel      Pixel
+	dependents []*beaconNode
+}
+
+// buildBeaconTree nests pixels under the pixel named by their AFTER field and orders
+// every level by descending PRIORITY, keeping configuration order for equal priorities
+func buildBeaconTree(pixels []Pixel) ([]*beaconNode, error) {
+	nodes := make(map[string]*beaconNode, len(pixels))
+	duplicates := make(map[string]bool)
+	ordered := make([]*beaconNode, 0, len(pixels))
+	for _, pixel := range pixels {
+		node := &beaconNode{pixel: pixel}
+		if _, exists := nodes[pixel.ID]; exists {
+			duplicates[pixel.ID] = true
+		} else {
+			nodes[pixel.ID] = node
+		}
+		ordered = append(ordered, node)
+	}
+
+	var roots []*beaconNode
+	for _, node := range ordered {
+		after := node.pixel.AFTER
+		if after == "" {
+			roots = append(roots, node)
+			continue
+		}
+		parent, exists := nodes[after]
+		if !exists {
+			return nil, fmt.Errorf("pixel %s fires after unknown dir pixel %s", node.pixel.ID, after)
+		}
+		if duplicates[after] {
+			return nil, fmt.Errorf("pixel %s fires after %s, which is not a unique pixel ID", node.pixel.ID, after)
+		}
+		parent.dependents = append(parent.dependents, node)
+	}
+
+	// Every pixel must be reachable from a root, otherwise its dependencies form a cycle
+	reached := make(map[*beaconNode]bool, len(ordered))
+	var visit func(level []*beaconNode)
+	visit = func(level []*beaconNode) {
+		sortBeacons(level)
+		for _, node := range level {
+			reached[node] = true
+			visit(node.dependents)
+		}
+	}
+	visit(roots)
+	if len(reached) != len(ordered) {
+		var cyclic []string
+		for _, node := range ordered {
+			if !reached[node] {
+				cyclic = append(cyclic, node.pixel.ID)
+			}
+		}
+		return nil, fmt.Errorf("pixel dependencies form a cycle among %s", strings.Join(cyclic, ", "))
+	}
+
+	return roots, nil
+}
+
+// sortBeacons orders beacons by descending priority, keeping their order otherwise
+func sortBeacons(nodes []*beaconNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].pixel.PRIORITY > nodes[j].pixel.PRIORITY
+	})
+}
+
+// renderBeacon generates the markup of a beacon and, nested inside it, its dependents.
+// In static mode a suppressed beacon suppresses its dependents as well.
+func renderBeacon(node *beaconNode, config ESIConfig) (string, error) {
+	pixel := node.pixel
+
+	if config.ConditionMode == ConditionModeStatic {
+		if fires, reason := evaluateConditions(pixel, config.StaticContext, config.Geo); !fires {
+			comments := []string{fmt.Sprintf("<!-- pixel %s suppressed: %s -->", pixel.ID, reason)}
+			comments = append(comments, suppressDependents(node)...)
+			return strings.Join(comments, "\n"), nil
+		}
+	}
+
+	var dependents strings.Builder
+	for _, dependent := range node.dependents {
+		markup, err := renderBeacon(dependent, config)
+		if err != nil {
+			return "", err
+		}
+		dependents.WriteString(markup)
+	}
+
+	markup, err := generateBeacon(pixel, config, dependents.String())
+	if err != nil {
+		return "", fmt.Errorf("error generating ESI for pixel %s: %w", pixel.ID, err)
+	}
+	return markup, nil
+}
+
+// suppressDependents returns suppression comments for all beacons depending on node
+func suppressDependents(node *beaconNode) []string {
+	var comments []string
+	for _, dependent := range node.dependents {
+		comments = append(comments, fmt.Sprintf("<!-- pixel %s suppressed: depends on %s -->", dependent.pixel.ID, node.pixel.ID))
+		comments = append(comments, suppressDependents(dependent)...)
+	}
+	return comments
+}
+
+// batchBeacons groups independent beacons into batches of at most max. Each batch is
+// its own esi:try block, so edges that fetch a block's includes together never have
+// more than max independent beacons in flight and a failure stays within its batch.
+func batchBeacons(beacons []string, max int) []string {
+	if max <= 0 || len(beacons) <= max {
+		return beacons
+	}
+
+	total := (len(beacons) + max - 1) / max
+	batches := make([]string, 0, total)
+	for start := 0; start < len(beacons); start += max {
+		end := start + max
+		if end > len(beacons) {
+			end = len(beacons)
+		}
+		batch := esigen.Try(esigen.Raw("\n" + strings.Join(beacons[start:end], "\n") + "\n"))
+		batches = append(batches, fmt.Sprintf("<!-- batch %d/%d -->\n%s", len(batches)+1, total, batch))
+	}
+	return batches
+}
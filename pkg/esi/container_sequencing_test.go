@@ -0,0 +1,179 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessContainerConfig_PriorityOrder(t *testing.T) {
+	config := ContainerConfig{
+		Pixels: []Pixel{
+			{ID: "low", URL: "https://example.com/low.gif", TYPE: "dir", PRIORITY: 1},
+			{ID: "default", URL: "https://example.com/default.gif", TYPE: "dir"},
+			{ID: "high", URL: "https://example.com/high.gif", TYPE: "dir", PRIORITY: 10},
+			{ID: "low2", URL: "https://example.com/low2.gif", TYPE: "dir", PRIORITY: 1},
+		},
+	}
+
+	esiContent, _, err := ProcessContainerConfig(config, ESIConfig{})
+	require.NoError(t, err)
+
+	high := strings.Index(esiContent, "high.gif")
+	low := strings.Index(esiContent, "low.gif")
+	low2 := strings.Index(esiContent, "low2.gif")
+	def := strings.Index(esiContent, "default.gif")
+	assert.True(t, high < low && low < low2 && low2 < def, esiContent)
+}
+
+func TestProcessContainerConfig_Dependencies(t *testing.T) {
+	config := ContainerConfig{
+		Pixels: []Pixel{
+			{ID: "sync", URL: "https://example.com/sync.gif", TYPE: "dir", AFTER: "id"},
+			{ID: "id", URL: "https://example.com/id.gif", TYPE: "dir"},
+			{ID: "other", URL: "https://example.com/other.gif", TYPE: "dir"},
+		},
+	}
+
+	esiContent, _, err := ProcessContainerConfig(config, ESIConfig{})
+	require.NoError(t, err)
+
+	// The dependency waits for its response and the dependent fires inside its attempt,
+	// in an esi:try of its own
+	assert.Contains(t, esiContent, `<esi:try><esi:attempt><esi:include src="https://example.com/id.gif" />`+
+		`<esi:try><esi:attempt><esi:include src="https://example.com/sync.gif" maxwait="0" /></esi:attempt><esi:except><!-- dependents of pixel id failed --></esi:except></esi:try>`+
+		`</esi:attempt><esi:except><!-- pixel id failed: dependents skipped --></esi:except></esi:try>`)
+	assert.Contains(t, esiContent, `<esi:include src="https://example.com/other.gif" maxwait="0" />`)
+	assert.Equal(t, 1, strings.Count(esiContent, "sync.gif"))
+}
+
+func TestProcessContainerConfig_DependencyConditions(t *testing.T) {
+	config := ContainerConfig{
+		Pixels: []Pixel{
+			{ID: "id", URL: "https://example.com/id.gif", TYPE: "dir", CONDITIONS: &PixelConditions{Countries: []string{"DE"}}},
+			{ID: "sync", URL: "https://example.com/sync.gif", TYPE: "dir", AFTER: "id"},
+			{ID: "match", URL: "https://example.com/match.gif", TYPE: "dir", AFTER: "sync"},
+		},
+	}
+
+	// At runtime the dependency's conditions wrap its dependents too
+	esiContent, _, err := ProcessContainerConfig(config, ESIConfig{})
+	require.NoError(t, err)
+	assert.Contains(t, esiContent, `<esi:choose><esi:when test="$(c_a)=='1'"><esi:try><esi:attempt><esi:include src="https://example.com/id.gif" />`)
+
+	// Statically, suppressing the dependency suppresses the whole chain
+	esiContent, _, err = ProcessContainerConfig(config, ESIConfig{ConditionMode: ConditionModeStatic})
+	require.NoError(t, err)
+	assert.Contains(t, esiContent, "<!-- pixel id suppressed: country in DE -->")
+	assert.Contains(t, esiContent, "<!-- pixel sync suppressed: depends on id -->")
+	assert.Contains(t, esiContent, "<!-- pixel match suppressed: depends on sync -->")
+	assert.NotContains(t, esiContent, "<esi:include")
+}
+
+func TestProcessContainerConfig_FailingDependent(t *testing.T) {
+	var mutex sync.Mutex
+	var hits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		hits = append(hits, r.URL.Path)
+		mutex.Unlock()
+		if r.URL.Path == "/broken.gif" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	config := ContainerConfig{
+		Pixels: []Pixel{
+			{ID: "id", URL: server.URL + "/id.gif", TYPE: "dir"},
+			{ID: "broken", URL: server.URL + "/broken.gif", TYPE: "dir", AFTER: "id"},
+		},
+	}
+	esiContent, _, err := ProcessContainerConfig(config, ESIConfig{})
+	require.NoError(t, err)
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 3})
+	result, err := processor.Process(esiContent, ProcessContext{Headers: map[string]string{}, Cookies: map[string]string{}})
+	require.NoError(t, err)
+
+	// The dependent's failure does not report the beacon it follows as failed
+	assert.Equal(t, []string{"/id.gif", "/broken.gif"}, hits)
+	assert.Contains(t, result, "<!-- dependents of pixel id failed -->")
+	assert.NotContains(t, result, "pixel id failed: dependents skipped")
+}
+
+func TestProcessContainerConfig_DependencyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		pixels []Pixel
+		errMsg string
+	}{
+		{
+			name:   "unknown dependency",
+			pixels: []Pixel{{ID: "a", URL: "https://example.com/a", TYPE: "dir", AFTER: "missing"}},
+			errMsg: "unknown dir pixel missing",
+		},
+		{
+			name: "dependency on browser pixel",
+			pixels: []Pixel{
+				{ID: "frame", URL: "https://example.com/f", TYPE: "frm"},
+				{ID: "a", URL: "https://example.com/a", TYPE: "dir", AFTER: "frame"},
+			},
+			errMsg: "unknown dir pixel frame",
+		},
+		{
+			name: "ambiguous dependency",
+			pixels: []Pixel{
+				{ID: "a", URL: "https://example.com/a1", TYPE: "dir"},
+				{ID: "a", URL: "https://example.com/a2", TYPE: "dir"},
+				{ID: "b", URL: "https://example.com/b", TYPE: "dir", AFTER: "a"},
+			},
+			errMsg: "not a unique pixel ID",
+		},
+		{
+			name: "cycle",
+			pixels: []Pixel{
+				{ID: "a", URL: "https://example.com/a", TYPE: "dir", AFTER: "b"},
+				{ID: "b", URL: "https://example.com/b", TYPE: "dir", AFTER: "a"},
+				{ID: "c", URL: "https://example.com/c", TYPE: "dir"},
+			},
+			errMsg: "cycle among a, b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ProcessContainerConfig(ContainerConfig{Pixels: tt.pixels}, ESIConfig{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestProcessContainerConfig_MaxConcurrentBeacons(t *testing.T) {
+	var pixels []Pixel
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		pixels = append(pixels, Pixel{ID: id, URL: "https://example.com/" + id + ".gif", TYPE: "dir"})
+	}
+
+	esiContent, _, err := ProcessContainerConfig(ContainerConfig{Pixels: pixels}, ESIConfig{MaxConcurrentBeacons: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(esiContent, "<esi:try>"))
+	assert.Contains(t, esiContent, "<!-- batch 1/3 -->")
+	assert.Contains(t, esiContent, "<!-- batch 3/3 -->")
+
+	batches := strings.Split(esiContent, "<!-- batch ")
+	require.Len(t, batches, 4)
+	assert.Equal(t, 2, strings.Count(batches[1], "<esi:include"))
+	assert.Equal(t, 1, strings.Count(batches[3], "<esi:include"))
+
+	// No batching when the limit is not exceeded
+	esiContent, _, err = ProcessContainerConfig(ContainerConfig{Pixels: pixels}, ESIConfig{MaxConcurrentBeacons: 5})
+	require.NoError(t, err)
+	assert.NotContains(t, esiContent, "<esi:try>")
+}
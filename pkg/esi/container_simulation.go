@@ -0,0 +1,445 @@
+package esi
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Outcomes of a simulated beacon
+const (
+	BeaconFired      = "fired"
+	BeaconFailed     = "failed"
+	BeaconSuppressed = "suppressed"
+	BeaconSkipped    = "skipped"
+)
+
+// DefaultMockLatency is the latency of mocked beacon endpoints without an explicit latency
+const DefaultMockLatency = 50 * time.Millisecond
+
+// MockEndpoint is the simulated response of a beacon host
+type MockEndpoint struct {
+	Status    int `json:"status"`
+	LatencyMS int `json:"latencyMs"`
+}
+
+// SimulationOptions configures a dry run of a container
+type SimulationOptions struct {
+	// Context is the request the container is simulated for
+	Context ProcessContext
+	// Variables preset ESI variables the emulator does not provide, e.g. PMUSER_UU or GEO_COUNTRY
+	Variables map[string]string
+	// Endpoints mocks beacon hosts by host name; other hosts answer with DefaultEndpoint
+	Endpoints map[string]MockEndpoint
+	// DefaultEndpoint defaults to status 200 after DefaultMockLatency
+	DefaultEndpoint MockEndpoint
+}
+
+// NewSimulationOptions returns options simulating the request described by a condition
+// context, with its country resolved by geo when unset
+func NewSimulationOptions(ctx *ConditionContext, geo GeoProvider) SimulationOptions {
+	if ctx == nil {
+		ctx = &ConditionContext{}
+	}
+	if geo == nil {
+		geo = EmulatorGeoProvider{}
+	}
+
+	context := ProcessContext{Headers: make(map[string]string), Cookies: make(map[string]string)}
+	for name, value := range ctx.Headers {
+		context.Headers[name] = value
+	}
+	for name, value := range ctx.Cookies {
+		context.Cookies[name] = value
+	}
+	if ctx.ClientIP != "" {
+		context.Headers["X-Forwarded-For"] = ctx.ClientIP
+	}
+	if len(ctx.Query) > 0 {
+		query := url.Values{}
+		for name, value := range ctx.Query {
+			query.Set(name, value)
+		}
+		context.Headers["Query-String"] = query.Encode()
+	}
+
+	country := ctx.country(geo)
+	return SimulationOptions{
+		Context:   context,
+		Variables: map[string]string{"GEO_COUNTRY": country, "GEO_COUNTRY_CODE": country},
+	}
+}
+
+// BeaconSimulation is the simulated outcome of one beacon
+type BeaconSimulation struct {
+	ID      string            `json:"id"`
+	Outcome string            `json:"outcome"`
+	Reason  string            `json:"reason,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Status  int               `json:"status,omitempty"`
+	// LatencyMS is the mocked latency of the beacon request
+	LatencyMS int    `json:"latencyMs,omitempty"`
+	Batch     int    `json:"batch"`
+	After     string `json:"after,omitempty"`
+}
+
+// SimulationReport describes which beacons of a container fire for a request
+type SimulationReport struct {
+	Beacons    []BeaconSimulation `json:"beacons"`
+	Fired      int                `json:"fired"`
+	Failed     int                `json:"failed"`
+	Suppressed int                `json:"suppressed"`
+	Skipped    int                `json:"skipped"`
+	// TotalLatencyMS is the time all beacon requests would take one after another
+	TotalLatencyMS int `json:"totalLatencyMs"`
+	// ParallelLatencyMS estimates the wall time at the edge: batches run in sequence,
+	// beacons within a batch in parallel and dependents after the beacon they follow
+	ParallelLatencyMS int `json:"parallelLatencyMs"`
+}
+
+// SimulateContainer dry-runs a container configuration. It generates the ESI of every
+// dir beacon and walks it against options.Context like the ESI processor would,
+// assigning hash variables, evaluating the esi:choose conditions with the processor's
+// expression rules and expanding the include request. Beacon requests are answered by
+// mocked endpoints instead of being sent.
+func SimulateContainer(config ContainerConfig, esiConfig ESIConfig, options SimulationOptions) (*SimulationReport, error) {
+	roots, _, err := prepareContainer(config, esiConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSimulator(roots, esiConfig, options)
+	batchSize := esiConfig.MaxConcurrentBeacons
+	if batchSize <= 0 {
+		batchSize = len(roots)
+	}
+
+	var batchLatency int
+	for i, root := range roots {
+		if i > 0 && i%batchSize == 0 {
+			s.report.ParallelLatencyMS += batchLatency
+			batchLatency = 0
+		}
+		latency, err := s.simulate(root, i/batchSize+1, "")
+		if err != nil {
+			return nil, err
+		}
+		if latency > batchLatency {
+			batchLatency = latency
+		}
+	}
+	s.report.ParallelLatencyMS += batchLatency
+
+	return s.report, nil
+}
+
+// simulator holds the state of one simulation run
+type simulator struct {
+	processor *Processor
+	config    ESIConfig
+	options   SimulationOptions
+	variables map[string]string
+	report    *SimulationReport
+}
+
+// newSimulator presets the variables the emulator does not provide: the simulation's
+// own variables, request headers (including the decoded consent headers) and, once
+// assigned, the hash variables of the beacons
+func newSimulator(roots []*beaconNode, config ESIConfig, options SimulationOptions) *simulator {
+	s := &simulator{
+		processor: NewProcessor(Config{Mode: "akamai", MaxDepth: 1, MaxIncludes: 1}),
+		config:    config,
+		options:   options,
+		variables: make(map[string]string),
+		report:    &SimulationReport{Beacons: []BeaconSimulation{}},
+	}
+
+	var vendorIDs []int
+	var collect func(nodes []*beaconNode)
+	collect = func(nodes []*beaconNode) {
+		for _, node := range nodes {
+			if node.pixel.CONDITIONS != nil && node.pixel.CONDITIONS.TCFVendor > 0 {
+				vendorIDs = append(vendorIDs, node.pixel.CONDITIONS.TCFVendor)
+			}
+			collect(node.dependents)
+		}
+	}
+	collect(roots)
+
+	headers := DecodeConsent(options.Context.Cookies).Headers(vendorIDs...)
+	for name, value := range options.Context.Headers {
+		headers[name] = value
+	}
+	for name, value := range headers {
+		s.variables[strings.TrimSuffix(strings.TrimPrefix(headerVariable(name), "$("), ")")] = value
+	}
+	for name, value := range options.Variables {
+		s.variables[name] = value
+	}
+	return s
+}
+
+// simulate simulates a beacon and its dependents and returns the latency of its chain
+func (s *simulator) simulate(node *beaconNode, batch int, after string) (int, error) {
+	pixel := node.pixel
+	result := BeaconSimulation{ID: pixel.ID, Batch: batch, After: after}
+
+	if s.config.ConditionMode == ConditionModeStatic {
+		if fires, reason := evaluateConditions(pixel, s.config.StaticContext, s.config.Geo); !fires {
+			result.Outcome, result.Reason = BeaconSuppressed, reason
+			s.record(result)
+			s.skipDependents(node, batch, BeaconSuppressed)
+			return 0, nil
+		}
+	}
+
+	markup, err := generateBeacon(pixel, s.config, "")
+	if err != nil {
+		return 0, fmt.Errorf("error generating ESI for pixel %s: %w", pixel.ID, err)
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(markup))
+	if err != nil {
+		return 0, fmt.Errorf("error parsing ESI for pixel %s: %w", pixel.ID, err)
+	}
+
+	include := s.walk(doc.Find("body").Nodes[0])
+	if include == nil {
+		result.Outcome, result.Reason = BeaconSuppressed, s.failedClause(buildConditionClauses(pixel))
+		s.record(result)
+		s.skipDependents(node, batch, BeaconSuppressed)
+		return 0, nil
+	}
+
+	// The processor expands entity and setheader itself, but not the simulation's variables
+	for _, name := range []string{"entity", "setheader"} {
+		if value, exists := include.Attr(name); exists {
+			include.SetAttr(name, s.substitute(value))
+		}
+	}
+	request := s.processor.includeRequest(include, s.expand(include.AttrOr("src", "")), s.options.Context)
+	result.Method, result.URL, result.Body = request.Method, request.URL, request.Body
+	if len(request.Headers) > 0 {
+		result.Headers = request.Headers
+	}
+
+	endpoint := s.endpoint(request.URL)
+	result.Status, result.LatencyMS = endpoint.Status, endpoint.LatencyMS
+	s.report.TotalLatencyMS += endpoint.LatencyMS
+	if endpoint.Status >= 400 {
+		result.Outcome, result.Reason = BeaconFailed, fmt.Sprintf("HTTP %d", endpoint.Status)
+		s.record(result)
+		s.skipDependents(node, batch, BeaconSkipped)
+		return endpoint.LatencyMS, nil
+	}
+	result.Outcome = BeaconFired
+	s.record(result)
+
+	var slowest int
+	for _, dependent := range node.dependents {
+		latency, err := s.simulate(dependent, batch, pixel.ID)
+		if err != nil {
+			return 0, err
+		}
+		if latency > slowest {
+			slowest = latency
+		}
+	}
+	return endpoint.LatencyMS + slowest, nil
+}
+
+// walk processes the ESI elements under node in document order until it reaches the
+// beacon's include, and returns nil when the conditions select no include
+func (s *simulator) walk(node *html.Node) *goquery.Selection {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		selection := goquery.NewDocumentFromNode(child).Selection
+
+		switch child.Data {
+		case "esi:assign":
+			s.assign(selection.AttrOr("name", ""), selection.AttrOr("value", ""))
+			// The HTML parser nests elements following a "self-closing" esi tag inside it
+			if include := s.walk(child); include != nil {
+				return include
+			}
+		case "esi:include":
+			return selection
+		case "esi:choose":
+			if branch := s.chooseBranch(child); branch != nil {
+				if include := s.walk(branch); include != nil {
+					return include
+				}
+			}
+		default:
+			if include := s.walk(child); include != nil {
+				return include
+			}
+		}
+	}
+	return nil
+}
+
+// failedClause returns the description of the first clause whose flag the walk did
+// not set, which is the condition that suppressed the beacon
+func (s *simulator) failedClause(clauses []conditionClause) string {
+	for i, clause := range clauses {
+		if s.variables[conditionFlag(i)] != "1" {
+			return clause.description
+		}
+	}
+	return ""
+}
+
+// chooseBranch returns the first esi:when whose test holds, or the esi:otherwise
+func (s *simulator) chooseBranch(choose *html.Node) *html.Node {
+	var otherwise *html.Node
+	for branch := choose.FirstChild; branch != nil; branch = branch.NextSibling {
+		if branch.Type != html.ElementNode {
+			continue
+		}
+		switch branch.Data {
+		case "esi:when":
+			for _, attr := range branch.Attr {
+				if attr.Key == "test" && s.processor.evaluateExpression(s.substitute(attr.Val), s.options.Context) == "true" {
+					return branch
+				}
+			}
+		case "esi:otherwise":
+			otherwise = branch
+		}
+	}
+	return otherwise
+}
+
+// hashFunctionPattern matches the runtime hash functions of generated assignments
+var hashFunctionPattern = regexp.MustCompile(`^\$(cookie_hash|generate_simple_suu|sample_bucket)\((.*)\)$`)
+
+// transformFunctionPattern matches the outermost runtime function of a transformed macro
+var transformFunctionPattern = regexp.MustCompile(`^\$(url_encode|digest_md5_hex)\((.*)\)$`)
+
+// assign evaluates an esi:assign. The hash functions of generated containers are
+// computed with md5, as in GenerateESIFunctions; other values are expanded.
+func (s *simulator) assign(name, value string) {
+	match := hashFunctionPattern.FindStringSubmatch(value)
+	if match == nil {
+		s.variables[name] = s.transform(value)
+		return
+	}
+
+	ctx := s.options.Context
+	clientIP := s.processor.akamaiExt.getESIVariable("CLIENT_IP", "", ctx)
+	request := &ConditionContext{ClientIP: clientIP, Headers: ctx.Headers, Cookies: ctx.Cookies}
+	if match[1] == "generate_simple_suu" {
+		s.variables[name], _ = SUU(request, HashMD5)
+		return
+	}
+
+	args := strings.Split(match[2], ",")
+	for i := range args {
+		args[i] = strings.Trim(strings.TrimSpace(args[i]), "'")
+	}
+	if len(args) != 3 {
+		return
+	}
+	if match[1] == sampleFunction {
+		rate, _ := strconv.Atoi(args[2])
+		s.variables[name] = "out"
+		if SampleBucket(sampleStableID(request, args[0]), args[1]) < rate {
+			s.variables[name] = "in"
+		}
+		return
+	}
+	s.variables[name], _ = HashCookie(ctx.Cookies[args[0]], args[2], args[1], HashMD5)
+}
+
+// transform evaluates the url_encode and digest_md5_hex functions applying macro
+// modifiers, expanding the innermost value
+func (s *simulator) transform(value string) string {
+	match := transformFunctionPattern.FindStringSubmatch(value)
+	if match == nil {
+		return s.expand(value)
+	}
+	inner := s.transform(match[2])
+	if match[1] == "url_encode" {
+		return url.QueryEscape(inner)
+	}
+	hashed, _ := HashHex(HashMD5, inner)
+	return hashed
+}
+
+// simulationVariablePattern matches variable references without keys, including names
+// with digits, and their optional default
+var simulationVariablePattern = regexp.MustCompile(`\$\(([A-Za-z0-9_]+)(?:\|'([^']*)')?\)`)
+
+// substitute replaces references to the simulation's variables, leaving others to the processor
+func (s *simulator) substitute(value string) string {
+	return simulationVariablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		groups := simulationVariablePattern.FindStringSubmatch(match)
+		if variable, exists := s.variables[groups[1]]; exists {
+			if variable == "" {
+				return groups[2]
+			}
+			return variable
+		}
+		return match
+	})
+}
+
+// expand expands all variables of value
+func (s *simulator) expand(value string) string {
+	return s.processor.ExpandESIVariables(s.substitute(value), s.options.Context)
+}
+
+// endpoint returns the mocked response for a beacon URL
+func (s *simulator) endpoint(beaconURL string) MockEndpoint {
+	endpoint := s.options.DefaultEndpoint
+	if parsed, err := url.Parse(beaconURL); err == nil {
+		if mock, exists := s.options.Endpoints[parsed.Hostname()]; exists {
+			endpoint = mock
+		}
+	}
+	if endpoint.Status == 0 {
+		endpoint.Status = 200
+	}
+	if endpoint.LatencyMS == 0 {
+		endpoint.LatencyMS = int(DefaultMockLatency / time.Millisecond)
+	}
+	return endpoint
+}
+
+// skipDependents records the dependents of a beacon that did not fire
+func (s *simulator) skipDependents(node *beaconNode, batch int, outcome string) {
+	for _, dependent := range node.dependents {
+		reason := fmt.Sprintf("depends on %s", node.pixel.ID)
+		if outcome == BeaconSkipped {
+			reason = fmt.Sprintf("%s did not succeed", node.pixel.ID)
+		}
+		s.record(BeaconSimulation{ID: dependent.pixel.ID, Outcome: outcome, Reason: reason, Batch: batch, After: node.pixel.ID})
+		s.skipDependents(dependent, batch, outcome)
+	}
+}
+
+// record adds a beacon to the report
+func (s *simulator) record(result BeaconSimulation) {
+	s.report.Beacons = append(s.report.Beacons, result)
+	switch result.Outcome {
+	case BeaconFired:
+		s.report.Fired++
+	case BeaconFailed:
+		s.report.Failed++
+	case BeaconSuppressed:
+		s.report.Suppressed++
+	case BeaconSkipped:
+		s.report.Skipped++
+	}
+}
@@ -0,0 +1,91 @@
+package esi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulateContainer(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "us", URL: "https://a.example/p.gif?cc=~~cc~~&h=~~c~uid~hpr~s~~", CONDITIONS: &PixelConditions{Countries: []string{"US"}}},
+		{ID: "fr", URL: "https://b.example/p.gif", CONDITIONS: &PixelConditions{Countries: []string{"FR"}}},
+		{ID: "after_fr", URL: "https://c.example/p.gif", AFTER: "fr"},
+		{ID: "capped", URL: "https://d.example/p.gif", CONDITIONS: &PixelConditions{FrequencyCap: 2}},
+		{ID: "broken", URL: "https://down.example/p.gif", PRIORITY: -1},
+		{ID: "after_broken", URL: "https://c.example/q.gif", AFTER: "broken"},
+		{ID: "collect", URL: "https://slow.example/c", METHOD: "POST", BODY: "u=~~uu~~", AFTER: "us"},
+		{ID: "vendor", URL: "https://e.example/p.gif", CONDITIONS: &PixelConditions{TCFVendor: 755}},
+	}}
+
+	options := SimulationOptions{
+		Context: ProcessContext{
+			Headers: map[string]string{},
+			Cookies: map[string]string{"uid": "42", "fc_capped": "2", TCFCookie: buildTCFString([]int{1}, []int{10})},
+		},
+		Variables: map[string]string{"GEO_COUNTRY": "US", "PMUSER_UU": "user-1"},
+		Endpoints: map[string]MockEndpoint{
+			"down.example": {Status: 503, LatencyMS: 30},
+			"slow.example": {LatencyMS: 200},
+		},
+	}
+
+	report, err := SimulateContainer(config, ESIConfig{MaxConcurrentBeacons: 3}, options)
+	require.NoError(t, err)
+
+	byID := make(map[string]BeaconSimulation)
+	for _, beacon := range report.Beacons {
+		byID[beacon.ID] = beacon
+	}
+
+	assert.Equal(t, BeaconFired, byID["us"].Outcome)
+	assert.Equal(t, "https://a.example/p.gif?cc=US&h="+md5Hex("s42"), byID["us"].URL)
+
+	assert.Equal(t, BeaconFired, byID["collect"].Outcome)
+	assert.Equal(t, "POST", byID["collect"].Method)
+	assert.Equal(t, "u=user-1", byID["collect"].Body)
+	assert.Equal(t, "us", byID["collect"].After)
+
+	assert.Equal(t, BeaconSuppressed, byID["fr"].Outcome)
+	assert.Equal(t, "country in FR", byID["fr"].Reason)
+	assert.Equal(t, BeaconSuppressed, byID["after_fr"].Outcome)
+	assert.Equal(t, "depends on fr", byID["after_fr"].Reason)
+
+	assert.Equal(t, BeaconSuppressed, byID["capped"].Outcome)
+	assert.Equal(t, "fired fewer than 2 times", byID["capped"].Reason)
+
+	assert.Equal(t, BeaconSuppressed, byID["vendor"].Outcome)
+	assert.Equal(t, "TCF consent for vendor 755", byID["vendor"].Reason)
+
+	assert.Equal(t, BeaconFailed, byID["broken"].Outcome)
+	assert.Equal(t, "HTTP 503", byID["broken"].Reason)
+	assert.Equal(t, BeaconSkipped, byID["after_broken"].Outcome)
+
+	assert.Equal(t, 2, report.Fired)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 4, report.Suppressed)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 50+200+30, report.TotalLatencyMS)
+	// Batch 1 (us → collect, fr, capped) takes 250ms, batch 2 (vendor, broken) 30ms
+	assert.Equal(t, 280, report.ParallelLatencyMS)
+	assert.Equal(t, 2, byID["broken"].Batch)
+}
+
+func TestSimulateContainer_Static(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "fr", URL: "https://b.example/p.gif", CONDITIONS: &PixelConditions{Countries: []string{"FR"}}},
+		{ID: "us", URL: "https://a.example/p.gif?h=~~c~uid~hpo~s~~"},
+	}}
+	esiConfig := ESIConfig{
+		ConditionMode: ConditionModeStatic,
+		StaticContext: &ConditionContext{Country: "US", Cookies: map[string]string{"uid": "abc"}},
+	}
+
+	report, err := SimulateContainer(config, esiConfig, SimulationOptions{})
+	require.NoError(t, err)
+	require.Len(t, report.Beacons, 2)
+	assert.Equal(t, BeaconSuppressed, report.Beacons[0].Outcome)
+	assert.Equal(t, "https://a.example/p.gif?h="+md5Hex("abcs"), report.Beacons[1].URL)
+	assert.Equal(t, 50, report.ParallelLatencyMS)
+}
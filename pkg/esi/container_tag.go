@@ -3,10 +3,13 @@ package esi
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/url"
 	"regexp"
 	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esigen"
 )
 
 // ContainerConfig represents the JSON configuration for partner beacons
@@ -26,6 +29,15 @@ type Pixel struct {
 	CONTINENT_FREQ map[string]int         `json:"CONTINENT_FREQ,omitempty"`
 	FIRE_EXPR      string                 `json:"FIRE_EXPR,omitempty"`
 	SCRIPT         string                 `json:"SCRIPT,omitempty"`
+	CONDITIONS     *PixelConditions       `json:"CONDITIONS,omitempty"`
+	METHOD         string                 `json:"METHOD,omitempty"`
+	BODY           string                 `json:"BODY,omitempty"`
+	BODY_TYPE      string                 `json:"BODY_TYPE,omitempty"`
+	HEADERS        map[string]string      `json:"HEADERS,omitempty"`
+	PRIORITY       int                    `json:"PRIORITY,omitempty"`
+	AFTER          string                 `json:"AFTER,omitempty"`
+	Partner        string                 `json:"partner,omitempty"`
+	PartnerParams  map[string]string      `json:"partnerParams,omitempty"`
 	Extra          map[string]interface{} `json:"-"`
 }
 
@@ -33,15 +45,70 @@ type Pixel struct {
 type ESIConfig struct {
 	BrowserVars bool
 	MaxWait     int
+
+	// ConditionMode selects runtime (ESI) or static (generation-time) evaluation of
+	// conditions and of request-dependent macros such as cookie hashes and suu
+	ConditionMode string
+	// StaticContext is the request conditions are evaluated against in static mode
+	StaticContext *ConditionContext
+	// Geo resolves the static context's country from its client IP
+	Geo GeoProvider
+	// Hashing configures hpr/hpo cookie hashing and suu fingerprints
+	Hashing HashConfig
+	// FrequencyEndpoint is the URL of the emulator's /frequency endpoint. When set,
+	// frequency capped pixels report each fire to it, which refreshes their cookie.
+	FrequencyEndpoint string
+	// Partners resolves the partner templates pixels reference (DefaultPartners if nil)
+	Partners *PartnerRegistry
+	// MaxConcurrentBeacons limits how many independent beacons are emitted per batch (0 for no limit)
+	MaxConcurrentBeacons int
+	// MaxIncludes is the include budget generated output is checked against (DefaultContainerIncludeBudget if 0)
+	MaxIncludes int
+	// BrowserTimeout is how long, in milliseconds, browser pixels may run (DefaultBrowserTimeout if 0)
+	BrowserTimeout int
 }
 
 // ProcessContainerConfig processes the JSON configuration and generates ESI includes
 func ProcessContainerConfig(config ContainerConfig, esiConfig ESIConfig) (string, ContainerConfig, error) {
 	var esiIncludes []string
+
+	roots, browserConfig, err := prepareContainer(config, esiConfig)
+	if err != nil {
+		return "", ContainerConfig{}, err
+	}
+	for _, root := range roots {
+		esiInclude, err := renderBeacon(root, esiConfig)
+		if err != nil {
+			return "", ContainerConfig{}, err
+		}
+		esiIncludes = append(esiIncludes, esiInclude)
+	}
+	esiIncludes = batchBeacons(esiIncludes, esiConfig.MaxConcurrentBeacons)
+
+	// Generate the ESI content
+	esiContent := generateESIContent(esiIncludes, esiConfig)
+
+	return esiContent, browserConfig, nil
+}
+
+// prepareContainer applies partner templates and defaults to the configured pixels.
+// It returns the dir pixels as a beacon tree and a config with the browser-executed pixels.
+func prepareContainer(config ContainerConfig, esiConfig ESIConfig) ([]*beaconNode, ContainerConfig, error) {
 	var browserPixels []Pixel
 
+	if err := esiConfig.Hashing.Validate(esiConfig.ConditionMode); err != nil {
+		return nil, ContainerConfig{}, err
+	}
+
 	// Process each pixel
+	var dirPixels []Pixel
 	for _, pixel := range config.Pixels {
+		// Inherit unset fields from the partner template
+		pixel, err := applyPartner(pixel, esiConfig.Partners)
+		if err != nil {
+			return nil, ContainerConfig{}, err
+		}
+
 		// Set defaults if not provided
 		if pixel.TYPE == "" {
 			pixel.TYPE = "dir"
@@ -59,43 +126,83 @@ func ProcessContainerConfig(config ContainerConfig, esiConfig ESIConfig) (string
 			pixel.RC = "default"
 		}
 
-		// Filter pixels: keep frm and script types for browser execution
-		if pixel.TYPE == "frm" || pixel.TYPE == "script" {
+		// Filter pixels: keep frm, script and img types for browser execution
+		if _, browser := browserInjection[pixel.TYPE]; browser {
 			browserPixels = append(browserPixels, pixel)
 			continue
 		}
 
-		// Process dir type pixels for ESI conversion
+		// Collect dir type pixels for ESI conversion
 		if pixel.TYPE == "dir" {
-			esiInclude, err := generateESIInclude(pixel, esiConfig)
-			if err != nil {
-				return "", ContainerConfig{}, fmt.Errorf("error generating ESI for pixel %s: %w", pixel.ID, err)
-			}
-			esiIncludes = append(esiIncludes, esiInclude)
+			dirPixels = append(dirPixels, pixel)
 		}
 	}
 
-	// Generate the ESI content
-	esiContent := generateESIContent(esiIncludes, esiConfig)
+	// Order dir pixels by priority and nest dependents under the beacon they follow
+	roots, err := buildBeaconTree(dirPixels)
+	if err != nil {
+		return nil, ContainerConfig{}, err
+	}
 
 	// Create new config with only browser-executed pixels
 	browserConfig := ContainerConfig{
 		Pixels: browserPixels,
 	}
 
-	return esiContent, browserConfig, nil
+	return roots, browserConfig, nil
 }
 
+// errHashing marks macro errors that must fail generation rather than leave the macro in place
+var errHashing = errors.New("hashing failed")
+
 // generateESIInclude generates an ESI include for a single pixel
 func generateESIInclude(pixel Pixel, config ESIConfig) (string, error) {
+	return generateBeacon(pixel, config, "")
+}
+
+// generateBeacon generates the ESI markup of a pixel. Dependents, the markup of beacons
+// that fire only if this one succeeds, are placed after the include inside an esi:try
+// attempt, so an include failure skips them. Such an include waits for its response
+// instead of firing and forgetting, since success can only be observed that way. The
+// dependents have an esi:try of their own, so their failures leave the pixel's fire alone.
+func generateBeacon(pixel Pixel, config ESIConfig, dependents string) (string, error) {
 	// Process URL with macro substitution
 	processedURL, err := processMacros(pixel.URL, config)
 	if err != nil {
 		return "", fmt.Errorf("error processing macros in URL: %w", err)
 	}
 
+	// Request attributes of POST beacons
+	requestAttributes, err := beaconRequestAttributes(pixel, config)
+	if err != nil {
+		return "", err
+	}
+
 	// Generate ESI include with MAXWAIT=0 for fire-and-forget
-	esiInclude := fmt.Sprintf(`<esi:include src="%s" maxwait="%d" />`, processedURL, config.MaxWait)
+	include := esigen.Include(processedURL).Attrs(requestAttributes)
+	if dependents == "" || config.MaxWait != 0 {
+		include.MaxWait(config.MaxWait)
+	}
+
+	// Report the fire of frequency capped pixels
+	esiInclude := include.String() + frequencyInclude(pixel, config)
+
+	if dependents != "" {
+		dependentsTry := esigen.Try(esigen.Raw(dependents)).
+			Except(esigen.Comment(fmt.Sprintf("dependents of pixel %s failed", pixel.ID)))
+		esiInclude = esigen.Try(esigen.Raw(esiInclude), dependentsTry).
+			Except(esigen.Comment(fmt.Sprintf("pixel %s failed: dependents skipped", pixel.ID))).String()
+	}
+
+	// Compute the cookie hashes, suu and transformed macros not known at generation
+	// time at the edge before the include
+	esiInclude = strings.Join(hashAssignments(pixel.URL+" "+pixel.BODY, config), "") + esiInclude
+
+	// Unless evaluated at generation time, wrap the include in runtime conditions;
+	// the sample the conditions test is assigned first
+	if config.ConditionMode != ConditionModeStatic {
+		esiInclude = sampleAssignment(pixel) + wrapWithConditions(esiInclude, buildConditionClauses(pixel))
+	}
 
 	return esiInclude, nil
 }
@@ -115,37 +222,47 @@ func generateESIContent(includes []string, config ESIConfig) string {
 	return content.String()
 }
 
+// macroPattern matches ~~macro~~ placeholders
+var macroPattern = regexp.MustCompile(`~~(.*?)~~`)
+
 // processMacros processes macro substitution in URLs
 func processMacros(urlStr string, config ESIConfig) (string, error) {
-	macroRegex := regexp.MustCompile(`~~(.*?)~~`)
-
-	processedURL := macroRegex.ReplaceAllStringFunc(urlStr, func(match string) string {
+	var macroErr error
+	processedURL := macroPattern.ReplaceAllStringFunc(urlStr, func(match string) string {
 		macroContent := match[2 : len(match)-2]
 		replacement, err := processMacro(macroContent, config)
 		if err != nil {
+			if (errors.Is(err, errHashing) || errors.Is(err, errModifier)) && macroErr == nil {
+				macroErr = err
+			}
 			return match
 		}
 		return replacement
 	})
 
-	return processedURL, nil
+	return processedURL, macroErr
 }
 
 // processMacro processes a single macro and returns its replacement
 func processMacro(macro string, config ESIConfig) (string, error) {
-	// Support macros like dl:qs and dl:qs~utm_source
+	base, modifiers, err := parseMacroModifiers(macro)
+	if err != nil {
+		return "", err
+	}
+	if len(modifiers) > 0 {
+		return processModifiedMacro(macro, base, modifiers, config)
+	}
+	return processMacroParts(splitMacro(macro), config)
+}
+
+// splitMacro splits a macro into its parts. Macros like dl:qs~utm_source use
+// ":" after the macro type and "~" between the remaining parts.
+func splitMacro(macro string) []string {
 	if strings.Contains(macro, ":") {
 		parts := strings.SplitN(macro, ":", 2)
-		macroType := parts[0]
-		rest := parts[1]
-		// Rebuild the parts slice as [macroType, rest split by ~...]
-		restParts := strings.Split(rest, "~")
-		allParts := append([]string{macroType}, restParts...)
-		return processMacroParts(allParts, config)
-	}
-	// Otherwise, split by ~ as before
-	parts := strings.Split(macro, "~")
-	return processMacroParts(parts, config)
+		return append([]string{parts[0]}, strings.Split(parts[1], "~")...)
+	}
+	return strings.Split(macro, "~")
 }
 
 // Helper to process macro parts
@@ -154,6 +271,18 @@ func processMacroParts(parts []string, config ESIConfig) (string, error) {
 		return "", fmt.Errorf("empty macro")
 	}
 	macroType := parts[0]
+
+	// Request-dependent hashes are computed at generation time in static mode
+	if config.ConditionMode == ConditionModeStatic {
+		value, ok, err := staticHashValue(parts, config)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errHashing, err)
+		}
+		if ok {
+			return value, nil
+		}
+	}
+
 	switch macroType {
 	case "r":
 		return "$(TIME)", nil
@@ -166,7 +295,7 @@ func processMacroParts(parts []string, config ESIConfig) (string, error) {
 	case "uu":
 		return "$(PMUSER_UU)", nil
 	case "suu":
-		return "$(PMUSER_SUU)", nil
+		return "$(" + suuVariable + ")", nil
 	case "c":
 		return processCookieMacro(parts, config)
 	case "dl":
@@ -188,19 +317,9 @@ func processCookieMacro(parts []string, config ESIConfig) (string, error) {
 
 	cookieName := parts[1]
 
-	// Check for hash directives
-	if len(parts) >= 4 {
-		hashType := parts[2]
-		salt := parts[3]
-
-		switch hashType {
-		case "hpr":
-			// Hash: path + cookie value
-			return fmt.Sprintf("$(PMUSER_COOKIE_HASH_PR_{%s}_{%s})", cookieName, salt), nil
-		case "hpo":
-			// Hash: cookie value + path
-			return fmt.Sprintf("$(PMUSER_COOKIE_HASH_PO_{%s}_{%s})", cookieName, salt), nil
-		}
+	// Hashed cookies refer to the variable assigned before the include
+	if macro, ok := parseCookieHashMacro(parts, config); ok {
+		return "$(" + macro.variable() + ")", nil
 	}
 
 	// Simple cookie value
@@ -281,185 +400,6 @@ func GenerateCookieHash(cookieValue, salt string, hashType string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// GenerateESIFunctions generates ESI functions for advanced macro processing
-func GenerateESIFunctions() string {
-	return `<!-- ESI Functions for Advanced Macro Processing -->
-
-<esi:function name="generate_suu">
-    <!-- Comprehensive fingerprint generation similar to JavaScript Fingerprint2 -->
-    <esi:assign name="ip" value="$(CLIENT_IP)" />
-    <esi:assign name="ua" value="$(HTTP_USER_AGENT)" />
-    <esi:assign name="accept" value="$(HTTP_ACCEPT)" />
-    <esi:assign name="accept_lang" value="$(HTTP_ACCEPT_LANGUAGE)" />
-    <esi:assign name="accept_enc" value="$(HTTP_ACCEPT_ENCODING)" />
-    <esi:assign name="connection" value="$(HTTP_CONNECTION)" />
-    <esi:assign name="upgrade_insecure" value="$(HTTP_UPGRADE_INSECURE_REQUESTS)" />
-    
-    <!-- Build fingerprint components in order of importance -->
-    <esi:assign name="components" value="" />
-    
-    <esi:choose>
-        <esi:when test="$is_empty($(ip))">
-            <!-- Skip empty IP -->
-        </esi:when>
-        <esi:otherwise>
-            <esi:assign name="components" value="client_ip:$(ip)" />
-        </esi:otherwise>
-    </esi:choose>
-    
-    <esi:choose>
-        <esi:when test="$is_empty($(ua))">
-            <!-- Skip empty User Agent -->
-        </esi:when>
-        <esi:otherwise>
-            <esi:choose>
-                <esi:when test="$is_empty($(components))">
-                    <esi:assign name="components" value="user_agent:$(ua)" />
-                </esi:when>
-                <esi:otherwise>
-                    <esi:assign name="components" value="$(components)~~~user_agent:$(ua)" />
-                </esi:otherwise>
-            </esi:choose>
-        </esi:otherwise>
-    </esi:choose>
-    
-    <esi:choose>
-        <esi:when test="$is_empty($(accept))">
-            <!-- Skip empty Accept -->
-        </esi:when>
-        <esi:otherwise>
-            <esi:choose>
-                <esi:when test="$is_empty($(components))">
-                    <esi:assign name="components" value="accept:$(accept)" />
-                </esi:when>
-                <esi:otherwise>
-                    <esi:assign name="components" value="$(components)~~~accept:$(accept)" />
-                </esi:otherwise>
-            </esi:choose>
-        </esi:otherwise>
-    </esi:choose>
-    
-    <esi:choose>
-        <esi:when test="$is_empty($(accept_lang))">
-            <!-- Skip empty Accept Language -->
-        </esi:when>
-        <esi:otherwise>
-            <esi:choose>
-                <esi:when test="$is_empty($(components))">
-                    <esi:assign name="components" value="accept_language:$(accept_lang)" />
-                </esi:when>
-                <esi:otherwise>
-                    <esi:assign name="components" value="$(components)~~~accept_language:$(accept_lang)" />
-                </esi:otherwise>
-            </esi:choose>
-        </esi:otherwise>
-    </esi:choose>
-    
-    <esi:choose>
-        <esi:when test="$is_empty($(accept_enc))">
-            <!-- Skip empty Accept Encoding -->
-        </esi:when>
-        <esi:otherwise>
-            <esi:choose>
-                <esi:when test="$is_empty($(components))">
-                    <esi:assign name="components" value="accept_encoding:$(accept_enc)" />
-                </esi:when>
-                <esi:otherwise>
-                    <esi:assign name="components" value="$(components)~~~accept_encoding:$(accept_enc)" />
-                </esi:otherwise>
-            </esi:choose>
-        </esi:otherwise>
-    </esi:choose>
-    
-    <esi:choose>
-        <esi:when test="$is_empty($(connection))">
-            <!-- Skip empty Connection -->
-        </esi:when>
-        <esi:otherwise>
-            <esi:choose>
-                <esi:when test="$is_empty($(components))">
-                    <esi:assign name="components" value="connection:$(connection)" />
-                </esi:when>
-                <esi:otherwise>
-                    <esi:assign name="components" value="$(components)~~~connection:$(connection)" />
-                </esi:otherwise>
-            </esi:choose>
-        </esi:otherwise>
-    </esi:choose>
-    
-    <esi:choose>
-        <esi:when test="$is_empty($(upgrade_insecure))">
-            <!-- Skip empty Upgrade Insecure Requests -->
-        </esi:when>
-        <esi:otherwise>
-            <esi:choose>
-                <esi:when test="$is_empty($(components))">
-                    <esi:assign name="components" value="upgrade_insecure_requests:$(upgrade_insecure)" />
-                </esi:when>
-                <esi:otherwise>
-                    <esi:assign name="components" value="$(components)~~~upgrade_insecure_requests:$(upgrade_insecure)" />
-                </esi:otherwise>
-            </esi:choose>
-        </esi:otherwise>
-    </esi:choose>
-    
-    <!-- Generate MD5 hash of the combined components -->
-    <esi:return value="$digest_md5_hex($(components))" />
-</esi:function>
-
-<esi:function name="generate_simple_suu">
-    <!-- Simple fingerprint generation for backward compatibility -->
-    <esi:assign name="ip" value="$(CLIENT_IP)" />
-    <esi:assign name="accept" value="$(HTTP_ACCEPT)" />
-    <esi:assign name="ua" value="$(HTTP_USER_AGENT)" />
-    <esi:assign name="combined" value="$(ip)$(accept)$(ua)" />
-    <esi:return value="$digest_md5_hex($(combined))" />
-</esi:function>
-
-<esi:function name="cookie_hash">
-    <esi:assign name="cookie_name" value="$(ARGS{0})" />
-    <esi:assign name="hash_type" value="$(ARGS{1})" />
-    <esi:assign name="salt" value="$(ARGS{2})" />
-    <esi:assign name="cookie_value" value="$(HTTP_COOKIE{$(cookie_name)})" />
-    
-    <esi:choose>
-        <esi:when test="$(hash_type)=='hpr'">
-            <esi:return value="$digest_md5_hex($(salt)$(cookie_value))" />
-        </esi:when>
-        <esi:when test="$(hash_type)=='hpo'">
-            <esi:return value="$digest_md5_hex($(cookie_value)$(salt))" />
-        </esi:when>
-        <esi:otherwise>
-            <esi:return value="$(cookie_value)" />
-        </esi:otherwise>
-    </esi:choose>
-</esi:function>
-
-<esi:function name="url_decode">
-    <esi:assign name="encoded" value="$(ARGS{0})" />
-    <esi:return value="$url_decode($(encoded))" />
-</esi:function>
-
-<esi:function name="query_param_decode">
-    <esi:assign name="param_name" value="$(ARGS{0})" />
-    <esi:assign name="param_value" value="$(QUERY_STRING{$(param_name)})" />
-    <esi:return value="$url_decode($(param_value))" />
-</esi:function>
-
-<esi:function name="default_value">
-    <esi:assign name="value" value="$(ARGS{0})" />
-    <esi:assign name="default" value="$(ARGS{1})" />
-    <esi:choose>
-        <esi:when test="$is_empty($(value))">
-            <esi:return value="$(default)" />
-        </esi:when>
-        <esi:otherwise>
-            <esi:return value="$(value)" />
-        </esi:otherwise>
-    </esi:choose>
-</esi:function>`
-}
-
 // GenerateESIInclude generates an ESI include tag for a pixel configuration
 func GenerateESIInclude(pixel Pixel, baseURL string, ipAddress, acceptHeaders, userAgent string) string {
 	// Generate fingerprint ID using passive fingerprinting
@@ -468,7 +408,7 @@ func GenerateESIInclude(pixel Pixel, baseURL string, ipAddress, acceptHeaders, u
 	// Build the URL with parameters
 	url := baseURL + "?" + buildQueryString(pixel, fingerprintID)
 
-	return fmt.Sprintf(`<esi:include src="%s" />`, url)
+	return esigen.Include(url).String()
 }
 
 // GenerateESIIncludeWithMacros generates an ESI include tag with macro substitution
@@ -479,7 +419,7 @@ func GenerateESIIncludeWithMacros(pixel Pixel, baseURL string, ipAddress, accept
 	// Apply macro substitution to the URL
 	url := baseURL + "?" + buildQueryStringWithMacros(pixel, fingerprintID, macros)
 
-	return fmt.Sprintf(`<esi:include src="%s" />`, url)
+	return esigen.Include(url).String()
 }
 
 // buildQueryString builds a query string for a pixel with fingerprint ID
@@ -0,0 +1,440 @@
+package esi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Output targets of the container generator
+const (
+	// TargetAkamai generates ESI markup for Akamai
+	TargetAkamai = "akamai"
+	// TargetFastly generates a Fastly Compute JavaScript service
+	TargetFastly = "fastly"
+	// TargetCloudflare generates a Cloudflare Worker module
+	TargetCloudflare = "cloudflare"
+)
+
+// edgeBeacon is a dir pixel as data for the edge script runtime. URL, body and headers
+// keep ESI variable references, which the runtime expands per request like the ESI
+// processor would, and conditions keep their runtime ESI tests.
+type edgeBeacon struct {
+	ID        string            `json:"id"`
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Body      string            `json:"body,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Assign    []edgeAssignment  `json:"assign,omitempty"`
+	When      [][]string        `json:"when,omitempty"`
+	Frequency *edgeFrequency    `json:"frequency,omitempty"`
+	Then      []*edgeBeacon     `json:"then,omitempty"`
+}
+
+// edgeAssignment computes a hash, suu, sample or transformed macro variable before the beacon's URL is expanded
+type edgeAssignment struct {
+	Name     string   `json:"name"`
+	Function string   `json:"function"`
+	Args     []string `json:"args,omitempty"`
+}
+
+// edgeFrequency is the frequency cap whose cookie the runtime refreshes when the beacon fires
+type edgeFrequency struct {
+	Cap    int    `json:"cap"`
+	Period string `json:"period"`
+}
+
+// edgeEntryPoints hold the platform specific part of each script target
+var edgeEntryPoints = map[string]string{
+	TargetFastly:     fastlyEntryPoint,
+	TargetCloudflare: cloudflareEntryPoint,
+}
+
+// GenerateEdgeScript generates the server-side container for a non-ESI target: a script
+// that proxies the origin and fires the dir pixels in the background with the same
+// macros, conditions, ordering and batching as the ESI container.
+func GenerateEdgeScript(config ContainerConfig, esiConfig ESIConfig, target string) (string, ContainerConfig, error) {
+	entryPoint, ok := edgeEntryPoints[target]
+	if !ok {
+		return "", ContainerConfig{}, fmt.Errorf("unsupported script target %q, must be %s or %s", target, TargetFastly, TargetCloudflare)
+	}
+
+	roots, browserConfig, err := prepareContainer(config, esiConfig)
+	if err != nil {
+		return "", ContainerConfig{}, err
+	}
+
+	beacons := make([]*edgeBeacon, 0, len(roots))
+	var suppressed []string
+	for _, root := range roots {
+		beacon, comments, err := buildEdgeBeacon(root, esiConfig)
+		if err != nil {
+			return "", ContainerConfig{}, err
+		}
+		if beacon != nil {
+			beacons = append(beacons, beacon)
+		}
+		suppressed = append(suppressed, comments...)
+	}
+
+	beaconJSON, err := json.MarshalIndent(beacons, "", "  ")
+	if err != nil {
+		return "", ContainerConfig{}, fmt.Errorf("error encoding beacons: %w", err)
+	}
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "// Server-side container generated for %s. Regenerate it from the JSON configuration instead of editing.\n", target)
+	for _, comment := range suppressed {
+		fmt.Fprintf(&script, "// %s\n", comment)
+	}
+	script.WriteString(strings.NewReplacer(
+		"__BEACONS__", string(beaconJSON),
+		"__MAX_CONCURRENT__", strconv.Itoa(esiConfig.MaxConcurrentBeacons),
+		"__MAX_WAIT__", strconv.Itoa(esiConfig.MaxWait),
+	).Replace(edgeRuntime))
+	script.WriteString(entryPoint)
+
+	return script.String(), browserConfig, nil
+}
+
+// buildEdgeBeacon converts a beacon and its dependents. In static mode a suppressed
+// beacon is left out with its dependents and only described by the returned comments.
+func buildEdgeBeacon(node *beaconNode, config ESIConfig) (*edgeBeacon, []string, error) {
+	pixel := node.pixel
+
+	if config.ConditionMode == ConditionModeStatic {
+		if fires, reason := evaluateConditions(pixel, config.StaticContext, config.Geo); !fires {
+			comments := []string{fmt.Sprintf("pixel %s suppressed: %s", pixel.ID, reason)}
+			for _, comment := range suppressDependents(node) {
+				comments = append(comments, strings.TrimSuffix(strings.TrimPrefix(comment, "<!-- "), " -->"))
+			}
+			return nil, comments, nil
+		}
+	}
+
+	processedURL, err := processMacros(pixel.URL, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating script for pixel %s: error processing macros in URL: %w", pixel.ID, err)
+	}
+	request, err := buildBeaconRequest(pixel, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating script for pixel %s: %w", pixel.ID, err)
+	}
+
+	beacon := &edgeBeacon{
+		ID:     pixel.ID,
+		URL:    processedURL,
+		Method: request.method,
+		Body:   request.body,
+	}
+	if len(request.headers) > 0 {
+		beacon.Headers = request.headers
+	}
+
+	// Values not known at generation time and, unless evaluated at generation time,
+	// conditions are computed per request
+	for _, variable := range hashVariables(pixel.URL+" "+pixel.BODY, config) {
+		beacon.Assign = append(beacon.Assign, edgeAssignment{Name: variable.name, Function: variable.function, Args: variable.args})
+	}
+	if config.ConditionMode != ConditionModeStatic {
+		if variable, ok := sampleVariable(pixel); ok {
+			beacon.Assign = append(beacon.Assign, edgeAssignment{Name: variable.name, Function: variable.function, Args: variable.args})
+		}
+		for _, clause := range buildConditionClauses(pixel) {
+			beacon.When = append(beacon.When, clause.tests)
+		}
+	}
+
+	if conditions := pixel.CONDITIONS; conditions != nil && conditions.FrequencyCap > 0 {
+		period := conditions.FrequencyPeriod
+		if period == "" {
+			period = "session"
+		}
+		beacon.Frequency = &edgeFrequency{Cap: conditions.FrequencyCap, Period: period}
+	}
+
+	var suppressed []string
+	for _, dependent := range node.dependents {
+		child, comments, err := buildEdgeBeacon(dependent, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		if child != nil {
+			beacon.Then = append(beacon.Then, child)
+		}
+		suppressed = append(suppressed, comments...)
+	}
+
+	return beacon, suppressed, nil
+}
+
+// edgeRuntime is the platform independent part of the edge scripts. It expands the ESI
+// variables of the beacons, evaluates their ==/!= tests like the ESI processor and fires
+// them in batches after the response is sent, dependents only after their beacon succeeds.
+const edgeRuntime = `
+const BEACONS = __BEACONS__;
+const MAX_CONCURRENT = __MAX_CONCURRENT__;
+const MAX_WAIT = __MAX_WAIT__;
+
+// userVariables returns the PMUSER_ variables of a request, e.g. { UU: ... } for ~~uu~~.
+// Adapt it to how the property sets them; EVID defaults to a random event ID.
+function userVariables(request) {
+  return { EVID: crypto.randomUUID() };
+}
+
+function parseCookies(header) {
+  const cookies = {};
+  for (const part of (header || "").split(";")) {
+    const index = part.indexOf("=");
+    if (index > 0) {
+      cookies[part.slice(0, index).trim()] = part.slice(index + 1).trim();
+    }
+  }
+  return cookies;
+}
+
+function requestContext(request, country, clientIP) {
+  return {
+    request,
+    url: new URL(request.url),
+    country,
+    clientIP,
+    cookies: parseCookies(request.headers.get("Cookie")),
+    user: userVariables(request),
+    assigned: {},
+  };
+}
+
+function decode(value) {
+  try {
+    return decodeURIComponent(value.replace(/\+/g, " "));
+  } catch (e) {
+    return value;
+  }
+}
+
+function variable(ctx, name, key) {
+  if (key !== undefined) {
+    switch (name) {
+      case "HTTP_COOKIE":
+        return ctx.cookies[key] || "";
+      case "QUERY_STRING":
+      case "PMUSER_DECODED_QS_":
+        return ctx.url.searchParams.get(key) || "";
+      default:
+        return "";
+    }
+  }
+  if (name in ctx.assigned) {
+    return ctx.assigned[name];
+  }
+  switch (name) {
+    case "TIME":
+      return String(Math.floor(Date.now() / 1000));
+    case "GEO_COUNTRY":
+    case "GEO_COUNTRY_CODE":
+      return ctx.country;
+    case "REMOTE_ADDR":
+      return ctx.clientIP;
+    case "QUERY_STRING":
+      return ctx.url.search.slice(1);
+    case "PMUSER_DECODED_QUERY_STRING":
+      return decode(ctx.url.search.slice(1));
+  }
+  if (name.startsWith("PMUSER_")) {
+    return String(ctx.user[name.slice(7)] ?? "");
+  }
+  if (name.startsWith("HTTP_")) {
+    return ctx.request.headers.get(name.slice(5).replace(/_/g, "-")) || "";
+  }
+  return "";
+}
+
+function expand(ctx, value) {
+  return value.replace(/\$\(([A-Za-z_]+)(?:\{([^}]*)\})?(?:\|'([^']*)')?\)/g,
+    (match, name, key, fallback) => variable(ctx, name, key) || fallback || "");
+}
+
+function urlEncode(value) {
+  return encodeURIComponent(value).replace(/[!'()*]/g, (c) => "%" + c.charCodeAt(0).toString(16).toUpperCase()).replace(/%20/g, "+");
+}
+
+function unquote(value) {
+  return value.trim().replace(/^['"]+|['"]+$/g, "");
+}
+
+function evaluate(ctx, test) {
+  for (const operator of ["==", "!="]) {
+    const index = test.indexOf(operator);
+    if (index >= 0) {
+      const left = unquote(expand(ctx, test.slice(0, index)));
+      const right = unquote(expand(ctx, test.slice(index + 2)));
+      return operator === "==" ? left === right : left !== right;
+    }
+  }
+  const value = expand(ctx, test).trim();
+  return value === "true" || value === "1";
+}
+
+async function md5(value) {
+  const digest = await crypto.subtle.digest("MD5", new TextEncoder().encode(value));
+  return [...new Uint8Array(digest)].map((b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+async function assign(ctx, assignments) {
+  for (const assignment of assignments || []) {
+    if (assignment.function === "cookie_hash") {
+      const [cookie, type, salt] = assignment.args;
+      const value = ctx.cookies[cookie] || "";
+      ctx.assigned[assignment.name] = await md5(type === "hpr" ? salt + value : type === "hpo" ? value + salt : value);
+    } else if (assignment.function === "generate_simple_suu") {
+      const headers = ctx.request.headers;
+      ctx.assigned[assignment.name] = await md5(ctx.clientIP + (headers.get("Accept") || "") + (headers.get("User-Agent") || ""));
+    } else if (assignment.function === "sample_bucket") {
+      const [cookie, salt, rate] = assignment.args;
+      const headers = ctx.request.headers;
+      const stableID = (cookie && ctx.cookies[cookie]) || await md5(ctx.clientIP + (headers.get("Accept") || "") + (headers.get("User-Agent") || ""));
+      const bucket = parseInt((await md5(stableID + salt)).slice(0, 4), 16) % 100;
+      ctx.assigned[assignment.name] = bucket < Number(rate) ? "in" : "out";
+    } else if (assignment.function === "transform") {
+      const [reference, ...modifiers] = assignment.args;
+      let value = expand(ctx, reference);
+      for (const modifier of modifiers) {
+        const [name, ...arg] = modifier.split(":");
+        if (name === "default") {
+          value = value || arg.join(":");
+        } else if (name === "urlencode") {
+          value = urlEncode(value);
+        } else if (name === "hash") {
+          value = await md5(value);
+        }
+      }
+      ctx.assigned[assignment.name] = value;
+    }
+  }
+}
+
+// plan evaluates the conditions of the beacons and expands the requests of those that fire
+async function plan(ctx, beacons) {
+  const planned = [];
+  for (const beacon of beacons) {
+    await assign(ctx, beacon.assign);
+    if (!(beacon.when || []).every((tests) => tests.some((test) => evaluate(ctx, test)))) {
+      continue;
+    }
+    const headers = {};
+    for (const [name, value] of Object.entries(beacon.headers || {})) {
+      headers[name] = expand(ctx, value);
+    }
+    planned.push({
+      beacon,
+      url: expand(ctx, beacon.url),
+      init: { method: beacon.method, headers, body: beacon.body ? expand(ctx, beacon.body) : undefined },
+      then: await plan(ctx, beacon.then || []),
+    });
+  }
+  return planned;
+}
+
+// frequencyCookies refreshes the frequency cookies of the planned beacons
+function frequencyCookies(ctx, planned) {
+  const cookies = [];
+  for (const fire of planned) {
+    const frequency = fire.beacon.frequency;
+    if (frequency) {
+      const name = "fc_" + fire.beacon.id;
+      let cookie = name + "=" + ((parseInt(ctx.cookies[name], 10) || 0) + 1) + "; Path=/; HttpOnly; SameSite=Lax";
+      if (frequency.period === "day") {
+        const midnight = new Date();
+        midnight.setUTCHours(24, 0, 0, 0);
+        cookie += "; Expires=" + midnight.toUTCString();
+      }
+      cookies.push(cookie);
+    }
+    cookies.push(...frequencyCookies(ctx, fire.then));
+  }
+  return cookies;
+}
+
+async function fire(planned) {
+  try {
+    const init = beaconInit(planned.init);
+    if (MAX_WAIT > 0) {
+      init.signal = AbortSignal.timeout(MAX_WAIT);
+    }
+    const response = await fetch(planned.url, init);
+    if (response.body) {
+      await response.body.cancel();
+    }
+    if (!response.ok) {
+      return;
+    }
+  } catch (e) {
+    return;
+  }
+  await Promise.all(planned.then.map(fire));
+}
+
+async function fireAll(planned) {
+  const size = MAX_CONCURRENT > 0 ? MAX_CONCURRENT : Math.max(planned.length, 1);
+  for (let start = 0; start < planned.length; start += size) {
+    await Promise.all(planned.slice(start, start + size).map(fire));
+  }
+}
+
+function withCookies(origin, cookies) {
+  const response = new Response(origin.body, origin);
+  for (const cookie of cookies) {
+    response.headers.append("Set-Cookie", cookie);
+  }
+  return response;
+}
+`
+
+// fastlyEntryPoint serves a Fastly Compute JavaScript service. The origin and the
+// beacon hosts are reached through the named backends.
+const fastlyEntryPoint = `
+/// <reference types="@fastly/js-compute" />
+import { getGeolocationForIpAddress } from "fastly:geolocation";
+
+const ORIGIN_BACKEND = "origin";
+const BEACON_BACKEND = "beacons";
+
+function beaconInit(init) {
+  return { ...init, backend: BEACON_BACKEND };
+}
+
+async function handleRequest(event) {
+  const request = event.request;
+  const clientIP = event.client.address;
+  const geo = getGeolocationForIpAddress(clientIP);
+  const ctx = requestContext(request, (geo && geo.country_code) || "", clientIP);
+
+  const planned = await plan(ctx, BEACONS);
+  const origin = await fetch(request, { backend: ORIGIN_BACKEND });
+  event.waitUntil(fireAll(planned));
+  return withCookies(origin, frequencyCookies(ctx, planned));
+}
+
+addEventListener("fetch", (event) => event.respondWith(handleRequest(event)));
+`
+
+// cloudflareEntryPoint serves a Cloudflare Worker module
+const cloudflareEntryPoint = `
+function beaconInit(init) {
+  return { ...init };
+}
+
+export default {
+  async fetch(request, env, context) {
+    const country = (request.cf && request.cf.country) || "";
+    const ctx = requestContext(request, country, request.headers.get("CF-Connecting-IP") || "");
+
+    const planned = await plan(ctx, BEACONS);
+    const origin = await fetch(request);
+    context.waitUntil(fireAll(planned));
+    return withCookies(origin, frequencyCookies(ctx, planned));
+  },
+};
+`
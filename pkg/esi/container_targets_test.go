@@ -0,0 +1,112 @@
+package esi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptBeacons extracts the beacon data embedded in a generated edge script
+func scriptBeacons(t *testing.T, script string) []edgeBeacon {
+	start := strings.Index(script, "const BEACONS = ") + len("const BEACONS = ")
+	end := strings.Index(script, ";\nconst MAX_CONCURRENT")
+	require.True(t, start > 0 && end > start, "script embeds no beacons")
+
+	var beacons []edgeBeacon
+	require.NoError(t, json.Unmarshal([]byte(script[start:end]), &beacons))
+	return beacons
+}
+
+func TestGenerateEdgeScript(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "sync", URL: "https://a.example/p.gif?cc=~~cc~~&h=~~c~uid~hpr~s~~", CONDITIONS: &PixelConditions{Countries: []string{"US"}, FrequencyCap: 2}},
+		{ID: "collect", URL: "https://b.example/c", METHOD: "POST", BODY: "e=~~evid~~", AFTER: "sync"},
+		{ID: "top", URL: "https://c.example/p", PRIORITY: 5},
+		{ID: "iframe", URL: "https://d.example/f.html", TYPE: "frm"},
+	}}
+
+	tests := []struct {
+		target     string
+		entryPoint string
+	}{
+		{target: TargetCloudflare, entryPoint: "export default {"},
+		{target: TargetFastly, entryPoint: `addEventListener("fetch"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			script, browserConfig, err := GenerateEdgeScript(config, ESIConfig{MaxConcurrentBeacons: 2}, tt.target)
+			require.NoError(t, err)
+			assert.Contains(t, script, tt.entryPoint)
+			assert.Contains(t, script, "const MAX_CONCURRENT = 2;")
+			require.Len(t, browserConfig.Pixels, 1)
+
+			beacons := scriptBeacons(t, script)
+			require.Len(t, beacons, 2)
+			assert.Equal(t, "top", beacons[0].ID)
+
+			sync := beacons[1]
+			assert.Equal(t, "https://a.example/p.gif?cc=$(GEO_COUNTRY)&h=$("+cookieHashMacro{cookie: "uid", hashType: "hpr", salt: "s"}.variable()+")", sync.URL)
+			assert.Equal(t, []edgeAssignment{{Name: cookieHashMacro{cookie: "uid", hashType: "hpr", salt: "s"}.variable(), Function: "cookie_hash", Args: []string{"uid", "hpr", "s"}}}, sync.Assign)
+			assert.Equal(t, [][]string{
+				{"$(GEO_COUNTRY_CODE)=='US'"},
+				{"$(HTTP_COOKIE{fc_sync})==''", "$(HTTP_COOKIE{fc_sync})=='0'", "$(HTTP_COOKIE{fc_sync})=='1'"},
+			}, sync.When)
+			assert.Equal(t, &edgeFrequency{Cap: 2, Period: "session"}, sync.Frequency)
+
+			require.Len(t, sync.Then, 1)
+			collect := sync.Then[0]
+			assert.Equal(t, "POST", collect.Method)
+			assert.Equal(t, "e=$(PMUSER_EVID)", collect.Body)
+			assert.Equal(t, map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, collect.Headers)
+		})
+	}
+}
+
+func TestGenerateEdgeScript_Static(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "fr", URL: "https://a.example/p.gif", CONDITIONS: &PixelConditions{Countries: []string{"FR"}}},
+		{ID: "after_fr", URL: "https://b.example/p.gif", AFTER: "fr"},
+		{ID: "us", URL: "https://c.example/p.gif?h=~~c~uid~hpo~s~~", CONDITIONS: &PixelConditions{Countries: []string{"US"}}},
+	}}
+	esiConfig := ESIConfig{
+		ConditionMode: ConditionModeStatic,
+		StaticContext: &ConditionContext{Country: "US", Cookies: map[string]string{"uid": "abc"}},
+	}
+
+	script, _, err := GenerateEdgeScript(config, esiConfig, TargetCloudflare)
+	require.NoError(t, err)
+	assert.Contains(t, script, "// pixel fr suppressed: country in FR\n// pixel after_fr suppressed: depends on fr\n")
+
+	beacons := scriptBeacons(t, script)
+	require.Len(t, beacons, 1)
+	assert.Equal(t, "https://c.example/p.gif?h="+md5Hex("abcs"), beacons[0].URL)
+	assert.Empty(t, beacons[0].When)
+	assert.Empty(t, beacons[0].Assign)
+}
+
+func TestGenerateEdgeScript_Modifiers(t *testing.T) {
+	config := ContainerConfig{Pixels: []Pixel{
+		{ID: "p", URL: "https://a.example/p.gif?seg=~~c~seg|default:none~~&u=~~uu|default:anon|hash~~"},
+	}}
+
+	script, _, err := GenerateEdgeScript(config, ESIConfig{}, TargetCloudflare)
+	require.NoError(t, err)
+
+	beacons := scriptBeacons(t, script)
+	require.Len(t, beacons, 1)
+	name := transformVariableName("uu|default:anon|hash")
+	assert.Equal(t, "https://a.example/p.gif?seg=$(HTTP_COOKIE{seg}|'none')&u=$("+name+")", beacons[0].URL)
+	assert.Equal(t, []edgeAssignment{{Name: name, Function: transformFunction, Args: []string{"$(PMUSER_UU)", "default:anon", "hash:md5"}}}, beacons[0].Assign)
+}
+
+func TestGenerateEdgeScript_Errors(t *testing.T) {
+	_, _, err := GenerateEdgeScript(ContainerConfig{}, ESIConfig{}, "vcl")
+	assert.EqualError(t, err, `unsupported script target "vcl", must be fastly or cloudflare`)
+
+	_, _, err = GenerateEdgeScript(ContainerConfig{Pixels: []Pixel{{ID: "p", URL: "https://a.example", METHOD: "PUT"}}}, ESIConfig{}, TargetFastly)
+	assert.EqualError(t, err, `error generating script for pixel p: unsupported beacon method "PUT"`)
+}
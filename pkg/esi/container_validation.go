@@ -0,0 +1,438 @@
+package esi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ValidationError is a schema violation in a container configuration
+type ValidationError struct {
+	// Path locates the offending value, e.g. pixels[2].URL
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// JSON kinds of configuration properties
+const (
+	kindString  = "string"
+	kindInteger = "integer"
+	kindBoolean = "boolean"
+	kindObject  = "object"
+	kindArray   = "array"
+)
+
+// propertySchema describes one property of a configuration object
+type propertySchema struct {
+	name     string
+	kind     string
+	required bool
+	// requiredUnless names a property that makes a required property optional
+	requiredUnless string
+	// enum lists the allowed string values, compared case-insensitively when fold is set
+	enum []string
+	fold bool
+	// minimum and maximum bound integers when hasRange is set
+	hasRange         bool
+	minimum, maximum int64
+	// check validates the value further once its kind is correct
+	check func(path string, value interface{}) []ValidationError
+}
+
+// pixelSchema describes the properties of a pixel, in reporting order
+var pixelSchema = []propertySchema{
+	{name: "ID", kind: kindString, required: true, check: checkNotEmpty},
+	{name: "URL", kind: kindString, required: true, requiredUnless: "partner", check: checkBeaconURL},
+	{name: "TYPE", kind: kindString, enum: []string{"dir", "frm", "script", "img"}},
+	{name: "REQ", kind: kindBoolean},
+	{name: "PCT", kind: kindInteger, hasRange: true, minimum: 0, maximum: 100},
+	{name: "CAP", kind: kindInteger, hasRange: true, minimum: 0, maximum: 1<<31 - 1},
+	{name: "RC", kind: kindString},
+	{name: "CONTINENT_FREQ", kind: kindObject, check: checkContinentFrequencies},
+	{name: "FIRE_EXPR", kind: kindString},
+	{name: "SCRIPT", kind: kindString},
+	{name: "CONDITIONS", kind: kindObject, check: checkConditions},
+	{name: "METHOD", kind: kindString, enum: []string{"GET", "POST"}, fold: true},
+	{name: "BODY", kind: kindString, check: checkMacroModifiers},
+	{name: "BODY_TYPE", kind: kindString, enum: []string{BodyTypeForm, BodyTypeJSON}, fold: true},
+	{name: "HEADERS", kind: kindObject, check: checkStringMap},
+	{name: "PRIORITY", kind: kindInteger, hasRange: true, minimum: -1 << 31, maximum: 1<<31 - 1},
+	{name: "AFTER", kind: kindString},
+	{name: "partner", kind: kindString, check: checkPartner},
+	{name: "partnerParams", kind: kindObject, check: checkStringMap},
+}
+
+// conditionsSchema describes the properties of a pixel's CONDITIONS
+var conditionsSchema = []propertySchema{
+	{name: "countries", kind: kindArray, check: checkCountryCodes},
+	{name: "excludeCountries", kind: kindArray, check: checkCountryCodes},
+	{name: "consent", kind: kindString},
+	{name: "frequencyCap", kind: kindInteger, hasRange: true, minimum: 0, maximum: 1<<31 - 1},
+	{name: "frequencyPeriod", kind: kindString, enum: []string{"session", "day"}},
+	{name: "sampleRate", kind: kindInteger, hasRange: true, minimum: 1, maximum: 100},
+	{name: "sampleKey", kind: kindString, check: checkNotEmpty},
+	{name: "keyValues", kind: kindObject, check: checkKeyValues},
+	{name: "tcfVendor", kind: kindInteger, hasRange: true, minimum: 1, maximum: 1<<16 - 1},
+	{name: "tcfPurposes", kind: kindArray, check: checkTCFPurposes},
+	{name: "usPrivacy", kind: kindBoolean},
+}
+
+// continents are the continent codes accepted in CONTINENT_FREQ
+var continents = []string{"AF", "AN", "AS", "EU", "NA", "OC", "SA"}
+
+// ValidateConfig validates a container configuration document against the pixel schema
+// and the references between pixels. It returns every violation found, pixel by pixel,
+// or nil if the configuration can be generated.
+func ValidateConfig(data []byte) []ValidationError {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return []ValidationError{{Message: jsonErrorMessage(data, err)}}
+	}
+	if decoder.More() {
+		return []ValidationError{{Message: "invalid JSON: unexpected data after the configuration object"}}
+	}
+
+	root, ok := document.(map[string]interface{})
+	if !ok {
+		return []ValidationError{{Message: "configuration must be a JSON object"}}
+	}
+
+	var errs []ValidationError
+	errs = append(errs, unknownProperties("", root, []propertySchema{{name: "pixels"}})...)
+
+	value, exists := root["pixels"]
+	if !exists {
+		return append(errs, ValidationError{Path: "pixels", Message: "required property is missing"})
+	}
+	pixels, ok := value.([]interface{})
+	if !ok {
+		return append(errs, ValidationError{Path: "pixels", Message: fmt.Sprintf("must be an array, got %s", kindOf(value))})
+	}
+
+	ids := make(map[string]int, len(pixels))
+	types := make(map[string]string, len(pixels))
+	for i, value := range pixels {
+		path := fmt.Sprintf("pixels[%d]", i)
+		pixel, ok := value.(map[string]interface{})
+		if !ok {
+			errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("must be an object, got %s", kindOf(value))})
+			continue
+		}
+		errs = append(errs, validateObject(path, pixel, pixelSchema)...)
+
+		if id, ok := pixel["ID"].(string); ok && id != "" {
+			if first, exists := ids[id]; exists {
+				errs = append(errs, ValidationError{Path: path + ".ID", Message: fmt.Sprintf("duplicate ID %q, first used by pixels[%d]", id, first)})
+			} else {
+				ids[id] = i
+				pixelType, _ := pixel["TYPE"].(string)
+				if pixelType == "" {
+					pixelType = "dir"
+				}
+				types[id] = pixelType
+			}
+		}
+
+		method, _ := pixel["METHOD"].(string)
+		if body, ok := pixel["BODY"].(string); ok && body != "" && (method == "" || strings.EqualFold(method, "GET")) {
+			errs = append(errs, ValidationError{Path: path + ".BODY", Message: "GET beacons cannot have a BODY"})
+		}
+	}
+
+	// AFTER references are checked once all IDs are known
+	for i, value := range pixels {
+		pixel, _ := value.(map[string]interface{})
+		after, ok := pixel["AFTER"].(string)
+		if !ok || after == "" {
+			continue
+		}
+		path := fmt.Sprintf("pixels[%d].AFTER", i)
+		pixelType, exists := types[after]
+		switch {
+		case !exists:
+			errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("unknown pixel ID %q", after)})
+		case pixelType != "dir":
+			errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("pixel %q is a %s pixel; only dir pixels can be depended on", after, pixelType)})
+		}
+	}
+
+	return errs
+}
+
+// validateObject validates an object against its schema
+func validateObject(path string, object map[string]interface{}, schema []propertySchema) []ValidationError {
+	var errs []ValidationError
+	for _, property := range schema {
+		propertyPath := path + "." + property.name
+		value, exists := object[property.name]
+		if !exists || value == nil {
+			if property.required && (property.requiredUnless == "" || object[property.requiredUnless] == nil) {
+				errs = append(errs, ValidationError{Path: propertyPath, Message: "required property is missing"})
+			}
+			continue
+		}
+		errs = append(errs, validateProperty(propertyPath, value, property)...)
+	}
+	return append(errs, unknownProperties(path, object, schema)...)
+}
+
+// validateProperty validates a property value against its schema
+func validateProperty(path string, value interface{}, property propertySchema) []ValidationError {
+	if kind := kindOf(value); kind != property.kind && !(property.kind == kindInteger && kind == "number") {
+		return []ValidationError{{Path: path, Message: fmt.Sprintf("must be %s %s, got %s", article(property.kind), property.kind, kind)}}
+	}
+
+	switch property.kind {
+	case kindInteger:
+		number, err := value.(json.Number).Int64()
+		if err != nil {
+			return []ValidationError{{Path: path, Message: fmt.Sprintf("must be an integer, got %s", value)}}
+		}
+		if property.hasRange && (number < property.minimum || number > property.maximum) {
+			return []ValidationError{{Path: path, Message: fmt.Sprintf("must be between %d and %d, got %d", property.minimum, property.maximum, number)}}
+		}
+	case kindString:
+		if len(property.enum) > 0 && !matchesEnum(value.(string), property.enum, property.fold) {
+			return []ValidationError{{Path: path, Message: fmt.Sprintf("must be one of %s, got %q", strings.Join(property.enum, ", "), value)}}
+		}
+	}
+
+	if property.check != nil {
+		return property.check(path, value)
+	}
+	return nil
+}
+
+// unknownProperties reports properties not described by the schema. Property names are
+// case-sensitive, so a lowercase "url" is reported rather than silently ignored.
+func unknownProperties(path string, object map[string]interface{}, schema []propertySchema) []ValidationError {
+	known := make(map[string]bool, len(schema))
+	for _, property := range schema {
+		known[property.name] = true
+	}
+
+	var names []string
+	for name := range object {
+		if !known[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var errs []ValidationError
+	for _, name := range names {
+		message := "unknown property"
+		for _, property := range schema {
+			if strings.EqualFold(property.name, name) {
+				message = fmt.Sprintf("unknown property, did you mean %s?", property.name)
+				break
+			}
+		}
+		errs = append(errs, ValidationError{Path: strings.TrimPrefix(path+"."+name, "."), Message: message})
+	}
+	return errs
+}
+
+// checkNotEmpty rejects empty strings
+func checkNotEmpty(path string, value interface{}) []ValidationError {
+	if strings.TrimSpace(value.(string)) == "" {
+		return []ValidationError{{Path: path, Message: "must not be empty"}}
+	}
+	return nil
+}
+
+// checkBeaconURL requires an absolute http(s) URL. Macros are replaced by a placeholder
+// first, as they are substituted at generation or request time.
+func checkBeaconURL(path string, value interface{}) []ValidationError {
+	raw := value.(string)
+	if strings.TrimSpace(raw) == "" {
+		return []ValidationError{{Path: path, Message: "must not be empty"}}
+	}
+
+	if errs := checkMacroModifiers(path, value); errs != nil {
+		return errs
+	}
+
+	parsed, err := url.Parse(macroPattern.ReplaceAllString(raw, "macro"))
+	if err != nil {
+		return []ValidationError{{Path: path, Message: fmt.Sprintf("invalid URL: %v", err)}}
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return []ValidationError{{Path: path, Message: fmt.Sprintf("must be an absolute http or https URL, got %q", raw)}}
+	}
+	return nil
+}
+
+// checkMacroModifiers rejects unknown or malformed macro modifiers
+func checkMacroModifiers(path string, value interface{}) []ValidationError {
+	var errs []ValidationError
+	for _, match := range macroPattern.FindAllStringSubmatch(value.(string), -1) {
+		if _, _, err := parseMacroModifiers(match[1]); err != nil {
+			errs = append(errs, ValidationError{Path: path, Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+// checkPartner requires a partner registered in DefaultPartners
+func checkPartner(path string, value interface{}) []ValidationError {
+	if _, exists := DefaultPartners.Lookup(value.(string)); !exists {
+		return []ValidationError{{Path: path, Message: fmt.Sprintf("unknown partner %q, must be one of %s", value, strings.Join(DefaultPartners.Names(), ", "))}}
+	}
+	return nil
+}
+
+// checkContinentFrequencies requires continent codes mapped to percentages
+func checkContinentFrequencies(path string, value interface{}) []ValidationError {
+	var errs []ValidationError
+	for _, continent := range sortedKeys(value.(map[string]interface{})) {
+		continentPath := fmt.Sprintf("%s.%s", path, continent)
+		if !containsString(continents, continent) {
+			errs = append(errs, ValidationError{Path: continentPath, Message: fmt.Sprintf("unknown continent code, must be one of %s", strings.Join(continents, ", "))})
+			continue
+		}
+		errs = append(errs, validateProperty(continentPath, value.(map[string]interface{})[continent],
+			propertySchema{kind: kindInteger, hasRange: true, minimum: 0, maximum: 100})...)
+	}
+	return errs
+}
+
+// checkConditions validates a CONDITIONS object
+func checkConditions(path string, value interface{}) []ValidationError {
+	return validateObject(path, value.(map[string]interface{}), conditionsSchema)
+}
+
+// checkStringMap requires every value of an object to be a string
+func checkStringMap(path string, value interface{}) []ValidationError {
+	var errs []ValidationError
+	object := value.(map[string]interface{})
+	for _, key := range sortedKeys(object) {
+		errs = append(errs, validateProperty(fmt.Sprintf("%s[%q]", path, key), object[key], propertySchema{kind: kindString})...)
+	}
+	return errs
+}
+
+// checkKeyValues requires string values keyed by cookie, header or query names
+func checkKeyValues(path string, value interface{}) []ValidationError {
+	errs := checkStringMap(path, value)
+	for _, key := range sortedKeys(value.(map[string]interface{})) {
+		source, name, found := strings.Cut(key, ":")
+		if !found {
+			continue
+		}
+		keyPath := fmt.Sprintf("%s[%q]", path, key)
+		switch {
+		case !matchesEnum(source, []string{"cookie", "header", "query"}, true):
+			errs = append(errs, ValidationError{Path: keyPath, Message: "key must be a cookie name or start with cookie:, header: or query:"})
+		case name == "":
+			errs = append(errs, ValidationError{Path: keyPath, Message: "key names no " + source})
+		}
+	}
+	return errs
+}
+
+// checkCountryCodes requires two-letter country codes
+func checkCountryCodes(path string, value interface{}) []ValidationError {
+	var errs []ValidationError
+	for i, code := range value.([]interface{}) {
+		codePath := fmt.Sprintf("%s[%d]", path, i)
+		if s, ok := code.(string); !ok || len(s) != 2 {
+			errs = append(errs, ValidationError{Path: codePath, Message: fmt.Sprintf("must be a two-letter country code, got %v", code)})
+		}
+	}
+	return errs
+}
+
+// checkTCFPurposes requires TCF purpose IDs
+func checkTCFPurposes(path string, value interface{}) []ValidationError {
+	var errs []ValidationError
+	for i, purpose := range value.([]interface{}) {
+		errs = append(errs, validateProperty(fmt.Sprintf("%s[%d]", path, i), purpose,
+			propertySchema{kind: kindInteger, hasRange: true, minimum: 1, maximum: 24})...)
+	}
+	return errs
+}
+
+// kindOf names the JSON kind of a decoded value
+func kindOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return kindString
+	case bool:
+		return kindBoolean
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return kindInteger
+		}
+		return "number"
+	case []interface{}:
+		return kindArray
+	case map[string]interface{}:
+		return kindObject
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// article returns the indefinite article for a kind
+func article(kind string) string {
+	if strings.IndexAny(kind[:1], "aeiou") == 0 {
+		return "an"
+	}
+	return "a"
+}
+
+// matchesEnum reports whether value is one of enum
+func matchesEnum(value string, enum []string, fold bool) bool {
+	for _, allowed := range enum {
+		if value == allowed || (fold && strings.EqualFold(value, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of an object in sorted order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonErrorMessage describes a JSON syntax error with its line and column
+func jsonErrorMessage(data []byte, err error) string {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return fmt.Sprintf("invalid JSON: %v", err)
+	}
+	// The offset is just past the offending byte
+	offset := int(syntaxErr.Offset) - 1
+	if offset > len(data) {
+		offset = len(data)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	return fmt.Sprintf("invalid JSON at line %d, column %d: %v", line, column, err)
+}
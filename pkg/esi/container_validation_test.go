@@ -0,0 +1,109 @@
+package esi
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected []ValidationError
+	}{
+		{
+			name:   "valid",
+			config: `{"pixels": [{"ID": "a", "URL": "https://example.com/a.gif?u=~~uu~~", "PCT": 50, "CONDITIONS": {"countries": ["US"], "frequencyPeriod": "day"}}, {"ID": "b", "URL": "https://example.com/b", "METHOD": "post", "BODY": "x=1", "AFTER": "a"}]}`,
+		},
+		{
+			name:   "missing URL",
+			config: `{"pixels": [{"ID": "a", "URL": "https://example.com/a"}, {"ID": "b", "URL": "https://example.com/b"}, {"ID": "c"}]}`,
+			expected: []ValidationError{
+				{Path: "pixels[2].URL", Message: "required property is missing"},
+			},
+		},
+		{
+			name:   "misspelled property",
+			config: `{"pixels": [{"ID": "a", "url": "https://example.com/a"}]}`,
+			expected: []ValidationError{
+				{Path: "pixels[0].URL", Message: "required property is missing"},
+				{Path: "pixels[0].url", Message: "unknown property, did you mean URL?"},
+			},
+		},
+		{
+			name:   "invalid values",
+			config: `{"pixels": [{"ID": "a", "URL": "/relative", "TYPE": "beacon", "PCT": 150, "CAP": 1.5, "REQ": "yes"}]}`,
+			expected: []ValidationError{
+				{Path: "pixels[0].URL", Message: `must be an absolute http or https URL, got "/relative"`},
+				{Path: "pixels[0].TYPE", Message: `must be one of dir, frm, script, img, got "beacon"`},
+				{Path: "pixels[0].REQ", Message: "must be a boolean, got string"},
+				{Path: "pixels[0].PCT", Message: "must be between 0 and 100, got 150"},
+				{Path: "pixels[0].CAP", Message: "must be an integer, got 1.5"},
+			},
+		},
+		{
+			name:   "invalid conditions",
+			config: `{"pixels": [{"ID": "a", "URL": "https://example.com/a", "CONTINENT_FREQ": {"XX": 10, "EU": -1}, "CONDITIONS": {"countries": ["USA"], "keyValues": {"env:x": "1"}, "tcfPurposes": [0], "frequencyPeriod": "week", "sampleRate": 0, "sampleKey": ""}}]}`,
+			expected: []ValidationError{
+				{Path: "pixels[0].CONTINENT_FREQ.EU", Message: "must be between 0 and 100, got -1"},
+				{Path: "pixels[0].CONTINENT_FREQ.XX", Message: "unknown continent code, must be one of AF, AN, AS, EU, NA, OC, SA"},
+				{Path: "pixels[0].CONDITIONS.countries[0]", Message: "must be a two-letter country code, got USA"},
+				{Path: "pixels[0].CONDITIONS.frequencyPeriod", Message: `must be one of session, day, got "week"`},
+				{Path: "pixels[0].CONDITIONS.sampleRate", Message: "must be between 1 and 100, got 0"},
+				{Path: "pixels[0].CONDITIONS.sampleKey", Message: "must not be empty"},
+				{Path: `pixels[0].CONDITIONS.keyValues["env:x"]`, Message: "key must be a cookie name or start with cookie:, header: or query:"},
+				{Path: "pixels[0].CONDITIONS.tcfPurposes[0]", Message: "must be between 1 and 24, got 0"},
+			},
+		},
+		{
+			name:   "references",
+			config: `{"pixels": [{"ID": "a", "URL": "https://example.com/a", "BODY": "x"}, {"ID": "a", "URL": "https://example.com/b"}, {"ID": "f", "TYPE": "frm", "URL": "https://example.com/f"}, {"ID": "c", "URL": "https://example.com/c", "AFTER": "f"}, {"ID": "d", "URL": "https://example.com/d", "AFTER": "z"}]}`,
+			expected: []ValidationError{
+				{Path: "pixels[0].BODY", Message: "GET beacons cannot have a BODY"},
+				{Path: "pixels[1].ID", Message: `duplicate ID "a", first used by pixels[0]`},
+				{Path: "pixels[3].AFTER", Message: `pixel "f" is a frm pixel; only dir pixels can be depended on`},
+				{Path: "pixels[4].AFTER", Message: `unknown pixel ID "z"`},
+			},
+		},
+		{
+			name:   "macro modifiers",
+			config: `{"pixels": [{"ID": "a", "URL": "https://example.com/a?u=~~uu|hash:sha256~~&p=~~dl:qs~p|lower~~", "METHOD": "POST", "BODY": "c=~~c~x|default:a'b~~"}]}`,
+			expected: []ValidationError{
+				{Path: "pixels[0].URL", Message: `invalid macro modifier in ~~dl:qs~p|lower~~: unknown modifier "lower", must be one of default, urlencode, hash`},
+				{Path: "pixels[0].BODY", Message: `invalid macro modifier in ~~c~x|default:a'b~~: default value "a'b" may not contain ' or )`},
+			},
+		},
+		{
+			name:   "partner pixels",
+			config: `{"pixels": [{"ID": "a", "partner": "examplepartner", "partnerParams": {"account": "x"}}, {"ID": "b", "partner": "nobody"}]}`,
+			expected: []ValidationError{
+				{Path: "pixels[1].partner", Message: `unknown partner "nobody", must be one of examplecollector, examplepartner`},
+			},
+		},
+		{
+			name:     "pixels not an array",
+			config:   `{"pixels": {}}`,
+			expected: []ValidationError{{Path: "pixels", Message: "must be an array, got object"}},
+		},
+		{
+			name:     "syntax error",
+			config:   "{\n  \"pixels\": [,]\n}",
+			expected: []ValidationError{{Message: "invalid JSON at line 2, column 14: invalid character ',' looking for beginning of value"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ValidateConfig([]byte(tt.config)))
+		})
+	}
+}
+
+func TestValidateConfig_Examples(t *testing.T) {
+	data, err := os.ReadFile("../../cmd/ESIcontainergenerator/example_advanced.json")
+	require.NoError(t, err)
+	assert.Empty(t, ValidateConfig(data))
+}
@@ -0,0 +1,143 @@
+package esi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DegradationNote is an ESI construct of a template and how it renders without ESI
+type DegradationNote struct {
+	Line    int    `json:"line"`
+	Feature string `json:"feature"` // Element name, or <!--esi --> for comment blocks
+	Effect  string `json:"effect"`
+}
+
+// DegradationReport compares a template processed with ESI to the page a CDN without
+// ESI delivers, where browsers ignore the unknown esi: tags but render their content
+type DegradationReport struct {
+	Processed   string            `json:"processed"`
+	Unprocessed string            `json:"unprocessed"`
+	Diff        string            `json:"diff,omitempty"` // Unified diff from processed to unprocessed, empty when they match
+	Notes       []DegradationNote `json:"notes"`
+}
+
+// Degrades reports whether the page differs without ESI
+func (r *DegradationReport) Degrades() bool {
+	return r.Diff != ""
+}
+
+// Degrade processes a template for a request and renders it as it degrades without ESI:
+// includes are stripped, esi:remove content is kept and <!--esi --> blocks are left as
+// comments. Both outputs are normalized and formatted alike, so the diff only shows what
+// ESI changes.
+func (p *Processor) Degrade(html string, context ProcessContext) (*DegradationReport, error) {
+	processed, err := p.Process(html, context)
+	if err != nil {
+		return nil, err
+	}
+	unprocessed, err := p.unprocessed(html, context)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DegradationReport{
+		Processed:   processed,
+		Unprocessed: unprocessed,
+		Diff:        unifiedDiff("processed", "unprocessed", strings.Split(processed, "\n"), strings.Split(unprocessed, "\n")),
+		Notes:       []DegradationNote{},
+	}
+	if template, err := ParseTemplate(html); err == nil {
+		report.Notes = degradationNotes(template)
+	}
+	return report, nil
+}
+
+// Unprocessed returns a template as a browser renders it from a CDN without ESI: the
+// esi: tags are dropped, their content kept, and <!--esi --> blocks stay comments
+func Unprocessed(html string) (string, error) {
+	template, err := ParseTemplate(html)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	writeUnprocessed(&out, template.Nodes)
+	return out.String(), nil
+}
+
+// writeUnprocessed writes nodes without their ESI tags
+func writeUnprocessed(out *strings.Builder, nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Type {
+		case TextNode, CommentBlockNode:
+			out.WriteString(n.Text)
+		case ElementNode:
+			writeUnprocessed(out, n.Children)
+		}
+	}
+}
+
+// unprocessed renders the template without ESI, normalized and formatted as Process
+// renders the page
+func (p *Processor) unprocessed(html string, context ProcessContext) (string, error) {
+	if !p.needsProcessing(html, context) {
+		return html, nil
+	}
+	output, err := Unprocessed(html)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ESI template: %w", err)
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(output))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	result, err := doc.Html()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate HTML: %w", err)
+	}
+	return formatOutput(result, p.outputMode(context)), nil
+}
+
+// degradationNotes lists how the ESI constructs of a template render without ESI.
+// Elements inside comment blocks are hidden with their block and not listed.
+func degradationNotes(template *Template) []DegradationNote {
+	notes := []DegradationNote{}
+	template.Walk(func(n *Node) bool {
+		if n.Type == CommentBlockNode {
+			notes = append(notes, DegradationNote{Line: n.Line, Feature: commentBlockFeature, Effect: "content hidden in an HTML comment"})
+			return false
+		}
+		if n.Type != ElementNode {
+			return true
+		}
+
+		var effect string
+		switch n.ESIName() {
+		case "include", "inline":
+			src, _ := n.GetAttr("src")
+			effect = "content of " + src + " missing"
+		case "remove":
+			effect = "fallback content shown"
+		case "choose":
+			effect = "every branch shown"
+		case "try":
+			effect = "attempt and except content both shown"
+		case "vars":
+			if strings.Contains(n.TextContent(), "$(") {
+				effect = "variables shown unexpanded"
+			}
+		case "comment", "when", "otherwise", "attempt", "except":
+			// Reported with their choose or try, or empty
+		default:
+			if strings.TrimSpace(n.TextContent()) != "" {
+				effect = "content shown as text"
+			}
+		}
+		if effect != "" {
+			notes = append(notes, DegradationNote{Line: n.Line, Feature: n.Name, Effect: effect})
+		}
+		return true
+	})
+	return notes
+}
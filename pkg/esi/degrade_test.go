@@ -0,0 +1,74 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnprocessed(t *testing.T) {
+	output, err := Unprocessed(`<esi:include src="/header"/><esi:remove><a href="/nav">Nav</a></esi:remove>` +
+		`<!--esi <p>$(HTTP_HOST)</p> --><esi:choose><esi:when test="1==1">yes</esi:when><esi:otherwise>no</esi:otherwise></esi:choose>` +
+		`<esi:comment text="note"/><esi:vars><p>$(HTTP_HOST)</p></esi:vars>`)
+	require.NoError(t, err)
+	assert.Equal(t, `<a href="/nav">Nav</a><!--esi <p>$(HTTP_HOST)</p> -->yesno<p>$(HTTP_HOST)</p>`, output)
+}
+
+func TestProcessor_Degrade(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<nav>Menu</nav>"))
+	}))
+	defer origin.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	template := "<esi:include src=\"/nav\"/>\n<esi:remove><a href=\"/nav\">Nav</a></esi:remove>\n<!--esi <p>Personalized</p> -->\n<p>Footer</p>"
+
+	report, err := processor.Degrade(template, ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Contains(t, report.Processed, "<nav>Menu</nav>")
+	assert.Contains(t, report.Processed, "<p>Personalized</p>")
+	assert.NotContains(t, report.Processed, `<a href="/nav">`)
+
+	assert.Contains(t, report.Unprocessed, `<a href="/nav">Nav</a>`)
+	assert.Contains(t, report.Unprocessed, "<!--esi <p>Personalized</p> -->")
+	assert.Contains(t, report.Unprocessed, "<p>Footer</p>")
+	assert.NotContains(t, report.Unprocessed, "Menu")
+	assert.NotContains(t, report.Unprocessed, "esi:include")
+
+	assert.True(t, report.Degrades())
+	assert.Contains(t, report.Diff, "--- processed\n+++ unprocessed\n")
+	assert.Contains(t, report.Diff, "-<html><head></head><body><nav>Menu</nav>\n")
+	assert.Contains(t, report.Diff, "+<html><head></head><body><a href=\"/nav\">Nav</a>\n")
+
+	assert.Equal(t, []DegradationNote{
+		{Line: 1, Feature: "esi:include", Effect: "content of /nav missing"},
+		{Line: 2, Feature: "esi:remove", Effect: "fallback content shown"},
+		{Line: 3, Feature: "<!--esi -->", Effect: "content hidden in an HTML comment"},
+	}, report.Notes)
+}
+
+func TestProcessor_DegradeNotes(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	report, err := processor.Degrade("<esi:choose><esi:when test=\"1==1\">a</esi:when><esi:otherwise>b</esi:otherwise></esi:choose>\n"+
+		"<esi:try><esi:attempt>x</esi:attempt><esi:except>y</esi:except></esi:try>\n"+
+		"<esi:vars>$(HTTP_HOST)</esi:vars><esi:comment text=\"c\"/>", ProcessContext{})
+	require.NoError(t, err)
+	assert.Equal(t, []DegradationNote{
+		{Line: 1, Feature: "esi:choose", Effect: "every branch shown"},
+		{Line: 2, Feature: "esi:try", Effect: "attempt and except content both shown"},
+		{Line: 3, Feature: "esi:vars", Effect: "variables shown unexpanded"},
+	}, report.Notes)
+}
+
+func TestProcessor_DegradeWithoutESI(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	report, err := processor.Degrade("<p>plain</p>", ProcessContext{})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>plain</p>", report.Processed)
+	assert.Equal(t, report.Processed, report.Unprocessed)
+	assert.False(t, report.Degrades())
+	assert.Empty(t, report.Notes)
+}
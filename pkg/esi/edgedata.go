@@ -0,0 +1,403 @@
+package esi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Edge data connector defaults
+const (
+	DefaultEdgeDataTimeout = time.Second // Time a lookup may take before its default is used
+	DefaultEdgeDataTTL     = time.Minute // Time a looked up value is cached
+	EdgeDataCacheSize      = 10000       // Lookups cached at most
+)
+
+// EdgeDataKeyPlaceholder is replaced by the escaped key in the URL of an HTTP edge data
+// source; URLs without it get the key as their key query parameter
+const EdgeDataKeyPlaceholder = "{key}"
+
+// maxEdgeDataValue limits the bytes read from an HTTP edge data response
+const maxEdgeDataValue = 1 << 20
+
+// EdgeDataSource looks up personalization data by key, like an EdgeKV namespace or an
+// edge dictionary. Keys that are not found are not errors.
+type EdgeDataSource interface {
+	Lookup(ctx context.Context, key string) (value string, found bool, err error)
+}
+
+// EdgeDataConfig configures the edge data read by $(EDGE_DATA{key}) and esi:lookup,
+// from either an HTTP JSON endpoint or a local JSON file
+type EdgeDataConfig struct {
+	// URL is fetched for each key, with EdgeDataKeyPlaceholder replaced by the key. A 404
+	// means the key is not found; JSON strings are returned unquoted and other JSON values
+	// as compact JSON.
+	URL string `json:"url,omitempty"`
+	// File is a JSON object whose members are the keys; dotted keys also reach into
+	// nested objects. The file is read again when it changes.
+	File string `json:"file,omitempty"`
+	// Timeout bounds each lookup; zero selects DefaultEdgeDataTimeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// TTL is how long values, and keys not found, are cached; zero selects
+	// DefaultEdgeDataTTL and negative disables caching
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// ValidateEdgeData checks that edge data is read from either a valid http(s) URL or a
+// readable JSON object file, with a non-negative timeout
+func ValidateEdgeData(config EdgeDataConfig) error {
+	switch {
+	case config.URL != "" && config.File != "":
+		return errors.New("url and file are exclusive")
+	case config.URL != "":
+		u, err := url.Parse(strings.ReplaceAll(config.URL, EdgeDataKeyPlaceholder, "key"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url %q: want an http or https URL", config.URL)
+		}
+	case config.File != "":
+		if _, err := NewFileEdgeData(config.File).(*fileEdgeData).load(); err != nil {
+			return err
+		}
+	}
+	if config.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// httpEdgeData looks keys up with GET requests to a JSON endpoint
+type httpEdgeData struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPEdgeData creates a source fetching keys from rawURL (see EdgeDataConfig.URL)
+// with client, or http.DefaultClient when client is nil
+func NewHTTPEdgeData(rawURL string, client *http.Client) EdgeDataSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpEdgeData{url: rawURL, client: client}
+}
+
+// Lookup fetches the value of key
+func (h *httpEdgeData) Lookup(ctx context.Context, key string) (string, bool, error) {
+	target := h.url
+	if strings.Contains(target, EdgeDataKeyPlaceholder) {
+		target = strings.ReplaceAll(target, EdgeDataKeyPlaceholder, url.PathEscape(key))
+	} else {
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + "key=" + url.QueryEscape(key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", false, fmt.Errorf("edge data lookup of %s: HTTP %d", key, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEdgeDataValue))
+	if err != nil {
+		return "", false, err
+	}
+
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		return string(body), true, nil
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", false, err
+	}
+	formatted, found := formatEdgeDataValue(value)
+	return formatted, found, nil
+}
+
+// fileEdgeData looks keys up in a JSON object file, read again when it changes
+type fileEdgeData struct {
+	path    string
+	data    map[string]interface{}
+	modTime time.Time
+	mutex   sync.Mutex
+}
+
+// NewFileEdgeData creates a source reading keys from the JSON object file at path
+func NewFileEdgeData(path string) EdgeDataSource {
+	return &fileEdgeData{path: path}
+}
+
+// Lookup returns the value of key, a member of the object or a dotted path into it
+func (f *fileEdgeData) Lookup(_ context.Context, key string) (string, bool, error) {
+	data, err := f.load()
+	if err != nil {
+		return "", false, err
+	}
+	value, found := lookupJSONKey(data, key)
+	return value, found, nil
+}
+
+// lookupJSONKey returns the value of key in a JSON object, a member or a dotted path
+// into it, formatted by formatEdgeDataValue. Exact members win over dotted paths.
+func lookupJSONKey(data map[string]interface{}, key string) (string, bool) {
+	if value, exists := data[key]; exists {
+		return formatEdgeDataValue(value)
+	}
+	var value interface{} = data
+	for _, part := range strings.Split(key, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[part]; !ok {
+			return "", false
+		}
+	}
+	return formatEdgeDataValue(value)
+}
+
+// load returns the file's object, reading the file when it changed since last read
+func (f *fileEdgeData) load() (map[string]interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading edge data file: %w", err)
+	}
+	if f.data != nil && info.ModTime().Equal(f.modTime) {
+		return f.data, nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading edge data file: %w", err)
+	}
+	var data map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("parsing edge data file %s: want a JSON object: %w", f.path, err)
+	}
+	f.data, f.modTime = data, info.ModTime()
+	return data, nil
+}
+
+// formatEdgeDataValue returns a JSON value as text: strings as they are, other values
+// as compact JSON. Null is not found.
+func formatEdgeDataValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// edgeDataConnector looks keys up in a source with a timeout, caching the results
+type edgeDataConnector struct {
+	source  EdgeDataSource
+	timeout time.Duration
+	ttl     time.Duration
+	size    int // Entries cached at most
+	entries map[string]edgeDataEntry
+	now     func() time.Time
+	mutex   sync.Mutex
+}
+
+// edgeDataEntry is a cached lookup result
+type edgeDataEntry struct {
+	value   string
+	found   bool
+	expires time.Time
+}
+
+// newEdgeDataConnector creates a connector for source with the timeout and TTL of config
+func newEdgeDataConnector(source EdgeDataSource, config EdgeDataConfig) *edgeDataConnector {
+	connector := &edgeDataConnector{
+		source:  source,
+		timeout: config.Timeout,
+		ttl:     config.TTL,
+		size:    EdgeDataCacheSize,
+		entries: make(map[string]edgeDataEntry),
+		now:     time.Now,
+	}
+	if connector.timeout == 0 {
+		connector.timeout = DefaultEdgeDataTimeout
+	}
+	if connector.ttl == 0 {
+		connector.ttl = DefaultEdgeDataTTL
+	}
+	return connector
+}
+
+// lookup returns the value of key from the cache or the source. Failed lookups are not
+// cached, and expired entries are dropped when read.
+func (c *edgeDataConnector) lookup(key string) (string, bool, error) {
+	now := c.now()
+	c.mutex.Lock()
+	entry, exists := c.entries[key]
+	if exists && !now.Before(entry.expires) {
+		delete(c.entries, key)
+		exists = false
+	}
+	c.mutex.Unlock()
+	if exists {
+		return entry.value, entry.found, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	value, found, err := c.source.Lookup(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+
+	if c.ttl > 0 {
+		c.store(key, edgeDataEntry{value: value, found: found, expires: now.Add(c.ttl)}, now)
+	}
+	return value, found, nil
+}
+
+// store caches entry under key. A full cache first drops its expired entries and, if
+// none has expired, the entry expiring soonest, so keys read once do not pile up.
+func (c *edgeDataConnector) store(key string, entry edgeDataEntry, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.size {
+		var soonest string
+		var soonestExpires time.Time
+		for cached, cachedEntry := range c.entries {
+			if !now.Before(cachedEntry.expires) {
+				delete(c.entries, cached)
+			} else if soonestExpires.IsZero() || cachedEntry.expires.Before(soonestExpires) {
+				soonest, soonestExpires = cached, cachedEntry.expires
+			}
+		}
+		if len(c.entries) >= c.size {
+			delete(c.entries, soonest)
+		}
+	}
+	c.entries[key] = entry
+}
+
+// configuredEdgeData returns the source named by the configuration's EdgeData, or nil.
+// HTTP lookups go through the include transport, so host overrides, origin TLS and
+// faults apply to them too.
+func (p *Processor) configuredEdgeData() EdgeDataSource {
+	switch {
+	case p.config.EdgeData.URL != "":
+		return NewHTTPEdgeData(p.config.EdgeData.URL, &http.Client{Transport: p.faults})
+	case p.config.EdgeData.File != "":
+		return NewFileEdgeData(p.config.EdgeData.File)
+	}
+	return nil
+}
+
+// SetEdgeDataSource plugs in the source $(EDGE_DATA{key}) and esi:lookup read, such as
+// a connector to a real key-value store, with the timeout and TTL of the configuration's
+// EdgeData. A nil source disables edge data.
+func (p *Processor) SetEdgeDataSource(source EdgeDataSource) {
+	if source == nil {
+		p.edgeData.Store(nil)
+		return
+	}
+	p.edgeData.Store(newEdgeDataConnector(source, p.config.EdgeData))
+}
+
+// lookupEdgeData returns the edge data value of key. Keys not found, failed lookups and
+// lookups without a source return false.
+func (p *Processor) lookupEdgeData(key string) (string, bool) {
+	connector := p.edgeData.Load()
+	if connector == nil {
+		if p.debugEnabled() {
+			fmt.Printf("⚠️  Edge data lookup of %s: no edge data source configured\n", key)
+		}
+		return "", false
+	}
+
+	value, found, err := connector.lookup(key)
+	if err != nil {
+		if p.debugEnabled() {
+			fmt.Printf("⚠️  Edge data lookup of %s failed: %v\n", key, err)
+		}
+		return "", false
+	}
+	if p.debugEnabled() {
+		fmt.Printf("🗄️  Edge data %s = %q (found: %t)\n", key, value, found)
+	}
+	return value, found
+}
+
+// edgeData returns the edge data value of key
+func (a *AkamaiExtensions) edgeData(key string) (string, bool) {
+	processor, ok := a.processor.(*Processor)
+	if !ok {
+		return "", false
+	}
+	return processor.lookupEdgeData(key)
+}
+
+// lookup handles an esi:lookup element: the edge data value of its key, or its default
+// when the key is not found or the lookup fails or times out. With a name, the value is
+// assigned to that variable, in the scope of its scope attribute, instead of output.
+func (a *AkamaiExtensions) lookup(s *goquery.Selection, context ProcessContext) string {
+	key, _ := s.Attr("key")
+	key = a.expandVariables(key, context)
+	if key == "" {
+		if a.processor.GetConfig().Debug {
+			fmt.Println("⚠️  esi:lookup missing key attribute")
+		}
+		return ""
+	}
+
+	value, found := a.edgeData(key)
+	if !found {
+		defaultValue, _ := s.Attr("default")
+		value = a.expandVariables(defaultValue, context)
+	}
+
+	if name, _ := s.Attr("name"); name != "" {
+		scope, _ := s.Attr("scope")
+		a.assignVariable(name, value, scope, context)
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,269 @@
+package esi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapEdgeData is an in-memory edge data source counting its lookups
+type mapEdgeData struct {
+	values  map[string]string
+	err     error
+	lookups atomic.Int32
+}
+
+func (m *mapEdgeData) Lookup(_ context.Context, key string) (string, bool, error) {
+	m.lookups.Add(1)
+	if m.err != nil {
+		return "", false, m.err
+	}
+	value, found := m.values[key]
+	return value, found, nil
+}
+
+func TestHTTPEdgeData_Lookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/kv/greeting":
+			w.Write([]byte(`"Hello, \"friend\""`))
+		case "/kv/tier":
+			w.Write([]byte(`{"level": 3, "name": "gold"}`))
+		case "/kv/count":
+			w.Write([]byte(`42`))
+		case "/kv/plain":
+			w.Write([]byte("not json\n"))
+		case "/kv/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := NewHTTPEdgeData(server.URL+"/kv/"+EdgeDataKeyPlaceholder, nil)
+	tests := []struct {
+		key   string
+		value string
+		found bool
+	}{
+		{"greeting", `Hello, "friend"`, true},
+		{"tier", `{"level":3,"name":"gold"}`, true},
+		{"count", "42", true},
+		{"plain", "not json", true},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			value, found, err := source.Lookup(context.Background(), tt.key)
+			require.NoError(t, err)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+
+	_, _, err := source.Lookup(context.Background(), "broken")
+	assert.ErrorContains(t, err, "HTTP 500")
+}
+
+func TestHTTPEdgeData_KeyQueryParameter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"` + r.URL.Query().Get("ns") + ":" + r.URL.Query().Get("key") + `"`))
+	}))
+	defer server.Close()
+
+	value, found, err := NewHTTPEdgeData(server.URL+"/lookup?ns=users", nil).Lookup(context.Background(), "a b")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "users:a b", value)
+}
+
+func TestFileEdgeData_Lookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"banner": "sale", "user.tier": "flat",
+		"user": {"tier": "gold", "points": 1200, "vip": true}, "empty": null}`), 0o644))
+	source := NewFileEdgeData(path)
+
+	tests := []struct {
+		key   string
+		value string
+		found bool
+	}{
+		{"banner", "sale", true},
+		{"user.tier", "flat", true}, // Exact keys win over dotted paths
+		{"user.points", "1200", true},
+		{"user.vip", "true", true},
+		{"user", `{"points":1200,"tier":"gold","vip":true}`, true},
+		{"user.missing", "", false},
+		{"banner.text", "", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			value, found, err := source.Lookup(context.Background(), tt.key)
+			require.NoError(t, err)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+
+	// The file is read again when it changes
+	require.NoError(t, os.WriteFile(path, []byte(`{"banner": "clearance"}`), 0o644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	value, found, err := source.Lookup(context.Background(), "banner")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "clearance", value)
+
+	_, _, err = NewFileEdgeData(filepath.Join(t.TempDir(), "missing.json")).Lookup(context.Background(), "banner")
+	assert.ErrorContains(t, err, "reading edge data file")
+}
+
+func TestEdgeDataConnector_CachesAndTimesOut(t *testing.T) {
+	source := &mapEdgeData{values: map[string]string{"banner": "sale"}}
+	connector := newEdgeDataConnector(source, EdgeDataConfig{TTL: time.Minute})
+	now := time.Now()
+	connector.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		value, found, err := connector.lookup("banner")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "sale", value)
+		_, found, err = connector.lookup("missing")
+		require.NoError(t, err)
+		assert.False(t, found)
+	}
+	assert.EqualValues(t, 2, source.lookups.Load())
+
+	now = now.Add(2 * time.Minute)
+	_, _, err := connector.lookup("banner")
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, source.lookups.Load())
+
+	// A full cache drops its expired entries, then the one expiring soonest
+	connector.size = 2
+	now = now.Add(time.Second)
+	connector.lookup("a")
+	assert.Len(t, connector.entries, 2)
+	assert.NotContains(t, connector.entries, "missing")
+	now = now.Add(time.Second)
+	connector.lookup("b")
+	assert.Len(t, connector.entries, 2)
+	assert.NotContains(t, connector.entries, "banner")
+
+	// Failed lookups are not cached
+	failing := &mapEdgeData{err: errors.New("unavailable")}
+	connector = newEdgeDataConnector(failing, EdgeDataConfig{})
+	for i := 0; i < 2; i++ {
+		_, _, err := connector.lookup("banner")
+		assert.Error(t, err)
+	}
+	assert.EqualValues(t, 2, failing.lookups.Load())
+
+	// A negative TTL disables caching
+	source = &mapEdgeData{values: map[string]string{"banner": "sale"}}
+	connector = newEdgeDataConnector(source, EdgeDataConfig{TTL: -1})
+	connector.lookup("banner")
+	connector.lookup("banner")
+	assert.EqualValues(t, 2, source.lookups.Load())
+
+	// Slow sources time out
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	connector = newEdgeDataConnector(NewHTTPEdgeData(slow.URL, nil), EdgeDataConfig{Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	_, _, err = connector.lookup("banner")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestAkamaiExtensions_EdgeDataVariable(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+	processor.SetEdgeDataSource(&mapEdgeData{values: map[string]string{"banner": "sale", "user-7.tier": "gold"}})
+
+	result, err := processor.Process(`<esi:vars><p>$(EDGE_DATA{banner})|$(EDGE_DATA{missing}|none)|$(EDGE_DATA{user-7.tier})</p></esi:vars>`+
+		`<esi:choose><esi:when test="$(EDGE_DATA{banner}) == 'sale'"><p>on sale</p></esi:when></esi:choose>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>sale|none|gold</p>")
+	assert.Contains(t, result, "<p>on sale</p>")
+
+	// Without a source keys are not found
+	processor.SetEdgeDataSource(nil)
+	result, err = processor.Process(`<esi:vars><p>[$(EDGE_DATA{banner}|default)]</p></esi:vars>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>[default]</p>")
+}
+
+func TestAkamaiExtensions_Lookup(t *testing.T) {
+	source := &mapEdgeData{values: map[string]string{"banner": "sale", "greeting.user-7": "Hi Ada"}}
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+	processor.SetEdgeDataSource(source)
+
+	result, err := processor.Process(`<p><esi:lookup key="banner"/></p>`+
+		`<p><esi:lookup key="missing" default="fallback"/></p>`+
+		`<esi:assign name="uid" value="user-7"/>`+
+		`<esi:lookup key="greeting.$(uid)" name="greeting"/>`+
+		`<esi:vars><p>$(greeting)!</p></esi:vars>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>sale</p>")
+	assert.Contains(t, result, "<p>fallback</p>")
+	assert.Contains(t, result, "<p>Hi Ada!</p>")
+
+	// Failed lookups use the default
+	processor.SetEdgeDataSource(&mapEdgeData{err: errors.New("unavailable")})
+	result, err = processor.Process(`<p><esi:lookup key="banner" default="no banner"/></p>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>no banner</p>")
+}
+
+func TestProcessor_ConfiguredEdgeData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"banner": "from file"}`), 0o644))
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, EdgeData: EdgeDataConfig{File: path}})
+	result, err := processor.Process(`<p><esi:lookup key="banner"/></p>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>from file</p>")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`"from ` + r.URL.Path + `"`))
+	}))
+	defer server.Close()
+	processor = NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, EdgeData: EdgeDataConfig{URL: server.URL + "/kv/{key}"}})
+	result, err = processor.Process(`<p><esi:lookup key="banner"/></p>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>from /kv/banner</p>")
+}
+
+func TestValidateEdgeData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"banner": "sale"}`), 0o644))
+	invalid := filepath.Join(t.TempDir(), "list.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`["banner"]`), 0o644))
+
+	assert.NoError(t, ValidateEdgeData(EdgeDataConfig{}))
+	assert.NoError(t, ValidateEdgeData(EdgeDataConfig{URL: "https://kv.example.com/{key}", Timeout: time.Second}))
+	assert.NoError(t, ValidateEdgeData(EdgeDataConfig{File: path}))
+
+	assert.ErrorContains(t, ValidateEdgeData(EdgeDataConfig{URL: "https://kv.example.com", File: path}), "exclusive")
+	assert.ErrorContains(t, ValidateEdgeData(EdgeDataConfig{URL: "ftp://kv.example.com"}), "invalid url")
+	assert.ErrorContains(t, ValidateEdgeData(EdgeDataConfig{File: invalid}), "want a JSON object")
+	assert.ErrorContains(t, ValidateEdgeData(EdgeDataConfig{File: path + ".missing"}), "reading edge data file")
+	assert.ErrorContains(t, ValidateEdgeData(EdgeDataConfig{URL: "https://kv.example.com", Timeout: -time.Second}), "timeout")
+}
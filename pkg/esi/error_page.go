@@ -0,0 +1,110 @@
+package esi
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Error page defaults
+const (
+	DefaultErrorPageStatus = http.StatusServiceUnavailable
+	DefaultErrorPageBody   = "<!DOCTYPE html><html><head><title>Service Unavailable</title></head>" +
+		"<body><h1>Service Unavailable</h1><p>The page could not be assembled. Please try again later.</p></body></html>"
+)
+
+// ErrorPageConfig is the failure-mode policy of pages whose includes fail: rather than a
+// partially assembled page, the error page is served when more than FailurePercent of
+// the includes fail, or when an include marked required="true" fails
+type ErrorPageConfig struct {
+	// FailurePercent is the share of failed includes, 0 to 100, above which the error
+	// page is served; zero disables the threshold, required includes still apply
+	FailurePercent float64 `json:"failurePercent,omitempty"`
+	// StatusCode of the error page; zero selects DefaultErrorPageStatus
+	StatusCode int `json:"statusCode,omitempty"`
+	// Body of the error page; empty selects DefaultErrorPageBody
+	Body string `json:"body,omitempty"`
+}
+
+// ValidateErrorPage checks that the failure threshold is a percentage and the status
+// code an error status
+func ValidateErrorPage(config ErrorPageConfig) error {
+	if config.FailurePercent < 0 || config.FailurePercent > 100 {
+		return fmt.Errorf("failure percent %g must be between 0 and 100", config.FailurePercent)
+	}
+	if config.StatusCode != 0 && (config.StatusCode < 400 || config.StatusCode > 599) {
+		return fmt.Errorf("status code %d must be a 4xx or 5xx status", config.StatusCode)
+	}
+	return nil
+}
+
+// includeOutcomes counts the includes of a page and its fragments and those that
+// failed, including any alt, for the error page policy
+type includeOutcomes struct {
+	total          int
+	failed         int
+	requiredFailed string // src of the first failed required include
+	mutex          sync.Mutex
+}
+
+// record counts an include, and its failure when failed
+func (o *includeOutcomes) record(src string, failed, required bool) {
+	if o == nil {
+		return
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.total++
+	if !failed {
+		return
+	}
+	o.failed++
+	if required && o.requiredFailed == "" {
+		o.requiredFailed = src
+	}
+}
+
+// errorPageReason returns why the page must be replaced by the error page, or "" when
+// it may be served
+func (p *Processor) errorPageReason(outcomes *includeOutcomes) string {
+	if outcomes == nil {
+		return ""
+	}
+	outcomes.mutex.Lock()
+	defer outcomes.mutex.Unlock()
+
+	if outcomes.requiredFailed != "" {
+		return fmt.Sprintf("required include %s failed", outcomes.requiredFailed)
+	}
+	threshold := p.config.ErrorPage.FailurePercent
+	if threshold > 0 && outcomes.total > 0 {
+		if percent := 100 * float64(outcomes.failed) / float64(outcomes.total); percent > threshold {
+			return fmt.Sprintf("%d of %d includes failed (%.0f%%, threshold %g%%)", outcomes.failed, outcomes.total, percent, threshold)
+		}
+	}
+	return ""
+}
+
+// errorPage records that a page was replaced by the error page for reason and returns
+// the error page, setting its status on the response
+func (p *Processor) errorPage(reason string, context ProcessContext) string {
+	p.stats.errorPages.Add(1)
+
+	status := p.config.ErrorPage.StatusCode
+	if status == 0 {
+		status = DefaultErrorPageStatus
+	}
+	if context.Response != nil {
+		context.Response.StatusCode = status
+		context.Response.ErrorPage = reason
+	}
+	if p.debugEnabled() {
+		fmt.Printf("🛑 Serving the error page (%d): %s%s\n", status, reason, requestIDLabel(context))
+	}
+
+	if p.config.ErrorPage.Body != "" {
+		return p.config.ErrorPage.Body
+	}
+	return DefaultErrorPageBody
+}
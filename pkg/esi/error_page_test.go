@@ -0,0 +1,96 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingOrigin serves fragments, answering 500 for paths under /fail
+func failingOrigin(t *testing.T) *httptest.Server {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func TestProcessor_ErrorPageThreshold(t *testing.T) {
+	origin := failingOrigin(t)
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5,
+		ErrorPage: ErrorPageConfig{FailurePercent: 50, StatusCode: 502, Body: "<h1>Down</h1>"}})
+
+	// Half the includes failing is not more than the threshold
+	response := NewResponseMeta()
+	result, err := processor.Process(`<esi:include src="/a"/><esi:include src="/fail/b" onerror="continue"/>`,
+		ProcessContext{BaseURL: origin.URL, Response: response})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>/a</p>")
+	assert.Zero(t, response.StatusCode)
+	assert.Empty(t, response.ErrorPage)
+
+	// Includes handled by alt succeed
+	response = NewResponseMeta()
+	result, err = processor.Process(`<esi:include src="/a"/><esi:include src="/fail/b" alt="/b"/><esi:include src="/fail/c" onerror="continue"/>`,
+		ProcessContext{BaseURL: origin.URL, Response: response})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>/b</p>")
+	assert.Zero(t, response.StatusCode)
+
+	// Failures in fragments count, and esi:except does not hide them
+	response = NewResponseMeta()
+	result, err = processor.Process(`<esi:include src="/a"/><esi:try><esi:attempt><esi:include src="/fail/b"/></esi:attempt>`+
+		`<esi:except>fallback</esi:except></esi:try><esi:include src="/fail/c" onerror="continue"/>`,
+		ProcessContext{BaseURL: origin.URL, Response: response})
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>Down</h1>", result)
+	assert.Equal(t, 502, response.StatusCode)
+	assert.Equal(t, "2 of 3 includes failed (67%, threshold 50%)", response.ErrorPage)
+	assert.Equal(t, int64(1), processor.GetStats().ErrorPages)
+}
+
+func TestProcessor_ErrorPageRequiredInclude(t *testing.T) {
+	origin := failingOrigin(t)
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+
+	response := NewResponseMeta()
+	result, err := processor.Process(`<esi:include src="/a" required="true"/><esi:include src="/fail/b" onerror="continue"/>`,
+		ProcessContext{BaseURL: origin.URL, Response: response})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>/a</p>")
+	assert.Zero(t, response.StatusCode)
+
+	// Without a threshold, a failed required include still serves the default error page
+	response = NewResponseMeta()
+	result, err = processor.Process(`<esi:include src="/a"/><esi:include src="/fail/cart" required="true" onerror="continue"/>`,
+		ProcessContext{BaseURL: origin.URL, Response: response})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultErrorPageBody, result)
+	assert.Equal(t, DefaultErrorPageStatus, response.StatusCode)
+	assert.Equal(t, "required include /fail/cart failed", response.ErrorPage)
+
+	// Required includes of fragments fail the page too
+	fragments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<esi:include src="` + origin.URL + `/fail/price" required="true"/>`))
+	}))
+	defer fragments.Close()
+	response = NewResponseMeta()
+	_, err = processor.Process(`<esi:include src="`+fragments.URL+`/product"/>`, ProcessContext{BaseURL: origin.URL, Response: response})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultErrorPageStatus, response.StatusCode)
+}
+
+func TestValidateErrorPage(t *testing.T) {
+	assert.NoError(t, ValidateErrorPage(ErrorPageConfig{}))
+	assert.NoError(t, ValidateErrorPage(ErrorPageConfig{FailurePercent: 25, StatusCode: 500}))
+	assert.ErrorContains(t, ValidateErrorPage(ErrorPageConfig{FailurePercent: 120}), "between 0 and 100")
+	assert.ErrorContains(t, ValidateErrorPage(ErrorPageConfig{StatusCode: 200}), "4xx or 5xx")
+}
@@ -0,0 +1,44 @@
+package esi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
+)
+
+// getExperimentVariable returns the variant of the experiment named name the request is
+// assigned to, or "" when no experiment has that name
+func (a *AkamaiExtensions) getExperimentVariable(name string, context ProcessContext) string {
+	config := a.processor.GetConfig()
+	found, exists := experiment.Find(config.Experiments, name)
+	if !exists {
+		if config.Debug {
+			fmt.Printf("⚠️  Unknown experiment: %s\n", name)
+		}
+		return ""
+	}
+
+	variant := found.Assign(experiment.Request{
+		Cookies:  context.Cookies,
+		Headers:  context.Headers,
+		Query:    context.Headers["Query-String"],
+		ClientIP: clientIP(context),
+	})
+	if config.Debug {
+		fmt.Printf("🧪 Experiment %s assigned variant %s\n", name, variant)
+	}
+	return variant
+}
+
+// clientIP returns the client IP of the request, from its X-Forwarded-For or X-Real-IP
+// header
+func clientIP(context ProcessContext) string {
+	if ip, exists := context.Headers["X-Forwarded-For"]; exists {
+		return strings.TrimSpace(strings.Split(ip, ",")[0])
+	}
+	if ip, exists := context.Headers["X-Real-IP"]; exists {
+		return ip
+	}
+	return ""
+}
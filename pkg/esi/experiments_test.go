@@ -0,0 +1,58 @@
+package esi
+
+import (
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAkamaiExtensions_ExperimentVariable(t *testing.T) {
+	checkout := experiment.Experiment{
+		Name:      "checkout",
+		StickyKey: "cookie:uid",
+		Variants:  []experiment.Variant{{Name: "control", Weight: 1}, {Name: "one-page", Weight: 1}},
+	}
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, Experiments: []experiment.Experiment{checkout}})
+	page := `<esi:choose><esi:when test="$(EXPERIMENT{checkout}) == 'one-page'">one page</esi:when>` +
+		`<esi:otherwise>steps</esi:otherwise></esi:choose><esi:vars>[$(EXPERIMENT{checkout})|$(EXPERIMENT{missing})]</esi:vars>`
+
+	result, err := processor.Process(page, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "steps")
+	assert.Contains(t, result, "[control|]")
+
+	for _, uid := range []string{"user-1", "user-2", "user-3", "user-4"} {
+		variant := checkout.Bucket(uid)
+		result, err := processor.Process(page, ProcessContext{Cookies: map[string]string{"uid": uid}})
+		require.NoError(t, err)
+		assert.Contains(t, result, "["+variant+"|]")
+		if variant == "one-page" {
+			assert.Contains(t, result, "one page")
+		} else {
+			assert.Contains(t, result, "steps")
+		}
+	}
+}
+
+func TestAkamaiExtensions_ExperimentStickyKeys(t *testing.T) {
+	banner := experiment.Experiment{
+		Name:      "banner",
+		StickyKey: "ip",
+		Variants:  []experiment.Variant{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}},
+	}
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, Experiments: []experiment.Experiment{banner}})
+
+	result, err := processor.Process(`<esi:vars><p>$(EXPERIMENT{banner})</p></esi:vars>`,
+		ProcessContext{Headers: map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1"}})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>"+banner.Bucket("203.0.113.7")+"</p>")
+
+	banner.StickyKey = "query:uid"
+	processor = NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, Experiments: []experiment.Experiment{banner}})
+	result, err = processor.Process(`<esi:vars><p>$(EXPERIMENT{banner})</p></esi:vars>`,
+		ProcessContext{Headers: map[string]string{"Query-String": "uid=user-9"}})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>"+banner.Bucket("user-9")+"</p>")
+}
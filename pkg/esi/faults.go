@@ -0,0 +1,155 @@
+package esi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaultRule injects faults into the include fetches sent to a host, so try/except,
+// alt and onerror handling can be exercised against misbehaving origins
+type FaultRule struct {
+	Host      string  `json:"host"`      // Host or host:port the rule applies to; "*" matches every host
+	Percent   float64 `json:"percent"`   // Share of matching fetches affected, from 0 to 100
+	LatencyMS int     `json:"latencyMs"` // Delay added before the fetch is sent or failed
+	Drop      bool    `json:"drop"`      // Fail the fetch as if the connection was dropped
+	Status    int     `json:"status"`    // Answer with this status code instead of fetching
+}
+
+// FaultConfig configures fault injection for include fetches
+type FaultConfig struct {
+	Rules []FaultRule `json:"rules,omitempty"`
+	Seed  int64       `json:"seed,omitempty"` // Seeds the random choice of affected fetches; zero seeds from the clock
+}
+
+// ErrFaultDropped is returned for fetches dropped by a fault rule
+var ErrFaultDropped = errors.New("connection dropped by fault injection")
+
+// ValidateFaults checks that fault rules name a host, affect 0 to 100 percent of
+// fetches, delay by a non-negative time and answer with a valid status code
+func ValidateFaults(rules []FaultRule) error {
+	for i, rule := range rules {
+		switch {
+		case rule.Host == "":
+			return fmt.Errorf("fault rule %d: host is required", i+1)
+		case rule.Percent < 0 || rule.Percent > 100:
+			return fmt.Errorf("fault rule %d: percent must be between 0 and 100", i+1)
+		case rule.LatencyMS < 0:
+			return fmt.Errorf("fault rule %d: latencyMs must not be negative", i+1)
+		case rule.Status != 0 && (rule.Status < 100 || rule.Status > 599):
+			return fmt.Errorf("fault rule %d: status must be between 100 and 599", i+1)
+		case rule.Drop && rule.Status != 0:
+			return fmt.Errorf("fault rule %d: drop and status cannot be combined", i+1)
+		}
+	}
+	return nil
+}
+
+// faultTransport applies fault rules before handing fetches to the next transport
+type faultTransport struct {
+	next   http.RoundTripper
+	mutex  sync.Mutex
+	rules  []FaultRule
+	random *rand.Rand
+}
+
+// newFaultTransport creates a fault injecting transport in front of next
+func newFaultTransport(next http.RoundTripper, config FaultConfig) *faultTransport {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &faultTransport{
+		next:   next,
+		rules:  append([]FaultRule(nil), config.Rules...),
+		random: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// setRules replaces the fault rules
+func (t *faultTransport) setRules(rules []FaultRule) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rules = append([]FaultRule(nil), rules...)
+}
+
+// getRules returns a copy of the fault rules
+func (t *faultTransport) getRules() []FaultRule {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]FaultRule{}, t.rules...)
+}
+
+// fault returns the first rule matching host when it affects this fetch
+func (t *faultTransport) fault(host string) (FaultRule, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, rule := range t.rules {
+		if rule.Host != "*" && !strings.EqualFold(rule.Host, host) && !strings.EqualFold(rule.Host, hostname(host)) {
+			continue
+		}
+		return rule, t.random.Float64()*100 < rule.Percent
+	}
+	return FaultRule{}, false
+}
+
+// RoundTrip delays, drops or answers a fetch as the matching rule demands
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule, affected := t.fault(req.URL.Host)
+	if !affected {
+		return t.next.RoundTrip(req)
+	}
+
+	if rule.LatencyMS > 0 {
+		select {
+		case <-time.After(time.Duration(rule.LatencyMS) * time.Millisecond):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	switch {
+	case rule.Drop:
+		return nil, ErrFaultDropped
+	case rule.Status != 0:
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", rule.Status, http.StatusText(rule.Status)),
+			StatusCode: rule.Status,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"X-Fault-Injected": {"true"}},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+	return t.next.RoundTrip(req)
+}
+
+// hostname strips the port from host
+func hostname(host string) string {
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		return host[:i]
+	}
+	return host
+}
+
+// SetFaults replaces the fault rules of include fetches while the processor is serving requests
+func (p *Processor) SetFaults(rules []FaultRule) error {
+	if err := ValidateFaults(rules); err != nil {
+		return err
+	}
+	p.faults.setRules(rules)
+	return nil
+}
+
+// GetFaults returns the fault rules of include fetches
+func (p *Processor) GetFaults() []FaultRule {
+	return p.faults.getRules()
+}
@@ -0,0 +1,84 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaults_Injection(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte("<p>Fragment</p>"))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name    string
+		rule    FaultRule
+		err     string
+		fetched bool
+	}{
+		{name: "drop", rule: FaultRule{Host: host, Percent: 100, Drop: true}, err: ErrFaultDropped.Error()},
+		{name: "status", rule: FaultRule{Host: "127.0.0.1", Percent: 100, Status: 503}, err: "HTTP 503"},
+		{name: "latency only", rule: FaultRule{Host: "*", Percent: 100, LatencyMS: 20}, fetched: true},
+		{name: "other host", rule: FaultRule{Host: "partner.example", Percent: 100, Drop: true}, fetched: true},
+		{name: "never", rule: FaultRule{Host: "*", Percent: 0, Drop: true}, fetched: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetches = 0
+			processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, Faults: FaultConfig{Rules: []FaultRule{tt.rule}}})
+
+			start := time.Now()
+			content, err := processor.fetchInclude("/fragment", ProcessContext{BaseURL: server.URL})
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "<p>Fragment</p>", content)
+			}
+			assert.Equal(t, tt.fetched, fetches == 1)
+			assert.GreaterOrEqual(t, time.Since(start), time.Duration(tt.rule.LatencyMS)*time.Millisecond)
+		})
+	}
+}
+
+func TestFaults_Percent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", Faults: FaultConfig{Seed: 1, Rules: []FaultRule{{Host: "*", Percent: 30, Status: 500}}}})
+	failed := 0
+	for i := 0; i < 200; i++ {
+		if _, err := processor.fetchInclude("/fragment", ProcessContext{BaseURL: server.URL}); err != nil {
+			failed++
+		}
+	}
+	assert.InDelta(t, 60, failed, 25)
+}
+
+func TestFaults_SetFaults(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai"})
+	assert.Empty(t, processor.GetFaults())
+
+	rules := []FaultRule{{Host: "origin.example", Percent: 10, LatencyMS: 500}}
+	require.NoError(t, processor.SetFaults(rules))
+	assert.Equal(t, rules, processor.GetFaults())
+
+	assert.ErrorContains(t, processor.SetFaults([]FaultRule{{Percent: 10}}), "host is required")
+	assert.ErrorContains(t, processor.SetFaults([]FaultRule{{Host: "*", Percent: 101}}), "percent")
+	assert.ErrorContains(t, processor.SetFaults([]FaultRule{{Host: "*", Status: 42}}), "status")
+	assert.ErrorContains(t, processor.SetFaults([]FaultRule{{Host: "*", Drop: true, Status: 500}}), "cannot be combined")
+	assert.Equal(t, rules, processor.GetFaults(), "invalid rules are not applied")
+}
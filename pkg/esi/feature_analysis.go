@@ -0,0 +1,71 @@
+package esi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FeatureAnalysis is the ESI features a template uses and which of the analyzed modes
+// process each of them
+type FeatureAnalysis struct {
+	Modes    []string       `json:"modes"`
+	Features []FeatureUsage `json:"features"`
+}
+
+// AnalyzeFeatures reports the ESI elements, comment blocks and variables a template
+// uses and whether each mode processes them. Nothing is fetched or processed, so it
+// is cheap enough to run on every template change.
+func AnalyzeFeatures(template string, modes []string) *FeatureAnalysis {
+	return &FeatureAnalysis{Modes: modes, Features: featureUsage(template, modes)}
+}
+
+// Unsupported returns the features a mode does not process, in feature order
+func (a *FeatureAnalysis) Unsupported(mode string) []string {
+	var unsupported []string
+	for _, usage := range a.Features {
+		if !usage.Supported[mode] {
+			unsupported = append(unsupported, usage.Feature)
+		}
+	}
+	return unsupported
+}
+
+// UnsupportedModes returns the analyzed modes that do not process a feature usage, in
+// the order of the analysis
+func (a *FeatureAnalysis) UnsupportedModes(usage FeatureUsage) []string {
+	var unsupported []string
+	for _, mode := range a.Modes {
+		if !usage.Supported[mode] {
+			unsupported = append(unsupported, mode)
+		}
+	}
+	return unsupported
+}
+
+// Report renders a line per feature with where it is used and the modes that do not
+// process it, such as "uses esi:assign (lines 3, 7) — unsupported in fastly, w3c"
+func (a *FeatureAnalysis) Report() string {
+	if len(a.Features) == 0 {
+		return "No ESI features used\n"
+	}
+
+	var report strings.Builder
+	for _, usage := range a.Features {
+		lines := make([]string, len(usage.Lines))
+		for i, line := range usage.Lines {
+			lines[i] = fmt.Sprint(line)
+		}
+		label := "line"
+		if len(lines) > 1 {
+			label = "lines"
+		}
+
+		fmt.Fprintf(&report, "uses %s (%s %s) — ", usage.Feature, label, strings.Join(lines, ", "))
+		if unsupported := a.UnsupportedModes(usage); len(unsupported) > 0 {
+			fmt.Fprintf(&report, "unsupported in %s\n", strings.Join(unsupported, ", "))
+		} else {
+			report.WriteString("supported in all modes\n")
+		}
+	}
+	return report.String()
+}
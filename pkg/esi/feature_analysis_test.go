@@ -0,0 +1,39 @@
+package esi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeFeatures(t *testing.T) {
+	template := "<esi:assign name=\"page\" value=\"'home'\" />\n" +
+		"<esi:include src=\"/header\" />\n" +
+		"<!--esi <p>$(page)</p> -->\n" +
+		"<esi:assign name=\"a\" value=\"1\" /><esi:assign name=\"b\" value=\"2\" />\n" +
+		"<p>$(HTTP_HOST) $(a)</p>"
+
+	analysis := AnalyzeFeatures(template, []string{"fastly", "akamai", "w3c"})
+	assert.Equal(t, []FeatureUsage{
+		{Feature: "$(variables)", Count: 3, Lines: []int{3, 5}, Supported: map[string]bool{"fastly": false, "akamai": true, "w3c": true}},
+		{Feature: "<!--esi -->", Count: 1, Lines: []int{3}, Supported: map[string]bool{"fastly": false, "akamai": true, "w3c": true}},
+		{Feature: "esi:assign", Count: 3, Lines: []int{1, 4}, Supported: map[string]bool{"fastly": false, "akamai": true, "w3c": false}},
+		{Feature: "esi:include", Count: 1, Lines: []int{2}, Supported: map[string]bool{"fastly": true, "akamai": true, "w3c": true}},
+	}, analysis.Features)
+
+	assert.Equal(t, []string{"$(variables)", "<!--esi -->", "esi:assign"}, analysis.Unsupported("fastly"))
+	assert.Empty(t, analysis.Unsupported("akamai"))
+	assert.Equal(t, []string{"fastly", "w3c"}, analysis.UnsupportedModes(analysis.Features[2]))
+
+	assert.Equal(t, "uses $(variables) (lines 3, 5) — unsupported in fastly\n"+
+		"uses <!--esi --> (line 3) — unsupported in fastly\n"+
+		"uses esi:assign (lines 1, 4) — unsupported in fastly, w3c\n"+
+		"uses esi:include (line 2) — supported in all modes\n", analysis.Report())
+}
+
+func TestAnalyzeFeatures_NoFeatures(t *testing.T) {
+	analysis := AnalyzeFeatures("<p>plain</p>", []string{"fastly"})
+	assert.Empty(t, analysis.Features)
+	assert.Empty(t, analysis.Unsupported("fastly"))
+	assert.Equal(t, "No ESI features used\n", analysis.Report())
+}
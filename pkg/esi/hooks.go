@@ -0,0 +1,161 @@
+package esi
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIncludeVetoed is returned by BeforeInclude hooks to skip an include. A vetoed
+// include fails like an unreachable one, so its alt and onerror handling applies.
+var ErrIncludeVetoed = errors.New("include vetoed by hook")
+
+// BeforeProcessHook runs before a document is processed. It may change the context,
+// for example to stamp an A/B bucket header, and returns the HTML to process.
+// An error stops processing.
+type BeforeProcessHook func(html string, context *ProcessContext) (string, error)
+
+// BeforeIncludeHook runs before an include is fetched, alt URLs included. It may
+// rewrite the request's URL, method, body or headers, and vetoes the include by
+// returning an error such as ErrIncludeVetoed.
+type BeforeIncludeHook func(include *IncludeRequest, context ProcessContext) error
+
+// AfterIncludeHook runs after an include was fetched or read from the cache and
+// returns the content to insert. An error fails the include.
+type AfterIncludeHook func(include IncludeRequest, content string, context ProcessContext) (string, error)
+
+// AfterProcessHook runs after a document was processed and returns the output.
+// An error fails processing.
+type AfterProcessHook func(result string, context ProcessContext) (string, error)
+
+// Run is the outcome of processing a page, passed to the AfterRun hooks
+type Run struct {
+	Start time.Time
+	Mode  string
+	// TemplateHash is the hex SHA-256 of the page as passed to Process
+	TemplateHash string
+	Duration     time.Duration
+	// Includes counts the includes of the page and its fragments, failed ones included
+	Includes       int
+	FailedIncludes int
+	// Err is the error processing failed with, if it did
+	Err error
+}
+
+// AfterRunHook runs after each page is processed, whether or not processing failed,
+// for example to record processing history. It cannot change the output.
+type AfterRunHook func(run Run)
+
+// hooks are the lifecycle callbacks registered on a processor, run in registration order
+type hooks struct {
+	beforeProcess []BeforeProcessHook
+	beforeInclude []BeforeIncludeHook
+	afterInclude  []AfterIncludeHook
+	afterProcess  []AfterProcessHook
+	afterRun      []AfterRunHook
+}
+
+// OnBeforeProcess registers a hook run before each document is processed
+func (p *Processor) OnBeforeProcess(hook BeforeProcessHook) {
+	p.hooksMutex.Lock()
+	defer p.hooksMutex.Unlock()
+	p.hooks.beforeProcess = append(p.hooks.beforeProcess, hook)
+}
+
+// OnBeforeInclude registers a hook run before each include is fetched
+func (p *Processor) OnBeforeInclude(hook BeforeIncludeHook) {
+	p.hooksMutex.Lock()
+	defer p.hooksMutex.Unlock()
+	p.hooks.beforeInclude = append(p.hooks.beforeInclude, hook)
+}
+
+// OnAfterInclude registers a hook run on the content of each include
+func (p *Processor) OnAfterInclude(hook AfterIncludeHook) {
+	p.hooksMutex.Lock()
+	defer p.hooksMutex.Unlock()
+	p.hooks.afterInclude = append(p.hooks.afterInclude, hook)
+}
+
+// OnAfterProcess registers a hook run on the output of each processed document
+func (p *Processor) OnAfterProcess(hook AfterProcessHook) {
+	p.hooksMutex.Lock()
+	defer p.hooksMutex.Unlock()
+	p.hooks.afterProcess = append(p.hooks.afterProcess, hook)
+}
+
+// OnAfterRun registers a hook run with the outcome of each processed page
+func (p *Processor) OnAfterRun(hook AfterRunHook) {
+	p.hooksMutex.Lock()
+	defer p.hooksMutex.Unlock()
+	p.hooks.afterRun = append(p.hooks.afterRun, hook)
+}
+
+// registeredHooks returns the hooks registered so far; hooks added while a request is
+// processed apply from the next request on
+func (p *Processor) registeredHooks() hooks {
+	p.hooksMutex.RLock()
+	defer p.hooksMutex.RUnlock()
+	return p.hooks
+}
+
+// runBeforeProcess runs the BeforeProcess hooks in order
+func (p *Processor) runBeforeProcess(html string, context *ProcessContext) (string, error) {
+	for _, hook := range p.registeredHooks().beforeProcess {
+		var err error
+		if html, err = hook(html, context); err != nil {
+			return html, fmt.Errorf("before process hook: %w", err)
+		}
+	}
+	return html, nil
+}
+
+// runBeforeInclude runs the BeforeInclude hooks in order, stopping at the first veto
+func (p *Processor) runBeforeInclude(include *IncludeRequest, context ProcessContext) error {
+	for _, hook := range p.registeredHooks().beforeInclude {
+		if err := hook(include, context); err != nil {
+			return fmt.Errorf("include %s: %w", include.URL, err)
+		}
+	}
+	return nil
+}
+
+// runAfterInclude runs the AfterInclude hooks in order, each on the previous one's content
+func (p *Processor) runAfterInclude(include IncludeRequest, content string, context ProcessContext) (string, error) {
+	for _, hook := range p.registeredHooks().afterInclude {
+		var err error
+		if content, err = hook(include, content, context); err != nil {
+			return "", fmt.Errorf("after include hook for %s: %w", include.URL, err)
+		}
+	}
+	return content, nil
+}
+
+// runAfterProcess runs the AfterProcess hooks in order, each on the previous one's output
+func (p *Processor) runAfterProcess(result string, context ProcessContext) (string, error) {
+	for _, hook := range p.registeredHooks().afterProcess {
+		var err error
+		if result, err = hook(result, context); err != nil {
+			return result, fmt.Errorf("after process hook: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// runAfterRun runs the AfterRun hooks on the outcome of processing page since start
+func (p *Processor) runAfterRun(hooks []AfterRunHook, page string, start time.Time, outcomes *includeOutcomes, err error) {
+	run := Run{
+		Start:        start,
+		Mode:         p.config.Mode,
+		TemplateHash: fmt.Sprintf("%x", sha256.Sum256([]byte(page))),
+		Duration:     time.Since(start),
+		Err:          err,
+	}
+	outcomes.mutex.Lock()
+	run.Includes, run.FailedIncludes = outcomes.total, outcomes.failed
+	outcomes.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook(run)
+	}
+}
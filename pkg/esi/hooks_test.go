@@ -0,0 +1,123 @@
+package esi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks_Lifecycle(t *testing.T) {
+	var buckets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buckets = append(buckets, r.Header.Get("X-AB-Bucket"))
+		w.Write([]byte("<span>" + r.URL.Path + "</span>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	var order []string
+	processor.OnBeforeProcess(func(html string, context *ProcessContext) (string, error) {
+		order = append(order, "before process")
+		context.Headers = map[string]string{"X-AB-Bucket": "b"}
+		return html, nil
+	})
+	processor.OnBeforeInclude(func(include *IncludeRequest, context ProcessContext) error {
+		order = append(order, "before include")
+		include.URL = strings.Replace(include.URL, "/v1/", "/v2/", 1)
+		return nil
+	})
+	processor.OnAfterInclude(func(include IncludeRequest, content string, context ProcessContext) (string, error) {
+		order = append(order, "after include")
+		return strings.ToUpper(content), nil
+	})
+	processor.OnAfterProcess(func(result string, context ProcessContext) (string, error) {
+		order = append(order, "after process")
+		return result + "<!-- bucket " + context.Headers["X-AB-Bucket"] + " -->", nil
+	})
+
+	result, err := processor.Process(`<esi:include src="`+server.URL+`/v1/header"/>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<span>/V2/HEADER</span>", "tags are lowercased when the page is parsed")
+	assert.Contains(t, result, "<!-- bucket b -->")
+	assert.Equal(t, []string{"b"}, buckets)
+	assert.Equal(t, []string{"before process", "before include", "after include", "after process"}, order)
+}
+
+func TestHooks_VetoInclude(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<span>" + r.URL.Path + "</span>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	processor.OnBeforeInclude(func(include *IncludeRequest, context ProcessContext) error {
+		if strings.Contains(include.URL, "/blocked") {
+			return ErrIncludeVetoed
+		}
+		return nil
+	})
+
+	result, err := processor.Process(`<esi:include src="`+server.URL+`/blocked" alt="`+server.URL+`/fallback"/>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.NotContains(t, result, "/blocked")
+	assert.Contains(t, result, "<span>/fallback</span>")
+
+	result, err = processor.Process(`<esi:try><esi:attempt><esi:include src="`+server.URL+`/blocked"/></esi:attempt>`+
+		`<esi:except>offline</esi:except></esi:try>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.NotContains(t, result, "/blocked")
+}
+
+func TestHooks_Errors(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	processor.OnBeforeProcess(func(html string, context *ProcessContext) (string, error) {
+		return html, errors.New("rejected")
+	})
+
+	_, err := processor.Process("<p>a</p>", ProcessContext{})
+	assert.ErrorContains(t, err, "before process hook: rejected")
+	assert.Equal(t, int64(1), processor.GetStats().Errors)
+}
+
+func TestHooks_AfterRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/nested":
+			w.Write([]byte("nested"))
+		default:
+			w.Write([]byte(`<esi:include src="/nested"/>`))
+		}
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, BaseURL: server.URL})
+	var runs []Run
+	processor.OnAfterRun(func(run Run) { runs = append(runs, run) })
+
+	page := `<esi:include src="/a"/><esi:include src="/missing" onerror="continue"/>`
+	_, err := processor.Process(page, ProcessContext{})
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, "akamai", runs[0].Mode)
+	assert.Len(t, runs[0].TemplateHash, 64)
+	assert.Equal(t, 3, runs[0].Includes)
+	assert.Equal(t, 1, runs[0].FailedIncludes)
+	assert.NoError(t, runs[0].Err)
+	assert.False(t, runs[0].Start.IsZero())
+
+	processor.OnBeforeProcess(func(html string, context *ProcessContext) (string, error) {
+		return html, errors.New("rejected")
+	})
+	_, err = processor.Process(page, ProcessContext{})
+	require.Error(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, runs[0].TemplateHash, runs[1].TemplateHash)
+	assert.ErrorContains(t, runs[1].Err, "rejected")
+}
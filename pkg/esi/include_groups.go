@@ -0,0 +1,84 @@
+package esi
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// parallel executes an esi:parallel group. The includes directly in the group, and the
+// esi:sequential groups directly in it, are fetched concurrently before the group is
+// executed; the includes of a sequential group are fetched one after the other, each
+// once the one before it completed. The group is then executed in document order as
+// usual, so output, assignments and esi:try handling are unaffected. Variables in
+// prefetched includes are expanded as they stand at the start of the group, and
+// includes nested deeper are fetched when they are reached.
+func (w *walker) parallel(out *bytes.Buffer, n *Node, expand bool) {
+	remaining := w.p.config.MaxIncludes - *w.includes
+	var tasks [][]*Node
+	for _, c := range n.Children {
+		if c.Type != ElementNode {
+			continue
+		}
+		var task []*Node
+		switch c.ESIName() {
+		case "include":
+			task = []*Node{c}
+		case "sequential":
+			for _, include := range c.Children {
+				if include.Type == ElementNode && include.ESIName() == "include" {
+					task = append(task, include)
+				}
+			}
+		}
+
+		// Includes beyond MaxIncludes are never fetched
+		if len(task) > remaining {
+			task = task[:remaining]
+		}
+		if len(task) > 0 {
+			tasks = append(tasks, task)
+			remaining -= len(task)
+		}
+	}
+
+	if w.p.debugEnabled() && len(tasks) > 0 {
+		fmt.Printf("⚡ Fetching %d include groups in parallel at line %d\n", len(tasks), n.Line)
+	}
+	w.prefetch(tasks, expand)
+	w.walk(out, n.Children, expand)
+}
+
+// prefetch fetches tasks concurrently, the includes of each task in order, and keeps
+// their results for the walk. Each task counts its includes in a response of its own,
+// added to the page's once all are done.
+func (w *walker) prefetch(tasks [][]*Node, expand bool) {
+	results := make([][]includeResult, len(tasks))
+	responses := make([]*ResponseMeta, len(tasks))
+
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		context := w.context
+		responses[i] = NewResponseMeta()
+		context.Response = responses[i]
+
+		wg.Add(1)
+		go func(i int, task []*Node, context ProcessContext) {
+			defer wg.Done()
+			for _, n := range task {
+				results[i] = append(results[i], w.fetch(n, expand, context))
+			}
+		}(i, task, context)
+	}
+	wg.Wait()
+
+	if w.prefetched == nil {
+		w.prefetched = make(map[*Node]includeResult)
+	}
+	for i, task := range tasks {
+		w.context.Response.addIncludes(responses[i])
+		for j, n := range task {
+			w.prefetched[n] = results[i][j]
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// barrierOrigin serves fragments that report whether the requests for the paths in
+// together arrived while each other was in flight
+func barrierOrigin(t *testing.T, together ...string) *httptest.Server {
+	var mutex sync.Mutex
+	arrived := 0
+	all := make(chan struct{})
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		for _, path := range together {
+			if path != name {
+				continue
+			}
+			mutex.Lock()
+			if arrived++; arrived == len(together) {
+				close(all)
+			}
+			mutex.Unlock()
+
+			select {
+			case <-all:
+				w.Write([]byte("<p>" + name + ":concurrent</p>"))
+			case <-time.After(300 * time.Millisecond):
+				w.Write([]byte("<p>" + name + ":alone</p>"))
+			}
+			return
+		}
+		w.Write([]byte("<p>" + name + "</p>"))
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func TestProcessor_ParallelIncludes(t *testing.T) {
+	origin := barrierOrigin(t, "a", "b", "c")
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+
+	response := NewResponseMeta()
+	result, err := processor.Process(`<esi:parallel><esi:include src="/a"/><esi:assign name="x" value="1"/>`+
+		`<esi:include src="/b"/><esi:vars><i>$(x)</i></esi:vars><esi:include src="/c"/></esi:parallel><esi:include src="/d"/>`,
+		ProcessContext{BaseURL: origin.URL, Response: response})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>a:concurrent</p><p>b:concurrent</p><i>1</i><p>c:concurrent</p><p>d</p>")
+	assert.Equal(t, 4, response.Includes)
+
+	// Without a group the same includes are fetched one at a time: /a gives up waiting
+	origin = barrierOrigin(t, "a", "b")
+	result, err = processor.Process(`<esi:include src="/a"/><esi:include src="/b"/>`, ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>a:alone</p>")
+}
+
+func TestProcessor_SequentialGroupInParallel(t *testing.T) {
+	var authenticated atomic.Bool
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			time.Sleep(50 * time.Millisecond)
+			authenticated.Store(true)
+			w.Write([]byte("<p>auth</p>"))
+		case "/profile":
+			if authenticated.Load() {
+				w.Write([]byte("<p>profile:member</p>"))
+			} else {
+				w.Write([]byte("<p>profile:anonymous</p>"))
+			}
+		default:
+			w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+		}
+	}))
+	defer origin.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	result, err := processor.Process(`<esi:parallel><esi:include src="/news"/>`+
+		`<esi:sequential><esi:include src="/auth"/><esi:include src="/profile"/></esi:sequential></esi:parallel>`,
+		ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>/news</p><p>auth</p><p>profile:member</p>")
+}
+
+func TestProcessor_ParallelIncludeFailures(t *testing.T) {
+	var requests atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if strings.HasPrefix(r.URL.Path, "/fail") {
+			w.Header().Set("X-Reason", "down")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+	}))
+	defer origin.Close()
+
+	// Failures fall back to alt, onerror and esi:try handling as they are reached
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	result, err := processor.Process(`<esi:try><esi:attempt><esi:parallel>`+
+		`<esi:include src="/fail/a" alt="/alt"/><esi:vars><i>$(LAST_INCLUDE_STATUS)</i></esi:vars>`+
+		`<esi:include src="/fail/b" onerror="continue"/><esi:vars><i>$(INCLUDE_HDR{X-Reason})</i></esi:vars>`+
+		`</esi:parallel></esi:attempt><esi:except>fallback</esi:except></esi:try>`,
+		ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>/alt</p><i>200</i><i>down</i>")
+	assert.NotContains(t, result, "fallback")
+
+	result, err = processor.Process(`<esi:try><esi:attempt><esi:parallel><esi:include src="/a"/><esi:include src="/fail/b"/>`+
+		`</esi:parallel></esi:attempt><esi:except>fallback</esi:except></esi:try>`, ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Contains(t, result, "fallback")
+
+	// Includes beyond MaxIncludes are not fetched
+	requests.Store(0)
+	processor = NewProcessor(Config{Mode: "akamai", MaxIncludes: 2, MaxDepth: 5})
+	_, err = processor.Process(`<esi:parallel><esi:include src="/a"/><esi:include src="/b"/><esi:include src="/c"/></esi:parallel>`,
+		ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, requests.Load())
+}
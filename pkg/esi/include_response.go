@@ -0,0 +1,38 @@
+package esi
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// includeResponse is the response of an include: its status and headers, exposed to
+// the markup after it as $(LAST_INCLUDE_STATUS) and $(INCLUDE_HDR{name})
+type includeResponse struct {
+	status int // Zero when the include got no response
+	header http.Header
+}
+
+// record sets the status and headers of the response
+func (r *includeResponse) record(status int, header http.Header) {
+	if r == nil {
+		return
+	}
+	r.status, r.header = status, header
+}
+
+// getIncludeVariable returns the status, or the header named key, of the latest
+// include of the page or fragment being executed. Both are empty before the first
+// include and when it got no response.
+func (a *AkamaiExtensions) getIncludeVariable(varName, key string, context ProcessContext) string {
+	response := context.lastInclude
+	if response == nil || response.status == 0 {
+		return ""
+	}
+	if varName == "LAST_INCLUDE_STATUS" {
+		return strconv.Itoa(response.status)
+	}
+	if key == "" {
+		return ""
+	}
+	return response.header.Get(key)
+}
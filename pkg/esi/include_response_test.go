@@ -0,0 +1,71 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAkamaiExtensions_IncludeResponseVariables(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/promo":
+			w.Header().Set("X-Fragment-Version", "2")
+			w.Write([]byte("<p>Promo</p>"))
+		default:
+			w.Header().Set("X-Reason", "maintenance")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer origin.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Cache: CacheConfig{Enabled: true, TTL: 60}})
+	template := `<esi:vars><p>[$(LAST_INCLUDE_STATUS|none)]</p></esi:vars>` +
+		`<esi:include src="/promo"/>` +
+		`<esi:choose><esi:when test="$(INCLUDE_HDR{X-Fragment-Version}) == '2'"><p>v2</p></esi:when>` +
+		`<esi:otherwise><p>v1</p></esi:otherwise></esi:choose>` +
+		`<esi:vars><p>[$(LAST_INCLUDE_STATUS)]</p></esi:vars>` +
+		`<esi:include src="/down" onerror="continue"/>` +
+		`<esi:vars><p>[$(LAST_INCLUDE_STATUS)|$(INCLUDE_HDR{x-reason})|$(INCLUDE_HDR{X-Fragment-Version}|unset)]</p></esi:vars>`
+
+	// The second run reads the promo fragment, and its headers, from the cache
+	for i := 0; i < 2; i++ {
+		result, err := processor.Process(template, ProcessContext{BaseURL: origin.URL})
+		require.NoError(t, err)
+		assert.Contains(t, result, "<p>[none]</p>")
+		assert.Contains(t, result, "<p>v2</p>")
+		assert.Contains(t, result, "<p>[200]</p>")
+		assert.Contains(t, result, "<p>[503|maintenance|unset]</p>")
+	}
+	assert.Equal(t, int64(1), processor.GetStats().CacheHits)
+
+	// Alt responses replace the failed include's, and includes without a response clear it
+	result, err := processor.Process(`<esi:include src="/down" alt="/promo"/><esi:vars><p>[$(LAST_INCLUDE_STATUS)]</p></esi:vars>`+
+		`<esi:include src="http://127.0.0.1:1/x" onerror="continue"/><esi:vars><p>[$(LAST_INCLUDE_STATUS|none)]</p></esi:vars>`,
+		ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>[200]</p>")
+	assert.Contains(t, result, "<p>[none]</p>")
+}
+
+func TestAkamaiExtensions_IncludeResponseInFragments(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Fragment", r.URL.Path)
+		if r.URL.Path == "/outer" {
+			w.Write([]byte(`<esi:include src="/inner"/><esi:vars><i>$(INCLUDE_HDR{X-Fragment})</i></esi:vars>`))
+			return
+		}
+		w.Write([]byte("inner"))
+	}))
+	defer origin.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	result, err := processor.Process(`<esi:include src="/outer"/><esi:vars><b>$(INCLUDE_HDR{X-Fragment})</b></esi:vars>`,
+		ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<i>/inner</i>")
+	assert.Contains(t, result, "<b>/outer</b>")
+}
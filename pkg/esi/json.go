@@ -0,0 +1,287 @@
+package esi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// JSONIncludeKey is the key of include directives in JSON documents. An object whose
+// only key it is stands for the JSON fetched from its src:
+//
+//	{"$esi:include": "/api/price?id=42"}
+//	{"$esi:include": {"src": "/api/price?id=42", "alt": "/api/price-cache?id=42", "default": 0}}
+const JSONIncludeKey = "$esi:include"
+
+// ErrInvalidJSON is returned by ProcessJSON for documents that are not valid JSON
+var ErrInvalidJSON = errors.New("invalid JSON document")
+
+// jsonInclude is an include directive of a JSON document
+type jsonInclude struct {
+	Src     string `json:"src"`
+	Alt     string `json:"alt"`
+	OnError string `json:"onerror"`
+	// Default is the raw JSON used when src and alt fail, if given
+	Default json.RawMessage `json:"default"`
+}
+
+// jsonMember is a member of a JSON object
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// jsonObject is a JSON object whose members keep the order of the document
+type jsonObject []jsonMember
+
+// jsonAssembly assembles one JSON document, counting its includes across fragments
+type jsonAssembly struct {
+	p        *Processor
+	includes int
+}
+
+// ProcessJSON assembles a JSON document at the edge (experimental). Every include
+// directive (see JSONIncludeKey) is replaced by the JSON fetched from its src, itself
+// assembled one level deeper. A failed include falls back to its alt, then to its
+// default; with onerror "continue" it becomes null, and otherwise the document fails.
+// Variables in src and alt are expanded, fragments are cached like HTML fragments and
+// the include hooks run, but fragments are never sanitized. Member order is kept and
+// the result is compact.
+func (p *Processor) ProcessJSON(data []byte, context ProcessContext) ([]byte, error) {
+	startTime := time.Now()
+	if context.scope == nil {
+		context.scope = newPageScope(context.Variables)
+	}
+	if context.budget == nil {
+		context.budget = p.newBudget(startTime)
+	}
+
+	p.stats.count(&p.stats.requests, func(slot *windowSlot) { slot.requests++ })
+
+	value, err := decodeJSON(data)
+	if err != nil {
+		p.incrementErrors()
+		return data, fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	assembly := &jsonAssembly{p: p}
+	value, err = assembly.assemble(value, context)
+	if err != nil {
+		p.incrementErrors()
+		return data, err
+	}
+
+	var out bytes.Buffer
+	if err := encodeJSON(&out, value); err != nil {
+		p.incrementErrors()
+		return data, err
+	}
+	p.recordProcessing(time.Since(startTime))
+	return out.Bytes(), nil
+}
+
+// assemble replaces the include directives in value
+func (a *jsonAssembly) assemble(value interface{}, context ProcessContext) (interface{}, error) {
+	switch v := value.(type) {
+	case jsonObject:
+		if len(v) == 1 && v[0].key == JSONIncludeKey {
+			return a.include(v[0].value, context)
+		}
+		for i := range v {
+			assembled, err := a.assemble(v[i].value, context)
+			if err != nil {
+				return nil, err
+			}
+			v[i].value = assembled
+		}
+	case []interface{}:
+		for i := range v {
+			assembled, err := a.assemble(v[i], context)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = assembled
+		}
+	}
+	return value, nil
+}
+
+// include resolves an include directive whose value is a src string or an object
+func (a *jsonAssembly) include(directive interface{}, context ProcessContext) (interface{}, error) {
+	var include jsonInclude
+	switch d := directive.(type) {
+	case string:
+		include.Src = d
+	case jsonObject:
+		var raw bytes.Buffer
+		if err := encodeJSON(&raw, d); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw.Bytes(), &include); err != nil {
+			return nil, fmt.Errorf("invalid %s directive: %w", JSONIncludeKey, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s directive: want a src string or an object", JSONIncludeKey)
+	}
+	if include.Src == "" {
+		return nil, fmt.Errorf("%s directive without src", JSONIncludeKey)
+	}
+
+	a.includes++
+	if a.includes > a.p.config.MaxIncludes {
+		return nil, fmt.Errorf("maximum includes exceeded: %d", a.p.config.MaxIncludes)
+	}
+
+	src := a.p.ExpandESIVariables(include.Src, context)
+	value, err := a.fetch(src, context)
+	if err != nil && include.Alt != "" {
+		if a.p.debugEnabled() {
+			fmt.Printf("⚠️  JSON include failed for %s: %v\n", src, err)
+		}
+		src = a.p.ExpandESIVariables(include.Alt, context)
+		value, err = a.fetch(src, context)
+	}
+	if err == nil {
+		return value, nil
+	}
+
+	if a.p.debugEnabled() {
+		fmt.Printf("⚠️  JSON include failed for %s: %v\n", src, err)
+	}
+	if len(include.Default) > 0 {
+		return decodeJSON(include.Default)
+	}
+	if include.OnError == "continue" {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("include %s failed: %w", src, err)
+}
+
+// fetch fetches the JSON fragment at src and assembles it one level deeper
+func (a *jsonAssembly) fetch(src string, context ProcessContext) (interface{}, error) {
+	if context.Depth > a.p.config.MaxDepth {
+		return nil, fmt.Errorf("maximum include depth exceeded: %d", a.p.config.MaxDepth)
+	}
+
+	include := IncludeRequest{Method: http.MethodGet, URL: src}
+	a.p.rewriteInclude(&include, context)
+	if err := a.p.runBeforeInclude(&include, context); err != nil {
+		return nil, err
+	}
+	content, err := a.p.fetchIncludeContent(include, context)
+	if err != nil {
+		return nil, err
+	}
+	content, err = a.p.runAfterInclude(include, content, context)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := decodeJSON([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("fragment %s is not JSON: %w", src, err)
+	}
+	context.Depth++
+	return a.assemble(value, context)
+}
+
+// decodeJSON decodes a single JSON value, keeping member order and number literals
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("data after the top-level value")
+	}
+	return value, nil
+}
+
+// decodeJSONValue decodes the next value of decoder
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonMember{key: key.(string), value: value})
+		}
+		_, err := decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := decoder.Token()
+		return array, err
+	}
+	return token, nil
+}
+
+// encodeJSON writes value compactly, objects in member order
+func encodeJSON(out *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case jsonObject:
+		out.WriteByte('{')
+		for i, member := range v {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := encodeJSONScalar(out, member.key); err != nil {
+				return err
+			}
+			out.WriteByte(':')
+			if err := encodeJSON(out, member.value); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	case []interface{}:
+		out.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := encodeJSON(out, element); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	default:
+		return encodeJSONScalar(out, v)
+	}
+	return nil
+}
+
+// encodeJSONScalar writes a string, number, bool or null without escaping HTML characters
+func encodeJSONScalar(out *bytes.Buffer, value interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	out.Truncate(out.Len() - 1) // Encode ends values with a newline
+	return nil
+}
@@ -0,0 +1,110 @@
+package esi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newJSONOrigin(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/price":
+			w.Write([]byte(`{"amount": 19.90, "currency": "EUR"}`))
+		case "/product":
+			w.Write([]byte(`{"id": 42, "price": {"$esi:include": "/price"}, "note": "<b>&</b>"}`))
+		case "/user":
+			w.Write([]byte(`{"name": "` + r.Header.Get("X-User") + `"}`))
+		case "/loop":
+			w.Write([]byte(`{"next": {"$esi:include": "/loop"}}`))
+		case "/html":
+			w.Write([]byte(`<p>not json</p>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProcessJSON_Assembly(t *testing.T) {
+	origin := newJSONOrigin(t)
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+
+	document := `{"z": 1, "product": {"$esi:include": {"src": "/product"}}, "items": [{"$esi:include": "/price"}, 2.50]}`
+	result, err := processor.ProcessJSON([]byte(document), ProcessContext{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Equal(t, `{"z":1,"product":{"id":42,"price":{"amount":19.90,"currency":"EUR"},"note":"<b>&</b>"},`+
+		`"items":[{"amount":19.90,"currency":"EUR"},2.50]}`, string(result), "member order and number literals are kept")
+}
+
+func TestProcessJSON_Variables(t *testing.T) {
+	origin := newJSONOrigin(t)
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+
+	var received string
+	processor.OnBeforeInclude(func(include *IncludeRequest, context ProcessContext) error {
+		received = include.URL
+		include.Headers = map[string]string{"X-User": "ada"}
+		return nil
+	})
+	result, err := processor.ProcessJSON([]byte(`{"$esi:include": "/user?lang=$(HTTP_ACCEPT_LANGUAGE)"}`),
+		ProcessContext{BaseURL: origin.URL, Headers: map[string]string{"Accept-Language": "de"}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"ada"}`, string(result))
+	assert.Equal(t, "/user?lang=de", received)
+}
+
+func TestProcessJSON_Fallbacks(t *testing.T) {
+	origin := newJSONOrigin(t)
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	context := ProcessContext{BaseURL: origin.URL}
+
+	tests := []struct {
+		name     string
+		document string
+		want     string
+		wantErr  bool
+	}{
+		{name: "alt", document: `{"$esi:include": {"src": "/missing", "alt": "/price"}}`, want: `{"amount":19.90,"currency":"EUR"}`},
+		{name: "default", document: `[{"$esi:include": {"src": "/missing", "alt": "/gone", "default": {"amount": 0}}}]`, want: `[{"amount":0}]`},
+		{name: "continue", document: `{"price": {"$esi:include": {"src": "/missing", "onerror": "continue"}}}`, want: `{"price":null}`},
+		{name: "not json", document: `{"$esi:include": {"src": "/html", "default": "n/a"}}`, want: `"n/a"`},
+		{name: "failure", document: `{"price": {"$esi:include": "/missing"}}`, wantErr: true},
+		{name: "depth", document: `{"$esi:include": "/loop"}`, wantErr: true},
+		{name: "no src", document: `{"$esi:include": {"alt": "/price"}}`, wantErr: true},
+		{name: "directive with siblings", document: `{"$esi:include": "/price", "x": 1}`, want: `{"$esi:include":"/price","x":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := processor.ProcessJSON([]byte(tt.document), context)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(result))
+		})
+	}
+}
+
+func TestProcessJSON_InvalidDocument(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	for _, document := range []string{`{"a": `, `{} {}`, `<p>html</p>`} {
+		_, err := processor.ProcessJSON([]byte(document), ProcessContext{})
+		assert.True(t, errors.Is(err, ErrInvalidJSON), "%s: %v", document, err)
+	}
+}
+
+func TestProcessJSON_MaxIncludes(t *testing.T) {
+	origin := newJSONOrigin(t)
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 1, MaxDepth: 5})
+
+	_, err := processor.ProcessJSON([]byte(`[{"$esi:include": "/price"}, {"$esi:include": "/price"}]`), ProcessContext{BaseURL: origin.URL})
+	assert.ErrorContains(t, err, "maximum includes exceeded")
+}
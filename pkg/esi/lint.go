@@ -0,0 +1,298 @@
+package esi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Lint severities
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// Lint rules
+const (
+	RuleUnknownElement      = "unknown-element"
+	RuleMissingAttribute    = "missing-attribute"
+	RuleUnreachableBranch   = "unreachable-branch"
+	RuleUndefinedVariable   = "undefined-variable"
+	RuleIncludeBudget       = "include-budget"
+	RuleUnsupportedVariable = "unsupported-variable"
+)
+
+// LintIssue is a problem found in an ESI template
+type LintIssue struct {
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+// String formats the issue as line: severity: message [rule]
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%d: %s: %s [%s]", i.Line, i.Severity, i.Message, i.Rule)
+}
+
+// requiredAttributes are the attributes an element is ignored without
+var requiredAttributes = map[string][]string{
+	"esi:include":    {"src"},
+	"esi:when":       {"test"},
+	"esi:assign":     {"name"},
+	"esi:eval":       {"expr"},
+	"esi:function":   {"name"},
+	"esi:dictionary": {"src", "key"},
+	"esi:lookup":     {"key"},
+}
+
+// standardVariables are the request variables the processor resolves in every mode with variables
+var standardVariables = []string{
+	"HTTP_HOST", "HTTP_USER_AGENT", "HTTP_COOKIE", "HTTP_REFERER", "HTTP_ACCEPT_LANGUAGE",
+	"QUERY_STRING", "REQUEST_METHOD", "REQUEST_URI", "CTX",
+}
+
+// akamaiVariables are only resolved in akamai and development modes
+var akamaiVariables = []string{
+	"GEO_COUNTRY_CODE", "GEO_COUNTRY_NAME", "GEO_REGION", "GEO_CITY", "CLIENT_IP",
+	"UA_BRAND", "UA_VERSION", "UA_MOBILE", "UA_PLATFORM", "EXPERIMENT",
+	"EDGE_DATA", "LAST_INCLUDE_STATUS", "INCLUDE_HDR",
+}
+
+// lintVariablePattern matches variable references
+var lintVariablePattern = regexp.MustCompile(`\$\(([A-Za-z_][A-Za-z0-9_]*)(?:\{[^}]*\})?(?:\|[^)]*)?\)`)
+
+// lintChoose tracks the branches of an open esi:choose
+type lintChoose struct {
+	alwaysLine    int // line of a when whose test is always true, 0 if none
+	otherwiseLine int // line of the first otherwise, 0 if none
+}
+
+// linter walks a parsed template in document order
+type linter struct {
+	config    Config
+	mode      Mode
+	features  Features
+	processor *Processor
+	issues    []LintIssue
+	assigned  map[string]bool
+	chooses   []*lintChoose
+	includes  int
+}
+
+// Lint parses an ESI template and reports problems for the configured mode without
+// fetching anything: elements the mode does not process, missing required attributes,
+// unreachable branches, variables referenced before they are assigned and includes
+// over the MaxIncludes budget (unchecked when zero).
+func Lint(template string, config Config) []LintIssue {
+	processor := NewProcessor(config)
+	l := &linter{
+		config:    config,
+		mode:      processor.mode,
+		features:  processor.GetFeatures(),
+		processor: processor,
+		assigned:  make(map[string]bool),
+	}
+
+	parsed, err := ParseTemplate(template)
+	if err != nil {
+		var parseErr *ParseError
+		line := 1
+		if errors.As(err, &parseErr) {
+			line = parseErr.Line
+		}
+		l.report(line, LintError, RuleUnknownElement, "cannot parse template: %v", err)
+		return l.issues
+	}
+	l.lint(parsed.Nodes)
+	return l.issues
+}
+
+// report records an issue
+func (l *linter) report(line int, severity, rule, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{Line: line, Severity: severity, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// akamai reports whether the mode processes the Akamai extension elements
+func (l *linter) akamai() bool {
+	return l.mode.Extensions()
+}
+
+// lint lints template nodes in order
+func (l *linter) lint(nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Type {
+		case TextNode:
+			l.markup(n.Text, n.Line)
+		case CommentBlockNode:
+			if !l.features.CommentBlocks {
+				l.report(n.Line, LintError, RuleUnknownElement, "<!--esi --> blocks are not processed in %s mode", l.config.Mode)
+			} else {
+				l.lint(n.Children)
+			}
+		case ElementNode:
+			l.element(n)
+		}
+	}
+}
+
+// markup lints the variable references of markup starting at line, outside HTML comments
+func (l *linter) markup(markup string, line int) {
+	tokenizer := html.NewTokenizer(strings.NewReader(markup))
+	for tokenType := tokenizer.Next(); tokenType != html.ErrorToken; tokenType = tokenizer.Next() {
+		raw := string(tokenizer.Raw())
+		if tokenType != html.CommentToken {
+			l.references(raw, line)
+		}
+		line += strings.Count(raw, "\n")
+	}
+}
+
+// element lints an ESI element and its content
+func (l *linter) element(n *Node) {
+	for _, attr := range n.Attr {
+		if n.Name != "esi:assign" || attr.Key != "name" {
+			l.references(attr.Val, n.Line)
+		}
+	}
+
+	if !strings.HasPrefix(n.Name, "esi:") || !l.supported(n.Name, n.Line) {
+		l.lint(n.Children)
+		return
+	}
+
+	for _, name := range requiredAttributes[n.Name] {
+		if value, _ := n.GetAttr(name); value == "" {
+			l.report(n.Line, LintError, RuleMissingAttribute, "%s has no %s attribute", n.Name, name)
+		}
+	}
+
+	switch n.Name {
+	case "esi:include":
+		l.includes++
+		if l.config.MaxIncludes > 0 && l.includes == l.config.MaxIncludes+1 {
+			l.report(n.Line, LintError, RuleIncludeBudget, "include %d exceeds the budget of %d includes; it and later includes are not fetched", l.includes, l.config.MaxIncludes)
+		}
+	case "esi:remove":
+		// The content of esi:remove is never processed
+		return
+	case "esi:assign":
+		if name, _ := n.GetAttr("name"); name != "" {
+			l.assigned[name] = true
+		}
+	case "esi:choose":
+		l.chooses = append(l.chooses, &lintChoose{})
+		l.lint(n.Children)
+		l.chooses = l.chooses[:len(l.chooses)-1]
+		return
+	case "esi:when":
+		test, _ := n.GetAttr("test")
+		l.when(test, n.Line)
+	case "esi:otherwise":
+		l.otherwise(n.Line)
+	}
+	l.lint(n.Children)
+}
+
+// supported reports an element the mode does not process
+func (l *linter) supported(name string, line int) bool {
+	supported, known := elementSupported(name, l.mode)
+	switch {
+	case !known:
+		l.report(line, LintError, RuleUnknownElement, "unknown element %s", name)
+	case !supported:
+		l.report(line, LintError, RuleUnknownElement, "%s is not processed in %s mode", name, l.config.Mode)
+	}
+	return supported
+}
+
+// elementSupported reports whether a mode processes an ESI element, and whether the
+// element is known at all
+func elementSupported(name string, mode Mode) (supported bool, known bool) {
+	features := mode.Features()
+	switch name {
+	case "esi:include":
+		return features.Include, true
+	case "esi:comment":
+		return features.Comment, true
+	case "esi:remove":
+		return features.Remove, true
+	case "esi:inline":
+		return features.Inline, true
+	case "esi:choose", "esi:when", "esi:otherwise":
+		return features.Choose, true
+	case "esi:try", "esi:attempt", "esi:except":
+		return features.Try, true
+	case "esi:vars":
+		return features.Vars, true
+	case "esi:assign", "esi:eval", "esi:function", "esi:dictionary", "esi:lookup", "esi:debug",
+		"esi:parallel", "esi:sequential":
+		// Extensions are only processed by the Akamai handler
+		return mode.Extensions(), true
+	}
+	return false, false
+}
+
+// when lints a branch of the innermost esi:choose
+func (l *linter) when(test string, line int) {
+	if len(l.chooses) == 0 {
+		return
+	}
+	choose := l.chooses[len(l.chooses)-1]
+
+	if choose.alwaysLine > 0 {
+		l.report(line, LintWarning, RuleUnreachableBranch, "esi:when is unreachable: the esi:when on line %d always matches", choose.alwaysLine)
+		return
+	}
+	if test != "" && !strings.Contains(test, "$") && l.processor.evaluateExpression(test, ProcessContext{}) == "true" {
+		choose.alwaysLine = line
+	}
+}
+
+// otherwise lints the fallback branch of the innermost esi:choose
+func (l *linter) otherwise(line int) {
+	if len(l.chooses) == 0 {
+		return
+	}
+	choose := l.chooses[len(l.chooses)-1]
+
+	switch {
+	case choose.otherwiseLine > 0:
+		l.report(line, LintWarning, RuleUnreachableBranch, "esi:otherwise is unreachable: the esi:otherwise on line %d is used", choose.otherwiseLine)
+		return
+	case choose.alwaysLine > 0:
+		l.report(line, LintWarning, RuleUnreachableBranch, "esi:otherwise is unreachable: the esi:when on line %d always matches", choose.alwaysLine)
+	}
+	choose.otherwiseLine = line
+}
+
+// references lints the variable references of text starting at line
+func (l *linter) references(text string, line int) {
+	for _, match := range lintVariablePattern.FindAllStringSubmatchIndex(text, -1) {
+		name := text[match[2]:match[3]]
+		refLine := line + strings.Count(text[:match[0]], "\n")
+
+		if !l.features.Variables {
+			l.report(refLine, LintWarning, RuleUnsupportedVariable, "$(%s) is not expanded in %s mode", name, l.config.Mode)
+			continue
+		}
+		if !l.defined(name) {
+			l.report(refLine, LintWarning, RuleUndefinedVariable, "$(%s) is not a known variable and is not assigned before use", name)
+		}
+	}
+}
+
+// defined reports whether a variable is resolved by the processor or assigned earlier
+func (l *linter) defined(name string) bool {
+	if l.assigned[name] || containsString(standardVariables, name) {
+		return true
+	}
+	if l.akamai() {
+		// PMUSER variables are set by Property Manager rules before ESI runs
+		return containsString(akamaiVariables, name) || strings.HasPrefix(name, "PMUSER_")
+	}
+	return false
+}
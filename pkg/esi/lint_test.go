@@ -0,0 +1,127 @@
+package esi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		template string
+		expected []LintIssue
+	}{
+		{
+			name:     "clean template",
+			mode:     "akamai",
+			template: `<esi:assign name="section" value="'news'" /><esi:include src="/$(section)?h=$(HTTP_HOST)&c=$(GEO_COUNTRY_CODE)" />`,
+		},
+		{
+			name:     "experiment variable",
+			mode:     "akamai",
+			template: `<esi:include src="/checkout/$(EXPERIMENT{checkout})" />`,
+		},
+		{
+			name:     "edge data",
+			mode:     "akamai",
+			template: `<esi:lookup key="banner" name="banner" default="$(EDGE_DATA{fallback})" />`,
+		},
+		{
+			name:     "extension element in fastly mode",
+			mode:     "fastly",
+			template: "<p>\n<esi:assign name=\"x\" value=\"1\" />\n<esi:choose></esi:choose>",
+			expected: []LintIssue{
+				{Line: 2, Severity: LintError, Rule: RuleUnknownElement, Message: "esi:assign is not processed in fastly mode"},
+				{Line: 3, Severity: LintError, Rule: RuleUnknownElement, Message: "esi:choose is not processed in fastly mode"},
+			},
+		},
+		{
+			name:     "unknown element",
+			mode:     "w3c",
+			template: `<esi:includ src="/a" />`,
+			expected: []LintIssue{
+				{Line: 1, Severity: LintError, Rule: RuleUnknownElement, Message: "unknown element esi:includ"},
+			},
+		},
+		{
+			name:     "missing src",
+			mode:     "akamai",
+			template: "<esi:include alt=\"/b\" />\n<esi:dictionary key=\"k\" />",
+			expected: []LintIssue{
+				{Line: 1, Severity: LintError, Rule: RuleMissingAttribute, Message: "esi:include has no src attribute"},
+				{Line: 2, Severity: LintError, Rule: RuleMissingAttribute, Message: "esi:dictionary has no src attribute"},
+			},
+		},
+		{
+			name: "unreachable branches",
+			mode: "akamai",
+			template: "<esi:choose>\n<esi:when test=\"1==1\">a</esi:when>\n<esi:when test=\"$(HTTP_HOST)=='x'\">b</esi:when>\n<esi:otherwise>c</esi:otherwise>\n</esi:choose>\n" +
+				"<esi:choose><esi:when test=\"$(HTTP_HOST)=='x'\">a</esi:when><esi:otherwise>b</esi:otherwise><esi:otherwise>c</esi:otherwise></esi:choose>",
+			expected: []LintIssue{
+				{Line: 3, Severity: LintWarning, Rule: RuleUnreachableBranch, Message: "esi:when is unreachable: the esi:when on line 2 always matches"},
+				{Line: 4, Severity: LintWarning, Rule: RuleUnreachableBranch, Message: "esi:otherwise is unreachable: the esi:when on line 2 always matches"},
+				{Line: 6, Severity: LintWarning, Rule: RuleUnreachableBranch, Message: "esi:otherwise is unreachable: the esi:otherwise on line 6 is used"},
+			},
+		},
+		{
+			name:     "variable used before assignment",
+			mode:     "akamai",
+			template: "<p>$(section)</p>\n<esi:assign name=\"section\" value=\"$(section|'home')\" />\n$(section) $(PMUSER_SEGMENT)",
+			expected: []LintIssue{
+				{Line: 1, Severity: LintWarning, Rule: RuleUndefinedVariable, Message: "$(section) is not a known variable and is not assigned before use"},
+				{Line: 2, Severity: LintWarning, Rule: RuleUndefinedVariable, Message: "$(section) is not a known variable and is not assigned before use"},
+			},
+		},
+		{
+			name:     "akamai variables in w3c mode",
+			mode:     "w3c",
+			template: `<esi:vars>$(GEO_COUNTRY_CODE) $(HTTP_COOKIE{uid})</esi:vars>`,
+			expected: []LintIssue{
+				{Line: 1, Severity: LintWarning, Rule: RuleUndefinedVariable, Message: "$(GEO_COUNTRY_CODE) is not a known variable and is not assigned before use"},
+			},
+		},
+		{
+			name:     "variables in fastly mode",
+			mode:     "fastly",
+			template: `<esi:include src="/a?h=$(HTTP_HOST)" />`,
+			expected: []LintIssue{
+				{Line: 1, Severity: LintWarning, Rule: RuleUnsupportedVariable, Message: "$(HTTP_HOST) is not expanded in fastly mode"},
+			},
+		},
+		{
+			name:     "removed content is not linted",
+			mode:     "fastly",
+			template: `<esi:remove><esi:assign name="x" /><esi:include /> $(nope)</esi:remove>`,
+		},
+		{
+			name:     "comment blocks",
+			mode:     "akamai",
+			template: "<!--esi\n<esi:include />\n-->",
+			expected: []LintIssue{
+				{Line: 2, Severity: LintError, Rule: RuleMissingAttribute, Message: "esi:include has no src attribute"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Lint(tt.template, Config{Mode: tt.mode}))
+		})
+	}
+}
+
+func TestLint_IncludeBudget(t *testing.T) {
+	template := "<esi:include src=\"/a\" />\n<esi:include src=\"/b\" />\n<esi:include src=\"/c\" />"
+
+	assert.Empty(t, Lint(template, Config{Mode: "akamai"}))
+	assert.Equal(t, []LintIssue{
+		{Line: 3, Severity: LintError, Rule: RuleIncludeBudget, Message: "include 3 exceeds the budget of 2 includes; it and later includes are not fetched"},
+	}, Lint(template, Config{Mode: "akamai", MaxIncludes: 2}))
+}
+
+func TestLintIssue_String(t *testing.T) {
+	issue := LintIssue{Line: 3, Severity: LintError, Rule: RuleMissingAttribute, Message: "esi:include has no src attribute"}
+	assert.Equal(t, "3: error: esi:include has no src attribute [missing-attribute]", issue.String())
+}
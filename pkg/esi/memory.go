@@ -0,0 +1,121 @@
+package esi
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBufferSize is the capacity above which buffers are dropped rather than
+// pooled, so one huge page does not pin its memory
+const maxPooledBufferSize = 1 << 20
+
+// bufferPool holds the byte buffers fragment bodies are read into and output is
+// assembled in, shared by every processor
+var bufferPool sync.Pool
+
+// MemoryStats approximates the memory requests use from the bytes they buffer: the
+// fragment bodies read and the output assembled, counted by the capacity of the
+// buffers holding them, and the rendered page
+type MemoryStats struct {
+	PeakRequestBytes int64 `json:"peakRequestBytes"` // Highest peak of a single request
+	AvgRequestBytes  int64 `json:"avgRequestBytes"`  // Average peak of the requests measured
+	Requests         int64 `json:"requests"`         // Requests measured
+	BufferGets       int64 `json:"bufferGets"`       // Buffers taken from the pool
+	BufferAllocs     int64 `json:"bufferAllocs"`     // Buffers allocated because the pool was empty
+}
+
+// memoryAccount tracks the bytes a request holds in buffers and their peak. It is
+// shared by the page and its fragments, whose includes may be fetched concurrently.
+type memoryAccount struct {
+	live atomic.Int64
+	peak atomic.Int64
+}
+
+// hold adds n bytes held by the request
+func (m *memoryAccount) hold(n int64) {
+	if m == nil {
+		return
+	}
+	m.observe(m.live.Add(n))
+}
+
+// release removes n bytes no longer held by the request
+func (m *memoryAccount) release(n int64) {
+	if m == nil {
+		return
+	}
+	m.live.Add(-n)
+}
+
+// observe raises the peak to bytes when it is higher
+func (m *memoryAccount) observe(bytes int64) {
+	for {
+		peak := m.peak.Load()
+		if bytes <= peak || m.peak.CompareAndSwap(peak, bytes) {
+			return
+		}
+	}
+}
+
+// pooledBuffer is a buffer from bufferPool whose capacity is held by a request
+type pooledBuffer struct {
+	*bytes.Buffer
+	account  *memoryAccount
+	reserved int64 // Capacity held in the account
+}
+
+// getBuffer takes an empty buffer from the pool, holding its capacity in account
+func (p *Processor) getBuffer(account *memoryAccount) pooledBuffer {
+	p.stats.bufferGets.Add(1)
+	buffer, _ := bufferPool.Get().(*bytes.Buffer)
+	if buffer == nil {
+		p.stats.bufferAllocs.Add(1)
+		buffer = new(bytes.Buffer)
+	}
+
+	reserved := int64(buffer.Cap())
+	account.hold(reserved)
+	return pooledBuffer{Buffer: buffer, account: account, reserved: reserved}
+}
+
+// putBuffer returns a buffer to the pool, releasing its capacity. The capacity it
+// grew by is held first, so the request's peak includes it.
+func (p *Processor) putBuffer(buffer pooledBuffer) {
+	capacity := int64(buffer.Cap())
+	buffer.account.hold(capacity - buffer.reserved)
+	buffer.account.release(capacity)
+
+	if capacity > maxPooledBufferSize {
+		return
+	}
+	buffer.Reset()
+	bufferPool.Put(buffer.Buffer)
+}
+
+// recordMemory adds the peak of a request to the memory statistics
+func (p *Processor) recordMemory(account *memoryAccount) {
+	peak := account.peak.Load()
+	p.stats.memoryRequests.Add(1)
+	p.stats.requestBytes.Add(peak)
+	for {
+		highest := p.stats.peakRequestBytes.Load()
+		if peak <= highest || p.stats.peakRequestBytes.CompareAndSwap(highest, peak) {
+			return
+		}
+	}
+}
+
+// memoryStats snapshots the memory statistics, reading the counters with read
+func (s *statsRecorder) memoryStats(read func(counter *atomic.Int64) int64) MemoryStats {
+	stats := MemoryStats{
+		PeakRequestBytes: read(&s.peakRequestBytes),
+		Requests:         read(&s.memoryRequests),
+		BufferGets:       read(&s.bufferGets),
+		BufferAllocs:     read(&s.bufferAllocs),
+	}
+	if requestBytes := read(&s.requestBytes); stats.Requests > 0 {
+		stats.AvgRequestBytes = requestBytes / stats.Requests
+	}
+	return stats
+}
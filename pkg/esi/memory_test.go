@@ -0,0 +1,64 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryAccount(t *testing.T) {
+	var account memoryAccount
+	account.hold(100)
+	account.hold(50)
+	account.release(120)
+	account.hold(40)
+	assert.EqualValues(t, 70, account.live.Load())
+	assert.EqualValues(t, 150, account.peak.Load())
+
+	// A nil account records nothing
+	var none *memoryAccount
+	none.hold(10)
+	none.release(10)
+}
+
+func TestProcessor_MemoryStats(t *testing.T) {
+	fragment := "<p>" + strings.Repeat("x", 64*1024) + "</p>"
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fragment))
+	}))
+	defer origin.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	for i := 0; i < 3; i++ {
+		result, err := processor.Process(`<div><esi:include src="/big"/></div>`, ProcessContext{BaseURL: origin.URL})
+		require.NoError(t, err)
+		assert.Contains(t, result, fragment)
+	}
+
+	memory := processor.GetStats().Memory
+	assert.EqualValues(t, 3, memory.Requests)
+	assert.GreaterOrEqual(t, memory.PeakRequestBytes, int64(2*len(fragment)))
+	assert.LessOrEqual(t, memory.AvgRequestBytes, memory.PeakRequestBytes)
+	assert.NotZero(t, memory.AvgRequestBytes)
+	// Each request takes buffers for the page, the fragment body and the fragment
+	assert.GreaterOrEqual(t, memory.BufferGets, int64(9))
+	assert.LessOrEqual(t, memory.BufferAllocs, memory.BufferGets)
+
+	processor.ResetStats()
+	assert.Equal(t, MemoryStats{}, processor.GetStats().Memory)
+}
+
+func TestProcessor_MemoryStatsPassThrough(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	_, err := processor.Process("<p>plain</p>", ProcessContext{})
+	require.NoError(t, err)
+
+	memory := processor.GetStats().Memory
+	assert.EqualValues(t, 1, memory.Requests)
+	assert.Zero(t, memory.PeakRequestBytes)
+	assert.Zero(t, memory.BufferGets)
+}
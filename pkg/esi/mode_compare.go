@@ -0,0 +1,286 @@
+package esi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// DefaultComparisonBaseURL resolves relative includes of a mode comparison without a base URL
+const DefaultComparisonBaseURL = "http://localhost"
+
+// diffContextLines is the number of unchanged lines around each unified diff hunk
+const diffContextLines = 3
+
+// Feature names of featureUsage that are not elements
+const (
+	commentBlockFeature = "<!--esi -->"
+	variablesFeature    = "$(variables)"
+)
+
+// ModeComparisonOptions configures CompareModes
+type ModeComparisonOptions struct {
+	// Modes are processed in order; the first is the baseline the others are diffed against
+	Modes []string
+	// Includes mocks include responses by absolute URL or by path and query. Other
+	// includes are answered with a placeholder comment naming the URL.
+	Includes map[string]string
+	// BaseURL defaults to DefaultComparisonBaseURL
+	BaseURL string
+	// MaxIncludes defaults to 256, as the emulator
+	MaxIncludes int
+	// Context is the request the template is processed for
+	Context ProcessContext
+}
+
+// ModeDiff is the unified diff of the outputs of two modes, empty when they match
+type ModeDiff struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Unified string `json:"unified,omitempty"`
+}
+
+// FeatureUsage is an ESI feature used by a template and whether each mode processes it
+type FeatureUsage struct {
+	Feature string `json:"feature"`
+	Count   int    `json:"count"`
+	// Lines are the lines the feature is used on, in order, each once
+	Lines     []int           `json:"lines"`
+	Supported map[string]bool `json:"supported"`
+}
+
+// ModeComparison is the outcome of processing a template under several modes
+type ModeComparison struct {
+	Modes   []string          `json:"modes"`
+	Outputs map[string]string `json:"outputs"`
+	Diffs   []ModeDiff        `json:"diffs"`
+	Usage   []FeatureUsage    `json:"usage"`
+}
+
+// Differs reports whether any mode produced a different output than the baseline
+func (c *ModeComparison) Differs() bool {
+	for _, diff := range c.Diffs {
+		if diff.Unified != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// mockTransport answers include requests from ModeComparisonOptions.Includes
+type mockTransport map[string]string
+
+func (m mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	content, exists := m[req.URL.String()]
+	if !exists {
+		content, exists = m[req.URL.RequestURI()]
+	}
+	if !exists {
+		content = fmt.Sprintf("<!-- include %s -->", req.URL.String())
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(content)),
+		Request:    req,
+	}, nil
+}
+
+// CompareModes processes a template under each mode with mocked includes, diffs every
+// output against the first mode's and reports which modes process the features it uses
+func CompareModes(template string, options ModeComparisonOptions) (*ModeComparison, error) {
+	if len(options.Modes) < 2 {
+		return nil, fmt.Errorf("at least two modes are needed, got %d", len(options.Modes))
+	}
+	if options.BaseURL == "" {
+		options.BaseURL = DefaultComparisonBaseURL
+	}
+	if options.MaxIncludes == 0 {
+		options.MaxIncludes = 256
+	}
+
+	comparison := &ModeComparison{Modes: options.Modes, Outputs: make(map[string]string, len(options.Modes))}
+	for _, mode := range options.Modes {
+		processor := NewProcessor(Config{Mode: mode, MaxIncludes: options.MaxIncludes, MaxDepth: 1, BaseURL: options.BaseURL})
+		processor.client = &http.Client{Transport: mockTransport(options.Includes)}
+
+		context := options.Context
+		context.BaseURL = options.BaseURL
+		output, err := processor.Process(template, context)
+		if err != nil {
+			return nil, fmt.Errorf("error processing in %s mode: %w", mode, err)
+		}
+		comparison.Outputs[mode] = output
+	}
+
+	baseline := options.Modes[0]
+	for _, mode := range options.Modes[1:] {
+		comparison.Diffs = append(comparison.Diffs, ModeDiff{
+			From:    baseline,
+			To:      mode,
+			Unified: unifiedDiff(baseline, mode, strings.Split(comparison.Outputs[baseline], "\n"), strings.Split(comparison.Outputs[mode], "\n")),
+		})
+	}
+
+	comparison.Usage = featureUsage(template, options.Modes)
+	return comparison, nil
+}
+
+// featureUsage counts the ESI elements, comment blocks and variables of a template
+func featureUsage(template string, modes []string) []FeatureUsage {
+	counts := make(map[string]int)
+	lines := make(map[string][]int)
+	use := func(feature string, line int) {
+		counts[feature]++
+		if used := lines[feature]; len(used) == 0 || used[len(used)-1] != line {
+			lines[feature] = append(used, line)
+		}
+	}
+
+	if parsed, err := ParseTemplate(template); err == nil {
+		parsed.Walk(func(n *Node) bool {
+			switch {
+			case n.Type == CommentBlockNode:
+				use(commentBlockFeature, n.Line)
+			case n.Type == ElementNode && strings.HasPrefix(n.Name, "esi:"):
+				use(n.Name, n.Line)
+			}
+			return true
+		})
+	}
+	for _, match := range lintVariablePattern.FindAllStringIndex(template, -1) {
+		use(variablesFeature, 1+strings.Count(template[:match[0]], "\n"))
+	}
+
+	features := make([]string, 0, len(counts))
+	for feature := range counts {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+
+	usage := make([]FeatureUsage, 0, len(features))
+	for _, feature := range features {
+		supported := make(map[string]bool, len(modes))
+		for _, mode := range modes {
+			supported[mode] = featureSupported(feature, mode)
+		}
+		usage = append(usage, FeatureUsage{Feature: feature, Count: counts[feature], Lines: lines[feature], Supported: supported})
+	}
+	return usage
+}
+
+// featureSupported reports whether a mode processes a feature of featureUsage
+func featureSupported(feature, mode string) bool {
+	features := modeFor(mode).Features()
+	switch feature {
+	case commentBlockFeature:
+		return features.CommentBlocks
+	case variablesFeature:
+		return features.Variables
+	}
+	supported, _ := elementSupported(feature, modeFor(mode))
+	return supported
+}
+
+// unifiedDiff renders the unified diff turning a into b, empty when they are equal
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	edits := lineEdits(a, b)
+	unchanged := func(i int) bool { return edits[i][0] == ' ' }
+
+	var diff strings.Builder
+	for start := 0; start < len(edits); {
+		first := start
+		for first < len(edits) && unchanged(first) {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+
+		// Extend the hunk while the next change is close enough to share context
+		end := first + 1
+		for {
+			next := end
+			for next < len(edits) && unchanged(next) {
+				next++
+			}
+			if next == len(edits) || next-end > 2*diffContextLines {
+				break
+			}
+			end = next + 1
+		}
+		hunkStart := max(first-diffContextLines, start)
+		hunkEnd := min(end+diffContextLines, len(edits))
+
+		if diff.Len() == 0 {
+			fmt.Fprintf(&diff, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fromLine, toLine := hunkPosition(edits[:hunkStart])
+		fromCount, toCount := hunkPosition(edits[hunkStart:hunkEnd])
+		fmt.Fprintf(&diff, "@@ -%s +%s @@\n", hunkRange(fromLine, fromCount), hunkRange(toLine, toCount))
+		for _, edit := range edits[hunkStart:hunkEnd] {
+			diff.WriteString(edit[:1] + edit[2:] + "\n")
+		}
+		start = hunkEnd
+	}
+	return diff.String()
+}
+
+// hunkPosition counts the lines of a and b covered by edits
+func hunkPosition(edits []string) (int, int) {
+	fromLines, toLines := 0, 0
+	for _, edit := range edits {
+		if edit[0] != '+' {
+			fromLines++
+		}
+		if edit[0] != '-' {
+			toLines++
+		}
+	}
+	return fromLines, toLines
+}
+
+// hunkRange formats the start,count of a hunk that follows the first before lines
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// Report renders the diffs followed by a feature usage table
+func (c *ModeComparison) Report() string {
+	var report strings.Builder
+	for _, diff := range c.Diffs {
+		if diff.Unified == "" {
+			fmt.Fprintf(&report, "%s and %s produce the same output\n", diff.From, diff.To)
+			continue
+		}
+		report.WriteString(diff.Unified)
+	}
+
+	if len(c.Usage) == 0 {
+		report.WriteString("\nNo ESI features used\n")
+		return report.String()
+	}
+	report.WriteString("\nFeature usage:\n")
+	writer := tabwriter.NewWriter(&report, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "FEATURE\tCOUNT\t%s\n", strings.ToUpper(strings.Join(c.Modes, "\t")))
+	for _, usage := range c.Usage {
+		fmt.Fprintf(writer, "%s\t%d", usage.Feature, usage.Count)
+		for _, mode := range c.Modes {
+			status := "✅"
+			if !usage.Supported[mode] {
+				status = "❌"
+			}
+			fmt.Fprintf(writer, "\t%s", status)
+		}
+		fmt.Fprintln(writer)
+	}
+	writer.Flush()
+	return report.String()
+}
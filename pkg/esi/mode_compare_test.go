@@ -0,0 +1,66 @@
+package esi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareModes(t *testing.T) {
+	template := `<esi:include src="/header"></esi:include><esi:choose><esi:when test="1==1">yes</esi:when></esi:choose><p>$(HTTP_HOST)</p>`
+
+	comparison, err := CompareModes(template, ModeComparisonOptions{
+		Modes:    []string{"akamai", "fastly", "w3c"},
+		Includes: map[string]string{"/header": "<h1>Header</h1>"},
+		Context:  ProcessContext{Headers: map[string]string{"Host": "example.com"}},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, comparison.Outputs["akamai"], "<h1>Header</h1>yes<p>example.com</p>")
+	assert.Contains(t, comparison.Outputs["fastly"], "<h1>Header</h1>")
+	assert.Contains(t, comparison.Outputs["fastly"], "$(HTTP_HOST)")
+
+	require.Len(t, comparison.Diffs, 2)
+	assert.Equal(t, "fastly", comparison.Diffs[0].To)
+	assert.True(t, strings.HasPrefix(comparison.Diffs[0].Unified, "--- akamai\n+++ fastly\n@@ -1,1 +1,1 @@\n-"))
+	// Outside esi:vars, only Akamai expands variables
+	assert.Contains(t, comparison.Diffs[1].Unified, "+<html><head></head><body><h1>Header</h1>yes<p>$(HTTP_HOST)</p></body></html>\n")
+	assert.True(t, comparison.Differs())
+
+	assert.Equal(t, []FeatureUsage{
+		{Feature: "$(variables)", Count: 1, Lines: []int{1}, Supported: map[string]bool{"akamai": true, "fastly": false, "w3c": true}},
+		{Feature: "esi:choose", Count: 1, Lines: []int{1}, Supported: map[string]bool{"akamai": true, "fastly": false, "w3c": true}},
+		{Feature: "esi:include", Count: 1, Lines: []int{1}, Supported: map[string]bool{"akamai": true, "fastly": true, "w3c": true}},
+		{Feature: "esi:when", Count: 1, Lines: []int{1}, Supported: map[string]bool{"akamai": true, "fastly": false, "w3c": true}},
+	}, comparison.Usage)
+
+	report := comparison.Report()
+	assert.Contains(t, report, "--- akamai\n+++ w3c\n")
+	assert.Contains(t, report, "\nFeature usage:\nFEATURE       COUNT  AKAMAI  FASTLY  W3C\n")
+}
+
+func TestCompareModes_PlaceholderIncludes(t *testing.T) {
+	comparison, err := CompareModes(`<esi:include src="/nav"></esi:include>`, ModeComparisonOptions{Modes: []string{"fastly", "akamai"}})
+	require.NoError(t, err)
+
+	assert.Contains(t, comparison.Outputs["fastly"], "<!-- include http://localhost/nav -->")
+	assert.False(t, comparison.Differs())
+	assert.Contains(t, comparison.Report(), "fastly and akamai produce the same output\n")
+}
+
+func TestCompareModes_NeedsTwoModes(t *testing.T) {
+	_, err := CompareModes("", ModeComparisonOptions{Modes: []string{"akamai"}})
+	assert.Error(t, err)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Split("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12", "\n")
+	b := strings.Split("1\nx\n3\n4\n5\n6\n7\n8\n9\n10\n11", "\n")
+
+	assert.Equal(t, "--- a\n+++ b\n"+
+		"@@ -1,5 +1,5 @@\n 1\n-2\n+x\n 3\n 4\n 5\n"+
+		"@@ -9,4 +9,3 @@\n 9\n 10\n 11\n-12\n", unifiedDiff("a", "b", a, b))
+	assert.Empty(t, unifiedDiff("a", "b", a, a))
+}
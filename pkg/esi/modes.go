@@ -0,0 +1,130 @@
+package esi
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Mode is an ESI dialect the processor emulates, such as a CDN's flavour of ESI. Modes
+// are registered by name with RegisterMode and selected by Config.Mode, so dialects can
+// be added as plugins without changing the processor.
+type Mode interface {
+	// Features returns the ESI features the dialect processes
+	Features() Features
+	// Extensions reports whether the Akamai extension elements, such as esi:assign, and
+	// the Akamai variables are processed
+	Extensions() bool
+	// PreProcess runs on every document and fragment before it is parsed, for example to
+	// translate dialect-specific markup into standard ESI. An error fails processing of
+	// a document; a fragment that fails is inserted as fetched.
+	PreProcess(html string, context ProcessContext) (string, error)
+	// VariableResolver returns the resolver of the dialect's own variables, or nil
+	VariableResolver() VariableResolver
+}
+
+// VariableResolver resolves an ESI variable, with the key of a dictionary variable
+// such as $(HTTP_COOKIE{name}). It is consulted before the standard variables, so it
+// can override them; ok is false for variables it does not know.
+type VariableResolver func(name, key string, context ProcessContext) (value string, ok bool)
+
+// BaseMode is a Mode with a fixed feature set, no preprocessing and no variables of
+// its own. Plugins can embed it and override the methods they need.
+type BaseMode struct {
+	Supported Features
+	// AkamaiExtensions enables the Akamai extension elements and variables
+	AkamaiExtensions bool
+}
+
+// Features returns the supported features
+func (m BaseMode) Features() Features { return m.Supported }
+
+// Extensions reports whether the Akamai extensions are enabled
+func (m BaseMode) Extensions() bool { return m.AkamaiExtensions }
+
+// PreProcess returns the HTML unchanged
+func (m BaseMode) PreProcess(html string, context ProcessContext) (string, error) {
+	return html, nil
+}
+
+// VariableResolver returns nil: only the standard variables are resolved
+func (m BaseMode) VariableResolver() VariableResolver { return nil }
+
+// standardFeatures are the features every mode processes
+var standardFeatures = Features{Include: true, Comment: true, Remove: true}
+
+// fullFeatures are the features of the Akamai, W3C and development modes
+var fullFeatures = Features{
+	Include:       true,
+	Comment:       true,
+	Remove:        true,
+	Inline:        true,
+	Choose:        true,
+	Try:           true,
+	Vars:          true,
+	Variables:     true,
+	Expressions:   true,
+	CommentBlocks: true,
+	Assign:        true,
+	Eval:          true,
+	Function:      true,
+	Dictionary:    true,
+	Lookup:        true,
+	Groups:        true,
+	Debug:         true,
+	GeoVariables:  true,
+	ExtendedVars:  true,
+}
+
+// modeRegistry holds the registered modes and their names in registration order
+var modeRegistry = struct {
+	sync.RWMutex
+	modes map[string]Mode
+	names []string
+}{modes: make(map[string]Mode)}
+
+func init() {
+	RegisterMode("fastly", BaseMode{Supported: standardFeatures})
+	RegisterMode("akamai", BaseMode{Supported: fullFeatures, AkamaiExtensions: true})
+	RegisterMode("w3c", BaseMode{Supported: fullFeatures})
+	RegisterMode("development", BaseMode{Supported: fullFeatures, AkamaiExtensions: true})
+}
+
+// RegisterMode makes a mode available under name, usually from the init function of
+// the package implementing it. It panics when the name is taken or the mode is nil.
+func RegisterMode(name string, mode Mode) {
+	modeRegistry.Lock()
+	defer modeRegistry.Unlock()
+	if mode == nil {
+		panic(fmt.Sprintf("esi: RegisterMode of nil mode %q", name))
+	}
+	if _, exists := modeRegistry.modes[name]; exists {
+		panic(fmt.Sprintf("esi: mode %q registered twice", name))
+	}
+	modeRegistry.modes[name] = mode
+	modeRegistry.names = append(modeRegistry.names, name)
+}
+
+// LookupMode returns the mode registered under name
+func LookupMode(name string) (Mode, bool) {
+	modeRegistry.RLock()
+	defer modeRegistry.RUnlock()
+	mode, ok := modeRegistry.modes[name]
+	return mode, ok
+}
+
+// Modes returns the names of the registered modes in registration order, the built-in
+// fastly, akamai, w3c and development modes first
+func Modes() []string {
+	modeRegistry.RLock()
+	defer modeRegistry.RUnlock()
+	return append([]string(nil), modeRegistry.names...)
+}
+
+// modeFor returns the mode registered under name; unknown modes process the standard
+// features only
+func modeFor(name string) Mode {
+	if mode, ok := LookupMode(name); ok {
+		return mode
+	}
+	return BaseMode{Supported: standardFeatures}
+}
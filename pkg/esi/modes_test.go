@@ -0,0 +1,108 @@
+package esi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+// ssiMode is a dialect plugin translating SSI includes into esi:include and resolving
+// a CloudFront-style viewer country variable
+type ssiMode struct {
+	BaseMode
+}
+
+var ssiInclude = regexp.MustCompile(`<!--#include virtual="([^"]*)" -->`)
+
+func (m ssiMode) PreProcess(page string, context ProcessContext) (string, error) {
+	if strings.Contains(page, "<!--#exec") {
+		return page, errors.New("exec is not supported")
+	}
+	return ssiInclude.ReplaceAllStringFunc(page, func(directive string) string {
+		src := ssiInclude.FindStringSubmatch(directive)[1]
+		return `<esi:include src="` + html.EscapeString(src) + `"/>`
+	}), nil
+}
+
+func (m ssiMode) VariableResolver() VariableResolver {
+	return func(name, key string, context ProcessContext) (string, bool) {
+		switch name {
+		case "VIEWER_COUNTRY":
+			return context.Headers["Cloudfront-Viewer-Country"], true
+		case "HTTP_HOST":
+			return "edge.example.com", true
+		}
+		return "", false
+	}
+}
+
+func init() {
+	RegisterMode("test-ssi", ssiMode{BaseMode{Supported: Features{Include: true, Comment: true, Remove: true, Vars: true, Variables: true}}})
+}
+
+func TestModes_Builtin(t *testing.T) {
+	assert.Equal(t, []string{"fastly", "akamai", "w3c", "development"}, Modes()[:4])
+	assert.Contains(t, Modes(), "test-ssi")
+
+	akamai, ok := LookupMode("akamai")
+	require.True(t, ok)
+	assert.True(t, akamai.Extensions())
+	w3c, _ := LookupMode("w3c")
+	assert.False(t, w3c.Extensions())
+	assert.True(t, w3c.Features().Choose)
+
+	_, ok = LookupMode("varnish")
+	assert.False(t, ok)
+	assert.Equal(t, Features{Include: true, Comment: true, Remove: true}, NewProcessor(Config{Mode: "varnish"}).GetFeatures())
+}
+
+func TestModes_RegisterTwice(t *testing.T) {
+	assert.Panics(t, func() { RegisterMode("akamai", BaseMode{}) })
+	assert.Panics(t, func() { RegisterMode("nil-mode", nil) })
+	_, ok := LookupMode("nil-mode")
+	assert.False(t, ok)
+}
+
+func TestModes_Plugin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<span>` + r.URL.Path + `</span><!--#include virtual="/nested" -->`))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "test-ssi", MaxIncludes: 10, MaxDepth: 1, BaseURL: server.URL})
+	context := ProcessContext{Headers: map[string]string{"Cloudfront-Viewer-Country": "NL", "Host": "origin.example.com"}}
+
+	// SSI includes are translated in the page and in its fragments
+	result, err := processor.Process(`<div><!--#include virtual="/header" --></div>`, context)
+	require.NoError(t, err)
+	assert.Contains(t, result, "<span>/header</span><span>/nested</span>")
+
+	// The mode's variables are resolved before the standard ones
+	result, err = processor.Process(`<esi:vars>$(VIEWER_COUNTRY) $(HTTP_HOST) $(HTTP_USER_AGENT|none)</esi:vars>`, context)
+	require.NoError(t, err)
+	assert.Contains(t, result, "NL edge.example.com none")
+
+	// Extension elements are left alone without the Akamai extensions
+	result, err = processor.Process(`<esi:assign name="x" value="1"/>`, context)
+	require.NoError(t, err)
+	assert.Contains(t, result, "<esi:assign")
+
+	_, err = processor.Process(`<!--#exec cmd="ls" -->`, context)
+	assert.ErrorContains(t, err, "test-ssi mode: exec is not supported")
+}
+
+func TestModes_PluginLintAndFeatures(t *testing.T) {
+	issues := Lint(`<esi:include src="/a"/><esi:choose><esi:when test="1">x</esi:when></esi:choose>`, Config{Mode: "test-ssi"})
+	require.NotEmpty(t, issues)
+	assert.Equal(t, "esi:choose is not processed in test-ssi mode", issues[0].Message)
+
+	analysis := AnalyzeFeatures(`<esi:vars>$(HTTP_HOST)</esi:vars><esi:assign name="x" value="1"/>`, []string{"test-ssi", "akamai"})
+	assert.Equal(t, []string{"esi:assign"}, analysis.Unsupported("test-ssi"))
+}
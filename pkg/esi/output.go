@@ -0,0 +1,98 @@
+package esi
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Output formatting modes
+const (
+	OutputPreserve = "preserve" // Keep the processed HTML exactly as rendered
+	OutputCollapse = "collapse" // Drop the blank lines and trailing spaces left by removed ESI elements
+	OutputMinify   = "minify"   // Collapse all whitespace and drop HTML comments
+)
+
+// OutputModes are the valid output formatting modes
+var OutputModes = []string{OutputPreserve, OutputCollapse, OutputMinify}
+
+// rawTextOpenPattern matches the opening tags of elements whose content is never reformatted
+var rawTextOpenPattern = regexp.MustCompile(`(?i)<(pre|textarea|script|style)\b`)
+
+// rawTextClosePatterns match the closing tags of the raw text elements, by lowercase name
+var rawTextClosePatterns = map[string]*regexp.Regexp{
+	"pre":      regexp.MustCompile(`(?i)</pre`),
+	"textarea": regexp.MustCompile(`(?i)</textarea`),
+	"script":   regexp.MustCompile(`(?i)</script`),
+	"style":    regexp.MustCompile(`(?i)</style`),
+}
+
+var (
+	blankLinesPattern  = regexp.MustCompile(`\n(?:[ \t]*\n)+`)
+	trailingPattern    = regexp.MustCompile(`[ \t]+\n`)
+	whitespacePattern  = regexp.MustCompile(`\s+`)
+	htmlCommentPattern = regexp.MustCompile(`<!--[\s\S]*?-->`)
+)
+
+// outputMode returns the output formatting mode used for context
+func (p *Processor) outputMode(context ProcessContext) string {
+	if context.PreserveOutput || p.config.Output == "" {
+		return OutputPreserve
+	}
+	return p.config.Output
+}
+
+// formatOutput applies an output formatting mode to processed HTML. The content of
+// pre, textarea, script and style elements is left untouched.
+func formatOutput(html, mode string) string {
+	var format func(string) string
+	switch mode {
+	case OutputCollapse:
+		format = collapseWhitespace
+	case OutputMinify:
+		format = minifyHTML
+	default:
+		return html
+	}
+
+	// Offsets are only ever taken in html itself: lowercasing a copy can change the
+	// byte length of non-ASCII text
+	var out strings.Builder
+	for {
+		loc := rawTextOpenPattern.FindStringSubmatchIndex(html)
+		if loc == nil {
+			break
+		}
+		end := len(html)
+		closing := rawTextClosePatterns[strings.ToLower(html[loc[2]:loc[3]])]
+		if i := closing.FindStringIndex(html[loc[1]:]); i != nil {
+			end = loc[1] + i[0]
+		}
+		out.WriteString(format(html[:loc[0]]))
+		out.WriteString(html[loc[0]:end])
+		html = html[end:]
+	}
+	out.WriteString(format(html))
+
+	if mode == OutputMinify {
+		return strings.TrimSpace(out.String())
+	}
+	return out.String()
+}
+
+// collapseWhitespace trims trailing spaces and drops blank lines
+func collapseWhitespace(html string) string {
+	html = trailingPattern.ReplaceAllString(html, "\n")
+	return blankLinesPattern.ReplaceAllString(html, "\n")
+}
+
+// minifyHTML drops HTML comments, keeping conditional comments, and reduces every
+// whitespace run to a single space
+func minifyHTML(html string) string {
+	html = htmlCommentPattern.ReplaceAllStringFunc(html, func(comment string) string {
+		if strings.HasPrefix(comment, "<!--[if") || strings.HasPrefix(comment, "<!--<![endif") {
+			return comment
+		}
+		return ""
+	})
+	return whitespacePattern.ReplaceAllString(html, " ")
+}
@@ -0,0 +1,89 @@
+package esi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "preserve",
+			mode:     OutputPreserve,
+			input:    "<p>a</p>\n  \n\n<p>b</p>",
+			expected: "<p>a</p>\n  \n\n<p>b</p>",
+		},
+		{
+			name:     "collapse blank lines",
+			mode:     OutputCollapse,
+			input:    "<p>a</p>   \n  \n\n\t\n<p>b</p>\n",
+			expected: "<p>a</p>\n<p>b</p>\n",
+		},
+		{
+			name:     "minify",
+			mode:     OutputMinify,
+			input:    "\n<div>\n  <!-- note -->\n  <p>a   b</p>\n</div>\n",
+			expected: "<div> <p>a b</p> </div>",
+		},
+		{
+			name:     "minify keeps conditional comments",
+			mode:     OutputMinify,
+			input:    "<!--[if IE]><p>ie</p><![endif]-->",
+			expected: "<!--[if IE]><p>ie</p><![endif]-->",
+		},
+		{
+			name:     "raw text elements untouched",
+			mode:     OutputMinify,
+			input:    "<PRE>a\n\n  b</PRE>\n\n<script>var s = 'x  y';\n</script>  <p>c</p>",
+			expected: "<PRE>a\n\n  b</PRE> <script>var s = 'x  y';\n</script> <p>c</p>",
+		},
+		{
+			name:     "non-ASCII text before raw text elements",
+			mode:     OutputMinify,
+			input:    strings.Repeat("\u212a", 20) + "\n\n<pre>a\n  b</Pre>\n\n<p>\u00e9</p>",
+			expected: strings.Repeat("\u212a", 20) + " <pre>a\n  b</Pre> <p>\u00e9</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, formatOutput(tt.input, tt.mode))
+		})
+	}
+}
+
+// TestProcessor_OutputNonASCII tests that minifying pages whose text changes byte
+// length when lowercased, such as the Kelvin sign, does not panic
+func TestProcessor_OutputNonASCII(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Output: OutputMinify})
+	page := strings.Repeat("\u212a", 20) + `<esi:comment text="x"/><pre>x  y</pre>  <p>z</p>`
+	result, err := processor.Process(page, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<pre>x  y</pre> <p>z</p>")
+}
+
+func TestProcessor_Output(t *testing.T) {
+	html := "<div>\n<esi:remove>fallback</esi:remove>\n<esi:comment text=\"x\"></esi:comment>\n<p>kept</p>\n</div>"
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Output: OutputCollapse})
+	result, err := processor.Process(html, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<div>\n<p>kept</p>\n</div>")
+
+	result, err = processor.Process(html, ProcessContext{PreserveOutput: true})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<div>\n\n\n<p>kept</p>\n</div>")
+
+	processor = NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Output: OutputMinify})
+	result, err = processor.Process(html, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<div> <p>kept</p> </div>")
+}
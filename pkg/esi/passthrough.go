@@ -0,0 +1,95 @@
+package esi
+
+import (
+	"mime"
+	"strings"
+)
+
+// DefaultProcessContentTypes are the media types of the documents processed by default
+var DefaultProcessContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// SurrogateControlHeader is the header a page declares its ESI content in, such as
+// Surrogate-Control: content="ESI/1.0"
+const SurrogateControlHeader = "Surrogate-Control"
+
+// needsProcessing reports whether content has to go through the parse and execute
+// pipeline. A Surrogate-Control header decides on its own, processing only content it
+// declares as ESI; without one, content is scanned for ESI markup.
+func (p *Processor) needsProcessing(content string, context ProcessContext) bool {
+	if control := headerValue(context.Headers, SurrogateControlHeader); control != "" {
+		return strings.Contains(strings.ToUpper(control), "ESI/1.0")
+	}
+
+	// Akamai modes also expand variables outside esi:vars
+	return HasESIMarkup(content) || (p.akamaiEnabled() && strings.Contains(content, "$("))
+}
+
+// HasESIMarkup reports whether content contains an ESI start or end tag, with or
+// without the esi: prefix and in any case, or an <!--esi block. It errs on the side
+// of true, such as for ESI tags within scripts.
+func HasESIMarkup(content string) bool {
+	for i := strings.IndexByte(content, '<'); i >= 0; i = nextTag(content, i) {
+		rest := content[i+1:]
+		if strings.HasPrefix(rest, "!--esi") {
+			return true
+		}
+		rest = strings.TrimPrefix(rest, "/")
+
+		end := 0
+		for end < len(rest) && isTagNameByte(rest[end]) {
+			end++
+		}
+		if name := strings.ToLower(rest[:end]); isESIElement(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextTag returns the index of the next < after i, or -1
+func nextTag(content string, i int) int {
+	next := strings.IndexByte(content[i+1:], '<')
+	if next < 0 {
+		return -1
+	}
+	return i + 1 + next
+}
+
+// isTagNameByte reports whether b can be part of an ESI tag name
+func isTagNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == ':'
+}
+
+// ShouldProcess reports whether a document of contentType is processed for a request.
+// Documents of media types outside ProcessContentTypes and the responses to Range
+// requests, which must keep their bytes where the range expects them, pass through
+// untouched. Documents without a content type are treated as HTML.
+func (p *Processor) ShouldProcess(contentType string, context ProcessContext) bool {
+	if headerValue(context.Headers, "Range") != "" {
+		return false
+	}
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	contentTypes := p.config.ProcessContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultProcessContentTypes
+	}
+	for _, allowed := range contentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// passThrough counts a document returned untouched
+func (p *Processor) passThrough() {
+	p.stats.count(&p.stats.passThrough, func(slot *windowSlot) { slot.passThrough++ })
+}
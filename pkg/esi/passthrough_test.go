@@ -0,0 +1,135 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasESIMarkup(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected bool
+	}{
+		{"", false},
+		{"<html><body><p>Plain</p><!-- note --></body></html>", false},
+		{"<p>a < b, <i>esi</i> and includes</p>", false},
+		{`<esi:include src="/a"/>`, true},
+		{`<ESI:Include src="/a"/>`, true},
+		{"<!--esi <p>x</p> -->", true},
+		{"<vars>$(HTTP_HOST)</vars>", true},
+		{"a</esi:choose>b", true},
+		{"<p>unterminated <", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, HasESIMarkup(tt.content), "%q", tt.content)
+	}
+}
+
+func TestProcessor_PassThrough(t *testing.T) {
+	plain := "<p>Plain   content</p>\n"
+
+	t.Run("content without markup is untouched", func(t *testing.T) {
+		processor := NewProcessor(Config{Mode: "fastly", MaxIncludes: 10})
+
+		result, err := processor.Process(plain, ProcessContext{})
+		require.NoError(t, err)
+		assert.Equal(t, plain, result)
+
+		result, err = processor.Process("<p>a</p><esi:remove>b</esi:remove>", ProcessContext{})
+		require.NoError(t, err)
+		assert.Equal(t, "<html><head></head><body><p>a</p></body></html>", result)
+
+		stats := processor.GetStats()
+		assert.Equal(t, int64(2), stats.Requests)
+		assert.Equal(t, int64(1), stats.PassThrough)
+		assert.Equal(t, int64(1), stats.TemplateCacheMiss)
+	})
+
+	t.Run("akamai modes process variables outside esi:vars", func(t *testing.T) {
+		processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+
+		result, err := processor.Process("<p>$(HTTP_HOST)</p>", ProcessContext{Headers: map[string]string{"Host": "example.com"}})
+		require.NoError(t, err)
+		assert.Contains(t, result, "<p>example.com</p>")
+		assert.Zero(t, processor.GetStats().PassThrough)
+	})
+
+	t.Run("Surrogate-Control decides", func(t *testing.T) {
+		processor := NewProcessor(Config{Mode: "fastly", MaxIncludes: 10})
+		markup := "<p>a</p><esi:remove>b</esi:remove>"
+
+		result, err := processor.Process(markup, ProcessContext{Headers: map[string]string{"Surrogate-Control": "max-age=300"}})
+		require.NoError(t, err)
+		assert.Equal(t, markup, result)
+
+		result, err = processor.Process(plain, ProcessContext{Headers: map[string]string{"surrogate-control": `content="ESI/1.0"`}})
+		require.NoError(t, err)
+		assert.Contains(t, result, "<body><p>Plain   content</p>")
+
+		assert.Equal(t, int64(1), processor.GetStats().PassThrough)
+	})
+}
+
+func TestProcessor_ShouldProcess(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+	custom := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, ProcessContentTypes: []string{"text/*"}})
+
+	tests := []struct {
+		processor   *Processor
+		contentType string
+		headers     map[string]string
+		expected    bool
+	}{
+		{processor, "", nil, true},
+		{processor, "text/html; charset=utf-8", nil, true},
+		{processor, "TEXT/HTML", nil, true},
+		{processor, "application/xhtml+xml", nil, true},
+		{processor, "text/css", nil, false},
+		{processor, "image/png", nil, false},
+		{processor, "text/html", map[string]string{"range": "bytes=0-99"}, false},
+		{processor, "not a type;;", nil, false},
+		{custom, "text/css", nil, true},
+		{custom, "application/xhtml+xml", nil, false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.processor.ShouldProcess(tt.contentType, ProcessContext{Headers: tt.headers}),
+			"%q with %v", tt.contentType, tt.headers)
+	}
+}
+
+func TestProcessBytes_PassThrough(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+	content := []byte("body { content: '<esi:include src=\"/a\"/>'; }")
+
+	output, name, err := processor.ProcessBytes(content, "text/css", ProcessContext{})
+	require.NoError(t, err)
+	assert.Equal(t, content, output)
+	assert.Empty(t, name)
+	assert.Equal(t, int64(1), processor.GetStats().PassThrough)
+	assert.Zero(t, processor.GetStats().Requests)
+}
+
+func TestProcessor_IncludesIgnoreRange(t *testing.T) {
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range")+r.Header.Get("If-Range"))
+		w.Write([]byte("<p>Fragment</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 1, BaseURL: server.URL})
+	result, err := processor.Process(`<esi:include src="/fragment"/>`, ProcessContext{
+		BaseURL: server.URL,
+		Headers: map[string]string{"Range": "bytes=0-9", "If-Range": `"v1"`},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, result, "<p>Fragment</p>")
+	assert.Equal(t, []string{""}, ranges)
+}
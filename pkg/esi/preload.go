@@ -0,0 +1,61 @@
+package esi
+
+import "strings"
+
+// PreloadLinks returns the URLs of the first Config.PreloadHints includes of a page, in
+// document order and resolved against the request's base URL, for the page's
+// Link: <url>; rel=preload headers. Hints are known before the page is processed, so
+// only includes with a static src are hinted: src with variables, includes inside
+// esi:remove or esi:comment and, in modes without them, <!--esi --> blocks are skipped.
+func (p *Processor) PreloadLinks(html string, context ProcessContext) []string {
+	limit := p.config.PreloadHints
+	if limit <= 0 || !HasESIMarkup(html) {
+		return nil
+	}
+	template, err := p.parseTemplate(html)
+	if err != nil {
+		return nil
+	}
+
+	var links []string
+	seen := make(map[string]bool)
+	template.Walk(func(n *Node) bool {
+		if len(links) == limit {
+			return false
+		}
+		switch {
+		case n.Type == CommentBlockNode:
+			return p.features.CommentBlocks
+		case n.Type != ElementNode:
+			return true
+		}
+
+		switch n.ESIName() {
+		case "remove", "comment":
+			return false
+		case "include":
+			src, _ := n.GetAttr("src")
+			if src == "" || strings.Contains(src, "$(") {
+				return false
+			}
+			resolved, err := p.resolveURL(src, context.BaseURL)
+			if err != nil || seen[resolved] {
+				return false
+			}
+			seen[resolved] = true
+			links = append(links, resolved)
+			return false
+		}
+		return true
+	})
+	return links
+}
+
+// PreloadHeader renders preload links as the value of a Link header
+func PreloadHeader(links []string) string {
+	values := make([]string, len(links))
+	for i, link := range links {
+		values[i] = "<" + link + ">; rel=preload"
+	}
+	return strings.Join(values, ", ")
+}
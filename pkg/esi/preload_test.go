@@ -0,0 +1,49 @@
+package esi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreloadLinks(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", PreloadHints: 3})
+	context := ProcessContext{BaseURL: "https://www.example.com/page"}
+
+	template := `<esi:remove><esi:include src="/removed" /></esi:remove>
+<esi:include src="/header" />
+<esi:include src="/user/$(HTTP_COOKIE{id})" />
+<esi:choose><esi:when test="1==1"><esi:include src="https://cdn.example.com/a" /></esi:when></esi:choose>
+<esi:include src="/header" />
+<!--esi <esi:include src="/nav" /> -->
+<esi:include src="/footer" />`
+
+	assert.Equal(t, []string{
+		"https://www.example.com/header",
+		"https://cdn.example.com/a",
+		"https://www.example.com/nav",
+	}, processor.PreloadLinks(template, context))
+
+	// Fastly does not process comment blocks
+	fastly := NewProcessor(Config{Mode: "fastly", PreloadHints: 3})
+	assert.Equal(t, []string{
+		"https://www.example.com/header",
+		"https://cdn.example.com/a",
+		"https://www.example.com/footer",
+	}, fastly.PreloadLinks(template, context))
+
+	assert.Empty(t, NewProcessor(Config{Mode: "akamai"}).PreloadLinks(template, context))
+	assert.Empty(t, processor.PreloadLinks("<p>no includes</p>", context))
+}
+
+func TestProcess_PreloadHeader(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxDepth: 1, PreloadHints: 2})
+	processor.client = &http.Client{Transport: mockTransport(map[string]string{"/a": "A", "/b": "B"})}
+
+	context := ProcessContext{BaseURL: "http://localhost", Response: NewResponseMeta()}
+	_, err := processor.Process(`<esi:include src="/a" /><esi:include src="/b" /><esi:include src="/c" onerror="continue" />`, context)
+	require.NoError(t, err)
+	assert.Equal(t, "<http://localhost/a>; rel=preload, <http://localhost/b>; rel=preload", context.Response.Headers.Get("Link"))
+}
@@ -0,0 +1,304 @@
+package esi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPrerenderMinTTL is the shortest TTL an include may have to be pre-rendered
+const DefaultPrerenderMinTTL = time.Hour
+
+// PrerenderOptions configures Prerender
+type PrerenderOptions struct {
+	// BaseURL resolves relative includes; it defaults to DefaultComparisonBaseURL
+	BaseURL string
+	// MinTTL is the shortest TTL of the includes inlined; zero selects DefaultPrerenderMinTTL
+	MinTTL time.Duration
+	// Includes mocks include responses by absolute URL or by path and query, as in
+	// ModeComparisonOptions, and then includes not mocked stay dynamic; when nil,
+	// includes are fetched over HTTP
+	Includes map[string]string
+	// MaxDepth bounds how deeply inlined fragments are pre-rendered; zero selects 5
+	MaxDepth int
+}
+
+// PrerenderedInclude is an include of a pre-rendered template
+type PrerenderedInclude struct {
+	Src   string `json:"src"`
+	Line  int    `json:"line"`            // Line of the include in its template or fragment
+	Depth int    `json:"depth,omitempty"` // Zero for the template, one for its fragments and so on
+	// TTLSeconds is the include's TTL, from its ttl attribute or the fragment's Cache-Control
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	// Size is the length of the inlined content
+	Size int `json:"size,omitempty"`
+	// Reason says why the include stays dynamic
+	Reason string `json:"reason,omitempty"`
+}
+
+// PrerenderReport lists the includes Prerender inlined and those it left as ESI
+type PrerenderReport struct {
+	Inlined []PrerenderedInclude `json:"inlined"`
+	Dynamic []PrerenderedInclude `json:"dynamic"`
+}
+
+// prerenderer flattens a template and the fragments inlined into it
+type prerenderer struct {
+	options PrerenderOptions
+	client  *http.Client
+	report  *PrerenderReport
+}
+
+// Prerender resolves the static includes of a template at build time: GET includes
+// without variables, request headers or a body that are marked cacheable with a TTL of
+// at least MinTTL. The TTL is the include's ttl attribute (such as 3600, 90m or 1d), or
+// for cacheable="true" the max-age of the fragment's Cache-Control. Static includes are
+// replaced by their content, itself pre-rendered; every other include, and anything in
+// <!--esi --> blocks, is left as written. The report lists what was inlined and why the
+// remaining includes stay dynamic.
+func Prerender(template string, options PrerenderOptions) (string, *PrerenderReport, error) {
+	if options.BaseURL == "" {
+		options.BaseURL = DefaultComparisonBaseURL
+	}
+	if options.MinTTL == 0 {
+		options.MinTTL = DefaultPrerenderMinTTL
+	}
+	if options.MaxDepth == 0 {
+		options.MaxDepth = 5
+	}
+
+	p := &prerenderer{
+		options: options,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		report:  &PrerenderReport{Inlined: []PrerenderedInclude{}, Dynamic: []PrerenderedInclude{}},
+	}
+
+	output, err := p.prerender(template, 0)
+	if err != nil {
+		return template, nil, err
+	}
+	return output, p.report, nil
+}
+
+// prerender flattens content found depth includes deep
+func (p *prerenderer) prerender(content string, depth int) (string, error) {
+	parsed, err := ParseTemplate(content)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := p.render(&out, parsed.Nodes, depth); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// render writes nodes as written, with their static includes inlined
+func (p *prerenderer) render(out *strings.Builder, nodes []*Node, depth int) error {
+	for _, n := range nodes {
+		switch {
+		case n.Type == TextNode:
+			out.WriteString(n.Text)
+		case n.Type == CommentBlockNode:
+			walkNodes(n.Children, func(child *Node) bool {
+				if child.Type == ElementNode && child.ESIName() == "include" {
+					src, _ := child.GetAttr("src")
+					p.dynamic(src, child.Line, depth, "inside an <!--esi --> block")
+				}
+				return true
+			})
+			out.WriteString(n.Text)
+		case n.ESIName() == "include":
+			flattened, inlined, err := p.include(n, depth)
+			if err != nil {
+				return err
+			}
+			if inlined {
+				out.WriteString(flattened)
+				continue
+			}
+			out.WriteString(n.startTag)
+			if err := p.render(out, n.Children, depth); err != nil {
+				return err
+			}
+			out.WriteString(n.endTag)
+		default:
+			out.WriteString(n.startTag)
+			if err := p.render(out, n.Children, depth); err != nil {
+				return err
+			}
+			out.WriteString(n.endTag)
+		}
+	}
+	return nil
+}
+
+// include returns the pre-rendered content of the include n and whether it is static
+func (p *prerenderer) include(n *Node, depth int) (string, bool, error) {
+	src, _ := n.GetAttr("src")
+	if reason := staticIncludeReason(n, src); reason != "" {
+		p.dynamic(src, n.Line, depth, reason)
+		return "", false, nil
+	}
+	if depth >= p.options.MaxDepth {
+		p.dynamic(src, n.Line, depth, fmt.Sprintf("deeper than %d includes", p.options.MaxDepth))
+		return "", false, nil
+	}
+
+	ttl, marked := includeTTL(n)
+	content, header, err := p.fetch(src)
+	if err != nil {
+		p.dynamic(src, n.Line, depth, err.Error())
+		return "", false, nil
+	}
+	if !marked {
+		ttl = maxAge(header)
+	}
+	if ttl < p.options.MinTTL {
+		p.report.Dynamic = append(p.report.Dynamic, PrerenderedInclude{
+			Src: src, Line: n.Line, Depth: depth, TTLSeconds: int(ttl.Seconds()),
+			Reason: fmt.Sprintf("TTL below %s", p.options.MinTTL),
+		})
+		return "", false, nil
+	}
+
+	flattened, err := p.prerender(content, depth+1)
+	if err != nil {
+		return "", false, fmt.Errorf("fragment %s: %w", src, err)
+	}
+	p.report.Inlined = append(p.report.Inlined, PrerenderedInclude{
+		Src: src, Line: n.Line, Depth: depth, TTLSeconds: int(ttl.Seconds()), Size: len(flattened),
+	})
+	return flattened, true, nil
+}
+
+// dynamic reports an include left as ESI
+func (p *prerenderer) dynamic(src string, line, depth int, reason string) {
+	p.report.Dynamic = append(p.report.Dynamic, PrerenderedInclude{Src: src, Line: line, Depth: depth, Reason: reason})
+}
+
+// fetch fetches the fragment at src
+func (p *prerenderer) fetch(src string) (string, http.Header, error) {
+	base, err := url.Parse(p.options.BaseURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	ref, err := url.Parse(src)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid src: %w", err)
+	}
+
+	resolved := base.ResolveReference(ref)
+	if p.options.Includes != nil {
+		content, exists := p.options.Includes[resolved.String()]
+		if !exists {
+			content, exists = p.options.Includes[resolved.RequestURI()]
+		}
+		if !exists {
+			return "", nil, fmt.Errorf("%s is not mocked", resolved)
+		}
+		return content, http.Header{}, nil
+	}
+
+	resp, err := p.client.Get(resolved.String())
+	if err != nil {
+		return "", nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", nil, fmt.Errorf("fetch failed: HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	return string(body), resp.Header, nil
+}
+
+// staticIncludeReason returns why the include n of src cannot be pre-rendered, or ""
+// when it can if its TTL is long enough
+func staticIncludeReason(n *Node, src string) string {
+	switch {
+	case src == "":
+		return "no src"
+	case strings.Contains(src, "$("):
+		return "src uses variables"
+	}
+	if method, ok := n.GetAttr("method"); ok && !strings.EqualFold(method, http.MethodGet) {
+		return "not a GET include"
+	}
+	for _, attr := range []string{"setheader", "entity"} {
+		if _, ok := n.GetAttr(attr); ok {
+			return attr + " attribute"
+		}
+	}
+	if noStore, _ := n.GetAttr("no-store"); noStore == "on" || noStore == "true" {
+		return "marked no-store"
+	}
+	if _, marked := includeTTL(n); marked {
+		return ""
+	}
+	if cacheable, _ := n.GetAttr("cacheable"); cacheable != "true" {
+		return "not marked cacheable"
+	}
+	return ""
+}
+
+// includeTTL returns the TTL of the ttl attribute of n and whether it has a valid one
+func includeTTL(n *Node) (time.Duration, bool) {
+	value, ok := n.GetAttr("ttl")
+	if !ok {
+		return 0, false
+	}
+	return parseESITTL(value)
+}
+
+// parseESITTL parses a TTL in seconds, optionally suffixed with s, m, h or d
+func parseESITTL(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	unit := time.Second
+	if value != "" {
+		switch value[len(value)-1] {
+		case 's':
+			value = value[:len(value)-1]
+		case 'm':
+			unit, value = time.Minute, value[:len(value)-1]
+		case 'h':
+			unit, value = time.Hour, value[:len(value)-1]
+		case 'd':
+			unit, value = 24*time.Hour, value[:len(value)-1]
+		}
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0, false
+	}
+	return time.Duration(count) * unit, true
+}
+
+// maxAge returns the s-maxage, or else the max-age, of a Cache-Control header; zero
+// when the response is private, no-store or no-cache
+func maxAge(header http.Header) time.Duration {
+	var age, sharedAge time.Duration
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "private", "no-store", "no-cache":
+			return 0
+		case "max-age":
+			seconds, _ := strconv.Atoi(value)
+			age = time.Duration(seconds) * time.Second
+		case "s-maxage":
+			seconds, _ := strconv.Atoi(value)
+			sharedAge = time.Duration(seconds) * time.Second
+		}
+	}
+	if sharedAge > 0 {
+		return sharedAge
+	}
+	return age
+}
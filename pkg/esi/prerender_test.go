@@ -0,0 +1,89 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrerender_InlinesStaticIncludes(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+			w.Write([]byte(`<header><esi:include src="/nav" ttl="1d"/><esi:include src="/user/$(HTTP_COOKIE{id})"/></header>`))
+		case "/nav":
+			w.Write([]byte("<nav>menu</nav>"))
+		case "/promo":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("<p>promo</p>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	template := "<html>\n" +
+		`<esi:include src="/header" cacheable="true"/>` + "\n" +
+		`<esi:include src="/promo" cacheable="true"/>` + "\n" +
+		`<esi:include src="/cart" method="POST" ttl="1d"/>` + "\n" +
+		`<esi:include src="/footer"/>` + "\n" +
+		`<!--esi <esi:include src="/nav" ttl="1d"/> -->` + "\n" +
+		`<esi:choose><esi:when test="1==1"><esi:include src="/nav" ttl="2h"></esi:include></esi:when></esi:choose>` + "\n</html>"
+
+	output, report, err := Prerender(template, PrerenderOptions{BaseURL: origin.URL})
+	require.NoError(t, err)
+	assert.Equal(t, "<html>\n"+
+		`<header><nav>menu</nav><esi:include src="/user/$(HTTP_COOKIE{id})"/></header>`+"\n"+
+		`<esi:include src="/promo" cacheable="true"/>`+"\n"+
+		`<esi:include src="/cart" method="POST" ttl="1d"/>`+"\n"+
+		`<esi:include src="/footer"/>`+"\n"+
+		`<!--esi <esi:include src="/nav" ttl="1d"/> -->`+"\n"+
+		`<esi:choose><esi:when test="1==1"><nav>menu</nav></esi:when></esi:choose>`+"\n</html>", output)
+
+	assert.Equal(t, []PrerenderedInclude{
+		{Src: "/nav", Line: 1, Depth: 1, TTLSeconds: 86400, Size: len("<nav>menu</nav>")},
+		{Src: "/header", Line: 2, TTLSeconds: 86400, Size: len(`<header><nav>menu</nav><esi:include src="/user/$(HTTP_COOKIE{id})"/></header>`)},
+		{Src: "/nav", Line: 7, TTLSeconds: 7200, Size: len("<nav>menu</nav>")},
+	}, report.Inlined)
+
+	reasons := map[string]string{}
+	for _, include := range report.Dynamic {
+		reasons[include.Src] = include.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"/user/$(HTTP_COOKIE{id})": "src uses variables",
+		"/promo":                   "TTL below 1h0m0s",
+		"/cart":                    "not a GET include",
+		"/footer":                  "not marked cacheable",
+		"/nav":                     "inside an <!--esi --> block",
+	}, reasons)
+}
+
+func TestPrerender_MockedIncludes(t *testing.T) {
+	output, report, err := Prerender(`<esi:include src="/a" ttl="30m"/><esi:include src="/b" ttl="30m"/>`, PrerenderOptions{
+		MinTTL:   10 * time.Minute,
+		Includes: map[string]string{"/a": "<p>a</p>"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `<p>a</p><esi:include src="/b" ttl="30m"/>`, output)
+	require.Len(t, report.Dynamic, 1)
+	assert.Equal(t, "http://localhost/b is not mocked", report.Dynamic[0].Reason)
+}
+
+func TestParseESITTL(t *testing.T) {
+	tests := map[string]time.Duration{"3600": time.Hour, "90s": 90 * time.Second, "15m": 15 * time.Minute, "2h": 2 * time.Hour, "1d": 24 * time.Hour}
+	for value, want := range tests {
+		got, ok := parseESITTL(value)
+		assert.True(t, ok, value)
+		assert.Equal(t, want, got, value)
+	}
+	for _, value := range []string{"", "soon", "-5m"} {
+		_, ok := parseESITTL(value)
+		assert.False(t, ok, value)
+	}
+}
@@ -8,21 +8,75 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
 )
 
 // Config holds the ESI processor configuration
 type Config struct {
-	Mode        string      `json:"mode"`        // fastly, akamai, w3c, development
+	Mode        string      `json:"mode"`        // fastly, akamai, w3c, development or a registered mode
 	Debug       bool        `json:"debug"`       // Enable debug logging
 	MaxIncludes int         `json:"maxIncludes"` // Maximum number of includes per request
 	MaxDepth    int         `json:"maxDepth"`    // Maximum include depth
 	BaseURL     string      `json:"baseUrl"`     // Base URL for relative includes
 	Cache       CacheConfig `json:"cache"`       // Cache configuration
+	// RequestIDHeader carries the request ID on include requests; empty selects DefaultRequestIDHeader
+	RequestIDHeader string `json:"requestIdHeader,omitempty"`
+	// SlowIncludeMS is the fetch time from which includes are sampled as slow; zero selects DefaultSlowIncludeThreshold
+	SlowIncludeMS int `json:"slowIncludeMs,omitempty"`
+	// Faults injects latency, dropped connections and error statuses into include fetches
+	Faults FaultConfig `json:"faults,omitempty"`
+	// Output formats the processed HTML (preserve, collapse or minify); empty selects OutputPreserve
+	Output string `json:"output,omitempty"`
+	// TemplateCacheSize is the number of parsed templates kept by content hash; zero selects
+	// DefaultTemplateCacheSize and a negative size disables the template cache
+	TemplateCacheSize int `json:"templateCacheSize,omitempty"`
+	// GeoHeaderPrefix enables request headers such as X-Emulator-Geo-Country to override the
+	// geo variables; empty disables the override
+	GeoHeaderPrefix string `json:"geoHeaderPrefix,omitempty"`
+	// ProcessContentTypes are the media types of the documents ProcessBytes processes, such
+	// as text/html or text/*; empty selects DefaultProcessContentTypes
+	ProcessContentTypes []string `json:"processContentTypes,omitempty"`
+	// Sanitize strips script-injecting constructs from the fragments of untrusted hosts
+	// before they are processed
+	Sanitize SanitizeConfig `json:"sanitize,omitempty"`
+	// StatsWindows keeps per-second counters for the rolling one- and five-minute windows
+	// reported by GetStatsWindows
+	StatsWindows bool `json:"statsWindows,omitempty"`
+	// MaxProcessingTime bounds the wall-clock time of processing a page. Once it is spent
+	// no new include fetches are issued: the remaining includes are answered from the
+	// cache or substituted by their alt or esi:except content. Zero disables the budget.
+	MaxProcessingTime time.Duration `json:"maxProcessingTime,omitempty"`
+	// URLSigning signs fragment URLs for the sign_url function, for origins that only
+	// serve signed URLs
+	URLSigning URLSigningConfig `json:"urlSigning,omitempty"`
+	// TLS configures the include fetches of hosts with a private PKI or mTLS; the first
+	// settings matching a host apply
+	TLS []OriginTLS `json:"tls,omitempty"`
+	// Resolve connects include fetches to other addresses, like curl's --resolve: it maps
+	// a host or host:port to an address or address:port, so templates naming production
+	// hosts can run against local or staging origins
+	Resolve map[string]string `json:"resolve,omitempty"`
+	// Rewrites rewrite the URLs of includes, src and alt, before they are fetched; every
+	// rule applies in order
+	Rewrites []RewriteRule `json:"rewrites,omitempty"`
+	// Experiments are the A/B experiments whose variants $(EXPERIMENT{name}) returns
+	Experiments []experiment.Experiment `json:"experiments,omitempty"`
+	// EdgeData is the personalization data read by $(EDGE_DATA{key}) and esi:lookup
+	EdgeData EdgeDataConfig `json:"edgeData,omitempty"`
+	// ErrorPage replaces pages whose includes fail past its policy with an error page
+	ErrorPage ErrorPageConfig `json:"errorPage,omitempty"`
+	// PreloadHints is the number of include URLs, the first of the page, sent back in a
+	// Link rel=preload header for downstream prefetching; zero sends none
+	PreloadHints int `json:"preloadHints,omitempty"`
 }
 
+// DefaultRequestIDHeader is the header include requests carry the request ID in by default
+const DefaultRequestIDHeader = "X-Request-ID"
+
 // CacheConfig holds cache-related configuration
 type CacheConfig struct {
 	Enabled bool `json:"enabled"` // Whether caching is enabled
@@ -46,108 +100,263 @@ type Features struct {
 	Eval         bool `json:"eval"`         // <esi:eval> - Expression evaluation
 	Function     bool `json:"function"`     // <esi:function> - Built-in functions
 	Dictionary   bool `json:"dictionary"`   // <esi:dictionary> - Key-value lookups
+	Lookup       bool `json:"lookup"`       // <esi:lookup> - Edge data lookups
+	Groups       bool `json:"groups"`       // <esi:parallel>/<esi:sequential> - Include ordering
 	Debug        bool `json:"debug"`        // <esi:debug> - Debug output
 	GeoVariables bool `json:"geoVariables"` // Geo-location variables
 	ExtendedVars bool `json:"extendedVars"` // Extended variable set
 }
 
-// Stats holds processing statistics
+// Stats is a snapshot of the processing statistics; it is a plain value, safe to copy
+// and compare
 type Stats struct {
 	Requests  int64 `json:"requests"`
 	CacheHits int64 `json:"cacheHits"`
 	CacheMiss int64 `json:"cacheMiss"`
 	Errors    int64 `json:"errors"`
 	TotalTime int64 `json:"totalTime"` // Total processing time in milliseconds
-	mutex     sync.RWMutex
+
+	// Conditional refetches of expired fragments and the ones answered 304 Not Modified
+	Revalidations int64 `json:"revalidations"`
+	NotModified   int64 `json:"notModified"`
+
+	// Template parses answered by the parsed-template cache and the ones that were parsed
+	TemplateCacheHits int64 `json:"templateCacheHits"`
+	TemplateCacheMiss int64 `json:"templateCacheMiss"`
+
+	// Documents returned untouched: without ESI markup, of unprocessed media types or ranged
+	PassThrough int64 `json:"passThrough"`
+
+	// Fragments of untrusted hosts the sanitizer changed
+	Sanitized int64 `json:"sanitized"`
+
+	// Pages whose includes were substituted because MaxProcessingTime was spent
+	Truncated int64 `json:"truncated"`
+
+	// Pages replaced by the error page because their includes failed
+	ErrorPages int64 `json:"errorPages"`
+
+	// Bytes buffered per request and buffer pool use
+	Memory MemoryStats `json:"memory"`
+
+	// Latency breakdown
+	ProcessingTime   Histogram            `json:"processingTime"`   // Processing time per request
+	IncludeFetchTime Histogram            `json:"includeFetchTime"` // Fetch time per include, cache hits excluded
+	IncludeHosts     map[string]HostStats `json:"includeHosts"`     // Include fetches by host
+	SlowIncludes     []IncludeSample      `json:"slowIncludes"`     // Most recent slow include fetches, oldest first
 }
 
 // CacheEntry represents a cached fragment
 type CacheEntry struct {
 	Content   string    `json:"content"`
 	ExpiresAt time.Time `json:"expiresAt"`
+	StoredAt  time.Time `json:"storedAt"`
+	Hits      int64     `json:"hits"` // Lookups answered by this entry
+	// Vary holds the request header values of the headers the fragment's Vary response header names
+	Vary map[string]string `json:"vary,omitempty"`
+	// Validators sent to revalidate the entry once it expires
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Tags select the entry for purges, from the fragment's Surrogate-Key or cache tag headers
+	Tags []string `json:"tags,omitempty"`
+	// SoftPurged is set when a soft purge marked the entry stale
+	SoftPurged bool `json:"softPurged,omitempty"`
+	// Header holds the fragment's response headers, read by $(INCLUDE_HDR{name})
+	Header http.Header `json:"header,omitempty"`
 }
 
 // ProcessContext holds context for ESI processing
 type ProcessContext struct {
-	BaseURL string            `json:"baseUrl"`
-	Headers map[string]string `json:"headers"`
-	Cookies map[string]string `json:"cookies"`
-	Depth   int               `json:"depth"`
+	BaseURL  string            `json:"baseUrl"`
+	Headers  map[string]string `json:"headers"`
+	Cookies  map[string]string `json:"cookies"`
+	Depth    int               `json:"depth"`
+	Response *ResponseMeta     `json:"-"` // Response metadata set by ESI built-ins (optional)
+	// RequestID correlates include requests with the request being processed (optional)
+	RequestID string `json:"requestId,omitempty"`
+	// PreserveOutput skips output formatting for this request, keeping the exact rendering for debugging
+	PreserveOutput bool `json:"preserveOutput,omitempty"`
+	// NoCache bypasses the fragment cache for this request: includes are neither served
+	// from it nor stored in it, as when the page's property marks it no-store
+	NoCache bool `json:"noCache,omitempty"`
+	// Variables, when not nil, holds the page's variables: they are visible as if
+	// assigned at the top of the page, and the page's assignments are stored in it, so a
+	// caller can carry them over to later pages
+	Variables map[string]string `json:"variables,omitempty"`
+	// Data is synthetic request data, such as a user segment or feature flags, that
+	// $(CTX{key}) reads in every mode, so tests need not fabricate cookies or headers.
+	// Keys are members of the object or dotted paths into it.
+	Data map[string]interface{} `json:"data,omitempty"`
+
+	scope    *variableScope    // Variables assigned by esi:assign, from the page or fragment being executed
+	budget   *processingBudget // Wall-clock time left for the page, shared with its fragments
+	outcomes *includeOutcomes  // Include failures of the page, shared with its fragments
+	// Response of the latest include of the page or fragment, recorded by the fetch
+	lastInclude *includeResponse
+	memory      *memoryAccount // Bytes buffered for the page and its fragments
+}
+
+// ResponseMeta collects response metadata set by ESI built-in functions during processing
+type ResponseMeta struct {
+	StatusCode int         `json:"statusCode,omitempty"`
+	Headers    http.Header `json:"headers,omitempty"`
+	// Truncated is set when includes were substituted because the processing budget was spent
+	Truncated bool `json:"truncated,omitempty"`
+	// Includes counts the includes fetched or read from the fragment cache, and
+	// CachedIncludes those read from the cache
+	Includes       int `json:"includes,omitempty"`
+	CachedIncludes int `json:"cachedIncludes,omitempty"`
+	// ErrorPage says why the page was replaced by the error page of Config.ErrorPage,
+	// when it was
+	ErrorPage string `json:"errorPage,omitempty"`
+}
+
+// NewResponseMeta creates an empty response metadata collector
+func NewResponseMeta() *ResponseMeta {
+	return &ResponseMeta{
+		Headers: make(http.Header),
+	}
+}
+
+// SetRedirect records a redirect to location with the given status code
+func (r *ResponseMeta) SetRedirect(location string, statusCode int) {
+	r.StatusCode = statusCode
+	r.Headers.Set("Location", location)
+}
+
+// AddHeader records a response header, after any values already recorded for name
+func (r *ResponseMeta) AddHeader(name, value string) {
+	r.Headers.Add(name, value)
+}
+
+// SetHeader records a response header, replacing any values recorded for name
+func (r *ResponseMeta) SetHeader(name, value string) {
+	r.Headers.Set(name, value)
+}
+
+// countInclude counts an include of the response, read from the fragment cache when cached
+func (r *ResponseMeta) countInclude(cached bool) {
+	if r == nil {
+		return
+	}
+	r.Includes++
+	if cached {
+		r.CachedIncludes++
+	}
+}
+
+// addIncludes adds the includes counted in other
+func (r *ResponseMeta) addIncludes(other *ResponseMeta) {
+	if r == nil {
+		return
+	}
+	r.Includes += other.Includes
+	r.CachedIncludes += other.CachedIncludes
 }
 
 // Processor is the main ESI processing engine
 type Processor struct {
-	config    Config
-	features  Features
-	stats     Stats
-	cache     map[string]CacheEntry
-	mutex     sync.RWMutex
-	client    *http.Client
-	akamaiExt *AkamaiExtensions // Akamai extensions handler
+	config          Config
+	mode            Mode             // The ESI dialect of config.Mode
+	features        Features         // The features of the mode
+	resolveVariable VariableResolver // The mode's own variables; nil without any
+	stats           statsRecorder
+	cache           map[string]CacheEntry
+	mutex           sync.RWMutex
+	client          *http.Client
+	faults          *faultTransport                   // Fault injection in front of the client's transport
+	templates       *templateCache                    // Parsed templates by content hash
+	rewrites        []compiledRewrite                 // Include URL rewrite rules; invalid rules are skipped
+	akamaiExt       *AkamaiExtensions                 // Akamai extensions handler
+	edgeData        atomic.Pointer[edgeDataConnector] // Edge data lookups; nil without a source
+	debug           atomic.Bool                       // Debug output, changeable at runtime with SetDebug
+
+	hooks      hooks        // Lifecycle hooks registered with the On* methods
+	hooksMutex sync.RWMutex // Guards hooks
 }
 
 // NewProcessor creates a new ESI processor with the given configuration
 func NewProcessor(config Config) *Processor {
 	processor := &Processor{
-		config: config,
-		cache:  make(map[string]CacheEntry),
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		config:    config,
+		cache:     make(map[string]CacheEntry),
+		faults:    newFaultTransport(newOriginTLSTransport(newIncludeTransport(config.Resolve), config.TLS), config.Faults),
+		templates: newTemplateCache(config.TemplateCacheSize),
+		rewrites:  compileRewriteRules(config.Rewrites),
+	}
+	processor.stats.reset(config.StatsWindows)
+	processor.client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: processor.faults,
 	}
 
-	processor.features = processor.getSupportedFeatures()
+	if source := processor.configuredEdgeData(); source != nil {
+		processor.SetEdgeDataSource(source)
+	}
+
+	processor.debug.Store(config.Debug)
+	processor.mode = modeFor(config.Mode)
+	processor.features = processor.mode.Features()
+	processor.resolveVariable = processor.mode.VariableResolver()
 	processor.akamaiExt = NewAkamaiExtensions(processor) // Initialize Akamai extensions
 	return processor
 }
 
-// getSupportedFeatures returns the features supported by the current mode
-func (p *Processor) getSupportedFeatures() Features {
-	base := Features{
-		Include: true,
-		Comment: true,
-		Remove:  true,
-	}
-
-	switch p.config.Mode {
-	case "fastly":
-		return base
-	case "akamai", "w3c", "development":
-		return Features{
-			Include:       true,
-			Comment:       true,
-			Remove:        true,
-			Inline:        true,
-			Choose:        true,
-			Try:           true,
-			Vars:          true,
-			Variables:     true,
-			Expressions:   true,
-			CommentBlocks: true,
-			Assign:        true,
-			Eval:          true,
-			Function:      true,
-			Dictionary:    true,
-			Debug:         true,
-			GeoVariables:  true,
-			ExtendedVars:  true,
+// Process processes ESI content and returns the processed HTML, running the
+// BeforeProcess and AfterProcess hooks around it and the AfterRun hooks on pages
+func (p *Processor) Process(html string, context ProcessContext) (result string, err error) {
+	if context.scope == nil {
+		context.scope = newPageScope(context.Variables)
+	}
+	if context.budget == nil {
+		context.budget = p.newBudget(time.Now())
+	}
+	if context.outcomes == nil {
+		context.outcomes = &includeOutcomes{}
+	}
+	if context.memory == nil {
+		context.memory = &memoryAccount{}
+		defer func() { p.recordMemory(context.memory) }()
+	}
+	if hooks := p.registeredHooks().afterRun; context.Depth == 0 && len(hooks) > 0 {
+		page, start := html, time.Now()
+		defer func() { p.runAfterRun(hooks, page, start, context.outcomes, err) }()
+	}
+
+	html, err = p.runBeforeProcess(html, &context)
+	if err != nil {
+		p.incrementErrors()
+		return html, err
+	}
+
+	// Preload hints go out with the page, however its includes turn out
+	if context.Depth == 0 && context.Response != nil {
+		if links := p.PreloadLinks(html, context); len(links) > 0 {
+			context.Response.SetHeader("Link", PreloadHeader(links))
 		}
-	default:
-		return base
 	}
+
+	result, err = p.process(html, context)
+	if err != nil {
+		return result, err
+	}
+
+	result, err = p.runAfterProcess(result, context)
+	if err != nil {
+		p.incrementErrors()
+	}
+	return result, err
 }
 
-// Process processes ESI content and returns the processed HTML
-func (p *Processor) Process(html string, context ProcessContext) (string, error) {
+// process processes ESI content without running the process hooks
+func (p *Processor) process(html string, context ProcessContext) (string, error) {
 	startTime := time.Now()
 
-	p.stats.mutex.Lock()
-	p.stats.Requests++
-	p.stats.mutex.Unlock()
+	p.stats.count(&p.stats.requests, func(slot *windowSlot) { slot.requests++ })
 
-	if p.config.Debug {
-		fmt.Printf("🔄 Processing ESI content (mode: %s): %s...\n",
-			p.config.Mode, truncateString(html, 100))
+	if p.debugEnabled() {
+		fmt.Printf("🔄 Processing ESI content (mode: %s%s): %s...\n",
+			p.config.Mode, requestIDLabel(context), truncateString(html, 100))
 	}
 
 	// Check depth limit
@@ -155,22 +364,49 @@ func (p *Processor) Process(html string, context ProcessContext) (string, error)
 		return html, fmt.Errorf("maximum include depth exceeded: %d", p.config.MaxDepth)
 	}
 
-	// Process ESI comment blocks first (<!--esi ...-->)
-	if p.features.CommentBlocks {
-		html = p.processCommentBlocks(html, context)
+	// The mode translates its own markup before anything else looks at the content
+	preprocessed, err := p.mode.PreProcess(html, context)
+	if err != nil {
+		p.incrementErrors()
+		return html, fmt.Errorf("%s mode: %w", p.config.Mode, err)
+	}
+	html = preprocessed
+
+	// Content without ESI markup is returned untouched, skipping parsing and rendering
+	if !p.needsProcessing(html, context) {
+		p.passThrough()
+		p.recordProcessing(time.Since(startTime))
+
+		if p.debugEnabled() {
+			fmt.Printf("⏩ No ESI markup, passing content through%s\n", requestIDLabel(context))
+		}
+		return html, nil
 	}
 
-	// Parse HTML with goquery
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	// Parse the ESI template, then execute it for this request
+	template, err := p.parseTemplate(html)
 	if err != nil {
 		p.incrementErrors()
-		return html, fmt.Errorf("failed to parse HTML: %w", err)
+		return html, fmt.Errorf("failed to parse ESI template: %w", err)
+	}
+	output := p.execute(template, context)
+	if context.Depth == 0 && context.budget.truncated() {
+		p.recordTruncated(context)
 	}
 
-	// Process ESI elements
-	if err := p.processESIElements(doc, context); err != nil {
+	// Pages whose includes failed past the error page policy are not served partially
+	if context.Depth == 0 {
+		if reason := p.errorPageReason(context.outcomes); reason != "" {
+			p.recordProcessing(time.Since(startTime))
+			return p.errorPage(reason, context), nil
+		}
+	}
+
+	// Parse the output with goquery so it is rendered as a normalized document
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(output))
+	if err != nil {
 		p.incrementErrors()
-		return html, err
+		return html, fmt.Errorf("failed to parse HTML: %w", err)
 	}
 
 	// Get the processed HTML
@@ -179,239 +415,224 @@ func (p *Processor) Process(html string, context ProcessContext) (string, error)
 		p.incrementErrors()
 		return html, fmt.Errorf("failed to generate HTML: %w", err)
 	}
+	context.memory.observe(context.memory.live.Load() + int64(len(output)+len(result)))
 
 	// Final variable expansion for Akamai mode
-	if (p.config.Mode == "akamai" || p.config.Mode == "development") && p.akamaiExt != nil {
+	if p.akamaiEnabled() {
 		result = p.akamaiExt.expandVariables(result, context)
 	}
 
+	// Format the page once, after every include has been inserted
+	if context.Depth == 0 {
+		result = formatOutput(result, p.outputMode(context))
+	}
+
 	// Update statistics
-	processingTime := time.Since(startTime).Milliseconds()
-	p.stats.mutex.Lock()
-	p.stats.TotalTime += processingTime
-	p.stats.mutex.Unlock()
+	elapsed := time.Since(startTime)
+	p.recordProcessing(elapsed)
+	processingTime := elapsed.Milliseconds()
 
-	if p.config.Debug {
-		fmt.Printf("✅ Processing completed in %dms\n", processingTime)
+	if p.debugEnabled() {
+		fmt.Printf("✅ Processing completed in %dms%s\n", processingTime, requestIDLabel(context))
 	}
 
 	return result, nil
 }
 
-// processCommentBlocks processes <!--esi ... --> comment blocks
-func (p *Processor) processCommentBlocks(html string, context ProcessContext) string {
-	if p.config.Debug {
-		fmt.Println("🔍 Processing ESI comment blocks")
-	}
-
-	// Enhanced regex to match <!--esi ... --> blocks with better whitespace handling
-	// This regex handles various whitespace patterns and nested content
-	// Also handles empty comment blocks
-	re := regexp.MustCompile(`<!--esi\s*([\s\S]*?)\s*-->`)
-
-	return re.ReplaceAllStringFunc(html, func(match string) string {
-		// Extract the ESI content from the comment
-		matches := re.FindStringSubmatch(match)
-		if len(matches) > 1 {
-			esiContent := strings.TrimSpace(matches[1])
-
-			if p.config.Debug {
-				fmt.Printf("📝 Found ESI comment block: %s\n", truncateString(esiContent, 50))
-			}
-
-			// If the content is empty, just remove the comment block
-			if esiContent == "" {
-				if p.config.Debug {
-					fmt.Println("📝 Empty ESI comment block, removing")
-				}
-				return ""
-			}
-
-			// Process the extracted ESI content through the full processor
-			// This allows for nested processing of includes, vars, choose, etc.
-			processedContent, err := p.Process(esiContent, context)
-			if err != nil {
-				if p.config.Debug {
-					fmt.Printf("⚠️  Error processing ESI comment content: %v\n", err)
-				}
-				// Return empty string on error to remove the comment block
-				return ""
-			}
-
-			if p.config.Debug {
-				fmt.Printf("✅ Processed ESI comment block: %s\n", truncateString(processedContent, 50))
-			}
-
-			return processedContent
-		}
-		return match
-	})
+// IncludeRequest describes the HTTP request made for an ESI include
+type IncludeRequest struct {
+	Method  string
+	URL     string
+	Body    string
+	Headers map[string]string
 }
 
-// processESIElements processes all ESI elements in the document
-func (p *Processor) processESIElements(doc *goquery.Document, context ProcessContext) error {
-	// Process Akamai-specific extensions first if in Akamai mode
-	if p.config.Mode == "akamai" || p.config.Mode == "development" {
-		if err := p.akamaiExt.ProcessAkamaiExtensions(doc, context); err != nil {
-			return err
-		}
+// includeRequest builds the request for an include element. In modes with the Akamai
+// extensions the method, entity (request body) and setheader attributes are honoured;
+// setheader holds one "Name: value" header per line. Variables in the entity and
+// header values are expanded.
+func (p *Processor) includeRequest(s *goquery.Selection, src string, context ProcessContext) IncludeRequest {
+	req := IncludeRequest{Method: http.MethodGet, URL: src}
+	if !p.mode.Extensions() {
+		return req
 	}
 
-	// Process different ESI elements based on supported features
-	if p.features.Include {
-		if err := p.processIncludes(doc, context); err != nil {
-			return err
-		}
+	if method, exists := s.Attr("method"); exists && method != "" {
+		req.Method = strings.ToUpper(method)
 	}
-
-	if p.features.Choose {
-		if err := p.processChoose(doc, context); err != nil {
-			return err
-		}
+	if entity, exists := s.Attr("entity"); exists {
+		req.Body = p.ExpandESIVariables(entity, context)
 	}
-
-	if p.features.Try {
-		if err := p.processTry(doc, context); err != nil {
-			return err
+	if setHeader, exists := s.Attr("setheader"); exists {
+		req.Headers = make(map[string]string)
+		for _, line := range strings.Split(setHeader, "\n") {
+			name, value, found := strings.Cut(line, ":")
+			if found && strings.TrimSpace(name) != "" {
+				req.Headers[strings.TrimSpace(name)] = p.ExpandESIVariables(strings.TrimSpace(value), context)
+			}
 		}
 	}
+	return req
+}
 
-	if p.features.Vars {
-		if err := p.processVars(doc, context); err != nil {
-			return err
-		}
-	}
+// fetchInclude fetches content for an ESI include
+func (p *Processor) fetchInclude(src string, context ProcessContext) (string, error) {
+	return p.fetchIncludeRequest(IncludeRequest{Method: http.MethodGet, URL: src}, context)
+}
 
-	if p.features.Comment {
-		p.processComments(doc)
+// fetchIncludeRequest performs the request of an ESI include, rewriting its URL and
+// running the BeforeInclude hooks on the request and the AfterInclude hooks on its
+// content, and sanitizes the content of untrusted hosts, judged by the URL as written
+func (p *Processor) fetchIncludeRequest(include IncludeRequest, context ProcessContext) (string, error) {
+	src := include.URL
+	p.rewriteInclude(&include, context)
+	if err := p.runBeforeInclude(&include, context); err != nil {
+		return "", err
 	}
 
-	if p.features.Remove {
-		p.processRemove(doc)
+	content, err := p.fetchIncludeContent(include, context)
+	if err != nil {
+		return "", err
 	}
-
-	return nil
-}
-
-// processIncludes handles esi:include elements
-func (p *Processor) processIncludes(doc *goquery.Document, context ProcessContext) error {
-	var includeCount int
-
-	doc.Find("esi\\:include, include").Each(func(i int, s *goquery.Selection) {
-		includeCount++
-		if includeCount > p.config.MaxIncludes {
-			if p.config.Debug {
-				fmt.Printf("⚠️  Maximum includes exceeded: %d\n", p.config.MaxIncludes)
-			}
-			return
-		}
-
-		src, exists := s.Attr("src")
-		if !exists || src == "" {
-			if p.config.Debug {
-				fmt.Println("⚠️  esi:include missing src attribute")
-			}
-			s.Remove()
-			return
-		}
-
-		alt, _ := s.Attr("alt")
-		onerror, _ := s.Attr("onerror")
-
-		// Try to fetch the content
-		content, err := p.fetchInclude(src, context)
-		if err != nil {
-			if p.config.Debug {
-				fmt.Printf("⚠️  Include failed for %s: %v\n", src, err)
-			}
-
-			// Try alt URL if available
-			if alt != "" && p.features.Include {
-				if altContent, altErr := p.fetchInclude(alt, context); altErr == nil {
-					s.ReplaceWithHtml(altContent)
-					return
-				} else if p.config.Debug {
-					fmt.Printf("⚠️  Alt include failed for %s: %v\n", alt, altErr)
-				}
-			}
-
-			// Handle onerror="continue"
-			if onerror == "continue" {
-				s.Remove()
-			} else {
-				if p.config.Debug {
-					s.ReplaceWithHtml(fmt.Sprintf("<!-- ESI include error: %v -->", err))
-				} else {
-					s.Remove()
-				}
-			}
-			return
-		}
-
-		// Replace with fetched content
-		s.ReplaceWithHtml(content)
-	})
-
-	return nil
+	content, err = p.runAfterInclude(include, content, context)
+	if err != nil {
+		return "", err
+	}
+	return p.sanitizeFragment(src, content, context), nil
 }
 
-// fetchInclude fetches content for an ESI include
-func (p *Processor) fetchInclude(src string, context ProcessContext) (string, error) {
+// fetchIncludeContent fetches the content of an include. Only GET responses are cached,
+// and none for contexts that bypass the cache.
+func (p *Processor) fetchIncludeContent(include IncludeRequest, context ProcessContext) (string, error) {
 	// Resolve relative URLs
-	resolvedURL, err := p.resolveURL(src, context.BaseURL)
+	resolvedURL, err := p.resolveURL(include.URL, context.BaseURL)
 	if err != nil {
-		return "", fmt.Errorf("failed to resolve URL %s: %w", src, err)
+		return "", fmt.Errorf("failed to resolve URL %s: %w", include.URL, err)
 	}
+	cacheable := p.config.Cache.Enabled && include.Method == http.MethodGet && !context.NoCache
+	key := cacheKey(resolvedURL, include.Headers)
 
-	// Check cache first
-	if p.config.Cache.Enabled {
-		p.mutex.RLock()
-		if entry, exists := p.cache[resolvedURL]; exists && time.Now().Before(entry.ExpiresAt) {
-			p.mutex.RUnlock()
+	// Check cache first; expired entries with validators are revalidated
+	var stale *CacheEntry
+	if cacheable {
+		p.mutex.Lock()
+		entry, exists := p.cache[key]
+		if exists && time.Now().Before(entry.ExpiresAt) {
+			entry.Hits++
+			p.cache[key] = entry
+			p.mutex.Unlock()
 			p.incrementCacheHits()
+			context.Response.countInclude(true)
+			context.lastInclude.record(http.StatusOK, entry.Header)
 			return entry.Content, nil
 		}
-		p.mutex.RUnlock()
+		if exists && (entry.ETag != "" || entry.LastModified != "") {
+			stale = &entry
+		}
+		p.mutex.Unlock()
+	}
+
+	// Once the processing budget is spent only cached content is used
+	if context.budget.spent() {
+		return "", fmt.Errorf("include %s not fetched: %w", resolvedURL, ErrBudgetExceeded)
 	}
 
 	p.incrementCacheMiss()
+	context.Response.countInclude(false)
 
-	// Create HTTP request
-	req, err := http.NewRequest("GET", resolvedURL, nil)
+	// Create HTTP request, cut short when the processing budget runs out
+	var requestBody io.Reader
+	if include.Body != "" {
+		requestBody = strings.NewReader(include.Body)
+	}
+	fetchContext, cancel := context.budget.fetchContext()
+	defer cancel()
+	req, err := http.NewRequestWithContext(fetchContext, include.Method, resolvedURL, requestBody)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Add headers from context
+	// Add headers from context, then the include's own headers. Fragments are always
+	// fetched whole, whatever range the page was requested with.
 	for key, value := range context.Headers {
 		req.Header.Set(key, value)
 	}
+	req.Header.Del("Range")
+	req.Header.Del("If-Range")
+	if context.RequestID != "" {
+		req.Header.Set(p.requestIDHeader(), context.RequestID)
+	}
+	for key, value := range include.Headers {
+		req.Header.Set(key, value)
+	}
+	if stale != nil {
+		setValidators(req, *stale)
+		p.incrementRevalidations()
+	}
+
+	// Perform request, recording its fetch time
+	fetch := includeFetch{url: resolvedURL, requestID: context.RequestID}
+	fetchStart := time.Now()
+	defer func() {
+		fetch.duration = time.Since(fetchStart)
+		p.recordIncludeFetch(fetch)
+	}()
 
-	// Perform request
 	resp, err := p.client.Do(req)
 	if err != nil {
+		fetch.err = err
+		if budgetErr := context.budget.budgetError(resolvedURL, err); budgetErr != err {
+			return "", budgetErr
+		}
 		return "", fmt.Errorf("failed to fetch %s: %w", resolvedURL, err)
 	}
 	defer resp.Body.Close()
+	fetch.status = resp.StatusCode
+
+	if stale != nil && resp.StatusCode == http.StatusNotModified {
+		context.lastInclude.record(http.StatusOK, stale.Header)
+		return p.refreshCacheEntry(key, *stale, resp.Header), nil
+	}
 
+	context.lastInclude.record(resp.StatusCode, resp.Header)
 	if resp.StatusCode >= 400 {
-		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		fetch.err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		return "", fetch.err
 	}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	// Read response body into a pooled buffer
+	body := p.getBuffer(context.memory)
+	defer p.putBuffer(body)
+	_, err = body.ReadFrom(resp.Body)
 	if err != nil {
+		fetch.err = err
+		if budgetErr := context.budget.budgetError(resolvedURL, err); budgetErr != err {
+			return "", budgetErr
+		}
 		return "", fmt.Errorf("failed to read response: %w", err)
 	}
 
-	content := string(body)
+	// Transcode fragments served in other charsets to UTF-8
+	content, err := decodeFragment(body.Bytes(), resp.Header.Get("Content-Type"))
+	if err != nil {
+		fetch.err = err
+		return "", err
+	}
 
 	// Cache the result
-	if p.config.Cache.Enabled {
+	if cacheable {
+		now := time.Now()
 		p.mutex.Lock()
-		p.cache[resolvedURL] = CacheEntry{
+		p.cache[key] = CacheEntry{
 			Content:   content,
-			ExpiresAt: time.Now().Add(time.Duration(p.config.Cache.TTL) * time.Second),
+			ExpiresAt: now.Add(time.Duration(p.config.Cache.TTL) * time.Second),
+			StoredAt:  now,
+			Vary:      varyValues(resp.Header, req.Header),
+
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Tags:         cacheTags(resp.Header),
+			Header:       resp.Header.Clone(),
 		}
 		p.mutex.Unlock()
 	}
@@ -419,209 +640,6 @@ func (p *Processor) fetchInclude(src string, context ProcessContext) (string, er
 	return content, nil
 }
 
-// processChoose handles esi:choose/when/otherwise elements for conditional processing
-func (p *Processor) processChoose(doc *goquery.Document, context ProcessContext) error {
-	if p.config.Debug {
-		fmt.Println("🔍 Processing esi:choose elements")
-	}
-
-	doc.Find("esi\\:choose, choose").Each(func(i int, chooseSelection *goquery.Selection) {
-		// Find all esi:when elements within this choose block
-		whenElements := chooseSelection.Find("esi\\:when, when")
-		otherwiseElement := chooseSelection.Find("esi\\:otherwise, otherwise").First()
-
-		var selectedContent string
-		var foundMatch bool
-
-		// Evaluate each when condition
-		whenElements.Each(func(j int, whenSelection *goquery.Selection) {
-			if foundMatch {
-				return // Skip if we already found a match
-			}
-
-			// Get the test attribute
-			test, exists := whenSelection.Attr("test")
-			if !exists || test == "" {
-				if p.config.Debug {
-					fmt.Println("⚠️  esi:when missing test attribute")
-				}
-				return
-			}
-
-			// Evaluate the test expression
-			result := p.evaluateExpression(test, context)
-			if result == "true" {
-				// Get the content of this when block
-				content, err := whenSelection.Html()
-				if err != nil {
-					if p.config.Debug {
-						fmt.Printf("⚠️  Failed to get esi:when content: %v\n", err)
-					}
-					return
-				}
-
-				selectedContent = content
-				foundMatch = true
-
-				if p.config.Debug {
-					fmt.Printf("✅ esi:when condition '%s' matched\n", test)
-				}
-			}
-		})
-
-		// If no when condition matched, use otherwise
-		if !foundMatch && otherwiseElement.Length() > 0 {
-			content, err := otherwiseElement.Html()
-			if err != nil {
-				if p.config.Debug {
-					fmt.Printf("⚠️  Failed to get esi:otherwise content: %v\n", err)
-				}
-			} else {
-				selectedContent = content
-				if p.config.Debug {
-					fmt.Println("✅ Using esi:otherwise content")
-				}
-			}
-		}
-
-		// Replace the entire choose block with the selected content
-		if selectedContent != "" {
-			chooseSelection.ReplaceWithHtml(selectedContent)
-		} else {
-			// Remove the choose block if no content was selected
-			chooseSelection.Remove()
-		}
-
-		if p.config.Debug {
-			fmt.Printf("📝 Processed esi:choose block: %s\n", truncateString(selectedContent, 50))
-		}
-	})
-
-	return nil
-}
-
-// processTry handles esi:try/attempt/except elements for error handling
-func (p *Processor) processTry(doc *goquery.Document, context ProcessContext) error {
-	if p.config.Debug {
-		fmt.Println("🔍 Processing esi:try elements")
-	}
-
-	doc.Find("esi\\:try, try").Each(func(i int, trySelection *goquery.Selection) {
-		// Find attempt and except elements
-		attemptElement := trySelection.Find("esi\\:attempt, attempt").First()
-		exceptElement := trySelection.Find("esi\\:except, except").First()
-
-		var finalContent string
-		var processingError error
-
-		// Try to process the attempt block
-		if attemptElement.Length() > 0 {
-			content, err := attemptElement.Html()
-			if err != nil {
-				if p.config.Debug {
-					fmt.Printf("⚠️  Failed to get esi:attempt content: %v\n", err)
-				}
-				processingError = err
-			} else {
-				// Create a temporary processor to process the attempt content
-				// This allows us to catch errors from includes, vars, etc.
-				tempProcessor := NewProcessor(p.config)
-
-				// Process the attempt content
-				processedContent, err := tempProcessor.Process(content, context)
-				if err != nil {
-					if p.config.Debug {
-						fmt.Printf("⚠️  Error processing esi:attempt content: %v\n", err)
-					}
-					processingError = err
-				} else {
-					// Check if the processed content contains error indicators
-					if strings.Contains(processedContent, "ESI include error") ||
-						strings.Contains(processedContent, "failed to fetch") ||
-						strings.Contains(processedContent, "HTTP 4") ||
-						strings.Contains(processedContent, "HTTP 5") {
-						processingError = fmt.Errorf("include processing failed")
-					} else {
-						finalContent = processedContent
-						if p.config.Debug {
-							fmt.Println("✅ esi:attempt content processed successfully")
-						}
-					}
-				}
-			}
-		}
-
-		// If there was an error and we have an except block, use it
-		if processingError != nil && exceptElement.Length() > 0 {
-			content, err := exceptElement.Html()
-			if err != nil {
-				if p.config.Debug {
-					fmt.Printf("⚠️  Failed to get esi:except content: %v\n", err)
-				}
-			} else {
-				// Process the except content
-				processedContent, err := p.Process(content, context)
-				if err != nil {
-					if p.config.Debug {
-						fmt.Printf("⚠️  Error processing esi:except content: %v\n", err)
-					}
-				} else {
-					finalContent = processedContent
-					if p.config.Debug {
-						fmt.Println("✅ Using esi:except content due to error")
-					}
-				}
-			}
-		}
-
-		// Replace the entire try block with the final content
-		if finalContent != "" {
-			trySelection.ReplaceWithHtml(finalContent)
-		} else {
-			// Remove the try block if no content was processed
-			trySelection.Remove()
-		}
-
-		if p.config.Debug {
-			fmt.Printf("📝 Processed esi:try block: %s\n", truncateString(finalContent, 50))
-		}
-	})
-
-	return nil
-}
-
-// processVars handles esi:vars elements for variable substitution
-func (p *Processor) processVars(doc *goquery.Document, context ProcessContext) error {
-	if p.config.Debug {
-		fmt.Println("🔍 Processing esi:vars elements")
-	}
-
-	doc.Find("esi\\:vars, vars").Each(func(i int, s *goquery.Selection) {
-		// Get the content inside the esi:vars element
-		content, err := s.Html()
-		if err != nil {
-			if p.config.Debug {
-				fmt.Printf("⚠️  Failed to get esi:vars content: %v\n", err)
-			}
-			s.Remove()
-			return
-		}
-
-		// Expand variables in the content
-		expandedContent := p.ExpandESIVariables(content, context)
-
-		// Replace the esi:vars element with the expanded content
-		s.ReplaceWithHtml(expandedContent)
-
-		if p.config.Debug {
-			fmt.Printf("📝 Processed esi:vars: %s -> %s\n",
-				truncateString(content, 50), truncateString(expandedContent, 50))
-		}
-	})
-
-	return nil
-}
-
 // ExpandESIVariables expands ESI variables in content with support for default values
 func (p *Processor) ExpandESIVariables(input string, context ProcessContext) string {
 	// Regex to match $(VARIABLE), $(VARIABLE{key}), and $(VARIABLE|default) patterns
@@ -656,8 +674,15 @@ func (p *Processor) ExpandESIVariables(input string, context ProcessContext) str
 	})
 }
 
-// GetESIVariable returns the value of a standard ESI variable
+// GetESIVariable returns the value of an ESI variable; the mode's own variables are
+// resolved before the standard ones
 func (p *Processor) GetESIVariable(varName, key string, context ProcessContext) string {
+	if p.resolveVariable != nil {
+		if value, ok := p.resolveVariable(varName, key, context); ok {
+			return value
+		}
+	}
+
 	switch varName {
 	case "HTTP_HOST":
 		if host, exists := context.Headers["Host"]; exists {
@@ -719,28 +744,25 @@ func (p *Processor) GetESIVariable(varName, key string, context ProcessContext)
 		}
 		return ""
 
+	case "CTX":
+		if key == "" || context.Data == nil {
+			return ""
+		}
+		value, _ := lookupJSONKey(context.Data, key)
+		return value
+
 	default:
-		// Delegate to Akamai extensions for non-standard variables in Akamai/development mode
-		if (p.config.Mode == "akamai" || p.config.Mode == "development") && p.akamaiExt != nil {
+		// Delegate to Akamai extensions for non-standard variables in modes with the extensions
+		if p.akamaiEnabled() {
 			return p.akamaiExt.getESIVariable(varName, key, context)
 		}
-		if p.config.Debug {
+		if p.debugEnabled() {
 			fmt.Printf("⚠️  Unknown ESI variable: %s\n", varName)
 		}
 		return ""
 	}
 }
 
-// processComments removes esi:comment elements
-func (p *Processor) processComments(doc *goquery.Document) {
-	doc.Find("esi\\:comment, comment").Remove()
-}
-
-// processRemove removes esi:remove elements
-func (p *Processor) processRemove(doc *goquery.Document) {
-	doc.Find("esi\\:remove, remove").Remove()
-}
-
 // resolveURL resolves a relative URL against a base URL
 func (p *Processor) resolveURL(urlStr, baseURL string) (string, error) {
 	if urlStr == "" {
@@ -776,18 +798,7 @@ func (p *Processor) resolveURL(urlStr, baseURL string) (string, error) {
 
 // GetStats returns current processing statistics
 func (p *Processor) GetStats() Stats {
-	p.stats.mutex.RLock()
-	defer p.stats.mutex.RUnlock()
-
-	// Return a copy without the mutex to avoid copy lock error
-	return Stats{
-		Requests:  p.stats.Requests,
-		CacheHits: p.stats.CacheHits,
-		CacheMiss: p.stats.CacheMiss,
-		Errors:    p.stats.Errors,
-		TotalTime: p.stats.TotalTime,
-		// Note: mutex is not copied
-	}
+	return p.stats.snapshot(false, false)
 }
 
 // GetFeatures returns supported features for the current mode
@@ -811,26 +822,48 @@ func (p *Processor) GetCacheSize() int {
 
 // GetConfig returns the processor configuration (implements ProcessorInterface)
 func (p *Processor) GetConfig() Config {
-	return p.config
+	config := p.config
+	config.Debug = p.debugEnabled()
+	return config
+}
+
+// requestIDHeader returns the header include requests carry the request ID in
+func (p *Processor) requestIDHeader() string {
+	if p.config.RequestIDHeader != "" {
+		return p.config.RequestIDHeader
+	}
+	return DefaultRequestIDHeader
+}
+
+// requestIDLabel formats the request ID of context for debug output
+func requestIDLabel(context ProcessContext) string {
+	if context.RequestID == "" {
+		return ""
+	}
+	return ", request " + context.RequestID
+}
+
+// SetDebug turns debug output on or off while the processor is serving requests
+func (p *Processor) SetDebug(debug bool) {
+	p.debug.Store(debug)
+}
+
+// debugEnabled reports whether debug output is on
+func (p *Processor) debugEnabled() bool {
+	return p.debug.Load()
 }
 
 // Helper methods for statistics
 func (p *Processor) incrementCacheHits() {
-	p.stats.mutex.Lock()
-	defer p.stats.mutex.Unlock()
-	p.stats.CacheHits++
+	p.stats.count(&p.stats.cacheHits, func(slot *windowSlot) { slot.cacheHits++ })
 }
 
 func (p *Processor) incrementCacheMiss() {
-	p.stats.mutex.Lock()
-	defer p.stats.mutex.Unlock()
-	p.stats.CacheMiss++
+	p.stats.count(&p.stats.cacheMiss, func(slot *windowSlot) { slot.cacheMiss++ })
 }
 
 func (p *Processor) incrementErrors() {
-	p.stats.mutex.Lock()
-	defer p.stats.mutex.Unlock()
-	p.stats.Errors++
+	p.stats.count(&p.stats.errors, func(slot *windowSlot) { slot.errors++ })
 }
 
 // truncateString truncates a string to the specified length
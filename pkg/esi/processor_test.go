@@ -1,10 +1,13 @@
 package esi
 
 import (
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -49,6 +52,8 @@ func TestNewProcessor(t *testing.T) {
 				Eval:          true,
 				Function:      true,
 				Dictionary:    true,
+				Lookup:        true,
+				Groups:        true,
 				Debug:         true,
 				GeoVariables:  true,
 				ExtendedVars:  true,
@@ -75,6 +80,8 @@ func TestNewProcessor(t *testing.T) {
 				Eval:          true,
 				Function:      true,
 				Dictionary:    true,
+				Lookup:        true,
+				Groups:        true,
 				Debug:         true,
 				GeoVariables:  true,
 				ExtendedVars:  true,
@@ -392,6 +399,93 @@ func TestProcessor_ProcessIncludes(t *testing.T) {
 	}
 }
 
+func TestProcessor_ProcessIncludes_POST(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(fmt.Sprintf("<p>%s %s %s</p>", r.Method, body, r.Header.Get("X-Test"))))
+	}))
+	defer server.Close()
+
+	context := ProcessContext{
+		Headers: map[string]string{},
+		Cookies: map[string]string{"user": "alice"},
+	}
+	input := `<html><body><esi:include src="/collect" method="post" entity="user=$(HTTP_COOKIE{user})" setheader="X-Test: one&#10;X-Other: two"></esi:include></body></html>`
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, BaseURL: server.URL})
+	result, err := processor.Process(input, context)
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>POST user=alice one</p>")
+
+	// Extended attributes are ignored outside Akamai and development mode
+	processor = NewProcessor(Config{Mode: "w3c", MaxIncludes: 10, BaseURL: server.URL})
+	result, err = processor.Process(input, context)
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>GET  </p>")
+}
+
+func TestProcessor_RequestIDHeader(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Correlation-ID")+"|"+r.Header.Get(DefaultRequestIDHeader))
+		w.Write([]byte("<p>Fragment</p>"))
+	}))
+	defer server.Close()
+
+	input := `<esi:include src="/fragment.html"></esi:include>`
+	context := ProcessContext{BaseURL: server.URL, RequestID: "req-42"}
+
+	_, err := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10}).Process(input, context)
+	require.NoError(t, err)
+	_, err = NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, RequestIDHeader: "X-Correlation-ID"}).Process(input, context)
+	require.NoError(t, err)
+	context.RequestID = ""
+	_, err = NewProcessor(Config{Mode: "akamai", MaxIncludes: 10}).Process(input, context)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"|req-42", "req-42|", "|"}, received)
+}
+
+func TestProcessor_LatencyStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<p>Fragment</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, SlowIncludeMS: 10})
+	context := ProcessContext{BaseURL: server.URL, RequestID: "req-7"}
+	_, err := processor.Process(`<esi:include src="/fast"></esi:include><esi:include src="/slow"></esi:include>`+
+		`<esi:include src="/missing" onerror="continue"></esi:include>`, context)
+	require.NoError(t, err)
+
+	stats := processor.GetStats()
+	assert.Equal(t, int64(1), stats.ProcessingTime.Count)
+	assert.Equal(t, int64(3), stats.IncludeFetchTime.Count)
+	assert.Len(t, stats.IncludeFetchTime.Counts, len(LatencyBoundsMS)+1)
+	assert.GreaterOrEqual(t, stats.IncludeFetchTime.SumMS, 20.0)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.Equal(t, int64(3), stats.IncludeHosts[host].Fetches)
+	assert.Equal(t, int64(1), stats.IncludeHosts[host].Errors)
+	assert.Equal(t, int64(1), stats.IncludeHosts[host].SlowIncludes)
+
+	require.Len(t, stats.SlowIncludes, 1)
+	assert.Equal(t, server.URL+"/slow", stats.SlowIncludes[0].URL)
+	assert.Equal(t, "req-7", stats.SlowIncludes[0].RequestID)
+	assert.Equal(t, http.StatusOK, stats.SlowIncludes[0].Status)
+
+	// The returned statistics are copies
+	stats.IncludeFetchTime.Counts[0] = 100
+	assert.NotEqual(t, int64(100), processor.GetStats().IncludeFetchTime.Counts[0])
+}
+
 func TestProcessor_Cache(t *testing.T) {
 	// Create a test server with a counter
 	callCount := 0
@@ -445,6 +539,40 @@ func TestProcessor_Cache(t *testing.T) {
 	assert.Equal(t, 0, processor.GetCacheSize())
 }
 
+func TestProcessor_NoCacheContext(t *testing.T) {
+	callCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		w.Write([]byte("<p>Fragment content</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{
+		Mode:        "akamai",
+		MaxIncludes: 10,
+		Cache:       CacheConfig{Enabled: true, TTL: 60},
+	})
+	input := `<esi:include src="/fragment.html"></esi:include>`
+
+	// A no-cache request neither uses nor fills the cache
+	noCache := ProcessContext{BaseURL: server.URL, Headers: map[string]string{}, NoCache: true}
+	for i := 0; i < 2; i++ {
+		_, err := processor.Process(input, noCache)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, callCount)
+	assert.Equal(t, 0, processor.GetCacheSize())
+
+	// Other requests still cache the fragment
+	cached := ProcessContext{BaseURL: server.URL, Headers: map[string]string{}}
+	for i := 0; i < 2; i++ {
+		_, err := processor.Process(input, cached)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, callCount)
+	assert.Equal(t, 1, processor.GetCacheSize())
+}
+
 func TestProcessor_MaxIncludes(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
@@ -885,6 +1013,25 @@ func TestProcessor_ExpandESIVariables(t *testing.T) {
 	}
 }
 
+func TestProcessor_ContextData(t *testing.T) {
+	context := ProcessContext{Data: map[string]interface{}{
+		"segment": "vip",
+		"flags":   map[string]interface{}{"newNav": true, "limit": 3},
+		"tags":    []interface{}{"a", "b"},
+	}}
+
+	processor := NewProcessor(Config{Mode: "w3c"})
+	assert.Equal(t, "vip|true|3|[\"a\",\"b\"]|none",
+		processor.ExpandESIVariables("$(CTX{segment})|$(CTX{flags.newNav})|$(CTX{flags.limit})|$(CTX{tags})|$(CTX{missing}|none)", context))
+	assert.Empty(t, processor.ExpandESIVariables("$(CTX{segment})", ProcessContext{}))
+
+	processor = NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+	result, err := processor.Process(`<esi:choose><esi:when test="$(CTX{segment}) == 'vip'"><p>VIP</p></esi:when>`+
+		`<esi:otherwise><p>Guest</p></esi:otherwise></esi:choose>`, context)
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>VIP</p>")
+}
+
 func TestProcessor_ProcessChoose(t *testing.T) {
 	tests := []struct {
 		name             string
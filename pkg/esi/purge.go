@@ -0,0 +1,97 @@
+package esi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PurgeRequest selects cached fragments to invalidate, in the shape of the JSON purge
+// APIs of CDNs: the fragments of each URL, whatever headers their includes set, and the
+// fragments tagged with one of the tags
+type PurgeRequest struct {
+	URLs []string `json:"urls,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+	// Soft marks the fragments stale instead of removing them, as Fastly and Akamai soft
+	// purges do: the next include revalidates them with their validators, and a
+	// 304 Not Modified keeps their content
+	Soft bool `json:"soft,omitempty"`
+}
+
+// PurgeResult counts and lists the cache keys a purge invalidated, ordered by key
+type PurgeResult struct {
+	Purged int      `json:"purged"`
+	Keys   []string `json:"keys"`
+	Soft   bool     `json:"soft"`
+}
+
+// Purge invalidates the cached fragments selected by req
+func (p *Processor) Purge(req PurgeRequest) PurgeResult {
+	result := PurgeResult{Keys: []string{}, Soft: req.Soft}
+	now := time.Now()
+
+	p.mutex.Lock()
+	for key, entry := range p.cache {
+		if !purgeMatches(req, key, entry) {
+			continue
+		}
+		result.Keys = append(result.Keys, key)
+		if !req.Soft {
+			delete(p.cache, key)
+			continue
+		}
+		if entry.ExpiresAt.After(now) {
+			entry.ExpiresAt = now
+		}
+		entry.SoftPurged = true
+		p.cache[key] = entry
+	}
+	p.mutex.Unlock()
+
+	sort.Strings(result.Keys)
+	result.Purged = len(result.Keys)
+	if p.debugEnabled() {
+		fmt.Printf("🧹 Purged %d cached fragments (soft: %t)\n", result.Purged, req.Soft)
+	}
+	return result
+}
+
+// purgeMatches reports whether the entry cached under key is selected by req. Keys
+// start with the fragment URL, followed by the headers of includes that set some.
+func purgeMatches(req PurgeRequest, key string, entry CacheEntry) bool {
+	for _, url := range req.URLs {
+		if key == url || strings.HasPrefix(key, url+" [") {
+			return true
+		}
+	}
+	for _, tag := range req.Tags {
+		for _, entryTag := range entry.Tags {
+			if tag == entryTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheTags returns the purge tags of a fragment response: the space-separated
+// Surrogate-Key of Fastly and the comma-separated Edge-Cache-Tag of Akamai and
+// Cache-Tag of other CDNs
+func cacheTags(header http.Header) []string {
+	var tags []string
+	for _, value := range header.Values("Surrogate-Key") {
+		tags = append(tags, strings.Fields(value)...)
+	}
+	for _, name := range []string{"Edge-Cache-Tag", "Cache-Tag"} {
+		for _, value := range header.Values(name) {
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+	return tags
+}
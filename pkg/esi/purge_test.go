@@ -0,0 +1,84 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurge_URLsAndTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/price":
+			w.Header().Set("Surrogate-Key", "product-42 prices")
+		case "/stock":
+			w.Header().Set("Edge-Cache-Tag", "product-42, stock")
+		}
+		w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Cache: CacheConfig{Enabled: true, TTL: 60}})
+	template := `<esi:include src="` + server.URL + `/price"/><esi:include src="` + server.URL + `/stock"/>` +
+		`<esi:include src="` + server.URL + `/banner"/><esi:include src="` + server.URL + `/banner" setheader="X-User: 1"/>`
+	_, err := processor.Process(template, ProcessContext{})
+	require.NoError(t, err)
+	require.Equal(t, 4, processor.GetCacheSize())
+
+	info, _, exists := processor.GetCacheEntry(server.URL + "/stock")
+	require.True(t, exists)
+	assert.Equal(t, []string{"product-42", "stock"}, info.Tags)
+
+	result := processor.Purge(PurgeRequest{URLs: []string{server.URL + "/banner"}})
+	assert.Equal(t, 2, result.Purged, "every variant of the URL is purged")
+	assert.Equal(t, 2, processor.GetCacheSize())
+
+	result = processor.Purge(PurgeRequest{Tags: []string{"product-42"}})
+	assert.Equal(t, []string{server.URL + "/price", server.URL + "/stock"}, result.Keys)
+	assert.Equal(t, 0, processor.GetCacheSize())
+
+	assert.Empty(t, processor.Purge(PurgeRequest{Tags: []string{"unknown"}}).Keys)
+}
+
+func TestPurge_Soft(t *testing.T) {
+	bodies := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Tag", "home")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		bodies++
+		w.Write([]byte("<p>fragment</p>"))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Cache: CacheConfig{Enabled: true, TTL: 60}})
+	template := `<esi:include src="` + server.URL + `/f"/>`
+	_, err := processor.Process(template, ProcessContext{})
+	require.NoError(t, err)
+
+	result := processor.Purge(PurgeRequest{Tags: []string{"home"}, Soft: true})
+	assert.Equal(t, 1, result.Purged)
+	assert.True(t, result.Soft)
+
+	info, content, exists := processor.GetCacheEntry(server.URL + "/f")
+	require.True(t, exists, "soft purges keep the entry")
+	assert.True(t, info.Expired)
+	assert.True(t, info.SoftPurged)
+	assert.Equal(t, "<p>fragment</p>", content)
+
+	output, err := processor.Process(template, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, output, "<p>fragment</p>")
+	assert.Equal(t, 1, bodies, "the stale entry is revalidated rather than downloaded")
+
+	info, _, _ = processor.GetCacheEntry(server.URL + "/f")
+	assert.False(t, info.Expired)
+	assert.False(t, info.SoftPurged)
+	assert.Equal(t, int64(1), processor.GetStats().NotModified)
+}
@@ -0,0 +1,69 @@
+package esi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ValidateResolve checks that every host override names a host and an address
+func ValidateResolve(resolve map[string]string) error {
+	for host, address := range resolve {
+		switch {
+		case strings.TrimSpace(host) == "":
+			return fmt.Errorf("resolve override for %q: host is required", address)
+		case strings.TrimSpace(address) == "":
+			return fmt.Errorf("resolve override for %s: address is required", host)
+		}
+	}
+	return nil
+}
+
+// newIncludeTransport returns the base transport of include fetches, connecting to the
+// addresses of resolve instead of resolving their hosts
+func newIncludeTransport(resolve map[string]string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(resolve) == 0 {
+		return transport
+	}
+
+	overrides := make(map[string]string, len(resolve))
+	for host, address := range resolve {
+		overrides[strings.ToLower(strings.TrimSpace(host))] = strings.TrimSpace(address)
+	}
+	dialer := &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultDialTimeout}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, resolveAddress(overrides, addr))
+	}
+	return transport
+}
+
+// defaultDialTimeout is the connect timeout and keep-alive period of include connections,
+// those of http.DefaultTransport
+const defaultDialTimeout = 30 * time.Second
+
+// resolveAddress returns the address to connect to for addr (host:port), like curl's
+// --resolve: an override for host:port wins over one for host, and overrides without a
+// port keep the port of addr
+func resolveAddress(overrides map[string]string, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	host = strings.ToLower(host)
+
+	address, exists := overrides[net.JoinHostPort(host, port)]
+	if !exists {
+		address, exists = overrides[host]
+	}
+	if !exists {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), port)
+}
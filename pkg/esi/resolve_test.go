@@ -0,0 +1,68 @@
+package esi
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveAddress(t *testing.T) {
+	overrides := map[string]string{
+		"www.example.com":     "127.0.0.1",
+		"www.example.com:443": "127.0.0.1:8443",
+		"api.example.com":     "10.0.0.5:9000",
+		"v6.example.com":      "[::1]",
+	}
+	tests := map[string]string{
+		"www.example.com:80":  "127.0.0.1:80",
+		"WWW.example.com:443": "127.0.0.1:8443",
+		"api.example.com:443": "10.0.0.5:9000",
+		"v6.example.com:80":   "[::1]:80",
+		"cdn.example.com:443": "cdn.example.com:443",
+	}
+	for addr, want := range tests {
+		assert.Equal(t, want, resolveAddress(overrides, addr), addr)
+	}
+}
+
+func TestResolve_IncludeFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>" + r.Host + r.URL.Path + "</p>"))
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, Resolve: map[string]string{"www.example.com:80": address}})
+	content, err := processor.fetchInclude("/fragments/header", ProcessContext{BaseURL: "http://www.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>www.example.com/fragments/header</p>", content, "the Host header keeps the template's host")
+
+	// TLS is verified against the template's host, here one of the test certificate's names
+	tlsServer := httptest.NewTLSServer(server.Config.Handler)
+	defer tlsServer.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), 0o600))
+
+	processor = NewProcessor(Config{
+		Mode:        "akamai",
+		MaxIncludes: 10,
+		Resolve:     map[string]string{"example.com": strings.TrimPrefix(tlsServer.URL, "https://")},
+		TLS:         []OriginTLS{{Host: "example.com", CAFile: caFile}},
+	})
+	content, err = processor.fetchInclude("https://example.com/fragments/footer", ProcessContext{})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>example.com/fragments/footer</p>", content)
+}
+
+func TestValidateResolve(t *testing.T) {
+	assert.NoError(t, ValidateResolve(map[string]string{"www.example.com": "127.0.0.1:8080"}))
+	assert.ErrorContains(t, ValidateResolve(map[string]string{"www.example.com": ""}), "address is required")
+	assert.ErrorContains(t, ValidateResolve(map[string]string{" ": "127.0.0.1"}), "host is required")
+}
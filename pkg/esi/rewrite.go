@@ -0,0 +1,119 @@
+package esi
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RewriteRule rewrites the URLs of includes before they are fetched, such as to point
+// partner beacon domains at a local sink. A rule either replaces the matches of a
+// regular expression on the absolute URL (Match and Replace) or moves the URLs of a host
+// to another one (Host and To).
+type RewriteRule struct {
+	// Match is a regular expression matched against the absolute include URL
+	Match string `json:"match,omitempty"`
+	// Replace replaces the matches of Match; $1 or ${name} insert submatches
+	Replace string `json:"replace,omitempty"`
+	// Host is the host or host:port whose URLs are moved; "*" matches every host
+	Host string `json:"host,omitempty"`
+	// To is the host[:port], or scheme://host[:port], replacing Host
+	To string `json:"to,omitempty"`
+}
+
+// ValidateRewriteRules checks that every rewrite rule is either a valid regular
+// expression with its replacement or a host with the one it maps to
+func ValidateRewriteRules(rules []RewriteRule) error {
+	for i, rule := range rules {
+		if _, err := rule.compile(); err != nil {
+			return fmt.Errorf("rewrite rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// compiledRewrite is a rewrite rule ready to apply
+type compiledRewrite struct {
+	rule    RewriteRule
+	pattern *regexp.Regexp // Nil for host mappings
+	scheme  string         // Scheme of To, empty to keep the URL's
+	host    string         // Host of To
+}
+
+// compile checks and compiles the rule
+func (r RewriteRule) compile() (compiledRewrite, error) {
+	switch {
+	case r.Match != "" && r.Host == "" && r.To == "":
+		pattern, err := regexp.Compile(r.Match)
+		if err != nil {
+			return compiledRewrite{}, fmt.Errorf("invalid match: %w", err)
+		}
+		return compiledRewrite{rule: r, pattern: pattern}, nil
+	case r.Host != "" && r.To != "" && r.Match == "" && r.Replace == "":
+		compiled := compiledRewrite{rule: r, host: r.To}
+		if scheme, host, found := strings.Cut(r.To, "://"); found {
+			compiled.scheme, compiled.host = strings.ToLower(scheme), host
+		}
+		if compiled.host == "" || strings.ContainsAny(compiled.host, "/?#") {
+			return compiledRewrite{}, fmt.Errorf("invalid to %q: want host[:port] or scheme://host[:port]", r.To)
+		}
+		return compiled, nil
+	}
+	return compiledRewrite{}, fmt.Errorf("want match with replace, or host with to")
+}
+
+// apply returns rawURL rewritten by the rule
+func (c compiledRewrite) apply(rawURL string) string {
+	if c.pattern != nil {
+		return c.pattern.ReplaceAllString(rawURL, c.rule.Replace)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	if c.rule.Host != "*" && !strings.EqualFold(c.rule.Host, u.Host) && !strings.EqualFold(c.rule.Host, u.Hostname()) {
+		return rawURL
+	}
+	if c.scheme != "" {
+		u.Scheme = c.scheme
+	}
+	u.Host = c.host
+	return u.String()
+}
+
+// compileRewriteRules compiles the valid rules of rules, in order
+func compileRewriteRules(rules []RewriteRule) []compiledRewrite {
+	var compiled []compiledRewrite
+	for _, rule := range rules {
+		if c, err := rule.compile(); err == nil {
+			compiled = append(compiled, c)
+		}
+	}
+	return compiled
+}
+
+// rewriteInclude applies the rewrite rules, in order, to the absolute URL of an include.
+// Includes no rule changes keep their URL as written.
+func (p *Processor) rewriteInclude(include *IncludeRequest, context ProcessContext) {
+	if len(p.rewrites) == 0 {
+		return
+	}
+	original, err := p.resolveURL(include.URL, context.BaseURL)
+	if err != nil {
+		return
+	}
+
+	rewritten := original
+	for _, rule := range p.rewrites {
+		rewritten = rule.apply(rewritten)
+	}
+	if rewritten == original {
+		return
+	}
+	if p.debugEnabled() {
+		fmt.Printf("🔀 Rewrote include URL %s -> %s\n", original, rewritten)
+	}
+	include.URL = rewritten
+}
@@ -0,0 +1,94 @@
+package esi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteRule_Apply(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RewriteRule
+		url  string
+		want string
+	}{
+		{name: "regex", rule: RewriteRule{Match: `^https://cdn\.example\.com/v1/`, Replace: "http://localhost:8080/v2/"},
+			url: "https://cdn.example.com/v1/header", want: "http://localhost:8080/v2/header"},
+		{name: "regex submatch", rule: RewriteRule{Match: `lang=(\w+)`, Replace: "locale=$1"},
+			url: "http://example.com/nav?lang=en", want: "http://example.com/nav?locale=en"},
+		{name: "host", rule: RewriteRule{Host: "beacon.partner.com", To: "localhost:3000"},
+			url: "https://beacon.partner.com/pixel?id=1", want: "https://localhost:3000/pixel?id=1"},
+		{name: "host with scheme", rule: RewriteRule{Host: "beacon.partner.com", To: "http://localhost:3000"},
+			url: "https://beacon.partner.com:443/pixel", want: "http://localhost:3000/pixel"},
+		{name: "other host", rule: RewriteRule{Host: "beacon.partner.com", To: "localhost:3000"},
+			url: "https://www.example.com/pixel", want: "https://www.example.com/pixel"},
+		{name: "any host", rule: RewriteRule{Host: "*", To: "staging.example.com"},
+			url: "http://www.example.com/a", want: "http://staging.example.com/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := tt.rule.compile()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, compiled.apply(tt.url))
+		})
+	}
+}
+
+func TestRewrite_IncludeFetch(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>sink " + r.URL.RequestURI() + "</p>"))
+	}))
+	defer sink.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, Debug: true, Rewrites: []RewriteRule{
+		{Host: "beacon.partner.invalid", To: sink.URL},
+		{Match: `/v1/`, Replace: "/v2/"},
+	}})
+
+	var result string
+	output := captureStdout(t, func() {
+		var err error
+		result, err = processor.Process(`<esi:include src="https://beacon.partner.invalid/v1/pixel?id=1"/>`+
+			`<esi:include src="https://down.partner.invalid/x" alt="https://beacon.partner.invalid/alt"/>`,
+			ProcessContext{Headers: map[string]string{}, Cookies: map[string]string{}})
+		require.NoError(t, err)
+	})
+	assert.Contains(t, result, "<p>sink /v2/pixel?id=1</p>")
+	assert.Contains(t, result, "<p>sink /alt</p>", "alt is rewritten too")
+	assert.Contains(t, output, "Rewrote include URL https://beacon.partner.invalid/v1/pixel?id=1 -> "+sink.URL+"/v2/pixel?id=1")
+
+	// Relative includes are rewritten once resolved against the base URL
+	content, err := processor.fetchInclude("/v1/nav", ProcessContext{BaseURL: "https://beacon.partner.invalid"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>sink /v2/nav</p>", content)
+}
+
+func TestValidateRewriteRules(t *testing.T) {
+	assert.NoError(t, ValidateRewriteRules([]RewriteRule{{Match: "^http:", Replace: "https:"}, {Host: "a.example", To: "http://b.example:8080"}}))
+	assert.ErrorContains(t, ValidateRewriteRules([]RewriteRule{{Match: "("}}), "rewrite rule 1: invalid match")
+	assert.ErrorContains(t, ValidateRewriteRules([]RewriteRule{{Host: "a.example"}}), "want match with replace, or host with to")
+	assert.ErrorContains(t, ValidateRewriteRules([]RewriteRule{{Match: "a", Host: "a.example", To: "b"}}), "want match")
+	assert.ErrorContains(t, ValidateRewriteRules([]RewriteRule{{Host: "a.example", To: "http://b.example/path"}}), "invalid to")
+}
+
+// captureStdout returns what fn writes to standard output
+func captureStdout(t *testing.T, fn func()) string {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+	writer.Close()
+	output, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return strings.TrimSpace(string(output))
+}
@@ -0,0 +1,202 @@
+package esi
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// DefaultSanitizeElements are the elements removed with their content from untrusted
+// fragments when the policy names none
+var DefaultSanitizeElements = []string{
+	"script", "iframe", "frame", "frameset", "object", "embed", "applet", "base", "meta",
+}
+
+// SanitizeConfig is the policy for fragments included from untrusted sources, such as
+// partner-provided ESI snippets being previewed
+type SanitizeConfig struct {
+	// Hosts are the untrusted hosts, as host or host:port; "*" matches every host
+	Hosts []string `json:"hosts,omitempty"`
+	// Elements are removed with their content; empty selects DefaultSanitizeElements
+	Elements []string `json:"elements,omitempty"`
+	// AllowEventHandlers keeps on* attributes such as onclick
+	AllowEventHandlers bool `json:"allowEventHandlers,omitempty"`
+	// AllowScriptURLs keeps attribute values with javascript:, vbscript: and data:text/html URLs
+	AllowScriptURLs bool `json:"allowScriptUrls,omitempty"`
+}
+
+// untrusted reports whether fragments fetched from rawURL are sanitized
+func (c SanitizeConfig) untrusted(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return len(c.Hosts) > 0
+	}
+	for _, host := range c.Hosts {
+		if host == "*" || strings.EqualFold(host, u.Host) || strings.EqualFold(host, hostname(u.Host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// untrustedInclude reports whether the fragments of an include from src are sanitized
+func (p *Processor) untrustedInclude(src string, context ProcessContext) bool {
+	if len(p.config.Sanitize.Hosts) == 0 {
+		return false
+	}
+	resolvedURL, err := p.resolveURL(src, context.BaseURL)
+	return err == nil && p.config.Sanitize.untrusted(resolvedURL)
+}
+
+// sanitizeFragment sanitizes the content of a fragment included from src when its
+// host is untrusted, counting the fragments that changed
+func (p *Processor) sanitizeFragment(src, content string, context ProcessContext) string {
+	if !p.untrustedInclude(src, context) {
+		return content
+	}
+	resolvedURL, _ := p.resolveURL(src, context.BaseURL)
+
+	sanitized := Sanitize(content, p.config.Sanitize)
+	if sanitized != content {
+		p.stats.sanitized.Add(1)
+		if p.debugEnabled() {
+			fmt.Printf("🧹 Sanitized fragment from %s\n", resolvedURL)
+		}
+	}
+	return sanitized
+}
+
+// voidElements are the removable elements that never have content or an end tag
+var voidElements = map[string]bool{"base": true, "embed": true, "meta": true}
+
+// attributeEscaper escapes attribute values written in double quotes, leaving ESI
+// expressions such as $(VAR|'default') readable
+var attributeEscaper = strings.NewReplacer(`&`, "&amp;", `"`, "&quot;")
+
+// Sanitize strips script-injecting constructs from content while keeping its ESI
+// markup: the policy's elements are removed with their content, and event handler
+// attributes and script URLs are dropped from the remaining HTML tags. The content of
+// <!--esi --> blocks and the values of esi:assign are sanitized the same way. Markup
+// left unchanged is kept as written.
+func Sanitize(content string, policy SanitizeConfig) string {
+	elements := policy.Elements
+	if len(elements) == 0 {
+		elements = DefaultSanitizeElements
+	}
+	removed := make(map[string]bool, len(elements))
+	for _, element := range elements {
+		removed[strings.ToLower(element)] = true
+	}
+	return sanitize(content, removed, policy)
+}
+
+// sanitize sanitizes content, removing the elements in removed
+func sanitize(content string, removed map[string]bool, policy SanitizeConfig) string {
+	var out strings.Builder
+	var skipped string // Element whose content is being removed, and how deeply it is nested
+	var depth int
+
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			// Content the tokenizer gives up on is dropped rather than passed on unchecked
+			return out.String()
+		}
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+
+		if skipped != "" {
+			switch {
+			case tokenType == html.StartTagToken && token.Data == skipped:
+				depth++
+			case tokenType == html.EndTagToken && token.Data == skipped:
+				if depth--; depth == 0 {
+					skipped = ""
+				}
+			}
+			continue
+		}
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if removed[token.Data] {
+				if tokenType == html.StartTagToken && !voidElements[token.Data] {
+					skipped, depth = token.Data, 1
+				}
+				continue
+			}
+			raw = sanitizeTag(raw, token, tokenType == html.SelfClosingTagToken, removed, policy)
+		case html.EndTagToken:
+			if removed[token.Data] {
+				continue
+			}
+		case html.CommentToken:
+			if strings.HasPrefix(token.Data, "esi") {
+				inner, closing := strings.TrimPrefix(raw, "<!--"), ""
+				if strings.HasSuffix(inner, "-->") {
+					inner, closing = strings.TrimSuffix(inner, "-->"), "-->"
+				}
+				raw = "<!--" + sanitize(inner, removed, policy) + closing
+			}
+		}
+		out.WriteString(raw)
+	}
+}
+
+// sanitizeTag drops event handler attributes and script URLs from an HTML tag and
+// sanitizes the value of esi:assign. Tags with nothing to change keep their markup.
+func sanitizeTag(raw string, token html.Token, selfClosing bool, removed map[string]bool, policy SanitizeConfig) string {
+	isESI := isESIElement(token.Data)
+	changed := false
+	attrs := make([]html.Attribute, 0, len(token.Attr))
+	for _, attr := range token.Attr {
+		switch {
+		case isESI:
+			if attr.Key == "value" && strings.TrimPrefix(token.Data, "esi:") == "assign" {
+				if value := sanitize(attr.Val, removed, policy); value != attr.Val {
+					attr.Val, changed = value, true
+				}
+			}
+		case !policy.AllowEventHandlers && strings.HasPrefix(attr.Key, "on"),
+			!policy.AllowScriptURLs && isScriptURL(attr.Val):
+			changed = true
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	if !changed {
+		return raw
+	}
+
+	var tag strings.Builder
+	tag.WriteString("<" + token.Data)
+	for _, attr := range attrs {
+		tag.WriteString(" " + attr.Key + `="` + attributeEscaper.Replace(attr.Val) + `"`)
+	}
+	if selfClosing {
+		tag.WriteString("/")
+	}
+	tag.WriteString(">")
+	return tag.String()
+}
+
+// isScriptURL reports whether an attribute value is a URL that runs script. Browsers
+// ignore whitespace and control characters within the scheme, so they are skipped.
+func isScriptURL(value string) bool {
+	var normalized strings.Builder
+	for _, r := range value {
+		if r > ' ' {
+			normalized.WriteRune(unicode.ToLower(r))
+		}
+		if normalized.Len() >= len("data:text/html") {
+			break
+		}
+	}
+	scheme := normalized.String()
+	return strings.HasPrefix(scheme, "javascript:") || strings.HasPrefix(scheme, "vbscript:") ||
+		strings.HasPrefix(scheme, "data:text/html")
+}
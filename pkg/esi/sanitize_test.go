@@ -0,0 +1,129 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		policy   SanitizeConfig
+		expected string
+	}{
+		{
+			name:     "markup without scripts is kept as written",
+			content:  `<div class='promo'><a href="/offer?a=1&amp;b=2">Offer</a></div>`,
+			expected: `<div class='promo'><a href="/offer?a=1&amp;b=2">Offer</a></div>`,
+		},
+		{
+			name:     "script elements are removed with their content",
+			content:  `<p>a</p><script>document.write("<p>b</p>")</script><SCRIPT src="/x.js"></SCRIPT><p>c</p>`,
+			expected: `<p>a</p><p>c</p>`,
+		},
+		{
+			name:     "nested and void elements",
+			content:  `<object><object><param name="x"></object></object><embed src="/x.swf"><base href="/"><meta http-equiv="refresh" content="0"><p>kept</p>`,
+			expected: `<p>kept</p>`,
+		},
+		{
+			name:     "event handlers and script URLs",
+			content:  `<img src="/a.png" onerror="alert(1)" alt="A"><a href=" java&#9;script:alert(1)">x</a><a href="data:text/html,<b>">y</a>`,
+			expected: `<img src="/a.png" alt="A"><a>x</a><a>y</a>`,
+		},
+		{
+			name:     "ESI markup is kept",
+			content:  `<esi:include src="/a" onerror="continue"/><esi:vars><a href="$(HTTP_REFERER|'/')" onclick="x()">$(HTTP_HOST)</a></esi:vars>`,
+			expected: `<esi:include src="/a" onerror="continue"/><esi:vars><a href="$(HTTP_REFERER|'/')">$(HTTP_HOST)</a></esi:vars>`,
+		},
+		{
+			name:     "esi comment blocks and assign values",
+			content:  `<!--esi <p>a</p><script>x()</script> --><esi:assign name="v" value="'<script>x()</script>b'"/>`,
+			expected: `<!--esi <p>a</p> --><esi:assign name="v" value="'b'"/>`,
+		},
+		{
+			name:     "policy",
+			content:  `<iframe src="/frame"></iframe><style>p{}</style><button onclick="x()">b</button><a href="javascript:x()">a</a>`,
+			policy:   SanitizeConfig{Elements: []string{"Style"}, AllowEventHandlers: true, AllowScriptURLs: true},
+			expected: `<iframe src="/frame"></iframe><button onclick="x()">b</button><a href="javascript:x()">a</a>`,
+		},
+		{
+			name:     "unterminated script",
+			content:  `<p>a</p><script>x()`,
+			expected: `<p>a</p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Sanitize(tt.content, tt.policy))
+		})
+	}
+}
+
+func TestSanitizeConfig_Untrusted(t *testing.T) {
+	config := SanitizeConfig{Hosts: []string{"partner.example.com", "127.0.0.1:8080"}}
+
+	assert.True(t, config.untrusted("http://partner.example.com/snippet"))
+	assert.True(t, config.untrusted("https://PARTNER.example.com:8443/snippet"))
+	assert.True(t, config.untrusted("http://127.0.0.1:8080/snippet"))
+	assert.False(t, config.untrusted("http://127.0.0.1:9090/snippet"))
+	assert.False(t, config.untrusted("http://www.example.com/snippet"))
+	assert.False(t, SanitizeConfig{}.untrusted("http://partner.example.com/snippet"))
+	assert.True(t, SanitizeConfig{Hosts: []string{"*"}}.untrusted("http://www.example.com/"))
+}
+
+func TestProcessor_SanitizeUntrustedFragments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<p onclick="steal()">Partner</p><script>steal()</script>`))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	page := `<esi:include src="/snippet"/>`
+
+	trusting := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 1})
+	result, err := trusting.Process(page, ProcessContext{BaseURL: server.URL})
+	require.NoError(t, err)
+	assert.Contains(t, result, "steal()")
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 1, Sanitize: SanitizeConfig{Hosts: []string{host}}})
+	result, err = processor.Process(page, ProcessContext{BaseURL: server.URL})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>Partner</p>")
+	assert.NotContains(t, result, "steal()")
+	assert.Equal(t, int64(1), processor.GetStats().Sanitized)
+}
+
+// TestProcessor_SanitizeExecutedFragments tests that script URLs and handlers an
+// untrusted fragment builds from variables are removed from its output
+func TestProcessor_SanitizeExecutedFragments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/assign":
+			w.Write([]byte(`<esi:assign name="p" value="javascript"/><esi:vars><a href="$(p):alert(1)">assigned</a></esi:vars>`))
+		case "/header":
+			w.Write([]byte(`<esi:vars><a href="$(HTTP_REFERER)">header</a></esi:vars><a href="$(QUERY_STRING{next})">query</a>`))
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 1, Sanitize: SanitizeConfig{Hosts: []string{host}}})
+	context := ProcessContext{
+		BaseURL: server.URL,
+		Headers: map[string]string{"Referer": "javascript:alert(2)", "Query-String": "next=javascript:alert(3)"},
+	}
+	result, err := processor.Process(`<esi:include src="/assign"/><esi:include src="/header"/>`, context)
+	require.NoError(t, err)
+	assert.Contains(t, result, "<a>assigned</a>")
+	assert.Contains(t, result, "<a>header</a>")
+	assert.Contains(t, result, "<a>query</a>")
+	assert.NotContains(t, result, "javascript")
+}
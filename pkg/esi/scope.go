@@ -0,0 +1,59 @@
+package esi
+
+// Variable scopes of esi:assign
+const (
+	ScopeLocal  = "local"  // The page, or the fragment the assignment is in
+	ScopeGlobal = "global" // The page, from within any of its fragments
+)
+
+// variableScope holds the variables assigned in a page or in one of its included
+// fragments. A fragment's scope sees the variables of the scopes it is nested in.
+type variableScope struct {
+	variables map[string]string
+	parent    *variableScope
+}
+
+// newVariableScope creates a scope nested in parent, or a page scope when parent is nil
+func newVariableScope(parent *variableScope) *variableScope {
+	return &variableScope{variables: make(map[string]string), parent: parent}
+}
+
+// newPageScope creates a page scope storing its variables in variables, or in a map of
+// its own when variables is nil
+func newPageScope(variables map[string]string) *variableScope {
+	if variables == nil {
+		return newVariableScope(nil)
+	}
+	return &variableScope{variables: variables}
+}
+
+// lookup returns the value of a variable from the innermost scope that assigned it
+func (s *variableScope) lookup(name string) (string, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value, exists := scope.variables[name]; exists {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// page returns the page scope s is nested in
+func (s *variableScope) page() *variableScope {
+	for s.parent != nil {
+		s = s.parent
+	}
+	return s
+}
+
+// visible returns the variables visible from s, inner scopes shadowing outer ones
+func (s *variableScope) visible() map[string]string {
+	variables := make(map[string]string)
+	for scope := s; scope != nil; scope = scope.parent {
+		for name, value := range scope.variables {
+			if _, shadowed := variables[name]; !shadowed {
+				variables[name] = value
+			}
+		}
+	}
+	return variables
+}
@@ -0,0 +1,135 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessor_AssignScope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/local":
+			w.Write([]byte(`<esi:assign name="tier" value="local" /><esi:vars><p>In $(tier)</p></esi:vars>`))
+		case "/global":
+			w.Write([]byte(`<esi:assign name="tier" value="global" scope="global" />`))
+		case "/reads":
+			w.Write([]byte(`<esi:vars><p>Sees $(tier|none)</p></esi:vars>`))
+		case "/nested":
+			w.Write([]byte(`<esi:include src="/global" /><esi:vars><p>Nested $(tier|none)</p></esi:vars>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "fragment assignments are local to the fragment",
+			input:    `<esi:include src="/local" /><esi:vars><p>Page $(tier|none)</p></esi:vars>`,
+			expected: []string{"<p>In local</p>", "<p>Page none</p>"},
+		},
+		{
+			name:     "fragment assignments shadow page variables",
+			input:    `<esi:assign name="tier" value="page" /><esi:include src="/local" /><esi:vars><p>Page $(tier)</p></esi:vars>`,
+			expected: []string{"<p>In local</p>", "<p>Page page</p>"},
+		},
+		{
+			name:     "global assignments are visible to the page",
+			input:    `<esi:include src="/global" /><esi:vars><p>Page $(tier|none)</p></esi:vars>`,
+			expected: []string{"<p>Page global</p>"},
+		},
+		{
+			name:     "global assignments from nested fragments are visible to the page",
+			input:    `<esi:include src="/nested" /><esi:vars><p>Page $(tier|none)</p></esi:vars>`,
+			expected: []string{"<p>Nested global</p>", "<p>Page global</p>"},
+		},
+		{
+			name:     "fragments see page variables",
+			input:    `<esi:assign name="tier" value="page" /><esi:include src="/reads" />`,
+			expected: []string{"<p>Sees page</p>"},
+		},
+		{
+			name:     "fragments see variables assigned above the include only",
+			input:    `<esi:include src="/reads" /><esi:assign name="tier" value="page" />`,
+			expected: []string{"<p>Sees none</p>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 2, BaseURL: server.URL})
+
+			result, err := processor.Process(tt.input, ProcessContext{BaseURL: server.URL})
+			require.NoError(t, err)
+
+			for _, expected := range tt.expected {
+				assert.Contains(t, result, expected)
+			}
+		})
+	}
+}
+
+func TestProcessor_AssignScopePerRequest(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+
+	result, err := processor.Process(`<esi:assign name="user" value="alice" /><esi:vars><p>$(user|none)</p></esi:vars>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>alice</p>")
+
+	result, err = processor.Process(`<esi:vars><p>$(user|none)</p></esi:vars>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>none</p>")
+}
+
+func TestProcessor_ContextVariables(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<esi:assign name="visits" value="2" scope="global" /><esi:assign name="local" value="fragment" />`))
+	}))
+	defer server.Close()
+
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 2, BaseURL: server.URL})
+	variables := map[string]string{"user": "alice", "visits": "1"}
+
+	result, err := processor.Process(`<esi:vars><p>$(user) $(visits)</p></esi:vars><esi:include src="/fragment" /><esi:assign name="tier" value="gold" />`,
+		ProcessContext{BaseURL: server.URL, Variables: variables})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>alice 1</p>")
+	assert.Equal(t, map[string]string{"user": "alice", "visits": "2", "tier": "gold"}, variables)
+
+	result, err = processor.Process(`<esi:vars><p>$(user) $(visits) $(tier)</p></esi:vars>`, ProcessContext{Variables: variables})
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>alice 2 gold</p>")
+}
+
+func TestVariableScope(t *testing.T) {
+	page := newVariableScope(nil)
+	page.variables["a"] = "page"
+	page.variables["b"] = "page"
+	fragment := newVariableScope(page)
+	fragment.variables["b"] = "fragment"
+
+	value, ok := fragment.lookup("a")
+	assert.True(t, ok)
+	assert.Equal(t, "page", value)
+
+	value, _ = fragment.lookup("b")
+	assert.Equal(t, "fragment", value)
+
+	_, ok = page.lookup("c")
+	assert.False(t, ok)
+
+	var none *variableScope
+	_, ok = none.lookup("a")
+	assert.False(t, ok)
+
+	assert.Same(t, page, fragment.page())
+	assert.Equal(t, map[string]string{"a": "page", "b": "fragment"}, fragment.visible())
+}
@@ -0,0 +1,70 @@
+package esi
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignURL_Verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signed, err := SignURL("/fragments/header?lang=en", "secret", now.Add(time.Minute))
+	require.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, "/fragments/header", u.Path)
+	assert.Equal(t, "en", u.Query().Get("lang"))
+	assert.Equal(t, "1700000060", u.Query().Get(SignedURLExpiresParam))
+
+	assert.NoError(t, VerifySignedURL(u, "secret", now))
+	assert.ErrorIs(t, VerifySignedURL(u, "other", now), ErrURLSignatureInvalid)
+	assert.ErrorIs(t, VerifySignedURL(u, "secret", now.Add(2*time.Minute)), ErrURLSignatureExpired)
+
+	// The signature covers the path and expiry
+	moved := *u
+	moved.Path = "/fragments/footer"
+	assert.ErrorIs(t, VerifySignedURL(&moved, "secret", now), ErrURLSignatureInvalid)
+	extended := *u
+	query := extended.Query()
+	query.Set(SignedURLExpiresParam, "1800000000")
+	extended.RawQuery = query.Encode()
+	assert.ErrorIs(t, VerifySignedURL(&extended, "secret", now), ErrURLSignatureInvalid)
+
+	unsigned, _ := url.Parse("/fragments/header")
+	assert.ErrorIs(t, VerifySignedURL(unsigned, "secret", now), ErrURLSignatureMissing)
+
+	// Re-signing replaces the previous signature
+	resigned, err := SignURL(signed, "secret", now.Add(time.Hour))
+	require.NoError(t, err)
+	u, _ = url.Parse(resigned)
+	assert.Len(t, u.Query()[SignedURLSignatureParam], 1)
+	assert.NoError(t, VerifySignedURL(u, "secret", now.Add(30*time.Minute)))
+}
+
+func TestAkamaiExtensions_SignURLFunction(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", URLSigning: URLSigningConfig{Key: "secret"}})
+	context := ProcessContext{Headers: map[string]string{"Host": "example.com"}, Cookies: map[string]string{}}
+
+	result, err := processor.Process(`<a href="x"><esi:function name="sign_url" input="/fragments/$(HTTP_HOST)" ttl="1h"></esi:function></a>`, context)
+	require.NoError(t, err)
+
+	match := regexp.MustCompile(`<a href="x">([^<]+)</a>`).FindStringSubmatch(result)
+	require.Len(t, match, 2, result)
+	u, err := url.Parse(html.UnescapeString(match[1]))
+	require.NoError(t, err)
+	assert.Equal(t, "/fragments/example.com", u.Path)
+	assert.NoError(t, VerifySignedURL(u, "secret", time.Now().Add(59*time.Minute)))
+	assert.ErrorIs(t, VerifySignedURL(u, "secret", time.Now().Add(61*time.Minute)), ErrURLSignatureExpired)
+
+	// Without a key the URL is left unsigned
+	processor = NewProcessor(Config{Mode: "akamai"})
+	result, err = processor.Process(`<p><esi:function name="sign_url" input="/fragments/header"></esi:function></p>`, context)
+	require.NoError(t, err)
+	assert.Contains(t, result, "<p>/fragments/header</p>")
+}
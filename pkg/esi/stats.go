@@ -0,0 +1,352 @@
+package esi
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBoundsMS are the upper bounds in milliseconds of the latency histogram buckets
+var LatencyBoundsMS = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// DefaultSlowIncludeThreshold is the fetch time from which an include is sampled as slow
+const DefaultSlowIncludeThreshold = 100 * time.Millisecond
+
+// slowIncludeSamples is the number of most recent slow includes kept
+const slowIncludeSamples = 20
+
+// Histogram counts latencies per bucket. Counts[i] holds the latencies up to
+// BoundsMS[i] and above BoundsMS[i-1]; the last count holds those above every bound.
+type Histogram struct {
+	BoundsMS []float64 `json:"boundsMs"`
+	Counts   []int64   `json:"counts"`
+	Count    int64     `json:"count"`
+	SumMS    float64   `json:"sumMs"`
+}
+
+// newHistogram creates an empty histogram with LatencyBoundsMS
+func newHistogram() Histogram {
+	return Histogram{
+		BoundsMS: LatencyBoundsMS,
+		Counts:   make([]int64, len(LatencyBoundsMS)+1),
+	}
+}
+
+// observe records a latency
+func (h *Histogram) observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	bucket := len(h.BoundsMS)
+	for i, bound := range h.BoundsMS {
+		if ms <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.Counts[bucket]++
+	h.Count++
+	h.SumMS += ms
+}
+
+// copy returns a histogram that does not share counts with h
+func (h Histogram) copy() Histogram {
+	h.Counts = append([]int64(nil), h.Counts...)
+	return h
+}
+
+// HostStats counts the include fetches sent to a host
+type HostStats struct {
+	Fetches      int64   `json:"fetches"`
+	Errors       int64   `json:"errors"`
+	TotalTimeMS  float64 `json:"totalTimeMs"`
+	SlowIncludes int64   `json:"slowIncludes"`
+}
+
+// IncludeSample describes a slow include fetch
+type IncludeSample struct {
+	URL        string    `json:"url"`
+	Host       string    `json:"host"`
+	DurationMS float64   `json:"durationMs"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// statsRecorder collects the statistics GetStats snapshots. Counters are atomic, so
+// concurrent requests and include fetches record them without a lock; the histograms,
+// host counters and slow include samples are guarded by mutex.
+type statsRecorder struct {
+	requests          atomic.Int64
+	cacheHits         atomic.Int64
+	cacheMiss         atomic.Int64
+	errors            atomic.Int64
+	totalTime         atomic.Int64 // Milliseconds
+	revalidations     atomic.Int64
+	notModified       atomic.Int64
+	templateCacheHits atomic.Int64
+	templateCacheMiss atomic.Int64
+	passThrough       atomic.Int64
+	sanitized         atomic.Int64
+	truncated         atomic.Int64
+	errorPages        atomic.Int64
+
+	// Buffered bytes of requests and buffer pool use, see MemoryStats
+	peakRequestBytes atomic.Int64
+	requestBytes     atomic.Int64 // Sum of the peaks of the requests measured
+	memoryRequests   atomic.Int64
+	bufferGets       atomic.Int64
+	bufferAllocs     atomic.Int64
+
+	window atomic.Pointer[statsWindow] // Per-second counters of the rolling windows, nil when disabled
+
+	mutex            sync.Mutex
+	processingTime   Histogram
+	includeFetchTime Histogram
+	includeHosts     map[string]HostStats
+	slowIncludes     []IncludeSample
+}
+
+// count adds one to counter and, when the rolling windows are on, to the counter of the
+// current second that update increments
+func (s *statsRecorder) count(counter *atomic.Int64, update func(slot *windowSlot)) {
+	counter.Add(1)
+	s.window.Load().add(time.Now(), update)
+}
+
+// includeFetch is the outcome of a single include fetch
+type includeFetch struct {
+	url       string
+	duration  time.Duration
+	status    int
+	err       error
+	requestID string
+}
+
+// recordProcessing adds a processing time to the statistics
+func (p *Processor) recordProcessing(d time.Duration) {
+	p.stats.totalTime.Add(d.Milliseconds())
+	p.stats.window.Load().add(time.Now(), func(slot *windowSlot) { slot.totalTime += d.Milliseconds() })
+
+	p.stats.mutex.Lock()
+	defer p.stats.mutex.Unlock()
+	p.stats.processingTime.observe(d)
+}
+
+// recordIncludeFetch adds an include fetch to the fetch time histogram and its host's
+// counters, and samples it when it took at least the slow include threshold
+func (p *Processor) recordIncludeFetch(fetch includeFetch) {
+	host := fetch.url
+	if parsed, err := url.Parse(fetch.url); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	ms := float64(fetch.duration) / float64(time.Millisecond)
+	slow := fetch.duration >= p.slowIncludeThreshold()
+
+	p.stats.mutex.Lock()
+	defer p.stats.mutex.Unlock()
+
+	p.stats.includeFetchTime.observe(fetch.duration)
+	hostStats := p.stats.includeHosts[host]
+	hostStats.Fetches++
+	hostStats.TotalTimeMS += ms
+	if fetch.err != nil {
+		hostStats.Errors++
+	}
+	if slow {
+		hostStats.SlowIncludes++
+	}
+	p.stats.includeHosts[host] = hostStats
+
+	if !slow {
+		return
+	}
+	sample := IncludeSample{
+		URL:        fetch.url,
+		Host:       host,
+		DurationMS: ms,
+		Status:     fetch.status,
+		RequestID:  fetch.requestID,
+		Time:       time.Now(),
+	}
+	if fetch.err != nil {
+		sample.Error = fetch.err.Error()
+	}
+	p.stats.slowIncludes = append(p.stats.slowIncludes, sample)
+	if len(p.stats.slowIncludes) > slowIncludeSamples {
+		p.stats.slowIncludes = p.stats.slowIncludes[len(p.stats.slowIncludes)-slowIncludeSamples:]
+	}
+}
+
+// slowIncludeThreshold returns the fetch time from which includes are sampled as slow
+func (p *Processor) slowIncludeThreshold() time.Duration {
+	if p.config.SlowIncludeMS > 0 {
+		return time.Duration(p.config.SlowIncludeMS) * time.Millisecond
+	}
+	return DefaultSlowIncludeThreshold
+}
+
+// StatsWindows are the rolling windows reported by GetStatsWindows, by name
+var StatsWindows = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+}
+
+// windowSeconds is the span of the per-second counters kept for the rolling windows
+const windowSeconds = 300
+
+// WindowStats holds the counters of a rolling window
+type WindowStats struct {
+	Seconds           int     `json:"seconds"`
+	Requests          int64   `json:"requests"`
+	CacheHits         int64   `json:"cacheHits"`
+	CacheMiss         int64   `json:"cacheMiss"`
+	Errors            int64   `json:"errors"`
+	PassThrough       int64   `json:"passThrough"`
+	TotalTime         int64   `json:"totalTime"` // Total processing time in milliseconds
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+}
+
+// windowSlot holds the counters of a single second
+type windowSlot struct {
+	second      int64
+	requests    int64
+	cacheHits   int64
+	cacheMiss   int64
+	errors      int64
+	passThrough int64
+	totalTime   int64
+}
+
+// statsWindow keeps per-second counters of the last windowSeconds seconds in a ring;
+// a nil window records nothing
+type statsWindow struct {
+	slots []windowSlot
+	mutex sync.Mutex
+}
+
+// newStatsWindow creates the rolling window counters when enabled
+func newStatsWindow(enabled bool) *statsWindow {
+	if !enabled {
+		return nil
+	}
+	return &statsWindow{slots: make([]windowSlot, windowSeconds)}
+}
+
+// add updates the counters of the second now falls in
+func (w *statsWindow) add(now time.Time, update func(slot *windowSlot)) {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	second := now.Unix()
+	slot := &w.slots[second%int64(len(w.slots))]
+	if slot.second != second {
+		*slot = windowSlot{second: second}
+	}
+	update(slot)
+}
+
+// sum adds up the counters of the span seconds up to and including now
+func (w *statsWindow) sum(now time.Time, span time.Duration) WindowStats {
+	seconds := int(span / time.Second)
+	if seconds > len(w.slots) {
+		seconds = len(w.slots)
+	}
+	stats := WindowStats{Seconds: seconds}
+	current := now.Unix()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, slot := range w.slots {
+		if age := current - slot.second; age < 0 || age >= int64(seconds) {
+			continue
+		}
+		stats.Requests += slot.requests
+		stats.CacheHits += slot.cacheHits
+		stats.CacheMiss += slot.cacheMiss
+		stats.Errors += slot.errors
+		stats.PassThrough += slot.passThrough
+		stats.TotalTime += slot.totalTime
+	}
+	if seconds > 0 {
+		stats.RequestsPerSecond = float64(stats.Requests) / float64(seconds)
+	}
+	return stats
+}
+
+// GetStatsWindows returns the counters of the rolling one- and five-minute windows by
+// name, or nil when Config.StatsWindows is off
+func (p *Processor) GetStatsWindows() map[string]WindowStats {
+	window := p.stats.window.Load()
+	if window == nil {
+		return nil
+	}
+	now := time.Now()
+	windows := make(map[string]WindowStats, len(StatsWindows))
+	for name, span := range StatsWindows {
+		windows[name] = window.sum(now, span)
+	}
+	return windows
+}
+
+// ResetStats zeroes the processing statistics, including the rolling windows, and
+// returns the statistics collected up to the reset
+func (p *Processor) ResetStats() Stats {
+	return p.stats.snapshot(true, p.config.StatsWindows)
+}
+
+// reset replaces the counters with empty ones, keeping rolling windows when windows is set
+func (s *statsRecorder) reset(windows bool) {
+	s.snapshot(true, windows)
+}
+
+// snapshot returns a copy of the statistics that shares no state with s. With reset set,
+// the counters are zeroed as they are read, so no update is lost or counted twice, and
+// the rolling windows are replaced by empty ones when windows is set.
+func (s *statsRecorder) snapshot(reset, windows bool) Stats {
+	read := func(counter *atomic.Int64) int64 {
+		if reset {
+			return counter.Swap(0)
+		}
+		return counter.Load()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := Stats{
+		Requests:          read(&s.requests),
+		CacheHits:         read(&s.cacheHits),
+		CacheMiss:         read(&s.cacheMiss),
+		Errors:            read(&s.errors),
+		TotalTime:         read(&s.totalTime),
+		Revalidations:     read(&s.revalidations),
+		NotModified:       read(&s.notModified),
+		TemplateCacheHits: read(&s.templateCacheHits),
+		TemplateCacheMiss: read(&s.templateCacheMiss),
+		PassThrough:       read(&s.passThrough),
+		Sanitized:         read(&s.sanitized),
+		Truncated:         read(&s.truncated),
+		ErrorPages:        read(&s.errorPages),
+		Memory:            s.memoryStats(read),
+		ProcessingTime:    s.processingTime.copy(),
+		IncludeFetchTime:  s.includeFetchTime.copy(),
+		IncludeHosts:      make(map[string]HostStats, len(s.includeHosts)),
+		SlowIncludes:      append([]IncludeSample{}, s.slowIncludes...),
+	}
+	for host, hostStats := range s.includeHosts {
+		stats.IncludeHosts[host] = hostStats
+	}
+
+	if reset {
+		s.processingTime = newHistogram()
+		s.includeFetchTime = newHistogram()
+		s.includeHosts = make(map[string]HostStats)
+		s.slowIncludes = nil
+		s.window.Store(newStatsWindow(windows))
+	}
+	return stats
+}
@@ -0,0 +1,104 @@
+package esi
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessor_ResetStats(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	for i := 0; i < 3; i++ {
+		_, err := processor.Process(`<p>plain</p>`, ProcessContext{})
+		require.NoError(t, err)
+	}
+
+	previous := processor.ResetStats()
+	assert.Equal(t, int64(3), previous.Requests)
+	assert.Equal(t, int64(3), previous.PassThrough)
+	assert.Equal(t, int64(3), previous.ProcessingTime.Count)
+
+	stats := processor.GetStats()
+	assert.Zero(t, stats.Requests)
+	assert.Zero(t, stats.PassThrough)
+	assert.Zero(t, stats.ProcessingTime.Count)
+	assert.Len(t, stats.ProcessingTime.Counts, len(LatencyBoundsMS)+1)
+	assert.Empty(t, stats.IncludeHosts)
+	assert.Nil(t, processor.GetStatsWindows())
+
+	_, err := processor.Process(`<p>plain</p>`, ProcessContext{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), processor.GetStats().Requests)
+}
+
+func TestProcessor_StatsConcurrent(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, StatsWindows: true})
+
+	var wg sync.WaitGroup
+	var reset Stats
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := processor.Process(`<p>plain</p>`, ProcessContext{})
+				assert.NoError(t, err)
+				processor.GetStats()
+				processor.GetStatsWindows()
+				if worker == 0 && j == 25 {
+					reset = processor.ResetStats()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every request is counted exactly once, before or after the reset
+	stats := processor.GetStats()
+	assert.Equal(t, int64(400), reset.Requests+stats.Requests)
+	assert.Equal(t, int64(400), reset.PassThrough+stats.PassThrough)
+	assert.NotZero(t, processor.GetStatsWindows()["1m"].Requests)
+}
+
+func TestProcessor_StatsWindows(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5, StatsWindows: true})
+	_, err := processor.Process(`<p>plain</p>`, ProcessContext{})
+	require.NoError(t, err)
+
+	windows := processor.GetStatsWindows()
+	require.Len(t, windows, 2)
+	assert.Equal(t, 60, windows["1m"].Seconds)
+	assert.Equal(t, int64(1), windows["1m"].Requests)
+	assert.Equal(t, int64(1), windows["5m"].PassThrough)
+
+	processor.ResetStats()
+	assert.Zero(t, processor.GetStatsWindows()["5m"].Requests)
+}
+
+func TestStatsWindow_Sum(t *testing.T) {
+	window := newStatsWindow(true)
+	now := time.Unix(1_000_000, 0)
+	window.add(now.Add(-400*time.Second), func(slot *windowSlot) { slot.requests += 100 })
+	window.add(now.Add(-200*time.Second), func(slot *windowSlot) { slot.requests += 10 })
+	window.add(now.Add(-30*time.Second), func(slot *windowSlot) { slot.requests++; slot.errors++ })
+	window.add(now, func(slot *windowSlot) { slot.requests++; slot.totalTime += 5 })
+
+	minute := window.sum(now, time.Minute)
+	assert.Equal(t, int64(2), minute.Requests)
+	assert.Equal(t, int64(1), minute.Errors)
+	assert.Equal(t, int64(5), minute.TotalTime)
+	assert.InDelta(t, 2.0/60, minute.RequestsPerSecond, 1e-9)
+
+	// The slot of 400 seconds ago was reused and is outside the window
+	assert.Equal(t, int64(12), window.sum(now, 5*time.Minute).Requests)
+
+	// A reused slot starts from zero
+	window.add(now.Add(300*time.Second), func(slot *windowSlot) { slot.requests++ })
+	assert.Equal(t, int64(1), window.sum(now.Add(300*time.Second), time.Second).Requests)
+
+	var disabled *statsWindow
+	disabled.add(now, func(slot *windowSlot) { slot.requests++ })
+}
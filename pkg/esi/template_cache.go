@@ -0,0 +1,116 @@
+package esi
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// DefaultTemplateCacheSize is the number of parsed templates kept by default
+const DefaultTemplateCacheSize = 256
+
+// templateCache keeps the most recently used parsed templates by content hash
+type templateCache struct {
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List // Most recently used first
+	mutex   sync.Mutex
+}
+
+// templateCacheEntry is a parsed template and the hash of its content
+type templateCacheEntry struct {
+	hash     [sha256.Size]byte
+	template *Template
+}
+
+// newTemplateCache creates a cache of size templates; zero selects
+// DefaultTemplateCacheSize and a negative size disables caching
+func newTemplateCache(size int) *templateCache {
+	if size == 0 {
+		size = DefaultTemplateCacheSize
+	}
+	return &templateCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the template parsed from content, if cached
+func (c *templateCache) get(hash [sha256.Size]byte) (*Template, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[hash]
+	if !exists {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*templateCacheEntry).template, true
+}
+
+// put stores a parsed template, evicting the least recently used one when full
+func (c *templateCache) put(hash [sha256.Size]byte, template *Template) {
+	if c.size < 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[hash]; exists {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[hash] = c.order.PushFront(&templateCacheEntry{hash: hash, template: template})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*templateCacheEntry).hash)
+	}
+}
+
+// len returns the number of cached templates
+func (c *templateCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+// parseTemplate parses content, reusing the template parsed from identical content
+// when it is still cached
+func (p *Processor) parseTemplate(content string) (*Template, error) {
+	if p.templates.size < 0 {
+		return ParseTemplate(content)
+	}
+
+	hash := sha256.Sum256([]byte(content))
+	if template, ok := p.templates.get(hash); ok {
+		p.stats.templateCacheHits.Add(1)
+		return template, nil
+	}
+
+	p.stats.templateCacheMiss.Add(1)
+
+	template, err := ParseTemplate(content)
+	if err != nil {
+		return nil, err
+	}
+	p.templates.put(hash, template)
+	return template, nil
+}
+
+// GetTemplateCacheSize returns the number of cached parsed templates
+func (p *Processor) GetTemplateCacheSize() int {
+	return p.templates.len()
+}
+
+// TemplateCacheHitRate returns the share of template parses answered by the template
+// cache, from 0 to 1
+func (s *Stats) TemplateCacheHitRate() float64 {
+	lookups := s.TemplateCacheHits + s.TemplateCacheMiss
+	if lookups == 0 {
+		return 0
+	}
+	return float64(s.TemplateCacheHits) / float64(lookups)
+}
@@ -0,0 +1,62 @@
+package esi
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessor_TemplateCache(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+	page := `<html><body><esi:vars><p>Host: $(HTTP_HOST)</p></esi:vars></body></html>`
+
+	for _, host := range []string{"a.example", "b.example", "c.example"} {
+		result, err := processor.Process(page, ProcessContext{Headers: map[string]string{"Host": host}})
+		require.NoError(t, err)
+		assert.Contains(t, result, "<p>Host: "+host+"</p>")
+	}
+
+	stats := processor.GetStats()
+	assert.Equal(t, int64(2), stats.TemplateCacheHits)
+	assert.Equal(t, int64(1), stats.TemplateCacheMiss)
+	assert.InDelta(t, 2.0/3.0, stats.TemplateCacheHitRate(), 0.001)
+	assert.Equal(t, 1, processor.GetTemplateCacheSize())
+}
+
+func TestProcessor_TemplateCacheDisabled(t *testing.T) {
+	processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, TemplateCacheSize: -1})
+
+	for i := 0; i < 2; i++ {
+		_, err := processor.Process(`<p>Page</p>`, ProcessContext{})
+		require.NoError(t, err)
+	}
+
+	stats := processor.GetStats()
+	assert.Zero(t, stats.TemplateCacheHits)
+	assert.Zero(t, stats.TemplateCacheMiss)
+	assert.Zero(t, stats.TemplateCacheHitRate())
+	assert.Zero(t, processor.GetTemplateCacheSize())
+}
+
+func TestTemplateCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTemplateCache(2)
+	hash := func(i int) [sha256.Size]byte { return sha256.Sum256([]byte(fmt.Sprint(i))) }
+
+	cache.put(hash(1), &Template{})
+	cache.put(hash(2), &Template{})
+	_, ok := cache.get(hash(1))
+	require.True(t, ok)
+
+	cache.put(hash(3), &Template{})
+	assert.Equal(t, 2, cache.len())
+
+	_, ok = cache.get(hash(1))
+	assert.True(t, ok, "recently used template is kept")
+	_, ok = cache.get(hash(2))
+	assert.False(t, ok, "least recently used template is evicted")
+	_, ok = cache.get(hash(3))
+	assert.True(t, ok)
+}
@@ -0,0 +1,122 @@
+package esi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// OriginTLS configures TLS for the include fetches sent to a host, so fragments of
+// internal origins with a private PKI or client certificate authentication can be fetched
+type OriginTLS struct {
+	Host string `json:"host"` // Host or host:port the settings apply to; "*" matches every host
+	// CAFile is a PEM bundle of CA certificates trusted in addition to the system roots
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are the PEM client certificate and key presented for mTLS
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// InsecureSkipVerify accepts any server certificate; for development only
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// ValidateOriginTLS checks that origin TLS settings name a host, give client
+// certificates with their keys and that their files load
+func ValidateOriginTLS(origins []OriginTLS) error {
+	for i, origin := range origins {
+		if origin.Host == "" {
+			return fmt.Errorf("origin TLS %d: host is required", i+1)
+		}
+		if _, err := origin.config(); err != nil {
+			return fmt.Errorf("origin TLS %d (%s): %w", i+1, origin.Host, err)
+		}
+	}
+	return nil
+}
+
+// config returns the TLS client configuration of the origin
+func (o OriginTLS) config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", o.CAFile)
+		}
+		config.RootCAs = roots
+	}
+
+	switch {
+	case o.CertFile != "" && o.KeyFile != "":
+		certificate, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	case o.CertFile != "" || o.KeyFile != "":
+		return nil, errors.New("certFile and keyFile must be given together")
+	}
+	return config, nil
+}
+
+// originTLSTransport sends each fetch through the transport of the first origin TLS
+// settings matching its host, or through next when none match
+type originTLSTransport struct {
+	next    http.RoundTripper
+	origins []OriginTLS
+	// transports are the transports of origins, by index; nil where the settings did not load
+	transports []http.RoundTripper
+	errors     []error
+}
+
+// newOriginTLSTransport creates a transport applying origins in front of next, the
+// transport of the other hosts whose settings the origins' transports start from.
+// Settings that fail to load make the fetches to their host fail with the loading error.
+func newOriginTLSTransport(next *http.Transport, origins []OriginTLS) http.RoundTripper {
+	if len(origins) == 0 {
+		return next
+	}
+
+	t := &originTLSTransport{
+		next:       next,
+		origins:    append([]OriginTLS(nil), origins...),
+		transports: make([]http.RoundTripper, len(origins)),
+		errors:     make([]error, len(origins)),
+	}
+	for i, origin := range origins {
+		config, err := origin.config()
+		if err != nil {
+			t.errors[i] = fmt.Errorf("origin TLS for %s: %w", origin.Host, err)
+			continue
+		}
+		transport := next.Clone()
+		transport.TLSClientConfig = config
+		t.transports[i] = transport
+	}
+	return t
+}
+
+// RoundTrip sends the fetch with the TLS settings of its host
+func (t *originTLSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	for i, origin := range t.origins {
+		if origin.Host != "*" && !strings.EqualFold(origin.Host, host) && !strings.EqualFold(origin.Host, hostname(host)) {
+			continue
+		}
+		if t.errors[i] != nil {
+			return nil, t.errors[i]
+		}
+		return t.transports[i].RoundTrip(req)
+	}
+	return t.next.RoundTrip(req)
+}
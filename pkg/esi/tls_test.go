@@ -0,0 +1,124 @@
+package esi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its key to dir
+func writeClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "esi-emulator"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certificate, certFile, keyFile
+}
+
+func TestOriginTLS_Fetch(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := writeClientCertificate(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>Internal</p>"))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // Failed handshakes are expected
+	server.StartTLS()
+	defer server.Close()
+
+	mtlsServer := httptest.NewUnstartedServer(server.Config.Handler)
+	mtlsServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mtlsServer.Config.ErrorLog = server.Config.ErrorLog
+	mtlsServer.StartTLS()
+	defer mtlsServer.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	for _, s := range []*httptest.Server{server, mtlsServer} {
+		bundle, _ := os.ReadFile(caFile)
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})...)
+		require.NoError(t, os.WriteFile(caFile, bundle, 0o600))
+	}
+
+	tests := []struct {
+		name   string
+		origin *OriginTLS
+		server *httptest.Server
+		err    string
+	}{
+		{name: "private PKI untrusted", server: server, err: "certificate"},
+		{name: "custom CA", origin: &OriginTLS{Host: "127.0.0.1", CAFile: caFile}, server: server},
+		{name: "insecure skip verify", origin: &OriginTLS{Host: "*", InsecureSkipVerify: true}, server: server},
+		{name: "other host", origin: &OriginTLS{Host: "internal.example", InsecureSkipVerify: true}, server: server, err: "certificate"},
+		{name: "mTLS without client certificate", origin: &OriginTLS{Host: "127.0.0.1", CAFile: caFile}, server: mtlsServer, err: "fetch"},
+		{name: "mTLS", origin: &OriginTLS{Host: "127.0.0.1", CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, server: mtlsServer},
+		{name: "unloadable settings", origin: &OriginTLS{Host: "127.0.0.1", CAFile: filepath.Join(dir, "missing.pem")}, server: server, err: "origin TLS for 127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Mode: "akamai", MaxIncludes: 10}
+			if tt.origin != nil {
+				config.TLS = []OriginTLS{*tt.origin}
+			}
+			processor := NewProcessor(config)
+
+			content, err := processor.fetchInclude("/fragment", ProcessContext{BaseURL: tt.server.URL})
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "<p>Internal</p>", content)
+		})
+	}
+}
+
+func TestValidateOriginTLS(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeClientCertificate(t, dir)
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate"), 0o600))
+
+	assert.NoError(t, ValidateOriginTLS([]OriginTLS{{Host: "internal.example", CAFile: certFile, CertFile: certFile, KeyFile: keyFile}}))
+	assert.ErrorContains(t, ValidateOriginTLS([]OriginTLS{{InsecureSkipVerify: true}}), "host is required")
+	assert.ErrorContains(t, ValidateOriginTLS([]OriginTLS{{Host: "a", CertFile: certFile}}), "must be given together")
+	assert.ErrorContains(t, ValidateOriginTLS([]OriginTLS{{Host: "a", CAFile: empty}}), "no certificates found")
+	assert.ErrorContains(t, ValidateOriginTLS([]OriginTLS{{Host: "a", CertFile: keyFile, KeyFile: keyFile}}), "loading client certificate")
+}
@@ -0,0 +1,377 @@
+package esi
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// rawTextElements are the elements whose text is rendered without escaping
+var rawTextElements = map[string]bool{
+	atom.Script.String(): true, atom.Style.String(): true,
+}
+
+// walker executes a parsed template in a single pass in document order, so every
+// element sees the variables assigned and the branches chosen above it. Branches
+// that are not chosen are never evaluated.
+type walker struct {
+	p        *Processor
+	context  ProcessContext
+	includes *int // Includes fetched for the page, shared with its fragments
+	failures int  // Includes that failed without onerror="continue", for esi:try
+	// Includes fetched ahead by the esi:parallel groups being executed
+	prefetched map[*Node]includeResult
+}
+
+// execute executes a parsed template and returns the resulting markup
+func (p *Processor) execute(template *Template, context ProcessContext) string {
+	var includes int
+	w := &walker{p: p, context: context, includes: &includes}
+
+	out := p.getBuffer(context.memory)
+	defer p.putBuffer(out)
+	w.walk(out.Buffer, template.Nodes, false)
+	return out.String()
+}
+
+// walk executes nodes in order. Within esi:vars, expand is set and variables are
+// expanded as they are reached.
+func (w *walker) walk(out *bytes.Buffer, nodes []*Node, expand bool) {
+	for _, n := range nodes {
+		w.walkNode(out, n, expand)
+	}
+}
+
+// walkNode executes a node, writing its output
+func (w *walker) walkNode(out *bytes.Buffer, n *Node, expand bool) {
+	switch n.Type {
+	case TextNode:
+		if expand {
+			out.WriteString(w.expandMarkup(n.Text))
+		} else {
+			out.WriteString(n.Text)
+		}
+		return
+	case CommentBlockNode:
+		if !w.p.features.CommentBlocks {
+			out.WriteString(n.Text)
+			return
+		}
+		if w.p.debugEnabled() {
+			fmt.Printf("📝 Processing ESI comment block at line %d\n", n.Line)
+		}
+		w.walk(out, n.Children, expand)
+		return
+	}
+
+	features := w.p.features
+	akamai := w.p.akamaiEnabled()
+
+	switch name := n.ESIName(); {
+	case name == "include" && features.Include:
+		w.include(out, n, expand)
+	case name == "choose" && features.Choose:
+		w.choose(out, n, expand)
+	case name == "try" && features.Try:
+		w.try(out, n, expand)
+	case name == "vars" && features.Vars:
+		w.walk(out, n.Children, true)
+	case name == "comment" && features.Comment, name == "remove" && features.Remove:
+	case name == "assign" && akamai:
+		w.p.akamaiExt.assign(n.selection(), w.context)
+	case name == "eval" && akamai:
+		out.WriteString(w.p.akamaiExt.eval(n.selection(), w.context))
+	case name == "function" && akamai:
+		out.WriteString(w.p.akamaiExt.function(n.selection(), w.context))
+	case name == "dictionary" && akamai:
+		out.WriteString(w.p.akamaiExt.dictionary(n.selection(), w.context))
+	case name == "lookup" && akamai:
+		out.WriteString(w.p.akamaiExt.lookup(n.selection(), w.context))
+	case name == "parallel" && akamai:
+		w.parallel(out, n, expand)
+	case name == "sequential" && akamai:
+		w.walk(out, n.Children, expand)
+	case name == "debug" && akamai:
+		out.WriteString(w.p.akamaiExt.debug(n.selection(), w.context))
+	default:
+		w.unprocessed(out, n, expand)
+	}
+}
+
+// unprocessed writes an element the mode does not process as written, executing its content
+func (w *walker) unprocessed(out *bytes.Buffer, n *Node, expand bool) {
+	if expand {
+		out.WriteString(w.p.ExpandESIVariables(n.startTag, w.context))
+	} else {
+		out.WriteString(n.startTag)
+	}
+	w.walk(out, n.Children, expand)
+	out.WriteString(n.endTag)
+}
+
+// include executes an esi:include element. The fetched fragment is parsed and
+// executed one level deeper; includes beyond MaxDepth fail. Includes prefetched by
+// an esi:parallel group use the prefetched response.
+func (w *walker) include(out *bytes.Buffer, n *Node, expand bool) {
+	s := n.selection()
+	if w.p.akamaiEnabled() {
+		w.p.akamaiExt.extendedInclude(s)
+	}
+
+	*w.includes++
+	if *w.includes > w.p.config.MaxIncludes {
+		if w.p.debugEnabled() {
+			fmt.Printf("⚠️  Maximum includes exceeded at line %d: %d\n", n.Line, w.p.config.MaxIncludes)
+		}
+		w.unprocessed(out, n, false)
+		return
+	}
+
+	result, prefetched := w.prefetched[n]
+	if !prefetched {
+		result = w.fetch(n, expand, w.context)
+	}
+	if result.src == "" {
+		if w.p.debugEnabled() {
+			fmt.Printf("⚠️  esi:include at line %d missing src attribute\n", n.Line)
+		}
+		return
+	}
+
+	// The response of the include, or of its alt, is exposed to the markup after it
+	w.context.lastInclude = result.response
+	w.context.outcomes.record(result.src, result.err != nil, result.required)
+	if result.err != nil {
+		// Handle onerror="continue"
+		if onerror, _ := n.GetAttr("onerror"); onerror == "continue" {
+			return
+		}
+		w.failures++
+		if w.p.debugEnabled() {
+			fmt.Fprintf(out, "<!-- ESI include error at line %d: %v -->", n.Line, result.err)
+		}
+		return
+	}
+	out.WriteString(w.fragment(result.content, result.untrusted))
+}
+
+// includeResult is the outcome of fetching an include, its alt included
+type includeResult struct {
+	src      string // Expanded src, empty when the include has none
+	required bool   // A failure fails the whole page (see Config.ErrorPage)
+	// untrusted is set when the content came from a host the sanitizer policy covers
+	untrusted bool
+	content   string
+	err       error
+	response  *includeResponse
+}
+
+// fetch fetches the fragment of an include element, or of its alt when it fails. It
+// does not change the walker, so the includes of a parallel group are fetched
+// concurrently.
+func (w *walker) fetch(n *Node, expand bool, context ProcessContext) includeResult {
+	src, _ := n.GetAttr("src")
+	alt, _ := n.GetAttr("alt")
+	if expand {
+		src = w.p.ExpandESIVariables(src, context)
+		alt = w.p.ExpandESIVariables(alt, context)
+	}
+	requiredAttr, _ := n.GetAttr("required")
+	result := includeResult{src: src, required: requiredAttr == "true", response: &includeResponse{}}
+	if src == "" {
+		return result
+	}
+
+	context.lastInclude = result.response
+	result.err = fmt.Errorf("maximum include depth exceeded: %d", w.p.config.MaxDepth)
+	if context.Depth <= w.p.config.MaxDepth {
+		result.content, result.err = w.p.fetchIncludeRequest(w.p.includeRequest(n.selection(), src, context), context)
+	}
+	if result.err == nil {
+		result.untrusted = w.p.untrustedInclude(src, context)
+		return result
+	}
+	if w.p.debugEnabled() {
+		fmt.Printf("⚠️  Include failed for %s at line %d: %v\n", src, n.Line, result.err)
+	}
+
+	// Try alt URL if available
+	if alt != "" && context.Depth <= w.p.config.MaxDepth {
+		context.lastInclude = &includeResponse{}
+		altContent, altErr := w.p.fetchInclude(alt, context)
+		result.response = context.lastInclude
+		if altErr == nil {
+			result.content, result.err = altContent, nil
+			result.untrusted = w.p.untrustedInclude(alt, context)
+			return result
+		}
+		if w.p.debugEnabled() {
+			fmt.Printf("⚠️  Alt include failed for %s at line %d: %v\n", alt, n.Line, altErr)
+		}
+	}
+	return result
+}
+
+// fragment preprocesses, parses and executes an included fragment one level deeper,
+// in a scope of its own nested in the scope of the include. The output of untrusted
+// fragments is sanitized again: their ESI can build script URLs and event handlers
+// from variables the sanitizer did not see in the fetched markup.
+func (w *walker) fragment(content string, untrusted bool) string {
+	context := w.context
+	context.Depth++
+
+	preprocessed, err := w.p.mode.PreProcess(content, context)
+	if err != nil {
+		if w.p.debugEnabled() {
+			fmt.Printf("⚠️  Failed to preprocess included fragment: %v\n", err)
+		}
+		return content
+	}
+	template, err := w.p.parseTemplate(preprocessed)
+	if err != nil {
+		if w.p.debugEnabled() {
+			fmt.Printf("⚠️  Failed to parse included fragment: %v\n", err)
+		}
+		return content
+	}
+
+	context.scope = newVariableScope(context.scope)
+	fragment := &walker{p: w.p, context: context, includes: w.includes}
+
+	out := w.p.getBuffer(context.memory)
+	defer w.p.putBuffer(out)
+	fragment.walk(out.Buffer, template.Nodes, false)
+	if !untrusted {
+		return out.String()
+	}
+
+	// Variables left for the page's final expansion are expanded here, in the
+	// fragment's scope, so the sanitizer sees their values
+	output := out.String()
+	if w.p.akamaiEnabled() {
+		output = w.p.akamaiExt.expandVariables(output, context)
+	}
+	return Sanitize(output, w.p.config.Sanitize)
+}
+
+// choose executes an esi:choose element. The first esi:when whose test is true is
+// chosen, or esi:otherwise when none is; only the chosen branch is executed.
+func (w *walker) choose(out *bytes.Buffer, n *Node, expand bool) {
+	var chosen, otherwise *Node
+	for _, c := range n.Children {
+		if c.Type != ElementNode {
+			continue
+		}
+		switch c.ESIName() {
+		case "when":
+			if chosen != nil {
+				continue
+			}
+			test, _ := c.GetAttr("test")
+			if test == "" {
+				if w.p.debugEnabled() {
+					fmt.Printf("⚠️  esi:when at line %d missing test attribute\n", c.Line)
+				}
+				continue
+			}
+			if w.p.evaluateExpression(test, w.context) == "true" {
+				chosen = c
+				if w.p.debugEnabled() {
+					fmt.Printf("✅ esi:when condition '%s' at line %d matched\n", test, c.Line)
+				}
+			}
+		case "otherwise":
+			if otherwise == nil {
+				otherwise = c
+			}
+		}
+	}
+
+	if chosen == nil && otherwise != nil {
+		chosen = otherwise
+		if w.p.debugEnabled() {
+			fmt.Printf("✅ Using esi:otherwise content at line %d\n", otherwise.Line)
+		}
+	}
+	if chosen != nil {
+		w.walk(out, chosen.Children, expand)
+	}
+}
+
+// try executes an esi:try element. The esi:attempt branch is kept unless one of its
+// includes failed, in which case the esi:except branch is executed instead.
+func (w *walker) try(out *bytes.Buffer, n *Node, expand bool) {
+	var attempt, except *Node
+	for _, c := range n.Children {
+		if c.Type != ElementNode {
+			continue
+		}
+		switch c.ESIName() {
+		case "attempt":
+			if attempt == nil {
+				attempt = c
+			}
+		case "except":
+			if except == nil {
+				except = c
+			}
+		}
+	}
+	if attempt == nil {
+		return
+	}
+
+	// A failure handled here does not fail an enclosing esi:try
+	failures := w.failures
+	attempted := w.p.getBuffer(w.context.memory)
+	defer w.p.putBuffer(attempted)
+	w.walk(attempted.Buffer, attempt.Children, expand)
+	failed := w.failures > failures
+	w.failures = failures
+
+	switch {
+	case !failed:
+		out.Write(attempted.Bytes())
+	case except != nil:
+		if w.p.debugEnabled() {
+			fmt.Printf("✅ Using esi:except content at line %d due to error\n", except.Line)
+		}
+		w.walk(out, except.Children, expand)
+	}
+}
+
+// expandMarkup expands the variables in markup within esi:vars. Text is escaped as
+// it would be rendered before variables are expanded, and variable values are
+// inserted as HTML.
+func (w *walker) expandMarkup(markup string) string {
+	if !strings.Contains(markup, "$(") {
+		return markup
+	}
+
+	var out strings.Builder
+	var rawText bool
+	tokenizer := html.NewTokenizer(strings.NewReader(markup))
+	for tokenType := tokenizer.Next(); tokenType != html.ErrorToken; tokenType = tokenizer.Next() {
+		raw := string(tokenizer.Raw())
+		if tokenType == html.TextToken && !rawText {
+			raw = html.EscapeString(html.UnescapeString(raw))
+		}
+		out.WriteString(w.p.ExpandESIVariables(raw, w.context))
+
+		if tokenType == html.StartTagToken {
+			name, _ := tokenizer.TagName()
+			rawText = rawTextElements[string(name)]
+		} else {
+			rawText = false
+		}
+	}
+	return out.String()
+}
+
+// akamaiEnabled reports whether the Akamai extension elements are processed
+func (p *Processor) akamaiEnabled() bool {
+	return p.mode.Extensions() && p.akamaiExt != nil
+}
@@ -0,0 +1,149 @@
+package esi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessor_DocumentOrder(t *testing.T) {
+	tests := []struct {
+		name             string
+		html             string
+		context          ProcessContext
+		shouldContain    []string
+		shouldNotContain []string
+	}{
+		{
+			name:             "assign above choose",
+			html:             `<html><body><esi:assign name="tier" value="gold" /><esi:choose><esi:when test="$(tier) == 'gold'"><p>Gold</p></esi:when><esi:otherwise><p>Standard</p></esi:otherwise></esi:choose></body></html>`,
+			shouldContain:    []string{"<p>Gold</p>"},
+			shouldNotContain: []string{"<p>Standard</p>"},
+		},
+		{
+			name:             "assign below choose",
+			html:             `<html><body><esi:choose><esi:when test="$(tier) == 'gold'"><p>Gold</p></esi:when><esi:otherwise><p>Standard</p></esi:otherwise></esi:choose><esi:assign name="tier" value="gold" /></body></html>`,
+			shouldContain:    []string{"<p>Standard</p>"},
+			shouldNotContain: []string{"<p>Gold</p>"},
+		},
+		{
+			name:             "reassigned between chooses",
+			html:             `<html><body><esi:assign name="tier" value="gold" /><esi:choose><esi:when test="$(tier) == 'gold'"><p>First gold</p></esi:when></esi:choose><esi:assign name="tier" value="silver" /><esi:choose><esi:when test="$(tier) == 'gold'"><p>Second gold</p></esi:when><esi:otherwise><p>Second silver</p></esi:otherwise></esi:choose></body></html>`,
+			shouldContain:    []string{"<p>First gold</p>", "<p>Second silver</p>"},
+			shouldNotContain: []string{"<p>Second gold</p>"},
+		},
+		{
+			name:             "assign in unchosen branch",
+			html:             `<html><body><esi:choose><esi:when test="'a' == 'b'"><esi:assign name="tier" value="gold" /></esi:when><esi:otherwise><esi:assign name="tier" value="silver" /></esi:otherwise></esi:choose><esi:vars><p>Tier: $(tier)</p></esi:vars></body></html>`,
+			shouldContain:    []string{"<p>Tier: silver</p>"},
+			shouldNotContain: []string{"gold"},
+		},
+		{
+			name: "choose nested in vars",
+			html: `<html><body><esi:vars><esi:choose><esi:when test="$(HTTP_HOST) == 'example.com'"><p>Host: $(HTTP_HOST)</p></esi:when><esi:otherwise><p>Other</p></esi:otherwise></esi:choose></esi:vars></body></html>`,
+			context: ProcessContext{
+				Headers: map[string]string{"Host": "example.com"},
+			},
+			shouldContain:    []string{"<p>Host: example.com</p>"},
+			shouldNotContain: []string{"<p>Other</p>", "$(HTTP_HOST)"},
+		},
+		{
+			name:             "assign within vars",
+			html:             `<html><body><esi:vars><p>Before: $(tier|none)</p><esi:assign name="tier" value="gold" /><p>After: $(tier|none)</p></esi:vars></body></html>`,
+			shouldContain:    []string{"<p>Before: none</p>", "<p>After: gold</p>"},
+			shouldNotContain: []string{"<esi:assign"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10})
+
+			result, err := processor.Process(tt.html, tt.context)
+			require.NoError(t, err)
+
+			for _, shouldContain := range tt.shouldContain {
+				assert.Contains(t, result, shouldContain)
+			}
+			for _, shouldNotContain := range tt.shouldNotContain {
+				assert.NotContains(t, result, shouldNotContain)
+			}
+		})
+	}
+}
+
+func TestProcessor_DocumentOrderIncludes(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/outer":
+			w.Write([]byte(`<p>Outer</p><esi:include src="/inner" />`))
+		case "/inner":
+			w.Write([]byte(`<p>Inner</p><esi:remove><p>Removed</p></esi:remove>`))
+		case "/fragment":
+			w.Write([]byte(`<p>Fragment</p>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	newProcessor := func() *Processor {
+		return NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 1, BaseURL: server.URL})
+	}
+	context := ProcessContext{BaseURL: server.URL}
+
+	t.Run("unchosen branch is not fetched", func(t *testing.T) {
+		requests.Store(0)
+		result, err := newProcessor().Process(`<html><body><esi:choose><esi:when test="'a' == 'b'"><esi:include src="/fragment" /></esi:when><esi:otherwise><p>Otherwise</p></esi:otherwise></esi:choose></body></html>`, context)
+		require.NoError(t, err)
+
+		assert.Contains(t, result, "<p>Otherwise</p>")
+		assert.NotContains(t, result, "<p>Fragment</p>")
+		assert.Equal(t, int32(0), requests.Load())
+	})
+
+	t.Run("nested includes are processed up to max depth", func(t *testing.T) {
+		result, err := newProcessor().Process(`<html><body><esi:include src="/outer" /><p>After</p></body></html>`, context)
+		require.NoError(t, err)
+
+		assert.Contains(t, result, "<p>Outer</p><p>Inner</p><p>After</p>")
+		assert.NotContains(t, result, "Removed")
+
+		processor := NewProcessor(Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 0, BaseURL: server.URL})
+		result, err = processor.Process(`<html><body><esi:include src="/outer" /></body></html>`, context)
+		require.NoError(t, err)
+
+		assert.Contains(t, result, "<p>Outer</p>")
+		assert.NotContains(t, result, "<p>Inner</p>")
+	})
+
+	t.Run("failed include selects except", func(t *testing.T) {
+		result, err := newProcessor().Process(`<html><body><esi:try><esi:attempt><p>Attempt</p><esi:include src="/missing" /></esi:attempt><esi:except><p>Except</p></esi:except></esi:try></body></html>`, context)
+		require.NoError(t, err)
+
+		assert.Contains(t, result, "<p>Except</p>")
+		assert.NotContains(t, result, "<p>Attempt</p>")
+	})
+
+	t.Run("onerror continue does not fail the attempt", func(t *testing.T) {
+		result, err := newProcessor().Process(`<html><body><esi:try><esi:attempt><p>Attempt</p><esi:include src="/missing" onerror="continue" /></esi:attempt><esi:except><p>Except</p></esi:except></esi:try></body></html>`, context)
+		require.NoError(t, err)
+
+		assert.Contains(t, result, "<p>Attempt</p>")
+		assert.NotContains(t, result, "<p>Except</p>")
+	})
+
+	t.Run("failure handled by a nested try", func(t *testing.T) {
+		result, err := newProcessor().Process(`<html><body><esi:try><esi:attempt><esi:try><esi:attempt><esi:include src="/missing" /></esi:attempt><esi:except><p>Inner except</p></esi:except></esi:try></esi:attempt><esi:except><p>Outer except</p></esi:except></esi:try></body></html>`, context)
+		require.NoError(t, err)
+
+		assert.Contains(t, result, "<p>Inner except</p>")
+		assert.NotContains(t, result, "<p>Outer except</p>")
+	})
+}
@@ -0,0 +1,263 @@
+// Package esigen builds ESI markup. Elements are assembled with small builders, such as
+// Include(src).Timeout(5000).OnError(Continue) or Choose().When(test, body), and
+// rendered with String, which escapes attribute values so that any value, including
+// expressions, URLs with query strings and multi-line headers, reaches the processor
+// unchanged.
+package esigen
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Markup is an ESI element or a fragment of markup
+type Markup interface {
+	String() string
+}
+
+// Raw is markup written as is
+type Raw string
+
+func (r Raw) String() string {
+	return string(r)
+}
+
+// Concat renders markup one after the other
+func Concat(markup ...Markup) Markup {
+	return Raw(render(markup))
+}
+
+// Join renders markup separated by sep
+func Join(markup []Markup, sep string) Markup {
+	parts := make([]string, len(markup))
+	for i, m := range markup {
+		parts[i] = m.String()
+	}
+	return Raw(strings.Join(parts, sep))
+}
+
+// render concatenates the rendered markup
+func render(markup []Markup) string {
+	var b strings.Builder
+	for _, m := range markup {
+		b.WriteString(m.String())
+	}
+	return b.String()
+}
+
+// attributeEscaper escapes values for double-quoted attributes. Newlines are escaped
+// too, so multi-line values such as setheader survive on one line.
+var attributeEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	`"`, "&quot;",
+	"<", "&lt;",
+	"\n", "&#10;",
+)
+
+// EscapeAttribute escapes a value for a double-quoted attribute
+func EscapeAttribute(value string) string {
+	return attributeEscaper.Replace(value)
+}
+
+// Attribute is an element attribute with its unescaped value
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// Attributes are element attributes, rendered in order
+type Attributes []Attribute
+
+// Set sets an attribute, in place when it is already set
+func (a *Attributes) Set(name, value string) {
+	for i := range *a {
+		if (*a)[i].Name == name {
+			(*a)[i].Value = value
+			return
+		}
+	}
+	*a = append(*a, Attribute{Name: name, Value: value})
+}
+
+// Get returns the value of an attribute
+func (a Attributes) Get(name string) (string, bool) {
+	for _, attribute := range a {
+		if attribute.Name == name {
+			return attribute.Value, true
+		}
+	}
+	return "", false
+}
+
+// String renders the attributes, each preceded by a space
+func (a Attributes) String() string {
+	var b strings.Builder
+	for _, attribute := range a {
+		b.WriteString(" " + attribute.Name + `="` + EscapeAttribute(attribute.Value) + `"`)
+	}
+	return b.String()
+}
+
+// empty renders a self-closing ESI element
+func empty(name string, attributes Attributes) string {
+	return "<esi:" + name + attributes.String() + " />"
+}
+
+// container renders an ESI element that always has a closing tag
+func container(name string, body []Markup) string {
+	return "<esi:" + name + ">" + render(body) + "</esi:" + name + ">"
+}
+
+// ErrorMode is the onerror behaviour of an include
+type ErrorMode string
+
+// Continue drops a failed include silently instead of failing the page
+const Continue ErrorMode = "continue"
+
+// IncludeElement is an esi:include
+type IncludeElement struct {
+	attributes Attributes
+}
+
+// Include starts an esi:include of src
+func Include(src string) *IncludeElement {
+	return &IncludeElement{attributes: Attributes{{Name: "src", Value: src}}}
+}
+
+// Alt sets the URL fetched when src fails
+func (i *IncludeElement) Alt(alt string) *IncludeElement {
+	return i.Attr("alt", alt)
+}
+
+// OnError sets the behaviour when the include fails
+func (i *IncludeElement) OnError(mode ErrorMode) *IncludeElement {
+	return i.Attr("onerror", string(mode))
+}
+
+// Timeout sets the fetch timeout in milliseconds
+func (i *IncludeElement) Timeout(ms int) *IncludeElement {
+	return i.Attr("timeout", strconv.Itoa(ms))
+}
+
+// MaxWait sets how long the page waits for the include, in milliseconds. With 0 the
+// include is fired and forgotten.
+func (i *IncludeElement) MaxWait(ms int) *IncludeElement {
+	return i.Attr("maxwait", strconv.Itoa(ms))
+}
+
+// Method sets the request method
+func (i *IncludeElement) Method(method string) *IncludeElement {
+	return i.Attr("method", method)
+}
+
+// Entity sets the request body
+func (i *IncludeElement) Entity(body string) *IncludeElement {
+	return i.Attr("entity", body)
+}
+
+// SetHeader adds a request header. Headers are sent one per line of the setheader
+// attribute.
+func (i *IncludeElement) SetHeader(name, value string) *IncludeElement {
+	header := name + ": " + value
+	if headers, ok := i.attributes.Get("setheader"); ok {
+		header = headers + "\n" + header
+	}
+	return i.Attr("setheader", header)
+}
+
+// Attr sets any other attribute
+func (i *IncludeElement) Attr(name, value string) *IncludeElement {
+	i.attributes.Set(name, value)
+	return i
+}
+
+// Attrs sets attributes, in order
+func (i *IncludeElement) Attrs(attributes Attributes) *IncludeElement {
+	for _, attribute := range attributes {
+		i.attributes.Set(attribute.Name, attribute.Value)
+	}
+	return i
+}
+
+func (i *IncludeElement) String() string {
+	return empty("include", i.attributes)
+}
+
+// ChooseElement is an esi:choose
+type ChooseElement struct {
+	whens     []string
+	otherwise []Markup
+}
+
+// Choose starts an esi:choose
+func Choose() *ChooseElement {
+	return &ChooseElement{}
+}
+
+// When adds an esi:when branch rendering body when test is true
+func (c *ChooseElement) When(test string, body ...Markup) *ChooseElement {
+	c.whens = append(c.whens, `<esi:when test="`+EscapeAttribute(test)+`">`+render(body)+"</esi:when>")
+	return c
+}
+
+// Otherwise sets the esi:otherwise branch
+func (c *ChooseElement) Otherwise(body ...Markup) *ChooseElement {
+	c.otherwise = body
+	return c
+}
+
+func (c *ChooseElement) String() string {
+	body := strings.Join(c.whens, "")
+	if c.otherwise != nil {
+		body += container("otherwise", c.otherwise)
+	}
+	return "<esi:choose>" + body + "</esi:choose>"
+}
+
+// TryElement is an esi:try
+type TryElement struct {
+	attempt []Markup
+	except  []Markup
+}
+
+// Try starts an esi:try attempting body
+func Try(attempt ...Markup) *TryElement {
+	return &TryElement{attempt: attempt}
+}
+
+// Except sets the markup rendered when the attempt fails. Without it the failure
+// renders nothing.
+func (t *TryElement) Except(body ...Markup) *TryElement {
+	t.except = body
+	return t
+}
+
+func (t *TryElement) String() string {
+	return "<esi:try>" + container("attempt", t.attempt) + container("except", t.except) + "</esi:try>"
+}
+
+// Assign returns an esi:assign setting the variable name to the expression value
+func Assign(name, value string) Markup {
+	return Raw(empty("assign", Attributes{{Name: "name", Value: name}, {Name: "value", Value: value}}))
+}
+
+// Function returns an esi:function calling the built-in function name with attributes
+func Function(name string, attributes ...Attribute) Markup {
+	return Raw(empty("function", append(Attributes{{Name: "name", Value: name}}, attributes...)))
+}
+
+// Vars returns an esi:vars substituting variables in body
+func Vars(body ...Markup) Markup {
+	return Raw(container("vars", body))
+}
+
+// Remove returns an esi:remove, whose body is shown only without ESI processing
+func Remove(body ...Markup) Markup {
+	return Raw(container("remove", body))
+}
+
+// Comment returns an HTML comment. Sequences that would end the comment early are
+// broken up.
+func Comment(text string) Markup {
+	return Raw("<!-- " + strings.ReplaceAll(text, "--", "- -") + " -->")
+}
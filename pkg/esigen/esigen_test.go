@@ -0,0 +1,75 @@
+package esigen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInclude(t *testing.T) {
+	assert.Equal(t, `<esi:include src="/a?x=1&amp;y=2" />`, Include("/a?x=1&y=2").String())
+	assert.Equal(t, `<esi:include src="/a" alt="/b" timeout="5000" onerror="continue" />`,
+		Include("/a").Alt("/b").Timeout(5000).OnError(Continue).String())
+
+	// Setting an attribute again replaces it in place
+	assert.Equal(t, `<esi:include src="/a" maxwait="0" onerror="continue" />`,
+		Include("/a").MaxWait(100).OnError(Continue).MaxWait(0).String())
+
+	include := Include("/collect").Method("POST").Entity(`{"id":"<1>"}`).
+		SetHeader("Content-Type", "application/json").SetHeader("X-Id", "1")
+	assert.Equal(t, `<esi:include src="/collect" method="POST" entity="{&quot;id&quot;:&quot;&lt;1>&quot;}"`+
+		` setheader="Content-Type: application/json&#10;X-Id: 1" />`, include.String())
+}
+
+func TestAttributes(t *testing.T) {
+	var attributes Attributes
+	assert.Equal(t, "", attributes.String())
+
+	attributes.Set("method", "POST")
+	attributes.Set("entity", "a=1&b=2")
+	attributes.Set("method", "GET")
+	assert.Equal(t, ` method="GET" entity="a=1&amp;b=2"`, attributes.String())
+
+	value, ok := attributes.Get("entity")
+	assert.True(t, ok)
+	assert.Equal(t, "a=1&b=2", value)
+
+	assert.Equal(t, `<esi:include src="/a" method="GET" entity="a=1&amp;b=2" maxwait="0" />`,
+		Include("/a").Attrs(attributes).MaxWait(0).String())
+}
+
+func TestChoose(t *testing.T) {
+	choose := Choose().
+		When(`$(HTTP_COOKIE{tier})=='gold' && $(GEO_COUNTRY)!="US"`, Raw("<b>gold</b>")).
+		When("$(HTTP_COOKIE{tier})=='silver'", Include("/silver")).
+		Otherwise(Raw("default"))
+	assert.Equal(t, `<esi:choose>`+
+		`<esi:when test="$(HTTP_COOKIE{tier})=='gold' &amp;&amp; $(GEO_COUNTRY)!=&quot;US&quot;"><b>gold</b></esi:when>`+
+		`<esi:when test="$(HTTP_COOKIE{tier})=='silver'"><esi:include src="/silver" /></esi:when>`+
+		`<esi:otherwise>default</esi:otherwise></esi:choose>`, choose.String())
+
+	// An empty branch still closes its element
+	assert.Equal(t, `<esi:choose><esi:when test="1==1"></esi:when></esi:choose>`, Choose().When("1==1").String())
+}
+
+func TestTry(t *testing.T) {
+	assert.Equal(t, `<esi:try><esi:attempt><esi:include src="/a" /></esi:attempt><esi:except></esi:except></esi:try>`,
+		Try(Include("/a")).String())
+	assert.Equal(t, `<esi:try><esi:attempt><esi:include src="/a" /><p>b</p></esi:attempt>`+
+		`<esi:except><!-- a failed --></esi:except></esi:try>`,
+		Try(Include("/a"), Raw("<p>b</p>")).Except(Comment("a failed")).String())
+}
+
+func TestOtherElements(t *testing.T) {
+	assert.Equal(t, `<esi:assign name="seg" value="$(HTTP_COOKIE{seg}) + &quot;-x&quot;" />`,
+		Assign("seg", `$(HTTP_COOKIE{seg}) + "-x"`).String())
+	assert.Equal(t, `<esi:function name="add_header" header="X-Seg" value="$(seg)" />`,
+		Function("add_header", Attribute{Name: "header", Value: "X-Seg"}, Attribute{Name: "value", Value: "$(seg)"}).String())
+	assert.Equal(t, `<esi:vars><i>$(seg)</i></esi:vars>`, Vars(Raw("<i>$(seg)</i>")).String())
+	assert.Equal(t, `<esi:remove><a href="/fallback">x</a></esi:remove>`, Remove(Raw(`<a href="/fallback">x</a>`)).String())
+	assert.Equal(t, `<!-- a - -> b -->`, Comment("a --> b").String())
+
+	markup := []Markup{Include("/a"), Include("/b")}
+	assert.Equal(t, `<esi:include src="/a" /><esi:include src="/b" />`, Concat(markup...).String())
+	assert.Equal(t, "<esi:include src=\"/a\" />\n<esi:include src=\"/b\" />", Join(markup, "\n").String())
+}
@@ -0,0 +1,206 @@
+package esitest
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+// update rewrites golden files with the current output: go test ./... -update
+var update = flag.Bool("update", false, "Rewrite ESI golden files with the current output")
+
+// FragmentServer serves mocked fragments by path for ESI includes
+type FragmentServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	fragments map[string]string
+	requests  []string
+}
+
+// NewFragmentServer starts a fragment server that is closed when the test ends.
+// Paths without a fragment answer 404, so includes fall back to alt and esi:except.
+func NewFragmentServer(t testing.TB, fragments map[string]string) *FragmentServer {
+	t.Helper()
+
+	server := &FragmentServer{fragments: make(map[string]string, len(fragments))}
+	for path, content := range fragments {
+		server.fragments[path] = content
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serve))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// serve answers a fragment request by path and query, then by path alone
+func (s *FragmentServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.requests = append(s.requests, r.URL.RequestURI())
+	content, exists := s.fragments[r.URL.RequestURI()]
+	if !exists {
+		content, exists = s.fragments[r.URL.Path]
+	}
+	s.mutex.Unlock()
+
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(content))
+}
+
+// SetFragment adds or replaces the fragment served at path
+func (s *FragmentServer) SetFragment(path, content string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fragments[path] = content
+}
+
+// Requests returns the paths requested so far, in order
+func (s *FragmentServer) Requests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Options configures Render
+type Options struct {
+	// Mode defaults to akamai
+	Mode string
+	// Fragments are served by path to the template's relative includes
+	Fragments map[string]string
+	// MaxIncludes defaults to 256 and MaxDepth to 5, as the emulator
+	MaxIncludes int
+	MaxDepth    int
+	// Context is the request the template is processed for; its BaseURL is the fragment server
+	Context esi.ProcessContext
+}
+
+// Render processes a template against a fragment server serving options.Fragments
+// and fails the test when processing fails
+func Render(t testing.TB, template string, options Options) string {
+	t.Helper()
+
+	server := NewFragmentServer(t, options.Fragments)
+	config := esi.Config{Mode: options.Mode, MaxIncludes: options.MaxIncludes, MaxDepth: options.MaxDepth, BaseURL: server.URL}
+	if config.Mode == "" {
+		config.Mode = "akamai"
+	}
+	if config.MaxIncludes == 0 {
+		config.MaxIncludes = 256
+	}
+	if config.MaxDepth == 0 {
+		config.MaxDepth = 5
+	}
+
+	context := options.Context
+	context.BaseURL = server.URL
+	if context.Headers == nil {
+		context.Headers = map[string]string{}
+	}
+	if context.Cookies == nil {
+		context.Cookies = map[string]string{}
+	}
+
+	output, err := esi.NewProcessor(config).Process(template, context)
+	if err != nil {
+		t.Fatalf("processing template in %s mode: %v", config.Mode, err)
+	}
+	return output
+}
+
+// ReadTemplate reads a template file, failing the test when it cannot be read
+func ReadTemplate(t testing.TB, path string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading template: %v", err)
+	}
+	return string(content)
+}
+
+// Normalizer rewrites output before it is compared, so insignificant differences do not fail tests
+type Normalizer func(string) string
+
+var (
+	whitespacePattern        = regexp.MustCompile(`\s+`)
+	whitespaceBetweenPattern = regexp.MustCompile(`>\s+<`)
+)
+
+// NormalizeWhitespace collapses whitespace runs to a single space and drops whitespace between tags
+func NormalizeWhitespace(output string) string {
+	output = whitespacePattern.ReplaceAllString(strings.TrimSpace(output), " ")
+	return whitespaceBetweenPattern.ReplaceAllString(output, "><")
+}
+
+// SortAttributes orders the attributes of every tag by name
+func SortAttributes(output string) string {
+	var sorted strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(output))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return sorted.String()
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			sorted.Write(tokenizer.Raw())
+			continue
+		}
+
+		token := tokenizer.Token()
+		sort.SliceStable(token.Attr, func(i, j int) bool { return token.Attr[i].Key < token.Attr[j].Key })
+		sorted.WriteString(token.String())
+	}
+}
+
+// BodyOnly strips the <html><head></head><body> document the processor wraps output in
+func BodyOnly(output string) string {
+	output = strings.TrimPrefix(output, "<html><head></head><body>")
+	return strings.TrimSuffix(output, "</body></html>")
+}
+
+// normalize applies normalizers in order
+func normalize(output string, normalizers []Normalizer) string {
+	for _, normalizer := range normalizers {
+		output = normalizer(output)
+	}
+	return output
+}
+
+// AssertGolden compares output with the golden file at path after applying normalizers
+// to both. With -update, the normalized output is written to the golden file instead.
+func AssertGolden(t testing.TB, path, output string, normalizers ...Normalizer) bool {
+	t.Helper()
+
+	output = normalize(output, normalizers)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("creating golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+		return true
+	}
+
+	golden, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Fatalf("golden file %s does not exist; run go test with -update to create it", path)
+	}
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	return assert.Equal(t, normalize(string(golden), normalizers), output, "output differs from %s; run go test with -update to accept it", path)
+}
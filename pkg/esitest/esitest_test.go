@@ -0,0 +1,37 @@
+package esitest
+
+import (
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderGolden(t *testing.T) {
+	output := Render(t, ReadTemplate(t, "testdata/home.html"), Options{
+		Fragments: map[string]string{
+			"/nav":             `<nav><a class="home" href="/">Home</a></nav>`,
+			"/offers/fallback": `<p class="fallback" id="offers">No offers</p>`,
+		},
+		Context: esi.ProcessContext{Cookies: map[string]string{"member": "gold"}},
+	})
+
+	AssertGolden(t, "testdata/home.golden", output, BodyOnly, NormalizeWhitespace, SortAttributes)
+}
+
+func TestFragmentServer(t *testing.T) {
+	server := NewFragmentServer(t, map[string]string{"/a": "A"})
+	server.SetFragment("/b?x=1", "B")
+
+	for _, include := range []struct{ path, expected string }{{"/a?x=2", "A"}, {"/b?x=1", "B"}} {
+		output := Render(t, `<esi:include src="`+server.URL+include.path+`"></esi:include>`, Options{})
+		assert.Equal(t, "<html><head></head><body>"+include.expected+"</body></html>", output)
+	}
+	assert.Equal(t, []string{"/a?x=2", "/b?x=1"}, server.Requests())
+}
+
+func TestNormalizers(t *testing.T) {
+	assert.Equal(t, "<p>a b</p><br/>", NormalizeWhitespace("\n <p>a \n b</p>\n  <br/> "))
+	assert.Equal(t, `<a class="x" href="/">x</a><br a="1" b="2"/>`, SortAttributes(`<a href="/" class="x">x</a><br b="2" a="1"/>`))
+	assert.Equal(t, "<p>x</p>", BodyOnly("<html><head></head><body><p>x</p></body></html>"))
+}
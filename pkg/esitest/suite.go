@@ -0,0 +1,398 @@
+package esitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+	"github.com/edge-computing/emulator-suite/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultOrigin is the mock origin requests are sent to, so templates' relative includes
+// reach it
+const DefaultOrigin = "default"
+
+// Suite is an end-to-end example suite for integrated mode, read from a YAML file: a
+// property, mock origins serving fragments, and requests with the response expected
+// for each. Paths are relative to the suite file.
+type Suite struct {
+	// Property is the property file: XML, or JSON holding {"rules": [...]} as the
+	// /property-manager/process endpoint takes them
+	Property string `yaml:"property"`
+	// ESI configures the processor; mode defaults to akamai
+	ESI SuiteESI `yaml:"esi"`
+	// Origins are mock origins by name, each serving routes by path. Templates and
+	// fragments reach an origin other than DefaultOrigin through {{origin.name}}.
+	Origins  map[string]map[string]SuiteRoute `yaml:"origins"`
+	Requests []SuiteRequest                   `yaml:"requests"`
+
+	dir string
+}
+
+// SuiteESI configures the ESI processor of a suite
+type SuiteESI struct {
+	Mode        string `yaml:"mode"`
+	MaxIncludes int    `yaml:"maxIncludes"`
+	MaxDepth    int    `yaml:"maxDepth"`
+	Cache       bool   `yaml:"cache"`
+}
+
+// SuiteRoute is a response of a mock origin
+type SuiteRoute struct {
+	Status  int               `yaml:"status"` // 200 by default
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	File    string            `yaml:"file"` // Body read from a file instead
+}
+
+// SuiteRequest is a request of a suite and the response expected for it
+type SuiteRequest struct {
+	Name    string            `yaml:"name"`
+	Method  string            `yaml:"method"` // GET by default
+	Path    string            `yaml:"path"`
+	Host    string            `yaml:"host"` // The default origin by default
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	// Template is the page the origin answers with, sent with OriginHeaders
+	Template      string            `yaml:"template"`
+	OriginHeaders map[string]string `yaml:"originHeaders"`
+	Expect        SuiteExpectation  `yaml:"expect"`
+}
+
+// SuiteExpectation is the response a request must get. Unset fields are not checked.
+type SuiteExpectation struct {
+	Status int `yaml:"status"`
+	// ESI is whether ESI processed the page
+	ESI *bool `yaml:"esi"`
+	// Headers must have these values; an empty value means the header must be absent
+	Headers     map[string]string `yaml:"headers"`
+	Contains    []string          `yaml:"contains"`
+	NotContains []string          `yaml:"notContains"`
+}
+
+// SuiteResponse is the response the integrated workflow gives a suite request, as the
+// /integrated/process endpoint writes it
+type SuiteResponse struct {
+	Status     int
+	Headers    http.Header
+	Body       string
+	ESIEnabled bool
+}
+
+// LoadSuite reads a suite file
+func LoadSuite(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading suite: %w", err)
+	}
+	var suite Suite
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("parsing suite %s: %w", path, err)
+	}
+	if suite.Property == "" {
+		return nil, fmt.Errorf("suite %s: no property", path)
+	}
+	for i, request := range suite.Requests {
+		if request.Path == "" {
+			return nil, fmt.Errorf("suite %s: request %d (%s) has no path", path, i+1, request.Name)
+		}
+	}
+	suite.dir = filepath.Dir(path)
+	return &suite, nil
+}
+
+// RunSuite loads the suite at path and runs each request as a subtest through the
+// integrated workflow of the emulator: Property Manager, ESI and response behaviors.
+// It is meant to validate a property locally:
+//
+//	func TestProperty(t *testing.T) { esitest.RunSuite(t, "testdata/suite.yaml") }
+func RunSuite(t *testing.T, path string) {
+	t.Helper()
+
+	suite, err := LoadSuite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins := suite.startOrigins(t)
+	pm, err := suite.propertyManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor := suite.processor()
+
+	for _, request := range suite.Requests {
+		request := request
+		name := request.Name
+		if name == "" {
+			name = request.Path
+		}
+		t.Run(name, func(t *testing.T) {
+			response, err := suite.run(pm, processor, origins, request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Expect.check(t, response)
+		})
+	}
+}
+
+// startOrigins starts the mock origins, closed when the test ends, and returns their URLs
+func (s *Suite) startOrigins(t *testing.T) map[string]string {
+	origins := make(map[string]string, len(s.Origins))
+	for name := range s.Origins {
+		origins[name] = ""
+	}
+	if _, ok := origins[DefaultOrigin]; !ok {
+		origins[DefaultOrigin] = ""
+	}
+
+	// Servers start before serving so routes can reference each other's URLs
+	servers := make(map[string]*httptest.Server, len(origins))
+	for name := range origins {
+		servers[name] = httptest.NewUnstartedServer(nil)
+		origins[name] = "http://" + servers[name].Listener.Addr().String()
+	}
+	for name, server := range servers {
+		routes, err := s.loadRoutes(s.Origins[name], origins)
+		if err != nil {
+			t.Fatalf("origin %s: %v", name, err)
+		}
+		server.Config.Handler = routes
+		server.Start()
+		t.Cleanup(server.Close)
+	}
+	return origins
+}
+
+// loadRoutes reads the bodies of an origin's routes
+func (s *Suite) loadRoutes(routes map[string]SuiteRoute, origins map[string]string) (http.Handler, error) {
+	loaded := make(map[string]SuiteRoute, len(routes))
+	for path, route := range routes {
+		if route.File != "" {
+			body, err := s.readFile(route.File)
+			if err != nil {
+				return nil, err
+			}
+			route.Body = body
+		}
+		route.Body = expandOrigins(route.Body, origins)
+		loaded[path] = route
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := loaded[r.URL.RequestURI()]
+		if !ok {
+			route, ok = loaded[r.URL.Path]
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		for name, value := range route.Headers {
+			w.Header().Set(name, value)
+		}
+		if route.Status != 0 {
+			w.WriteHeader(route.Status)
+		}
+		w.Write([]byte(route.Body))
+	}), nil
+}
+
+// propertyManager loads the suite's property
+func (s *Suite) propertyManager() (*propertymanager.PropertyManager, error) {
+	pm := propertymanager.NewPropertyManager(false)
+	path := filepath.Join(s.dir, s.Property)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading property: %w", err)
+		}
+		var property struct {
+			Rules []propertymanager.Rule `json:"rules"`
+		}
+		if err := json.Unmarshal(data, &property); err != nil {
+			return nil, fmt.Errorf("parsing property %s: %w", path, err)
+		}
+		pm.UseProperty(&propertymanager.Property{Name: filepath.Base(path), Rules: propertymanager.Rules{Rule: property.Rules}})
+		return pm, nil
+	}
+	if err := pm.LoadPropertyFile(path); err != nil {
+		return nil, err
+	}
+	return pm, nil
+}
+
+// processor creates the suite's ESI processor
+func (s *Suite) processor() *esi.Processor {
+	config := esi.Config{Mode: s.ESI.Mode, MaxIncludes: s.ESI.MaxIncludes, MaxDepth: s.ESI.MaxDepth}
+	if config.Mode == "" {
+		config.Mode = "akamai"
+	}
+	if config.MaxIncludes == 0 {
+		config.MaxIncludes = 256
+	}
+	if config.MaxDepth == 0 {
+		config.MaxDepth = 5
+	}
+	config.Cache = esi.CacheConfig{Enabled: s.ESI.Cache, TTL: 300}
+	return esi.NewProcessor(config)
+}
+
+// run sends a request through the integrated workflow
+func (s *Suite) run(pm *propertymanager.PropertyManager, processor *esi.Processor, origins map[string]string, request SuiteRequest) (*SuiteResponse, error) {
+	html := ""
+	if request.Template != "" {
+		template, err := s.readFile(request.Template)
+		if err != nil {
+			return nil, err
+		}
+		html = expandOrigins(template, origins)
+	}
+
+	method := request.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, request.Path, strings.NewReader(request.Body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Host = request.Host
+	if req.Host == "" {
+		defaultOrigin, _ := url.Parse(origins[DefaultOrigin])
+		req.Host = defaultOrigin.Host
+	}
+	for name, value := range request.Headers {
+		req.Header.Set(name, value)
+	}
+	origin := make(http.Header)
+	for name, value := range request.OriginHeaders {
+		origin.Set(name, value)
+	}
+
+	result, err := server.ProcessIntegrated(pm, processor, req, origin, html)
+	if err != nil {
+		return nil, err
+	}
+	if result.ESIError != nil {
+		return nil, fmt.Errorf("ESI processing failed: %w", result.ESIError)
+	}
+	return integratedResponse(result), nil
+}
+
+// integratedResponse lays out an integrated result the way /integrated/process answers
+// denied, redirected and constructed requests; other pages answer 200 with the
+// response phase headers
+func integratedResponse(result *server.IntegratedResult) *SuiteResponse {
+	pmResult := result.PropertyManagerResult
+	response := &SuiteResponse{Status: http.StatusOK, Headers: make(http.Header), ESIEnabled: result.ESIEnabled}
+	setHeaders := func(headers map[string]string) {
+		for name, value := range headers {
+			response.Headers.Set(name, value)
+		}
+	}
+	setDownstreamCache := func() {
+		if pmResult.DownstreamCache == nil {
+			return
+		}
+		for _, name := range pmResult.DownstreamCache.RemovedHeaders {
+			response.Headers.Del(name)
+		}
+		setHeaders(pmResult.DownstreamCache.Headers)
+	}
+	setCookies := func(result *propertymanager.RuleResult) {
+		for _, setCookie := range result.SetCookieHeaders() {
+			response.Headers.Add("Set-Cookie", setCookie)
+		}
+	}
+
+	switch {
+	case pmResult.Denied:
+		response.Status = http.StatusForbidden
+		response.Headers.Set("Cache-Control", "no-store")
+		response.Body = pmResult.DenyReason
+	case pmResult.RedirectLocation != "":
+		response.Status = pmResult.RedirectStatus
+		if response.Status < 300 || response.Status > 399 {
+			response.Status = http.StatusFound
+		}
+		setHeaders(pmResult.ModifiedHeaders)
+		response.Headers.Del("Status")
+		response.Headers.Set("Location", pmResult.RedirectLocation)
+		setDownstreamCache()
+		setCookies(pmResult)
+		response.Body = pmResult.ResponseContent
+	case pmResult.ConstructedResponse != nil:
+		response.Status = pmResult.ConstructedResponse.Status
+		setHeaders(pmResult.ModifiedHeaders)
+		setHeaders(pmResult.ConstructedResponse.Headers)
+		setDownstreamCache()
+		setCookies(pmResult)
+		response.Body = pmResult.ConstructedResponse.Body
+	default:
+		setHeaders(result.ResponseResult.ModifiedHeaders)
+		for _, name := range result.ResponseResult.RemovedHeaders {
+			if _, set := result.ResponseResult.ModifiedHeaders[name]; !set {
+				response.Headers.Del(name)
+			}
+		}
+		setCookies(result.ResponseResult)
+		response.Body = result.ProcessedHTML
+	}
+	return response
+}
+
+// check asserts the response matches the expectation
+func (e SuiteExpectation) check(t *testing.T, response *SuiteResponse) {
+	t.Helper()
+
+	if e.Status != 0 {
+		assert.Equal(t, e.Status, response.Status, "status")
+	}
+	if e.ESI != nil {
+		assert.Equal(t, *e.ESI, response.ESIEnabled, "ESI processing")
+	}
+	names := make([]string, 0, len(e.Headers))
+	for name := range e.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		assert.Equal(t, e.Headers[name], response.Headers.Get(name), "header %s", name)
+	}
+	for _, snippet := range e.Contains {
+		assert.Contains(t, response.Body, snippet)
+	}
+	for _, snippet := range e.NotContains {
+		assert.NotContains(t, response.Body, snippet)
+	}
+}
+
+// readFile reads a file relative to the suite
+func (s *Suite) readFile(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", name, err)
+	}
+	return string(data), nil
+}
+
+// expandOrigins replaces {{origin.name}} with the URL of the mock origin name
+func expandOrigins(content string, origins map[string]string) string {
+	for name, origin := range origins {
+		content = strings.ReplaceAll(content, "{{origin."+name+"}}", origin)
+	}
+	return content
+}
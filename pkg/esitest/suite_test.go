@@ -0,0 +1,52 @@
+package esitest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSuite(t *testing.T) {
+	RunSuite(t, "testdata/suite/suite.yaml")
+}
+
+func TestRunSuite_JSONProperty(t *testing.T) {
+	dir := t.TempDir()
+	writeSuiteFile(t, dir, "property.json", `{"rules": [{"Name": "default", "Behaviors": [{"Name": "esi"}]}]}`)
+	writeSuiteFile(t, dir, "page.html", `<esi:include src="/fragment"/>`)
+	writeSuiteFile(t, dir, "suite.yaml", `
+property: property.json
+origins:
+  default:
+    /fragment:
+      body: <p>from json</p>
+requests:
+  - path: /
+    template: page.html
+    expect:
+      esi: true
+      contains: [<p>from json</p>]
+`)
+	RunSuite(t, filepath.Join(dir, "suite.yaml"))
+}
+
+func TestLoadSuite_Errors(t *testing.T) {
+	dir := t.TempDir()
+	writeSuiteFile(t, dir, "no-property.yaml", "requests: []\n")
+	writeSuiteFile(t, dir, "no-path.yaml", "property: p.xml\nrequests:\n  - name: home\n")
+
+	_, err := LoadSuite(filepath.Join(dir, "no-property.yaml"))
+	assert.ErrorContains(t, err, "no property")
+	_, err = LoadSuite(filepath.Join(dir, "no-path.yaml"))
+	assert.ErrorContains(t, err, "request 1 (home) has no path")
+	_, err = LoadSuite(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func writeSuiteFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+}
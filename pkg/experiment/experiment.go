@@ -0,0 +1,191 @@
+// Package experiment assigns requests to the variants of A/B experiments, the way edge
+// experimentation does: a request's sticky key, such as a user ID cookie, is hashed with
+// the experiment's name into a bucket, so the same user always sees the same variant
+// while the variants share traffic by weight.
+package experiment
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Sticky key sources
+const (
+	SourceCookie = "cookie" // cookie:<name>
+	SourceHeader = "header" // header:<name>
+	SourceQuery  = "query"  // query:<name>
+	SourceIP     = "ip"     // The client IP
+)
+
+// Experiment splits traffic between variants
+type Experiment struct {
+	Name     string    `json:"name"`
+	Variants []Variant `json:"variants"`
+	// StickyKey is the request attribute identifying a user: cookie:<name>, header:<name>,
+	// query:<name> or ip. Requests without it get the first variant, the control.
+	StickyKey string `json:"stickyKey"`
+}
+
+// Variant is an arm of an experiment, receiving Weight parts of the traffic
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// Request holds the request attributes sticky keys are read from
+type Request struct {
+	Cookies  map[string]string
+	Headers  map[string]string // Header names in any case
+	Query    string            // Raw query string
+	ClientIP string
+}
+
+// Validate checks that experiments have unique names, valid sticky keys and named
+// variants with non-negative weights, some of them positive
+func Validate(experiments []Experiment) error {
+	names := make(map[string]bool, len(experiments))
+	for i, experiment := range experiments {
+		if experiment.Name == "" {
+			return fmt.Errorf("experiment %d: name is required", i+1)
+		}
+		if names[experiment.Name] {
+			return fmt.Errorf("experiment %s: defined more than once", experiment.Name)
+		}
+		names[experiment.Name] = true
+		if err := experiment.validate(); err != nil {
+			return fmt.Errorf("experiment %s: %w", experiment.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the sticky key and variants of the experiment
+func (e Experiment) validate() error {
+	if _, _, err := parseStickyKey(e.StickyKey); err != nil {
+		return err
+	}
+	if len(e.Variants) == 0 {
+		return fmt.Errorf("at least one variant is required")
+	}
+
+	total := 0
+	variants := make(map[string]bool, len(e.Variants))
+	for _, variant := range e.Variants {
+		switch {
+		case variant.Name == "":
+			return fmt.Errorf("variant name is required")
+		case variants[variant.Name]:
+			return fmt.Errorf("variant %s defined more than once", variant.Name)
+		case variant.Weight < 0:
+			return fmt.Errorf("variant %s: weight must not be negative", variant.Name)
+		}
+		variants[variant.Name] = true
+		total += variant.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("variant weights must not all be zero")
+	}
+	return nil
+}
+
+// parseStickyKey returns the source and name of a sticky key
+func parseStickyKey(key string) (string, string, error) {
+	source, name, _ := strings.Cut(key, ":")
+	source = strings.ToLower(strings.TrimSpace(source))
+	name = strings.TrimSpace(name)
+
+	switch source {
+	case SourceCookie, SourceHeader, SourceQuery:
+		if name == "" {
+			return "", "", fmt.Errorf("sticky key %q: %s name is required", key, source)
+		}
+		return source, name, nil
+	case SourceIP:
+		return source, "", nil
+	}
+	return "", "", fmt.Errorf("invalid sticky key %q: want cookie:<name>, header:<name>, query:<name> or ip", key)
+}
+
+// Find returns the experiment named name
+func Find(experiments []Experiment, name string) (Experiment, bool) {
+	for _, experiment := range experiments {
+		if experiment.Name == name {
+			return experiment, true
+		}
+	}
+	return Experiment{}, false
+}
+
+// Key returns the sticky key of the request, or "" when the request lacks it
+func (e Experiment) Key(req Request) string {
+	source, name, err := parseStickyKey(e.StickyKey)
+	if err != nil {
+		return ""
+	}
+
+	switch source {
+	case SourceCookie:
+		return req.Cookies[name]
+	case SourceHeader:
+		for header, value := range req.Headers {
+			if strings.EqualFold(header, name) {
+				return value
+			}
+		}
+		return ""
+	case SourceQuery:
+		values, err := url.ParseQuery(req.Query)
+		if err != nil {
+			return ""
+		}
+		return values.Get(name)
+	default:
+		return req.ClientIP
+	}
+}
+
+// Assign returns the variant of the request: the bucket of its sticky key, or the first
+// variant when it has none
+func (e Experiment) Assign(req Request) string {
+	if len(e.Variants) == 0 {
+		return ""
+	}
+	key := e.Key(req)
+	if key == "" {
+		return e.Variants[0].Name
+	}
+	return e.Bucket(key)
+}
+
+// Bucket returns the variant of a sticky key. Keys are hashed with the experiment's
+// name, so a user's variants in different experiments are independent.
+func (e Experiment) Bucket(key string) string {
+	total := 0
+	for _, variant := range e.Variants {
+		if variant.Weight > 0 {
+			total += variant.Weight
+		}
+	}
+	if total == 0 {
+		if len(e.Variants) == 0 {
+			return ""
+		}
+		return e.Variants[0].Name
+	}
+
+	sum := sha256.Sum256([]byte(e.Name + "\x00" + key))
+	point := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for _, variant := range e.Variants {
+		if variant.Weight <= 0 {
+			continue
+		}
+		if point < variant.Weight {
+			return variant.Name
+		}
+		point -= variant.Weight
+	}
+	return e.Variants[len(e.Variants)-1].Name
+}
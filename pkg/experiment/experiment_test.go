@@ -0,0 +1,116 @@
+package experiment
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkout() Experiment {
+	return Experiment{
+		Name:      "checkout",
+		StickyKey: "cookie:uid",
+		Variants:  []Variant{{Name: "control", Weight: 50}, {Name: "one-page", Weight: 50}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate([]Experiment{checkout(), {Name: "banner", StickyKey: "ip", Variants: []Variant{{Name: "a", Weight: 1}}}}))
+
+	tests := []struct {
+		name        string
+		experiment  Experiment
+		expectedErr string
+	}{
+		{"missing name", Experiment{StickyKey: "ip", Variants: []Variant{{Name: "a", Weight: 1}}}, "experiment 1: name is required"},
+		{"invalid sticky key", Experiment{Name: "e", StickyKey: "session", Variants: []Variant{{Name: "a", Weight: 1}}}, `invalid sticky key "session"`},
+		{"sticky key without name", Experiment{Name: "e", StickyKey: "cookie:", Variants: []Variant{{Name: "a", Weight: 1}}}, "cookie name is required"},
+		{"no variants", Experiment{Name: "e", StickyKey: "ip"}, "at least one variant is required"},
+		{"unnamed variant", Experiment{Name: "e", StickyKey: "ip", Variants: []Variant{{Weight: 1}}}, "variant name is required"},
+		{"duplicate variant", Experiment{Name: "e", StickyKey: "ip", Variants: []Variant{{Name: "a", Weight: 1}, {Name: "a", Weight: 1}}}, "variant a defined more than once"},
+		{"negative weight", Experiment{Name: "e", StickyKey: "ip", Variants: []Variant{{Name: "a", Weight: -1}}}, "weight must not be negative"},
+		{"zero weights", Experiment{Name: "e", StickyKey: "ip", Variants: []Variant{{Name: "a"}}}, "must not all be zero"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, Validate([]Experiment{tt.experiment}), tt.expectedErr)
+		})
+	}
+
+	assert.ErrorContains(t, Validate([]Experiment{checkout(), checkout()}), "experiment checkout: defined more than once")
+}
+
+func TestExperiment_Key(t *testing.T) {
+	req := Request{
+		Cookies:  map[string]string{"uid": "u-1"},
+		Headers:  map[string]string{"X-User-Id": "u-2"},
+		Query:    "uid=u-3&page=2",
+		ClientIP: "203.0.113.7",
+	}
+
+	assert.Equal(t, "u-1", Experiment{StickyKey: "cookie:uid"}.Key(req))
+	assert.Equal(t, "u-2", Experiment{StickyKey: "header:x-user-id"}.Key(req))
+	assert.Equal(t, "u-3", Experiment{StickyKey: "query:uid"}.Key(req))
+	assert.Equal(t, "203.0.113.7", Experiment{StickyKey: "ip"}.Key(req))
+	assert.Empty(t, Experiment{StickyKey: "cookie:session"}.Key(req))
+	assert.Empty(t, Experiment{StickyKey: "bogus"}.Key(req))
+}
+
+func TestExperiment_Assign(t *testing.T) {
+	experiment := checkout()
+
+	// Requests without the sticky key get the control
+	assert.Equal(t, "control", experiment.Assign(Request{}))
+
+	// Assignments are deterministic
+	req := Request{Cookies: map[string]string{"uid": "user-42"}}
+	variant := experiment.Assign(req)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, variant, experiment.Assign(req))
+	}
+
+	// Traffic is split by weight
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[experiment.Bucket("user-"+strconv.Itoa(i))]++
+	}
+	assert.InDelta(t, 5000, counts["control"], 300)
+	assert.InDelta(t, 5000, counts["one-page"], 300)
+
+	experiment.Variants = []Variant{{Name: "control", Weight: 9}, {Name: "off", Weight: 0}, {Name: "treatment", Weight: 1}}
+	counts = map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[experiment.Bucket("user-"+strconv.Itoa(i))]++
+	}
+	assert.InDelta(t, 9000, counts["control"], 300)
+	assert.Zero(t, counts["off"])
+	assert.InDelta(t, 1000, counts["treatment"], 300)
+}
+
+func TestExperiment_BucketIndependentAcrossExperiments(t *testing.T) {
+	first := checkout()
+	second := checkout()
+	second.Name = "banner"
+
+	differ := 0
+	for i := 0; i < 1000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		if first.Bucket(key) != second.Bucket(key) {
+			differ++
+		}
+	}
+	assert.InDelta(t, 500, differ, 100)
+}
+
+func TestFind(t *testing.T) {
+	experiments := []Experiment{checkout()}
+
+	found, ok := Find(experiments, "checkout")
+	require.True(t, ok)
+	assert.Equal(t, "cookie:uid", found.StickyKey)
+
+	_, ok = Find(experiments, "missing")
+	assert.False(t, ok)
+}
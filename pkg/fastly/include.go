@@ -0,0 +1,55 @@
+package fastly
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+)
+
+// BeforeInclude runs vcl_recv on an ESI include request, as Fastly does for every
+// include, for use as an esi.BeforeIncludeHook. The include is sent to the selected
+// backend with the URL and headers vcl_recv set, and an error statement vetoes it.
+// Headers vcl_recv unsets are still sent when the page request carried them.
+func (v *VCL) BeforeInclude(include *esi.IncludeRequest, context esi.ProcessContext) error {
+	target, err := url.Parse(include.URL)
+	if err != nil {
+		return err
+	}
+	if base, err := url.Parse(context.BaseURL); err == nil {
+		target = base.ResolveReference(target)
+	}
+
+	req, err := http.NewRequest(include.Method, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range context.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, value := range include.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Del("Host")
+
+	result := v.Recv(req)
+	if result.ErrorStatus != 0 {
+		return fmt.Errorf("%w: vcl_recv answered %d %s", esi.ErrIncludeVetoed, result.ErrorStatus, result.ErrorMessage)
+	}
+
+	origin := target.Scheme + "://" + target.Host
+	if result.Backend != nil {
+		origin = result.Backend.URL()
+	}
+	include.URL = origin + result.URL
+	if include.Headers == nil {
+		include.Headers = make(map[string]string)
+	}
+	for name, value := range result.RequestHeaders {
+		if name != "Host" && req.Header.Get(name) != value {
+			include.Headers[name] = value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package fastly
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeforeInclude(t *testing.T) {
+	vcl, err := Parse(`
+backend F_origin { .host = "origin.internal"; .port = "8080"; }
+backend F_fragments { .host = "fragments.internal"; .ssl = true; }
+sub vcl_recv {
+    if (req.url ~ "^/private/") {
+        error 403;
+    }
+    if (req.url ~ "^/fragments/") {
+        set req.backend = F_fragments;
+        set req.url = "/v2" + req.url;
+    }
+    set req.http.X-Include = "1";
+    unset req.http.Cookie;
+}`)
+	require.NoError(t, err)
+	context := esi.ProcessContext{BaseURL: "http://www.example.com", Headers: map[string]string{"Cookie": "a=1", "Accept": "text/html"}}
+
+	include := &esi.IncludeRequest{Method: "GET", URL: "/fragments/header?v=1", Headers: map[string]string{"X-Custom": "x"}}
+	require.NoError(t, vcl.BeforeInclude(include, context))
+	assert.Equal(t, "https://fragments.internal/v2/fragments/header?v=1", include.URL)
+	assert.Equal(t, map[string]string{"X-Custom": "x", "X-Include": "1"}, include.Headers)
+
+	include = &esi.IncludeRequest{Method: "GET", URL: "http://cdn.example.com/footer"}
+	require.NoError(t, vcl.BeforeInclude(include, context))
+	assert.Equal(t, "http://origin.internal:8080/footer", include.URL)
+
+	err = vcl.BeforeInclude(&esi.IncludeRequest{Method: "GET", URL: "/private/account"}, context)
+	assert.True(t, errors.Is(err, esi.ErrIncludeVetoed))
+	assert.Contains(t, err.Error(), "vcl_recv answered 403 Forbidden")
+}
+
+func TestBeforeInclude_NoBackends(t *testing.T) {
+	vcl, err := Parse(`sub vcl_recv { set req.url = req.url.path; }`)
+	require.NoError(t, err)
+
+	include := &esi.IncludeRequest{Method: "GET", URL: "/header?cache=bust"}
+	require.NoError(t, vcl.BeforeInclude(include, esi.ProcessContext{BaseURL: "http://www.example.com"}))
+	assert.Equal(t, "http://www.example.com/header", include.URL)
+}
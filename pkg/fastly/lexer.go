@@ -0,0 +1,109 @@
+package fastly
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tokenKind is the kind of a VCL token
+type tokenKind int
+
+const (
+	tokenEOF    tokenKind = iota
+	tokenIdent            // Names, variables such as req.http.X-Forwarded-For and backend properties such as .host
+	tokenString           // "quoted" or {"long"} strings, without their delimiters
+	tokenNumber           // Integers, optionally with a duration unit such as 60s
+	tokenSymbol           // Punctuation and operators
+)
+
+// token is a lexed VCL token and the line it starts on
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// symbols are the VCL operators and punctuation, two-character ones first
+var symbols = []string{"==", "!=", "!~", "&&", "||", "{", "}", "(", ")", ";", "=", "~", "!", "+", ","}
+
+// lex splits VCL source into tokens, dropping #, // and /* */ comments
+func lex(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			end := strings.IndexAny(src[i+1:], "\"\n")
+			if end < 0 || src[i+1+end] != '"' {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			tokens = append(tokens, token{tokenString, src[i+1 : i+1+end], line})
+			i += end + 2
+		case strings.HasPrefix(src[i:], `{"`):
+			end := strings.Index(src[i+2:], `"}`)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated long string", line)
+			}
+			text := src[i+2 : i+2+end]
+			tokens = append(tokens, token{tokenString, text, line})
+			line += strings.Count(text, "\n")
+			i += end + 4
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && isIdentByte(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, src[start:i], line})
+		case isIdentByte(c) || c == '.':
+			start := i
+			for i < len(src) && (isIdentByte(src[i]) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, src[start:i], line})
+		default:
+			matched := false
+			for _, symbol := range symbols {
+				if strings.HasPrefix(src[i:], symbol) {
+					tokens = append(tokens, token{tokenSymbol, symbol, line})
+					i += len(symbol)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", line}), nil
+}
+
+// isIdentByte reports whether c can be part of a name; - is allowed for header names
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '-' || c < unicode.MaxASCII && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)))
+}
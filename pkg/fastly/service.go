@@ -0,0 +1,348 @@
+package fastly
+
+import (
+	"net"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxCallDepth bounds call statements, so subroutines calling each other cannot loop
+const maxCallDepth = 16
+
+// Result is what the VCL decided for a request
+type Result struct {
+	// Backend is the backend the request is sent to: the one vcl_recv set, or the first
+	// declared; nil when the VCL declares none
+	Backend *Backend `json:"backend,omitempty"`
+	// URL is the request URL, path and query string, after vcl_recv
+	URL string `json:"url"`
+	// RequestHeaders are the headers of the request after vcl_recv, as sent to the backend
+	RequestHeaders map[string]string `json:"requestHeaders"`
+	// Return is the action of the return statement that ended vcl_recv, such as pass
+	Return string `json:"return,omitempty"`
+
+	// ErrorStatus and ErrorMessage are set when an error statement answered the request
+	// itself; the backend is not fetched and ESI does not run
+	ErrorStatus  int    `json:"errorStatus,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+
+	// ESI is set when vcl_fetch enabled ESI processing of the backend response, with
+	// esi or beresp.do_esi
+	ESI bool `json:"esi"`
+	// Status and ResponseHeaders are the response to the client after vcl_fetch and
+	// vcl_deliver
+	Status          int               `json:"status,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+}
+
+// Recv runs vcl_recv for a client request
+func (v *VCL) Recv(req *http.Request) *Result {
+	result := &Result{
+		URL:            req.URL.RequestURI(),
+		RequestHeaders: make(map[string]string),
+	}
+	if len(v.Backends) > 0 {
+		result.Backend = &v.Backends[0]
+	}
+	for name, values := range req.Header {
+		if len(values) > 0 {
+			result.RequestHeaders[name] = values[0]
+		}
+	}
+	if req.Host != "" {
+		result.RequestHeaders["Host"] = req.Host
+	}
+
+	run := &execution{vcl: v, result: result, method: req.Method, clientIP: clientIP(req)}
+	result.Return = run.sub(SubRecv, 0)
+	return result
+}
+
+// Fetch runs vcl_fetch on the backend response, with its status and headers, then
+// vcl_deliver on the response to the client, unless vcl_recv answered the request
+// with an error
+func (v *VCL) Fetch(result *Result, req *http.Request, status int, headers http.Header) {
+	if result.ErrorStatus != 0 {
+		return
+	}
+	result.Status = status
+	result.ResponseHeaders = make(map[string]string)
+	for name, values := range headers {
+		if len(values) > 0 {
+			result.ResponseHeaders[textproto.CanonicalMIMEHeaderKey(name)] = values[0]
+		}
+	}
+
+	run := &execution{vcl: v, result: result, method: req.Method, clientIP: clientIP(req)}
+	if run.sub(SubFetch, 0); result.ErrorStatus == 0 {
+		run.sub(SubDeliver, 0)
+	}
+}
+
+// Process runs a request through vcl_recv, then the backend response through vcl_fetch
+// and vcl_deliver
+func (v *VCL) Process(req *http.Request, status int, headers http.Header) *Result {
+	result := v.Recv(req)
+	v.Fetch(result, req, status, headers)
+	return result
+}
+
+// clientIP returns the address of the client of req
+func clientIP(req *http.Request) string {
+	if ip := req.Header.Get("Fastly-Client-IP"); ip != "" {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// execution is the state of a request running through the VCL
+type execution struct {
+	vcl      *VCL
+	result   *Result
+	method   string
+	clientIP string
+}
+
+// exit ends a subroutine with the action of the return or error statement that ended it
+type exit struct{ action string }
+
+// sub runs a subroutine and returns the action of the return statement that ended it
+func (e *execution) sub(name string, depth int) string {
+	if stop := e.run(e.vcl.subroutines[name], depth); stop != nil {
+		return stop.action
+	}
+	return ""
+}
+
+// run executes statements until one returns
+func (e *execution) run(statements []statement, depth int) *exit {
+	for _, s := range statements {
+		if stop := s.exec(e, depth); stop != nil {
+			return stop
+		}
+	}
+	return nil
+}
+
+// get returns the value of a variable; unset headers are empty
+func (e *execution) get(variable string) string {
+	if scope, header, ok := headerVariable(variable); ok {
+		return e.headers(scope)[header]
+	}
+
+	path, query, _ := strings.Cut(e.result.URL, "?")
+	switch variable {
+	case "req.url":
+		return e.result.URL
+	case "req.url.path":
+		return path
+	case "req.url.qs":
+		return query
+	case "req.method", "req.request":
+		return e.method
+	case "req.backend":
+		if e.result.Backend != nil {
+			return e.result.Backend.Name
+		}
+	case "client.ip":
+		return e.clientIP
+	case "beresp.status", "resp.status":
+		return strconv.Itoa(e.result.Status)
+	case "beresp.do_esi":
+		return strconv.FormatBool(e.result.ESI)
+	}
+	return ""
+}
+
+// set assigns a variable
+func (e *execution) set(variable, value string) {
+	if scope, header, ok := headerVariable(variable); ok {
+		e.headers(scope)[header] = value
+		return
+	}
+
+	switch variable {
+	case "req.url":
+		e.result.URL = value
+	case "req.backend":
+		e.result.Backend = e.vcl.Backend(value)
+	case "beresp.status", "resp.status":
+		if status, err := strconv.Atoi(value); err == nil {
+			e.result.Status = status
+		}
+	case "beresp.do_esi":
+		e.result.ESI = value == "true"
+	}
+}
+
+// headers returns the request or response headers a header variable of scope refers to
+func (e *execution) headers(scope string) map[string]string {
+	if scope == "req" {
+		return e.result.RequestHeaders
+	}
+	// The backend response becomes the response to the client
+	if e.result.ResponseHeaders == nil {
+		e.result.ResponseHeaders = make(map[string]string)
+	}
+	return e.result.ResponseHeaders
+}
+
+// headerVariable splits a header variable such as req.http.X-Forwarded-For into its
+// scope and canonical header name
+func headerVariable(variable string) (scope, header string, ok bool) {
+	scope, rest, _ := strings.Cut(variable, ".")
+	header, ok = strings.CutPrefix(rest, "http.")
+	return scope, textproto.CanonicalMIMEHeaderKey(header), ok
+}
+
+// statement is an executable VCL statement; a non-nil result ends the subroutine
+type statement interface {
+	exec(e *execution, depth int) *exit
+}
+
+// setStatement assigns a variable
+type setStatement struct {
+	target string
+	value  expr
+}
+
+func (s *setStatement) exec(e *execution, depth int) *exit {
+	e.set(s.target, s.value.eval(e))
+	return nil
+}
+
+// unsetStatement removes a header
+type unsetStatement struct {
+	target string
+}
+
+func (s *unsetStatement) exec(e *execution, depth int) *exit {
+	scope, header, _ := headerVariable(s.target)
+	delete(e.headers(scope), header)
+	return nil
+}
+
+// callStatement runs another subroutine; its return ends the caller too
+type callStatement struct {
+	sub string
+}
+
+func (s *callStatement) exec(e *execution, depth int) *exit {
+	if depth >= maxCallDepth {
+		return &exit{}
+	}
+	return e.run(e.vcl.subroutines[s.sub], depth+1)
+}
+
+// returnStatement ends the subroutine
+type returnStatement struct {
+	action string
+}
+
+func (s *returnStatement) exec(e *execution, depth int) *exit {
+	return &exit{action: s.action}
+}
+
+// errorStatement answers the request with a synthetic response
+type errorStatement struct {
+	status  int
+	message string
+}
+
+func (s *errorStatement) exec(e *execution, depth int) *exit {
+	e.result.ErrorStatus = s.status
+	e.result.ErrorMessage = s.message
+	if e.result.ErrorMessage == "" {
+		e.result.ErrorMessage = http.StatusText(s.status)
+	}
+	return &exit{action: "error"}
+}
+
+// ifStatement runs the body of the first branch whose condition holds; a final else
+// branch has no condition
+type ifStatement struct {
+	branches []branch
+}
+
+type branch struct {
+	cond condition
+	body []statement
+}
+
+func (s *ifStatement) exec(e *execution, depth int) *exit {
+	for _, b := range s.branches {
+		if b.cond == nil || b.cond.eval(e) {
+			return e.run(b.body, depth)
+		}
+	}
+	return nil
+}
+
+// expr is a string expression: literals and variables joined by +
+type expr []operand
+
+type operand struct {
+	literal  string
+	variable string
+}
+
+func (x expr) eval(e *execution) string {
+	var value strings.Builder
+	for _, o := range x {
+		if o.variable != "" {
+			value.WriteString(e.get(o.variable))
+		} else {
+			value.WriteString(o.literal)
+		}
+	}
+	return value.String()
+}
+
+// condition is a boolean VCL expression
+type condition interface {
+	eval(e *execution) bool
+}
+
+// setCondition holds when the expression is not empty, such as a header that is set
+type setCondition struct{ value expr }
+
+func (c *setCondition) eval(e *execution) bool { return c.value.eval(e) != "" }
+
+// compareCondition compares two expressions with == or, negated, !=
+type compareCondition struct {
+	left, right expr
+	negate      bool
+}
+
+func (c *compareCondition) eval(e *execution) bool {
+	return (c.left.eval(e) == c.right.eval(e)) != c.negate
+}
+
+// matchCondition matches an expression against a regular expression with ~ or, negated, !~
+type matchCondition struct {
+	value   expr
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+func (c *matchCondition) eval(e *execution) bool {
+	return c.pattern.MatchString(c.value.eval(e)) != c.negate
+}
+
+type notCondition struct{ cond condition }
+
+func (c *notCondition) eval(e *execution) bool { return !c.cond.eval(e) }
+
+type andCondition struct{ left, right condition }
+
+func (c *andCondition) eval(e *execution) bool { return c.left.eval(e) && c.right.eval(e) }
+
+type orCondition struct{ left, right condition }
+
+func (c *orCondition) eval(e *execution) bool { return c.left.eval(e) || c.right.eval(e) }
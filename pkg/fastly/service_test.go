@@ -0,0 +1,113 @@
+package fastly
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcess(t *testing.T) {
+	vcl, err := Parse(testVCL)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/products?page=2", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone) Mobile")
+	req.Header.Set("Cookie", "session=abc")
+	origin := http.Header{"Content-Type": {"text/html; charset=utf-8"}, "Server": {"nginx"}, "Via": {"1.1 varnish"}}
+
+	result := vcl.Process(req, http.StatusOK, origin)
+	assert.Equal(t, "F_origin", result.Backend.Name)
+	assert.Equal(t, "/products?page=2", result.URL)
+	assert.Equal(t, "mobile", result.RequestHeaders["X-Device"])
+	assert.Equal(t, "eu-mobile", result.RequestHeaders["X-Region"])
+	assert.Equal(t, "www.example.com", result.RequestHeaders["Host"])
+	assert.NotContains(t, result.RequestHeaders, "Cookie")
+	assert.Empty(t, result.Return)
+
+	assert.True(t, result.ESI)
+	assert.Equal(t, http.StatusOK, result.Status)
+	assert.Equal(t, map[string]string{"Content-Type": "text/html; charset=utf-8", "X-Served-By": "emulator"}, result.ResponseHeaders)
+}
+
+func TestProcess_Branches(t *testing.T) {
+	vcl, err := Parse(testVCL)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/api/users", nil)
+	result := vcl.Process(req, http.StatusOK, http.Header{"Content-Type": {"application/json"}})
+	assert.Equal(t, "F_api", result.Backend.Name)
+	assert.Equal(t, "pass", result.Return)
+	assert.NotContains(t, result.RequestHeaders, "X-Device", "return ends vcl_recv")
+	assert.False(t, result.ESI)
+
+	req = httptest.NewRequest(http.MethodGet, "http://www.example.com/", nil)
+	req.Header.Set("User-Agent", "Mobile Safari")
+	req.Header.Set("X-Force-Desktop", "1")
+	assert.Equal(t, "desktop", vcl.Recv(req).RequestHeaders["X-Device"])
+
+	req = httptest.NewRequest(http.MethodGet, "http://blocked.example.com/", nil)
+	result = vcl.Process(req, http.StatusOK, nil)
+	assert.Equal(t, http.StatusForbidden, result.ErrorStatus)
+	assert.Equal(t, "Forbidden", result.ErrorMessage)
+	assert.Zero(t, result.Status, "the backend is not fetched")
+}
+
+func TestProcess_Variables(t *testing.T) {
+	vcl, err := Parse(`
+sub vcl_recv {
+    set req.http.X-Path = req.url.path;
+    set req.http.X-Query = req.url.qs;
+    set req.http.X-Method = req.method;
+    set req.http.X-Client = client.ip;
+    if (req.method != "GET" || req.url.qs == "") {
+        set req.url = "/fallback";
+    }
+    if (req.http.Host !~ "\.example\.com$") {
+        error 421;
+    }
+}
+sub vcl_fetch {
+    if (beresp.status == "404") {
+        set beresp.status = 200;
+        set beresp.do_esi = true;
+    }
+}
+sub vcl_deliver {
+    set resp.http.X-Status = resp.status;
+}`)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/a/b?c=d", nil)
+	req.RemoteAddr = "192.0.2.10:5050"
+	result := vcl.Process(req, http.StatusNotFound, nil)
+	assert.Equal(t, "/a/b", result.RequestHeaders["X-Path"])
+	assert.Equal(t, "c=d", result.RequestHeaders["X-Query"])
+	assert.Equal(t, "GET", result.RequestHeaders["X-Method"])
+	assert.Equal(t, "192.0.2.10", result.RequestHeaders["X-Client"])
+	assert.Equal(t, "/a/b?c=d", result.URL)
+	assert.Nil(t, result.Backend)
+	assert.True(t, result.ESI)
+	assert.Equal(t, "200", result.ResponseHeaders["X-Status"])
+
+	req = httptest.NewRequest(http.MethodPost, "http://www.example.com/a", nil)
+	req.Header.Set("Fastly-Client-IP", "203.0.113.7")
+	result = vcl.Recv(req)
+	assert.Equal(t, "/fallback", result.URL)
+	assert.Equal(t, "203.0.113.7", result.RequestHeaders["X-Client"])
+
+	result = vcl.Recv(httptest.NewRequest(http.MethodGet, "http://other.test/?a=1", nil))
+	assert.Equal(t, http.StatusMisdirectedRequest, result.ErrorStatus)
+	assert.Equal(t, "Misdirected Request", result.ErrorMessage)
+}
+
+func TestProcess_CallDepth(t *testing.T) {
+	vcl, err := Parse(`sub loop { set req.http.X-Loop = "1"; call loop; } sub vcl_recv { call loop; set req.http.X-After = "1"; }`)
+	require.NoError(t, err)
+
+	result := vcl.Recv(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "1", result.RequestHeaders["X-Loop"])
+	assert.NotContains(t, result.RequestHeaders, "X-After")
+}
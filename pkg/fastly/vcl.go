@@ -0,0 +1,506 @@
+// Package fastly emulates the part of a Fastly service that decides how a page is
+// served: a small subset of VCL selecting the backend, rewriting request and response
+// headers and enabling ESI, which the fastly-mode ESI processor then applies.
+package fastly
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Subroutines run for a request, in order. Other subroutines can be run with call.
+const (
+	SubRecv    = "vcl_recv"    // The client request, before the backend is chosen
+	SubFetch   = "vcl_fetch"   // The backend response, where ESI is enabled
+	SubDeliver = "vcl_deliver" // The response to the client
+)
+
+// Backend is an origin declared with a backend block
+type Backend struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+	SSL  bool   `json:"ssl,omitempty"`
+}
+
+// URL returns the scheme and address of the backend, such as https://origin.example.com
+func (b Backend) URL() string {
+	scheme, defaultPort := "http", 80
+	if b.SSL || b.Port == 443 {
+		scheme, defaultPort = "https", 443
+	}
+	if b.Port == 0 || b.Port == defaultPort {
+		return scheme + "://" + b.Host
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, b.Host, b.Port)
+}
+
+// VCL is a parsed VCL service configuration. The supported subset is backend
+// declarations and subroutines of set, unset (or remove), if/else if/else, esi,
+// call, return and error statements, with ==, !=, ~ and !~ comparisons, !, && and ||
+// conditions and strings joined with +.
+type VCL struct {
+	Backends    []Backend
+	subroutines map[string][]statement
+}
+
+// ParseFile reads and parses a VCL file
+func ParseFile(path string) (*VCL, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vcl, err := Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return vcl, nil
+}
+
+// Parse parses VCL source, reporting the first unsupported or malformed construct
+func Parse(src string) (*VCL, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	vcl := &VCL{subroutines: make(map[string][]statement)}
+
+	for p.peek().kind != tokenEOF {
+		keyword := p.next()
+		switch keyword.text {
+		case "backend":
+			backend, err := p.backend()
+			if err != nil {
+				return nil, err
+			}
+			if vcl.Backend(backend.Name) != nil {
+				return nil, fmt.Errorf("line %d: backend %s is declared twice", keyword.line, backend.Name)
+			}
+			vcl.Backends = append(vcl.Backends, backend)
+		case "sub":
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			p.sub = name.text
+			body, err := p.block()
+			if err != nil {
+				return nil, err
+			}
+			// Fastly concatenates subroutines declared more than once, as snippets are
+			vcl.subroutines[name.text] = append(vcl.subroutines[name.text], body...)
+		default:
+			return nil, fmt.Errorf("line %d: expected backend or sub, got %s", keyword.line, keyword)
+		}
+	}
+
+	for _, ref := range p.backendRefs {
+		if vcl.Backend(ref.text) == nil {
+			return nil, fmt.Errorf("line %d: unknown backend %s", ref.line, ref.text)
+		}
+	}
+	for _, ref := range p.calls {
+		if _, ok := vcl.subroutines[ref.text]; !ok {
+			return nil, fmt.Errorf("line %d: unknown subroutine %s", ref.line, ref.text)
+		}
+	}
+	return vcl, nil
+}
+
+// Backend returns the backend declared with name, or nil
+func (v *VCL) Backend(name string) *Backend {
+	for i := range v.Backends {
+		if v.Backends[i].Name == name {
+			return &v.Backends[i]
+		}
+	}
+	return nil
+}
+
+// variables are the supported variables other than headers, such as req.http.Host,
+// and whether they can be set
+var variables = map[string]bool{
+	"req.url":       true,
+	"req.url.path":  false,
+	"req.url.qs":    false,
+	"req.method":    false,
+	"req.request":   false,
+	"req.backend":   true,
+	"client.ip":     false,
+	"beresp.status": true,
+	"beresp.do_esi": true,
+	"resp.status":   true,
+}
+
+// parser is a recursive descent parser over lexed VCL
+type parser struct {
+	tokens []token
+	pos    int
+	// sub is the subroutine being parsed, which decides the variables it can use
+	sub string
+	// backendRefs and calls are checked once every backend and subroutine is declared
+	backendRefs []token
+	calls       []token
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token when it is the symbol or keyword text
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); t.kind != tokenString && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the symbol or keyword text, or fails
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("line %d: expected %q, got %s", t.line, text, t)
+	}
+	return nil
+}
+
+// ident consumes a name
+func (p *parser) ident() (token, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return t, fmt.Errorf("line %d: expected a name, got %s", t.line, t)
+	}
+	return t, nil
+}
+
+// backend parses a backend declaration after the backend keyword
+func (p *parser) backend() (Backend, error) {
+	name, err := p.ident()
+	if err != nil {
+		return Backend{}, err
+	}
+	backend := Backend{Name: name.text}
+	if err := p.expect("{"); err != nil {
+		return Backend{}, err
+	}
+	for !p.accept("}") {
+		property, err := p.ident()
+		if err != nil {
+			return Backend{}, err
+		}
+		if err := p.expect("="); err != nil {
+			return Backend{}, err
+		}
+		value := p.next()
+		if value.kind == tokenEOF {
+			return Backend{}, fmt.Errorf("line %d: backend %s is never closed", name.line, name.text)
+		}
+		switch property.text {
+		case ".host":
+			backend.Host = value.text
+		case ".port":
+			port, err := strconv.Atoi(value.text)
+			if err != nil || port <= 0 || port > 65535 {
+				return Backend{}, fmt.Errorf("line %d: invalid port %s", value.line, value)
+			}
+			backend.Port = port
+		case ".ssl":
+			backend.SSL = value.text == "true"
+		}
+		// Other properties, such as timeouts and probes, do not change what is served
+		if err := p.expect(";"); err != nil {
+			return Backend{}, err
+		}
+	}
+	if backend.Host == "" {
+		return Backend{}, fmt.Errorf("line %d: backend %s has no .host", name.line, name.text)
+	}
+	return backend, nil
+}
+
+// block parses statements between braces
+func (p *parser) block() ([]statement, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var statements []statement
+	for !p.accept("}") {
+		if p.peek().kind == tokenEOF {
+			return nil, fmt.Errorf("line %d: %s is never closed", p.peek().line, p.sub)
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, s)
+	}
+	return statements, nil
+}
+
+// statement parses a statement
+func (p *parser) statement() (statement, error) {
+	keyword, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+
+	switch keyword.text {
+	case "set":
+		target, err := p.variable(true)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		s := &setStatement{target: target.text}
+		switch target.text {
+		case "req.backend":
+			backend, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			p.backendRefs = append(p.backendRefs, backend)
+			s.value = expr{{literal: backend.text}}
+		case "beresp.do_esi":
+			value := p.next()
+			if value.text != "true" && value.text != "false" {
+				return nil, fmt.Errorf("line %d: beresp.do_esi is true or false, got %s", value.line, value)
+			}
+			s.value = expr{{literal: value.text}}
+		default:
+			if s.value, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		return s, p.expect(";")
+	case "unset", "remove":
+		target, err := p.variable(true)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(target.text, ".http.") {
+			return nil, fmt.Errorf("line %d: only headers can be unset, got %s", target.line, target.text)
+		}
+		return &unsetStatement{target: target.text}, p.expect(";")
+	case "esi":
+		if p.sub != SubFetch {
+			return nil, fmt.Errorf("line %d: esi is only allowed in %s", keyword.line, SubFetch)
+		}
+		return &setStatement{target: "beresp.do_esi", value: expr{{literal: "true"}}}, p.expect(";")
+	case "call":
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		p.calls = append(p.calls, name)
+		return &callStatement{sub: name.text}, p.expect(";")
+	case "return":
+		s := &returnStatement{}
+		if p.accept("(") {
+			action, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			s.action = action.text
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		}
+		return s, p.expect(";")
+	case "error":
+		status := p.next()
+		code, err := strconv.Atoi(status.text)
+		if status.kind != tokenNumber || err != nil || code < 100 || code > 999 {
+			return nil, fmt.Errorf("line %d: error needs a status code, got %s", status.line, status)
+		}
+		s := &errorStatement{status: code}
+		if p.peek().kind == tokenString {
+			s.message = p.next().text
+		}
+		return s, p.expect(";")
+	case "if":
+		return p.ifStatement()
+	}
+	return nil, fmt.Errorf("line %d: unsupported statement %s", keyword.line, keyword.text)
+}
+
+// ifStatement parses an if statement and its else if and else branches
+func (p *parser) ifStatement() (statement, error) {
+	s := &ifStatement{}
+	for {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.branches = append(s.branches, branch{cond: cond, body: body})
+
+		switch {
+		case p.accept("elsif"), p.accept("elseif"):
+			continue
+		case p.accept("else"):
+			if p.accept("if") {
+				continue
+			}
+			body, err := p.block()
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, branch{body: body})
+		}
+		return s, nil
+	}
+}
+
+// condition parses conditions joined by ||
+func (p *parser) condition() (condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &orCondition{left, right}
+	}
+	return left, nil
+}
+
+// and parses conditions joined by &&
+func (p *parser) and() (condition, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andCondition{left, right}
+	}
+	return left, nil
+}
+
+// unary parses a negated or parenthesized condition, or a comparison
+func (p *parser) unary() (condition, error) {
+	if p.accept("!") {
+		cond, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notCondition{cond}, nil
+	}
+	if p.accept("(") {
+		cond, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.expect(")")
+	}
+
+	left, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	operator := p.peek()
+	switch operator.text {
+	case "==", "!=":
+		p.next()
+		right, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &compareCondition{left: left, right: right, negate: operator.text == "!="}, nil
+	case "~", "!~":
+		p.next()
+		pattern := p.next()
+		if pattern.kind != tokenString {
+			return nil, fmt.Errorf("line %d: %s needs a regular expression string, got %s", pattern.line, operator.text, pattern)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid regular expression %q: %v", pattern.line, pattern.text, err)
+		}
+		return &matchCondition{value: left, pattern: re, negate: operator.text == "!~"}, nil
+	}
+	return &setCondition{left}, nil
+}
+
+// expr parses strings, numbers and variables joined by +
+func (p *parser) expr() (expr, error) {
+	var e expr
+	for {
+		t := p.peek()
+		switch t.kind {
+		case tokenString, tokenNumber:
+			p.next()
+			e = append(e, operand{literal: t.text})
+		case tokenIdent:
+			variable, err := p.variable(false)
+			if err != nil {
+				return nil, err
+			}
+			e = append(e, operand{variable: variable.text})
+		default:
+			return nil, fmt.Errorf("line %d: expected a string or variable, got %s", t.line, t)
+		}
+		if !p.accept("+") {
+			return e, nil
+		}
+	}
+}
+
+// variable consumes a variable name, checking it exists and, when set, that it can be
+// assigned, in the subroutine being parsed
+func (p *parser) variable(set bool) (token, error) {
+	t, err := p.ident()
+	if err != nil {
+		return t, err
+	}
+	scope, name, _ := strings.Cut(t.text, ".")
+
+	// Custom subroutines can be called from any of the built-in ones
+	if builtin := p.sub == SubRecv || p.sub == SubFetch || p.sub == SubDeliver; builtin {
+		if scope == "beresp" && p.sub != SubFetch || scope == "resp" && p.sub != SubDeliver {
+			return t, fmt.Errorf("line %d: %s is not available in %s", t.line, t.text, p.sub)
+		}
+	}
+
+	if header, ok := strings.CutPrefix(name, "http."); ok && header != "" && (scope == "req" || scope == "beresp" || scope == "resp") {
+		return t, nil
+	}
+	settable, ok := variables[t.text]
+	if !ok {
+		return t, fmt.Errorf("line %d: unsupported variable %s", t.line, t.text)
+	}
+	if set && !settable {
+		return t, fmt.Errorf("line %d: %s cannot be set", t.line, t.text)
+	}
+	return t, nil
+}
@@ -0,0 +1,119 @@
+package fastly
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testVCL = `
+# Backends
+backend F_origin {
+    .host = "origin.example.com";
+    .port = "443";
+    .connect_timeout = 1s;
+}
+backend F_api { .host = "api.internal"; .port = "8080"; }
+
+sub vcl_recv {
+    /* Route the API to its own backend */
+    if (req.url ~ "^/api/") {
+        set req.backend = F_api;
+        return(pass);
+    } elsif (req.http.Host == "blocked.example.com") {
+        error 403 "Forbidden";
+    }
+    set req.http.X-Device = "desktop";
+    if (req.http.User-Agent ~ "(?i)mobile" && !req.http.X-Force-Desktop) {
+        set req.http.X-Device = "mobile";
+    }
+    unset req.http.Cookie;
+    call add_region;
+}
+
+sub add_region {
+    set req.http.X-Region = "eu-" + req.http.X-Device;
+}
+
+sub vcl_fetch {
+    if (beresp.http.Content-Type ~ "^text/html") {
+        esi;
+    }
+    unset beresp.http.Server;
+}
+
+sub vcl_deliver {
+    set resp.http.X-Served-By = "emulator";
+    remove resp.http.Via;
+}
+`
+
+func TestParse(t *testing.T) {
+	vcl, err := Parse(testVCL)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Backend{
+		{Name: "F_origin", Host: "origin.example.com", Port: 443},
+		{Name: "F_api", Host: "api.internal", Port: 8080},
+	}, vcl.Backends)
+	assert.Len(t, vcl.subroutines[SubRecv], 5)
+	assert.Len(t, vcl.subroutines["add_region"], 1)
+	assert.Equal(t, "https://origin.example.com", vcl.Backend("F_origin").URL())
+	assert.Equal(t, "http://api.internal:8080", vcl.Backend("F_api").URL())
+	assert.Nil(t, vcl.Backend("F_missing"))
+}
+
+func TestParse_SnippetsConcatenated(t *testing.T) {
+	vcl, err := Parse(`sub vcl_recv { set req.http.A = "1"; } sub vcl_recv { set req.http.B = "2"; }`)
+	require.NoError(t, err)
+	assert.Len(t, vcl.subroutines[SubRecv], 2)
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		message string
+	}{
+		{"top level", `import std;`, "line 1: expected backend or sub"},
+		{"unknown backend", "sub vcl_recv {\n set req.backend = F_nope;\n}", "line 2: unknown backend F_nope"},
+		{"backend without host", `backend F_a { .port = "80"; }`, "backend F_a has no .host"},
+		{"invalid port", `backend F_a { .host = "a"; .port = "http"; }`, `invalid port "http"`},
+		{"duplicate backend", `backend F_a { .host = "a"; } backend F_a { .host = "b"; }`, "backend F_a is declared twice"},
+		{"unknown subroutine", `sub vcl_recv { call missing; }`, "unknown subroutine missing"},
+		{"unsupported statement", `sub vcl_recv { synthetic "x"; }`, "unsupported statement synthetic"},
+		{"unsupported variable", `sub vcl_fetch { set beresp.ttl = 60s; }`, "unsupported variable beresp.ttl"},
+		{"read-only variable", `sub vcl_recv { set client.ip = "1.2.3.4"; }`, "client.ip cannot be set"},
+		{"wrong subroutine", `sub vcl_recv { set resp.http.X = "1"; }`, "resp.http.X is not available in vcl_recv"},
+		{"esi outside fetch", `sub vcl_deliver { esi; }`, "esi is only allowed in vcl_fetch"},
+		{"invalid regex", `sub vcl_recv { if (req.url ~ "(") { return(pass); } }`, "invalid regular expression"},
+		{"do_esi value", `sub vcl_fetch { set beresp.do_esi = "yes"; }`, "beresp.do_esi is true or false"},
+		{"unset variable", `sub vcl_recv { unset req.url; }`, "only headers can be unset"},
+		{"missing semicolon", `sub vcl_recv { set req.http.A = "1" }`, `expected ";", got "}"`},
+		{"unclosed", `sub vcl_recv { set req.http.A = "1";`, "vcl_recv is never closed"},
+		{"unterminated string", `sub vcl_recv { set req.http.A = "1; }`, "unterminated string"},
+		{"error status", `sub vcl_recv { error "denied"; }`, "error needs a status code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.src)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.vcl")
+	require.NoError(t, os.WriteFile(path, []byte("sub vcl_fetch {\n  esi\n}"), 0o644))
+
+	_, err := ParseFile(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service.vcl: line 3")
+
+	_, err = ParseFile(filepath.Join(t.TempDir(), "missing.vcl"))
+	assert.Error(t, err)
+}
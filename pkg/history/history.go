@@ -0,0 +1,306 @@
+// Package history records the outcome of every processed page in a SQLite database,
+// so processing time and include failures can be compared across emulator versions
+// and template changes. Runs are written in the background, off the request path.
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	_ "modernc.org/sqlite" // Registers the sqlite database/sql driver
+)
+
+// DefaultLimit is the number of runs Runs returns when the filter sets no limit
+const DefaultLimit = 100
+
+// queueSize is the number of runs waiting to be written before new ones are dropped
+const queueSize = 1024
+
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at      INTEGER NOT NULL,
+	version         TEXT    NOT NULL,
+	mode            TEXT    NOT NULL,
+	template_hash   TEXT    NOT NULL,
+	duration_us     INTEGER NOT NULL,
+	includes        INTEGER NOT NULL,
+	failed_includes INTEGER NOT NULL,
+	error           TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS runs_template ON runs (template_hash, started_at);
+CREATE INDEX IF NOT EXISTS runs_started ON runs (started_at);
+`
+
+// Run is a recorded page processing run
+type Run struct {
+	ID      int64     `json:"id"`
+	Time    time.Time `json:"time"`
+	Version string    `json:"version"`
+	Mode    string    `json:"mode"`
+	// TemplateHash is the hex SHA-256 of the page as it was processed
+	TemplateHash   string  `json:"templateHash"`
+	DurationMS     float64 `json:"durationMs"`
+	Includes       int     `json:"includes"`
+	FailedIncludes int     `json:"failedIncludes"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// Summary aggregates the runs of a template under an emulator version and mode
+type Summary struct {
+	Version        string    `json:"version"`
+	Mode           string    `json:"mode"`
+	TemplateHash   string    `json:"templateHash"`
+	Runs           int       `json:"runs"`
+	First          time.Time `json:"first"`
+	Last           time.Time `json:"last"`
+	AvgMS          float64   `json:"avgMs"`
+	MinMS          float64   `json:"minMs"`
+	MaxMS          float64   `json:"maxMs"`
+	FailedIncludes int       `json:"failedIncludes"`
+	Errors         int       `json:"errors"` // Runs whose processing failed
+}
+
+// Filter selects runs; zero fields match every run
+type Filter struct {
+	Version string
+	Mode    string
+	// TemplateHash matches templates whose hash starts with it, so short hashes work
+	TemplateHash string
+	Since        time.Time
+	// Limit bounds the runs Runs returns, newest first; zero selects DefaultLimit
+	Limit int
+}
+
+// where returns the SQL condition and arguments of the filter
+func (f Filter) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if f.Version != "" {
+		conditions = append(conditions, "version = ?")
+		args = append(args, f.Version)
+	}
+	if f.Mode != "" {
+		conditions = append(conditions, "mode = ?")
+		args = append(args, f.Mode)
+	}
+	if f.TemplateHash != "" {
+		conditions = append(conditions, "template_hash LIKE ?")
+		args = append(args, strings.ToLower(f.TemplateHash)+"%")
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "started_at >= ?")
+		args = append(args, f.Since.UnixMicro())
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// Store is a run history database
+type Store struct {
+	db *sql.DB
+
+	queue   chan Run
+	done    chan struct{}
+	dropped atomic.Int64
+	closed  bool
+	mutex   sync.RWMutex // Guards closed and sends on queue
+}
+
+// Open opens the run history database at path, creating it when it does not exist
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes writes, which SQLite does anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("history database %s: %w", path, err)
+	}
+
+	store := &Store{db: db, queue: make(chan Run, queueSize), done: make(chan struct{})}
+	go store.write()
+	return store, nil
+}
+
+// Close writes the runs still queued and closes the database
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mutex.Unlock()
+
+	<-s.done
+	return s.db.Close()
+}
+
+// Recorder returns an esi.AfterRunHook queueing the runs of a processor, recorded as
+// run by version of the emulator. Runs are dropped when the queue is full.
+func (s *Store) Recorder(version string) esi.AfterRunHook {
+	return func(run esi.Run) {
+		recorded := Run{
+			Time:           run.Start,
+			Version:        version,
+			Mode:           run.Mode,
+			TemplateHash:   run.TemplateHash,
+			DurationMS:     float64(run.Duration.Microseconds()) / 1000,
+			Includes:       run.Includes,
+			FailedIncludes: run.FailedIncludes,
+		}
+		if run.Err != nil {
+			recorded.Error = run.Err.Error()
+		}
+
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		if s.closed {
+			s.dropped.Add(1)
+			return
+		}
+		select {
+		case s.queue <- recorded:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns the number of runs that were not recorded, because the queue was
+// full or writing them failed
+func (s *Store) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// write records queued runs until the queue is closed
+func (s *Store) write() {
+	defer close(s.done)
+	for run := range s.queue {
+		if _, err := s.Record(run); err != nil {
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Record writes a run and returns its ID
+func (s *Store) Record(run Run) (int64, error) {
+	result, err := s.db.Exec(`INSERT INTO runs
+		(started_at, version, mode, template_hash, duration_us, includes, failed_includes, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.Time.UnixMicro(), run.Version, run.Mode, run.TemplateHash,
+		int64(run.DurationMS*1000), run.Includes, run.FailedIncludes, run.Error)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Runs returns the runs the filter selects, newest first
+func (s *Store) Runs(filter Filter) ([]Run, error) {
+	where, args := filter.where()
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	rows, err := s.db.Query(`SELECT id, started_at, version, mode, template_hash, duration_us,
+		includes, failed_includes, error FROM runs WHERE `+where+`
+		ORDER BY started_at DESC, id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		var started, duration int64
+		if err := rows.Scan(&run.ID, &started, &run.Version, &run.Mode, &run.TemplateHash,
+			&duration, &run.Includes, &run.FailedIncludes, &run.Error); err != nil {
+			return nil, err
+		}
+		run.Time = time.UnixMicro(started).UTC()
+		run.DurationMS = float64(duration) / 1000
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// Summaries aggregates the runs the filter selects by template, mode and version,
+// ordered by template and, within a template, by when a version was first seen, so
+// consecutive summaries of a template show its trend. The filter's limit is ignored.
+func (s *Store) Summaries(filter Filter) ([]Summary, error) {
+	where, args := filter.where()
+	rows, err := s.db.Query(`SELECT version, mode, template_hash, COUNT(*),
+		MIN(started_at), MAX(started_at), AVG(duration_us), MIN(duration_us), MAX(duration_us),
+		SUM(failed_includes), SUM(error != '')
+		FROM runs WHERE `+where+`
+		GROUP BY template_hash, mode, version
+		ORDER BY template_hash, mode, MIN(started_at)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []Summary{}
+	for rows.Next() {
+		var summary Summary
+		var first, last, minimum, maximum int64
+		var average float64
+		if err := rows.Scan(&summary.Version, &summary.Mode, &summary.TemplateHash, &summary.Runs,
+			&first, &last, &average, &minimum, &maximum, &summary.FailedIncludes, &summary.Errors); err != nil {
+			return nil, err
+		}
+		summary.First = time.UnixMicro(first).UTC()
+		summary.Last = time.UnixMicro(last).UTC()
+		summary.AvgMS = average / 1000
+		summary.MinMS = float64(minimum) / 1000
+		summary.MaxMS = float64(maximum) / 1000
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+// Report renders summaries as a block per template and mode with a line per version,
+// and the change of the average processing time from the version before
+func Report(summaries []Summary) string {
+	if len(summaries) == 0 {
+		return "No runs recorded\n"
+	}
+
+	var report strings.Builder
+	var previous *Summary
+	for i := range summaries {
+		summary := &summaries[i]
+		if previous == nil || previous.TemplateHash != summary.TemplateHash || previous.Mode != summary.Mode {
+			if previous != nil {
+				report.WriteString("\n")
+			}
+			fmt.Fprintf(&report, "template %s (%s)\n", shortHash(summary.TemplateHash), summary.Mode)
+			previous = nil
+		}
+
+		fmt.Fprintf(&report, "  %-10s %5d runs  avg %8.2fms  min %8.2fms  max %8.2fms  failed includes %d  errors %d",
+			summary.Version, summary.Runs, summary.AvgMS, summary.MinMS, summary.MaxMS, summary.FailedIncludes, summary.Errors)
+		if previous != nil && previous.AvgMS > 0 {
+			fmt.Fprintf(&report, "  %+.1f%%", (summary.AvgMS-previous.AvgMS)/previous.AvgMS*100)
+		}
+		report.WriteString("\n")
+		previous = summary
+	}
+	return report.String()
+}
+
+// shortHash abbreviates a template hash for display
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
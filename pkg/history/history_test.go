@@ -0,0 +1,104 @@
+package history
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestStore(t *testing.T) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := Open(path)
+	require.NoError(t, err)
+	return store, path
+}
+
+func TestStore_RecordAndQuery(t *testing.T) {
+	store, _ := openTestStore(t)
+	defer store.Close()
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	runs := []Run{
+		{Time: start, Version: "1.0.0", Mode: "akamai", TemplateHash: "aaaa1111", DurationMS: 10, Includes: 2},
+		{Time: start.Add(time.Minute), Version: "1.0.0", Mode: "akamai", TemplateHash: "aaaa1111", DurationMS: 20, Includes: 2, FailedIncludes: 1},
+		{Time: start.Add(time.Hour), Version: "1.1.0", Mode: "akamai", TemplateHash: "aaaa1111", DurationMS: 12.5, Includes: 2, Error: "timeout"},
+		{Time: start.Add(2 * time.Hour), Version: "1.1.0", Mode: "fastly", TemplateHash: "bbbb2222", DurationMS: 3, Includes: 1},
+	}
+	for _, run := range runs {
+		_, err := store.Record(run)
+		require.NoError(t, err)
+	}
+
+	recorded, err := store.Runs(Filter{})
+	require.NoError(t, err)
+	require.Len(t, recorded, 4)
+	assert.Equal(t, "bbbb2222", recorded[0].TemplateHash)
+	assert.Equal(t, start.Add(time.Hour), recorded[1].Time)
+	assert.Equal(t, 12.5, recorded[1].DurationMS)
+	assert.Equal(t, "timeout", recorded[1].Error)
+
+	recorded, err = store.Runs(Filter{TemplateHash: "AAAA", Since: start.Add(time.Minute), Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, "1.1.0", recorded[0].Version)
+
+	recorded, err = store.Runs(Filter{Version: "1.0.0", Mode: "fastly"})
+	require.NoError(t, err)
+	assert.Empty(t, recorded)
+
+	summaries, err := store.Summaries(Filter{Mode: "akamai"})
+	require.NoError(t, err)
+	assert.Equal(t, []Summary{
+		{Version: "1.0.0", Mode: "akamai", TemplateHash: "aaaa1111", Runs: 2, First: start, Last: start.Add(time.Minute),
+			AvgMS: 15, MinMS: 10, MaxMS: 20, FailedIncludes: 1},
+		{Version: "1.1.0", Mode: "akamai", TemplateHash: "aaaa1111", Runs: 1, First: start.Add(time.Hour), Last: start.Add(time.Hour),
+			AvgMS: 12.5, MinMS: 12.5, MaxMS: 12.5, Errors: 1},
+	}, summaries)
+
+	report := Report(summaries)
+	assert.Contains(t, report, "template aaaa1111 (akamai)\n")
+	assert.Contains(t, report, "  1.1.0          1 runs  avg    12.50ms")
+	assert.Contains(t, report, "errors 1  -16.7%\n")
+	assert.Equal(t, "No runs recorded\n", Report(nil))
+}
+
+func TestStore_Recorder(t *testing.T) {
+	store, path := openTestStore(t)
+
+	record := store.Recorder("2.0.0")
+	record(esi.Run{Start: time.Now(), Mode: "w3c", TemplateHash: "cccc3333", Duration: 1500 * time.Microsecond, Includes: 3, FailedIncludes: 1})
+	record(esi.Run{Start: time.Now(), Mode: "w3c", TemplateHash: "cccc3333", Err: errors.New("parse failed")})
+
+	// Closing writes the queued runs; later ones are dropped
+	require.NoError(t, store.Close())
+	record(esi.Run{Start: time.Now(), Mode: "w3c"})
+	assert.Equal(t, int64(1), store.Dropped())
+
+	// The history persists across opens
+	store, err := Open(path)
+	require.NoError(t, err)
+	defer store.Close()
+	runs, err := store.Runs(Filter{Version: "2.0.0"})
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "parse failed", runs[0].Error)
+	assert.Equal(t, 1.5, runs[1].DurationMS)
+	assert.Equal(t, 3, runs[1].Includes)
+	assert.Equal(t, 1, runs[1].FailedIncludes)
+}
+
+func TestStore_WithProcessor(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	processor := esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 2})
+	processor.OnAfterRun(store.Recorder("1.0.0"))
+	_, err := processor.Process(`<esi:vars>$(HTTP_HOST)</esi:vars>`, esi.ProcessContext{})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	assert.Zero(t, store.Dropped())
+}
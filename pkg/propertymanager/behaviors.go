@@ -2,6 +2,7 @@ package propertymanager
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -76,46 +77,6 @@ func (pm *PropertyManager) executeSetVariable(behavior *Behavior, context *HTTPC
 	return nil
 }
 
-// executeRedirect performs a redirect
-func (pm *PropertyManager) executeRedirect(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
-	var redirectURL, statusCode string
-
-	for _, option := range behavior.Option {
-		switch option.Name {
-		case "destination":
-			redirectURL = option.Value
-		case "status_code":
-			statusCode = option.Value
-		}
-	}
-
-	if redirectURL != "" {
-		if statusCode == "" {
-			statusCode = "302"
-		}
-
-		result.ResponseContent = fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-    <title>Redirecting...</title>
-    <meta http-equiv="refresh" content="0;url=%s">
-</head>
-<body>
-    <p>Redirecting to <a href="%s">%s</a>...</p>
-</body>
-</html>`, redirectURL, redirectURL, redirectURL)
-
-		result.ModifiedHeaders["Location"] = redirectURL
-		result.ModifiedHeaders["Status"] = statusCode
-
-		if pm.Debug {
-			fmt.Printf("🔄 Redirect: %s (Status: %s)\n", redirectURL, statusCode)
-		}
-	}
-
-	return nil
-}
-
 // executeCacheKeyQueryParams modifies cache key based on query parameters
 func (pm *PropertyManager) executeCacheKeyQueryParams(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
 	var behaviorType string
@@ -156,26 +117,6 @@ func (pm *PropertyManager) executeOriginErrorPassThru(behavior *Behavior, contex
 	return nil
 }
 
-// executeESI enables ESI processing
-func (pm *PropertyManager) executeESI(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
-	var enabled string
-
-	for _, option := range behavior.Option {
-		switch option.Name {
-		case "enabled":
-			enabled = option.Value
-		}
-	}
-
-	if pm.Debug {
-		fmt.Printf("🔧 ESI processing: %s\n", enabled)
-	}
-
-	// This behavior would typically enable ESI processing
-	// For now, we'll just log it
-	return nil
-}
-
 // executeGzipResponse enables gzip compression
 func (pm *PropertyManager) executeGzipResponse(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
 	var enabled string
@@ -189,6 +130,10 @@ func (pm *PropertyManager) executeGzipResponse(behavior *Behavior, context *HTTP
 
 	if enabled == "true" {
 		result.ModifiedHeaders["Content-Encoding"] = "gzip"
+		if result.CompressionSettings == nil {
+			result.CompressionSettings = make(map[string]interface{})
+		}
+		result.CompressionSettings["gzip"] = true
 		if pm.Debug {
 			fmt.Printf("🗜️  Gzip compression enabled\n")
 		}
@@ -228,6 +173,8 @@ func (pm *PropertyManager) executeEdgeRedirector(behavior *Behavior, context *HT
 		}
 
 		result.ModifiedHeaders["Location"] = destination
+		result.RedirectLocation = destination
+		result.RedirectStatus, _ = strconv.Atoi(result.ModifiedHeaders["Status"])
 	}
 
 	return nil
@@ -283,6 +230,7 @@ func (pm *PropertyManager) expandVariables(input string, context *HTTPContext) s
 	result = strings.ReplaceAll(result, "$(HTTP_PATH)", context.Path)
 	result = strings.ReplaceAll(result, "$(HTTP_QUERY)", context.Query)
 	result = strings.ReplaceAll(result, "$(CLIENT_IP)", context.ClientIP)
+	result = strings.ReplaceAll(result, "$(TRUE_CLIENT_IP)", context.trueClientIP())
 	result = strings.ReplaceAll(result, "$(USER_AGENT)", context.UserAgent)
 
 	return result
@@ -0,0 +1,206 @@
+package propertymanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxBodyInspectBytes is how much of a request body the body criteria inspect
+// unless PropertyManager.MaxBodyInspectBytes says otherwise
+const DefaultMaxBodyInspectBytes = 64 << 10
+
+// maxBodyInspectBytes returns the configured body inspection limit
+func (pm *PropertyManager) maxBodyInspectBytes() int {
+	if pm.MaxBodyInspectBytes > 0 {
+		return pm.MaxBodyInspectBytes
+	}
+	return DefaultMaxBodyInspectBytes
+}
+
+// readRequestBody reads up to limit bytes of the request body for the body criteria
+// and puts them back in front of the unread remainder, so later readers still see the
+// whole body. The size is the Content-Length when known, else the bytes seen.
+func readRequestBody(req *http.Request, limit int) (body string, size int64, truncated bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return "", 0, false
+	}
+
+	data, err := io.ReadAll(io.LimitReader(req.Body, int64(limit)+1))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), req.Body), req.Body}
+	if err != nil {
+		return "", 0, true
+	}
+
+	truncated = len(data) > limit
+	if truncated {
+		data = data[:limit]
+	}
+	size = req.ContentLength
+	if size < 0 {
+		size = int64(len(data))
+	}
+	return string(data), size, truncated
+}
+
+// limitContextBody applies the body inspection limit to a context that was built by
+// hand rather than from a request, filling in BodySize when it was left out
+func (pm *PropertyManager) limitContextBody(context *HTTPContext) {
+	if context.BodySize == 0 {
+		context.BodySize = int64(len(context.Body))
+	}
+	if limit := pm.maxBodyInspectBytes(); len(context.Body) > limit {
+		context.Body = context.Body[:limit]
+		context.BodyTruncated = true
+	}
+}
+
+// evaluateBodyContentTypeCriterion evaluates the media type of the request body,
+// ignoring parameters such as charset and case
+func (pm *PropertyManager) evaluateBodyContentTypeCriterion(criterion *Criterion, context *HTTPContext) bool {
+	contentType := context.Headers["Content-Type"]
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	value := strings.ToLower(criterion.Value)
+
+	switch criterion.Option {
+	case "equals":
+		return contentType == value
+	case "not_equals":
+		return contentType != value
+	case "starts_with":
+		return strings.HasPrefix(contentType, value)
+	case "ends_with":
+		return strings.HasSuffix(contentType, value)
+	case "contains":
+		return strings.Contains(contentType, value)
+	default:
+		return contentType == value
+	}
+}
+
+// evaluateBodySizeCriterion evaluates the size in bytes of the request body
+func (pm *PropertyManager) evaluateBodySizeCriterion(criterion *Criterion, context *HTTPContext) bool {
+	value, err := strconv.ParseInt(criterion.Value, 10, 64)
+	if err != nil {
+		if pm.Debug {
+			fmt.Printf("⚠️  Invalid body size: %s\n", criterion.Value)
+		}
+		return false
+	}
+
+	switch criterion.Option {
+	case "equals":
+		return context.BodySize == value
+	case "not_equals":
+		return context.BodySize != value
+	case "greater_than":
+		return context.BodySize > value
+	case "less_than":
+		return context.BodySize < value
+	default:
+		return context.BodySize == value
+	}
+}
+
+// evaluateBodyJSONCriterion evaluates a field of a JSON request body. The option is
+// the dot-separated path of the field, with numbers indexing arrays (items.0.sku);
+// bodies cut off at the inspection limit never match.
+func (pm *PropertyManager) evaluateBodyJSONCriterion(criterion *Criterion, context *HTTPContext) bool {
+	if context.Body == "" || context.BodyTruncated {
+		return false
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(context.Body))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		if pm.Debug {
+			fmt.Printf("⚠️  Request body is not JSON: %v\n", err)
+		}
+		return false
+	}
+
+	fieldValue, exists := lookupJSONField(document, criterion.Option)
+	if criterion.Extract == "exists" {
+		return exists
+	}
+	if !exists {
+		return false
+	}
+
+	value := criterion.Value
+	if !criterion.Case {
+		fieldValue = strings.ToLower(fieldValue)
+		value = strings.ToLower(value)
+	}
+
+	switch criterion.Extract {
+	case "equals":
+		return fieldValue == value
+	case "not_equals":
+		return fieldValue != value
+	case "starts_with":
+		return strings.HasPrefix(fieldValue, value)
+	case "ends_with":
+		return strings.HasSuffix(fieldValue, value)
+	case "contains":
+		return strings.Contains(fieldValue, value)
+	case "regex":
+		return pm.matchRegex(value, fieldValue)
+	default:
+		return fieldValue == value
+	}
+}
+
+// lookupJSONField returns the field of document at the dot-separated path as text:
+// strings as they are, numbers, booleans and null as written in JSON, objects and
+// arrays as compact JSON
+func lookupJSONField(document interface{}, path string) (string, bool) {
+	current := document
+	if path != "" {
+		for _, segment := range strings.Split(path, ".") {
+			switch node := current.(type) {
+			case map[string]interface{}:
+				next, ok := node[segment]
+				if !ok {
+					return "", false
+				}
+				current = next
+			case []interface{}:
+				index, err := strconv.Atoi(segment)
+				if err != nil || index < 0 || index >= len(node) {
+					return "", false
+				}
+				current = node[index]
+			default:
+				return "", false
+			}
+		}
+	}
+
+	switch value := current.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case nil:
+		return "null", true
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+}
@@ -0,0 +1,174 @@
+package propertymanager
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func bodyRulePM(criteria ...Criterion) *PropertyManager {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{{Name: "body", Criteria: criteria}}}}
+	return pm
+}
+
+func postRequest(body, contentType string) *http.Request {
+	req, _ := http.NewRequest("POST", "/api/orders", strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+func TestProcessRequest_BodyContentTypeCriterion(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		criterion   Criterion
+		expected    bool
+	}{
+		{"equals ignores parameters", "application/json; charset=utf-8", Criterion{Name: "body_content_type", Option: "equals", Value: "application/json"}, true},
+		{"equals ignores case", "Application/JSON", Criterion{Name: "body_content_type", Option: "equals", Value: "application/json"}, true},
+		{"not equals", "text/plain", Criterion{Name: "body_content_type", Option: "not_equals", Value: "application/json"}, true},
+		{"ends with", "application/vnd.api+json", Criterion{Name: "body_content_type", Option: "ends_with", Value: "+json"}, true},
+		{"missing", "", Criterion{Name: "body_content_type", Option: "equals", Value: "application/json"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := bodyRulePM(tt.criterion)
+			result, err := pm.ProcessRequest(postRequest("{}", tt.contentType))
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if matched := len(result.MatchedRules) == 1; matched != tt.expected {
+				t.Errorf("Expected match %v, got %v", tt.expected, matched)
+			}
+		})
+	}
+}
+
+func TestProcessRequest_BodySizeCriterion(t *testing.T) {
+	tests := []struct {
+		option   string
+		value    string
+		expected bool
+	}{
+		{"equals", "11", true},
+		{"not_equals", "11", false},
+		{"greater_than", "10", true},
+		{"greater_than", "11", false},
+		{"less_than", "12", true},
+		{"less_than", "not-a-number", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.option+" "+tt.value, func(t *testing.T) {
+			pm := bodyRulePM(Criterion{Name: "body_size", Option: tt.option, Value: tt.value})
+			result, err := pm.ProcessRequest(postRequest(`{"a":"xyz"}`, "application/json"))
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if matched := len(result.MatchedRules) == 1; matched != tt.expected {
+				t.Errorf("Expected match %v, got %v", tt.expected, matched)
+			}
+		})
+	}
+}
+
+func TestProcessRequest_BodyJSONCriterion(t *testing.T) {
+	body := `{"order": {"id": 42, "express": true, "note": null, "items": [{"sku": "BULK-7"}, {"sku": "A-1"}]}, "region": "EU"}`
+	tests := []struct {
+		name      string
+		criterion Criterion
+		expected  bool
+	}{
+		{"string field", Criterion{Name: "body_json", Option: "region", Extract: "equals", Value: "eu"}, true},
+		{"case sensitive", Criterion{Name: "body_json", Option: "region", Extract: "equals", Value: "eu", Case: true}, false},
+		{"number field", Criterion{Name: "body_json", Option: "order.id", Extract: "equals", Value: "42"}, true},
+		{"boolean field", Criterion{Name: "body_json", Option: "order.express", Value: "true"}, true},
+		{"null field", Criterion{Name: "body_json", Option: "order.note", Value: "null"}, true},
+		{"array index", Criterion{Name: "body_json", Option: "order.items.0.sku", Extract: "starts_with", Value: "BULK-"}, true},
+		{"array out of range", Criterion{Name: "body_json", Option: "order.items.5.sku", Extract: "exists"}, false},
+		{"regex", Criterion{Name: "body_json", Option: "order.items.1.sku", Extract: "regex", Value: "^a-[0-9]+$"}, true},
+		{"exists", Criterion{Name: "body_json", Option: "order.items", Extract: "exists"}, true},
+		{"missing field", Criterion{Name: "body_json", Option: "order.coupon", Extract: "not_equals", Value: "x"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := bodyRulePM(tt.criterion)
+			result, err := pm.ProcessRequest(postRequest(body, "application/json"))
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if matched := len(result.MatchedRules) == 1; matched != tt.expected {
+				t.Errorf("Expected match %v, got %v", tt.expected, matched)
+			}
+		})
+	}
+}
+
+func TestProcessRequest_BodyJSONCriterionInvalidJSON(t *testing.T) {
+	pm := bodyRulePM(Criterion{Name: "body_json", Option: "region", Extract: "exists"})
+	result, err := pm.ProcessRequest(postRequest("region=EU", "application/x-www-form-urlencoded"))
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if len(result.MatchedRules) != 0 {
+		t.Errorf("Expected no match for a form body, got %v", result.MatchedRules)
+	}
+}
+
+func TestProcessRequest_BodyInspectionLimit(t *testing.T) {
+	body := `{"region": "EU", "padding": "` + strings.Repeat("x", 100) + `"}`
+	pm := bodyRulePM(
+		Criterion{Name: "body_json", Option: "region", Extract: "exists"},
+	)
+	pm.MaxBodyInspectBytes = 32
+
+	req := postRequest(body, "application/json")
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if len(result.MatchedRules) != 0 {
+		t.Errorf("Expected a truncated body not to match, got %v", result.MatchedRules)
+	}
+
+	// The body is still readable in full after inspection
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("Reading body failed: %v", err)
+	}
+	if string(data) != body {
+		t.Errorf("Expected the full body to remain readable, got %q", string(data))
+	}
+
+	context := pm.createHTTPContext(postRequest(body, "application/json"))
+	if !context.BodyTruncated || len(context.Body) != 32 || context.BodySize != int64(len(body)) {
+		t.Errorf("Expected 32 of %d bytes inspected and truncated, got %d of %d (truncated %v)",
+			len(body), len(context.Body), context.BodySize, context.BodyTruncated)
+	}
+}
+
+func TestProcessHTTPContext_BodyCriteria(t *testing.T) {
+	pm := bodyRulePM(
+		Criterion{Name: "body_size", Option: "equals", Value: "16"},
+		Criterion{Name: "body_json", Option: "region", Extract: "equals", Value: "EU"},
+	)
+
+	result, err := pm.ProcessHTTPContext(&HTTPContext{
+		Method:  "POST",
+		Path:    "/api/orders",
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    `{"region": "EU"}`,
+	})
+	if err != nil {
+		t.Fatalf("ProcessHTTPContext failed: %v", err)
+	}
+	if len(result.MatchedRules) != 1 {
+		t.Errorf("Expected the body rule to match, got %v", result.MatchedRules)
+	}
+}
@@ -0,0 +1,226 @@
+package propertymanager
+
+import (
+	"strconv"
+)
+
+// RuleBuilder builds a rule fluently, for tests and integrations that construct rule
+// trees in code rather than embedding XML:
+//
+//	NewRule("api").PathStartsWith("/api").Behavior("esi").Child(NewRule("v2").PathRegex("^/api/v2/"))
+type RuleBuilder struct {
+	rule Rule
+}
+
+// NewRule starts building a rule named name
+func NewRule(name string) *RuleBuilder {
+	return &RuleBuilder{rule: Rule{Name: name}}
+}
+
+// EditRule starts building from a copy of an existing rule, such as one parsed from XML
+func EditRule(rule Rule) *RuleBuilder {
+	return &RuleBuilder{rule: copyRule(rule)}
+}
+
+// Comment sets the rule's comment
+func (b *RuleBuilder) Comment(comment string) *RuleBuilder {
+	b.rule.Comment = comment
+	return b
+}
+
+// Criterion adds a criterion; option is the match operator of most criteria and the
+// header, cookie or variable name of name-valued ones, whose operator is set by Extract
+func (b *RuleBuilder) Criterion(name, option, value string) *RuleBuilder {
+	b.rule.Criteria = append(b.rule.Criteria, Criterion{Name: name, Option: option, Value: value})
+	return b
+}
+
+// Extract sets the match operator of the last criterion added
+func (b *RuleBuilder) Extract(operator string) *RuleBuilder {
+	if n := len(b.rule.Criteria); n > 0 {
+		b.rule.Criteria[n-1].Extract = operator
+	}
+	return b
+}
+
+// CaseSensitive makes the last criterion added match case-sensitively
+func (b *RuleBuilder) CaseSensitive() *RuleBuilder {
+	if n := len(b.rule.Criteria); n > 0 {
+		b.rule.Criteria[n-1].Case = true
+	}
+	return b
+}
+
+// PathEquals matches requests for exactly path
+func (b *RuleBuilder) PathEquals(path string) *RuleBuilder {
+	return b.Criterion("path", "equals", path)
+}
+
+// PathStartsWith matches requests whose path starts with prefix
+func (b *RuleBuilder) PathStartsWith(prefix string) *RuleBuilder {
+	return b.Criterion("path", "starts_with", prefix)
+}
+
+// PathRegex matches requests whose path matches pattern
+func (b *RuleBuilder) PathRegex(pattern string) *RuleBuilder {
+	return b.Criterion("path", "regex", pattern)
+}
+
+// Method matches requests with the HTTP method
+func (b *RuleBuilder) Method(method string) *RuleBuilder {
+	return b.Criterion("method", "equals", method)
+}
+
+// Host matches requests for host
+func (b *RuleBuilder) Host(host string) *RuleBuilder {
+	return b.Criterion("host", "equals", host)
+}
+
+// Header matches requests whose header name compares to value with operator
+func (b *RuleBuilder) Header(name, operator, value string) *RuleBuilder {
+	return b.Criterion("header", name, value).Extract(operator)
+}
+
+// Cookie matches requests whose cookie name compares to value with operator
+func (b *RuleBuilder) Cookie(name, operator, value string) *RuleBuilder {
+	return b.Criterion("cookie", name, value).Extract(operator)
+}
+
+// QueryParam matches requests whose query parameter name compares to value with operator
+func (b *RuleBuilder) QueryParam(name, operator, value string) *RuleBuilder {
+	return b.Criterion("query_param", name, value).Extract(operator)
+}
+
+// Variable matches requests whose variable name compares to value with operator
+func (b *RuleBuilder) Variable(name, operator, value string) *RuleBuilder {
+	return b.Criterion("variable", name, value).Extract(operator)
+}
+
+// Experiment matches requests assigned a variant of the experiment name that compares
+// to variant with operator
+func (b *RuleBuilder) Experiment(name, operator, variant string) *RuleBuilder {
+	return b.Criterion("experiment", name, variant).Extract(operator)
+}
+
+// ClientIPIn matches requests from the comma-separated IP addresses and CIDR ranges
+func (b *RuleBuilder) ClientIPIn(ranges string) *RuleBuilder {
+	return b.Criterion("client_ip", "in", ranges)
+}
+
+// Behavior adds a behavior with options given as name, value pairs; a trailing name
+// without a value is ignored
+func (b *RuleBuilder) Behavior(name string, options ...string) *RuleBuilder {
+	behavior := Behavior{Name: name}
+	for i := 0; i+1 < len(options); i += 2 {
+		behavior.Option = append(behavior.Option, BehaviorOption{Name: options[i], Value: options[i+1]})
+	}
+	b.rule.Behaviors = append(b.rule.Behaviors, behavior)
+	return b
+}
+
+// SetRequestHeader adds a set_request_header behavior
+func (b *RuleBuilder) SetRequestHeader(name, value string) *RuleBuilder {
+	return b.Behavior("set_request_header", "header_name", name, "value", value)
+}
+
+// SetResponseHeader adds a set_response_header behavior
+func (b *RuleBuilder) SetResponseHeader(name, value string) *RuleBuilder {
+	return b.Behavior("set_response_header", "header_name", name, "value", value)
+}
+
+// SetVariable adds a set_variable behavior
+func (b *RuleBuilder) SetVariable(name, value string) *RuleBuilder {
+	return b.Behavior("set_variable", "variable_name", name, "value", value)
+}
+
+// Redirect adds a redirect behavior to destination with the status code
+func (b *RuleBuilder) Redirect(destination string, status int) *RuleBuilder {
+	return b.Behavior("redirect", "destination", destination, "status_code", strconv.Itoa(status))
+}
+
+// Child adds child rules, evaluated when this rule matches
+func (b *RuleBuilder) Child(children ...*RuleBuilder) *RuleBuilder {
+	for _, child := range children {
+		b.rule.Children = append(b.rule.Children, child.Build())
+	}
+	return b
+}
+
+// Build returns the rule; the builder can keep being used without affecting it
+func (b *RuleBuilder) Build() Rule {
+	return copyRule(b.rule)
+}
+
+// copyRule returns a deep copy of rule
+func copyRule(rule Rule) Rule {
+	copied := rule
+	copied.Criteria = append([]Criterion(nil), rule.Criteria...)
+	copied.Behaviors = nil
+	for _, behavior := range rule.Behaviors {
+		behavior.Option = append([]BehaviorOption(nil), behavior.Option...)
+		copied.Behaviors = append(copied.Behaviors, behavior)
+	}
+	copied.Children = nil
+	for _, child := range rule.Children {
+		copied.Children = append(copied.Children, copyRule(child))
+	}
+	return copied
+}
+
+// PropertyBuilder builds a property from rule builders
+type PropertyBuilder struct {
+	property Property
+}
+
+// NewProperty starts building a property named name, at version 1
+func NewProperty(name string) *PropertyBuilder {
+	return &PropertyBuilder{property: Property{Name: name, Version: 1}}
+}
+
+// ParseProperty starts building from a property in XML, with its includes resolved
+// relative to the working directory as LoadProperty does
+func ParseProperty(xmlData []byte) (*PropertyBuilder, error) {
+	property, err := parseProperty(xmlData, "")
+	if err != nil {
+		return nil, err
+	}
+	return &PropertyBuilder{property: *property}, nil
+}
+
+// Version sets the property version
+func (p *PropertyBuilder) Version(version int) *PropertyBuilder {
+	p.property.Version = version
+	return p
+}
+
+// Rule adds top-level rules
+func (p *PropertyBuilder) Rule(rules ...*RuleBuilder) *PropertyBuilder {
+	for _, rule := range rules {
+		p.property.Rules.Rule = append(p.property.Rules.Rule, rule.Build())
+	}
+	return p
+}
+
+// Variable adds a property variable with its initial value
+func (p *PropertyBuilder) Variable(name, value string) *PropertyBuilder {
+	p.property.Variables.Variable = append(p.property.Variables.Variable, Variable{Name: name, Value: value})
+	return p
+}
+
+// Build returns the property, ready for PropertyManager.UseProperty
+func (p *PropertyBuilder) Build() *Property {
+	property := p.property
+	property.Rules.Rule = nil
+	for _, rule := range p.property.Rules.Rule {
+		property.Rules.Rule = append(property.Rules.Rule, copyRule(rule))
+	}
+	property.Behaviors.Behavior = append([]Behavior(nil), p.property.Behaviors.Behavior...)
+	property.Variables.Variable = append([]Variable(nil), p.property.Variables.Variable...)
+	return &property
+}
+
+// Export serializes the property as ExportProperty does, in the xml or json format
+func (p *PropertyBuilder) Export(format string) ([]byte, error) {
+	pm := &PropertyManager{Property: p.Build()}
+	return pm.ExportProperty(format)
+}
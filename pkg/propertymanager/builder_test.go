@@ -0,0 +1,131 @@
+package propertymanager
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRuleBuilder_MatchesXML(t *testing.T) {
+	xmlData := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<property name="shop" version="2">
+	<rules>
+		<rule name="api" comment="API traffic">
+			<criteria name="path" option="starts_with" value="/api"/>
+			<criteria name="header" option="Accept" value="application/json" extract="contains"/>
+			<behaviors>
+				<behavior name="esi"/>
+				<behavior name="set_response_header">
+					<option name="header_name" value="X-API"/>
+					<option name="value" value="true"/>
+				</behavior>
+			</behaviors>
+			<children>
+				<rule name="v1">
+					<criteria name="path" option="regex" value="^/api/v1/" case="true"/>
+					<behaviors>
+						<behavior name="redirect">
+							<option name="destination" value="/api/v2/"/>
+							<option name="status_code" value="301"/>
+						</behavior>
+					</behaviors>
+				</rule>
+			</children>
+		</rule>
+	</rules>
+	<variables>
+		<variable name="PMUSER_TIER" value="gold"/>
+	</variables>
+</property>`)
+
+	built := NewProperty("shop").Version(2).
+		Rule(NewRule("api").Comment("API traffic").
+			PathStartsWith("/api").
+			Header("Accept", "contains", "application/json").
+			Behavior("esi").
+			SetResponseHeader("X-API", "true").
+			Child(NewRule("v1").PathRegex("^/api/v1/").CaseSensitive().Redirect("/api/v2/", 301))).
+		Variable("PMUSER_TIER", "gold")
+
+	fromBuilder, err := built.Export(ExportXML)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	pm := NewPropertyManager(false)
+	if err := pm.LoadProperty(xmlData); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+	fromXML, err := pm.ExportProperty(ExportXML)
+	if err != nil {
+		t.Fatalf("ExportProperty failed: %v", err)
+	}
+
+	if string(fromBuilder) != string(fromXML) {
+		t.Errorf("Expected the built property to export like its XML, got:\n%s\nexpected:\n%s", fromBuilder, fromXML)
+	}
+}
+
+func TestPropertyManager_UseProperty(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.UseProperty(NewProperty("built").
+		Rule(NewRule("mobile").
+			Header("User-Agent", "regex", "iPhone|Android").
+			SetVariable("PMUSER_DEVICE", "mobile").
+			Child(NewRule("tagged").Variable("PMUSER_DEVICE", "equals", "mobile").SetResponseHeader("X-Device", "mobile"))).
+		Build())
+
+	if pm.Rules["tagged"] == nil {
+		t.Error("Expected the rule map to include child rules")
+	}
+	if pm.compiled.patterns["iphone|android"] == nil {
+		t.Error("Expected the regex criteria to be compiled")
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)")
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if result.ModifiedHeaders["X-Device"] != "mobile" {
+		t.Errorf("Expected header X-Device=mobile, got %v (matched %v)", result.ModifiedHeaders, result.MatchedRules)
+	}
+}
+
+func TestParseProperty_Edit(t *testing.T) {
+	builder, err := ParseProperty([]byte(`<property name="shop" version="4">
+	<rules>
+		<rule name="home">
+			<criteria name="path" option="equals" value="/"/>
+		</rule>
+	</rules>
+</property>`))
+	if err != nil {
+		t.Fatalf("ParseProperty failed: %v", err)
+	}
+
+	original := builder.Build()
+	edited := EditRule(original.Rules.Rule[0]).SetResponseHeader("X-Home", "1").Build()
+	builder.Rule(NewRule("admin").PathStartsWith("/admin").ClientIPIn("10.0.0.0/8"))
+
+	if len(original.Rules.Rule[0].Behaviors) != 0 {
+		t.Errorf("Expected EditRule to leave the original rule untouched, got %v", original.Rules.Rule[0].Behaviors)
+	}
+	if len(edited.Behaviors) != 1 || len(edited.Criteria) != 1 {
+		t.Errorf("Expected the edited rule to keep its criteria and gain a behavior, got %+v", edited)
+	}
+
+	property := builder.Build()
+	if property.Version != 4 || len(property.Rules.Rule) != 2 || property.Rules.Rule[1].Criteria[1].Option != "in" {
+		t.Errorf("Expected the parsed property with the added rule, got %+v", property)
+	}
+	if len(original.Rules.Rule) != 1 {
+		t.Errorf("Expected earlier builds to be unaffected, got %d rules", len(original.Rules.Rule))
+	}
+}
+
+func TestParseProperty_Invalid(t *testing.T) {
+	if _, err := ParseProperty([]byte(`<property`)); err == nil {
+		t.Error("Expected an error for malformed XML")
+	}
+}
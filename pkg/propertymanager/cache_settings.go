@@ -0,0 +1,128 @@
+package propertymanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// noStoreBehaviors are the cache behavior values, lowercased with dashes as underscores,
+// that keep a response out of the edge cache
+var noStoreBehaviors = map[string]bool{"no_store": true, "bypass_cache": true}
+
+// NoStore reports whether the cache settings keep the response out of the edge cache:
+// cache_bypass ran, or a cache behavior set no_store to true or its behavior (or
+// cacheability) to no_store or bypass_cache. Integrated mode then bypasses the ESI
+// fragment cache for the request too.
+func (r *RuleResult) NoStore() bool {
+	settings := r.CacheSettings
+	if settingBool(settings["bypass"]) || settingBool(settings["no_store"]) {
+		return true
+	}
+	for _, name := range []string{"behavior", "cacheability"} {
+		value := strings.ToLower(strings.ReplaceAll(settingString(settings[name]), "-", "_"))
+		if noStoreBehaviors[value] {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentEncoding returns the encoding the compression settings give a response body of
+// size bytes for a client sending acceptEncoding: br when brotli is set and accepted,
+// gzip when gzip (or gzip_response) is set and accepted, and "" when the body is below
+// min_size, compression is disabled or the client accepts neither.
+func (r *RuleResult) ContentEncoding(acceptEncoding string, size int) string {
+	settings := r.CompressionSettings
+	if len(settings) == 0 {
+		return ""
+	}
+	if enabled, ok := settings["enabled"]; ok && !settingBool(enabled) {
+		return ""
+	}
+	if minSize, err := strconv.Atoi(settingString(settings["min_size"])); err == nil && size < minSize {
+		return ""
+	}
+
+	accepted := acceptedEncodings(acceptEncoding)
+	if settingBool(settings["brotli"]) && accepted["br"] {
+		return "br"
+	}
+	if settingBool(settings["gzip"]) && accepted["gzip"] {
+		return "gzip"
+	}
+	return ""
+}
+
+// ApplyCompression sets the Content-Encoding of the response phase from the compression
+// settings of result, so the processed body is encoded only for clients that accept it
+// and only once ESI processing has produced it
+func ApplyCompression(result, responseResult *RuleResult, acceptEncoding string, size int) {
+	for key, value := range result.CompressionSettings {
+		responseResult.CompressionSettings[key] = value
+	}
+	delete(responseResult.ModifiedHeaders, "Content-Encoding")
+	if len(result.CompressionSettings) == 0 {
+		return
+	}
+
+	responseResult.ModifiedHeaders["Vary"] = "Accept-Encoding"
+	if encoding := result.ContentEncoding(acceptEncoding, size); encoding != "" {
+		responseResult.ModifiedHeaders["Content-Encoding"] = encoding
+	}
+}
+
+// acceptedEncodings returns the encodings of an Accept-Encoding header with a non-zero
+// quality; * accepts gzip and br
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				quality, _ = strconv.ParseFloat(q, 64)
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		if name == "*" {
+			accepted["gzip"] = true
+			accepted["br"] = true
+			continue
+		}
+		accepted[name] = true
+	}
+	return accepted
+}
+
+// settingBool reads a cache or compression setting, which is a bool from the JSON API
+// and a string from XML options, as a bool
+func settingBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		enabled, _ := strconv.ParseBool(v)
+		return enabled
+	}
+	return false
+}
+
+// settingString reads a cache or compression setting as a string
+func settingString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,105 @@
+package propertymanager
+
+import (
+	"testing"
+)
+
+func TestRuleResult_NoStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     bool
+	}{
+		{name: "no settings", want: false},
+		{name: "ttl only", settings: map[string]interface{}{"ttl": "3600"}, want: false},
+		{name: "bypass", settings: map[string]interface{}{"bypass": true}, want: true},
+		{name: "no_store option", settings: map[string]interface{}{"no_store": "true"}, want: true},
+		{name: "behavior", settings: map[string]interface{}{"behavior": "NO_STORE"}, want: true},
+		{name: "cacheability", settings: map[string]interface{}{"cacheability": "no-store"}, want: true},
+		{name: "bypass_cache", settings: map[string]interface{}{"behavior": "bypass-cache"}, want: true},
+		{name: "max age", settings: map[string]interface{}{"behavior": "max_age"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &RuleResult{CacheSettings: tt.settings}
+			if got := result.NoStore(); got != tt.want {
+				t.Errorf("NoStore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessHTTPContext_CacheBehaviorXMLOptions(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{{
+		Name: "private",
+		Behaviors: []Behavior{
+			{Name: "cache", Option: []BehaviorOption{{Name: "behavior", Value: "no_store"}}},
+			{Name: "compress", Option: []BehaviorOption{{Name: "gzip", Value: "true"}}},
+		},
+	}}}}
+
+	result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/"})
+	if err != nil {
+		t.Fatalf("ProcessHTTPContext failed: %v", err)
+	}
+	if !result.NoStore() {
+		t.Errorf("Expected no_store from XML cache options, got %v", result.CacheSettings)
+	}
+	if result.CompressionSettings["gzip"] != "true" {
+		t.Errorf("Expected gzip from XML compress options, got %v", result.CompressionSettings)
+	}
+}
+
+func TestRuleResult_ContentEncoding(t *testing.T) {
+	tests := []struct {
+		name           string
+		settings       map[string]interface{}
+		acceptEncoding string
+		size           int
+		want           string
+	}{
+		{name: "no settings", acceptEncoding: "gzip", size: 2048, want: ""},
+		{name: "gzip", settings: map[string]interface{}{"gzip": true}, acceptEncoding: "gzip, deflate", size: 2048, want: "gzip"},
+		{name: "brotli preferred", settings: map[string]interface{}{"gzip": true, "brotli": true}, acceptEncoding: "gzip, br", size: 2048, want: "br"},
+		{name: "brotli not accepted", settings: map[string]interface{}{"gzip": "true", "brotli": "true"}, acceptEncoding: "gzip", size: 2048, want: "gzip"},
+		{name: "not accepted", settings: map[string]interface{}{"gzip": true}, acceptEncoding: "identity", size: 2048, want: ""},
+		{name: "zero quality", settings: map[string]interface{}{"gzip": true}, acceptEncoding: "gzip;q=0", size: 2048, want: ""},
+		{name: "wildcard", settings: map[string]interface{}{"gzip": true}, acceptEncoding: "*", size: 2048, want: "gzip"},
+		{name: "below min size", settings: map[string]interface{}{"gzip": true, "min_size": float64(1024)}, acceptEncoding: "gzip", size: 100, want: ""},
+		{name: "disabled", settings: map[string]interface{}{"gzip": true, "enabled": "false"}, acceptEncoding: "gzip", size: 2048, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &RuleResult{CompressionSettings: tt.settings}
+			if got := result.ContentEncoding(tt.acceptEncoding, tt.size); got != tt.want {
+				t.Errorf("ContentEncoding(%q, %d) = %q, want %q", tt.acceptEncoding, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyCompression(t *testing.T) {
+	result := &RuleResult{CompressionSettings: map[string]interface{}{"gzip": true}}
+
+	responseResult := &RuleResult{
+		ModifiedHeaders:     map[string]string{"Content-Encoding": "gzip"},
+		CompressionSettings: make(map[string]interface{}),
+	}
+	ApplyCompression(result, responseResult, "br", 2048)
+	if _, ok := responseResult.ModifiedHeaders["Content-Encoding"]; ok {
+		t.Errorf("Expected no Content-Encoding for a client without gzip, got %v", responseResult.ModifiedHeaders)
+	}
+	if responseResult.ModifiedHeaders["Vary"] != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %v", responseResult.ModifiedHeaders)
+	}
+
+	responseResult = &RuleResult{ModifiedHeaders: map[string]string{}, CompressionSettings: make(map[string]interface{})}
+	ApplyCompression(result, responseResult, "gzip", 2048)
+	if responseResult.ModifiedHeaders["Content-Encoding"] != "gzip" {
+		t.Errorf("Expected Content-Encoding gzip, got %v", responseResult.ModifiedHeaders)
+	}
+	if responseResult.CompressionSettings["gzip"] != true {
+		t.Errorf("Expected compression settings copied, got %v", responseResult.CompressionSettings)
+	}
+}
@@ -0,0 +1,126 @@
+package propertymanager
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrueClientIPHeader is the request header the true_client_ip behavior forwards the
+// client IP in unless its header_name option says otherwise
+const TrueClientIPHeader = "True-Client-IP"
+
+// effectiveClientIP returns the IP of the client that sent req. The connecting peer is
+// the client unless it is a trusted proxy, in which case X-Forwarded-For is walked from
+// the nearest hop back, skipping trusted proxies, to the first address that is not one.
+func (pm *PropertyManager) effectiveClientIP(req *http.Request) string {
+	hops := []string{}
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	hops = append(hops, stripPort(req.RemoteAddr))
+
+	trusted := parseIPRanges(pm.TrustedProxies)
+	for i := len(hops) - 1; i > 0; i-- {
+		if !ipInRanges(hops[i], trusted) {
+			return hops[i]
+		}
+	}
+	return hops[0]
+}
+
+// stripPort returns the host of a host:port address, or the address unchanged when it
+// has no port
+func stripPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// parseIPRanges parses IP addresses and CIDR ranges, skipping entries that are neither
+func parseIPRanges(entries []string) []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			ranges = append(ranges, network)
+		}
+	}
+	return ranges
+}
+
+// ipInRanges reports whether ip lies in one of ranges
+func ipInRanges(ip string, ranges []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range ranges {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// executeTrueClientIP sets the True-Client-IP header of the forward request to the
+// effective client IP. Options: header_name (True-Client-IP by default) and
+// allow_client_header ("true" keeps a header value the client sent itself, as Akamai
+// does when clients are allowed to set True-Client-IP).
+func (pm *PropertyManager) executeTrueClientIP(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	headerName := pm.getBehaviorOption(behavior, "header_name")
+	if headerName == "" {
+		headerName = TrueClientIPHeader
+	}
+	headerName = http.CanonicalHeaderKey(headerName)
+
+	trueClientIP := context.ClientIP
+	if pm.getBehaviorOption(behavior, "allow_client_header") == "true" {
+		if sent := strings.TrimSpace(context.Headers[headerName]); net.ParseIP(sent) != nil {
+			trueClientIP = sent
+		}
+	}
+	if trueClientIP == "" {
+		return fmt.Errorf("true client ip: the client IP is unknown")
+	}
+
+	context.TrueClientIP = trueClientIP
+	context.Headers[headerName] = trueClientIP
+	if context.Request != nil {
+		context.Request.Header.Set(headerName, trueClientIP)
+	}
+	if pm.Debug {
+		fmt.Printf("🌐 True client IP: %s = %s\n", headerName, trueClientIP)
+	}
+	return nil
+}
+
+// trueClientIP returns the True-Client-IP, which is the effective client IP unless a
+// true_client_ip behavior accepted one sent by the client
+func (context *HTTPContext) trueClientIP() string {
+	if context.TrueClientIP == "" {
+		return context.ClientIP
+	}
+	return context.TrueClientIP
+}
+
+// evaluateTrueClientIPCriterion evaluates True-Client-IP criteria
+func (pm *PropertyManager) evaluateTrueClientIPCriterion(criterion *Criterion, context *HTTPContext) bool {
+	return pm.evaluateIPCriterion(criterion, context.trueClientIP())
+}
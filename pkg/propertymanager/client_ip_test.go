@@ -0,0 +1,121 @@
+package propertymanager
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCreateHTTPContext_EffectiveClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   []string
+		expected       string
+	}{
+		{name: "port stripped", remoteAddr: "203.0.113.7:4711", expected: "203.0.113.7"},
+		{name: "forwarded for ignored without trusted proxies", remoteAddr: "203.0.113.7:4711", forwardedFor: []string{"198.51.100.1"}, expected: "203.0.113.7"},
+		{name: "untrusted peer", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "203.0.113.7:4711", forwardedFor: []string{"198.51.100.1"}, expected: "203.0.113.7"},
+		{name: "trusted peer", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.1.2.3:4711", forwardedFor: []string{"198.51.100.1"}, expected: "198.51.100.1"},
+		{name: "trusted hops skipped", trustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}, remoteAddr: "10.1.2.3:4711", forwardedFor: []string{"6.6.6.6, 198.51.100.1, 192.0.2.1", "10.9.9.9"}, expected: "198.51.100.1"},
+		{name: "all hops trusted", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.1.2.3:4711", forwardedFor: []string{"10.4.4.4"}, expected: "10.4.4.4"},
+		{name: "ipv6 peer", trustedProxies: []string{"::1"}, remoteAddr: "[::1]:4711", forwardedFor: []string{"2001:db8::5"}, expected: "2001:db8::5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.TrustedProxies = tt.trustedProxies
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			if context := pm.createHTTPContext(req); context.ClientIP != tt.expected {
+				t.Errorf("Expected client IP %s, got %s", tt.expected, context.ClientIP)
+			}
+		})
+	}
+}
+
+func TestProcessRequest_TrueClientIP(t *testing.T) {
+	xmlData := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<property name="test-property" version="1">
+	<rules>
+		<rule name="default">
+			<behaviors>
+				<behavior name="true_client_ip"/>
+			</behaviors>
+		</rule>
+		<rule name="partner">
+			<criteria name="true_client_ip" option="starts_with" value="198.51.100."/>
+			<behaviors>
+				<behavior name="set_response_header">
+					<option name="header_name" value="X-Client"/>
+					<option name="value" value="partner"/>
+				</behavior>
+			</behaviors>
+		</rule>
+	</rules>
+</property>`)
+
+	pm := NewPropertyManager(false)
+	pm.TrustedProxies = []string{"10.0.0.0/8"}
+	if err := pm.LoadProperty(xmlData); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:4711"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	req.Header.Set("True-Client-IP", "6.6.6.6")
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	if got := req.Header.Get("True-Client-IP"); got != "198.51.100.9" {
+		t.Errorf("Expected the forward request to carry True-Client-IP 198.51.100.9, got %s", got)
+	}
+	if len(result.MatchedRules) != 2 {
+		t.Errorf("Expected 2 matched rules, got %v", result.MatchedRules)
+	}
+	if result.ModifiedHeaders["X-Client"] != "partner" {
+		t.Errorf("Expected header X-Client=partner, got '%s'", result.ModifiedHeaders["X-Client"])
+	}
+}
+
+func TestProcessRequest_TrueClientIPClientHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		sent     string
+		expected string
+	}{
+		{name: "client header kept", sent: "198.51.100.20", expected: "198.51.100.20"},
+		{name: "invalid client header replaced", sent: "not-an-ip", expected: "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				{Name: "tcip", Behaviors: []Behavior{{Name: "true_client_ip", Option: []BehaviorOption{
+					{Name: "header_name", Value: "x-real-client"},
+					{Name: "allow_client_header", Value: "true"},
+				}}}},
+			}}}
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "203.0.113.7:4711"
+			req.Header.Set("X-Real-Client", tt.sent)
+			if _, err := pm.ProcessRequest(req); err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if got := req.Header.Get("X-Real-Client"); got != tt.expected {
+				t.Errorf("Expected X-Real-Client %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
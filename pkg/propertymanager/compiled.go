@@ -0,0 +1,83 @@
+package propertymanager
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RuleTrace reports the work and time rule evaluation took for a request
+type RuleTrace struct {
+	RulesEvaluated    int
+	CriteriaEvaluated int
+	EvaluationTime    time.Duration
+}
+
+// compiledCriteria holds the regular expressions and IP ranges of a rule tree, compiled
+// once when the rules are loaded rather than on every request. Patterns that failed to
+// compile are kept as nil so they are not retried.
+type compiledCriteria struct {
+	patterns map[string]*regexp.Regexp
+	networks map[string][]*net.IPNet
+}
+
+// ipCriteria are the criteria whose in and not_in options take IP addresses and CIDR ranges
+var ipCriteria = map[string]bool{"client_ip": true, "true_client_ip": true}
+
+// compileCriteria compiles the regex and IP range criteria of rules and their children
+func (pm *PropertyManager) compileCriteria(rules []Rule) *compiledCriteria {
+	compiled := &compiledCriteria{
+		patterns: make(map[string]*regexp.Regexp),
+		networks: make(map[string][]*net.IPNet),
+	}
+	compiled.add(rules, pm.Debug)
+	return compiled
+}
+
+func (c *compiledCriteria) add(rules []Rule, debug bool) {
+	for _, rule := range rules {
+		for _, criterion := range rule.Criteria {
+			if criterion.Option == "regex" || criterion.Extract == "regex" {
+				// Case-insensitive criteria match a lowercased pattern
+				for _, pattern := range []string{criterion.Value, strings.ToLower(criterion.Value)} {
+					if _, ok := c.patterns[pattern]; ok {
+						continue
+					}
+					re, err := regexp.Compile(pattern)
+					if err != nil && debug {
+						fmt.Printf("⚠️  Invalid regex in rule %s: %v\n", rule.Name, err)
+					}
+					c.patterns[pattern] = re
+				}
+			}
+			if ipCriteria[criterion.Name] && (criterion.Option == "in" || criterion.Option == "not_in") {
+				c.networks[criterion.Value] = parseIPRanges(strings.Split(criterion.Value, ","))
+			}
+		}
+		c.add(rule.Children, debug)
+	}
+}
+
+// matchRegex reports whether value matches pattern, using the compiled pattern when the
+// rules were loaded with it and compiling it otherwise; invalid patterns never match
+func (pm *PropertyManager) matchRegex(pattern, value string) bool {
+	if pm.compiled != nil {
+		if re, ok := pm.compiled.patterns[pattern]; ok {
+			return re != nil && re.MatchString(value)
+		}
+	}
+	matched, _ := regexp.MatchString(pattern, value)
+	return matched
+}
+
+// ipRanges returns the IP addresses and CIDR ranges of a comma-separated list
+func (pm *PropertyManager) ipRanges(value string) []*net.IPNet {
+	if pm.compiled != nil {
+		if networks, ok := pm.compiled.networks[value]; ok {
+			return networks
+		}
+	}
+	return parseIPRanges(strings.Split(value, ","))
+}
@@ -0,0 +1,160 @@
+package propertymanager
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// regexRules returns n rules each matching one path and user agent by regex, plus a
+// client IP range rule
+func regexRules(n int) []Rule {
+	rules := make([]Rule, 0, n+1)
+	for i := 0; i < n; i++ {
+		rules = append(rules, Rule{
+			Name: fmt.Sprintf("section-%d", i),
+			Criteria: []Criterion{
+				{Name: "path", Option: "regex", Value: fmt.Sprintf(`^/section-%d/[a-z]+/[0-9]+$`, i)},
+				{Name: "user_agent", Option: "regex", Value: `Mozilla/5\.0 .*(Chrome|Firefox)/[0-9]+`},
+			},
+			Behaviors: []Behavior{{Name: "set_variable", Option: []BehaviorOption{
+				{Name: "variable_name", Value: "PMUSER_SECTION"},
+				{Name: "value", Value: fmt.Sprint(i)},
+			}}},
+		})
+	}
+	return append(rules, Rule{
+		Name:     "office",
+		Criteria: []Criterion{{Name: "client_ip", Option: "in", Value: "10.20.0.0/16, 192.0.2.1"}},
+	})
+}
+
+func TestLoadProperty_CompilesCriteria(t *testing.T) {
+	xmlData := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<property name="test-property" version="1">
+	<rules>
+		<rule name="api">
+			<criteria name="path" option="regex" value="^/api/v[0-9]+/"/>
+			<children>
+				<rule name="bot">
+					<criteria name="header" option="User-Agent" extract="regex" value="Bot"/>
+				</rule>
+			</children>
+		</rule>
+		<rule name="office">
+			<criteria name="client_ip" option="in" value="10.20.0.0/16"/>
+		</rule>
+		<rule name="broken">
+			<criteria name="path" option="regex" value="("/>
+		</rule>
+	</rules>
+</property>`)
+
+	pm := NewPropertyManager(false)
+	if err := pm.LoadProperty(xmlData); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+
+	for _, pattern := range []string{"^/api/v[0-9]+/", "Bot", "bot"} {
+		if pm.compiled.patterns[pattern] == nil {
+			t.Errorf("Expected pattern %q to be compiled", pattern)
+		}
+	}
+	if re, ok := pm.compiled.patterns["("]; !ok || re != nil {
+		t.Errorf("Expected the invalid pattern to be recorded as nil, got %v (present %v)", re, ok)
+	}
+	if len(pm.compiled.networks["10.20.0.0/16"]) != 1 {
+		t.Errorf("Expected the CIDR range to be compiled, got %v", pm.compiled.networks)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v2/users", nil)
+	req.Header.Set("User-Agent", "SearchBot/1.0")
+	req.RemoteAddr = "10.20.200.7:4711"
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	expected := []string{"api", "bot", "office"}
+	if fmt.Sprint(result.MatchedRules) != fmt.Sprint(expected) {
+		t.Errorf("Expected matched rules %v, got %v", expected, result.MatchedRules)
+	}
+	if result.Trace.RulesEvaluated != 4 || result.Trace.CriteriaEvaluated != 4 {
+		t.Errorf("Expected 4 rules and 4 criteria evaluated, got %+v", result.Trace)
+	}
+	if result.Trace.EvaluationTime <= 0 {
+		t.Errorf("Expected an evaluation time, got %v", result.Trace.EvaluationTime)
+	}
+}
+
+func TestProcessRequest_UncompiledCriteria(t *testing.T) {
+	// Rules assigned directly rather than loaded are compiled on use
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: regexRules(3)}}
+
+	req, _ := http.NewRequest("GET", "/section-2/news/42", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/126")
+	req.RemoteAddr = "10.20.1.1:4711"
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	expected := []string{"section-2", "office"}
+	if fmt.Sprint(result.MatchedRules) != fmt.Sprint(expected) {
+		t.Errorf("Expected matched rules %v, got %v", expected, result.MatchedRules)
+	}
+}
+
+func TestIsIPInCIDR(t *testing.T) {
+	pm := NewPropertyManager(false)
+	tests := []struct {
+		ip       string
+		cidr     string
+		expected bool
+	}{
+		{"10.20.200.7", "10.20.0.0/16", true},
+		{"10.21.0.1", "10.20.0.0/16", false},
+		{"192.168.1.5", "192.168.1.0/24", true},
+		{"192.0.2.1", "192.0.2.1", true},
+		{"192.0.2.10", "192.0.2.1", false},
+		{"2001:db8::1", "2001:db8::/32", true},
+		{"198.51.100.7", "10.0.0.0/8, 198.51.100.0/24", true},
+		{"not-an-ip", "10.0.0.0/8", false},
+	}
+
+	for _, tt := range tests {
+		if got := pm.isIPInCIDR(tt.ip, tt.cidr); got != tt.expected {
+			t.Errorf("isIPInCIDR(%q, %q) = %v, expected %v", tt.ip, tt.cidr, got, tt.expected)
+		}
+	}
+}
+
+func benchmarkProcessRequest(b *testing.B, compile bool) {
+	pm := NewPropertyManager(false)
+	rules := regexRules(300)
+	pm.Property = &Property{Rules: Rules{Rule: rules}}
+	if compile {
+		pm.compiled = pm.compileCriteria(rules)
+	}
+
+	req, _ := http.NewRequest("GET", "/section-299/news/42", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) Chrome/125")
+	req.RemoteAddr = "10.20.1.1:4711"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := pm.ProcessRequest(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessRequest_CompiledCriteria(b *testing.B) {
+	benchmarkProcessRequest(b, true)
+}
+
+func BenchmarkProcessRequest_UncompiledCriteria(b *testing.B) {
+	benchmarkProcessRequest(b, false)
+}
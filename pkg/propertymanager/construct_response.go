@@ -0,0 +1,74 @@
+package propertymanager
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ConstructedResponse is a response answered at the edge by the construct_response
+// behavior, without contacting the origin
+type ConstructedResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    string
+}
+
+// executeConstructResponse answers the request with a constructed response, like PAPI's
+// constructResponse. Options: status_code (200 by default), body or template_file (read
+// relative to TemplateDir) with variables such as $(HTTP_PATH) expanded, content_type
+// (text/html by default) and repeatable header options of the form "Name: value".
+func (pm *PropertyManager) executeConstructResponse(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	status := http.StatusOK
+	if code := pm.getBehaviorOption(behavior, "status_code"); code != "" {
+		parsed, err := strconv.Atoi(code)
+		if err != nil || parsed < 100 || parsed > 599 {
+			return fmt.Errorf("construct response: invalid status_code %q", code)
+		}
+		status = parsed
+	}
+
+	body := pm.getBehaviorOption(behavior, "body")
+	if templateFile := pm.getBehaviorOption(behavior, "template_file"); templateFile != "" {
+		if body != "" {
+			return fmt.Errorf("construct response: body and template_file are exclusive")
+		}
+		if !filepath.IsAbs(templateFile) {
+			templateFile = filepath.Join(pm.TemplateDir, templateFile)
+		}
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return fmt.Errorf("construct response: reading template: %w", err)
+		}
+		body = string(data)
+	}
+
+	contentType := pm.getBehaviorOption(behavior, "content_type")
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	headers := map[string]string{"Content-Type": contentType}
+	for _, option := range behavior.Option {
+		if option.Name != "header" {
+			continue
+		}
+		name, value, ok := strings.Cut(option.Value, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("construct response: header %q is not of the form \"Name: value\"", option.Value)
+		}
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = pm.expandVariables(strings.TrimSpace(value), context)
+	}
+
+	result.ConstructedResponse = &ConstructedResponse{
+		Status:  status,
+		Headers: headers,
+		Body:    pm.expandVariables(body, context),
+	}
+	if pm.Debug {
+		fmt.Printf("🏗️  Constructed response: %d (%d bytes)\n", status, len(result.ConstructedResponse.Body))
+	}
+	return nil
+}
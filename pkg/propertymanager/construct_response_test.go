@@ -0,0 +1,118 @@
+package propertymanager
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func constructResponsePM(options ...BehaviorOption) *PropertyManager {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{
+		{Name: "synthetic", Behaviors: []Behavior{{Name: "construct_response", Option: options}}},
+	}}}
+	return pm
+}
+
+func TestProcessRequest_ConstructResponse(t *testing.T) {
+	pm := constructResponsePM(
+		BehaviorOption{Name: "status_code", Value: "503"},
+		BehaviorOption{Name: "body", Value: "Maintenance on $(HTTP_HOST)"},
+		BehaviorOption{Name: "content_type", Value: "text/plain"},
+		BehaviorOption{Name: "header", Value: "retry-after: 120"},
+		BehaviorOption{Name: "header", Value: "Cache-Control: no-store"},
+	)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Host = "www.example.com"
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	constructed := result.ConstructedResponse
+	if constructed == nil {
+		t.Fatalf("Expected a constructed response, errors: %v", result.Errors)
+	}
+	if constructed.Status != 503 {
+		t.Errorf("Expected status 503, got %d", constructed.Status)
+	}
+	if constructed.Body != "Maintenance on www.example.com" {
+		t.Errorf("Expected expanded body, got '%s'", constructed.Body)
+	}
+	expected := map[string]string{"Content-Type": "text/plain", "Retry-After": "120", "Cache-Control": "no-store"}
+	for name, value := range expected {
+		if constructed.Headers[name] != value {
+			t.Errorf("Expected header %s=%s, got '%s'", name, value, constructed.Headers[name])
+		}
+	}
+}
+
+func TestProcessRequest_ConstructResponseDefaults(t *testing.T) {
+	pm := constructResponsePM(BehaviorOption{Name: "body", Value: `{"ok":true}`})
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if result.ConstructedResponse == nil || result.ConstructedResponse.Status != 200 {
+		t.Fatalf("Expected a 200 constructed response, got %+v", result.ConstructedResponse)
+	}
+	if got := result.ConstructedResponse.Headers["Content-Type"]; got != "text/html; charset=utf-8" {
+		t.Errorf("Expected the default content type, got '%s'", got)
+	}
+}
+
+func TestProcessRequest_ConstructResponseTemplateFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "maintenance.html"), []byte("<p>Back soon, $(HTTP_PATH)</p>"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	pm := constructResponsePM(BehaviorOption{Name: "template_file", Value: "maintenance.html"})
+	pm.TemplateDir = dir
+
+	req, _ := http.NewRequest("GET", "/checkout", nil)
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if result.ConstructedResponse == nil || result.ConstructedResponse.Body != "<p>Back soon, /checkout</p>" {
+		t.Errorf("Expected the template body, got %+v (errors %v)", result.ConstructedResponse, result.Errors)
+	}
+}
+
+func TestProcessRequest_ConstructResponseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []BehaviorOption
+		message string
+	}{
+		{"invalid status", []BehaviorOption{{Name: "status_code", Value: "700"}}, "invalid status_code"},
+		{"missing template", []BehaviorOption{{Name: "template_file", Value: "missing.html"}}, "reading template"},
+		{"body and template", []BehaviorOption{{Name: "body", Value: "x"}, {Name: "template_file", Value: "page.html"}}, "exclusive"},
+		{"malformed header", []BehaviorOption{{Name: "header", Value: "Retry-After"}}, "Name: value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := constructResponsePM(tt.options...)
+			pm.TemplateDir = t.TempDir()
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			result, err := pm.ProcessRequest(req)
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if result.ConstructedResponse != nil {
+				t.Errorf("Expected no constructed response, got %+v", result.ConstructedResponse)
+			}
+			if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], tt.message) {
+				t.Errorf("Expected an error containing %q, got %v", tt.message, result.Errors)
+			}
+		})
+	}
+}
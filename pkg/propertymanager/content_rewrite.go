@@ -0,0 +1,90 @@
+package propertymanager
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultRewriteMaxSize is the largest response body, in bytes, a content rewrite
+// applies to unless its max_size option says otherwise
+const DefaultRewriteMaxSize = 1 << 20
+
+// ContentRewrite is a find/replace recorded by the rewrite_content behavior in the
+// request phase and applied to the response body in the response phase
+type ContentRewrite struct {
+	Find    string // Text, or regular expression when Regex is set, to replace
+	Replace string // Replacement with variables expanded; $1 style groups apply to regexes
+	Regex   bool
+	MaxSize int // Bodies larger than MaxSize bytes are left untouched
+
+	pattern *regexp.Regexp
+}
+
+// executeRewriteContent records a find/replace of the response body. Options: find,
+// replace (variables such as $(HTTP_HOST) are expanded), regex ("true" treats find
+// as a regular expression) and max_size (bytes, DefaultRewriteMaxSize by default).
+func (pm *PropertyManager) executeRewriteContent(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	rewrite := ContentRewrite{
+		Find:    pm.getBehaviorOption(behavior, "find"),
+		Replace: pm.expandVariables(pm.getBehaviorOption(behavior, "replace"), context),
+		Regex:   pm.getBehaviorOption(behavior, "regex") == "true",
+		MaxSize: DefaultRewriteMaxSize,
+	}
+	if rewrite.Find == "" {
+		return fmt.Errorf("rewrite content: find is required")
+	}
+	if rewrite.Regex {
+		pattern, err := regexp.Compile(rewrite.Find)
+		if err != nil {
+			return fmt.Errorf("rewrite content: invalid regex pattern: %v", err)
+		}
+		rewrite.pattern = pattern
+	}
+	if maxSize := pm.getBehaviorOption(behavior, "max_size"); maxSize != "" {
+		size, err := strconv.Atoi(maxSize)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("rewrite content: max_size must be a positive number of bytes, got %q", maxSize)
+		}
+		rewrite.MaxSize = size
+	}
+
+	result.ContentRewrites = append(result.ContentRewrites, rewrite)
+	if pm.Debug {
+		fmt.Printf("✏️  Content rewrite: %q -> %q\n", rewrite.Find, rewrite.Replace)
+	}
+	return nil
+}
+
+// ApplyContentRewrites applies the content rewrites recorded in result to body in
+// order and returns the rewritten body. The replacements made are added to
+// result.ContentReplacements; rewrites skipped because body exceeds their size limit
+// are reported in result.Errors.
+func ApplyContentRewrites(result *RuleResult, body string) string {
+	for _, rewrite := range result.ContentRewrites {
+		if len(body) > rewrite.MaxSize {
+			result.Errors = append(result.Errors, fmt.Sprintf(
+				"content rewrite %q skipped: body of %d bytes exceeds the limit of %d bytes", rewrite.Find, len(body), rewrite.MaxSize))
+			continue
+		}
+
+		if !rewrite.Regex {
+			result.ContentReplacements += strings.Count(body, rewrite.Find)
+			body = strings.ReplaceAll(body, rewrite.Find, rewrite.Replace)
+			continue
+		}
+
+		pattern := rewrite.pattern
+		if pattern == nil {
+			var err error
+			if pattern, err = regexp.Compile(rewrite.Find); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("content rewrite %q skipped: %v", rewrite.Find, err))
+				continue
+			}
+		}
+		result.ContentReplacements += len(pattern.FindAllStringIndex(body, -1))
+		body = pattern.ReplaceAllString(body, rewrite.Replace)
+	}
+	return body
+}
@@ -0,0 +1,105 @@
+package propertymanager
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProcessRequest_RewriteContent(t *testing.T) {
+	xmlData := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<property name="test-property" version="1">
+	<rules>
+		<rule name="staging-links">
+			<behaviors>
+				<behavior name="rewrite_content">
+					<option name="find" value="https://www.example.com/"/>
+					<option name="replace" value="https://$(HTTP_HOST)/"/>
+				</behavior>
+				<behavior name="rewrite_content">
+					<option name="find" value="v(\d+)\.js"/>
+					<option name="replace" value="v$1.min.js"/>
+					<option name="regex" value="true"/>
+				</behavior>
+			</behaviors>
+		</rule>
+	</rules>
+</property>`)
+
+	pm := NewPropertyManager(false)
+	if err := pm.LoadProperty(xmlData); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/page", nil)
+	req.Host = "staging.example.com"
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if len(result.ContentRewrites) != 2 {
+		t.Fatalf("Expected 2 content rewrites, got %d", len(result.ContentRewrites))
+	}
+
+	body := `<a href="https://www.example.com/a">a</a><a href="https://www.example.com/b">b</a><script src="app.v12.js"></script>`
+	rewritten := ApplyContentRewrites(result, body)
+
+	expected := `<a href="https://staging.example.com/a">a</a><a href="https://staging.example.com/b">b</a><script src="app.v12.min.js"></script>`
+	if rewritten != expected {
+		t.Errorf("Expected %q, got %q", expected, rewritten)
+	}
+	if result.ContentReplacements != 3 {
+		t.Errorf("Expected 3 replacements, got %d", result.ContentReplacements)
+	}
+}
+
+func TestApplyContentRewrites_MaxSize(t *testing.T) {
+	result := &RuleResult{ContentRewrites: []ContentRewrite{{Find: "a", Replace: "b", MaxSize: 4}}}
+
+	if rewritten := ApplyContentRewrites(result, "aaaaa"); rewritten != "aaaaa" {
+		t.Errorf("Expected body over the limit to be untouched, got %q", rewritten)
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "exceeds the limit of 4 bytes") {
+		t.Errorf("Expected a size limit error, got %v", result.Errors)
+	}
+
+	if rewritten := ApplyContentRewrites(result, "aaaa"); rewritten != "bbbb" {
+		t.Errorf("Expected body within the limit to be rewritten, got %q", rewritten)
+	}
+	if result.ContentReplacements != 4 {
+		t.Errorf("Expected 4 replacements, got %d", result.ContentReplacements)
+	}
+}
+
+func TestProcessRequest_RewriteContentInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []BehaviorOption
+		err     string
+	}{
+		{name: "missing find", options: []BehaviorOption{{Name: "replace", Value: "x"}}, err: "find is required"},
+		{name: "invalid regex", options: []BehaviorOption{{Name: "find", Value: "("}, {Name: "regex", Value: "true"}}, err: "invalid regex"},
+		{name: "invalid max size", options: []BehaviorOption{{Name: "find", Value: "a"}, {Name: "max_size", Value: "-1"}}, err: "max_size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				{Name: "rewrite", Behaviors: []Behavior{{Name: "rewrite_content", Option: tt.options}}},
+			}}}
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			result, err := pm.ProcessRequest(req)
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if len(result.ContentRewrites) != 0 {
+				t.Errorf("Expected no content rewrites, got %d", len(result.ContentRewrites))
+			}
+			if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], tt.err) {
+				t.Errorf("Expected an error containing %q, got %v", tt.err, result.Errors)
+			}
+		})
+	}
+}
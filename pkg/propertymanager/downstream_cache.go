@@ -0,0 +1,121 @@
+package propertymanager
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DownstreamCache is the client cacheability set by the downstream_cache behavior. It
+// only changes the Cache-Control and Expires headers sent to the client; edge caching
+// is configured separately by the cache behaviors.
+type DownstreamCache struct {
+	Behavior       string            // allow, must_revalidate, bust, none or tunnel_origin
+	Headers        map[string]string // Response headers to set
+	RemovedHeaders []string          // Response headers to remove
+}
+
+// expiredDate is the Expires value of responses clients must not reuse
+var expiredDate = time.Unix(0, 0).UTC().Format(http.TimeFormat)
+
+// executeDownstreamCache sets the cacheability of the response for clients, like PAPI's
+// downstreamCache. Options: behavior (allow, must_revalidate, bust, none, or
+// tunnel_origin to pass the origin headers through), ttl for allow (seconds or a
+// duration such as 10m), send_headers (cache_control_and_expires by default,
+// cache_control or expires) and send_private ("true" marks the response private).
+func (pm *PropertyManager) executeDownstreamCache(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	mode := strings.ToLower(pm.getBehaviorOption(behavior, "behavior"))
+	if mode == "" {
+		mode = "allow"
+	}
+	sendHeaders := strings.ToLower(pm.getBehaviorOption(behavior, "send_headers"))
+	if sendHeaders == "" {
+		sendHeaders = "cache_control_and_expires"
+	}
+	if sendHeaders != "cache_control_and_expires" && sendHeaders != "cache_control" && sendHeaders != "expires" {
+		return fmt.Errorf("downstream cache: unknown send_headers %q", sendHeaders)
+	}
+
+	now := context.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	downstream := &DownstreamCache{Behavior: mode, Headers: make(map[string]string)}
+	var cacheControl, expires string
+	switch mode {
+	case "allow":
+		ttl, err := parseDownstreamTTL(pm.getBehaviorOption(behavior, "ttl"))
+		if err != nil {
+			return err
+		}
+		cacheControl = "max-age=" + strconv.Itoa(int(ttl.Seconds()))
+		expires = now.Add(ttl).UTC().Format(http.TimeFormat)
+	case "must_revalidate":
+		cacheControl = "max-age=0, must-revalidate"
+		expires = now.UTC().Format(http.TimeFormat)
+	case "bust":
+		cacheControl = "no-store, no-cache, max-age=0"
+		expires = expiredDate
+	case "none":
+		downstream.RemovedHeaders = []string{"Cache-Control", "Expires"}
+	case "tunnel_origin":
+	default:
+		return fmt.Errorf("downstream cache: unknown behavior %q", mode)
+	}
+
+	if cacheControl != "" {
+		if pm.getBehaviorOption(behavior, "send_private") == "true" {
+			cacheControl = "private, " + cacheControl
+		}
+		if sendHeaders != "expires" {
+			downstream.Headers["Cache-Control"] = cacheControl
+		} else {
+			downstream.RemovedHeaders = append(downstream.RemovedHeaders, "Cache-Control")
+		}
+		if sendHeaders != "cache_control" {
+			downstream.Headers["Expires"] = expires
+		} else {
+			downstream.RemovedHeaders = append(downstream.RemovedHeaders, "Expires")
+		}
+	}
+
+	result.DownstreamCache = downstream
+	if pm.Debug {
+		fmt.Printf("📤 Downstream cache: %s %v\n", mode, downstream.Headers)
+	}
+	return nil
+}
+
+// parseDownstreamTTL parses the ttl option of downstream_cache: whole seconds or a
+// duration such as 10m
+func parseDownstreamTTL(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, fmt.Errorf("downstream cache: allow requires a ttl")
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if ttl, err := time.ParseDuration(value); err == nil && ttl >= 0 {
+		return ttl, nil
+	}
+	return 0, fmt.Errorf("downstream cache: invalid ttl %q", value)
+}
+
+// ApplyDownstreamCache adds the client cacheability of result to the response headers
+// of responseResult
+func ApplyDownstreamCache(result, responseResult *RuleResult) {
+	downstream := result.DownstreamCache
+	if downstream == nil {
+		return
+	}
+	for _, name := range downstream.RemovedHeaders {
+		delete(responseResult.ModifiedHeaders, name)
+	}
+	for name, value := range downstream.Headers {
+		responseResult.ModifiedHeaders[name] = value
+	}
+	responseResult.RemovedHeaders = append(responseResult.RemovedHeaders, downstream.RemovedHeaders...)
+	responseResult.DownstreamCache = downstream
+}
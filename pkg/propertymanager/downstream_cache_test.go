@@ -0,0 +1,145 @@
+package propertymanager
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessHTTPContext_DownstreamCache(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		options  []BehaviorOption
+		headers  map[string]string
+		removed  []string
+		behavior string
+	}{
+		{
+			name:     "allow",
+			options:  []BehaviorOption{{Name: "behavior", Value: "allow"}, {Name: "ttl", Value: "10m"}},
+			headers:  map[string]string{"Cache-Control": "max-age=600", "Expires": "Wed, 01 May 2024 12:10:00 GMT"},
+			behavior: "allow",
+		},
+		{
+			name:     "allow private cache control only",
+			options:  []BehaviorOption{{Name: "ttl", Value: "60"}, {Name: "send_headers", Value: "cache_control"}, {Name: "send_private", Value: "true"}},
+			headers:  map[string]string{"Cache-Control": "private, max-age=60"},
+			removed:  []string{"Expires"},
+			behavior: "allow",
+		},
+		{
+			name:     "must revalidate",
+			options:  []BehaviorOption{{Name: "behavior", Value: "must_revalidate"}},
+			headers:  map[string]string{"Cache-Control": "max-age=0, must-revalidate", "Expires": "Wed, 01 May 2024 12:00:00 GMT"},
+			behavior: "must_revalidate",
+		},
+		{
+			name:     "bust expires only",
+			options:  []BehaviorOption{{Name: "behavior", Value: "bust"}, {Name: "send_headers", Value: "expires"}},
+			headers:  map[string]string{"Expires": "Thu, 01 Jan 1970 00:00:00 GMT"},
+			removed:  []string{"Cache-Control"},
+			behavior: "bust",
+		},
+		{
+			name:     "none",
+			options:  []BehaviorOption{{Name: "behavior", Value: "NONE"}},
+			headers:  map[string]string{},
+			removed:  []string{"Cache-Control", "Expires"},
+			behavior: "none",
+		},
+		{
+			name:     "tunnel origin",
+			options:  []BehaviorOption{{Name: "behavior", Value: "tunnel_origin"}},
+			headers:  map[string]string{},
+			behavior: "tunnel_origin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				{Name: "downstream", Behaviors: []Behavior{{Name: "downstream_cache", Option: tt.options}}},
+			}}}
+
+			result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/", Timestamp: now})
+			if err != nil {
+				t.Fatalf("ProcessHTTPContext failed: %v", err)
+			}
+			downstream := result.DownstreamCache
+			if downstream == nil {
+				t.Fatalf("Expected a downstream cache setting, errors: %v", result.Errors)
+			}
+			if downstream.Behavior != tt.behavior {
+				t.Errorf("Expected behavior %s, got %s", tt.behavior, downstream.Behavior)
+			}
+			if !reflect.DeepEqual(downstream.Headers, tt.headers) {
+				t.Errorf("Expected headers %v, got %v", tt.headers, downstream.Headers)
+			}
+			if !reflect.DeepEqual(downstream.RemovedHeaders, tt.removed) {
+				t.Errorf("Expected removed headers %v, got %v", tt.removed, downstream.RemovedHeaders)
+			}
+			if len(result.ModifiedHeaders) != 0 {
+				t.Errorf("Expected the request headers to stay untouched, got %v", result.ModifiedHeaders)
+			}
+		})
+	}
+}
+
+func TestProcessRequest_DownstreamCacheErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []BehaviorOption
+		message string
+	}{
+		{"missing ttl", []BehaviorOption{{Name: "behavior", Value: "allow"}}, "requires a ttl"},
+		{"invalid ttl", []BehaviorOption{{Name: "ttl", Value: "soon"}}, "invalid ttl"},
+		{"unknown behavior", []BehaviorOption{{Name: "behavior", Value: "forever"}}, "unknown behavior"},
+		{"unknown headers", []BehaviorOption{{Name: "behavior", Value: "bust"}, {Name: "send_headers", Value: "pragma"}}, "unknown send_headers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				{Name: "downstream", Behaviors: []Behavior{{Name: "downstream_cache", Option: tt.options}}},
+			}}}
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			result, err := pm.ProcessRequest(req)
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if result.DownstreamCache != nil {
+				t.Errorf("Expected no downstream cache setting, got %+v", result.DownstreamCache)
+			}
+			if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], tt.message) {
+				t.Errorf("Expected an error containing %q, got %v", tt.message, result.Errors)
+			}
+		})
+	}
+}
+
+func TestApplyDownstreamCache(t *testing.T) {
+	result := &RuleResult{DownstreamCache: &DownstreamCache{
+		Behavior:       "none",
+		Headers:        map[string]string{},
+		RemovedHeaders: []string{"Cache-Control", "Expires"},
+	}}
+	responseResult := &RuleResult{ModifiedHeaders: map[string]string{"Cache-Control": "max-age=60", "X-Test": "yes"}}
+
+	ApplyDownstreamCache(result, responseResult)
+
+	if _, ok := responseResult.ModifiedHeaders["Cache-Control"]; ok {
+		t.Errorf("Expected Cache-Control to be dropped, got %v", responseResult.ModifiedHeaders)
+	}
+	if responseResult.ModifiedHeaders["X-Test"] != "yes" {
+		t.Errorf("Expected other headers to be kept, got %v", responseResult.ModifiedHeaders)
+	}
+	if !reflect.DeepEqual(responseResult.RemovedHeaders, []string{"Cache-Control", "Expires"}) {
+		t.Errorf("Expected removed headers, got %v", responseResult.RemovedHeaders)
+	}
+}
@@ -0,0 +1,93 @@
+package propertymanager
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultESIContentTypes are the response content types ESI processes unless the esi
+// behavior's content_types option says otherwise
+var DefaultESIContentTypes = []string{"text/html"}
+
+// ESISettings is the ESI processing configured by the esi behavior. The last esi
+// behavior executed wins, so a child rule can turn ESI off for part of a property.
+type ESISettings struct {
+	Enabled bool
+	// EnableViaHTTP processes only responses whose origin sends Edge-Control: dca=esi
+	EnableViaHTTP bool
+	// ContentTypes are the media types of the responses to process
+	ContentTypes []string
+}
+
+// executeESI configures ESI processing, like PAPI's edgeSideIncludes. Options: enabled
+// (true unless set to false), enable_via_http ("true" processes only responses the
+// origin marks with Edge-Control: dca=esi) and content_types (comma-separated media
+// types, text/html by default).
+func (pm *PropertyManager) executeESI(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	settings := &ESISettings{
+		Enabled:       pm.getBehaviorOption(behavior, "enabled") != "false",
+		EnableViaHTTP: pm.getBehaviorOption(behavior, "enable_via_http") == "true",
+		ContentTypes:  DefaultESIContentTypes,
+	}
+	if contentTypes := pm.getBehaviorOption(behavior, "content_types"); contentTypes != "" {
+		settings.ContentTypes = nil
+		for _, contentType := range strings.Split(contentTypes, ",") {
+			if contentType = strings.ToLower(strings.TrimSpace(contentType)); contentType != "" {
+				settings.ContentTypes = append(settings.ContentTypes, contentType)
+			}
+		}
+	}
+
+	result.ESI = settings
+	if pm.Debug {
+		fmt.Printf("🔧 ESI processing: enabled=%t via_http=%t types=%v\n", settings.Enabled, settings.EnableViaHTTP, settings.ContentTypes)
+	}
+	return nil
+}
+
+// SkipReason returns why ESI does not process a response with the origin headers, or ""
+// when it does. A response without a Content-Type is taken to be HTML.
+func (s *ESISettings) SkipReason(origin http.Header) string {
+	if !s.Enabled {
+		return "esi behavior disabled"
+	}
+
+	contentType := origin.Get("Content-Type")
+	mediaType := "text/html"
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return fmt.Sprintf("invalid content type %q", contentType)
+		}
+		mediaType = parsed
+	}
+	allowed := false
+	for _, candidate := range s.ContentTypes {
+		if candidate == mediaType {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Sprintf("content type %s not in %s", mediaType, strings.Join(s.ContentTypes, ", "))
+	}
+
+	if s.EnableViaHTTP && !edgeControlESI(origin) {
+		return "origin did not send Edge-Control: dca=esi"
+	}
+	return ""
+}
+
+// edgeControlESI reports whether the origin asked for ESI processing with Edge-Control
+func edgeControlESI(origin http.Header) bool {
+	for _, value := range origin.Values("Edge-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.ReplaceAll(directive, " ", ""), "dca=esi") {
+				return true
+			}
+		}
+	}
+	return false
+}
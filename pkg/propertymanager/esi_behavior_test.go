@@ -0,0 +1,63 @@
+package propertymanager
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestProcessHTTPContext_ESISettings(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{{
+		Name:      "default",
+		Behaviors: []Behavior{{Name: "esi", Option: []BehaviorOption{{Name: "content_types", Value: "text/html, Application/XHTML+XML"}}}},
+		Children: []Rule{{
+			Name:      "api",
+			Criteria:  []Criterion{{Name: "path", Option: "starts_with", Value: "/api/"}},
+			Behaviors: []Behavior{{Name: "esi", Option: []BehaviorOption{{Name: "enabled", Value: "false"}}}},
+		}},
+	}}}}
+
+	result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/home"})
+	if err != nil {
+		t.Fatalf("ProcessHTTPContext failed: %v", err)
+	}
+	want := &ESISettings{Enabled: true, ContentTypes: []string{"text/html", "application/xhtml+xml"}}
+	if !reflect.DeepEqual(result.ESI, want) {
+		t.Errorf("Expected ESI settings %+v, got %+v", want, result.ESI)
+	}
+
+	// The child rule's esi behavior overrides the parent's
+	result, err = pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/api/users"})
+	if err != nil {
+		t.Fatalf("ProcessHTTPContext failed: %v", err)
+	}
+	if result.ESI == nil || result.ESI.Enabled {
+		t.Errorf("Expected ESI disabled under /api/, got %+v", result.ESI)
+	}
+}
+
+func TestESISettings_SkipReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings ESISettings
+		origin   http.Header
+		skipped  bool
+	}{
+		{name: "html", settings: ESISettings{Enabled: true, ContentTypes: DefaultESIContentTypes}, origin: http.Header{"Content-Type": {"text/html; charset=utf-8"}}},
+		{name: "no content type", settings: ESISettings{Enabled: true, ContentTypes: DefaultESIContentTypes}},
+		{name: "json", settings: ESISettings{Enabled: true, ContentTypes: DefaultESIContentTypes}, origin: http.Header{"Content-Type": {"application/json"}}, skipped: true},
+		{name: "allowlisted json", settings: ESISettings{Enabled: true, ContentTypes: []string{"application/json"}}, origin: http.Header{"Content-Type": {"application/json"}}},
+		{name: "disabled", settings: ESISettings{ContentTypes: DefaultESIContentTypes}, skipped: true},
+		{name: "via http without edge control", settings: ESISettings{Enabled: true, EnableViaHTTP: true, ContentTypes: DefaultESIContentTypes}, skipped: true},
+		{name: "via http", settings: ESISettings{Enabled: true, EnableViaHTTP: true, ContentTypes: DefaultESIContentTypes}, origin: http.Header{"Edge-Control": {"max-age=60, dca=esi"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := tt.settings.SkipReason(tt.origin)
+			if (reason != "") != tt.skipped {
+				t.Errorf("SkipReason() = %q, skipped want %v", reason, tt.skipped)
+			}
+		})
+	}
+}
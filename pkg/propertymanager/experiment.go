@@ -0,0 +1,49 @@
+package propertymanager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
+)
+
+// evaluateExperimentCriterion evaluates experiment criteria: Option names one of the
+// PropertyManager's Experiments and Extract compares the variant the request is assigned
+// to with Value (equals, not_equals, or in and not_in with a comma-separated list)
+func (pm *PropertyManager) evaluateExperimentCriterion(criterion *Criterion, context *HTTPContext) bool {
+	found, exists := experiment.Find(pm.Experiments, criterion.Option)
+	if !exists {
+		if pm.Debug {
+			fmt.Printf("⚠️  Unknown experiment: %s\n", criterion.Option)
+		}
+		return false
+	}
+
+	variant := found.Assign(experiment.Request{
+		Cookies:  context.Cookies,
+		Headers:  context.Headers,
+		Query:    context.Query,
+		ClientIP: context.ClientIP,
+	})
+	value := criterion.Value
+	if !criterion.Case {
+		variant = strings.ToLower(variant)
+		value = strings.ToLower(value)
+	}
+
+	switch criterion.Extract {
+	case "not_equals":
+		return variant != value
+	case "in", "not_in":
+		in := false
+		for _, v := range strings.Split(value, ",") {
+			if strings.TrimSpace(v) == variant {
+				in = true
+				break
+			}
+		}
+		return in == (criterion.Extract == "in")
+	default:
+		return variant == value
+	}
+}
@@ -0,0 +1,70 @@
+package propertymanager
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
+)
+
+func TestProcessRequest_ExperimentCriterion(t *testing.T) {
+	checkout := experiment.Experiment{
+		Name:      "checkout",
+		StickyKey: "cookie:uid",
+		Variants:  []experiment.Variant{{Name: "control", Weight: 1}, {Name: "one-page", Weight: 1}},
+	}
+
+	// Find users of each variant
+	users := map[string]string{}
+	for _, uid := range []string{"user-1", "user-2", "user-3", "user-4", "user-5", "user-6"} {
+		users[checkout.Bucket(uid)] = uid
+	}
+	if len(users) != 2 {
+		t.Fatalf("Expected users of both variants, got %v", users)
+	}
+
+	tests := []struct {
+		name      string
+		uid       string
+		criterion Criterion
+		expected  bool
+	}{
+		{"equals", users["one-page"], Criterion{Name: "experiment", Option: "checkout", Value: "one-page"}, true},
+		{"equals other variant", users["control"], Criterion{Name: "experiment", Option: "checkout", Value: "one-page"}, false},
+		{"case-insensitive", users["one-page"], Criterion{Name: "experiment", Option: "checkout", Value: "ONE-PAGE"}, true},
+		{"not equals", users["control"], Criterion{Name: "experiment", Option: "checkout", Value: "one-page", Extract: "not_equals"}, true},
+		{"in", users["control"], Criterion{Name: "experiment", Option: "checkout", Value: "control, one-page", Extract: "in"}, true},
+		{"not in", users["control"], Criterion{Name: "experiment", Option: "checkout", Value: "one-page", Extract: "not_in"}, true},
+		{"no sticky key gets the control", "", Criterion{Name: "experiment", Option: "checkout", Value: "control"}, true},
+		{"unknown experiment", users["control"], Criterion{Name: "experiment", Option: "pricing", Value: "control"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Experiments = []experiment.Experiment{checkout}
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{{Name: "experiment", Criteria: []Criterion{tt.criterion}}}}}
+
+			req, _ := http.NewRequest("GET", "/checkout", nil)
+			if tt.uid != "" {
+				req.AddCookie(&http.Cookie{Name: "uid", Value: tt.uid})
+			}
+			result, err := pm.ProcessRequest(req)
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if matched := len(result.MatchedRules) == 1; matched != tt.expected {
+				t.Errorf("Expected match %v, got %v", tt.expected, matched)
+			}
+		})
+	}
+}
+
+func TestRuleBuilder_Experiment(t *testing.T) {
+	rule := NewRule("one-page").Experiment("checkout", "in", "one-page,express").Build()
+
+	expected := Criterion{Name: "experiment", Option: "checkout", Value: "one-page,express", Extract: "in"}
+	if len(rule.Criteria) != 1 || rule.Criteria[0] != expected {
+		t.Errorf("Expected criteria %+v, got %+v", expected, rule.Criteria)
+	}
+}
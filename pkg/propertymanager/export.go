@@ -0,0 +1,228 @@
+package propertymanager
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Export formats of ExportProperty
+const (
+	ExportXML  = "xml"
+	ExportJSON = "json"
+)
+
+// nameValuedCriteria are the criteria whose option names a header, cookie, variable,
+// field or experiment, leaving the match operator to extract
+var nameValuedCriteria = map[string]bool{"header": true, "cookie": true, "variable": true, "body_json": true, "experiment": true}
+
+// ExportProperty returns the loaded property, normalized, as XML that LoadProperty reads
+// back or as PAPI-style JSON. Normalization fills in the equals operator criteria default
+// to, turns the options map of JSON API behaviors into sorted options and trims names,
+// so rule sets built or changed in code export the same as their XML equivalent.
+func (pm *PropertyManager) ExportProperty(format string) ([]byte, error) {
+	if pm.Property == nil {
+		return nil, fmt.Errorf("export property: no property loaded")
+	}
+	property := normalizeProperty(pm.Property)
+
+	switch strings.ToLower(format) {
+	case ExportXML:
+		data, err := xml.MarshalIndent(property, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("export property: %w", err)
+		}
+		return append([]byte(xml.Header), append(data, '\n')...), nil
+	case ExportJSON:
+		data, err := json.MarshalIndent(papiProperty(property), "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("export property: %w", err)
+		}
+		return append(data, '\n'), nil
+	default:
+		return nil, fmt.Errorf("export property: unknown format %q, expected %s or %s", format, ExportXML, ExportJSON)
+	}
+}
+
+// normalizeProperty returns a normalized copy of property
+func normalizeProperty(property *Property) *Property {
+	normalized := &Property{
+		Name:      strings.TrimSpace(property.Name),
+		Version:   property.Version,
+		Rules:     Rules{Rule: normalizeRules(property.Rules.Rule)},
+		Behaviors: Behaviors{Behavior: normalizeBehaviors(property.Behaviors.Behavior)},
+		Comments:  property.Comments,
+	}
+	for _, variable := range property.Variables.Variable {
+		normalized.Variables.Variable = append(normalized.Variables.Variable, Variable{
+			Name:  strings.TrimSpace(variable.Name),
+			Value: variable.Value,
+			Type:  variable.Type,
+		})
+	}
+	return normalized
+}
+
+func normalizeRules(rules []Rule) []Rule {
+	normalized := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		copied := Rule{
+			Name:      strings.TrimSpace(rule.Name),
+			Comment:   rule.Comment,
+			Start:     rule.Start,
+			End:       rule.End,
+			Behaviors: normalizeBehaviors(rule.Behaviors),
+			Children:  normalizeRules(rule.Children),
+		}
+		for _, criterion := range rule.Criteria {
+			criterion.XMLName = xml.Name{}
+			criterion.Name = strings.TrimSpace(criterion.Name)
+			if nameValuedCriteria[criterion.Name] {
+				if criterion.Extract == "" {
+					criterion.Extract = "equals"
+				}
+			} else if criterion.Option == "" {
+				criterion.Option = "equals"
+			}
+			copied.Criteria = append(copied.Criteria, criterion)
+		}
+		if len(copied.Children) == 0 {
+			copied.Children = nil
+		}
+		normalized = append(normalized, copied)
+	}
+	return normalized
+}
+
+func normalizeBehaviors(behaviors []Behavior) []Behavior {
+	var normalized []Behavior
+	for _, behavior := range behaviors {
+		copied := Behavior{Name: strings.TrimSpace(behavior.Name)}
+		for _, option := range behavior.Option {
+			copied.Option = append(copied.Option, BehaviorOption{Name: strings.TrimSpace(option.Name), Value: option.Value})
+		}
+
+		names := make([]string, 0, len(behavior.Options))
+		for name := range behavior.Options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			copied.Option = append(copied.Option, BehaviorOption{Name: name, Value: optionString(behavior.Options[name])})
+		}
+		normalized = append(normalized, copied)
+	}
+	return normalized
+}
+
+// optionString formats a JSON API behavior option as an XML option value
+func optionString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = optionString(part)
+		}
+		return strings.Join(parts, ",")
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// papiRule is a rule of the PAPI-style JSON export
+type papiRule struct {
+	Name      string         `json:"name"`
+	Comments  string         `json:"comments,omitempty"`
+	Criteria  []papiFeature  `json:"criteria"`
+	Behaviors []papiFeature  `json:"behaviors"`
+	Children  []papiRule     `json:"children"`
+	Variables []papiVariable `json:"variables,omitempty"`
+}
+
+// papiFeature is a criterion or behavior of the PAPI-style JSON export
+type papiFeature struct {
+	Name    string                 `json:"name"`
+	Options map[string]interface{} `json:"options"`
+}
+
+// papiVariable is a property variable of the PAPI-style JSON export
+type papiVariable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+// papiProperty lays a normalized property out like a PAPI rule tree: a default rule
+// holding the property behaviors and variables, with the rules as its children
+func papiProperty(property *Property) map[string]interface{} {
+	root := papiRule{
+		Name:      "default",
+		Comments:  property.Comments,
+		Criteria:  []papiFeature{},
+		Behaviors: papiBehaviors(property.Behaviors.Behavior),
+		Children:  papiRules(property.Rules.Rule),
+	}
+	for _, variable := range property.Variables.Variable {
+		root.Variables = append(root.Variables, papiVariable{Name: variable.Name, Value: variable.Value, Type: variable.Type})
+	}
+	return map[string]interface{}{
+		"propertyName":    property.Name,
+		"propertyVersion": property.Version,
+		"rules":           root,
+	}
+}
+
+func papiRules(rules []Rule) []papiRule {
+	converted := []papiRule{}
+	for _, rule := range rules {
+		converted = append(converted, papiRule{
+			Name:      rule.Name,
+			Comments:  rule.Comment,
+			Criteria:  papiCriteria(rule.Criteria),
+			Behaviors: papiBehaviors(rule.Behaviors),
+			Children:  papiRules(rule.Children),
+		})
+	}
+	return converted
+}
+
+func papiCriteria(criteria []Criterion) []papiFeature {
+	converted := []papiFeature{}
+	for _, criterion := range criteria {
+		options := map[string]interface{}{"option": criterion.Option, "value": criterion.Value}
+		if criterion.Extract != "" {
+			options["extract"] = criterion.Extract
+		}
+		if criterion.Case {
+			options["matchCaseSensitive"] = true
+		}
+		converted = append(converted, papiFeature{Name: criterion.Name, Options: options})
+	}
+	return converted
+}
+
+// papiBehaviors converts behaviors, collecting repeated options into arrays
+func papiBehaviors(behaviors []Behavior) []papiFeature {
+	converted := []papiFeature{}
+	for _, behavior := range behaviors {
+		options := map[string]interface{}{}
+		for _, option := range behavior.Option {
+			switch existing := options[option.Name].(type) {
+			case nil:
+				options[option.Name] = option.Value
+			case string:
+				options[option.Name] = []string{existing, option.Value}
+			case []string:
+				options[option.Name] = append(existing, option.Value)
+			}
+		}
+		converted = append(converted, papiFeature{Name: behavior.Name, Options: options})
+	}
+	return converted
+}
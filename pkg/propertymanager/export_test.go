@@ -0,0 +1,155 @@
+package propertymanager
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const exportFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<property name=" shop " version="3">
+	<rules>
+		<rule name="api" comment="API traffic">
+			<criteria name="path" value="/api"/>
+			<criteria name="header" option="Accept" value="application/json"/>
+			<behaviors>
+				<behavior name="construct_response">
+					<option name="status_code" value="503"/>
+					<option name="header" value="Retry-After: 60"/>
+					<option name="header" value="Cache-Control: no-store"/>
+				</behavior>
+			</behaviors>
+			<children>
+				<rule name="v2">
+					<criteria name="path" option="regex" value="^/api/v2/" case="true"/>
+				</rule>
+			</children>
+		</rule>
+	</rules>
+	<behaviors>
+		<behavior name="origin">
+			<option name="hostname" value="origin.example.com"/>
+		</behavior>
+	</behaviors>
+	<variables>
+		<variable name="PMUSER_REGION" value="eu"/>
+	</variables>
+</property>`
+
+func TestExportProperty_XMLRoundTrip(t *testing.T) {
+	pm := NewPropertyManager(false)
+	if err := pm.LoadProperty([]byte(exportFixture)); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+
+	exported, err := pm.ExportProperty("xml")
+	if err != nil {
+		t.Fatalf("ExportProperty failed: %v", err)
+	}
+	for _, expected := range []string{
+		`<property name="shop" version="3">`,
+		`<criteria name="path" option="equals" value="/api"></criteria>`,
+		`<criteria name="header" option="Accept" value="application/json" extract="equals"></criteria>`,
+		`<criteria name="path" option="regex" value="^/api/v2/" case="true"></criteria>`,
+		`<option name="header" value="Retry-After: 60"></option>`,
+		`<variable name="PMUSER_REGION" value="eu"></variable>`,
+	} {
+		if !strings.Contains(string(exported), expected) {
+			t.Errorf("Expected the export to contain %s, got:\n%s", expected, exported)
+		}
+	}
+
+	reloaded := NewPropertyManager(false)
+	if err := reloaded.LoadProperty(exported); err != nil {
+		t.Fatalf("LoadProperty of the export failed: %v", err)
+	}
+	again, err := reloaded.ExportProperty("xml")
+	if err != nil {
+		t.Fatalf("ExportProperty failed: %v", err)
+	}
+	if string(again) != string(exported) {
+		t.Errorf("Expected the export to round-trip, got:\n%s\nthen:\n%s", exported, again)
+	}
+}
+
+func TestExportProperty_ProgrammaticRules(t *testing.T) {
+	// A rule set built in code with JSON API options exports like its XML equivalent
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Name: "built", Version: 1, Rules: Rules{Rule: []Rule{{
+		Name:     "admin",
+		Criteria: []Criterion{{Name: "path", Option: "starts_with", Value: "/admin"}},
+		Behaviors: []Behavior{{Name: "access_control", Options: map[string]interface{}{
+			"blocked_ips": "203.0.113.0/24",
+			"allowed_ips": []interface{}{"10.0.0.1", "10.0.0.2"},
+		}}},
+	}}}}
+
+	exported, err := pm.ExportProperty("XML")
+	if err != nil {
+		t.Fatalf("ExportProperty failed: %v", err)
+	}
+	allowed := strings.Index(string(exported), `<option name="allowed_ips" value="10.0.0.1,10.0.0.2"></option>`)
+	blocked := strings.Index(string(exported), `<option name="blocked_ips" value="203.0.113.0/24"></option>`)
+	if allowed < 0 || blocked < allowed {
+		t.Errorf("Expected sorted options, got:\n%s", exported)
+	}
+}
+
+func TestExportProperty_JSON(t *testing.T) {
+	pm := NewPropertyManager(false)
+	if err := pm.LoadProperty([]byte(exportFixture)); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+
+	exported, err := pm.ExportProperty("json")
+	if err != nil {
+		t.Fatalf("ExportProperty failed: %v", err)
+	}
+
+	var tree struct {
+		PropertyName    string   `json:"propertyName"`
+		PropertyVersion int      `json:"propertyVersion"`
+		Rules           papiRule `json:"rules"`
+	}
+	if err := json.Unmarshal(exported, &tree); err != nil {
+		t.Fatalf("Export is not JSON: %v\n%s", err, exported)
+	}
+
+	if tree.PropertyName != "shop" || tree.PropertyVersion != 3 {
+		t.Errorf("Expected property shop version 3, got %s version %d", tree.PropertyName, tree.PropertyVersion)
+	}
+	root := tree.Rules
+	if root.Name != "default" || len(root.Behaviors) != 1 || root.Behaviors[0].Name != "origin" {
+		t.Errorf("Expected a default rule with the property behaviors, got %+v", root)
+	}
+	if len(root.Variables) != 1 || root.Variables[0].Name != "PMUSER_REGION" {
+		t.Errorf("Expected the property variables on the default rule, got %+v", root.Variables)
+	}
+	if len(root.Children) != 1 || len(root.Children[0].Children) != 1 {
+		t.Fatalf("Expected the rule tree as children of the default rule, got %+v", root.Children)
+	}
+
+	api := root.Children[0]
+	if api.Comments != "API traffic" || api.Criteria[0].Options["option"] != "equals" {
+		t.Errorf("Expected normalized criteria and comments, got %+v", api)
+	}
+	headers, ok := api.Behaviors[0].Options["header"].([]interface{})
+	if !ok || len(headers) != 2 {
+		t.Errorf("Expected repeated options as an array, got %v", api.Behaviors[0].Options["header"])
+	}
+	if api.Children[0].Criteria[0].Options["matchCaseSensitive"] != true {
+		t.Errorf("Expected case sensitivity to be exported, got %v", api.Children[0].Criteria[0].Options)
+	}
+}
+
+func TestExportProperty_Errors(t *testing.T) {
+	pm := NewPropertyManager(false)
+	if _, err := pm.ExportProperty("xml"); err == nil || !strings.Contains(err.Error(), "no property loaded") {
+		t.Errorf("Expected an error without a property, got %v", err)
+	}
+
+	pm.Property = &Property{Name: "empty"}
+	if _, err := pm.ExportProperty("yaml"); err == nil || !strings.Contains(err.Error(), "unknown format") {
+		t.Errorf("Expected an unknown format error, got %v", err)
+	}
+}
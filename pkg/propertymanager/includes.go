@@ -0,0 +1,83 @@
+package propertymanager
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth bounds how deeply rule snippets may include each other
+const maxIncludeDepth = 16
+
+// LoadPropertyFile loads a property configuration from an XML file, resolving the rule
+// snippets it includes relative to the file's directory
+func (pm *PropertyManager) LoadPropertyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading property file: %w", err)
+	}
+	return pm.loadProperty(data, path)
+}
+
+// resolveIncludes merges the rule snippets referenced by rules, and by the snippets
+// themselves, into the rule tree. A rule with an include attribute gets the snippet's
+// rules as its first children, so its criteria guard the whole snippet. Paths are
+// relative to the including file; chain holds the files being included, outermost
+// first, to detect cycles.
+func resolveIncludes(rules []Rule, dir string, chain []string) error {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Include != "" {
+			snippet, err := loadSnippet(rule.Include, dir, chain)
+			if err != nil {
+				return fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			rule.Children = append(snippet, rule.Children...)
+			rule.Include = ""
+			continue
+		}
+		if err := resolveIncludes(rule.Children, dir, chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSnippet reads the rules of a snippet file, whose root element is <rules>, with
+// its own includes resolved
+func loadSnippet(include, dir string, chain []string) ([]Rule, error) {
+	path := include
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+
+	for i, included := range chain {
+		if included == path {
+			cycle := append(append([]string{}, chain[i:]...), path)
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if len(chain) >= maxIncludeDepth {
+		return nil, fmt.Errorf("include %s: more than %d nested includes", include, maxIncludeDepth)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("include %s: %w", include, err)
+	}
+	var snippet Rules
+	if err := xml.Unmarshal(data, &snippet); err != nil {
+		return nil, fmt.Errorf("include %s: parsing %s: %w", include, path, err)
+	}
+
+	chain = append(chain, path)
+	if err := resolveIncludes(snippet.Rule, filepath.Dir(path), chain); err != nil {
+		return nil, fmt.Errorf("in %s: %w", path, err)
+	}
+	return snippet.Rule, nil
+}
@@ -0,0 +1,145 @@
+package propertymanager
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSnippet(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func TestLoadPropertyFile_Includes(t *testing.T) {
+	dir := t.TempDir()
+	writeSnippet(t, dir, "snippets/security.xml", `<rules>
+	<rule name="block-admin">
+		<criteria name="path" option="starts_with" value="/admin"/>
+		<behaviors>
+			<behavior name="set_response_header">
+				<option name="header_name" value="X-Blocked"/>
+				<option name="value" value="true"/>
+			</behavior>
+		</behaviors>
+	</rule>
+	<rule name="headers" include="headers.xml"/>
+</rules>`)
+	writeSnippet(t, dir, "snippets/headers.xml", `<rules>
+	<rule name="frame-options">
+		<behaviors>
+			<behavior name="set_response_header">
+				<option name="header_name" value="X-Frame-Options"/>
+				<option name="value" value="DENY"/>
+			</behavior>
+		</behaviors>
+	</rule>
+</rules>`)
+	propertyFile := writeSnippet(t, dir, "property.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<property name="shop" version="1">
+	<rules>
+		<rule name="www" include="snippets/security.xml">
+			<criteria name="host" option="equals" value="www.example.com"/>
+			<children>
+				<rule name="local"/>
+			</children>
+		</rule>
+	</rules>
+</property>`)
+
+	pm := NewPropertyManager(false)
+	if err := pm.LoadPropertyFile(propertyFile); err != nil {
+		t.Fatalf("LoadPropertyFile failed: %v", err)
+	}
+
+	www := pm.Property.Rules.Rule[0]
+	if www.Include != "" {
+		t.Errorf("Expected the include to be resolved, got %q", www.Include)
+	}
+	names := []string{}
+	for _, child := range www.Children {
+		names = append(names, child.Name)
+	}
+	if strings.Join(names, ",") != "block-admin,headers,local" {
+		t.Errorf("Expected the snippet rules before the rule's own children, got %v", names)
+	}
+	if pm.Rules["frame-options"] == nil {
+		t.Errorf("Expected nested snippet rules in the rule map")
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/users", nil)
+	req.Host = "www.example.com"
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if result.ModifiedHeaders["X-Blocked"] != "true" || result.ModifiedHeaders["X-Frame-Options"] != "DENY" {
+		t.Errorf("Expected the included behaviors to run, got %v", result.ModifiedHeaders)
+	}
+
+	// The criteria of the including rule guard the snippet
+	req, _ = http.NewRequest("GET", "/admin/users", nil)
+	req.Host = "api.example.com"
+	result, err = pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if len(result.MatchedRules) != 0 {
+		t.Errorf("Expected no rules to match another host, got %v", result.MatchedRules)
+	}
+}
+
+func TestLoadPropertyFile_IncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeSnippet(t, dir, "a.xml", `<rules><rule name="to-b" include="b.xml"/></rules>`)
+	writeSnippet(t, dir, "b.xml", `<rules><rule name="to-a" include="a.xml"/></rules>`)
+	propertyFile := writeSnippet(t, dir, "property.xml",
+		`<property name="cyclic"><rules><rule name="root" include="a.xml"/></rules></property>`)
+
+	err := NewPropertyManager(false).LoadPropertyFile(propertyFile)
+	if err == nil {
+		t.Fatal("Expected an include cycle error")
+	}
+	expected := "include cycle: " + filepath.Join(dir, "a.xml") + " -> " + filepath.Join(dir, "b.xml") + " -> " + filepath.Join(dir, "a.xml")
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, got %v", expected, err)
+	}
+	if !strings.Contains(err.Error(), `rule "root"`) {
+		t.Errorf("Expected the error to name the including rule, got %v", err)
+	}
+}
+
+func TestLoadPropertyFile_IncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeSnippet(t, dir, "broken.xml", `<rules><rule name="x">`)
+	writeSnippet(t, dir, "self.xml", `<rules><rule name="again" include="self.xml"/></rules>`)
+
+	tests := []struct {
+		include string
+		message string
+	}{
+		{"missing.xml", "include missing.xml"},
+		{"broken.xml", "parsing " + filepath.Join(dir, "broken.xml")},
+		{"self.xml", "include cycle"},
+		{"property.xml", "include cycle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.include, func(t *testing.T) {
+			propertyFile := writeSnippet(t, dir, "property.xml",
+				`<property name="p"><rules><rule name="root" include="`+tt.include+`"/></rules></property>`)
+			err := NewPropertyManager(false).LoadPropertyFile(propertyFile)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("Expected an error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
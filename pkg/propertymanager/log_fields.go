@@ -0,0 +1,115 @@
+package propertymanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LogField is a custom field the log_fields behavior adds to the access log line of a
+// matched request, as log delivery customization does at the edge
+type LogField struct {
+	Name  string
+	Value string // Empty when the request has no value for the field's source
+}
+
+// geoLogFields maps the geo sources of log_fields to their variables and the values geo
+// criteria assume when a request has none
+var geoLogFields = map[string]struct{ variable, fallback string }{
+	"country":      {"GEO_COUNTRY_CODE", "US"},
+	"country_name": {"GEO_COUNTRY_NAME", "United States"},
+	"region":       {"GEO_REGION", "California"},
+	"city":         {"GEO_CITY", "San Francisco"},
+}
+
+// executeLogFields selects custom fields for the access log. Options: fields, a
+// comma-separated list of sources, header:<name>, cookie:<name>, variable:<name> or
+// geo:<country|country_name|region|city>, each logged as its kind and name, such as
+// header_user_agent, or under a label written label=source; and custom, a value with
+// variables such as $(GEO_COUNTRY_CODE) expanded, logged as custom. A later field of
+// the same name replaces an earlier one.
+func (pm *PropertyManager) executeLogFields(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	fields := pm.getBehaviorOption(behavior, "fields")
+	custom := pm.getBehaviorOption(behavior, "custom")
+	if strings.TrimSpace(fields) == "" && custom == "" {
+		return fmt.Errorf("log fields: fields or custom is required")
+	}
+
+	var selected []LogField
+	for _, source := range strings.Split(fields, ",") {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+		field, err := logField(source, context)
+		if err != nil {
+			return err
+		}
+		selected = append(selected, field)
+	}
+	if custom != "" {
+		selected = append(selected, LogField{Name: "custom", Value: pm.expandVariables(custom, context)})
+	}
+
+	for _, field := range selected {
+		result.setLogField(field)
+	}
+	if pm.Debug {
+		fmt.Printf("📝 Log fields: %v\n", selected)
+	}
+	return nil
+}
+
+// logField reads a log_fields source, optionally labelled as label=source, from the request
+func logField(source string, context *HTTPContext) (LogField, error) {
+	label, source, labelled := strings.Cut(source, "=")
+	if !labelled {
+		source = label
+	}
+	kind, name, found := strings.Cut(source, ":")
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	name = strings.TrimSpace(name)
+	if !found || name == "" {
+		return LogField{}, fmt.Errorf("log fields: invalid field %q, expected kind:name", source)
+	}
+	if !labelled {
+		label = kind + "_" + strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+	}
+	field := LogField{Name: strings.TrimSpace(label)}
+
+	switch kind {
+	case "header":
+		for header, value := range context.Headers {
+			if strings.EqualFold(header, name) {
+				field.Value = value
+				break
+			}
+		}
+	case "cookie":
+		field.Value = context.Cookies[name]
+	case "variable":
+		field.Value = context.Variables[name]
+	case "geo":
+		geo, ok := geoLogFields[strings.ToLower(name)]
+		if !ok {
+			return LogField{}, fmt.Errorf("log fields: unknown geo field %q", name)
+		}
+		field.Value = context.Variables[geo.variable]
+		if field.Value == "" {
+			field.Value = geo.fallback
+		}
+	default:
+		return LogField{}, fmt.Errorf("log fields: unknown field kind %q", kind)
+	}
+	return field, nil
+}
+
+// setLogField records a log field, replacing an earlier one of the same name
+func (r *RuleResult) setLogField(field LogField) {
+	for i, existing := range r.LogFields {
+		if existing.Name == field.Name {
+			r.LogFields[i] = field
+			return
+		}
+	}
+	r.LogFields = append(r.LogFields, field)
+}
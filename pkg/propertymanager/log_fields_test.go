@@ -0,0 +1,79 @@
+package propertymanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProcessHTTPContext_LogFields(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{
+		NewRule("log").Behavior("log_fields",
+			"fields", "header:user-agent, cookie:session, variable:PMUSER_SEGMENT, geo:country, geo:city, tier=header:X-Tier",
+			"custom", "$(HTTP_HOST)$(HTTP_PATH)").Build(),
+		NewRule("override").PathEquals("/shop").Behavior("log_fields", "fields", "tier=cookie:tier").Build(),
+	}}}
+
+	context := &HTTPContext{
+		Method:    "GET",
+		Path:      "/shop",
+		Host:      "www.example.com",
+		Headers:   map[string]string{"User-Agent": "curl/8.4.0"},
+		Cookies:   map[string]string{"tier": "gold"},
+		Variables: map[string]string{"PMUSER_SEGMENT": "returning", "GEO_COUNTRY_CODE": "DE"},
+	}
+	result, err := pm.ProcessHTTPContext(context)
+	if err != nil {
+		t.Fatalf("ProcessHTTPContext failed: %v", err)
+	}
+	if len(result.Errors) != 0 {
+		t.Fatalf("Expected no errors, got %v", result.Errors)
+	}
+
+	expected := []LogField{
+		{Name: "header_user_agent", Value: "curl/8.4.0"},
+		{Name: "cookie_session", Value: ""},
+		{Name: "variable_pmuser_segment", Value: "returning"},
+		{Name: "geo_country", Value: "DE"},
+		{Name: "geo_city", Value: "San Francisco"},
+		{Name: "tier", Value: "gold"},
+		{Name: "custom", Value: "www.example.com/shop"},
+	}
+	if !reflect.DeepEqual(result.LogFields, expected) {
+		t.Errorf("Expected log fields %+v, got %+v", expected, result.LogFields)
+	}
+}
+
+func TestProcessRequest_LogFieldsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		message string
+	}{
+		{"no fields", []string{"fields", " "}, "fields or custom is required"},
+		{"missing name", []string{"fields", "header:"}, "invalid field"},
+		{"unknown kind", []string{"fields", "query:page"}, "unknown field kind"},
+		{"unknown geo field", []string{"fields", "geo:continent"}, "unknown geo field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				NewRule("log").Behavior("log_fields", tt.options...).Build(),
+			}}}
+
+			result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/"})
+			if err != nil {
+				t.Fatalf("ProcessHTTPContext failed: %v", err)
+			}
+			if len(result.LogFields) != 0 {
+				t.Errorf("Expected no log fields, got %+v", result.LogFields)
+			}
+			if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], tt.message) {
+				t.Errorf("Expected an error containing %q, got %v", tt.message, result.Errors)
+			}
+		})
+	}
+}
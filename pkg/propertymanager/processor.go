@@ -4,13 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/clienthints"
 )
 
 // processRules processes a list of rules recursively
 func (pm *PropertyManager) processRules(rules []Rule, context *HTTPContext, result *RuleResult) error {
 	for _, rule := range rules {
-		if pm.evaluateRule(&rule, context) {
+		if pm.evaluateRule(&rule, context, &result.Trace) {
 			if pm.Debug {
 				fmt.Printf("🔍 Rule matched: %s\n", rule.Name)
 			}
@@ -34,13 +37,15 @@ func (pm *PropertyManager) processRules(rules []Rule, context *HTTPContext, resu
 }
 
 // evaluateRule evaluates whether a rule should be executed based on its criteria
-func (pm *PropertyManager) evaluateRule(rule *Rule, context *HTTPContext) bool {
+func (pm *PropertyManager) evaluateRule(rule *Rule, context *HTTPContext, trace *RuleTrace) bool {
+	trace.RulesEvaluated++
 	if len(rule.Criteria) == 0 {
 		return true // No criteria means always match
 	}
 
 	// All criteria must match (AND logic)
 	for _, criterion := range rule.Criteria {
+		trace.CriteriaEvaluated++
 		if !pm.evaluateCriterion(&criterion, context) {
 			return false
 		}
@@ -62,14 +67,20 @@ func (pm *PropertyManager) evaluateCriterion(criterion *Criterion, context *HTTP
 		return pm.evaluateHostCriterion(criterion, context)
 	case "query":
 		return pm.evaluateQueryCriterion(criterion, context)
+	case "query_param":
+		return pm.evaluateQueryParamCriterion(criterion, context)
 	case "cookie":
 		return pm.evaluateCookieCriterion(criterion, context)
 	case "variable":
 		return pm.evaluateVariableCriterion(criterion, context)
 	case "client_ip":
 		return pm.evaluateClientIPCriterion(criterion, context)
+	case "true_client_ip":
+		return pm.evaluateTrueClientIPCriterion(criterion, context)
 	case "user_agent":
 		return pm.evaluateUserAgentCriterion(criterion, context)
+	case "device_brand", "device_mobile", "device_platform":
+		return pm.evaluateDeviceCriterion(criterion, context)
 	case "geo_country_code":
 		return pm.evaluateGeoCountryCodeCriterion(criterion, context)
 	case "geo_country_name":
@@ -78,6 +89,14 @@ func (pm *PropertyManager) evaluateCriterion(criterion *Criterion, context *HTTP
 		return pm.evaluateGeoRegionCriterion(criterion, context)
 	case "geo_city":
 		return pm.evaluateGeoCityCriterion(criterion, context)
+	case "body_content_type":
+		return pm.evaluateBodyContentTypeCriterion(criterion, context)
+	case "body_size":
+		return pm.evaluateBodySizeCriterion(criterion, context)
+	case "body_json":
+		return pm.evaluateBodyJSONCriterion(criterion, context)
+	case "experiment":
+		return pm.evaluateExperimentCriterion(criterion, context)
 	default:
 		if pm.Debug {
 			fmt.Printf("⚠️  Unknown criterion type: %s\n", criterion.Name)
@@ -103,8 +122,7 @@ func (pm *PropertyManager) evaluatePathCriterion(criterion *Criterion, context *
 	case "contains":
 		return strings.Contains(path, value)
 	case "regex":
-		matched, _ := regexp.MatchString(value, path)
-		return matched
+		return pm.matchRegex(value, path)
 	default:
 		return path == value // Default to equals
 	}
@@ -135,8 +153,7 @@ func (pm *PropertyManager) evaluateHeaderCriterion(criterion *Criterion, context
 	case "contains":
 		return strings.Contains(headerValue, value)
 	case "regex":
-		matched, _ := regexp.MatchString(value, headerValue)
-		return matched
+		return pm.matchRegex(value, headerValue)
 	default:
 		return headerValue == value // Default to equals
 	}
@@ -196,8 +213,7 @@ func (pm *PropertyManager) evaluateQueryCriterion(criterion *Criterion, context
 	case "contains":
 		return strings.Contains(query, value)
 	case "regex":
-		matched, _ := regexp.MatchString(value, query)
-		return matched
+		return pm.matchRegex(value, query)
 	default:
 		return query == value
 	}
@@ -263,7 +279,11 @@ func (pm *PropertyManager) evaluateVariableCriterion(criterion *Criterion, conte
 
 // evaluateClientIPCriterion evaluates client IP criteria
 func (pm *PropertyManager) evaluateClientIPCriterion(criterion *Criterion, context *HTTPContext) bool {
-	clientIP := context.ClientIP
+	return pm.evaluateIPCriterion(criterion, context.ClientIP)
+}
+
+// evaluateIPCriterion evaluates an IP address criterion against clientIP
+func (pm *PropertyManager) evaluateIPCriterion(criterion *Criterion, clientIP string) bool {
 	value := criterion.Value
 
 	switch criterion.Option {
@@ -282,28 +302,16 @@ func (pm *PropertyManager) evaluateClientIPCriterion(criterion *Criterion, conte
 	case "not_in":
 		return !pm.isIPInCIDR(clientIP, value)
 	case "regex":
-		matched, _ := regexp.MatchString(value, clientIP)
-		return matched
+		return pm.matchRegex(value, clientIP)
 	default:
 		return clientIP == value
 	}
 }
 
-// isIPInCIDR checks if an IP is in a CIDR range
+// isIPInCIDR checks if an IP is in a CIDR range, or one of a comma-separated list of
+// addresses and ranges
 func (pm *PropertyManager) isIPInCIDR(ip, cidr string) bool {
-	// Simple CIDR check implementation
-	// For production, consider using a proper IP parsing library
-	if strings.Contains(cidr, "/") {
-		parts := strings.Split(cidr, "/")
-		if len(parts) != 2 {
-			return false
-		}
-		network := parts[0]
-		// For simplicity, we'll do a basic prefix check
-		// In a real implementation, you'd want proper IP/CIDR parsing
-		return strings.HasPrefix(ip, network[:strings.LastIndex(network, ".")+1])
-	}
-	return ip == cidr
+	return ipInRanges(ip, pm.ipRanges(cidr))
 }
 
 // evaluateGeoCountryCodeCriterion evaluates geo country code criteria
@@ -468,13 +476,71 @@ func (pm *PropertyManager) evaluateUserAgentCriterion(criterion *Criterion, cont
 	case "contains":
 		return strings.Contains(userAgent, value)
 	case "regex":
-		matched, _ := regexp.MatchString(value, userAgent)
-		return matched
+		return pm.matchRegex(value, userAgent)
 	default:
 		return userAgent == value
 	}
 }
 
+// evaluateDeviceCriterion evaluates device criteria against the request's client hints,
+// falling back to its User-Agent for the hints it lacks. device_mobile is true or false.
+func (pm *PropertyManager) evaluateDeviceCriterion(criterion *Criterion, context *HTTPContext) bool {
+	headers := context.Headers
+	if context.UserAgent != "" {
+		headers = make(map[string]string, len(context.Headers)+1)
+		for key, value := range context.Headers {
+			headers[key] = value
+		}
+		headers["User-Agent"] = context.UserAgent
+	}
+	device := clienthints.FromMap(headers)
+
+	var actual string
+	switch criterion.Name {
+	case "device_brand":
+		actual = device.Brand
+	case "device_mobile":
+		actual = strconv.FormatBool(device.Mobile)
+	default:
+		actual = device.Platform
+	}
+	value := criterion.Value
+
+	if !criterion.Case {
+		actual = strings.ToLower(actual)
+		value = strings.ToLower(value)
+	}
+
+	switch criterion.Option {
+	case "equals":
+		return actual == value
+	case "not_equals":
+		return actual != value
+	case "contains":
+		return strings.Contains(actual, value)
+	case "in":
+		values := strings.Split(value, ",")
+		for _, v := range values {
+			if strings.TrimSpace(v) == actual {
+				return true
+			}
+		}
+		return false
+	case "not_in":
+		values := strings.Split(value, ",")
+		for _, v := range values {
+			if strings.TrimSpace(v) == actual {
+				return false
+			}
+		}
+		return true
+	case "regex":
+		return pm.matchRegex(value, actual)
+	default:
+		return actual == value
+	}
+}
+
 // executeBehaviors executes a list of behaviors
 func (pm *PropertyManager) executeBehaviors(behaviors []Behavior, context *HTTPContext, result *RuleResult) error {
 	for _, behavior := range behaviors {
@@ -499,12 +565,16 @@ func (pm *PropertyManager) executeBehavior(behavior *Behavior, context *HTTPCont
 		return pm.executeCache(behavior, context, result)
 	case "cache_bypass":
 		return pm.executeCacheBypass(behavior, context, result)
+	case "downstream_cache":
+		return pm.executeDownstreamCache(behavior, context, result)
 
 	// Security behaviors
 	case "access_control":
 		return pm.executeAccessControl(behavior, context, result)
 	case "rate_limit":
 		return pm.executeRateLimit(behavior, context, result)
+	case "true_client_ip":
+		return pm.executeTrueClientIP(behavior, context, result)
 
 	// Performance behaviors
 	case "compress":
@@ -515,8 +585,18 @@ func (pm *PropertyManager) executeBehavior(behavior *Behavior, context *HTTPCont
 	// Content behaviors
 	case "modify_headers":
 		return pm.executeModifyHeaders(behavior, context, result)
+	case "response_cookie":
+		return pm.executeResponseCookie(behavior, context, result)
 	case "url_rewrite":
 		return pm.executeURLRewrite(behavior, context, result)
+	case "modify_query_param":
+		return pm.executeModifyQueryParam(behavior, context, result)
+	case "rewrite_content":
+		return pm.executeRewriteContent(behavior, context, result)
+
+	// Response behaviors
+	case "construct_response":
+		return pm.executeConstructResponse(behavior, context, result)
 
 	// Redirect behaviors
 	case "redirect":
@@ -524,6 +604,10 @@ func (pm *PropertyManager) executeBehavior(behavior *Behavior, context *HTTPCont
 	case "conditional_redirect":
 		return pm.executeConditionalRedirect(behavior, context, result)
 
+	// Logging behaviors
+	case "log_fields":
+		return pm.executeLogFields(behavior, context, result)
+
 	// Legacy behaviors (for backward compatibility)
 	case "set_response_header":
 		return pm.executeSetResponseHeader(behavior, context, result)
@@ -566,6 +650,9 @@ func (pm *PropertyManager) executeCache(behavior *Behavior, context *HTTPContext
 	for key, value := range behavior.Options {
 		result.CacheSettings[key] = value
 	}
+	for _, option := range behavior.Option {
+		result.CacheSettings[option.Name] = option.Value
+	}
 
 	return nil
 }
@@ -606,7 +693,7 @@ func (pm *PropertyManager) executeAccessControl(behavior *Behavior, context *HTT
 			}
 		}
 		if !allowed {
-			return fmt.Errorf("access denied: IP %s not in allowed list", context.ClientIP)
+			return pm.deny(result, "access denied: IP %s not in allowed list", context.ClientIP)
 		}
 	}
 
@@ -615,7 +702,7 @@ func (pm *PropertyManager) executeAccessControl(behavior *Behavior, context *HTT
 		ips := strings.Split(blockedIPs, ",")
 		for _, ip := range ips {
 			if pm.isIPInCIDR(context.ClientIP, strings.TrimSpace(ip)) {
-				return fmt.Errorf("access denied: IP %s is blocked", context.ClientIP)
+				return pm.deny(result, "access denied: IP %s is blocked", context.ClientIP)
 			}
 		}
 	}
@@ -635,7 +722,7 @@ func (pm *PropertyManager) executeAccessControl(behavior *Behavior, context *HTT
 			}
 		}
 		if !allowed {
-			return fmt.Errorf("access denied: country %s not allowed", countryCode)
+			return pm.deny(result, "access denied: country %s not allowed", countryCode)
 		}
 	}
 
@@ -648,7 +735,7 @@ func (pm *PropertyManager) executeAccessControl(behavior *Behavior, context *HTT
 		countries := strings.Split(blockedCountries, ",")
 		for _, country := range countries {
 			if strings.TrimSpace(country) == countryCode {
-				return fmt.Errorf("access denied: country %s is blocked", countryCode)
+				return pm.deny(result, "access denied: country %s is blocked", countryCode)
 			}
 		}
 	}
@@ -656,6 +743,14 @@ func (pm *PropertyManager) executeAccessControl(behavior *Behavior, context *HTT
 	return nil
 }
 
+// deny marks the result as denied and returns the denial as an error
+func (pm *PropertyManager) deny(result *RuleResult, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	result.Denied = true
+	result.DenyReason = err.Error()
+	return err
+}
+
 // executeRateLimit executes rate limiting behavior
 func (pm *PropertyManager) executeRateLimit(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
 	if pm.Debug {
@@ -693,6 +788,9 @@ func (pm *PropertyManager) executeCompression(behavior *Behavior, context *HTTPC
 	for key, value := range behavior.Options {
 		result.CompressionSettings[key] = value
 	}
+	for _, option := range behavior.Option {
+		result.CompressionSettings[option.Name] = option.Value
+	}
 
 	return nil
 }
@@ -298,6 +298,50 @@ func TestProcessRequest_RedirectBehavior(t *testing.T) {
 	if !strings.Contains(result.ResponseContent, "/new") {
 		t.Error("Response content should contain redirect URL")
 	}
+	if result.RedirectLocation != "/new" {
+		t.Errorf("Expected RedirectLocation /new, got '%s'", result.RedirectLocation)
+	}
+	if result.RedirectStatus != 301 {
+		t.Errorf("Expected RedirectStatus 301, got %d", result.RedirectStatus)
+	}
+}
+
+func TestProcessRequest_AccessControlDenied(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{
+		Rules: Rules{Rule: []Rule{
+			{
+				Name: "block-ip",
+				Behaviors: []Behavior{
+					{Name: "access_control", Options: map[string]interface{}{"blocked_ips": "10.0.0.1"}},
+				},
+			},
+		}},
+	}
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "10.0.0.1"
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	if !result.Denied {
+		t.Fatal("Expected request to be denied")
+	}
+	if !strings.Contains(result.DenyReason, "10.0.0.1 is blocked") {
+		t.Errorf("Unexpected deny reason '%s'", result.DenyReason)
+	}
+
+	req, _ = http.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "10.0.0.2"
+	result, err = pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if result.Denied {
+		t.Error("Expected request not to be denied")
+	}
 }
 
 func TestProcessRequest_SetVariableBehavior(t *testing.T) {
@@ -726,3 +770,120 @@ func TestProcessRequest_EmptyProperty(t *testing.T) {
 		t.Errorf("Expected 0 executed behaviors, got %d", len(result.ExecutedBehaviors))
 	}
 }
+
+func TestProcessRequest_GeoOverride(t *testing.T) {
+	xmlData := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<property name="test-property" version="1">
+	<rules>
+		<rule name="germany">
+			<criteria name="geo_country_code" option="equals" value="DE"/>
+			<criteria name="geo_city" option="equals" value="Berlin"/>
+			<behaviors>
+				<behavior name="set_response_header">
+					<option name="header_name" value="X-Geo"/>
+					<option name="value" value="berlin"/>
+				</behavior>
+			</behaviors>
+		</rule>
+	</rules>
+</property>`)
+
+	pm := NewPropertyManager(false)
+	if err := pm.LoadProperty(xmlData); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Emulator-Geo-Country", "de")
+	req.Header.Set("X-Emulator-Geo-City", "Berlin")
+
+	// Disabled by default
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if len(result.MatchedRules) != 0 {
+		t.Errorf("Expected no matched rules without a geo header prefix, got %v", result.MatchedRules)
+	}
+
+	pm.GeoHeaderPrefix = "X-Emulator-Geo-"
+	result, err = pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if result.ModifiedHeaders["X-Geo"] != "berlin" {
+		t.Errorf("Expected header X-Geo=berlin, got '%s'", result.ModifiedHeaders["X-Geo"])
+	}
+}
+
+func TestProcessRequest_DeviceCriteria(t *testing.T) {
+	xmlData := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<property name="test-property" version="1">
+	<rules>
+		<rule name="mobile-android">
+			<criteria name="device_mobile" option="equals" value="true"/>
+			<criteria name="device_platform" option="in" value="Android, iOS"/>
+			<behaviors>
+				<behavior name="set_response_header">
+					<option name="header_name" value="X-Device"/>
+					<option name="value" value="mobile"/>
+				</behavior>
+			</behaviors>
+		</rule>
+	</rules>
+</property>`)
+
+	pm := NewPropertyManager(false)
+	if err := pm.LoadProperty(xmlData); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		matched bool
+	}{
+		{
+			name: "client hints",
+			headers: map[string]string{
+				"User-Agent":         "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+				"Sec-CH-UA-Mobile":   "?1",
+				"Sec-CH-UA-Platform": `"Android"`,
+			},
+			matched: true,
+		},
+		{
+			name: "client hints of a desktop",
+			headers: map[string]string{
+				"User-Agent":         "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) Mobile/15E148",
+				"Sec-CH-UA-Mobile":   "?0",
+				"Sec-CH-UA-Platform": `"Windows"`,
+			},
+			matched: false,
+		},
+		{
+			name: "user agent fallback",
+			headers: map[string]string{
+				"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			},
+			matched: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/test", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			result, err := pm.ProcessRequest(req)
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if matched := result.ModifiedHeaders["X-Device"] == "mobile"; matched != tt.matched {
+				t.Errorf("Expected matched=%v, got %v", tt.matched, matched)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package propertymanager
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// queryParam is a parameter of a query string as sent, its name and value still escaped
+type queryParam struct {
+	name  string
+	value string
+	bare  bool // Sent without =, such as debug in ?debug&page=2
+}
+
+// parseQueryParams splits a raw query string into its parameters, in order
+func parseQueryParams(query string) []queryParam {
+	var params []queryParam
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		params = append(params, queryParam{name: name, value: value, bare: !found})
+	}
+	return params
+}
+
+// encodeQueryParams joins parameters back into a raw query string
+func encodeQueryParams(params []queryParam) string {
+	pairs := make([]string, len(params))
+	for i, param := range params {
+		pairs[i] = param.name
+		if !param.bare {
+			pairs[i] += "=" + param.value
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// unescapeQuery decodes a query string component, keeping it as is when it is malformed
+func unescapeQuery(component string) string {
+	if unescaped, err := url.QueryUnescape(component); err == nil {
+		return unescaped
+	}
+	return component
+}
+
+// queryParamValues returns the decoded values of the parameter name in a raw query string
+func queryParamValues(query, name string) []string {
+	var values []string
+	for _, param := range parseQueryParams(query) {
+		if unescapeQuery(param.name) == name {
+			values = append(values, unescapeQuery(param.value))
+		}
+	}
+	return values
+}
+
+// evaluateQueryParamCriterion matches a single query parameter, named by the criterion's
+// option, against its value with the extract operator: equals (the default),
+// not_equals, starts_with, ends_with, contains, regex (case sensitive unless the
+// pattern has (?i)), exists or not_exists. A parameter given several times matches
+// when any of its values does; not_equals matches when none equals the value, or the
+// parameter is absent.
+func (pm *PropertyManager) evaluateQueryParamCriterion(criterion *Criterion, context *HTTPContext) bool {
+	values := queryParamValues(context.Query, criterion.Option)
+	switch criterion.Extract {
+	case "exists":
+		return len(values) > 0
+	case "not_exists":
+		return len(values) == 0
+	case "not_equals":
+		equals := *criterion
+		equals.Extract = "equals"
+		return !pm.evaluateQueryParamCriterion(&equals, context)
+	}
+
+	value := criterion.Value
+	if !criterion.Case {
+		value = strings.ToLower(value)
+	}
+	for _, paramValue := range values {
+		if criterion.Extract == "regex" {
+			// The pattern is used as written, so it can match the precompiled one
+			if pm.matchRegex(criterion.Value, paramValue) {
+				return true
+			}
+			continue
+		}
+		if !criterion.Case {
+			paramValue = strings.ToLower(paramValue)
+		}
+		var matched bool
+		switch criterion.Extract {
+		case "starts_with":
+			matched = strings.HasPrefix(paramValue, value)
+		case "ends_with":
+			matched = strings.HasSuffix(paramValue, value)
+		case "contains":
+			matched = strings.Contains(paramValue, value)
+		default:
+			matched = paramValue == value
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// executeModifyQueryParam changes the query string of the request forwarded to the
+// origin. Options: action, one of add (append a parameter, keeping any of the same
+// name), set (replace every parameter of the name, or append one), remove and rename;
+// name; value, with variables such as $(HTTP_HOST) expanded, for add and set; and
+// new_name for rename. Later criteria and behaviors see the changed query string.
+func (pm *PropertyManager) executeModifyQueryParam(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	action := strings.ToLower(pm.getBehaviorOption(behavior, "action"))
+	name := pm.getBehaviorOption(behavior, "name")
+	if name == "" {
+		return fmt.Errorf("modify query param: name is required")
+	}
+	value := url.QueryEscape(pm.expandVariables(pm.getBehaviorOption(behavior, "value"), context))
+
+	params := parseQueryParams(context.Query)
+	var kept []queryParam
+	switch action {
+	case "add":
+		kept = append(params, queryParam{name: url.QueryEscape(name), value: value})
+	case "set":
+		replaced := false
+		for _, param := range params {
+			if unescapeQuery(param.name) != name {
+				kept = append(kept, param)
+			} else if !replaced {
+				kept = append(kept, queryParam{name: param.name, value: value})
+				replaced = true
+			}
+		}
+		if !replaced {
+			kept = append(kept, queryParam{name: url.QueryEscape(name), value: value})
+		}
+	case "remove":
+		for _, param := range params {
+			if unescapeQuery(param.name) != name {
+				kept = append(kept, param)
+			}
+		}
+	case "rename":
+		newName := pm.getBehaviorOption(behavior, "new_name")
+		if newName == "" {
+			return fmt.Errorf("modify query param: rename requires a new_name")
+		}
+		for _, param := range params {
+			if unescapeQuery(param.name) == name {
+				param.name = url.QueryEscape(newName)
+			}
+			kept = append(kept, param)
+		}
+	default:
+		return fmt.Errorf("modify query param: unknown action %q", action)
+	}
+
+	query := encodeQueryParams(kept)
+	if query != context.Query {
+		context.Query = query
+		result.RewrittenQuery = query
+	}
+	if pm.Debug {
+		fmt.Printf("🔧 Modify query param: %s %s -> ?%s\n", action, name, query)
+	}
+	return nil
+}
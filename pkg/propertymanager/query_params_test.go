@@ -0,0 +1,166 @@
+package propertymanager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProcessHTTPContext_QueryParamCriterion(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		param    string
+		value    string
+		query    string
+		expected bool
+	}{
+		{"equals", "equals", "utm_source", "newsletter", "utm_source=newsletter&page=2", true},
+		{"equals is not contains", "equals", "page", "2", "page=20", false},
+		{"equals ignores case", "equals", "utm_source", "Newsletter", "utm_source=NEWSLETTER", true},
+		{"equals decodes value", "equals", "q", "red shoes", "q=red+shoes", true},
+		{"default operator", "", "sort", "price", "sort=price", true},
+		{"any repeated value", "equals", "tag", "b", "tag=a&tag=b", true},
+		{"other parameter value", "equals", "sort", "price", "order=price", false},
+		{"not equals", "not_equals", "ab", "b", "ab=a", true},
+		{"not equals any repeated value", "not_equals", "tag", "b", "tag=a&tag=b", false},
+		{"not equals absent", "not_equals", "ab", "b", "", true},
+		{"starts with", "starts_with", "utm_campaign", "spring", "utm_campaign=spring_sale", true},
+		{"ends with", "ends_with", "file", ".pdf", "file=report.pdf", true},
+		{"contains", "contains", "q", "shoe", "q=red+shoes", true},
+		{"regex", "regex", "id", `^\d+$`, "id=123", true},
+		{"regex no match", "regex", "id", `^\d+$`, "id=12a", false},
+		{"exists", "exists", "debug", "", "page=1&debug", true},
+		{"exists absent", "exists", "debug", "", "page=1&debugger=1", false},
+		{"not exists", "not_exists", "debug", "", "page=1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				NewRule("param").QueryParam(tt.param, tt.operator, tt.value).Build(),
+			}}}
+
+			result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/", Query: tt.query})
+			if err != nil {
+				t.Fatalf("ProcessHTTPContext failed: %v", err)
+			}
+			if matched := len(result.MatchedRules) == 1; matched != tt.expected {
+				t.Errorf("Expected matched=%v for %s %q on ?%s, got %v", tt.expected, tt.operator, tt.value, tt.query, matched)
+			}
+		})
+	}
+}
+
+func TestProcessHTTPContext_QueryParamCriterionCaseSensitive(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{
+		{Name: "param", Criteria: []Criterion{{Name: "query_param", Option: "sort", Value: "Price", Extract: "equals", Case: true}}},
+	}}}
+
+	for query, expected := range map[string]bool{"sort=Price": true, "sort=price": false} {
+		result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/", Query: query})
+		if err != nil {
+			t.Fatalf("ProcessHTTPContext failed: %v", err)
+		}
+		if matched := len(result.MatchedRules) == 1; matched != expected {
+			t.Errorf("Expected matched=%v on ?%s, got %v", expected, query, matched)
+		}
+	}
+}
+
+func TestProcessHTTPContext_ModifyQueryParam(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []string
+		query    string
+		expected string
+	}{
+		{"add", []string{"action", "add", "name", "src", "value", "edge"}, "page=2", "page=2&src=edge"},
+		{"add keeps existing", []string{"action", "add", "name", "tag", "value", "b"}, "tag=a", "tag=a&tag=b"},
+		{"add to empty query", []string{"action", "add", "name", "src", "value", "edge"}, "", "src=edge"},
+		{"add escapes value", []string{"action", "add", "name", "host", "value", "$(HTTP_HOST) 1"}, "", "host=www.example.com+1"},
+		{"set replaces every value", []string{"action", "set", "name", "tag", "value", "c"}, "tag=a&page=2&tag=b", "tag=c&page=2"},
+		{"set appends", []string{"action", "set", "name", "tag", "value", "c"}, "page=2", "page=2&tag=c"},
+		{"remove", []string{"action", "remove", "name", "utm_source"}, "utm_source=x&page=2&utm_source=y", "page=2"},
+		{"remove bare", []string{"action", "remove", "name", "debug"}, "debug&page=2", "page=2"},
+		{"remove encoded name", []string{"action", "remove", "name", "a b"}, "a+b=1&c=2", "c=2"},
+		{"rename", []string{"action", "rename", "name", "q", "new_name", "search"}, "q=red+shoes&page=2", "search=red+shoes&page=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				NewRule("query").Behavior("modify_query_param", tt.options...).Build(),
+			}}}
+
+			context := &HTTPContext{Method: "GET", Path: "/", Host: "www.example.com", Query: tt.query}
+			result, err := pm.ProcessHTTPContext(context)
+			if err != nil {
+				t.Fatalf("ProcessHTTPContext failed: %v", err)
+			}
+			if len(result.Errors) != 0 {
+				t.Fatalf("Expected no errors, got %v", result.Errors)
+			}
+			if result.RewrittenQuery != tt.expected {
+				t.Errorf("Expected rewritten query %q, got %q", tt.expected, result.RewrittenQuery)
+			}
+			if context.Query != tt.expected {
+				t.Errorf("Expected the context query to be %q, got %q", tt.expected, context.Query)
+			}
+		})
+	}
+}
+
+func TestProcessHTTPContext_ModifyQueryParamLaterRules(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{
+		NewRule("rename").Behavior("modify_query_param", "action", "rename", "name", "s", "new_name", "sort").Build(),
+		NewRule("sorted").QueryParam("sort", "equals", "price").Behavior("modify_query_param", "action", "remove", "name", "page").Build(),
+		NewRule("unchanged").Behavior("modify_query_param", "action", "remove", "name", "missing").Build(),
+	}}}
+
+	result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/", Query: "s=price&page=1"})
+	if err != nil {
+		t.Fatalf("ProcessHTTPContext failed: %v", err)
+	}
+	if len(result.MatchedRules) != 3 {
+		t.Errorf("Expected the renamed parameter to match the second rule, got %v", result.MatchedRules)
+	}
+	if result.RewrittenQuery != "sort=price" {
+		t.Errorf("Expected rewritten query %q, got %q", "sort=price", result.RewrittenQuery)
+	}
+}
+
+func TestProcessRequest_ModifyQueryParamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		message string
+	}{
+		{"missing name", []string{"action", "add", "value", "x"}, "name is required"},
+		{"unknown action", []string{"action", "append", "name", "a"}, "unknown action"},
+		{"rename without new name", []string{"action", "rename", "name", "a"}, "requires a new_name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				NewRule("query").Behavior("modify_query_param", tt.options...).Build(),
+			}}}
+
+			result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/", Query: "a=1"})
+			if err != nil {
+				t.Fatalf("ProcessHTTPContext failed: %v", err)
+			}
+			if result.RewrittenQuery != "" {
+				t.Errorf("Expected the query to be left alone, got %q", result.RewrittenQuery)
+			}
+			if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], tt.message) {
+				t.Errorf("Expected an error containing %q, got %v", tt.message, result.Errors)
+			}
+		})
+	}
+}
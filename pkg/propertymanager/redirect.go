@@ -0,0 +1,135 @@
+package propertymanager
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// redirectStatusCodes are the status codes the redirect behavior answers with
+var redirectStatusCodes = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// redirectTemplatePattern matches the {{name}} placeholders of redirect destinations
+var redirectTemplatePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.]+)\s*\}\}`)
+
+// executeRedirect performs a redirect. Options: destination, a template whose
+// {{path}}, {{query}}, {{host}}, {{scheme}} and {{user.PMUSER_NAME}} placeholders are
+// filled from the request; status_code (301, 302, 307 or 308, 302 by default); and
+// query_string, "preserve" to merge the request's query string into the destination's.
+func (pm *PropertyManager) executeRedirect(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	destination := pm.getBehaviorOption(behavior, "destination")
+	if destination == "" {
+		return nil
+	}
+
+	statusCode := http.StatusFound
+	if code := pm.getBehaviorOption(behavior, "status_code"); code != "" {
+		parsed, err := strconv.Atoi(code)
+		if err != nil || !redirectStatusCodes[parsed] {
+			return fmt.Errorf("redirect: status_code must be 301, 302, 307 or 308, got %q", code)
+		}
+		statusCode = parsed
+	}
+
+	redirectURL := expandRedirectTemplate(destination, context)
+	if pm.getBehaviorOption(behavior, "query_string") == "preserve" {
+		redirectURL = mergeQuery(redirectURL, context.Query)
+	}
+
+	escaped := html.EscapeString(redirectURL)
+	result.ResponseContent = fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Redirecting...</title>
+    <meta http-equiv="refresh" content="0;url=%s">
+</head>
+<body>
+    <p>Redirecting to <a href="%s">%s</a>...</p>
+</body>
+</html>`, escaped, escaped, escaped)
+
+	result.ModifiedHeaders["Location"] = redirectURL
+	result.ModifiedHeaders["Status"] = strconv.Itoa(statusCode)
+	result.RedirectLocation = redirectURL
+	result.RedirectStatus = statusCode
+
+	if pm.Debug {
+		fmt.Printf("🔄 Redirect: %s (Status: %d)\n", redirectURL, statusCode)
+	}
+	return nil
+}
+
+// expandRedirectTemplate fills the placeholders of a redirect destination from the
+// request. PMUSER variables can be named with or without the user. prefix; unknown
+// placeholders expand to nothing.
+func expandRedirectTemplate(destination string, context *HTTPContext) string {
+	return redirectTemplatePattern.ReplaceAllStringFunc(destination, func(match string) string {
+		name := redirectTemplatePattern.FindStringSubmatch(match)[1]
+		switch strings.ToLower(name) {
+		case "path":
+			return context.Path
+		case "query":
+			return context.Query
+		case "host":
+			return context.Host
+		case "scheme":
+			if context.Request != nil && context.Request.TLS != nil {
+				return "https"
+			}
+			return "http"
+		}
+		return context.Variables[strings.TrimPrefix(name, "user.")]
+	})
+}
+
+// mergeQuery appends the parameters of query to the query string of target. The
+// target's query is kept as written, and parameters it sets itself keep its values.
+func mergeQuery(target, query string) string {
+	if query == "" {
+		return target
+	}
+
+	base, fragment, hasFragment := strings.Cut(target, "#")
+	_, targetQuery, _ := strings.Cut(base, "?")
+	params, err := url.ParseQuery(targetQuery)
+	if err != nil {
+		return target
+	}
+
+	var appended []string
+	for _, pair := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(name)
+		if pair == "" || err != nil {
+			continue
+		}
+		if _, set := params[name]; !set {
+			appended = append(appended, pair)
+		}
+	}
+	if len(appended) == 0 {
+		return target
+	}
+
+	merged := base
+	switch {
+	case !strings.Contains(base, "?"):
+		merged += "?"
+	case targetQuery != "":
+		merged += "&"
+	}
+	merged += strings.Join(appended, "&")
+	if hasFragment {
+		merged += "#" + fragment
+	}
+	return merged
+}
@@ -0,0 +1,159 @@
+package propertymanager
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProcessRequest_RedirectStatusCodes(t *testing.T) {
+	for _, code := range []string{"301", "302", "307", "308", ""} {
+		t.Run("status "+code, func(t *testing.T) {
+			options := []BehaviorOption{{Name: "destination", Value: "/new"}}
+			if code != "" {
+				options = append(options, BehaviorOption{Name: "status_code", Value: code})
+			}
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				{Name: "redirect", Behaviors: []Behavior{{Name: "redirect", Option: options}}},
+			}}}
+
+			req, _ := http.NewRequest("GET", "/old", nil)
+			result, err := pm.ProcessRequest(req)
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+
+			expected := code
+			if expected == "" {
+				expected = "302"
+			}
+			if strconv.Itoa(result.RedirectStatus) != expected {
+				t.Errorf("Expected RedirectStatus %s, got %d", expected, result.RedirectStatus)
+			}
+			if result.ModifiedHeaders["Status"] != expected {
+				t.Errorf("Expected Status header %s, got '%s'", expected, result.ModifiedHeaders["Status"])
+			}
+		})
+	}
+}
+
+func TestProcessRequest_RedirectInvalidStatusCode(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{
+		{Name: "redirect", Behaviors: []Behavior{{Name: "redirect", Option: []BehaviorOption{
+			{Name: "destination", Value: "/new"},
+			{Name: "status_code", Value: "303"},
+		}}}},
+	}}}
+
+	req, _ := http.NewRequest("GET", "/old", nil)
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if result.RedirectLocation != "" {
+		t.Errorf("Expected no redirect, got '%s'", result.RedirectLocation)
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "status_code must be 301, 302, 307 or 308") {
+		t.Errorf("Expected a status code error, got %v", result.Errors)
+	}
+}
+
+func TestProcessRequest_RedirectTemplate(t *testing.T) {
+	xmlData := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<property name="test-property" version="1">
+	<rules>
+		<rule name="market">
+			<behaviors>
+				<behavior name="set_variable">
+					<option name="variable_name" value="PMUSER_MARKET"/>
+					<option name="value" value="de"/>
+				</behavior>
+				<behavior name="redirect">
+					<option name="destination" value="https://{{host}}/{{user.PMUSER_MARKET}}{{path}}?src={{ PMUSER_MARKET }}"/>
+					<option name="status_code" value="308"/>
+				</behavior>
+			</behaviors>
+		</rule>
+	</rules>
+</property>`)
+
+	pm := NewPropertyManager(false)
+	if err := pm.LoadProperty(xmlData); err != nil {
+		t.Fatalf("LoadProperty failed: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/shop/cart?id=7", nil)
+	req.Host = "www.example.com"
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	expected := "https://www.example.com/de/shop/cart?src=de"
+	if result.RedirectLocation != expected {
+		t.Errorf("Expected RedirectLocation %s, got '%s'", expected, result.RedirectLocation)
+	}
+	if result.RedirectStatus != 308 {
+		t.Errorf("Expected RedirectStatus 308, got %d", result.RedirectStatus)
+	}
+}
+
+func TestProcessRequest_RedirectQueryString(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		queryString string
+		expected    string
+	}{
+		{name: "dropped by default", destination: "/new", expected: "/new"},
+		{name: "query placeholder", destination: "/new?{{query}}", expected: "/new?a=1&b=2"},
+		{name: "preserved", destination: "/new", queryString: "preserve", expected: "/new?a=1&b=2"},
+		{name: "merged", destination: "/new?b=9&c=3#top", queryString: "preserve", expected: "/new?b=9&c=3&a=1#top"},
+		{name: "destination order kept", destination: "/new?z=%7e&b=2&a=1", queryString: "preserve", expected: "/new?z=%7e&b=2&a=1"},
+		{name: "appended in request order", destination: "/new?c=3&b=9", queryString: "preserve", expected: "/new?c=3&b=9&a=1"},
+		{name: "empty destination query", destination: "/new?", queryString: "preserve", expected: "/new?a=1&b=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := []BehaviorOption{{Name: "destination", Value: tt.destination}}
+			if tt.queryString != "" {
+				options = append(options, BehaviorOption{Name: "query_string", Value: tt.queryString})
+			}
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				{Name: "redirect", Behaviors: []Behavior{{Name: "redirect", Option: options}}},
+			}}}
+
+			req, _ := http.NewRequest("GET", "/old?a=1&b=2", nil)
+			result, err := pm.ProcessRequest(req)
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			if result.RedirectLocation != tt.expected {
+				t.Errorf("Expected RedirectLocation %s, got '%s'", tt.expected, result.RedirectLocation)
+			}
+		})
+	}
+}
+
+func TestProcessRequest_RedirectBodyEscaped(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{
+		{Name: "redirect", Behaviors: []Behavior{{Name: "redirect", Option: []BehaviorOption{
+			{Name: "destination", Value: "/new?{{query}}"},
+		}}}},
+	}}}
+
+	req, _ := http.NewRequest("GET", `/old?q="><script>`, nil)
+	result, err := pm.ProcessRequest(req)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if strings.Contains(result.ResponseContent, "<script>") {
+		t.Errorf("Expected the destination to be escaped in the body, got %s", result.ResponseContent)
+	}
+}
@@ -0,0 +1,167 @@
+package propertymanager
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseCookie is a cookie the response_cookie behavior sets, rewrites or deletes on
+// the response to the client
+type ResponseCookie struct {
+	Action   string // set, rewrite or delete
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	SameSite string // Strict, Lax or None; empty leaves it to the browser
+	Secure   bool
+	HTTPOnly bool
+	// MaxAge is the lifetime in seconds, with Expires the matching date; zero makes a
+	// session cookie and deleted cookies have a negative MaxAge
+	MaxAge  int
+	Expires time.Time
+}
+
+// cookieSameSite maps the same_site option to the SameSite attribute
+var cookieSameSite = map[string]http.SameSite{
+	"strict": http.SameSiteStrictMode,
+	"lax":    http.SameSiteLaxMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// executeResponseCookie sets, rewrites or deletes a response cookie. Options: action
+// (set by default, rewrite to change the value of a cookie the request carries, or
+// delete), name, value with variables such as $(HTTP_HOST) expanded and, for rewrite,
+// $(COOKIE_VALUE) standing for the cookie's current value, domain, path (/ by default),
+// same_site (strict, lax or none, which requires secure), secure and http_only ("true")
+// and max_age (seconds or a duration such as 30d; a session cookie by default).
+func (pm *PropertyManager) executeResponseCookie(behavior *Behavior, context *HTTPContext, result *RuleResult) error {
+	action := strings.ToLower(pm.getBehaviorOption(behavior, "action"))
+	if action == "" {
+		action = "set"
+	}
+	name := pm.getBehaviorOption(behavior, "name")
+	if name == "" {
+		return fmt.Errorf("response cookie: name is required")
+	}
+
+	cookie := ResponseCookie{
+		Action:   action,
+		Name:     name,
+		Domain:   pm.getBehaviorOption(behavior, "domain"),
+		Path:     pm.getBehaviorOption(behavior, "path"),
+		Secure:   pm.getBehaviorOption(behavior, "secure") == "true",
+		HTTPOnly: pm.getBehaviorOption(behavior, "http_only") == "true",
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if sameSite := pm.getBehaviorOption(behavior, "same_site"); sameSite != "" {
+		if _, ok := cookieSameSite[strings.ToLower(sameSite)]; !ok {
+			return fmt.Errorf("response cookie: unknown same_site %q", sameSite)
+		}
+		cookie.SameSite = strings.ToUpper(sameSite[:1]) + strings.ToLower(sameSite[1:])
+		if cookie.SameSite == "None" && !cookie.Secure {
+			return fmt.Errorf("response cookie: same_site none requires secure")
+		}
+	}
+
+	now := context.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	switch action {
+	case "set", "rewrite":
+		cookie.Value = pm.expandVariables(pm.getBehaviorOption(behavior, "value"), context)
+		if action == "rewrite" {
+			current, ok := context.Cookies[name]
+			if !ok {
+				return nil
+			}
+			cookie.Value = strings.ReplaceAll(cookie.Value, "$(COOKIE_VALUE)", current)
+		}
+		if maxAge := pm.getBehaviorOption(behavior, "max_age"); maxAge != "" {
+			lifetime, err := parseCookieMaxAge(maxAge)
+			if err != nil {
+				return err
+			}
+			cookie.MaxAge = int(lifetime.Seconds())
+			cookie.Expires = now.Add(lifetime).UTC()
+		}
+	case "delete":
+		cookie.MaxAge = -1
+		cookie.Expires = time.Unix(0, 0).UTC()
+	default:
+		return fmt.Errorf("response cookie: unknown action %q", action)
+	}
+
+	result.setCookie(cookie)
+	if pm.Debug {
+		fmt.Printf("🍪 Response cookie: %s %s\n", action, cookie)
+	}
+	return nil
+}
+
+// parseCookieMaxAge parses the max_age option of response_cookie: whole seconds or a
+// duration such as 30m, with d for days
+func parseCookieMaxAge(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if count, err := strconv.Atoi(days); err == nil && count > 0 {
+			return time.Duration(count) * 24 * time.Hour, nil
+		}
+		return 0, fmt.Errorf("response cookie: invalid max_age %q", value)
+	}
+	lifetime, err := parseDownstreamTTL(value)
+	if err != nil || lifetime <= 0 {
+		return 0, fmt.Errorf("response cookie: invalid max_age %q", value)
+	}
+	return lifetime, nil
+}
+
+// setCookie records a response cookie, replacing an earlier one of the same name,
+// domain and path, as a later Set-Cookie would in the browser
+func (r *RuleResult) setCookie(cookie ResponseCookie) {
+	for i, existing := range r.Cookies {
+		if existing.Name == cookie.Name && existing.Domain == cookie.Domain && existing.Path == cookie.Path {
+			r.Cookies[i] = cookie
+			return
+		}
+	}
+	r.Cookies = append(r.Cookies, cookie)
+}
+
+// String renders the cookie as the value of a Set-Cookie header
+func (c ResponseCookie) String() string {
+	cookie := &http.Cookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Domain:   c.Domain,
+		Path:     c.Path,
+		Secure:   c.Secure,
+		HttpOnly: c.HTTPOnly,
+		MaxAge:   c.MaxAge,
+		Expires:  c.Expires,
+		SameSite: cookieSameSite[strings.ToLower(c.SameSite)],
+	}
+	return cookie.String()
+}
+
+// SetCookieHeaders returns the Set-Cookie header values of the response cookies, in the
+// order they were first set
+func (r *RuleResult) SetCookieHeaders() []string {
+	headers := make([]string, len(r.Cookies))
+	for i, cookie := range r.Cookies {
+		headers[i] = cookie.String()
+	}
+	return headers
+}
+
+// ApplyResponseCookies carries the response cookies of result over to responseResult
+func ApplyResponseCookies(result, responseResult *RuleResult) {
+	for _, cookie := range result.Cookies {
+		responseResult.setCookie(cookie)
+	}
+}
@@ -0,0 +1,124 @@
+package propertymanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessHTTPContext_ResponseCookie(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		options []BehaviorOption
+		cookies map[string]string
+		want    []string
+	}{
+		{
+			name: "set with attributes",
+			options: []BehaviorOption{
+				{Name: "name", Value: "visit"}, {Name: "value", Value: "$(HTTP_HOST)"}, {Name: "domain", Value: "example.com"},
+				{Name: "same_site", Value: "none"}, {Name: "secure", Value: "true"}, {Name: "http_only", Value: "true"}, {Name: "max_age", Value: "1h"},
+			},
+			want: []string{"visit=www.example.com; Path=/; Domain=example.com; Expires=Wed, 01 May 2024 13:00:00 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=None"},
+		},
+		{
+			name:    "session cookie",
+			options: []BehaviorOption{{Name: "action", Value: "set"}, {Name: "name", Value: "ab"}, {Name: "value", Value: "b"}, {Name: "path", Value: "/shop"}, {Name: "same_site", Value: "Lax"}},
+			want:    []string{"ab=b; Path=/shop; SameSite=Lax"},
+		},
+		{
+			name:    "rewrite",
+			options: []BehaviorOption{{Name: "action", Value: "rewrite"}, {Name: "name", Value: "prefs"}, {Name: "value", Value: "v2-$(COOKIE_VALUE)"}, {Name: "max_age", Value: "30d"}},
+			cookies: map[string]string{"prefs": "dark"},
+			want:    []string{"prefs=v2-dark; Path=/; Expires=Fri, 31 May 2024 12:00:00 GMT; Max-Age=2592000"},
+		},
+		{
+			name:    "rewrite without the cookie",
+			options: []BehaviorOption{{Name: "action", Value: "rewrite"}, {Name: "name", Value: "prefs"}, {Name: "value", Value: "v2"}},
+			want:    []string{},
+		},
+		{
+			name:    "delete",
+			options: []BehaviorOption{{Name: "action", Value: "DELETE"}, {Name: "name", Value: "session"}},
+			want:    []string{"session=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				{Name: "cookies", Behaviors: []Behavior{{Name: "response_cookie", Option: tt.options}}},
+			}}}
+
+			result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/", Host: "www.example.com", Cookies: tt.cookies, Timestamp: now})
+			if err != nil {
+				t.Fatalf("ProcessHTTPContext failed: %v", err)
+			}
+			if len(result.Errors) != 0 {
+				t.Fatalf("Expected no errors, got %v", result.Errors)
+			}
+			if got := result.SetCookieHeaders(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected Set-Cookie %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProcessHTTPContext_ResponseCookieReplaced(t *testing.T) {
+	pm := NewPropertyManager(false)
+	pm.Property = &Property{Rules: Rules{Rule: []Rule{
+		NewRule("first").Behavior("response_cookie", "name", "a", "value", "1").Behavior("response_cookie", "name", "b", "value", "2").Build(),
+		NewRule("second").Behavior("response_cookie", "name", "a", "action", "delete").Build(),
+	}}}
+
+	result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/"})
+	if err != nil {
+		t.Fatalf("ProcessHTTPContext failed: %v", err)
+	}
+	if len(result.Cookies) != 2 || result.Cookies[0].Action != "delete" || result.Cookies[1].Name != "b" {
+		t.Errorf("Expected the later behavior to replace cookie a in place, got %+v", result.Cookies)
+	}
+
+	responseResult := &RuleResult{}
+	ApplyResponseCookies(result, responseResult)
+	if !reflect.DeepEqual(responseResult.Cookies, result.Cookies) {
+		t.Errorf("Expected the cookies to carry over, got %+v", responseResult.Cookies)
+	}
+}
+
+func TestProcessRequest_ResponseCookieErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []BehaviorOption
+		message string
+	}{
+		{"missing name", []BehaviorOption{{Name: "value", Value: "x"}}, "name is required"},
+		{"unknown action", []BehaviorOption{{Name: "name", Value: "a"}, {Name: "action", Value: "append"}}, "unknown action"},
+		{"unknown same site", []BehaviorOption{{Name: "name", Value: "a"}, {Name: "same_site", Value: "loose"}}, "unknown same_site"},
+		{"insecure same site none", []BehaviorOption{{Name: "name", Value: "a"}, {Name: "same_site", Value: "none"}}, "requires secure"},
+		{"invalid max age", []BehaviorOption{{Name: "name", Value: "a"}, {Name: "max_age", Value: "xd"}}, "invalid max_age"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPropertyManager(false)
+			pm.Property = &Property{Rules: Rules{Rule: []Rule{
+				{Name: "cookies", Behaviors: []Behavior{{Name: "response_cookie", Option: tt.options}}},
+			}}}
+
+			result, err := pm.ProcessHTTPContext(&HTTPContext{Method: "GET", Path: "/"})
+			if err != nil {
+				t.Fatalf("ProcessHTTPContext failed: %v", err)
+			}
+			if len(result.Cookies) != 0 {
+				t.Errorf("Expected no cookies, got %+v", result.Cookies)
+			}
+			if len(result.Errors) != 1 || !strings.Contains(result.Errors[0], tt.message) {
+				t.Errorf("Expected an error containing %q, got %v", tt.message, result.Errors)
+			}
+		})
+	}
+}
@@ -3,7 +3,11 @@ package propertymanager
 import (
 	"encoding/xml"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/experiment"
 )
 
 // Property represents an Akamai property configuration
@@ -30,6 +34,7 @@ type Rule struct {
 	Comment   string      `xml:"comment,attr,omitempty"`
 	Start     string      `xml:"start,attr,omitempty"`
 	End       string      `xml:"end,attr,omitempty"`
+	Include   string      `xml:"include,attr,omitempty"` // Rule snippet file merged into Children at load time
 	Criteria  []Criterion `xml:"criteria"`
 	Behaviors []Behavior  `xml:"behaviors>behavior"`
 	Children  []Rule      `xml:"children>rule,omitempty"`
@@ -92,9 +97,18 @@ type HTTPContext struct {
 	Method    string
 	Host      string
 	Query     string
-	ClientIP  string
+	ClientIP  string // Effective client IP, resolved through PropertyManager.TrustedProxies
 	UserAgent string
 	Timestamp time.Time
+
+	// TrueClientIP is the True-Client-IP set by the true_client_ip behavior
+	TrueClientIP string
+
+	// Body holds up to MaxBodyInspectBytes of the request body for the body criteria;
+	// BodySize is the full size and BodyTruncated is set when Body was cut off
+	Body          string
+	BodySize      int64
+	BodyTruncated bool
 }
 
 // RuleResult represents the result of rule processing
@@ -112,6 +126,27 @@ type RuleResult struct {
 	RedirectLocation          string
 	RedirectStatus            int
 	RewrittenURL              string
+	// RewrittenQuery is the query string forwarded to the origin, without the leading ?,
+	// once modify_query_param has changed it
+	RewrittenQuery string
+	Denied         bool
+	DenyReason     string
+	// ContentRewrites are applied to the response body in the response phase, which
+	// counts their replacements in ContentReplacements
+	ContentRewrites     []ContentRewrite
+	ContentReplacements int
+	// ConstructedResponse answers the request at the edge; set by construct_response
+	ConstructedResponse *ConstructedResponse
+	// DownstreamCache is the client cacheability set by downstream_cache
+	DownstreamCache *DownstreamCache
+	// Cookies are the response cookies set, rewritten or deleted by response_cookie
+	Cookies []ResponseCookie
+	// LogFields are the custom access log fields selected by log_fields
+	LogFields []LogField
+	// ESI is the ESI processing configured by the esi behavior
+	ESI *ESISettings
+	// Trace reports the rules and criteria evaluated and the time it took
+	Trace RuleTrace
 }
 
 // PropertyManager represents the main property manager emulator
@@ -121,6 +156,31 @@ type PropertyManager struct {
 	Rules     map[string]*Rule
 	Behaviors map[string]*Behavior
 	Variables map[string]string
+	// GeoHeaderPrefix enables request headers such as X-Emulator-Geo-Country to override the
+	// GEO_* variables of geo criteria; empty disables the override
+	GeoHeaderPrefix string
+	// MaxBodyInspectBytes limits how much of a request body the body criteria read;
+	// zero selects DefaultMaxBodyInspectBytes
+	MaxBodyInspectBytes int
+	// TrustedProxies lists the IPs and CIDR ranges of proxies whose X-Forwarded-For hops
+	// are trusted when resolving the client IP; empty uses the connecting address
+	TrustedProxies []string
+	// TemplateDir is the directory relative template_file paths of construct_response
+	// are read from
+	TemplateDir string
+	// Experiments are the A/B experiments experiment criteria match the variants of
+	Experiments []experiment.Experiment
+
+	compiled *compiledCriteria
+}
+
+// geoOverrideHeaders maps the names, after GeoHeaderPrefix, of the geo override headers
+// to the variables they set
+var geoOverrideHeaders = map[string]string{
+	"Country":      "GEO_COUNTRY_CODE",
+	"Country-Name": "GEO_COUNTRY_NAME",
+	"Region":       "GEO_REGION",
+	"City":         "GEO_CITY",
 }
 
 // NewPropertyManager creates a new PropertyManager instance
@@ -133,25 +193,56 @@ func NewPropertyManager(debug bool) *PropertyManager {
 	}
 }
 
-// LoadProperty loads a property configuration from XML
+// LoadProperty loads a property configuration from XML. Rule snippets it includes are
+// resolved relative to the working directory; see LoadPropertyFile.
 func (pm *PropertyManager) LoadProperty(xmlData []byte) error {
+	return pm.loadProperty(xmlData, "")
+}
+
+// loadProperty loads a property read from path, empty for XML not read from a file
+func (pm *PropertyManager) loadProperty(xmlData []byte, path string) error {
+	property, err := parseProperty(xmlData, path)
+	if err != nil {
+		return err
+	}
+	pm.UseProperty(property)
+	return nil
+}
+
+// parseProperty parses a property read from path, resolving its includes
+func parseProperty(xmlData []byte, path string) (*Property, error) {
 	var property Property
 	if err := xml.Unmarshal(xmlData, &property); err != nil {
-		return err
+		return nil, err
 	}
 
-	pm.Property = &property
+	var chain []string
+	if path != "" {
+		if absolute, err := filepath.Abs(path); err == nil {
+			path = absolute
+		}
+		chain = []string{path}
+	}
+	if err := resolveIncludes(property.Rules.Rule, filepath.Dir(path), chain); err != nil {
+		return nil, err
+	}
+	return &property, nil
+}
+
+// UseProperty makes property, loaded or built in code, the property requests are
+// processed with
+func (pm *PropertyManager) UseProperty(property *Property) {
+	pm.Property = property
 
 	// Build rule and behavior maps for quick lookup
 	pm.buildRuleMap(&property.Rules)
 	pm.buildBehaviorMap(&property.Behaviors)
+	pm.compiled = pm.compileCriteria(property.Rules.Rule)
 
 	// Initialize variables
 	for _, v := range property.Variables.Variable {
 		pm.Variables[v.Name] = v.Value
 	}
-
-	return nil
 }
 
 // ProcessRequest processes an HTTP request through the property rules
@@ -170,8 +261,12 @@ func (pm *PropertyManager) ProcessRequest(req *http.Request) (*RuleResult, error
 	}
 
 	// Process rules
-	if err := pm.processRules(pm.Property.Rules.Rule, context, result); err != nil {
-		result.Errors = append(result.Errors, err.Error())
+	if pm.Property != nil {
+		start := time.Now()
+		if err := pm.processRules(pm.Property.Rules.Rule, context, result); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		}
+		result.Trace.EvaluationTime = time.Since(start)
 	}
 
 	return result, nil
@@ -216,6 +311,18 @@ func (pm *PropertyManager) createHTTPContext(req *http.Request) *HTTPContext {
 	for key, value := range pm.Variables {
 		variables[key] = value
 	}
+	if pm.GeoHeaderPrefix != "" {
+		for name, variable := range geoOverrideHeaders {
+			if value := strings.TrimSpace(req.Header.Get(pm.GeoHeaderPrefix + name)); value != "" {
+				if variable == "GEO_COUNTRY_CODE" {
+					value = strings.ToUpper(value)
+				}
+				variables[variable] = value
+			}
+		}
+	}
+
+	body, bodySize, bodyTruncated := readRequestBody(req, pm.maxBodyInspectBytes())
 
 	return &HTTPContext{
 		Request:   req,
@@ -226,9 +333,13 @@ func (pm *PropertyManager) createHTTPContext(req *http.Request) *HTTPContext {
 		Method:    req.Method,
 		Host:      req.Host,
 		Query:     req.URL.RawQuery,
-		ClientIP:  req.RemoteAddr,
+		ClientIP:  pm.effectiveClientIP(req),
 		UserAgent: req.UserAgent(),
 		Timestamp: time.Now(),
+
+		Body:          body,
+		BodySize:      bodySize,
+		BodyTruncated: bodyTruncated,
 	}
 }
 
@@ -240,6 +351,7 @@ func (pm *PropertyManager) SetRules(rules []Rule) {
 	// Build rule map from the provided rules
 	ruleCollection := Rules{Rule: rules}
 	pm.buildRuleMap(&ruleCollection)
+	pm.compiled = pm.compileCriteria(rules)
 }
 
 // ProcessHTTPContext processes an HTTP context directly
@@ -255,12 +367,15 @@ func (pm *PropertyManager) ProcessHTTPContext(context *HTTPContext) (*RuleResult
 		CompressionSettings:       make(map[string]interface{}),
 		ImageOptimizationSettings: make(map[string]interface{}),
 	}
+	pm.limitContextBody(context)
 
 	// If we have a property with rules, process them
 	if pm.Property != nil && len(pm.Property.Rules.Rule) > 0 {
+		start := time.Now()
 		if err := pm.processRules(pm.Property.Rules.Rule, context, result); err != nil {
 			result.Errors = append(result.Errors, err.Error())
 		}
+		result.Trace.EvaluationTime = time.Since(start)
 	}
 
 	return result, nil
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+	"github.com/gin-gonic/gin"
+)
+
+// edgeLogKey is the gin context key of the edge fields handlers record for the access log
+const edgeLogKey = "edgeLog"
+
+// edgeLogTimeFormat is the timestamp layout of the combined log format
+const edgeLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// Cache statuses of the edge access log, describing the fragment cache for a page
+const (
+	CacheStatusHit    = "HIT"    // Every include was read from the fragment cache
+	CacheStatusMiss   = "MISS"   // At least one include was fetched from its origin
+	CacheStatusBypass = "BYPASS" // The fragment cache was disabled or bypassed for the page
+)
+
+// edgeLogFields are the edge fields of a request's access log line
+type edgeLogFields struct {
+	cacheStatus  string
+	matchedRules []string
+	includes     int
+	// logFields are the custom fields selected by log_fields behaviors of matched rules
+	logFields []propertymanager.LogField
+}
+
+// WithEdgeAccessLog writes an access log in the combined log format followed by edge
+// fields (cache status, matched rules, ESI include count and processing time) and the
+// custom fields of log_fields behaviors to w, one line per request, so tooling built
+// for CDN logs can read the emulator's traffic.
+// It is written in addition to the access log of WithAccessLog.
+func WithEdgeAccessLog(w io.Writer) Option {
+	return func(s *Server) {
+		s.edgeAccessLog = w
+	}
+}
+
+// edgeAccessLogMiddleware writes the edge access log line of each request to w
+func edgeAccessLogMiddleware(w io.Writer) gin.HandlerFunc {
+	var mutex sync.Mutex
+	return func(c *gin.Context) {
+		startTime := time.Now()
+		c.Next()
+
+		line := formatEdgeLogLine(c, startTime, time.Since(startTime))
+		mutex.Lock()
+		defer mutex.Unlock()
+		io.WriteString(w, line)
+	}
+}
+
+// formatEdgeLogLine formats the access log line of a request that started at startTime
+// and took duration:
+//
+//	host - - [time] "METHOD URI PROTO" status bytes "referer" "user-agent" cache=HIT rules="a,b" esi_includes=2 ms=1.250 request_id=ID geo_country="US"
+func formatEdgeLogLine(c *gin.Context, startTime time.Time, duration time.Duration) string {
+	fields, _ := c.Get(edgeLogKey)
+	edge, _ := fields.(edgeLogFields)
+
+	size := "-"
+	if c.Writer.Size() > 0 {
+		size = fmt.Sprint(c.Writer.Size())
+	}
+
+	var custom strings.Builder
+	for _, field := range edge.logFields {
+		fmt.Fprintf(&custom, " %s=\"%s\"", field.Name, logField(field.Value))
+	}
+
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\" cache=%s rules=\"%s\" esi_includes=%d ms=%.3f request_id=%s%s\n",
+		c.ClientIP(), startTime.Format(edgeLogTimeFormat),
+		c.Request.Method, c.Request.URL.RequestURI(), c.Request.Proto,
+		c.Writer.Status(), size,
+		logField(c.Request.Referer()), logField(c.Request.UserAgent()),
+		logField(edge.cacheStatus), logField(strings.Join(edge.matchedRules, ",")), edge.includes,
+		float64(duration)/float64(time.Millisecond), logField(requestID(c)), custom.String())
+}
+
+// setEdgeLog records the edge fields of the request for the access log
+func setEdgeLog(c *gin.Context, fields edgeLogFields) {
+	c.Set(edgeLogKey, fields)
+}
+
+// esiEdgeLog returns the edge fields of a page processed with the response metadata
+// meta; noCache is set when the fragment cache was bypassed for the page
+func (s *Server) esiEdgeLog(meta *esi.ResponseMeta, noCache bool) edgeLogFields {
+	fields := edgeLogFields{}
+	if meta != nil {
+		fields.includes = meta.Includes
+	}
+
+	switch {
+	case noCache || !s.esiProcessor.GetConfig().Cache.Enabled:
+		fields.cacheStatus = CacheStatusBypass
+	case meta == nil || meta.Includes == 0:
+		// Pages without includes have no cache status
+	case meta.CachedIncludes == meta.Includes:
+		fields.cacheStatus = CacheStatusHit
+	default:
+		fields.cacheStatus = CacheStatusMiss
+	}
+	return fields
+}
+
+// logField returns a log field value with its quotes escaped, or - when it is empty
+func logField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
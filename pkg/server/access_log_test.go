@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedBuffer is a buffer written by the server and read by the test
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) Lines() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return strings.Split(strings.TrimSuffix(b.buffer.String(), "\n"), "\n")
+}
+
+func TestEdgeAccessLogMiddleware(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>fragment</p>"))
+	}))
+	defer origin.Close()
+
+	pm := propertymanager.NewPropertyManager(false)
+	pm.Property = propertymanager.NewProperty("site").Rule(
+		propertymanager.NewRule("default").Behavior("esi").Child(
+			propertymanager.NewRule("home").PathEquals("/"))).Build()
+
+	processor := esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5,
+		Cache: esi.CacheConfig{Enabled: true, TTL: 60}})
+	var log lockedBuffer
+	srv := New(Config{Mode: "integrated"}, WithIntegrated(processor, pm), WithEdgeAccessLog(&log))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	html := `<esi:include src="` + origin.URL + `/a"/><esi:include src="` + origin.URL + `/b"/>`
+	for i := 0; i < 2; i++ {
+		resp := postJSON(t, ts.URL+"/integrated/process", IntegratedProcessRequest{HTML: html,
+			Context: &propertymanager.HTTPContext{Method: "GET", Path: "/"}}, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	resp, err := http.Get(ts.URL + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	lines := log.Lines()
+	require.Len(t, lines, 3)
+	assert.Regexp(t, `^127\.0\.0\.1 - - \[[^\]]+\] "POST /integrated/process HTTP/1\.1" 200 \d+ "-" "Go-http-client/1\.1" `, lines[0])
+	assert.Contains(t, lines[0], `cache=MISS rules="default,home" esi_includes=2 ms=`)
+	assert.Contains(t, lines[1], `cache=HIT rules="default,home" esi_includes=2 ms=`)
+	assert.Regexp(t, `"GET /health HTTP/1\.1" 200 \d+ "-" "Go-http-client/1\.1" cache=- rules="-" esi_includes=0 ms=[0-9.]+ request_id=[0-9a-f]{32}$`, lines[2])
+}
+
+func TestEdgeAccessLogMiddleware_LogFields(t *testing.T) {
+	pm := propertymanager.NewPropertyManager(false)
+	pm.Property = propertymanager.NewProperty("site").Rule(
+		propertymanager.NewRule("default").Behavior("log_fields", "fields", "header:X-Tier, segment=cookie:segment, geo:country")).Build()
+
+	processor := esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})
+	var log lockedBuffer
+	srv := New(Config{Mode: "integrated"}, WithIntegrated(processor, pm), WithEdgeAccessLog(&log))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp := postJSON(t, ts.URL+"/integrated/process", IntegratedProcessRequest{HTML: "<p>page</p>",
+		Context: &propertymanager.HTTPContext{Method: "GET", Path: "/", Headers: map[string]string{"X-Tier": `gold "plus"`}}}, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	lines := log.Lines()
+	require.Len(t, lines, 1)
+	assert.Regexp(t, `request_id=[0-9a-f]{32} header_x_tier="gold \\"plus\\"" segment="-" geo_country="US"$`, lines[0])
+}
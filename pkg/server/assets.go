@@ -0,0 +1,69 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embeddedAssets are the built-in examples and fragments, compiled into the binary so
+// it runs without any content files
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+// nowPlaceholder in a fragment is replaced with the time it is served
+const nowPlaceholder = "{{now}}"
+
+var (
+	builtinOnce        sync.Once
+	builtinExampleSet  map[string]Example
+	builtinFragmentSet map[string]string
+)
+
+// Assets returns the built-in content, laid out like a library directory with
+// examples/ and fragments/, so it can be exported and customized
+func Assets() fs.FS {
+	assets, err := fs.Sub(embeddedAssets, "assets")
+	if err != nil {
+		panic(err) // The assets directory is embedded at build time
+	}
+	return assets
+}
+
+// loadBuiltins parses the embedded content once
+func loadBuiltins() {
+	builtinOnce.Do(func() {
+		builtinExampleSet, builtinFragmentSet, _ = loadContent(Assets(), "assets")
+	})
+}
+
+// builtinExamples returns example ESI content for testing
+func builtinExamples() map[string]Example {
+	loadBuiltins()
+	examples := make(map[string]Example, len(builtinExampleSet))
+	for name, example := range builtinExampleSet {
+		examples[name] = example
+	}
+	return examples
+}
+
+// builtinFragments returns test fragments for the examples' includes
+func builtinFragments() map[string]string {
+	loadBuiltins()
+	fragments := make(map[string]string, len(builtinFragmentSet))
+	for name, fragment := range builtinFragmentSet {
+		fragments[name] = fragment
+	}
+	return fragments
+}
+
+// expandFragment fills in the time a fragment is served at
+func expandFragment(fragment string) string {
+	if !strings.Contains(fragment, nowPlaceholder) {
+		return fragment
+	}
+	return strings.ReplaceAll(fragment, nowPlaceholder, time.Now().Format(time.RFC3339))
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes of GET /cache/entries
+const (
+	DefaultCacheEntriesLimit = 50
+	MaxCacheEntriesLimit     = 500
+)
+
+// CacheEntriesResponse is a page of cached fragments ordered by key
+type CacheEntriesResponse struct {
+	Entries []esi.CacheEntryInfo `json:"entries"`
+	Total   int                  `json:"total"`
+	Offset  int                  `json:"offset"`
+	Limit   int                  `json:"limit"`
+}
+
+// CacheEntryResponse is a cached fragment with its stored content
+type CacheEntryResponse struct {
+	esi.CacheEntryInfo
+	Content string `json:"content"`
+}
+
+// handleCacheEntries lists a page of the cached fragments, selected with the offset and
+// limit query parameters
+func (s *Server) handleCacheEntries(c *gin.Context) {
+	if s.esiProcessor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "ESI processor not available",
+			Message: "ESI processor has not been configured",
+		})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: "offset must be a non-negative integer",
+		})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DefaultCacheEntriesLimit)))
+	if err != nil || limit < 1 || limit > MaxCacheEntriesLimit {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: "limit must be between 1 and " + strconv.Itoa(MaxCacheEntriesLimit),
+		})
+		return
+	}
+
+	entries := s.esiProcessor.GetCacheEntries()
+	page := []esi.CacheEntryInfo{}
+	if offset < len(entries) {
+		end := offset + limit
+		if end > len(entries) {
+			end = len(entries)
+		}
+		page = entries[offset:end]
+	}
+
+	c.JSON(http.StatusOK, CacheEntriesResponse{
+		Entries: page,
+		Total:   len(entries),
+		Offset:  offset,
+		Limit:   limit,
+	})
+}
+
+// handleCacheEntry returns a cached fragment and its content. The key is the
+// fragment URL, path-escaped.
+func (s *Server) handleCacheEntry(c *gin.Context) {
+	if s.esiProcessor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "ESI processor not available",
+			Message: "ESI processor has not been configured",
+		})
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if info, content, exists := s.esiProcessor.GetCacheEntry(key); exists {
+		c.JSON(http.StatusOK, CacheEntryResponse{CacheEntryInfo: info, Content: content})
+		return
+	}
+
+	c.JSON(http.StatusNotFound, ErrorResponse{
+		Error:   "Cache entry not found",
+		Message: "No fragment is cached under " + key,
+	})
+}
+
+// handlePurge invalidates the cached fragments selected by URL or tag, answering purge
+// webhooks in the JSON shape of CDN purge APIs. Soft purges mark the fragments stale
+// instead of removing them.
+func (s *Server) handlePurge(c *gin.Context) {
+	if s.esiProcessor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "ESI processor not available",
+			Message: "ESI processor has not been configured",
+		})
+		return
+	}
+
+	var req esi.PurgeRequest
+	if !s.bindJSON(c, &req) {
+		return
+	}
+	if len(req.URLs) == 0 && len(req.Tags) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: "a purge needs urls or tags",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, s.esiProcessor.Purge(req))
+}
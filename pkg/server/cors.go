@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default CORS values used when the configuration leaves them unset
+var (
+	DefaultCORSMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	DefaultCORSHeaders = []string{"Origin", "X-Requested-With", "Content-Type", "Accept", "Authorization"}
+)
+
+// CORSConfig holds the cross-origin resource sharing policy
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowedMethods   []string `json:"allowedMethods"`
+	AllowedHeaders   []string `json:"allowedHeaders"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	MaxAge           int      `json:"maxAge"`
+}
+
+// allowsAnyOrigin reports whether the policy accepts every origin
+func (c CORSConfig) allowsAnyOrigin() bool {
+	if len(c.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsOrigin reports whether origin matches the policy.
+// Entries of the form "*.example.com" match any subdomain of example.com.
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	if c.allowsAnyOrigin() {
+		return true
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") {
+			// Compare the host alone, so origins with a port match too
+			u, err := url.Parse(origin)
+			if err == nil && strings.HasSuffix(strings.ToLower(u.Hostname()), strings.ToLower(allowed[1:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// corsMiddleware adds CORS headers according to the configured policy
+func corsMiddleware(config CORSConfig) gin.HandlerFunc {
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+	headers := config.AllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultCORSHeaders
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		allowed := origin == "" || config.allowsOrigin(origin)
+
+		if allowed {
+			// Wildcard policies never allow credentials: echoing any caller with them would
+			// let every site read responses with the user's cookies
+			if config.allowsAnyOrigin() {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else if origin != "" {
+				c.Header("Access-Control-Allow-Origin", origin)
+				c.Header("Vary", "Origin")
+				if config.AllowCredentials {
+					c.Header("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			c.Header("Access-Control-Allow-Methods", allowMethods)
+			c.Header("Access-Control-Allow-Headers", allowHeaders)
+			if config.MaxAge > 0 {
+				c.Header("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
+			}
+		}
+
+		if c.Request.Method == "OPTIONS" {
+			if !allowed {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
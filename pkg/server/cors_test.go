@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSConfig_AllowsOrigin(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{"https://app.example.com", "*.corp.example"}}
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://sso.corp.example", true},
+		{"https://sso.corp.example:8443", true},
+		{"https://evilcorp.example", false},
+		{"https://corp.example.evil.test", false},
+		{"https://app.example.com.evil.test", false},
+		{"https://evil.test", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.allowed, config.allowsOrigin(test.origin), test.origin)
+	}
+
+	assert.True(t, CORSConfig{}.allowsOrigin("https://anywhere.test"))
+	assert.True(t, CORSConfig{AllowedOrigins: []string{"*"}}.allowsOrigin("https://anywhere.test"))
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	preflight := func(config CORSConfig, origin string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(corsMiddleware(config))
+		router.POST("/process", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		req := httptest.NewRequest(http.MethodOptions, "/process", nil)
+		req.Header.Set("Origin", origin)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Default policy keeps the wildcard
+	resp := preflight(CORSConfig{}, "https://anywhere.test")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "*", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, resp.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Wildcard policies never send credentials
+	resp = preflight(CORSConfig{AllowCredentials: true}, "https://anywhere.test")
+	assert.Equal(t, "*", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, resp.Header().Get("Access-Control-Allow-Credentials"))
+
+	config := CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "*.corp.example"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+
+	resp = preflight(config, "https://app.example.com")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "https://app.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", resp.Header().Get("Vary"))
+	assert.Equal(t, "GET, POST", resp.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "600", resp.Header().Get("Access-Control-Max-Age"))
+
+	resp = preflight(config, "https://sso.corp.example:8443")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "https://sso.corp.example:8443", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+
+	for _, origin := range []string{"https://evilcorp.example", "https://evil.test"} {
+		resp = preflight(config, origin)
+		assert.Equal(t, http.StatusForbidden, resp.Code, origin)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"), origin)
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Credentials"), origin)
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/gin-gonic/gin"
+)
+
+// handleESIDegradation processes a template and returns it side by side with the page a
+// CDN without ESI would deliver, with their diff
+func (s *Server) handleESIDegradation(c *gin.Context) {
+	if s.esiProcessor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "ESI processor not available",
+			Message: "ESI processor has not been configured",
+		})
+		return
+	}
+
+	var req ProcessRequest
+	if !s.bindJSON(c, &req) {
+		return
+	}
+	context := requestContext(c, req.Context)
+	context.Response = esi.NewResponseMeta()
+	context.RequestID = requestID(c)
+
+	report, err := s.esiProcessor.Degrade(req.HTML, *context)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "ESI processing failed",
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/gin-gonic/gin"
+)
+
+// documentTypes are the markup media types a request body is posted as a document in
+var documentTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"application/xml":       true,
+	"text/xml":              true,
+}
+
+// isDocument reports whether the request carries a markup document rather than a
+// ProcessRequest. Requests without a Content-Type or with any other one are bound as
+// ProcessRequests.
+func isDocument(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && documentTypes[mediaType]
+}
+
+// handleESIDocument processes a request body as a document. Its charset is detected
+// from a byte order mark, the Content-Type charset or a meta declaration, and the
+// processed document is returned raw in that same charset. Documents the processor
+// does not process, such as XML content and Range requests, are returned as sent.
+func (s *Server) handleESIDocument(c *gin.Context) {
+	body, ok := readDocument(c)
+	if !ok {
+		return
+	}
+
+	context := documentContext(c)
+	session, inSession := s.beginSession(c)
+	if inSession {
+		withSession(&context, session)
+	}
+	contentType := c.GetHeader("Content-Type")
+	if s.esiProcessor.ShouldProcess(contentType, context) {
+		s.writeEarlyHints(c, string(body), context)
+	}
+	startTime := time.Now()
+	result, charsetName, err := s.esiProcessor.ProcessBytes(body, contentType, context)
+	processingTime := time.Since(startTime).Milliseconds()
+	if inSession {
+		s.endSession(session, responseSetCookie(context.Response)...)
+	}
+	setEdgeLog(c, s.esiEdgeLog(context.Response, false))
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "ESI processing failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Documents passed through keep their content type
+	if charsetName == "" {
+		c.Data(http.StatusOK, contentType, result)
+		return
+	}
+
+	if !s.checkResponseSize(c, string(result)) {
+		return
+	}
+	s.writeRawResponse(c, result, charsetName, context.Response, processingTime)
+}
+
+// readDocument reads the request body, writing the error response if it cannot
+func readDocument(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeTooLarge(c, maxBytesErr.Limit)
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+	return body, true
+}
+
+// documentContext returns the processing context of a document sent as the request
+// body, from the request's host, headers and cookies
+func documentContext(c *gin.Context) esi.ProcessContext {
+	context := esi.ProcessContext{
+		BaseURL:   fmt.Sprintf("%s://%s", getScheme(c), c.Request.Host),
+		Headers:   make(map[string]string),
+		Cookies:   make(map[string]string),
+		Response:  esi.NewResponseMeta(),
+		RequestID: requestID(c),
+	}
+	for key, values := range c.Request.Header {
+		if len(values) > 0 {
+			context.Headers[key] = values[0]
+		}
+	}
+	for _, cookie := range c.Request.Cookies() {
+		context.Cookies[cookie.Name] = cookie.Value
+	}
+	return context
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsDocument(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{contentType: "text/html", expected: true},
+		{contentType: "text/html; charset=iso-8859-1", expected: true},
+		{contentType: "application/xhtml+xml", expected: true},
+		{contentType: "text/xml", expected: true},
+		{contentType: "", expected: false},
+		{contentType: "application/json", expected: false},
+		{contentType: "text/plain", expected: false},
+		{contentType: "application/octet-stream", expected: false},
+		{contentType: "not a type;;", expected: false},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPost, "/process", nil)
+		require.NoError(t, err)
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		assert.Equal(t, tt.expected, isDocument(req), tt.contentType)
+	}
+}
+
+func TestServer_ProcessUnknownContentTypeAsJSON(t *testing.T) {
+	ts := newTestServer(t)
+	body := `{"html": "<p>a</p><esi:remove>gone</esi:remove>"}`
+
+	for _, contentType := range []string{"", "text/plain"} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/process", strings.NewReader(body))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, contentType)
+
+		var result ProcessResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		assert.Contains(t, result.Result, "<p>a</p>", contentType)
+		assert.NotContains(t, result.Result, "gone", contentType)
+	}
+
+	resp, err := http.Post(ts.URL+"/process", "text/html", strings.NewReader("<p>a</p><esi:remove>gone</esi:remove>"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "<p>a</p>")
+	assert.NotContains(t, string(page), "gone")
+	assert.False(t, json.Valid(page))
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/gin-gonic/gin"
+)
+
+// writeEarlyHints sends a 103 Early Hints response with the preload links of a page
+// before it is processed, as an edge server proxying the page would, when
+// Config.EarlyHints is set. The final response carries the same Link header.
+func (s *Server) writeEarlyHints(c *gin.Context, page string, context esi.ProcessContext) {
+	if !s.config.EarlyHints {
+		return
+	}
+	links := s.esiProcessor.PreloadLinks(page, context)
+	if len(links) == 0 {
+		return
+	}
+
+	// gin holds back the status until the body is written, so 1xx responses go to the
+	// connection's writer
+	writer, ok := c.Writer.(interface{ Unwrap() http.ResponseWriter })
+	if !ok {
+		return
+	}
+	c.Header("Link", esi.PreloadHeader(links))
+	writer.Unwrap().WriteHeader(http.StatusEarlyHints)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipBody is a decompressed request body that closes the compressed body with it
+type gzipBody struct {
+	*gzip.Reader
+	compressed io.Closer
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.compressed.Close()
+}
+
+// requestEncodingMiddleware decompresses gzip request bodies of the processing
+// endpoints, as large templates are sent compressed, and rejects other content codings
+// with 415. The decompressed body is held to the body size limit too, except for
+// streaming POST /process requests, which are limited per line.
+func requestEncodingMiddleware(s *Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+		switch encoding {
+		case "", "identity":
+			c.Next()
+			return
+		case "gzip", "x-gzip":
+		default:
+			c.Header("Accept-Encoding", "gzip")
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, ErrorResponse{
+				Error:   "Unsupported content encoding",
+				Message: fmt.Sprintf("content encoding %q is not supported, send gzip or identity", encoding),
+			})
+			return
+		}
+
+		reader, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: "invalid gzip body: " + err.Error(),
+			})
+			return
+		}
+
+		var body io.ReadCloser = &gzipBody{Reader: reader, compressed: c.Request.Body}
+		if !isStreamRequest(c) {
+			body = http.MaxBytesReader(c.Writer, body, s.maxBodySize())
+		}
+		c.Request.Body = body
+		c.Request.ContentLength = -1
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
+		c.Next()
+	}
+}
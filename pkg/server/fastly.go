@@ -0,0 +1,184 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/fastly"
+	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+	"github.com/gin-gonic/gin"
+)
+
+// FastlyProcessRequest represents a request to run a page through the Fastly VCL and ESI
+type FastlyProcessRequest struct {
+	HTML    string                       `json:"html" binding:"required"`
+	Context *propertymanager.HTTPContext `json:"context" binding:"required"`
+	// OriginStatus and OriginHeaders are the backend response the HTML came with, as
+	// vcl_fetch sees it (optional; 200 and no headers by default)
+	OriginStatus  int               `json:"originStatus,omitempty"`
+	OriginHeaders map[string]string `json:"originHeaders,omitempty"`
+}
+
+// FastlyProcessResponse represents the response from Fastly processing
+type FastlyProcessResponse struct {
+	Fastly        *fastly.Result `json:"fastly"`
+	ProcessedHTML string         `json:"processedHtml"`
+	// ESIErrorPage says why processedHtml is the ESI error page, when includes failed
+	// past the policy
+	ESIErrorPage string    `json:"esiErrorPage,omitempty"`
+	Stats        StatsInfo `json:"stats"`
+}
+
+// FastlyResult is the outcome of running a request through the VCL and ESI
+type FastlyResult struct {
+	VCL           *fastly.Result
+	ProcessedHTML string
+
+	// ESIResponse holds the response metadata ESI processing collected, when vcl_fetch
+	// enabled it
+	ESIResponse *esi.ResponseMeta
+
+	// ESIError is set when ESI processing failed and the original HTML was used instead
+	ESIError error
+}
+
+// WithFastly serves the ESI endpoints with processor and runs requests to
+// /fastly/process through vcl, which also runs vcl_recv on every include
+func WithFastly(processor *esi.Processor, vcl *fastly.VCL) Option {
+	return func(s *Server) {
+		processor.OnBeforeInclude(vcl.BeforeInclude)
+		s.SetESIProcessor(processor)
+		s.fastlyVCL = vcl
+	}
+}
+
+// ProcessFastly runs the Fastly workflow: vcl_recv → vcl_fetch and vcl_deliver on the
+// origin response → ESI processing of the page when vcl_fetch enabled it. An error
+// statement answers the request without ESI processing. status is the origin's
+// response status; zero stands for 200.
+func ProcessFastly(vcl *fastly.VCL, processor *esi.Processor, req *http.Request, status int, origin http.Header, page string) *FastlyResult {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	result := &FastlyResult{VCL: vcl.Process(req, status, origin), ProcessedHTML: page}
+	if result.VCL.ErrorStatus != 0 || !result.VCL.ESI {
+		return result
+	}
+
+	// ESI sees the request as vcl_recv left it
+	headers := make(map[string]string)
+	for key, value := range result.VCL.RequestHeaders {
+		headers[key] = value
+	}
+	delete(headers, "Content-Encoding")
+	cookies := make(map[string]string)
+	cookieRequest := http.Request{Header: http.Header{"Cookie": {headers["Cookie"]}}}
+	for _, cookie := range cookieRequest.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	context := esi.ProcessContext{
+		BaseURL:   fmt.Sprintf("%s://%s", getSchemeFromRequest(req), req.Host),
+		Headers:   headers,
+		Cookies:   cookies,
+		Response:  esi.NewResponseMeta(),
+		RequestID: req.Header.Get(RequestIDHeader),
+	}
+	result.ESIResponse = context.Response
+	processed, err := processor.Process(page, context)
+	if err != nil {
+		result.ESIError = err
+		return result
+	}
+	result.ProcessedHTML = processed
+	return result
+}
+
+// handleFastlyProcess runs a request through the Fastly VCL and ESI
+func (s *Server) handleFastlyProcess(c *gin.Context) {
+	if s.fastlyVCL == nil || s.esiProcessor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "Fastly processing not available",
+			Message: "A Fastly VCL file must be configured for the ESI emulator",
+		})
+		return
+	}
+
+	var req FastlyProcessRequest
+	if !s.bindJSON(c, &req) {
+		return
+	}
+	httpReq, err := s.createHTTPRequest(req.Context)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid HTTP context",
+			Message: err.Error(),
+		})
+		return
+	}
+	if httpReq.Header.Get(RequestIDHeader) == "" {
+		httpReq.Header.Set(RequestIDHeader, requestID(c))
+	}
+	origin := make(http.Header)
+	for key, value := range req.OriginHeaders {
+		origin.Set(key, value)
+	}
+
+	startTime := time.Now()
+	result := ProcessFastly(s.fastlyVCL, s.esiProcessor, httpReq, req.OriginStatus, origin, req.HTML)
+	if result.ESIResponse != nil {
+		setEdgeLog(c, s.esiEdgeLog(result.ESIResponse, false))
+	}
+
+	// An error statement answers with a synthetic response, as Fastly's default vcl_error does
+	if result.VCL.ErrorStatus != 0 {
+		s.writeVCLError(c, result.VCL)
+		return
+	}
+	if !s.checkResponseSize(c, result.ProcessedHTML) {
+		return
+	}
+
+	processingTime := time.Since(startTime).Milliseconds()
+	errorPage := ""
+	if result.ESIResponse != nil {
+		errorPage = result.ESIResponse.ErrorPage
+	}
+	c.JSON(http.StatusOK, FastlyProcessResponse{
+		Fastly:        result.VCL,
+		ProcessedHTML: result.ProcessedHTML,
+		ESIErrorPage:  errorPage,
+		Stats: StatsInfo{
+			ProcessingTime: processingTime,
+			Mode:           s.config.Mode,
+			Requests:       1,
+			TotalTime:      processingTime,
+		},
+	})
+}
+
+// writeVCLError writes the synthetic response of a VCL error statement
+func (s *Server) writeVCLError(c *gin.Context, vclResult *fastly.Result) {
+	message := html.EscapeString(vclResult.ErrorMessage)
+	body := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+    <title>%d %s</title>
+</head>
+<body>
+    <h1>%s</h1>
+</body>
+</html>`, vclResult.ErrorStatus, message, message)
+
+	c.Data(vclResult.ErrorStatus, "text/html; charset=utf-8", []byte(body))
+}
+
+// fastlyEndpoints adds the Fastly endpoint to the endpoints listed by the root handler
+func (s *Server) fastlyEndpoints(endpoints map[string]string) {
+	if s.fastlyVCL != nil {
+		endpoints["/fastly/process"] = "POST - Process a request through the Fastly VCL and ESI"
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/gin-gonic/gin"
+)
+
+// FaultsRequest replaces the fault injection rules of include fetches
+type FaultsRequest struct {
+	Rules []esi.FaultRule `json:"rules"`
+}
+
+// handleGetFaults returns the fault injection rules of include fetches
+func (s *Server) handleGetFaults(c *gin.Context) {
+	if s.esiProcessor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "ESI processor not available",
+			Message: "ESI processor has not been configured",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": s.esiProcessor.GetFaults()})
+}
+
+// handleSetFaults replaces the fault injection rules and returns them; an empty
+// list turns fault injection off
+func (s *Server) handleSetFaults(c *gin.Context) {
+	if s.esiProcessor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "ESI processor not available",
+			Message: "ESI processor has not been configured",
+		})
+		return
+	}
+
+	var req FaultsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := s.esiProcessor.SetFaults(req.Rules); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid fault rules",
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": s.esiProcessor.GetFaults()})
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Frequency cap periods: session caps last until the browser session ends,
+// daily caps until the next midnight UTC
+const (
+	FrequencyPeriodSession = "session"
+	FrequencyPeriodDay     = "day"
+)
+
+// FrequencyResult is the response to a recorded beacon fire
+type FrequencyResult struct {
+	Pixel     string `json:"pixel"`
+	Count     int    `json:"count"`
+	Cap       int    `json:"cap"`
+	Period    string `json:"period"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// FrequencyStats counts the fires of a pixel. Duplicates are fires at or over the cap,
+// which the generated frequency conditions should have suppressed.
+type FrequencyStats struct {
+	Pixel      string `json:"pixel"`
+	Fires      int64  `json:"fires"`
+	Duplicates int64  `json:"duplicates"`
+}
+
+// FrequencyTracker records beacon fires reported to the frequency endpoint
+type FrequencyTracker struct {
+	pixels map[string]*FrequencyStats
+	now    func() time.Time
+	mutex  sync.Mutex
+}
+
+// NewFrequencyTracker creates an empty tracker
+func NewFrequencyTracker() *FrequencyTracker {
+	return &FrequencyTracker{
+		pixels: make(map[string]*FrequencyStats),
+		now:    time.Now,
+	}
+}
+
+// Record records a fire of pixel
+func (f *FrequencyTracker) Record(pixel string, duplicate bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	stats, exists := f.pixels[pixel]
+	if !exists {
+		stats = &FrequencyStats{Pixel: pixel}
+		f.pixels[pixel] = stats
+	}
+	stats.Fires++
+	if duplicate {
+		stats.Duplicates++
+	}
+}
+
+// Snapshot returns the stats of all pixels ordered by pixel ID
+func (f *FrequencyTracker) Snapshot() []FrequencyStats {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	snapshot := make([]FrequencyStats, 0, len(f.pixels))
+	for _, stats := range f.pixels {
+		snapshot = append(snapshot, *stats)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Pixel < snapshot[j].Pixel
+	})
+	return snapshot
+}
+
+// Reset clears all recorded fires
+func (f *FrequencyTracker) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pixels = make(map[string]*FrequencyStats)
+}
+
+// handleFrequencyFire records a beacon fire and sets or refreshes its frequency cookie.
+// The cookie counts fires in the current period and is what the generated
+// esi:choose frequency conditions test.
+func (s *Server) handleFrequencyFire(c *gin.Context) {
+	pixel := c.Param("pixel")
+
+	capValue, err := strconv.Atoi(c.DefaultQuery("cap", "0"))
+	if err != nil || capValue < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: "cap must be a non-negative integer",
+		})
+		return
+	}
+
+	period := c.DefaultQuery("period", FrequencyPeriodSession)
+	if period != FrequencyPeriodSession && period != FrequencyPeriodDay {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: "period must be session or day",
+		})
+		return
+	}
+
+	cookieName := esi.FrequencyCookie(pixel)
+	count := 0
+	if value, err := c.Cookie(cookieName); err == nil {
+		count, _ = strconv.Atoi(value)
+	}
+
+	result := FrequencyResult{
+		Pixel:     pixel,
+		Count:     count + 1,
+		Cap:       capValue,
+		Period:    period,
+		Duplicate: capValue > 0 && count >= capValue,
+	}
+	s.frequency.Record(pixel, result.Duplicate)
+
+	cookie := &http.Cookie{
+		Name:     cookieName,
+		Value:    strconv.Itoa(result.Count),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if period == FrequencyPeriodDay {
+		now := s.frequency.now().UTC()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		cookie.Expires = midnight
+		cookie.MaxAge = int(midnight.Sub(now).Seconds())
+		if cookie.MaxAge < 1 {
+			cookie.MaxAge = 1
+		}
+	}
+	http.SetCookie(c.Writer, cookie)
+
+	c.JSON(http.StatusOK, result)
+}
+
+// handleFrequencyStats returns the recorded fires per pixel
+func (s *Server) handleFrequencyStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"pixels": s.frequency.Snapshot()})
+}
+
+// handleFrequencyReset clears the recorded fires
+func (s *Server) handleFrequencyReset(c *gin.Context) {
+	s.frequency.Reset()
+	c.JSON(http.StatusOK, gin.H{"message": "Frequency stats reset"})
+}
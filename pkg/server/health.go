@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadinessCheck reports whether a dependency is ready to serve requests
+type ReadinessCheck func() error
+
+// namedCheck is a readiness check registered under a component name
+type namedCheck struct {
+	name  string
+	check ReadinessCheck
+}
+
+// ComponentHealth is the readiness of a single component
+type ComponentHealth struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Component statuses
+const (
+	ComponentReady    = "ready"
+	ComponentNotReady = "not ready"
+)
+
+// WithReadinessCheck makes GET /health report the readiness of a dependency such as
+// loaded property rules, a geo database or a cache backend. The server answers 503
+// while any check fails.
+func WithReadinessCheck(name string, check ReadinessCheck) Option {
+	return func(s *Server) {
+		s.readinessChecks = append(s.readinessChecks, namedCheck{name: name, check: check})
+	}
+}
+
+// allReadinessChecks returns the built-in checks of the processors the server mode
+// needs, followed by the registered checks
+func (s *Server) allReadinessChecks() []namedCheck {
+	var checks []namedCheck
+	if s.config.Mode == "esi" || s.config.Mode == "integrated" {
+		checks = append(checks, namedCheck{name: "esi", check: func() error {
+			if s.esiProcessor == nil {
+				return fmt.Errorf("ESI processor has not been configured")
+			}
+			return nil
+		}})
+	}
+	if s.config.Mode == "property-manager" || s.config.Mode == "integrated" {
+		checks = append(checks, namedCheck{name: "propertyManager", check: func() error {
+			if s.propertyProcessor == nil {
+				return fmt.Errorf("Property Manager has not been configured")
+			}
+			return nil
+		}})
+	}
+	return append(checks, s.readinessChecks...)
+}
+
+// Readiness runs the readiness checks concurrently and reports each component by name
+func (s *Server) Readiness() (bool, map[string]ComponentHealth) {
+	checks := s.allReadinessChecks()
+	results := make([]error, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check ReadinessCheck) {
+			defer wg.Done()
+			results[i] = check()
+		}(i, check.check)
+	}
+	wg.Wait()
+
+	ready := true
+	components := make(map[string]ComponentHealth, len(checks))
+	for i, check := range checks {
+		if results[i] != nil {
+			ready = false
+			components[check.name] = ComponentHealth{Status: ComponentNotReady, Message: results[i].Error()}
+			continue
+		}
+		components[check.name] = ComponentHealth{Status: ComponentReady}
+	}
+	return ready, components
+}
+
+// handleHealth reports component readiness, answering 503 until every component is ready
+func (s *Server) handleHealth(c *gin.Context) {
+	ready, components := s.Readiness()
+
+	status, code := "healthy", http.StatusOK
+	if !ready {
+		status, code = "unhealthy", http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, gin.H{
+		"status":     status,
+		"uptime":     time.Since(s.startedAt).Seconds(),
+		"mode":       s.config.Mode,
+		"components": components,
+	})
+}
+
+// handleLive reports that the process is serving requests without touching any dependency
+func (s *Server) handleLive(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/history"
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryResponse lists recorded runs, newest first, and their summaries by template,
+// mode and version
+type HistoryResponse struct {
+	Runs      []history.Run     `json:"runs"`
+	Summaries []history.Summary `json:"summaries"`
+	// Dropped counts the runs since startup that were not recorded
+	Dropped int64 `json:"dropped"`
+}
+
+// WithHistory serves the run history of store at /history; the ESI processor's runs
+// are recorded with an AfterRun hook from store.Recorder
+func WithHistory(store *history.Store) Option {
+	return func(s *Server) {
+		s.history = store
+	}
+}
+
+// handleHistory lists the runs selected by the version, mode, template, since and
+// limit query parameters, with their summaries
+func (s *Server) handleHistory(c *gin.Context) {
+	if s.history == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "Run history not available",
+			Message: "A history database must be configured with ESI_HISTORY_DB",
+		})
+		return
+	}
+
+	filter := history.Filter{
+		Version:      c.Query("version"),
+		Mode:         c.Query("mode"),
+		TemplateHash: c.Query("template"),
+	}
+	if since := c.Query("since"); since != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: "since must be an RFC 3339 time",
+			})
+			return
+		}
+		filter.Since = parsed
+	}
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: "limit must be a non-negative integer",
+			})
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	runs, err := s.history.Runs(filter)
+	if err == nil {
+		var summaries []history.Summary
+		if summaries, err = s.history.Summaries(filter); err == nil {
+			c.JSON(http.StatusOK, HistoryResponse{Runs: runs, Summaries: summaries, Dropped: s.history.Dropped()})
+			return
+		}
+	}
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error:   "Reading the run history failed",
+		Message: err.Error(),
+	})
+}
+
+// historyEndpoints adds the history endpoint to the endpoints listed by the root handler
+func (s *Server) historyEndpoints(endpoints map[string]string) {
+	if s.history != nil {
+		endpoints["/history"] = "GET - Recorded processing runs and their trends (?version=&mode=&template=&since=&limit=)"
+	}
+}
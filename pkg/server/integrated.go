@@ -0,0 +1,208 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+)
+
+// IntegratedResult is the outcome of running a request through Property Manager and ESI
+type IntegratedResult struct {
+	PropertyManagerResult *propertymanager.RuleResult `json:"propertyManager"`
+	ResponseResult        *propertymanager.RuleResult `json:"response"`
+	ProcessedHTML         string                      `json:"processedHtml"`
+	ESIEnabled            bool                        `json:"esiEnabled"`
+	// ESISkipReason says why ESI did not process the page, when it did not
+	ESISkipReason string `json:"esiSkipReason,omitempty"`
+
+	// ESIResponse holds the response metadata ESI processing collected, such as its
+	// include counts
+	ESIResponse *esi.ResponseMeta `json:"-"`
+
+	// ESIError is set when ESI processing failed and the original HTML was used instead
+	ESIError error `json:"-"`
+}
+
+// Stopped reports whether Property Manager denied, redirected or answered the
+// request itself, in which case no ESI processing took place
+func (r *IntegratedResult) Stopped() bool {
+	return r.PropertyManagerResult.Denied || r.PropertyManagerResult.RedirectLocation != "" ||
+		r.PropertyManagerResult.ConstructedResponse != nil
+}
+
+// ESIErrorPage says why ESI replaced the page by its error page, or "" when it did not
+func (r *IntegratedResult) ESIErrorPage() string {
+	if r.ESIResponse == nil {
+		return ""
+	}
+	return r.ESIResponse.ErrorPage
+}
+
+// ProcessIntegrated runs the integrated workflow used by every binary:
+// Property Manager → ESI processing → response behaviors.
+// Denied, redirected and constructed responses stop after Property Manager processing.
+// origin holds the headers the origin sent with html, such as Content-Type and
+// Edge-Control, which decide with the esi behavior whether ESI runs; nil stands for an
+// HTML response.
+func ProcessIntegrated(pm *propertymanager.PropertyManager, processor *esi.Processor, req *http.Request, origin http.Header, html string) (*IntegratedResult, error) {
+	// Step 1: Property Manager processes the request
+	pmResult, err := pm.ProcessRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IntegratedResult{PropertyManagerResult: pmResult}
+	if result.Stopped() {
+		return result, nil
+	}
+
+	// Step 2: Create ESI context from Property Manager result
+	esiContext := NewESIContext(req, pmResult)
+
+	// Step 3: Process ESI content if enabled
+	result.ESISkipReason = ESISkipReason(pmResult, origin)
+	result.ESIEnabled = result.ESISkipReason == ""
+	result.ProcessedHTML = html
+	if result.ESIEnabled {
+		result.ESIResponse = esiContext.Response
+		processedHTML, err := processor.Process(html, esiContext)
+		if err != nil {
+			// Continue with original HTML if ESI fails
+			result.ESIError = err
+		} else {
+			result.ProcessedHTML = processedHTML
+		}
+	}
+
+	// Step 4: Process response behaviors
+	result.ResponseResult, result.ProcessedHTML = ResponseBehaviors(req, pmResult, result.ProcessedHTML)
+
+	return result, nil
+}
+
+// NewESIContext creates an ESI processing context from a request and its Property Manager result
+func NewESIContext(req *http.Request, pmResult *propertymanager.RuleResult) esi.ProcessContext {
+	// Start with request headers
+	headers := make(map[string]string)
+	for key, values := range req.Header {
+		if len(values) > 0 {
+			headers[key] = values[0]
+		}
+	}
+
+	// Apply Property Manager header modifications
+	for key, value := range pmResult.ModifiedHeaders {
+		headers[key] = value
+	}
+
+	// Remove headers that were removed by Property Manager. Content-Encoding describes
+	// the page response, so it is never sent with include requests.
+	for _, removedHeader := range pmResult.RemovedHeaders {
+		delete(headers, removedHeader)
+	}
+	delete(headers, "Content-Encoding")
+
+	// Extract cookies
+	cookies := make(map[string]string)
+	if cookieHeader := req.Header.Get("Cookie"); cookieHeader != "" {
+		// Simple cookie parsing
+		cookiePairs := strings.Split(cookieHeader, ";")
+		for _, pair := range cookiePairs {
+			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(parts) == 2 {
+				cookies[parts[0]] = parts[1]
+			}
+		}
+	}
+
+	// Add Property Manager variables to headers for ESI access
+	for key, value := range pmResult.Variables {
+		headers["X-PM-"+key] = value
+	}
+
+	return esi.ProcessContext{
+		BaseURL: fmt.Sprintf("%s://%s", getSchemeFromRequest(req), req.Host),
+		Headers: headers,
+		Cookies: cookies,
+		Depth:   0,
+
+		Response:  esi.NewResponseMeta(),
+		RequestID: req.Header.Get(RequestIDHeader),
+		// A no-store property keeps the page's fragments out of the cache too
+		NoCache: pmResult.NoStore(),
+	}
+}
+
+// getSchemeFromRequest returns the scheme (http/https) for a request
+func getSchemeFromRequest(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	if scheme := req.Header.Get("X-Forwarded-Proto"); scheme != "" {
+		return scheme
+	}
+	return "http"
+}
+
+// ESIEnabled checks if ESI processing is enabled for a response with the origin headers,
+// based on Property Manager result
+func ESIEnabled(pmResult *propertymanager.RuleResult, origin http.Header) bool {
+	return ESISkipReason(pmResult, origin) == ""
+}
+
+// ESISkipReason returns why ESI does not process a response with the origin headers, or
+// "" when it does: the esi behavior must have executed, be enabled and allow the
+// response's content type
+func ESISkipReason(pmResult *propertymanager.RuleResult, origin http.Header) string {
+	executed := false
+	for _, behavior := range pmResult.ExecutedBehaviors {
+		if behavior == "esi" {
+			executed = true
+			break
+		}
+	}
+	if !executed {
+		return "no esi behavior"
+	}
+	// Results built without running the behavior carry no settings
+	if pmResult.ESI == nil {
+		return ""
+	}
+	return pmResult.ESI.SkipReason(origin)
+}
+
+// ResponseBehaviors processes Property Manager response behaviors and returns the
+// response body with the content rewrites applied. The compression settings pick the
+// Content-Encoding of the processed body from the request's Accept-Encoding.
+func ResponseBehaviors(req *http.Request, pmResult *propertymanager.RuleResult, html string) (*propertymanager.RuleResult, string) {
+	responseResult := &propertymanager.RuleResult{
+		MatchedRules:              pmResult.MatchedRules,
+		ExecutedBehaviors:         pmResult.ExecutedBehaviors,
+		ModifiedHeaders:           make(map[string]string),
+		RemovedHeaders:            []string{},
+		Variables:                 make(map[string]string),
+		Errors:                    []string{},
+		CacheSettings:             make(map[string]interface{}),
+		CompressionSettings:       make(map[string]interface{}),
+		ImageOptimizationSettings: make(map[string]interface{}),
+		ContentRewrites:           pmResult.ContentRewrites,
+	}
+	for key, value := range pmResult.CacheSettings {
+		responseResult.CacheSettings[key] = value
+	}
+
+	// Copy modified headers from request processing
+	for key, value := range pmResult.ModifiedHeaders {
+		responseResult.ModifiedHeaders[key] = value
+	}
+
+	propertymanager.ApplyDownstreamCache(pmResult, responseResult)
+	propertymanager.ApplyResponseCookies(pmResult, responseResult)
+
+	html = propertymanager.ApplyContentRewrites(responseResult, html)
+	propertymanager.ApplyCompression(pmResult, responseResult, req.Header.Get("Accept-Encoding"), len(html))
+	return responseResult, html
+}
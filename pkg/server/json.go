@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/gin-gonic/gin"
+)
+
+// handleESIJSON assembles a JSON document sent as the request body, replacing its
+// $esi:include directives with the JSON they fetch (experimental). The assembled
+// document is returned as the response body.
+func (s *Server) handleESIJSON(c *gin.Context) {
+	if s.esiProcessor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "ESI processor not available",
+			Message: "ESI processor has not been configured",
+		})
+		return
+	}
+
+	body, ok := readDocument(c)
+	if !ok {
+		return
+	}
+
+	context := documentContext(c)
+	session, inSession := s.beginSession(c)
+	if inSession {
+		withSession(&context, session)
+	}
+	startTime := time.Now()
+	result, err := s.esiProcessor.ProcessJSON(body, context)
+	processingTime := time.Since(startTime).Milliseconds()
+	if inSession {
+		s.endSession(session, responseSetCookie(context.Response)...)
+	}
+	setEdgeLog(c, s.esiEdgeLog(context.Response, false))
+
+	if errors.Is(err, esi.ErrInvalidJSON) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "JSON assembly failed",
+			Message: err.Error(),
+		})
+		return
+	}
+	if !s.checkResponseSize(c, string(result)) {
+		return
+	}
+
+	c.Header("X-ESI-Processing-Time", strconv.FormatInt(processingTime, 10))
+	c.Data(http.StatusOK, "application/json; charset=utf-8", result)
+}
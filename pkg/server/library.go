@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+)
+
+// libraryRescanInterval is the minimum time between checks of the content directory for changes
+const libraryRescanInterval = time.Second
+
+// Library holds example templates and fragments loaded from a content directory.
+//
+// The directory contains an examples/ and a fragments/ subdirectory. Every .html
+// file becomes an entry named after the file (without extension). Files may start
+// with a front-matter block:
+//
+//	---
+//	name: Product Page
+//	description: Product page with personalised recommendations
+//	modes: akamai, w3c
+//	---
+//	<html>...</html>
+//
+// The directory is rescanned on access when files are added, removed or modified.
+// The built-in content, from Assets, uses the same layout.
+type Library struct {
+	dir       string
+	examples  map[string]Example
+	fragments map[string]string
+	errors    []string
+	signature string
+	lastCheck time.Time
+	mutex     sync.RWMutex
+}
+
+// NewLibrary creates a library for dir and loads its content
+func NewLibrary(dir string) (*Library, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("examples directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("examples directory: %s is not a directory", dir)
+	}
+
+	library := &Library{dir: dir}
+	library.Reload()
+	return library, nil
+}
+
+// Dir returns the content directory of the library
+func (l *Library) Dir() string {
+	return l.dir
+}
+
+// Examples returns the examples found on disk
+func (l *Library) Examples() map[string]Example {
+	l.refresh()
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	examples := make(map[string]Example, len(l.examples))
+	for name, example := range l.examples {
+		examples[name] = example
+	}
+	return examples
+}
+
+// Fragments returns the fragments found on disk
+func (l *Library) Fragments() map[string]string {
+	l.refresh()
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	fragments := make(map[string]string, len(l.fragments))
+	for name, fragment := range l.fragments {
+		fragments[name] = fragment
+	}
+	return fragments
+}
+
+// Errors returns the problems found during the last load
+func (l *Library) Errors() []string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return append([]string(nil), l.errors...)
+}
+
+// Reload rescans the content directory unconditionally
+func (l *Library) Reload() {
+	signature := l.scanSignature()
+	examples, fragments, errors := l.load()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.examples = examples
+	l.fragments = fragments
+	l.errors = errors
+	l.signature = signature
+	l.lastCheck = time.Now()
+}
+
+// refresh reloads the library if the directory changed since the last scan
+func (l *Library) refresh() {
+	l.mutex.RLock()
+	due := time.Since(l.lastCheck) >= libraryRescanInterval
+	l.mutex.RUnlock()
+	if !due {
+		return
+	}
+
+	signature := l.scanSignature()
+
+	l.mutex.Lock()
+	changed := signature != l.signature
+	l.lastCheck = time.Now()
+	l.mutex.Unlock()
+
+	if changed {
+		l.Reload()
+	}
+}
+
+// scanSignature summarises file names, sizes and modification times of the library
+func (l *Library) scanSignature() string {
+	var signature strings.Builder
+	for _, sub := range []string{"examples", "fragments"} {
+		files, _ := filepath.Glob(filepath.Join(l.dir, sub, "*.html"))
+		sort.Strings(files)
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&signature, "%s|%d|%d\n", file, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return signature.String()
+}
+
+// load reads all examples and fragments from disk
+func (l *Library) load() (map[string]Example, map[string]string, []string) {
+	return loadContent(os.DirFS(l.dir), l.dir)
+}
+
+// loadContent reads the examples and fragments of a content tree laid out like a library
+// directory; errors name the files under dir
+func loadContent(content fs.FS, dir string) (map[string]Example, map[string]string, []string) {
+	examples := make(map[string]Example)
+	fragments := make(map[string]string)
+	var errors []string
+
+	exampleFiles, _ := fs.Glob(content, "examples/*.html")
+	for _, file := range exampleFiles {
+		meta, body, err := readFrontMatterFile(content, file, dir)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+
+		key := strings.TrimSuffix(path.Base(file), path.Ext(file))
+		example := Example{
+			Name:        key,
+			Description: meta["description"],
+			HTML:        body,
+			Modes:       splitList(meta["modes"]),
+		}
+		if meta["name"] != "" {
+			example.Name = meta["name"]
+		}
+		if len(example.Modes) == 0 {
+			example.Modes = esi.Modes()
+		}
+		examples[key] = example
+	}
+
+	fragmentFiles, _ := fs.Glob(content, "fragments/*.html")
+	for _, file := range fragmentFiles {
+		_, body, err := readFrontMatterFile(content, file, dir)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+
+		key := strings.TrimSuffix(path.Base(file), path.Ext(file))
+		fragments[key] = body
+	}
+
+	return examples, fragments, errors
+}
+
+// readFrontMatterFile reads a file and splits its optional front-matter block from the body
+func readFrontMatterFile(content fs.FS, name, dir string) (map[string]string, string, error) {
+	fullPath := filepath.Join(dir, filepath.FromSlash(name))
+	data, err := fs.ReadFile(content, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", fullPath, err)
+	}
+
+	meta, body, err := parseFrontMatter(string(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", fullPath, err)
+	}
+	return meta, body, nil
+}
+
+// parseFrontMatter parses a leading "---" delimited block of "key: value" lines
+func parseFrontMatter(content string) (map[string]string, string, error) {
+	meta := make(map[string]string)
+
+	normalized := strings.ReplaceAll(content, "\r\n", "\n")
+	if !strings.HasPrefix(normalized, "---\n") {
+		return meta, content, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(normalized[len("---\n"):]))
+	consumed := len("---\n")
+	lineNumber := 1
+	for scanner.Scan() {
+		line := scanner.Text()
+		consumed += len(line) + 1
+		lineNumber++
+
+		if strings.TrimSpace(line) == "---" {
+			if consumed > len(normalized) {
+				consumed = len(normalized)
+			}
+			return meta, strings.TrimLeft(normalized[consumed:], "\n"), nil
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, "", fmt.Errorf("front matter line %d: expected key: value", lineNumber)
+		}
+		meta[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+
+	return nil, "", fmt.Errorf("front matter is not terminated by ---")
+}
+
+// splitList splits a comma-separated or bracketed list
+func splitList(value string) []string {
+	value = strings.Trim(strings.TrimSpace(value), "[]")
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), `"'`); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
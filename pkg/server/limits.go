@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default size limits applied when the configuration leaves them unset
+const (
+	DefaultMaxBodySize     int64 = 10 << 20 // 10 MiB
+	DefaultMaxResponseSize int64 = 50 << 20 // 50 MiB
+)
+
+// ndjsonContentType is the content type of streaming /process requests
+const ndjsonContentType = "application/x-ndjson"
+
+// StreamResult is a single line of a streaming /process response
+type StreamResult struct {
+	Line           int    `json:"line"`
+	Result         string `json:"result,omitempty"`
+	Error          string `json:"error,omitempty"`
+	ProcessingTime int64  `json:"processingTime"`
+}
+
+// maxBodySize returns the configured request body limit
+func (s *Server) maxBodySize() int64 {
+	if s.config.MaxBodySize > 0 {
+		return s.config.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+// maxResponseSize returns the configured processed output limit
+func (s *Server) maxResponseSize() int64 {
+	if s.config.MaxResponseSize > 0 {
+		return s.config.MaxResponseSize
+	}
+	return DefaultMaxResponseSize
+}
+
+// bodyLimitMiddleware rejects oversized requests and caps how much of the body can be read.
+// Streaming /process requests are limited per line instead of as a whole.
+func bodyLimitMiddleware(s *Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || isStreamRequest(c) {
+			c.Next()
+			return
+		}
+
+		limit := s.maxBodySize()
+		if c.Request.ContentLength > limit {
+			writeTooLarge(c, limit)
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// bindJSON decodes the request body into obj, writing a 413 or 400 response on failure
+func (s *Server) bindJSON(c *gin.Context, obj interface{}) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeTooLarge(c, maxBytesErr.Limit)
+		return false
+	}
+
+	c.JSON(http.StatusBadRequest, ErrorResponse{
+		Error:   "Invalid request",
+		Message: err.Error(),
+	})
+	return false
+}
+
+// checkResponseSize writes an error response if processed output exceeds the configured limit
+func (s *Server) checkResponseSize(c *gin.Context, result string) bool {
+	limit := s.maxResponseSize()
+	if int64(len(result)) <= limit {
+		return true
+	}
+
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error:   "Response too large",
+		Message: fmt.Sprintf("processed output of %d bytes exceeds the limit of %d bytes", len(result), limit),
+	})
+	return false
+}
+
+// writeTooLarge writes a 413 response
+func writeTooLarge(c *gin.Context, limit int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+		Error:   "Request too large",
+		Message: fmt.Sprintf("request body exceeds the limit of %d bytes", limit),
+	})
+}
+
+// isNDJSON reports whether the request carries newline-delimited JSON
+func isNDJSON(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == ndjsonContentType
+}
+
+// isStreamRequest reports whether c is a streaming POST /process request, whose body
+// handleESIStream limits per line. NDJSON sent to other routes keeps the body limit.
+func isStreamRequest(c *gin.Context) bool {
+	return c.Request.Method == http.MethodPost && c.FullPath() == "/process" && isNDJSON(c.Request)
+}
+
+// handleESIStream processes a newline-delimited stream of ProcessRequest documents.
+// Each line is processed as it arrives and its result is flushed as one NDJSON line,
+// so arbitrarily many documents can be sent without buffering the whole body.
+func (s *Server) handleESIStream(c *gin.Context) {
+	limit := s.maxBodySize()
+	scanner := bufio.NewScanner(c.Request.Body)
+	initialSize := int64(64 * 1024)
+	if limit < initialSize {
+		initialSize = limit
+	}
+	scanner.Buffer(make([]byte, 0, initialSize), int(limit))
+
+	// Results are written while the request body is still being read
+	_ = http.NewResponseController(c.Writer).EnableFullDuplex()
+
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	baseContext := esi.ProcessContext{
+		BaseURL: fmt.Sprintf("%s://%s", getScheme(c), c.Request.Host),
+	}
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		out := StreamResult{Line: line}
+
+		var req ProcessRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			out.Error = "invalid request: " + err.Error()
+		} else {
+			context := baseContext
+			if req.Context != nil {
+				context = *req.Context
+			}
+			context.RequestID = requestID(c)
+
+			startTime := time.Now()
+			result, err := s.esiProcessor.Process(req.HTML, context)
+			out.ProcessingTime = time.Since(startTime).Milliseconds()
+
+			switch {
+			case err != nil:
+				out.Error = err.Error()
+			case int64(len(result)) > s.maxResponseSize():
+				out.Error = fmt.Sprintf("processed output exceeds the limit of %d bytes", s.maxResponseSize())
+			default:
+				out.Result = result
+			}
+		}
+
+		if err := encoder.Encode(out); err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+
+	if err := scanner.Err(); err != nil {
+		message := err.Error()
+		if errors.Is(err, bufio.ErrTooLong) {
+			message = fmt.Sprintf("line %d exceeds the limit of %d bytes", line+1, limit)
+		}
+		_ = encoder.Encode(StreamResult{Line: line + 1, Error: message})
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ListenConfig selects the sockets the server accepts connections on. The zero value
+// listens on Port on all interfaces.
+type ListenConfig struct {
+	// Interfaces restricts listening to these network interfaces (such as lo0 or eth0)
+	// or IP addresses; each address of an interface gets its own listener
+	Interfaces []string `json:"interfaces,omitempty"`
+	// UnixSocket listens on a unix domain socket at this path instead of TCP, for local
+	// reverse proxies; a stale socket file left by a previous run is replaced
+	UnixSocket string `json:"unixSocket,omitempty"`
+	// ReusePort sets SO_REUSEPORT, so several emulators can share the port
+	ReusePort bool `json:"reusePort,omitempty"`
+}
+
+// Listen opens the listeners of the server configuration
+func (s *Server) Listen() ([]net.Listener, error) {
+	listen := s.config.Listen
+	if listen.UnixSocket != "" {
+		if len(listen.Interfaces) > 0 {
+			return nil, errors.New("listen: a unix socket cannot be combined with interfaces")
+		}
+		if err := removeStaleSocket(listen.UnixSocket); err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", listen.UnixSocket)
+		if err != nil {
+			return nil, fmt.Errorf("listen: %w", err)
+		}
+		return []net.Listener{listener}, nil
+	}
+
+	hosts := []string{""}
+	if len(listen.Interfaces) > 0 {
+		var err error
+		if hosts, err = interfaceAddresses(listen.Interfaces); err != nil {
+			return nil, err
+		}
+	}
+
+	config := net.ListenConfig{}
+	if listen.ReusePort {
+		config.Control = func(network, address string, conn syscall.RawConn) error {
+			var sockErr error
+			if err := conn.Control(func(fd uintptr) { sockErr = setReusePort(fd) }); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+
+	var listeners []net.Listener
+	for _, host := range hosts {
+		listener, err := config.Listen(context.Background(), "tcp", net.JoinHostPort(host, strconv.Itoa(s.config.Port)))
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("listen: %w", err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// interfaceAddresses resolves interface names and IP addresses to the IP addresses to
+// listen on. IPv6 link-local addresses are skipped, as they need a zone.
+func interfaceAddresses(interfaces []string) ([]string, error) {
+	var hosts []string
+	for _, name := range interfaces {
+		if ip := net.ParseIP(name); ip != nil {
+			hosts = append(hosts, ip.String())
+			continue
+		}
+
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("listen: interface %s: %w", name, err)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("listen: interface %s: %w", name, err)
+		}
+		found := false
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || (ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast()) {
+				continue
+			}
+			hosts = append(hosts, ipNet.IP.String())
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("listen: interface %s has no usable addresses", name)
+		}
+	}
+	return hosts, nil
+}
+
+// removeStaleSocket removes a socket file nothing listens on any more, leaving other
+// files and live sockets to make listening fail
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("listen: %s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("listen: %s is in use", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("listen: removing stale socket: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ListenUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "emulator.sock")
+	srv := New(Config{Mode: "esi", Listen: ListenConfig{UnixSocket: socket}},
+		WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})))
+	listeners, err := srv.Listen()
+	require.NoError(t, err)
+	go srv.Serve(listeners...)
+	defer srv.Shutdown()
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := httpClient.Get("http://emulator/livez")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A live socket is not replaced by a second server
+	_, err = New(Config{Listen: ListenConfig{UnixSocket: socket}}).Listen()
+	assert.ErrorContains(t, err, "in use")
+}
+
+func TestServer_ListenInterfacesAndReusePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is not available on windows")
+	}
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	config := Config{Port: port, Listen: ListenConfig{Interfaces: []string{"127.0.0.1"}, ReusePort: true}}
+	first, err := New(config).Listen()
+	require.NoError(t, err)
+	defer first[0].Close()
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", port), first[0].Addr().String())
+
+	// With SO_REUSEPORT a second emulator binds the same port
+	second, err := New(config).Listen()
+	require.NoError(t, err)
+	second[0].Close()
+
+	_, err = New(Config{Listen: ListenConfig{Interfaces: []string{"no-such-interface0"}}}).Listen()
+	assert.ErrorContains(t, err, "no-such-interface0")
+}
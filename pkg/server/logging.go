@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LogLevelController reads and changes log levels while the server is running
+type LogLevelController interface {
+	// Levels returns the default level and the component levels by name
+	Levels() map[string]string
+	// SetComponentLevel sets a component's level; an empty level removes it
+	SetComponentLevel(component, level string) error
+}
+
+// LogLevelRequest changes the log level of one component
+type LogLevelRequest struct {
+	Component string `json:"component" binding:"required"`
+	Level     string `json:"level"`
+}
+
+// WithLogLevels serves GET and PUT /admin/log-levels with controller
+func WithLogLevels(controller LogLevelController) Option {
+	return func(s *Server) {
+		s.logLevels = controller
+	}
+}
+
+// AccessLogFunc writes a formatted access log line
+type AccessLogFunc func(format string, args ...interface{})
+
+// WithAccessLog writes one line per request with logf instead of the Gin logger,
+// so access logs follow the caller's log format and levels
+func WithAccessLog(logf AccessLogFunc) Option {
+	return func(s *Server) {
+		s.accessLog = logf
+	}
+}
+
+// accessLogMiddleware logs the method, path, status, duration, client and request ID of each request
+func accessLogMiddleware(logf AccessLogFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now()
+		c.Next()
+
+		logf("%s %s %d %s %s request_id=%s", c.Request.Method, c.Request.URL.RequestURI(), c.Writer.Status(),
+			time.Since(startTime).Round(time.Microsecond), c.ClientIP(), requestID(c))
+	}
+}
+
+// handleGetLogLevels returns the current log levels
+func (s *Server) handleGetLogLevels(c *gin.Context) {
+	if s.logLevels == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "Log level control not available",
+			Message: "The server was started without a log level controller",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"levels": s.logLevels.Levels()})
+}
+
+// handleSetLogLevel changes the log level of a component and returns the updated levels
+func (s *Server) handleSetLogLevel(c *gin.Context) {
+	if s.logLevels == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "Log level control not available",
+			Message: "The server was started without a log level controller",
+		})
+		return
+	}
+
+	var req LogLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := s.logLevels.SetComponentLevel(req.Component, req.Level); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid log level",
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"levels": s.logLevels.Levels()})
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the request latency histogram
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// routeKey identifies a metrics series
+type routeKey struct {
+	Method string
+	Route  string
+	Mode   string
+}
+
+// routeMetrics holds the metrics of a single route
+type routeMetrics struct {
+	Requests     int64
+	LatencySum   float64
+	BucketCounts []int64
+	StatusCodes  map[int]int64
+}
+
+// Metrics collects per-route and per-mode HTTP metrics
+type Metrics struct {
+	routes map[routeKey]*routeMetrics
+	mutex  sync.RWMutex
+}
+
+// RouteMetricsSnapshot is the JSON representation of a route's metrics
+type RouteMetricsSnapshot struct {
+	Method         string           `json:"method"`
+	Route          string           `json:"route"`
+	Mode           string           `json:"mode"`
+	Requests       int64            `json:"requests"`
+	LatencySeconds float64          `json:"latencySeconds"`
+	Buckets        map[string]int64 `json:"buckets"`
+	StatusCodes    map[string]int64 `json:"statusCodes"`
+}
+
+// NewMetrics creates an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		routes: make(map[routeKey]*routeMetrics),
+	}
+}
+
+// Observe records a single request
+func (m *Metrics) Observe(method, route, mode string, status int, duration time.Duration) {
+	key := routeKey{Method: method, Route: route, Mode: mode}
+	seconds := duration.Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rm, exists := m.routes[key]
+	if !exists {
+		rm = &routeMetrics{
+			BucketCounts: make([]int64, len(latencyBuckets)),
+			StatusCodes:  make(map[int]int64),
+		}
+		m.routes[key] = rm
+	}
+
+	rm.Requests++
+	rm.LatencySum += seconds
+	rm.StatusCodes[status]++
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			rm.BucketCounts[i]++
+		}
+	}
+}
+
+// Snapshot returns a copy of all route metrics sorted by route, method and mode
+func (m *Metrics) Snapshot() []RouteMetricsSnapshot {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	snapshots := make([]RouteMetricsSnapshot, 0, len(m.routes))
+	for key, rm := range m.routes {
+		snapshot := RouteMetricsSnapshot{
+			Method:         key.Method,
+			Route:          key.Route,
+			Mode:           key.Mode,
+			Requests:       rm.Requests,
+			LatencySeconds: rm.LatencySum,
+			Buckets:        make(map[string]int64, len(latencyBuckets)+1),
+			StatusCodes:    make(map[string]int64, len(rm.StatusCodes)),
+		}
+		for i, bound := range latencyBuckets {
+			snapshot.Buckets[formatBound(bound)] = rm.BucketCounts[i]
+		}
+		snapshot.Buckets["+Inf"] = rm.Requests
+		for status, count := range rm.StatusCodes {
+			snapshot.StatusCodes[strconv.Itoa(status)] = count
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Route != snapshots[j].Route {
+			return snapshots[i].Route < snapshots[j].Route
+		}
+		if snapshots[i].Method != snapshots[j].Method {
+			return snapshots[i].Method < snapshots[j].Method
+		}
+		return snapshots[i].Mode < snapshots[j].Mode
+	})
+
+	return snapshots
+}
+
+// Prometheus renders the metrics in the Prometheus text exposition format
+func (m *Metrics) Prometheus() string {
+	snapshots := m.Snapshot()
+	var out strings.Builder
+
+	out.WriteString("# HELP emulator_http_requests_total Total HTTP requests by route, mode and status.\n")
+	out.WriteString("# TYPE emulator_http_requests_total counter\n")
+	for _, s := range snapshots {
+		statuses := make([]string, 0, len(s.StatusCodes))
+		for status := range s.StatusCodes {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(&out, "emulator_http_requests_total{%s,status=%q} %d\n",
+				metricLabels(s), status, s.StatusCodes[status])
+		}
+	}
+
+	out.WriteString("# HELP emulator_http_request_duration_seconds HTTP request latency by route and mode.\n")
+	out.WriteString("# TYPE emulator_http_request_duration_seconds histogram\n")
+	for _, s := range snapshots {
+		labels := metricLabels(s)
+		for _, bound := range latencyBuckets {
+			le := formatBound(bound)
+			fmt.Fprintf(&out, "emulator_http_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, le, s.Buckets[le])
+		}
+		fmt.Fprintf(&out, "emulator_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, s.Requests)
+		fmt.Fprintf(&out, "emulator_http_request_duration_seconds_sum{%s} %g\n", labels, s.LatencySeconds)
+		fmt.Fprintf(&out, "emulator_http_request_duration_seconds_count{%s} %d\n", labels, s.Requests)
+	}
+
+	return out.String()
+}
+
+// Reset clears all collected metrics
+func (m *Metrics) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.routes = make(map[routeKey]*routeMetrics)
+}
+
+// metricLabels formats the common Prometheus labels of a series
+func metricLabels(s RouteMetricsSnapshot) string {
+	return fmt.Sprintf("method=%q,route=%q,mode=%q", s.Method, s.Route, s.Mode)
+}
+
+// formatBound formats a histogram bucket bound
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+// metricsMiddleware records request metrics for every route
+func metricsMiddleware(metrics *Metrics, mode string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.Observe(c.Request.Method, route, mode, c.Writer.Status(), time.Since(startTime))
+	}
+}
+
+// handleMetrics exports the collected HTTP metrics
+func (s *Server) handleMetrics(c *gin.Context) {
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"routes": s.metrics.Snapshot(),
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(s.metrics.Prometheus()))
+}
@@ -0,0 +1,815 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIVersion is the OpenAPI specification version of the served document
+const OpenAPIVersion = "3.0.3"
+
+// handleOpenAPI serves the OpenAPI document describing all endpoints
+func (s *Server) handleOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, s.OpenAPISpec())
+}
+
+// OpenAPISpec returns the OpenAPI 3 document for the emulator HTTP API
+func (s *Server) OpenAPISpec() gin.H {
+	return gin.H{
+		"openapi": OpenAPIVersion,
+		"info": gin.H{
+			"title":       "Edge Computing Emulator",
+			"description": "ESI and Akamai Property Manager emulator API",
+			"version":     "0.1.0",
+		},
+		"paths":      openAPIPaths(),
+		"components": gin.H{"schemas": openAPISchemas()},
+	}
+}
+
+// openAPIPaths returns the path items for every registered route
+func openAPIPaths() gin.H {
+	return gin.H{
+		"/": gin.H{
+			"get": openAPIOperation("getRoot", "Server information and available endpoints", nil, jsonObject()),
+		},
+		"/openapi.json": gin.H{
+			"get": openAPIOperation("getOpenAPI", "OpenAPI document for this API", nil, jsonObject()),
+		},
+		"/process": gin.H{
+			"post": withDocumentBody(withStreamingBody(withSizeLimit(withQueryParam(openAPIOperation("processESI", "Process ESI content",
+				schemaRef("ProcessRequest"), schemaRef("ProcessResponse")),
+				"raw", "boolean", "Return the processed HTML as the body with computed response headers")))),
+		},
+		"/process/json": gin.H{
+			"post": withSizeLimit(openAPIOperation("processJSON",
+				"Assemble a JSON document, replacing its $esi:include directives with the JSON they fetch (experimental)",
+				jsonObject(), jsonObject())),
+		},
+		"/process/degradation": gin.H{
+			"post": withSizeLimit(openAPIOperation("processDegradation",
+				"Process ESI content and render it as a CDN without ESI would deliver it, with the diff of the two",
+				schemaRef("ProcessRequest"), schemaRef("DegradationReport"))),
+		},
+		"/process/by-name": gin.H{
+			"post": withQueryParam(openAPIOperation("processByName",
+				"Process a template from the template store, referenced as name@version or as name for the pinned or latest version",
+				schemaRef("ProcessByNameRequest"), schemaRef("ProcessResponse")),
+				"raw", "boolean", "Return the processed HTML as the body with computed response headers"),
+		},
+		"/examples": gin.H{
+			"get": openAPIOperation("listExamples", "List available examples", nil, jsonObject()),
+		},
+		"/examples/{name}": gin.H{
+			"get": withPathParam(openAPIOperation("getExample", "Get a specific example", nil, schemaRef("Example")), "name"),
+		},
+		"/fragments/{name}": gin.H{
+			"get": withQueryParam(withQueryParam(withPathParam(openAPIOperation("getFragment", "Get a test fragment", nil, nil), "name"),
+				"expires", "integer", "Expiry of a signed URL as a Unix timestamp, required with a fragment signing key"),
+				"signature", "string", "Hex HMAC-SHA256 of the path and expiry, required with a fragment signing key"),
+		},
+		"/frequency/{pixel}": gin.H{
+			"get": withQueryParam(withQueryParam(withPathParam(openAPIOperation("recordFrequencyFire",
+				"Record a beacon fire and set or refresh its frequency cookie", nil, schemaRef("FrequencyResult")), "pixel"),
+				"cap", "integer", "Maximum fires per period (0 for uncapped)"),
+				"period", "string", "session (default) or day"),
+		},
+		"/frequency": gin.H{
+			"get":    openAPIOperation("getFrequencyStats", "Beacon fire and duplicate counts per pixel", nil, jsonObject()),
+			"delete": openAPIOperation("resetFrequencyStats", "Reset beacon fire counts", nil, jsonObject()),
+		},
+		"/sink/{path}": gin.H{
+			"post": withNoContent(withPathParam(openAPIOperation("captureBeacon",
+				"Capture a beacon request; every method and any path under /sink is accepted", nil, nil), "path")),
+		},
+		"/sink/captures": gin.H{
+			"get": withQueryParam(withQueryParam(withQueryParam(withQueryParam(withQueryParam(openAPIOperation("listSinkCaptures",
+				"Requests captured by the beacon sink, oldest first", nil, schemaRef("SinkCapturesResponse")),
+				"method", "string", "Request method"),
+				"path", "string", "Prefix of the path after /sink"),
+				"contains", "string", "Substring of the URL or body"),
+				"since", "string", "Captures received at or after this RFC 3339 time"),
+				"limit", "integer", "Most recent captures returned"),
+			"delete": openAPIOperation("clearSinkCaptures", "Clear the beacon sink captures", nil, jsonObject()),
+		},
+		"/sink/assert": gin.H{
+			"post": openAPIOperation("assertSinkCaptures", "Check expectations against the beacon sink captures",
+				schemaRef("SinkAssertRequest"), schemaRef("SinkAssertResult")),
+		},
+		"/sessions": gin.H{
+			"get": openAPIOperation("listSessions", "Sessions named by the X-Emulator-Session header, by ID", nil,
+				gin.H{"type": "array", "items": schemaRef("Session")}),
+		},
+		"/sessions/{id}": gin.H{
+			"get": withPathParam(openAPIOperation("getSession", "A session's cookies and variables", nil, schemaRef("Session")), "id"),
+			"put": withPathParam(openAPIOperation("putSession", "Replace a session's cookies and variables",
+				schemaRef("SessionUpdate"), schemaRef("Session")), "id"),
+			"delete": withPathParam(openAPIOperation("deleteSession", "Drop a session", nil, jsonObject()), "id"),
+		},
+		"/property-manager/process": gin.H{
+			"post": withSizeLimit(openAPIOperation("processPropertyManager", "Process Property Manager rules",
+				schemaRef("PropertyManagerRequest"), schemaRef("PropertyManagerResponse"))),
+		},
+		"/integrated/process": gin.H{
+			"post": withSizeLimit(withIntegratedResponses(openAPIOperation("processIntegrated", "Process a request through Property Manager and ESI",
+				schemaRef("IntegratedProcessRequest"), schemaRef("IntegratedProcessResponse")))),
+		},
+		"/fastly/process": gin.H{
+			"post": withSizeLimit(withVCLErrorResponse(openAPIOperation("processFastly", "Process a request through the Fastly VCL and ESI",
+				schemaRef("FastlyProcessRequest"), schemaRef("FastlyProcessResponse")))),
+		},
+		"/stats": gin.H{
+			"get": openAPIOperation("getStats", "Get processing statistics", nil, jsonObject()),
+		},
+		"/history": gin.H{
+			"get": withQueryParam(withQueryParam(withQueryParam(withQueryParam(withQueryParam(openAPIOperation("getHistory",
+				"Recorded processing runs, newest first, and their summaries by template, mode and version", nil, schemaRef("HistoryResponse")),
+				"version", "string", "Emulator version"),
+				"mode", "string", "ESI mode"),
+				"template", "string", "Prefix of the template hash"),
+				"since", "string", "Runs started at or after this RFC 3339 time"),
+				"limit", "integer", "Most recent runs returned (default 100)"),
+		},
+		"/stats/reset": gin.H{
+			"post": openAPIOperation("resetStats", "Reset processing statistics, returning the totals up to the reset", nil, jsonObject()),
+		},
+		"/cache": gin.H{
+			"delete": openAPIOperation("clearCache", "Clear the fragment cache", nil, jsonObject()),
+		},
+		"/cache/purge": gin.H{
+			"post": openAPIOperation("purgeCache", "Purge cached fragments by URL or tag; soft purges mark them stale",
+				schemaRef("PurgeRequest"), schemaRef("PurgeResult")),
+		},
+		"/cache/entries": gin.H{
+			"get": withQueryParam(withQueryParam(openAPIOperation("listCacheEntries", "Cached fragments ordered by key",
+				nil, schemaRef("CacheEntriesResponse")),
+				"offset", "integer", "Number of entries to skip"),
+				"limit", "integer", "Maximum entries to return (default 50, at most 500)"),
+		},
+		"/cache/entries/{key}": gin.H{
+			"get": withPathParam(openAPIOperation("getCacheEntry", "A cached fragment and its content, by path-escaped URL",
+				nil, schemaRef("CacheEntryResponse")), "key"),
+		},
+		"/health": gin.H{
+			"get": withNotReady(openAPIOperation("getHealth", "Component readiness", nil, jsonObject())),
+		},
+		"/livez": gin.H{
+			"get": openAPIOperation("getLiveness", "Liveness check that touches no dependency", nil, jsonObject()),
+		},
+		"/metrics": gin.H{
+			"get": withQueryParam(openAPIOperation("getMetrics", "Per-route HTTP metrics in Prometheus text format", nil, nil),
+				"format", "string", "Set to json for a JSON snapshot"),
+		},
+		"/admin/log-levels": gin.H{
+			"get": openAPIOperation("getLogLevels", "Default and per-component log levels", nil, jsonObject()),
+			"put": openAPIOperation("setLogLevel", "Change the log level of a component at runtime",
+				schemaRef("LogLevelRequest"), jsonObject()),
+		},
+		"/admin/faults": gin.H{
+			"get": openAPIOperation("getFaults", "Fault injection rules of include fetches", nil, schemaRef("FaultsRequest")),
+			"put": openAPIOperation("setFaults", "Replace the fault injection rules of include fetches",
+				schemaRef("FaultsRequest"), schemaRef("FaultsRequest")),
+		},
+	}
+}
+
+// openAPIOperation builds an operation object with optional request and response schemas
+func openAPIOperation(operationID, summary string, requestSchema, responseSchema gin.H) gin.H {
+	operation := gin.H{
+		"operationId": operationID,
+		"summary":     summary,
+	}
+
+	if requestSchema != nil {
+		operation["requestBody"] = gin.H{
+			"required": true,
+			"content":  gin.H{"application/json": gin.H{"schema": requestSchema}},
+		}
+	}
+
+	success := gin.H{"description": "Successful response"}
+	if responseSchema != nil {
+		success["content"] = gin.H{"application/json": gin.H{"schema": responseSchema}}
+	} else {
+		success["content"] = gin.H{"text/html": gin.H{"schema": gin.H{"type": "string"}}}
+	}
+
+	operation["responses"] = gin.H{
+		"200": success,
+		"default": gin.H{
+			"description": "Error response",
+			"content":     gin.H{"application/json": gin.H{"schema": schemaRef("ErrorResponse")}},
+		},
+	}
+
+	return operation
+}
+
+// withPathParam adds a required string path parameter to an operation
+func withPathParam(operation gin.H, name string) gin.H {
+	operation["parameters"] = []gin.H{
+		{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   gin.H{"type": "string"},
+		},
+	}
+	return operation
+}
+
+// withNoContent makes an operation answer 204 No Content on success
+func withNoContent(operation gin.H) gin.H {
+	responses := operation["responses"].(gin.H)
+	delete(responses, "200")
+	responses["204"] = gin.H{"description": "Captured"}
+	return operation
+}
+
+// withQueryParam adds an optional query parameter to an operation
+func withQueryParam(operation gin.H, name, paramType, description string) gin.H {
+	params, _ := operation["parameters"].([]gin.H)
+	operation["parameters"] = append(params, gin.H{
+		"name":        name,
+		"in":          "query",
+		"required":    false,
+		"description": description,
+		"schema":      gin.H{"type": paramType},
+	})
+	return operation
+}
+
+// withIntegratedResponses adds the redirect and access-denied responses of the integrated endpoint
+func withIntegratedResponses(operation gin.H) gin.H {
+	responses := operation["responses"].(gin.H)
+	responses["3XX"] = gin.H{
+		"description": "Redirect demanded by Property Manager rules",
+		"headers":     gin.H{"Location": gin.H{"schema": gin.H{"type": "string"}}},
+	}
+	responses["403"] = gin.H{
+		"description": "Request denied by access control rules",
+		"content":     gin.H{"text/html": gin.H{"schema": gin.H{"type": "string"}}},
+	}
+	return operation
+}
+
+// withVCLErrorResponse adds the synthetic response of VCL error statements
+func withVCLErrorResponse(operation gin.H) gin.H {
+	responses := operation["responses"].(gin.H)
+	responses["4XX"] = gin.H{
+		"description": "Synthetic response of a VCL error statement",
+		"content":     gin.H{"text/html": gin.H{"schema": gin.H{"type": "string"}}},
+	}
+	return operation
+}
+
+// withNotReady adds the 503 response returned while a component is not ready
+func withNotReady(operation gin.H) gin.H {
+	responses := operation["responses"].(gin.H)
+	responses["503"] = gin.H{
+		"description": "A component is not ready",
+		"content":     gin.H{"application/json": gin.H{"schema": jsonObject()}},
+	}
+	return operation
+}
+
+// withSizeLimit adds the 413 response returned for bodies over the configured limit, and
+// the 415 response for request bodies neither gzip-compressed nor uncompressed
+func withSizeLimit(operation gin.H) gin.H {
+	responses := operation["responses"].(gin.H)
+	responses["413"] = gin.H{
+		"description": "Request body exceeds the configured size limit",
+		"content":     gin.H{"application/json": gin.H{"schema": schemaRef("ErrorResponse")}},
+	}
+	responses["415"] = gin.H{
+		"description": "Content-Encoding of the request body is not gzip or identity",
+		"content":     gin.H{"application/json": gin.H{"schema": schemaRef("ErrorResponse")}},
+	}
+	return operation
+}
+
+// withStreamingBody adds the newline-delimited JSON request and response of streaming /process calls
+func withStreamingBody(operation gin.H) gin.H {
+	content := operation["requestBody"].(gin.H)["content"].(gin.H)
+	content[ndjsonContentType] = gin.H{"schema": schemaRef("ProcessRequest")}
+
+	success := operation["responses"].(gin.H)["200"].(gin.H)["content"].(gin.H)
+	success[ndjsonContentType] = gin.H{"schema": schemaRef("StreamResult")}
+	return operation
+}
+
+// withDocumentBody documents that /process also accepts HTML documents in any charset,
+// returning them processed in that charset
+func withDocumentBody(operation gin.H) gin.H {
+	content := operation["requestBody"].(gin.H)["content"].(gin.H)
+	content["text/html"] = gin.H{"schema": gin.H{"type": "string"}}
+
+	success := operation["responses"].(gin.H)["200"].(gin.H)["content"].(gin.H)
+	success["text/html"] = gin.H{"schema": gin.H{"type": "string"}}
+	return operation
+}
+
+// cacheEntrySchema describes a cached fragment, with its content when withContent is set
+func cacheEntrySchema(withContent bool) gin.H {
+	str := gin.H{"type": "string"}
+	properties := gin.H{
+		"key":          str,
+		"size":         gin.H{"type": "integer"},
+		"ttlRemaining": gin.H{"type": "number"},
+		"expired":      gin.H{"type": "boolean"},
+		"storedAt":     gin.H{"type": "string", "format": "date-time"},
+		"expiresAt":    gin.H{"type": "string", "format": "date-time"},
+		"hits":         gin.H{"type": "integer"},
+		"vary":         stringMap(),
+		"etag":         str,
+		"lastModified": str,
+		"tags":         stringArray(),
+		"softPurged":   gin.H{"type": "boolean"},
+	}
+	if withContent {
+		properties["content"] = str
+	}
+	return gin.H{"type": "object", "properties": properties}
+}
+
+// schemaRef returns a reference to a component schema
+func schemaRef(name string) gin.H {
+	return gin.H{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonObject returns a free-form object schema
+func jsonObject() gin.H {
+	return gin.H{"type": "object", "additionalProperties": true}
+}
+
+// stringMap returns a schema for a map of strings
+func stringMap() gin.H {
+	return gin.H{"type": "object", "additionalProperties": gin.H{"type": "string"}}
+}
+
+// stringArray returns a schema for an array of strings
+func stringArray() gin.H {
+	return gin.H{"type": "array", "items": gin.H{"type": "string"}}
+}
+
+// openAPISchemas returns the component schemas for request and response types
+func openAPISchemas() gin.H {
+	integer := gin.H{"type": "integer", "format": "int64"}
+	str := gin.H{"type": "string"}
+
+	return gin.H{
+		"ProcessContext": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"baseUrl":        str,
+				"headers":        stringMap(),
+				"cookies":        stringMap(),
+				"depth":          gin.H{"type": "integer"},
+				"preserveOutput": gin.H{"type": "boolean"},
+				"noCache":        gin.H{"type": "boolean"},
+				"variables":      stringMap(),
+				"data":           jsonObject(),
+			},
+		},
+		"ProcessRequest": gin.H{
+			"type":     "object",
+			"required": []string{"html"},
+			"properties": gin.H{
+				"html":    str,
+				"context": schemaRef("ProcessContext"),
+			},
+		},
+		"ProcessResponse": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"result":    str,
+				"stats":     schemaRef("StatsInfo"),
+				"truncated": gin.H{"type": "boolean"},
+				"errorPage": gin.H{"type": "string", "description": "Why the result is the error page, when includes failed past the policy"},
+				"template":  gin.H{"type": "string", "description": "name@version of the template processed by /process/by-name"},
+			},
+		},
+		"ProcessByNameRequest": gin.H{
+			"type":     "object",
+			"required": []string{"template"},
+			"properties": gin.H{
+				"template": gin.H{"type": "string", "description": "Template reference, such as checkout@v42"},
+				"context":  schemaRef("ProcessContext"),
+			},
+		},
+		"DegradationReport": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"processed":   str,
+				"unprocessed": str,
+				"diff":        gin.H{"type": "string", "description": "Unified diff from processed to unprocessed, empty when they match"},
+				"notes": gin.H{"type": "array", "items": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"line":    gin.H{"type": "integer"},
+						"feature": str,
+						"effect":  str,
+					},
+				}},
+			},
+		},
+		"StatsInfo": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"processingTime": integer,
+				"mode":           str,
+				"requests":       integer,
+				"cacheHits":      integer,
+				"cacheMiss":      integer,
+				"errors":         integer,
+				"totalTime":      integer,
+			},
+		},
+		"StreamResult": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"line":           gin.H{"type": "integer"},
+				"result":         str,
+				"error":          str,
+				"processingTime": integer,
+			},
+		},
+		"FrequencyResult": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"pixel":     str,
+				"count":     gin.H{"type": "integer"},
+				"cap":       gin.H{"type": "integer"},
+				"period":    str,
+				"duplicate": gin.H{"type": "boolean"},
+			},
+		},
+		"FrequencyStats": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"pixel":      str,
+				"fires":      integer,
+				"duplicates": integer,
+			},
+		},
+		"SinkCapture": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"id":         integer,
+				"time":       gin.H{"type": "string", "format": "date-time"},
+				"method":     str,
+				"path":       str,
+				"url":        str,
+				"headers":    stringMap(),
+				"body":       str,
+				"truncated":  gin.H{"type": "boolean"},
+				"remoteAddr": str,
+			},
+		},
+		"HistoryRun": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"id":             integer,
+				"time":           gin.H{"type": "string", "format": "date-time"},
+				"version":        str,
+				"mode":           str,
+				"templateHash":   gin.H{"type": "string", "description": "Hex SHA-256 of the processed page"},
+				"durationMs":     gin.H{"type": "number"},
+				"includes":       integer,
+				"failedIncludes": integer,
+				"error":          str,
+			},
+		},
+		"HistorySummary": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"version":        str,
+				"mode":           str,
+				"templateHash":   str,
+				"runs":           integer,
+				"first":          gin.H{"type": "string", "format": "date-time"},
+				"last":           gin.H{"type": "string", "format": "date-time"},
+				"avgMs":          gin.H{"type": "number"},
+				"minMs":          gin.H{"type": "number"},
+				"maxMs":          gin.H{"type": "number"},
+				"failedIncludes": integer,
+				"errors":         gin.H{"type": "integer", "format": "int64", "description": "Runs whose processing failed"},
+			},
+		},
+		"HistoryResponse": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"runs":      gin.H{"type": "array", "items": schemaRef("HistoryRun")},
+				"summaries": gin.H{"type": "array", "items": schemaRef("HistorySummary")},
+				"dropped":   gin.H{"type": "integer", "format": "int64", "description": "Runs since startup that were not recorded"},
+			},
+		},
+		"SinkCapturesResponse": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"captures": gin.H{"type": "array", "items": schemaRef("SinkCapture")},
+				"total":    integer,
+			},
+		},
+		"SinkAssertRequest": gin.H{
+			"type":     "object",
+			"required": []string{"expectations"},
+			"properties": gin.H{
+				"expectations": gin.H{"type": "array", "items": schemaRef("SinkExpectation")},
+				"ordered":      gin.H{"type": "boolean", "description": "Each expectation's first match comes after the previous one's"},
+				"since":        gin.H{"type": "string", "format": "date-time"},
+			},
+		},
+		"SinkExpectation": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"name":   str,
+				"method": str,
+				"url":    gin.H{"type": "string", "description": "Regular expression searched in the path and query"},
+				"params": gin.H{"type": "object", "additionalProperties": schemaRef("ParamExpectation")},
+				"count":  gin.H{"type": "integer", "description": "Matching requests expected; at least one when omitted"},
+			},
+		},
+		"ParamExpectation": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"equals":    str,
+				"matches":   str,
+				"hashed":    gin.H{"type": "string", "description": "Plain value whose hex digest the parameter carries"},
+				"algorithm": gin.H{"type": "string", "enum": []string{"md5", "sha1", "sha256"}},
+				"salt":      str,
+				"hashType":  gin.H{"type": "string", "enum": []string{"hpr", "hpo"}},
+			},
+		},
+		"SinkAssertResult": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"passed": gin.H{"type": "boolean"},
+				"results": gin.H{"type": "array", "items": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"name":     str,
+						"passed":   gin.H{"type": "boolean"},
+						"count":    integer,
+						"captures": gin.H{"type": "array", "items": integer},
+						"message":  str,
+					},
+				}},
+			},
+		},
+		"Session": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"id":        str,
+				"cookies":   stringMap(),
+				"variables": stringMap(),
+				"created":   gin.H{"type": "string", "format": "date-time"},
+				"lastUsed":  gin.H{"type": "string", "format": "date-time"},
+				"requests":  gin.H{"type": "integer"},
+			},
+		},
+		"SessionUpdate": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"cookies":   stringMap(),
+				"variables": stringMap(),
+			},
+		},
+		"LogLevelRequest": gin.H{
+			"type":     "object",
+			"required": []string{"component"},
+			"properties": gin.H{
+				"component": str,
+				"level":     str,
+			},
+		},
+		"FaultsRequest": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"rules": gin.H{
+					"type": "array",
+					"items": gin.H{
+						"type":     "object",
+						"required": []string{"host"},
+						"properties": gin.H{
+							"host":      str,
+							"percent":   gin.H{"type": "number"},
+							"latencyMs": gin.H{"type": "integer"},
+							"drop":      gin.H{"type": "boolean"},
+							"status":    gin.H{"type": "integer"},
+						},
+					},
+				},
+			},
+		},
+		"ErrorResponse": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"error":   str,
+				"message": str,
+			},
+		},
+		"CacheEntryInfo":     cacheEntrySchema(false),
+		"CacheEntryResponse": cacheEntrySchema(true),
+		"CacheEntriesResponse": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"entries": gin.H{"type": "array", "items": schemaRef("CacheEntryInfo")},
+				"total":   gin.H{"type": "integer"},
+				"offset":  gin.H{"type": "integer"},
+				"limit":   gin.H{"type": "integer"},
+			},
+		},
+		"PurgeRequest": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"urls": stringArray(),
+				"tags": stringArray(),
+				"soft": gin.H{"type": "boolean"},
+			},
+		},
+		"PurgeResult": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"purged": gin.H{"type": "integer"},
+				"keys":   stringArray(),
+				"soft":   gin.H{"type": "boolean"},
+			},
+		},
+		"Example": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"name":        str,
+				"description": str,
+				"html":        str,
+				"modes":       stringArray(),
+			},
+		},
+		"HTTPContext": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"Headers":   stringMap(),
+				"Cookies":   stringMap(),
+				"Variables": stringMap(),
+				"Path":      str,
+				"Method":    str,
+				"Host":      str,
+				"Query":     str,
+				"ClientIP":  str,
+				"UserAgent": str,
+			},
+		},
+		"Rule": jsonObject(),
+		"RuleResult": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"MatchedRules":      stringArray(),
+				"ExecutedBehaviors": stringArray(),
+				"ModifiedHeaders":   stringMap(),
+				"RemovedHeaders":    stringArray(),
+				"ResponseContent":   str,
+				"Variables":         stringMap(),
+				"Errors":            stringArray(),
+				"RedirectLocation":  str,
+				"RedirectStatus":    gin.H{"type": "integer"},
+				"RewrittenURL":      str,
+				"RewrittenQuery":    str,
+				"ConstructedResponse": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"Status":  gin.H{"type": "integer"},
+						"Headers": stringMap(),
+						"Body":    str,
+					},
+				},
+				"Trace": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"RulesEvaluated":    gin.H{"type": "integer"},
+						"CriteriaEvaluated": gin.H{"type": "integer"},
+						"EvaluationTime":    gin.H{"type": "integer", "description": "Nanoseconds"},
+					},
+				},
+				"DownstreamCache": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"Behavior":       str,
+						"Headers":        stringMap(),
+						"RemovedHeaders": stringArray(),
+					},
+				},
+				"Cookies": gin.H{
+					"type": "array",
+					"items": gin.H{
+						"type": "object",
+						"properties": gin.H{
+							"Action":   gin.H{"type": "string", "enum": []string{"set", "rewrite", "delete"}},
+							"Name":     str,
+							"Value":    str,
+							"Domain":   str,
+							"Path":     str,
+							"SameSite": str,
+							"Secure":   gin.H{"type": "boolean"},
+							"HTTPOnly": gin.H{"type": "boolean"},
+							"MaxAge":   gin.H{"type": "integer"},
+							"Expires":  gin.H{"type": "string", "format": "date-time"},
+						},
+					},
+				},
+				"LogFields": gin.H{
+					"type": "array",
+					"items": gin.H{
+						"type": "object",
+						"properties": gin.H{
+							"Name":  str,
+							"Value": str,
+						},
+					},
+				},
+				"ESI": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"Enabled":       gin.H{"type": "boolean"},
+						"EnableViaHTTP": gin.H{"type": "boolean"},
+						"ContentTypes":  stringArray(),
+					},
+				},
+			},
+		},
+		"PropertyManagerRequest": gin.H{
+			"type":     "object",
+			"required": []string{"rules", "context"},
+			"properties": gin.H{
+				"rules":   gin.H{"type": "array", "items": schemaRef("Rule")},
+				"context": schemaRef("HTTPContext"),
+			},
+		},
+		"PropertyManagerResponse": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"result": schemaRef("RuleResult"),
+				"stats":  schemaRef("StatsInfo"),
+			},
+		},
+		"IntegratedProcessRequest": gin.H{
+			"type":     "object",
+			"required": []string{"html", "context"},
+			"properties": gin.H{
+				"html":          str,
+				"context":       schemaRef("HTTPContext"),
+				"originHeaders": stringMap(),
+			},
+		},
+		"FastlyProcessRequest": gin.H{
+			"type":     "object",
+			"required": []string{"html", "context"},
+			"properties": gin.H{
+				"html":          str,
+				"context":       schemaRef("HTTPContext"),
+				"originStatus":  gin.H{"type": "integer"},
+				"originHeaders": stringMap(),
+			},
+		},
+		"FastlyProcessResponse": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"fastly": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"backend": gin.H{
+							"type": "object",
+							"properties": gin.H{
+								"name": str,
+								"host": str,
+								"port": gin.H{"type": "integer"},
+								"ssl":  gin.H{"type": "boolean"},
+							},
+						},
+						"url":             str,
+						"requestHeaders":  stringMap(),
+						"return":          str,
+						"esi":             gin.H{"type": "boolean"},
+						"status":          gin.H{"type": "integer"},
+						"responseHeaders": stringMap(),
+					},
+				},
+				"processedHtml": str,
+				"esiErrorPage":  str,
+				"stats":         schemaRef("StatsInfo"),
+			},
+		},
+		"IntegratedProcessResponse": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"propertyManager": schemaRef("RuleResult"),
+				"response":        schemaRef("RuleResult"),
+				"processedHtml":   str,
+				"esiEnabled":      gin.H{"type": "boolean"},
+				"esiSkipReason":   str,
+				"esiErrorPage":    str,
+				"stats":           schemaRef("StatsInfo"),
+			},
+		},
+	}
+}
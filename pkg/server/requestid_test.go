@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer starts an ESI server with the given options
+func newTestServer(t *testing.T, options ...Option) *httptest.Server {
+	options = append([]Option{WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5}))}, options...)
+	ts := httptest.NewServer(New(Config{Mode: "esi"}, options...).Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// postJSON posts body as JSON with the given headers and returns the closed response
+func postJSON(t *testing.T, url string, body interface{}, headers map[string]string) *http.Response {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var received []string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(RequestIDHeader))
+		w.Write([]byte("<p>Fragment</p>"))
+	}))
+	t.Cleanup(origin.Close)
+	ts := newTestServer(t)
+
+	process := func(requestID string) *http.Response {
+		headers := map[string]string{}
+		if requestID != "" {
+			headers[RequestIDHeader] = requestID
+		}
+		resp := postJSON(t, ts.URL+"/process", ProcessRequest{HTML: `<esi:include src="/fragment"></esi:include>`,
+			Context: &esi.ProcessContext{BaseURL: origin.URL}}, headers)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp
+	}
+
+	resp := process("edge-req-1")
+	assert.Equal(t, "edge-req-1", resp.Header.Get(RequestIDHeader), "incoming IDs are honored")
+
+	resp = process("")
+	generated := resp.Header.Get(RequestIDHeader)
+	assert.Len(t, generated, 32)
+
+	resp = process(strings.Repeat("x", 200))
+	assert.Len(t, resp.Header.Get(RequestIDHeader), 32, "oversized IDs are replaced")
+
+	require.Len(t, received, 3)
+	assert.Equal(t, []string{"edge-req-1", generated}, received[:2], "include requests carry the request ID")
+
+	statsResp, err := http.Get(ts.URL + "/stats")
+	require.NoError(t, err)
+	defer statsResp.Body.Close()
+	var response struct {
+		Stats map[string]interface{} `json:"stats"`
+	}
+	require.NoError(t, json.NewDecoder(statsResp.Body).Decode(&response))
+	assert.Equal(t, float64(3), response.Stats["includeFetchTime"].(map[string]interface{})["count"])
+	assert.Contains(t, response.Stats["includeHosts"], strings.TrimPrefix(origin.URL, "http://"))
+	assert.Contains(t, response.Stats, "slowIncludes")
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package server
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setReusePort reports that SO_REUSEPORT is not available on this platform
+func setReusePort(fd uintptr) error {
+	return fmt.Errorf("listen: SO_REUSEPORT is not supported on %s", runtime.GOOS)
+}
@@ -3,13 +3,20 @@ package server
 import (
 	"context"
 	"fmt"
+	"html"
+	"io"
+	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/fastly"
+	"github.com/edge-computing/emulator-suite/pkg/history"
 	"github.com/edge-computing/emulator-suite/pkg/propertymanager"
+	"github.com/edge-computing/emulator-suite/pkg/templates"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,6 +26,25 @@ type Config struct {
 	Port  int    `json:"port"`
 	Debug bool   `json:"debug"`
 	Mode  string `json:"mode"`
+
+	// Size limits in bytes; zero selects the defaults
+	MaxBodySize     int64 `json:"maxBodySize"`
+	MaxResponseSize int64 `json:"maxResponseSize"`
+
+	// CORS policy; the zero value allows any origin without credentials
+	CORS CORSConfig `json:"cors"`
+
+	// Sockets to accept connections on; the zero value listens on Port on all interfaces
+	Listen ListenConfig `json:"listen"`
+
+	// FragmentSigningKey makes /fragments only serve URLs signed with this key, as by the
+	// sign_url ESI function, emulating a token-protected fragment origin; empty serves all
+	FragmentSigningKey string `json:"-"`
+
+	// EarlyHints sends a 103 Early Hints response with the page's preload links before
+	// processing documents sent as the request body; the processor's PreloadHints sets
+	// how many includes are hinted
+	EarlyHints bool `json:"earlyHints"`
 }
 
 // Server represents the HTTP server that can handle both ESI and Property Manager
@@ -29,6 +55,19 @@ type Server struct {
 	router            *gin.Engine
 	server            *http.Server
 	emulatorType      string
+	metrics           *Metrics
+	library           *Library
+	frequency         *FrequencyTracker
+	sink              *BeaconSink
+	sessions          *SessionStore
+	startedAt         time.Time
+	readinessChecks   []namedCheck
+	logLevels         LogLevelController
+	accessLog         AccessLogFunc
+	edgeAccessLog     io.Writer
+	fastlyVCL         *fastly.VCL
+	history           *history.Store
+	templates         *templates.Store
 }
 
 // ProcessRequest represents a request to process ESI content
@@ -41,6 +80,12 @@ type ProcessRequest struct {
 type ProcessResponse struct {
 	Result string    `json:"result"`
 	Stats  StatsInfo `json:"stats"`
+	// Truncated is set when includes were substituted because the processing budget was spent
+	Truncated bool `json:"truncated,omitempty"`
+	// ErrorPage says why the result is the error page, when includes failed past the policy
+	ErrorPage string `json:"errorPage,omitempty"`
+	// Template is the name@version of the template processed by /process/by-name
+	Template string `json:"template,omitempty"`
 }
 
 // PropertyManagerRequest represents a request to process Property Manager rules
@@ -84,6 +129,9 @@ type Example struct {
 type IntegratedProcessRequest struct {
 	HTML    string                       `json:"html" binding:"required"`
 	Context *propertymanager.HTTPContext `json:"context" binding:"required"`
+	// OriginHeaders are the headers the origin sent with the HTML, such as Content-Type
+	// and Edge-Control (optional; an HTML response is assumed)
+	OriginHeaders map[string]string `json:"originHeaders,omitempty"`
 }
 
 // IntegratedProcessResponse represents the response from integrated processing
@@ -92,28 +140,84 @@ type IntegratedProcessResponse struct {
 	ResponseResult        *propertymanager.RuleResult `json:"response"`
 	ProcessedHTML         string                      `json:"processedHtml"`
 	ESIEnabled            bool                        `json:"esiEnabled"`
-	Stats                 StatsInfo                   `json:"stats"`
+	ESISkipReason         string                      `json:"esiSkipReason,omitempty"`
+	// ESIErrorPage says why processedHtml is the ESI error page, when includes failed
+	// past the policy
+	ESIErrorPage string    `json:"esiErrorPage,omitempty"`
+	Stats        StatsInfo `json:"stats"`
+}
+
+// Option configures a Server during construction
+type Option func(*Server)
+
+// WithESI serves the ESI endpoints with processor
+func WithESI(processor *esi.Processor) Option {
+	return func(s *Server) {
+		s.SetESIProcessor(processor)
+	}
+}
+
+// WithPropertyManager serves the Property Manager endpoints with pm
+func WithPropertyManager(pm *propertymanager.PropertyManager) Option {
+	return func(s *Server) {
+		s.SetPropertyManagerProcessor(pm)
+	}
+}
+
+// WithIntegrated serves the ESI, Property Manager and integrated endpoints
+func WithIntegrated(processor *esi.Processor, pm *propertymanager.PropertyManager) Option {
+	return func(s *Server) {
+		s.esiProcessor = processor
+		s.propertyProcessor = pm
+		s.emulatorType = "integrated"
+	}
+}
+
+// WithLibrary serves examples and fragments from an on-disk library
+func WithLibrary(library *Library) Option {
+	return func(s *Server) {
+		s.SetLibrary(library)
+	}
 }
 
-// New creates a new server
-func New(config Config) *Server {
+// New creates a new server configured by the given options
+func New(config Config, opts ...Option) *Server {
 	// Set Gin mode
 	if !config.Debug {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	router := gin.New()
-
-	// Add middleware
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
-	router.Use(corsMiddleware())
+	metrics := NewMetrics()
 
 	server := &Server{
-		config: config,
-		router: router,
+		config:    config,
+		router:    router,
+		metrics:   metrics,
+		frequency: NewFrequencyTracker(),
+		sink:      NewBeaconSink(),
+		sessions:  NewSessionStore(),
+		startedAt: time.Now(),
+	}
+	for _, opt := range opts {
+		opt(server)
 	}
 
+	// Add middleware
+	router.Use(requestIDMiddleware())
+	if server.accessLog != nil {
+		router.Use(accessLogMiddleware(server.accessLog))
+	} else {
+		router.Use(gin.Logger())
+	}
+	if server.edgeAccessLog != nil {
+		router.Use(edgeAccessLogMiddleware(server.edgeAccessLog))
+	}
+	router.Use(gin.Recovery())
+	router.Use(metricsMiddleware(metrics, config.Mode))
+	router.Use(corsMiddleware(config.CORS))
+	router.Use(bodyLimitMiddleware(server))
+
 	server.setupRoutes()
 	return server
 }
@@ -130,27 +234,69 @@ func (s *Server) SetPropertyManagerProcessor(processor *propertymanager.Property
 	s.emulatorType = "property-manager"
 }
 
+// SetLibrary sets the on-disk example and fragment library.
+// Entries from the library are served alongside, and take precedence over, the built-in ones.
+func (s *Server) SetLibrary(library *Library) {
+	s.library = library
+}
+
 // setupRoutes configures all HTTP routes
 func (s *Server) setupRoutes() {
 	// Root endpoint - status and configuration
 	s.router.GET("/", s.handleRoot)
-
-	// ESI endpoints
-	s.router.POST("/process", s.handleESIProcess)
+	s.router.GET("/openapi.json", s.handleOpenAPI)
+
+	// ESI endpoints; processing endpoints accept gzip request bodies
+	decompress := requestEncodingMiddleware(s)
+	s.router.POST("/process", decompress, s.handleESIProcess)
+	s.router.POST("/process/json", decompress, s.handleESIJSON)
+	s.router.POST("/process/degradation", decompress, s.handleESIDegradation)
+	s.router.POST("/process/by-name", decompress, s.handleESIProcessByName)
 	s.router.GET("/examples", s.handleListExamples)
 	s.router.GET("/examples/:name", s.handleGetExample)
 	s.router.GET("/fragments/:name", s.handleGetFragment)
 
+	// Beacon frequency capping endpoints
+	s.router.GET("/frequency/:pixel", s.handleFrequencyFire)
+	s.router.GET("/frequency", s.handleFrequencyStats)
+	s.router.DELETE("/frequency", s.handleFrequencyReset)
+
+	// Beacon sink: any request is captured, except the capture API at /sink/captures and
+	// the assertions at /sink/assert
+	s.router.Any("/sink/*path", s.handleSink)
+
+	// Sessions carried across requests by the X-Emulator-Session header
+	s.router.GET("/sessions", s.handleListSessions)
+	s.router.GET("/sessions/:id", s.handleGetSession)
+	s.router.PUT("/sessions/:id", s.handlePutSession)
+	s.router.DELETE("/sessions/:id", s.handleDeleteSession)
+
 	// Property Manager endpoints
-	s.router.POST("/property-manager/process", s.handlePropertyManagerProcess)
+	s.router.POST("/property-manager/process", decompress, s.handlePropertyManagerProcess)
 
 	// Integrated endpoints (when both processors are available)
-	s.router.POST("/integrated/process", s.handleIntegratedProcess)
+	s.router.POST("/integrated/process", decompress, s.handleIntegratedProcess)
+
+	// Fastly endpoints (when a VCL is configured)
+	s.router.POST("/fastly/process", decompress, s.handleFastlyProcess)
 
 	// Common endpoints
 	s.router.GET("/stats", s.handleStats)
+	s.router.GET("/history", s.handleHistory)
+	s.router.POST("/stats/reset", s.handleResetStats)
 	s.router.DELETE("/cache", s.handleClearCache)
+	s.router.POST("/cache/purge", s.handlePurge)
+	s.router.GET("/cache/entries", s.handleCacheEntries)
+	s.router.GET("/cache/entries/*key", s.handleCacheEntry)
 	s.router.GET("/health", s.handleHealth)
+	s.router.GET("/livez", s.handleLive)
+	s.router.GET("/metrics", s.handleMetrics)
+
+	// Admin endpoints
+	s.router.GET("/admin/log-levels", s.handleGetLogLevels)
+	s.router.PUT("/admin/log-levels", s.handleSetLogLevel)
+	s.router.GET("/admin/faults", s.handleGetFaults)
+	s.router.PUT("/admin/faults", s.handleSetFaults)
 }
 
 // handleRoot returns server information and available endpoints
@@ -173,14 +319,35 @@ func (s *Server) handleRoot(c *gin.Context) {
 			features = s.esiProcessor.GetFeatures()
 		}
 		endpoints = map[string]string{
-			"/process":         "POST - Process ESI content",
-			"/examples":        "GET - List available examples",
-			"/examples/:name":  "GET - Get specific example",
-			"/stats":           "GET - Get processing statistics",
-			"/cache":           "DELETE - Clear cache",
-			"/fragments/:name": "GET - Get test fragments",
-			"/health":          "GET - Health check",
+			"/process":             "POST - Process ESI content",
+			"/process/json":        "POST - Assemble a JSON document from $esi:include directives (experimental)",
+			"/process/degradation": "POST - Processed output beside the output without ESI, with their diff",
+			"/examples":            "GET - List available examples",
+			"/examples/:name":      "GET - Get specific example",
+			"/stats":               "GET - Get processing statistics",
+			"/stats/reset":         "POST - Reset processing statistics",
+			"/cache":               "DELETE - Clear cache",
+			"/cache/purge":         "POST - Purge fragments by URL or tag, soft to mark them stale",
+			"/cache/entries":       "GET - Cached fragments (?offset=&limit=)",
+			"/cache/entries/:key":  "GET - A cached fragment and its content",
+			"/fragments/:name":     "GET - Get test fragments",
+			"/frequency/:pixel":    "GET - Record a beacon fire and refresh its frequency cookie",
+			"/frequency":           "GET - Beacon fire counts, DELETE - Reset them",
+			"/sink/*path":          "ANY - Capture a beacon request (204)",
+			"/sink/captures":       "GET - Captured requests (?method=&path=&contains=&since=&limit=), DELETE - Clear them",
+			"/sink/assert":         "POST - Check expectations against the captured requests",
+			"/sessions":            "GET - Sessions named by the X-Emulator-Session header",
+			"/sessions/:id":        "GET - A session's cookies and variables, PUT - Replace them, DELETE - Drop the session",
+			"/health":              "GET - Component readiness (503 until ready)",
+			"/livez":               "GET - Liveness check",
+			"/openapi.json":        "GET - OpenAPI specification",
+			"/metrics":             "GET - HTTP metrics (Prometheus text, ?format=json)",
+			"/admin/log-levels":    "GET - Log levels, PUT - Change a component's log level",
+			"/admin/faults":        "GET - Include fault rules, PUT - Replace them",
 		}
+		s.fastlyEndpoints(endpoints)
+		s.historyEndpoints(endpoints)
+		s.templateEndpoints(endpoints)
 	case "property-manager":
 		if s.propertyProcessor != nil {
 			// Property Manager doesn't have stats yet, but we can add them
@@ -197,8 +364,55 @@ func (s *Server) handleRoot(c *gin.Context) {
 			"/property-manager/process": "POST - Process Property Manager rules",
 			"/stats":                    "GET - Get processing statistics",
 			"/cache":                    "DELETE - Clear cache",
-			"/health":                   "GET - Health check",
+			"/health":                   "GET - Component readiness (503 until ready)",
+			"/livez":                    "GET - Liveness check",
+			"/openapi.json":             "GET - OpenAPI specification",
+			"/metrics":                  "GET - HTTP metrics (Prometheus text, ?format=json)",
+			"/admin/log-levels":         "GET - Log levels, PUT - Change a component's log level",
 		}
+	case "integrated":
+		if s.esiProcessor != nil {
+			esiStats := s.esiProcessor.GetStats()
+			stats = gin.H{
+				"requests":  esiStats.Requests,
+				"cacheHits": esiStats.CacheHits,
+				"cacheMiss": esiStats.CacheMiss,
+				"errors":    esiStats.Errors,
+				"totalTime": esiStats.TotalTime,
+			}
+			features = s.esiProcessor.GetFeatures()
+		}
+		endpoints = map[string]string{
+			"/process":                  "POST - Process ESI content",
+			"/process/json":             "POST - Assemble a JSON document from $esi:include directives (experimental)",
+			"/process/degradation":      "POST - Processed output beside the output without ESI, with their diff",
+			"/property-manager/process": "POST - Process Property Manager rules",
+			"/integrated/process":       "POST - Process a request through Property Manager and ESI",
+			"/examples":                 "GET - List available examples",
+			"/examples/:name":           "GET - Get specific example",
+			"/stats":                    "GET - Get processing statistics",
+			"/stats/reset":              "POST - Reset processing statistics",
+			"/cache":                    "DELETE - Clear cache",
+			"/cache/purge":              "POST - Purge fragments by URL or tag, soft to mark them stale",
+			"/cache/entries":            "GET - Cached fragments (?offset=&limit=)",
+			"/cache/entries/:key":       "GET - A cached fragment and its content",
+			"/fragments/:name":          "GET - Get test fragments",
+			"/frequency/:pixel":         "GET - Record a beacon fire and refresh its frequency cookie",
+			"/frequency":                "GET - Beacon fire counts, DELETE - Reset them",
+			"/sink/*path":               "ANY - Capture a beacon request (204)",
+			"/sink/captures":            "GET - Captured requests (?method=&path=&contains=&since=&limit=), DELETE - Clear them",
+			"/sink/assert":              "POST - Check expectations against the captured requests",
+			"/sessions":                 "GET - Sessions named by the X-Emulator-Session header",
+			"/sessions/:id":             "GET - A session's cookies and variables, PUT - Replace them, DELETE - Drop the session",
+			"/health":                   "GET - Component readiness (503 until ready)",
+			"/livez":                    "GET - Liveness check",
+			"/openapi.json":             "GET - OpenAPI specification",
+			"/metrics":                  "GET - HTTP metrics (Prometheus text, ?format=json)",
+			"/admin/log-levels":         "GET - Log levels, PUT - Change a component's log level",
+			"/admin/faults":             "GET - Include fault rules, PUT - Replace them",
+		}
+		s.historyEndpoints(endpoints)
+		s.templateEndpoints(endpoints)
 	default:
 		stats = gin.H{
 			"requests":  0,
@@ -209,7 +423,8 @@ func (s *Server) handleRoot(c *gin.Context) {
 		}
 		features = []string{}
 		endpoints = map[string]string{
-			"/health": "GET - Health check",
+			"/health": "GET - Component readiness (503 until ready)",
+			"/livez":  "GET - Liveness check",
 		}
 	}
 
@@ -224,6 +439,32 @@ func (s *Server) handleRoot(c *gin.Context) {
 	})
 }
 
+// requestContext completes the context of a JSON process request: without one, the
+// request's own URL is the base URL, and the request's headers are added to it
+func requestContext(c *gin.Context, context *esi.ProcessContext) *esi.ProcessContext {
+	// Create default context if not provided
+	if context == nil {
+		context = &esi.ProcessContext{
+			BaseURL: fmt.Sprintf("%s://%s", getScheme(c), c.Request.Host),
+			Headers: make(map[string]string),
+			Cookies: make(map[string]string),
+			Depth:   0,
+		}
+	}
+
+	// Add request headers to context
+	if context.Headers == nil {
+		context.Headers = make(map[string]string)
+	}
+
+	for key, values := range c.Request.Header {
+		if len(values) > 0 {
+			context.Headers[key] = values[0]
+		}
+	}
+	return context
+}
+
 // handleESIProcess processes ESI content
 func (s *Server) handleESIProcess(c *gin.Context) {
 	if s.esiProcessor == nil {
@@ -234,39 +475,46 @@ func (s *Server) handleESIProcess(c *gin.Context) {
 		return
 	}
 
-	var req ProcessRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-		})
+	// Newline-delimited JSON requests are processed as a stream
+	if isNDJSON(c.Request) {
+		s.handleESIStream(c)
 		return
 	}
 
-	// Create default context if not provided
-	if req.Context == nil {
-		req.Context = &esi.ProcessContext{
-			BaseURL: fmt.Sprintf("%s://%s", getScheme(c), c.Request.Host),
-			Headers: make(map[string]string),
-			Cookies: make(map[string]string),
-			Depth:   0,
-		}
+	// Markup bodies are processed as documents in their own charset, or pass through
+	if isDocument(c.Request) {
+		s.handleESIDocument(c)
+		return
 	}
 
-	// Add request headers to context
-	if req.Context.Headers == nil {
-		req.Context.Headers = make(map[string]string)
+	var req ProcessRequest
+	if !s.bindJSON(c, &req) {
+		return
 	}
+	s.processESIRequest(c, req, "")
+}
 
-	for key, values := range c.Request.Header {
-		if len(values) > 0 {
-			req.Context.Headers[key] = values[0]
-		}
+// processESIRequest processes the page of a JSON process request and writes the
+// result, as JSON or, with ?raw=true, as the response body. template is the reference
+// the page was loaded by, if it was.
+func (s *Server) processESIRequest(c *gin.Context, req ProcessRequest, template string) {
+	req.Context = requestContext(c, req.Context)
+
+	// Collect response metadata set by ESI built-ins
+	req.Context.Response = esi.NewResponseMeta()
+	req.Context.RequestID = requestID(c)
+	session, inSession := s.beginSession(c)
+	if inSession {
+		withSession(req.Context, session)
 	}
 
 	startTime := time.Now()
 	result, err := s.esiProcessor.Process(req.HTML, *req.Context)
 	processingTime := time.Since(startTime).Milliseconds()
+	if inSession {
+		s.endSession(session, responseSetCookie(req.Context.Response)...)
+	}
+	setEdgeLog(c, s.esiEdgeLog(req.Context.Response, req.Context.NoCache))
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -276,6 +524,16 @@ func (s *Server) handleESIProcess(c *gin.Context) {
 		return
 	}
 
+	if !s.checkResponseSize(c, result) {
+		return
+	}
+
+	// Raw mode returns the processed HTML as the response body
+	if c.Query("raw") == "true" {
+		s.writeRawResponse(c, []byte(result), "utf-8", req.Context.Response, processingTime)
+		return
+	}
+
 	stats := s.esiProcessor.GetStats()
 	c.JSON(http.StatusOK, ProcessResponse{
 		Result: result,
@@ -288,9 +546,51 @@ func (s *Server) handleESIProcess(c *gin.Context) {
 			Errors:         stats.Errors,
 			TotalTime:      stats.TotalTime,
 		},
+		Truncated: req.Context.Response.Truncated,
+		ErrorPage: req.Context.Response.ErrorPage,
+		Template:  template,
 	})
 }
 
+// writeRawResponse writes processed HTML encoded in charsetName with the headers an
+// edge server would compute
+func (s *Server) writeRawResponse(c *gin.Context, html []byte, charsetName string, meta *esi.ResponseMeta, processingTime int64) {
+	c.Header("Content-Type", "text/html; charset="+charsetName)
+
+	// Cache advisory derived from the fragment cache configuration
+	cacheConfig := s.esiProcessor.GetConfig().Cache
+	if cacheConfig.Enabled && cacheConfig.TTL > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("max-age=%d", cacheConfig.TTL))
+	} else {
+		c.Header("Cache-Control", "no-store")
+	}
+
+	c.Header("X-ESI-Mode", s.esiProcessor.GetConfig().Mode)
+	c.Header("X-ESI-Processing-Time", strconv.FormatInt(processingTime, 10))
+
+	// Headers and status set by ESI built-ins take precedence
+	statusCode := http.StatusOK
+	if meta != nil {
+		if meta.Truncated {
+			c.Header("X-ESI-Truncated", "budget")
+		}
+		if meta.ErrorPage != "" {
+			c.Header("X-ESI-Error-Page", meta.ErrorPage)
+		}
+		for name, values := range meta.Headers {
+			c.Writer.Header().Del(name)
+			for _, value := range values {
+				c.Writer.Header().Add(name, value)
+			}
+		}
+		if meta.StatusCode != 0 {
+			statusCode = meta.StatusCode
+		}
+	}
+
+	c.Data(statusCode, c.Writer.Header().Get("Content-Type"), html)
+}
+
 // handlePropertyManagerProcess processes Property Manager rules
 func (s *Server) handlePropertyManagerProcess(c *gin.Context) {
 	if s.propertyProcessor == nil {
@@ -302,11 +602,7 @@ func (s *Server) handlePropertyManagerProcess(c *gin.Context) {
 	}
 
 	var req PropertyManagerRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-		})
+	if !s.bindJSON(c, &req) {
 		return
 	}
 
@@ -324,6 +620,7 @@ func (s *Server) handlePropertyManagerProcess(c *gin.Context) {
 		})
 		return
 	}
+	setEdgeLog(c, edgeLogFields{matchedRules: result.MatchedRules, logFields: result.LogFields})
 
 	c.JSON(http.StatusOK, PropertyManagerResponse{
 		Result: result,
@@ -350,11 +647,7 @@ func (s *Server) handleIntegratedProcess(c *gin.Context) {
 	}
 
 	var req IntegratedProcessRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-		})
+	if !s.bindJSON(c, &req) {
 		return
 	}
 
@@ -368,10 +661,22 @@ func (s *Server) handleIntegratedProcess(c *gin.Context) {
 		return
 	}
 
-	startTime := time.Now()
+	// The simulated request keeps its own request ID, if any, so it matches its origin logs
+	if httpReq.Header.Get(RequestIDHeader) == "" {
+		httpReq.Header.Set(RequestIDHeader, requestID(c))
+	}
+	session, inSession := s.beginSession(c)
+	if inSession {
+		addSessionCookies(httpReq, session)
+	}
+
+	origin := make(http.Header)
+	for key, value := range req.OriginHeaders {
+		origin.Set(key, value)
+	}
 
-	// Step 1: Property Manager processes the request
-	pmResult, err := s.propertyProcessor.ProcessRequest(httpReq)
+	startTime := time.Now()
+	result, err := ProcessIntegrated(s.propertyProcessor, s.esiProcessor, httpReq, origin, req.HTML)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error:   "Property Manager processing failed",
@@ -379,32 +684,46 @@ func (s *Server) handleIntegratedProcess(c *gin.Context) {
 		})
 		return
 	}
+	if inSession {
+		setCookies := append(result.PropertyManagerResult.SetCookieHeaders(), result.PropertyManagerResult.ModifiedHeaders["Set-Cookie"])
+		setCookies = append(setCookies, responseSetCookie(result.ESIResponse)...)
+		s.endSession(session, setCookies...)
+	}
+	edgeLog := edgeLogFields{}
+	if result.ESIEnabled {
+		edgeLog = s.esiEdgeLog(result.ESIResponse, result.PropertyManagerResult.NoStore())
+	}
+	edgeLog.matchedRules = result.PropertyManagerResult.MatchedRules
+	edgeLog.logFields = result.PropertyManagerResult.LogFields
+	setEdgeLog(c, edgeLog)
 
-	// Step 2: Create ESI context from Property Manager result
-	esiContext := s.createESIContext(httpReq, pmResult)
-
-	// Step 3: Process ESI content if enabled
-	var processedHTML string
-	if s.isESIEnabled(pmResult) {
-		processedHTML, err = s.esiProcessor.Process(req.HTML, esiContext)
-		if err != nil {
-			// Continue with original HTML if ESI fails
-			processedHTML = req.HTML
-		}
-	} else {
-		processedHTML = req.HTML
+	// Denied, redirected and constructed responses never reach ESI processing
+	if result.PropertyManagerResult.Denied {
+		s.writeDenied(c, result.PropertyManagerResult)
+		return
+	}
+	if result.PropertyManagerResult.RedirectLocation != "" {
+		s.writeRedirect(c, result.PropertyManagerResult)
+		return
+	}
+	if result.PropertyManagerResult.ConstructedResponse != nil {
+		s.writeConstructedResponse(c, result.PropertyManagerResult)
+		return
 	}
 
-	// Step 4: Process response behaviors
-	responseResult := s.processResponseBehaviors(pmResult, processedHTML)
+	if !s.checkResponseSize(c, result.ProcessedHTML) {
+		return
+	}
 
 	processingTime := time.Since(startTime).Milliseconds()
 
 	c.JSON(http.StatusOK, IntegratedProcessResponse{
-		PropertyManagerResult: pmResult,
-		ResponseResult:        responseResult,
-		ProcessedHTML:         processedHTML,
-		ESIEnabled:            s.isESIEnabled(pmResult),
+		PropertyManagerResult: result.PropertyManagerResult,
+		ResponseResult:        result.ResponseResult,
+		ProcessedHTML:         result.ProcessedHTML,
+		ESIEnabled:            result.ESIEnabled,
+		ESISkipReason:         result.ESISkipReason,
+		ESIErrorPage:          result.ESIErrorPage(),
 		Stats: StatsInfo{
 			ProcessingTime: processingTime,
 			Mode:           s.config.Mode,
@@ -417,115 +736,101 @@ func (s *Server) handleIntegratedProcess(c *gin.Context) {
 	})
 }
 
-// createHTTPRequest creates an HTTP request from the context
-func (s *Server) createHTTPRequest(ctx *propertymanager.HTTPContext) (*http.Request, error) {
-	// Create a basic HTTP request
-	req, err := http.NewRequest(ctx.Method, ctx.Path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	// Set headers
-	for key, value := range ctx.Headers {
-		req.Header.Set(key, value)
+// writeRedirect writes the redirect demanded by the Property Manager result
+func (s *Server) writeRedirect(c *gin.Context, pmResult *propertymanager.RuleResult) {
+	statusCode := pmResult.RedirectStatus
+	if statusCode < 300 || statusCode > 399 {
+		statusCode = http.StatusFound
 	}
 
-	// Set host
-	if ctx.Host != "" {
-		req.Host = ctx.Host
+	for key, value := range pmResult.ModifiedHeaders {
+		if key == "Status" {
+			continue
+		}
+		c.Header(key, value)
 	}
+	c.Header("Location", pmResult.RedirectLocation)
+	writeDownstreamCache(c, pmResult)
+	writeResponseCookies(c, pmResult)
 
-	return req, nil
+	c.Data(statusCode, "text/html; charset=utf-8", []byte(pmResult.ResponseContent))
 }
 
-// createESIContext creates an ESI processing context from Property Manager result
-func (s *Server) createESIContext(req *http.Request, pmResult *propertymanager.RuleResult) esi.ProcessContext {
-	// Start with request headers
-	headers := make(map[string]string)
-	for key, values := range req.Header {
-		if len(values) > 0 {
-			headers[key] = values[0]
-		}
-	}
-
-	// Apply Property Manager header modifications
+// writeConstructedResponse writes the response constructed by a construct_response
+// behavior, with the response headers set by the matched rules
+func (s *Server) writeConstructedResponse(c *gin.Context, pmResult *propertymanager.RuleResult) {
+	constructed := pmResult.ConstructedResponse
 	for key, value := range pmResult.ModifiedHeaders {
-		headers[key] = value
+		c.Header(key, value)
 	}
-
-	// Remove headers that were removed by Property Manager
-	for _, removedHeader := range pmResult.RemovedHeaders {
-		delete(headers, removedHeader)
+	for key, value := range constructed.Headers {
+		c.Header(key, value)
 	}
+	writeDownstreamCache(c, pmResult)
+	writeResponseCookies(c, pmResult)
+	c.Data(constructed.Status, constructed.Headers["Content-Type"], []byte(constructed.Body))
+}
 
-	// Extract cookies
-	cookies := make(map[string]string)
-	if cookieHeader := req.Header.Get("Cookie"); cookieHeader != "" {
-		// Simple cookie parsing
-		cookiePairs := strings.Split(cookieHeader, ";")
-		for _, pair := range cookiePairs {
-			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
-			if len(parts) == 2 {
-				cookies[parts[0]] = parts[1]
-			}
-		}
+// writeDownstreamCache sets the client cacheability headers of a downstream_cache behavior
+func writeDownstreamCache(c *gin.Context, pmResult *propertymanager.RuleResult) {
+	if pmResult.DownstreamCache == nil {
+		return
 	}
-
-	// Add Property Manager variables to headers for ESI access
-	for key, value := range pmResult.Variables {
-		headers["X-PM-"+key] = value
+	for _, name := range pmResult.DownstreamCache.RemovedHeaders {
+		c.Writer.Header().Del(name)
 	}
-
-	return esi.ProcessContext{
-		BaseURL: fmt.Sprintf("%s://%s", getSchemeFromRequest(req), req.Host),
-		Headers: headers,
-		Cookies: cookies,
-		Depth:   0,
+	for name, value := range pmResult.DownstreamCache.Headers {
+		c.Header(name, value)
 	}
 }
 
-// getSchemeFromRequest returns the scheme (http/https) for a request
-func getSchemeFromRequest(req *http.Request) string {
-	if req.TLS != nil {
-		return "https"
-	}
-	if scheme := req.Header.Get("X-Forwarded-Proto"); scheme != "" {
-		return scheme
+// writeResponseCookies sets the cookies of response_cookie behaviors
+func writeResponseCookies(c *gin.Context, pmResult *propertymanager.RuleResult) {
+	for _, setCookie := range pmResult.SetCookieHeaders() {
+		c.Writer.Header().Add("Set-Cookie", setCookie)
 	}
-	return "http"
 }
 
-// isESIEnabled checks if ESI processing is enabled based on Property Manager result
-func (s *Server) isESIEnabled(pmResult *propertymanager.RuleResult) bool {
-	// Check if ESI behavior was executed
-	for _, behavior := range pmResult.ExecutedBehaviors {
-		if behavior == "esi" {
-			return true
-		}
-	}
-	return false
+// writeDenied writes the 403 response for a request denied by access control
+func (s *Server) writeDenied(c *gin.Context, pmResult *propertymanager.RuleResult) {
+	body := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Access Denied</title>
+</head>
+<body>
+    <h1>Access Denied</h1>
+    <p>%s</p>
+</body>
+</html>`, html.EscapeString(pmResult.DenyReason))
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusForbidden, "text/html; charset=utf-8", []byte(body))
 }
 
-// processResponseBehaviors processes Property Manager response behaviors
-func (s *Server) processResponseBehaviors(pmResult *propertymanager.RuleResult, html string) *propertymanager.RuleResult {
-	responseResult := &propertymanager.RuleResult{
-		MatchedRules:              pmResult.MatchedRules,
-		ExecutedBehaviors:         pmResult.ExecutedBehaviors,
-		ModifiedHeaders:           make(map[string]string),
-		RemovedHeaders:            []string{},
-		Variables:                 make(map[string]string),
-		Errors:                    []string{},
-		CacheSettings:             make(map[string]interface{}),
-		CompressionSettings:       make(map[string]interface{}),
-		ImageOptimizationSettings: make(map[string]interface{}),
+// createHTTPRequest creates an HTTP request from the context
+func (s *Server) createHTTPRequest(ctx *propertymanager.HTTPContext) (*http.Request, error) {
+	// Create a basic HTTP request, carrying the body for the body criteria
+	var body io.Reader
+	if ctx.Body != "" {
+		body = strings.NewReader(ctx.Body)
+	}
+	req, err := http.NewRequest(ctx.Method, ctx.Path, body)
+	if err != nil {
+		return nil, err
 	}
 
-	// Copy modified headers from request processing
-	for key, value := range pmResult.ModifiedHeaders {
-		responseResult.ModifiedHeaders[key] = value
+	// Set headers
+	for key, value := range ctx.Headers {
+		req.Header.Set(key, value)
+	}
+
+	// Set host
+	if ctx.Host != "" {
+		req.Host = ctx.Host
 	}
 
-	return responseResult
+	return req, nil
 }
 
 // handleStats returns processing statistics
@@ -535,7 +840,7 @@ func (s *Server) handleStats(c *gin.Context) {
 	var cache interface{}
 
 	switch s.emulatorType {
-	case "esi":
+	case "esi", "integrated":
 		if s.esiProcessor != nil {
 			esiStats := s.esiProcessor.GetStats()
 			stats = gin.H{
@@ -544,11 +849,33 @@ func (s *Server) handleStats(c *gin.Context) {
 				"cacheMiss": esiStats.CacheMiss,
 				"errors":    esiStats.Errors,
 				"totalTime": esiStats.TotalTime,
+
+				"revalidations": esiStats.Revalidations,
+				"notModified":   esiStats.NotModified,
+
+				"templateCacheHits":    esiStats.TemplateCacheHits,
+				"templateCacheMiss":    esiStats.TemplateCacheMiss,
+				"templateCacheHitRate": esiStats.TemplateCacheHitRate(),
+
+				"passThrough": esiStats.PassThrough,
+				"sanitized":   esiStats.Sanitized,
+				"truncated":   esiStats.Truncated,
+				"errorPages":  esiStats.ErrorPages,
+				"memory":      esiStats.Memory,
+
+				"processingTime":   esiStats.ProcessingTime,
+				"includeFetchTime": esiStats.IncludeFetchTime,
+				"includeHosts":     esiStats.IncludeHosts,
+				"slowIncludes":     esiStats.SlowIncludes,
+			}
+			if windows := s.esiProcessor.GetStatsWindows(); windows != nil {
+				stats.(gin.H)["windows"] = windows
 			}
 			features = s.esiProcessor.GetFeatures()
 			cache = gin.H{
-				"size":    s.esiProcessor.GetCacheSize(),
-				"enabled": s.esiProcessor.GetFeatures().Include,
+				"size":      s.esiProcessor.GetCacheSize(),
+				"enabled":   s.esiProcessor.GetFeatures().Include,
+				"templates": s.esiProcessor.GetTemplateCacheSize(),
 			}
 		}
 	case "property-manager":
@@ -589,13 +916,42 @@ func (s *Server) handleStats(c *gin.Context) {
 	})
 }
 
+// handleResetStats zeroes the ESI processing statistics and returns the totals
+// collected up to the reset
+func (s *Server) handleResetStats(c *gin.Context) {
+	if s.esiProcessor == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "No ESI processor available",
+			"stats":   gin.H{},
+		})
+		return
+	}
+
+	previous := s.esiProcessor.ResetStats()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "ESI statistics reset",
+		"stats": gin.H{
+			"requests":      previous.Requests,
+			"cacheHits":     previous.CacheHits,
+			"cacheMiss":     previous.CacheMiss,
+			"errors":        previous.Errors,
+			"totalTime":     previous.TotalTime,
+			"revalidations": previous.Revalidations,
+			"notModified":   previous.NotModified,
+			"passThrough":   previous.PassThrough,
+			"sanitized":     previous.Sanitized,
+			"memory":        previous.Memory,
+		},
+	})
+}
+
 // handleClearCache clears the fragment cache
 func (s *Server) handleClearCache(c *gin.Context) {
 	var stats interface{}
 	var message string
 
 	switch s.emulatorType {
-	case "esi":
+	case "esi", "integrated":
 		if s.esiProcessor != nil {
 			s.esiProcessor.ClearCache()
 			esiStats := s.esiProcessor.GetStats()
@@ -646,37 +1002,30 @@ func (s *Server) handleClearCache(c *gin.Context) {
 
 // handleListExamples returns available examples
 func (s *Server) handleListExamples(c *gin.Context) {
-	examples := []gin.H{
-		{
-			"name":        "basic-include",
-			"description": "Basic ESI include example",
-			"modes":       []string{"fastly", "akamai", "w3c"},
-		},
-		{
-			"name":        "conditional",
-			"description": "ESI conditional processing",
-			"modes":       []string{"akamai", "w3c"},
-		},
-		{
-			"name":        "error-handling",
-			"description": "ESI error handling and fallbacks",
-			"modes":       []string{"akamai", "w3c"},
-		},
-		{
-			"name":        "variables",
-			"description": "ESI variable substitution",
-			"modes":       []string{"akamai", "w3c"},
-		},
-		{
-			"name":        "ecommerce",
-			"description": "E-commerce shopping cart example",
-			"modes":       []string{"fastly", "akamai", "w3c"},
-		},
+	all := s.getExamples()
+	names := getMapKeys(all)
+	sort.Strings(names)
+
+	examples := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		examples = append(examples, gin.H{
+			"name":        name,
+			"description": all[name].Description,
+			"modes":       all[name].Modes,
+		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"examples": examples,
-	})
+	}
+	if s.library != nil {
+		response["examplesDir"] = s.library.Dir()
+		if errors := s.library.Errors(); len(errors) > 0 {
+			response["errors"] = errors
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // handleGetExample returns a specific example
@@ -696,8 +1045,19 @@ func (s *Server) handleGetExample(c *gin.Context) {
 	c.JSON(http.StatusOK, example)
 }
 
-// handleGetFragment returns test fragments
+// handleGetFragment returns test fragments, checking the URL signature when a fragment
+// signing key is configured
 func (s *Server) handleGetFragment(c *gin.Context) {
+	if key := s.config.FragmentSigningKey; key != "" {
+		if err := esi.VerifySignedURL(c.Request.URL, key, time.Now()); err != nil {
+			c.JSON(http.StatusForbidden, ErrorResponse{
+				Error:   "Forbidden",
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
 	name := c.Param("name")
 	fragments := s.getTestFragments()
 
@@ -711,251 +1071,52 @@ func (s *Server) handleGetFragment(c *gin.Context) {
 	}
 
 	c.Header("Content-Type", "text/html")
-	c.String(http.StatusOK, fragment)
+	c.String(http.StatusOK, expandFragment(fragment))
 }
 
-// handleHealth returns health status
-func (s *Server) handleHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "healthy",
-		"uptime": time.Since(time.Now()).Seconds(), // Placeholder
-		"mode":   s.config.Mode,
-	})
-}
-
-// getExamples returns example ESI content for testing
+// getExamples returns the built-in examples merged with the on-disk library
 func (s *Server) getExamples() map[string]Example {
-	return map[string]Example{
-		"basic-include": {
-			Name:        "Basic Include",
-			Description: "Simple ESI include example",
-			HTML: `<!DOCTYPE html>
-<html>
-<head>
-    <title>ESI Basic Include Example</title>
-</head>
-<body>
-    <h1>Welcome to ESI Testing</h1>
-    <esi:include src="/fragments/header" />
-    <main>
-        <p>This is the main content area.</p>
-        <esi:include src="/fragments/content" />
-    </main>
-    <esi:include src="/fragments/footer" />
-</body>
-</html>`,
-			Modes: []string{"fastly", "akamai", "w3c"},
-		},
-		"conditional": {
-			Name:        "Conditional Processing",
-			Description: "ESI choose/when/otherwise example",
-			HTML: `<!DOCTYPE html>
-<html>
-<head>
-    <title>ESI Conditional Example</title>
-</head>
-<body>
-    <esi:choose>
-        <esi:when test="$(HTTP_COOKIE{user_type})=='premium'">
-            <esi:include src="/fragments/premium-header" />
-        </esi:when>
-        <esi:when test="$(HTTP_COOKIE{user_type})=='basic'">
-            <esi:include src="/fragments/basic-header" />
-        </esi:when>
-        <esi:otherwise>
-            <esi:include src="/fragments/guest-header" />
-        </esi:otherwise>
-    </esi:choose>
-    
-    <main>Content based on user type</main>
-</body>
-</html>`,
-			Modes: []string{"akamai", "w3c"},
-		},
-		"error-handling": {
-			Name:        "Error Handling",
-			Description: "ESI try/attempt/except and onerror example",
-			HTML: `<!DOCTYPE html>
-<html>
-<head>
-    <title>ESI Error Handling Example</title>
-</head>
-<body>
-    <h1>Error Handling Examples</h1>
-    
-    <!-- Example 1: onerror="continue" -->
-    <div>
-        <h2>With onerror continue:</h2>
-        <esi:include src="/fragments/might-fail" onerror="continue" />
-        <p>This will always show, even if include fails.</p>
-    </div>
-    
-    <!-- Example 2: alt attribute -->
-    <div>
-        <h2>With fallback URL:</h2>
-        <esi:include src="/fragments/might-fail" alt="/fragments/fallback" />
-    </div>
-    
-    <!-- Example 3: try/attempt/except -->
-    <div>
-        <h2>With try/except block:</h2>
-        <esi:try>
-            <esi:attempt>
-                <esi:include src="/fragments/might-fail" />
-                <p>Primary content loaded successfully</p>
-            </esi:attempt>
-            <esi:except>
-                <p>Fallback content - primary source failed</p>
-            </esi:except>
-        </esi:try>
-    </div>
-</body>
-</html>`,
-			Modes: []string{"akamai", "w3c"},
-		},
-		"akamai-extensions": {
-			Name:        "Akamai Extensions",
-			Description: "Akamai-specific ESI extensions showcase",
-			HTML: `<!DOCTYPE html>
-<html>
-<head>
-    <title>Akamai ESI Extensions</title>
-</head>
-<body>
-    <h1>Akamai ESI Extensions Showcase</h1>
-    
-    <!-- Variable Assignment -->
-    <h2>Variable Assignment</h2>
-    <esi:assign name="user_level" value="premium" />
-    <esi:assign name="site_name">Akamai Demo Site</esi:assign>
-    
-    <!-- Expression Evaluation -->
-    <h2>Expression Evaluation</h2>
-    <p>User level: <esi:eval expr="$(user_level)" /></p>
-    <p>Is premium: <esi:eval expr="$(user_level) == 'premium'" /></p>
-    
-    <!-- Built-in Functions -->
-    <h2>Built-in Functions</h2>
-    <p>Current time: <esi:function name="time" format="2006-01-02 15:04:05" /></p>
-    <p>Random number: <esi:function name="random" min="1" max="100" /></p>
-    <p>URL encoded: <esi:function name="url_encode" input="Hello World!" /></p>
-    <p>Base64 encoded: <esi:function name="base64_encode" input="test data" /></p>
-    
-    <!-- Geo Variables -->
-    <h2>Geo-location Variables</h2>
-    <p>Country: $(GEO_COUNTRY_NAME) ($(GEO_COUNTRY_CODE))</p>
-    <p>Region: $(GEO_REGION)</p>
-    <p>City: $(GEO_CITY)</p>
-    <p>Client IP: $(CLIENT_IP)</p>
-    
-    <!-- Debug Information -->
-    <h2>Debug Information</h2>
-    <esi:debug type="vars" />
-    <esi:debug type="time" />
-    
-    <!-- Variable Expansion -->
-    <h2>Variable Expansion</h2>
-    <p>Welcome to $(site_name)!</p>
-    <p>Browser: $(HTTP_USER_AGENT{browser})</p>
-    <p>OS: $(HTTP_USER_AGENT{os})</p>
-</body>
-</html>`,
-			Modes: []string{"akamai"},
-		},
-		"variables": {
-			Name:        "Variable Substitution",
-			Description: "ESI variable substitution and expansion",
-			HTML: `<!DOCTYPE html>
-<html>
-<head>
-    <title>ESI Variables Example</title>
-</head>
-<body>
-    <h1>ESI Variable Substitution</h1>
-    
-    <!-- Standard Variables -->
-    <h2>Standard ESI Variables</h2>
-    <p>Host: $(HTTP_HOST)</p>
-    <p>User Agent: $(HTTP_USER_AGENT)</p>
-    <p>Cookie: $(HTTP_COOKIE{user_id})</p>
-    <p>Referer: $(HTTP_REFERER)</p>
-    
-    <!-- Akamai Extensions -->
-    <h2>Akamai Extended Variables</h2>
-    <p>Request Method: $(REQUEST_METHOD)</p>
-    <p>Request URI: $(REQUEST_URI)</p>
-    <p>Client IP: $(CLIENT_IP)</p>
-    
-    <!-- Custom Variables -->
-    <h2>Custom Variables</h2>
-    <esi:assign name="page_title" value="Dynamic Page" />
-    <esi:assign name="current_user" value="$(HTTP_COOKIE{username})" />
-    <p>Page: $(page_title)</p>
-    <p>User: $(current_user)</p>
-</body>
-</html>`,
-			Modes: []string{"akamai", "w3c"},
-		},
-		"ecommerce": {
-			Name:        "E-commerce Example",
-			Description: "Shopping cart with ESI includes",
-			HTML: `<!DOCTYPE html>
-<html>
-<head>
-    <title>Online Store</title>
-</head>
-<body>
-    <header>
-        <img src="/logo.png" alt="Store Logo" />
-        <esi:include src="/fragments/shopping-cart" />
-        <esi:include src="/fragments/user-menu" />
-    </header>
-    
-    <main>
-        <h1>Featured Products</h1>
-        <esi:include src="/fragments/featured-products" />
-        
-        <h2>Recommendations</h2>
-        <esi:include src="/fragments/recommendations" onerror="continue" />
-    </main>
-    
-    <footer>
-        <esi:include src="/fragments/footer" />
-    </footer>
-</body>
-</html>`,
-			Modes: []string{"fastly", "akamai", "w3c"},
-		},
+	examples := builtinExamples()
+	if s.library != nil {
+		for name, example := range s.library.Examples() {
+			examples[name] = example
+		}
 	}
+	return examples
 }
 
-// getTestFragments returns test fragments for includes
+// getTestFragments returns the built-in test fragments merged with the on-disk library
 func (s *Server) getTestFragments() map[string]string {
-	currentTime := time.Now().Format(time.RFC3339)
-
-	return map[string]string{
-		"header":            "<header><h2>Dynamic Header Content</h2><nav>Navigation here</nav></header>",
-		"content":           fmt.Sprintf("<div><p>This is dynamically included content.</p><p>Generated at: %s</p></div>", currentTime),
-		"footer":            "<footer><p>&copy; 2024 ESI Emulator. All rights reserved.</p></footer>",
-		"shopping-cart":     "<div class=\"cart\">Cart: 3 items ($45.99) <a href=\"/cart\">View Cart</a></div>",
-		"user-menu":         "<div class=\"user-menu\"><a href=\"/login\">Login</a> | <a href=\"/register\">Register</a></div>",
-		"featured-products": "<div class=\"products\"><div class=\"product\">Product 1 - $19.99</div><div class=\"product\">Product 2 - $25.99</div></div>",
-		"recommendations":   "<div class=\"recommendations\"><h3>You might also like:</h3><div class=\"product\">Recommended Product - $15.99</div></div>",
-		"fallback":          "<div class=\"fallback\">This is fallback content when the primary source fails.</div>",
-		"premium-header":    "<header class=\"premium\"><h2>Premium User Header</h2><div class=\"premium-badge\">PREMIUM</div></header>",
-		"basic-header":      "<header class=\"basic\"><h2>Basic User Header</h2></header>",
-		"guest-header":      "<header class=\"guest\"><h2>Welcome Guest</h2><a href=\"/login\">Login for more features</a></header>",
+	fragments := builtinFragments()
+	if s.library != nil {
+		for name, fragment := range s.library.Fragments() {
+			fragments[name] = fragment
+		}
 	}
+	return fragments
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server on the configured listeners
 func (s *Server) Start() error {
-	s.server = &http.Server{
-		Addr:    ":" + strconv.Itoa(s.config.Port),
-		Handler: s.router,
+	listeners, err := s.Listen()
+	if err != nil {
+		return err
 	}
+	return s.Serve(listeners...)
+}
+
+// Serve serves HTTP on listeners until the server is shut down, returning the first
+// listener's error
+func (s *Server) Serve(listeners ...net.Listener) error {
+	s.server = &http.Server{Handler: s.router}
 
-	return s.server.ListenAndServe()
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errs <- s.server.Serve(listener)
+		}(listener)
+	}
+	return <-errs
 }
 
 // Shutdown gracefully shuts down the server
@@ -970,22 +1131,6 @@ func (s *Server) Shutdown() error {
 	return s.server.Shutdown(ctx)
 }
 
-// corsMiddleware adds CORS headers
-func corsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
-
-		c.Next()
-	}
-}
-
 // getScheme returns the request scheme
 func getScheme(c *gin.Context) string {
 	if c.Request.TLS != nil {
@@ -1003,6 +1148,21 @@ func getMapKeys[K comparable, V any](m map[K]V) []K {
 	return keys
 }
 
+// Handler returns the HTTP handler serving all routes
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// GetFrequencyTracker returns the beacon frequency tracker
+func (s *Server) GetFrequencyTracker() *FrequencyTracker {
+	return s.frequency
+}
+
+// GetMetrics returns the HTTP metrics collector
+func (s *Server) GetMetrics() *Metrics {
+	return s.metrics
+}
+
 // GetESIProcessor returns the ESI processor
 func (s *Server) GetESIProcessor() *esi.Processor {
 	return s.esiProcessor
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_SignedFragments(t *testing.T) {
+	srv := New(Config{Mode: "esi", FragmentSigningKey: "secret"},
+		WithESI(esi.NewProcessor(esi.Config{Mode: "akamai", MaxIncludes: 10, MaxDepth: 5})))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Get(ts.URL + "/fragments/header")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	var errResp ErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	assert.Contains(t, errResp.Message, "not signed")
+
+	get := func(key string, expires time.Time) *http.Response {
+		signed, err := esi.SignURL(ts.URL+"/fragments/header", key, expires)
+		require.NoError(t, err)
+		resp, err := http.Get(signed)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	assert.Equal(t, http.StatusOK, get("secret", time.Now().Add(time.Minute)).StatusCode)
+	assert.Equal(t, http.StatusForbidden, get("other", time.Now().Add(time.Minute)).StatusCode)
+	assert.Equal(t, http.StatusForbidden, get("secret", time.Now().Add(-time.Minute)).StatusCode)
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionHeader names the session a request belongs to. Requests of a session see the
+// cookies and variables left by its earlier requests.
+const SessionHeader = "X-Emulator-Session"
+
+// Session is a simulated browser session: a cookie jar and the page variables carried
+// from request to request
+type Session struct {
+	ID        string            `json:"id"`
+	Cookies   map[string]string `json:"cookies"`
+	Variables map[string]string `json:"variables"`
+	Created   time.Time         `json:"created"`
+	LastUsed  time.Time         `json:"lastUsed"`
+	Requests  int               `json:"requests"` // Requests processed in the session
+}
+
+// SessionUpdate replaces the cookies and variables of a session
+type SessionUpdate struct {
+	Cookies   map[string]string `json:"cookies"`
+	Variables map[string]string `json:"variables"`
+}
+
+// SessionStore keeps the sessions named by the requests' SessionHeader. Sessions are
+// created by their first request and kept until deleted.
+type SessionStore struct {
+	sessions map[string]*Session
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+// NewSessionStore creates an empty session store
+func NewSessionStore() *SessionStore {
+	return &SessionStore{sessions: make(map[string]*Session), now: time.Now}
+}
+
+// Get returns a copy of the session id
+func (s *SessionStore) Get(id string) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return Session{}, false
+	}
+	return session.clone(), true
+}
+
+// List returns copies of the sessions, by ID
+func (s *SessionStore) List() []Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := make([]Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session.clone())
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions
+}
+
+// Put replaces the cookies and variables of the session id, creating it if needed
+func (s *SessionStore) Put(id string, update SessionUpdate) Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session := s.session(id)
+	session.Cookies = copyStrings(update.Cookies)
+	session.Variables = copyStrings(update.Variables)
+	return session.clone()
+}
+
+// Delete drops the session id and reports whether it existed
+func (s *SessionStore) Delete(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, exists := s.sessions[id]
+	delete(s.sessions, id)
+	return exists
+}
+
+// begin returns a copy of the session id for a request, creating the session if needed
+func (s *SessionStore) begin(id string) Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.session(id).clone()
+}
+
+// commit stores the cookies and variables a request left in its copy of the session
+func (s *SessionStore) commit(request Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session := s.session(request.ID)
+	session.Cookies = request.Cookies
+	session.Variables = request.Variables
+	session.LastUsed = s.now()
+	session.Requests++
+}
+
+// session returns the session id, creating it if needed; the caller holds the mutex
+func (s *SessionStore) session(id string) *Session {
+	session, exists := s.sessions[id]
+	if !exists {
+		now := s.now()
+		session = &Session{
+			ID:        id,
+			Cookies:   make(map[string]string),
+			Variables: make(map[string]string),
+			Created:   now,
+			LastUsed:  now,
+		}
+		s.sessions[id] = session
+	}
+	return session
+}
+
+// clone returns a copy of the session that shares none of its maps
+func (s *Session) clone() Session {
+	clone := *s
+	clone.Cookies = copyStrings(s.Cookies)
+	clone.Variables = copyStrings(s.Variables)
+	return clone
+}
+
+// copyStrings returns a copy of values, never nil
+func copyStrings(values map[string]string) map[string]string {
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
+
+// beginSession returns the session named by the request's SessionHeader, echoing the
+// header, or false when the request has none
+func (s *Server) beginSession(c *gin.Context) (Session, bool) {
+	id := strings.TrimSpace(c.GetHeader(SessionHeader))
+	if id == "" {
+		return Session{}, false
+	}
+	c.Header(SessionHeader, id)
+	return s.sessions.begin(id), true
+}
+
+// withSession adds the session's cookies to the context and makes the session's
+// variables the page's; cookies and variables the request gives itself win
+func withSession(context *esi.ProcessContext, session Session) {
+	if context.Cookies == nil {
+		context.Cookies = make(map[string]string)
+	}
+	added := false
+	for name, value := range session.Cookies {
+		if _, exists := context.Cookies[name]; !exists {
+			context.Cookies[name] = value
+			added = true
+		}
+	}
+	if added {
+		if context.Headers == nil {
+			context.Headers = make(map[string]string)
+		}
+		context.Headers["Cookie"] = cookieHeader(context.Cookies)
+	}
+	for name, value := range context.Variables {
+		session.Variables[name] = value
+	}
+	context.Variables = session.Variables
+}
+
+// addSessionCookies adds the session's cookies the request does not send itself
+func addSessionCookies(req *http.Request, session Session) {
+	names := make([]string, 0, len(session.Cookies))
+	for name := range session.Cookies {
+		if _, err := req.Cookie(name); err != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		req.AddCookie(&http.Cookie{Name: name, Value: session.Cookies[name]})
+	}
+}
+
+// endSession stores the session after a request, keeping the cookies of the Set-Cookie
+// values the response sets
+func (s *Server) endSession(session Session, setCookies ...string) {
+	header := make(http.Header)
+	for _, setCookie := range setCookies {
+		if setCookie != "" {
+			header.Add("Set-Cookie", setCookie)
+		}
+	}
+	now := s.sessions.now()
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		if cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && cookie.Expires.Before(now)) {
+			delete(session.Cookies, cookie.Name)
+			continue
+		}
+		session.Cookies[cookie.Name] = cookie.Value
+	}
+	s.sessions.commit(session)
+}
+
+// cookieHeader returns the Cookie header sending cookies, by name
+func cookieHeader(cookies map[string]string) string {
+	names := make([]string, 0, len(cookies))
+	for name := range cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + cookies[name]
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// responseSetCookie returns the Set-Cookie headers ESI built-ins set
+func responseSetCookie(meta *esi.ResponseMeta) []string {
+	if meta == nil {
+		return nil
+	}
+	return meta.Headers.Values("Set-Cookie")
+}
+
+// handleListSessions lists the sessions
+func (s *Server) handleListSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, s.sessions.List())
+}
+
+// handleGetSession returns a session
+func (s *Server) handleGetSession(c *gin.Context) {
+	session, exists := s.sessions.Get(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "Session not found",
+			Message: "No session named " + c.Param("id"),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// handlePutSession replaces the cookies and variables of a session, such as to start a
+// flow already logged in
+func (s *Server) handlePutSession(c *gin.Context) {
+	var update SessionUpdate
+	if !s.bindJSON(c, &update) {
+		return
+	}
+	c.JSON(http.StatusOK, s.sessions.Put(c.Param("id"), update))
+}
+
+// handleDeleteSession drops a session
+func (s *Server) handleDeleteSession(c *gin.Context) {
+	if !s.sessions.Delete(c.Param("id")) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "Session not found",
+			Message: "No session named " + c.Param("id"),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session deleted"})
+}
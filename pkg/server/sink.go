@@ -0,0 +1,209 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Beacon sink limits
+const (
+	DefaultSinkCapacity = 1000     // Captures kept; older ones are dropped first
+	DefaultSinkMaxBody  = 64 << 10 // Body bytes kept per capture
+)
+
+// sinkCapturesPath is the path, under /sink, of the capture API
+const sinkCapturesPath = "/captures"
+
+// SinkCapture is a request received by the beacon sink
+type SinkCapture struct {
+	ID         int64             `json:"id"`
+	Time       time.Time         `json:"time"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"` // Path after /sink
+	URL        string            `json:"url"`  // Path and query as requested
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body,omitempty"`
+	Truncated  bool              `json:"truncated,omitempty"` // Set when the body was cut at DefaultSinkMaxBody
+	RemoteAddr string            `json:"remoteAddr"`
+}
+
+// SinkFilter selects captures; zero fields match every capture
+type SinkFilter struct {
+	Method   string    // Request method, case-insensitive
+	Path     string    // Prefix of the path after /sink
+	Contains string    // Substring of the URL or body
+	Since    time.Time // Captures received at or after this time
+	Limit    int       // Most recent captures returned; zero returns all
+}
+
+// SinkCapturesResponse lists the captures matching a filter, oldest first
+type SinkCapturesResponse struct {
+	Captures []SinkCapture `json:"captures"`
+	Total    int           `json:"total"` // Captures kept, matching or not
+}
+
+// BeaconSink records the requests sent to /sink, so fire-and-forget includes such as
+// container beacons can be verified without third-party endpoints
+type BeaconSink struct {
+	captures []SinkCapture
+	nextID   int64
+	capacity int
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+// NewBeaconSink creates an empty sink keeping up to DefaultSinkCapacity captures
+func NewBeaconSink() *BeaconSink {
+	return &BeaconSink{capacity: DefaultSinkCapacity, nextID: 1, now: time.Now}
+}
+
+// Record stores a capture, dropping the oldest one when the sink is full
+func (b *BeaconSink) Record(capture SinkCapture) SinkCapture {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	capture.ID = b.nextID
+	b.nextID++
+	if capture.Time.IsZero() {
+		capture.Time = b.now()
+	}
+	if len(b.captures) >= b.capacity {
+		b.captures = append(b.captures[:0], b.captures[1:]...)
+	}
+	b.captures = append(b.captures, capture)
+	return capture
+}
+
+// Captures returns the captures matching filter, oldest first, and the number kept
+func (b *BeaconSink) Captures(filter SinkFilter) ([]SinkCapture, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	matches := []SinkCapture{}
+	for _, capture := range b.captures {
+		if filter.matches(capture) {
+			matches = append(matches, capture)
+		}
+	}
+	if filter.Limit > 0 && len(matches) > filter.Limit {
+		matches = matches[len(matches)-filter.Limit:]
+	}
+	return matches, len(b.captures)
+}
+
+// Reset drops all captures
+func (b *BeaconSink) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.captures = nil
+}
+
+// matches reports whether capture passes the filter
+func (f SinkFilter) matches(capture SinkCapture) bool {
+	switch {
+	case f.Method != "" && !strings.EqualFold(f.Method, capture.Method):
+		return false
+	case f.Path != "" && !strings.HasPrefix(capture.Path, f.Path):
+		return false
+	case f.Contains != "" && !strings.Contains(capture.URL, f.Contains) && !strings.Contains(capture.Body, f.Contains):
+		return false
+	case !f.Since.IsZero() && capture.Time.Before(f.Since):
+		return false
+	}
+	return true
+}
+
+// handleSink records any request to /sink/*path and answers 204 No Content. GET and
+// DELETE /sink/captures list and clear the captures instead, and POST /sink/assert
+// checks expectations against them.
+func (s *Server) handleSink(c *gin.Context) {
+	path := c.Param("path")
+	if path == sinkAssertPath && c.Request.Method == http.MethodPost {
+		s.handleSinkAssert(c)
+		return
+	}
+	if path == sinkCapturesPath {
+		switch c.Request.Method {
+		case http.MethodGet:
+			s.handleSinkCaptures(c)
+			return
+		case http.MethodDelete:
+			s.sink.Reset()
+			c.JSON(http.StatusOK, gin.H{"message": "Sink captures cleared"})
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, DefaultSinkMaxBody+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Failed to read request body: " + err.Error(),
+		})
+		return
+	}
+	truncated := len(body) > DefaultSinkMaxBody
+	if truncated {
+		body = body[:DefaultSinkMaxBody]
+	}
+
+	headers := make(map[string]string, len(c.Request.Header))
+	for name := range c.Request.Header {
+		headers[name] = c.Request.Header.Get(name)
+	}
+	if c.Request.Host != "" {
+		headers["Host"] = c.Request.Host
+	}
+
+	s.sink.Record(SinkCapture{
+		Method:     c.Request.Method,
+		Path:       path,
+		URL:        c.Request.URL.RequestURI(),
+		Headers:    headers,
+		Body:       string(body),
+		Truncated:  truncated,
+		RemoteAddr: c.ClientIP(),
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// handleSinkCaptures lists the captures selected by the method, path, contains, since
+// (RFC 3339) and limit query parameters
+func (s *Server) handleSinkCaptures(c *gin.Context) {
+	filter := SinkFilter{
+		Method:   c.Query("method"),
+		Path:     c.Query("path"),
+		Contains: c.Query("contains"),
+	}
+	if since := c.Query("since"); since != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: "since must be an RFC 3339 time",
+			})
+			return
+		}
+		filter.Since = parsed
+	}
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Message: "limit must be a non-negative integer",
+			})
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	captures, total := s.sink.Captures(filter)
+	c.JSON(http.StatusOK, SinkCapturesResponse{Captures: captures, Total: total})
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/gin-gonic/gin"
+)
+
+// sinkAssertPath is the path, under /sink, of the assertions API
+const sinkAssertPath = "/assert"
+
+// SinkAssertRequest is a set of expectations checked against the beacon sink captures
+type SinkAssertRequest struct {
+	Expectations []SinkExpectation `json:"expectations" binding:"required"`
+	// Ordered requires each expectation's first match to come after the previous one's
+	Ordered bool `json:"ordered,omitempty"`
+	// Since ignores the captures received before this time
+	Since time.Time `json:"since,omitempty"`
+}
+
+// SinkExpectation describes captured requests that are expected. Captures match when
+// every given field does.
+type SinkExpectation struct {
+	Name   string `json:"name,omitempty"`
+	Method string `json:"method,omitempty"` // Request method, case-insensitive
+	URL    string `json:"url,omitempty"`    // Regular expression searched in the path and query
+	// Params are query parameters the captures carry
+	Params map[string]ParamExpectation `json:"params,omitempty"`
+	// Count is the number of matching captures expected; nil expects at least one
+	Count *int `json:"count,omitempty"`
+}
+
+// ParamExpectation is the expected value of a query parameter. Equals compares the value
+// as is, Matches as a regular expression and Hashed compares it with the hex digest of
+// a plain value, hashed like the cookie hashes of containers (Algorithm md5, sha1 or
+// sha256, with Salt prefixed for HashType hpr or appended for hpo).
+type ParamExpectation struct {
+	Equals    string `json:"equals,omitempty"`
+	Matches   string `json:"matches,omitempty"`
+	Hashed    string `json:"hashed,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Salt      string `json:"salt,omitempty"`
+	HashType  string `json:"hashType,omitempty"`
+}
+
+// SinkAssertResult reports which expectations held
+type SinkAssertResult struct {
+	Passed  bool                    `json:"passed"`
+	Results []SinkExpectationResult `json:"results"`
+}
+
+// SinkExpectationResult is the outcome of an expectation
+type SinkExpectationResult struct {
+	Name     string  `json:"name"`
+	Passed   bool    `json:"passed"`
+	Count    int     `json:"count"`    // Matching captures
+	Captures []int64 `json:"captures"` // IDs of the matching captures
+	Message  string  `json:"message,omitempty"`
+}
+
+// compiledExpectation is an expectation with its patterns compiled
+type compiledExpectation struct {
+	SinkExpectation
+	url    *regexp.Regexp
+	params map[string]compiledParam
+}
+
+// compiledParam is a parameter expectation with its pattern compiled and hash computed
+type compiledParam struct {
+	ParamExpectation
+	matches *regexp.Regexp
+	digest  string
+}
+
+// Assert checks expectations against the captures
+func (b *BeaconSink) Assert(req SinkAssertRequest) (SinkAssertResult, error) {
+	expectations := make([]compiledExpectation, len(req.Expectations))
+	for i, expectation := range req.Expectations {
+		compiled, err := compileExpectation(expectation)
+		if err != nil {
+			return SinkAssertResult{}, fmt.Errorf("expectation %d: %w", i+1, err)
+		}
+		expectations[i] = compiled
+	}
+
+	captures, _ := b.Captures(SinkFilter{Since: req.Since})
+	result := SinkAssertResult{Passed: true, Results: make([]SinkExpectationResult, len(expectations))}
+	var previous *SinkExpectationResult
+	for i, expectation := range expectations {
+		outcome := SinkExpectationResult{Name: expectation.Name, Passed: true, Captures: []int64{}}
+		if outcome.Name == "" {
+			outcome.Name = fmt.Sprintf("expectation %d", i+1)
+		}
+		for _, capture := range captures {
+			if expectation.matches(capture) {
+				outcome.Captures = append(outcome.Captures, capture.ID)
+			}
+		}
+		outcome.Count = len(outcome.Captures)
+
+		switch {
+		case expectation.Count != nil && outcome.Count != *expectation.Count:
+			outcome.Passed = false
+			outcome.Message = fmt.Sprintf("expected %d matching requests, got %d", *expectation.Count, outcome.Count)
+		case expectation.Count == nil && outcome.Count == 0:
+			outcome.Passed = false
+			outcome.Message = "no matching request"
+		case req.Ordered && previous != nil && previous.Count > 0 && outcome.Count > 0 && outcome.Captures[0] < previous.Captures[0]:
+			outcome.Passed = false
+			outcome.Message = fmt.Sprintf("first matching request came before %s", previous.Name)
+		}
+
+		result.Results[i] = outcome
+		result.Passed = result.Passed && outcome.Passed
+		previous = &result.Results[i]
+	}
+	return result, nil
+}
+
+// compileExpectation compiles the patterns and hashes of an expectation
+func compileExpectation(expectation SinkExpectation) (compiledExpectation, error) {
+	compiled := compiledExpectation{SinkExpectation: expectation, params: make(map[string]compiledParam)}
+	if expectation.URL != "" {
+		pattern, err := regexp.Compile(expectation.URL)
+		if err != nil {
+			return compiled, fmt.Errorf("invalid url pattern: %w", err)
+		}
+		compiled.url = pattern
+	}
+	if expectation.Count != nil && *expectation.Count < 0 {
+		return compiled, fmt.Errorf("count must not be negative")
+	}
+
+	for name, param := range expectation.Params {
+		compiledParam := compiledParam{ParamExpectation: param}
+		if param.Matches != "" {
+			pattern, err := regexp.Compile(param.Matches)
+			if err != nil {
+				return compiled, fmt.Errorf("param %s: invalid matches pattern: %w", name, err)
+			}
+			compiledParam.matches = pattern
+		}
+		if param.Hashed != "" {
+			digest, err := esi.HashCookie(param.Hashed, param.Salt, param.HashType, param.Algorithm)
+			if err != nil {
+				return compiled, fmt.Errorf("param %s: %w", name, err)
+			}
+			compiledParam.digest = digest
+		}
+		compiled.params[name] = compiledParam
+	}
+	return compiled, nil
+}
+
+// matches reports whether capture meets the expectation
+func (e compiledExpectation) matches(capture SinkCapture) bool {
+	if e.Method != "" && !strings.EqualFold(e.Method, capture.Method) {
+		return false
+	}
+	if e.url != nil && !e.url.MatchString(capture.URL) {
+		return false
+	}
+	if len(e.params) == 0 {
+		return true
+	}
+
+	query := url.Values{}
+	if u, err := url.ParseRequestURI(capture.URL); err == nil {
+		query = u.Query()
+	}
+	for name, param := range e.params {
+		values, exists := query[name]
+		if !exists {
+			return false
+		}
+		value := values[0]
+		switch {
+		case param.Equals != "" && value != param.Equals,
+			param.matches != nil && !param.matches.MatchString(value),
+			param.digest != "" && !strings.EqualFold(value, param.digest):
+			return false
+		}
+	}
+	return true
+}
+
+// handleSinkAssert checks the expectations of the request against the captures
+func (s *Server) handleSinkAssert(c *gin.Context) {
+	var req SinkAssertRequest
+	if !s.bindJSON(c, &req) {
+		return
+	}
+
+	result, err := s.sink.Assert(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
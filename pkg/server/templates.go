@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/edge-computing/emulator-suite/pkg/esi"
+	"github.com/edge-computing/emulator-suite/pkg/templates"
+	"github.com/gin-gonic/gin"
+)
+
+// ProcessByNameRequest represents a request to process a template from the template
+// store, referenced as name@version or, for the pinned or latest version, as name
+type ProcessByNameRequest struct {
+	Template string              `json:"template" binding:"required"`
+	Context  *esi.ProcessContext `json:"context,omitempty"`
+}
+
+// WithTemplates serves /process/by-name with the templates of store
+func WithTemplates(store *templates.Store) Option {
+	return func(s *Server) {
+		s.templates = store
+	}
+}
+
+// handleESIProcessByName loads the referenced template and processes it like /process,
+// naming the version processed in the X-ESI-Template header
+func (s *Server) handleESIProcessByName(c *gin.Context) {
+	if s.esiProcessor == nil || s.templates == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "Template store not available",
+			Message: "A template store must be configured with ESI_TEMPLATES_DIR or ESI_TEMPLATES_URL",
+		})
+		return
+	}
+
+	var req ProcessByNameRequest
+	if !s.bindJSON(c, &req) {
+		return
+	}
+	template, err := s.templates.Load(req.Template)
+	if err != nil {
+		status, message := http.StatusBadGateway, "Loading the template failed"
+		var refErr *templates.RefError
+		switch {
+		case errors.As(err, &refErr):
+			status, message = http.StatusBadRequest, "Invalid request"
+		case errors.Is(err, templates.ErrNotFound):
+			status, message = http.StatusNotFound, "Template not found"
+		}
+		c.JSON(status, ErrorResponse{Error: message, Message: err.Error()})
+		return
+	}
+
+	reference := template.Ref().String()
+	c.Header("X-ESI-Template", reference)
+	s.processESIRequest(c, ProcessRequest{HTML: template.HTML, Context: req.Context}, reference)
+}
+
+// templateEndpoints adds the by-name process endpoint to the endpoints listed by the
+// root handler
+func (s *Server) templateEndpoints(endpoints map[string]string) {
+	if s.templates != nil {
+		endpoints["/process/by-name"] = "POST - Process a template from the template store by name@version"
+	}
+}
@@ -0,0 +1,464 @@
+// Package templates loads ESI templates by reference, such as checkout@v42, from a
+// template repository on disk or over HTTP, so callers can process a page by name
+// instead of sending its HTML with every request.
+//
+// A reference without a version selects the version pinned for the name, or else the
+// latest version the repository has. Versions are immutable: a version once loaded is
+// served from the cache, while the latest version is looked up again after the TTL.
+package templates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Template store defaults
+const (
+	DefaultTimeout = 5 * time.Second // Time fetching a template may take
+	DefaultTTL     = time.Minute     // Time the latest version of a name is cached
+)
+
+// URL placeholders of an HTTP template repository; URLs without them get
+// /{name}/{version} appended
+const (
+	NamePlaceholder    = "{name}"
+	VersionPlaceholder = "{version}"
+)
+
+// LatestVersion is the version an HTTP repository is asked for when a reference has no
+// version and none is pinned
+const LatestVersion = "latest"
+
+// VersionHeader is the response header an HTTP repository names the version it served
+// with, when asked for LatestVersion
+const VersionHeader = "X-Template-Version"
+
+// maxTemplateSize limits the bytes read from a template
+const maxTemplateSize = 10 << 20
+
+// namePattern is what template names and versions may contain, so they are safe as
+// file names and URL path segments
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ErrNotFound is returned for references the repository has no template for
+var ErrNotFound = errors.New("template not found")
+
+// Ref references a template by name and, optionally, version
+type Ref struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// RefError reports a template reference that does not parse
+type RefError struct {
+	Reference string
+	Reason    string
+}
+
+func (e *RefError) Error() string {
+	return fmt.Sprintf("invalid template reference %q: %s", e.Reference, e.Reason)
+}
+
+// ParseRef parses a name@version reference; the version is optional
+func ParseRef(reference string) (Ref, error) {
+	name, version, versioned := strings.Cut(reference, "@")
+	if !namePattern.MatchString(name) {
+		return Ref{}, &RefError{Reference: reference, Reason: "want name or name@version"}
+	}
+	if versioned && !namePattern.MatchString(version) {
+		return Ref{}, &RefError{Reference: reference, Reason: "invalid version"}
+	}
+	return Ref{Name: name, Version: version}, nil
+}
+
+// String returns the reference as name@version, or name without a version
+func (r Ref) String() string {
+	if r.Version == "" {
+		return r.Name
+	}
+	return r.Name + "@" + r.Version
+}
+
+// Template is a loaded template
+type Template struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	HTML    string `json:"-"`
+}
+
+// Ref returns the pinned reference of the template
+func (t Template) Ref() Ref {
+	return Ref{Name: t.Name, Version: t.Version}
+}
+
+// Source fetches templates from a repository. A reference without a version fetches
+// the latest version, and the returned template names the version it is.
+type Source interface {
+	Fetch(ctx context.Context, ref Ref) (Template, error)
+}
+
+// Config configures a template store reading from either a directory or an HTTP
+// repository
+type Config struct {
+	// Dir holds a directory per template name with a <version>.html file per version.
+	// The latest version is the highest, comparing digit runs as numbers, so v10 is
+	// after v9.
+	Dir string `json:"dir,omitempty"`
+	// URL is fetched for each template, with NamePlaceholder and VersionPlaceholder
+	// replaced. A 404 means the template is not found.
+	URL string `json:"url,omitempty"`
+	// Pins are the versions served for references without one, by template name
+	Pins map[string]string `json:"pins,omitempty"`
+	// Timeout bounds each fetch; zero selects DefaultTimeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// TTL is how long the latest version of a name is cached; zero selects DefaultTTL
+	// and negative looks it up for every reference
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// Validate checks that templates are read from either a directory or a valid http(s)
+// URL, with valid pins and a non-negative timeout
+func (c Config) Validate() error {
+	switch {
+	case c.Dir != "" && c.URL != "":
+		return errors.New("dir and url are exclusive")
+	case c.Dir != "":
+		info, err := os.Stat(c.Dir)
+		if err != nil {
+			return fmt.Errorf("template directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("template directory: %s is not a directory", c.Dir)
+		}
+	case c.URL != "":
+		replacer := strings.NewReplacer(NamePlaceholder, "name", VersionPlaceholder, "version")
+		u, err := url.Parse(replacer.Replace(c.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url %q: want an http or https URL", c.URL)
+		}
+	default:
+		return errors.New("a template dir or url is required")
+	}
+	for name, version := range c.Pins {
+		if !namePattern.MatchString(name) || !namePattern.MatchString(version) {
+			return fmt.Errorf("invalid pin %s@%s", name, version)
+		}
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// Source returns the source the configuration names; the HTTP source fetches with
+// http.DefaultClient
+func (c Config) Source() Source {
+	if c.URL != "" {
+		return NewHTTPSource(c.URL, nil)
+	}
+	return NewDirSource(c.Dir)
+}
+
+// dirSource reads templates from a directory of versioned files
+type dirSource struct {
+	dir string
+}
+
+// NewDirSource creates a source reading templates from dir (see Config.Dir)
+func NewDirSource(dir string) Source {
+	return &dirSource{dir: dir}
+}
+
+// Fetch reads the template file of ref, or of the latest version of its name
+func (d *dirSource) Fetch(_ context.Context, ref Ref) (Template, error) {
+	if ref.Version == "" {
+		version, err := d.latest(ref.Name)
+		if err != nil {
+			return Template{}, err
+		}
+		ref.Version = version
+	}
+
+	content, err := os.ReadFile(filepath.Join(d.dir, ref.Name, ref.Version+".html"))
+	if errors.Is(err, os.ErrNotExist) {
+		return Template{}, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	if err != nil {
+		return Template{}, err
+	}
+	return Template{Name: ref.Name, Version: ref.Version, HTML: string(content)}, nil
+}
+
+// latest returns the highest version of name in the directory
+func (d *dirSource) latest(name string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(d.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	latest := ""
+	for _, entry := range entries {
+		version, isTemplate := strings.CutSuffix(entry.Name(), ".html")
+		if entry.IsDir() || !isTemplate || !namePattern.MatchString(version) {
+			continue
+		}
+		if latest == "" || CompareVersions(version, latest) > 0 {
+			latest = version
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("%w: %s has no versions", ErrNotFound, name)
+	}
+	return latest, nil
+}
+
+// httpSource fetches templates with GET requests to a template repository
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSource creates a source fetching templates from rawURL (see Config.URL) with
+// client, or http.DefaultClient when client is nil
+func NewHTTPSource(rawURL string, client *http.Client) Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSource{url: rawURL, client: client}
+}
+
+// Fetch fetches the template of ref. Without a version LatestVersion is fetched, and the
+// version served is read from VersionHeader.
+func (h *httpSource) Fetch(ctx context.Context, ref Ref) (Template, error) {
+	version := ref.Version
+	if version == "" {
+		version = LatestVersion
+	}
+	target := h.url
+	if strings.Contains(target, NamePlaceholder) || strings.Contains(target, VersionPlaceholder) {
+		target = strings.NewReplacer(
+			NamePlaceholder, url.PathEscape(ref.Name),
+			VersionPlaceholder, url.PathEscape(version),
+		).Replace(target)
+	} else {
+		target = strings.TrimSuffix(target, "/") + "/" + url.PathEscape(ref.Name) + "/" + url.PathEscape(version)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return Template{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return Template{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Template{}, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Template{}, fmt.Errorf("fetching template %s: HTTP %d", ref, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTemplateSize))
+	if err != nil {
+		return Template{}, err
+	}
+
+	if served := resp.Header.Get(VersionHeader); ref.Version == "" && namePattern.MatchString(served) {
+		version = served
+	}
+	return Template{Name: ref.Name, Version: version, HTML: string(body)}, nil
+}
+
+// Store loads templates from a source, applying pins and caching them
+type Store struct {
+	source  Source
+	pins    map[string]string
+	timeout time.Duration
+	ttl     time.Duration
+	now     func() time.Time
+
+	// versions holds the templates loaded by version, latest the template last
+	// found to be the latest of a name
+	versions map[Ref]Template
+	latest   map[string]latestEntry
+	mutex    sync.Mutex
+}
+
+// latestEntry is the cached latest template of a name
+type latestEntry struct {
+	template Template
+	expires  time.Time
+}
+
+// NewStore creates a store loading templates from source, with the pins, timeout and
+// TTL of config
+func NewStore(source Source, config Config) *Store {
+	store := &Store{
+		source:   source,
+		pins:     make(map[string]string, len(config.Pins)),
+		timeout:  config.Timeout,
+		ttl:      config.TTL,
+		now:      time.Now,
+		versions: make(map[Ref]Template),
+		latest:   make(map[string]latestEntry),
+	}
+	for name, version := range config.Pins {
+		store.pins[name] = version
+	}
+	if store.timeout == 0 {
+		store.timeout = DefaultTimeout
+	}
+	if store.ttl == 0 {
+		store.ttl = DefaultTTL
+	}
+	return store
+}
+
+// Load returns the template of a name@version reference
+func (s *Store) Load(reference string) (Template, error) {
+	ref, err := ParseRef(reference)
+	if err != nil {
+		return Template{}, err
+	}
+	return s.Get(ref)
+}
+
+// Get returns the template of ref: its version, the version pinned for its name, or
+// the latest version. The latest version, whether asked for without a version or as
+// LatestVersion, is only cached for the TTL. Failed fetches are not cached.
+func (s *Store) Get(ref Ref) (Template, error) {
+	now := s.now()
+	s.mutex.Lock()
+	if pinned, ok := s.pins[ref.Name]; ok && ref.Version == "" {
+		ref.Version = pinned
+	}
+	latest := ref.Version == "" || ref.Version == LatestVersion
+	var template Template
+	var cached bool
+	if latest {
+		var entry latestEntry
+		entry, cached = s.latest[ref.Name]
+		cached = cached && now.Before(entry.expires)
+		template = entry.template
+	} else {
+		template, cached = s.versions[ref]
+	}
+	s.mutex.Unlock()
+	if cached {
+		return template, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	template, err := s.source.Fetch(ctx, ref)
+	if err != nil {
+		return Template{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if template.Version != "" && template.Version != LatestVersion {
+		s.versions[template.Ref()] = template
+	}
+	if latest && s.ttl > 0 {
+		s.latest[ref.Name] = latestEntry{template: template, expires: now.Add(s.ttl)}
+	}
+	return template, nil
+}
+
+// Pins returns the versions pinned by template name
+func (s *Store) Pins() map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pins := make(map[string]string, len(s.pins))
+	for name, version := range s.pins {
+		pins[name] = version
+	}
+	return pins
+}
+
+// Pin serves version for references to name without a version; an empty version
+// removes the pin, serving the latest version again
+func (s *Store) Pin(name, version string) error {
+	if !namePattern.MatchString(name) || (version != "" && !namePattern.MatchString(version)) {
+		return fmt.Errorf("invalid pin %s@%s", name, version)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if version == "" {
+		delete(s.pins, name)
+	} else {
+		s.pins[name] = version
+	}
+	return nil
+}
+
+// Clear empties the cache, so templates are fetched again
+func (s *Store) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.versions = make(map[Ref]Template)
+	s.latest = make(map[string]latestEntry)
+}
+
+// CompareVersions orders versions by their runs of digits as numbers and their other
+// runs as text, so v9 < v10 and 1.2 < 1.10; it returns -1, 0 or 1
+func CompareVersions(a, b string) int {
+	for a != "" && b != "" {
+		partA, restA := versionPart(a)
+		partB, restB := versionPart(b)
+		if isDigit(partA[0]) && isDigit(partB[0]) {
+			partA, partB = strings.TrimLeft(partA, "0"), strings.TrimLeft(partB, "0")
+			if len(partA) != len(partB) {
+				return compareInts(len(partA), len(partB))
+			}
+		}
+		if partA != partB {
+			return strings.Compare(partA, partB)
+		}
+		a, b = restA, restB
+	}
+	return compareInts(len(a), len(b))
+}
+
+// versionPart splits the leading run of digits or of other characters off version
+func versionPart(version string) (string, string) {
+	digits := isDigit(version[0])
+	end := 1
+	for end < len(version) && isDigit(version[end]) == digits {
+		end++
+	}
+	return version[:end], version[end:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
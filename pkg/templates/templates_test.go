@@ -0,0 +1,252 @@
+package templates
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTemplates creates a template directory with the files given by name/version
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for path, content := range files {
+		path = filepath.Join(dir, filepath.FromSlash(path)+".html")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+// countingSource counts the fetches of the source it wraps
+type countingSource struct {
+	Source
+	fetches atomic.Int32
+}
+
+func (c *countingSource) Fetch(ctx context.Context, ref Ref) (Template, error) {
+	c.fetches.Add(1)
+	return c.Source.Fetch(ctx, ref)
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		reference string
+		ref       Ref
+		valid     bool
+	}{
+		{"checkout@v42", Ref{Name: "checkout", Version: "v42"}, true},
+		{"checkout", Ref{Name: "checkout"}, true},
+		{"home-page@1.2.0", Ref{Name: "home-page", Version: "1.2.0"}, true},
+		{"", Ref{}, false},
+		{"checkout@", Ref{}, false},
+		{"../etc@v1", Ref{}, false},
+		{"checkout@v1/../../x", Ref{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.reference, func(t *testing.T) {
+			ref, err := ParseRef(test.reference)
+			if !test.valid {
+				var refErr *RefError
+				assert.ErrorAs(t, err, &refErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.ref, ref)
+			assert.Equal(t, test.reference, ref.String())
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, -1, CompareVersions("v9", "v10"))
+	assert.Equal(t, 1, CompareVersions("1.10", "1.2"))
+	assert.Equal(t, 0, CompareVersions("v042", "v42"))
+	assert.Equal(t, -1, CompareVersions("v1", "v1.1"))
+	assert.Equal(t, -1, CompareVersions("a", "b"))
+}
+
+func TestDirSource_Fetch(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"checkout/v9":  "<p>v9</p>",
+		"checkout/v10": "<p>v10</p>",
+	})
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "empty"), 0o755))
+	source := NewDirSource(dir)
+
+	template, err := source.Fetch(context.Background(), Ref{Name: "checkout", Version: "v9"})
+	require.NoError(t, err)
+	assert.Equal(t, Template{Name: "checkout", Version: "v9", HTML: "<p>v9</p>"}, template)
+
+	template, err = source.Fetch(context.Background(), Ref{Name: "checkout"})
+	require.NoError(t, err)
+	assert.Equal(t, "v10", template.Version)
+
+	_, err = source.Fetch(context.Background(), Ref{Name: "checkout", Version: "v11"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = source.Fetch(context.Background(), Ref{Name: "missing"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = source.Fetch(context.Background(), Ref{Name: "empty"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestHTTPSource_Fetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/templates/checkout/latest":
+			w.Header().Set(VersionHeader, "v42")
+			w.Write([]byte("<p>latest</p>"))
+		case "/templates/checkout/v41":
+			w.Write([]byte("<p>v41</p>"))
+		case "/templates/broken/v1":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, rawURL := range []string{server.URL + "/templates", server.URL + "/templates/{name}/{version}"} {
+		source := NewHTTPSource(rawURL, nil)
+
+		template, err := source.Fetch(context.Background(), Ref{Name: "checkout", Version: "v41"})
+		require.NoError(t, err)
+		assert.Equal(t, Template{Name: "checkout", Version: "v41", HTML: "<p>v41</p>"}, template)
+
+		template, err = source.Fetch(context.Background(), Ref{Name: "checkout"})
+		require.NoError(t, err)
+		assert.Equal(t, Template{Name: "checkout", Version: "v42", HTML: "<p>latest</p>"}, template)
+
+		_, err = source.Fetch(context.Background(), Ref{Name: "missing", Version: "v1"})
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = source.Fetch(context.Background(), Ref{Name: "broken", Version: "v1"})
+		assert.ErrorContains(t, err, "HTTP 500")
+	}
+}
+
+func TestStore_CachingAndPins(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"checkout/v41": "<p>v41</p>",
+		"checkout/v42": "<p>v42</p>",
+	})
+	source := &countingSource{Source: NewDirSource(dir)}
+	store := NewStore(source, Config{Pins: map[string]string{"checkout": "v41"}})
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// References without a version get the pinned one, served from the cache after
+	template, err := store.Load("checkout")
+	require.NoError(t, err)
+	assert.Equal(t, "v41", template.Version)
+	template, err = store.Load("checkout@v41")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>v41</p>", template.HTML)
+	assert.Equal(t, int32(1), source.fetches.Load())
+
+	// Without the pin the latest version is cached for the TTL
+	require.NoError(t, store.Pin("checkout", ""))
+	template, err = store.Load("checkout")
+	require.NoError(t, err)
+	assert.Equal(t, "v42", template.Version)
+	assert.Equal(t, int32(2), source.fetches.Load())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "checkout", "v43.html"), []byte("<p>v43</p>"), 0o644))
+	template, err = store.Load("checkout")
+	require.NoError(t, err)
+	assert.Equal(t, "v42", template.Version)
+	assert.Equal(t, int32(2), source.fetches.Load())
+
+	now = now.Add(DefaultTTL)
+	template, err = store.Load("checkout")
+	require.NoError(t, err)
+	assert.Equal(t, "v43", template.Version)
+	assert.Equal(t, map[string]string{}, store.Pins())
+
+	// Versions stay cached until cleared
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "checkout", "v41.html"), []byte("<p>changed</p>"), 0o644))
+	template, err = store.Load("checkout@v41")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>v41</p>", template.HTML)
+	store.Clear()
+	template, err = store.Load("checkout@v41")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>changed</p>", template.HTML)
+
+	_, err = store.Load("checkout@v1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Load("bad name")
+	var refErr *RefError
+	assert.ErrorAs(t, err, &refErr)
+	assert.Error(t, store.Pin("checkout", "../v1"))
+}
+
+func TestStore_LatestExpires(t *testing.T) {
+	var served atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		if r.URL.Path != "/checkout/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		// No VersionHeader, so the template is named after LatestVersion
+		w.Write([]byte("<p>" + strconv.Itoa(int(served.Load())) + "</p>"))
+	}))
+	defer server.Close()
+
+	store := NewStore(NewHTTPSource(server.URL, nil), Config{})
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// Within the TTL both spellings of the latest version are served from the cache
+	template, err := store.Load("checkout")
+	require.NoError(t, err)
+	assert.Equal(t, Template{Name: "checkout", Version: LatestVersion, HTML: "<p>1</p>"}, template)
+	template, err = store.Load("checkout@latest")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>1</p>", template.HTML)
+	assert.Equal(t, int32(1), served.Load())
+
+	// After the TTL it is fetched again, rather than pinned by the version cache
+	now = now.Add(DefaultTTL)
+	template, err = store.Load("checkout@latest")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>2</p>", template.HTML)
+	now = now.Add(DefaultTTL)
+	template, err = store.Load("checkout")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>3</p>", template.HTML)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, Config{Dir: dir, Pins: map[string]string{"checkout": "v42"}}.Validate())
+	assert.NoError(t, Config{URL: "https://templates.example.com/{name}/{version}"}.Validate())
+
+	tests := []struct {
+		config Config
+		err    string
+	}{
+		{Config{}, "dir or url is required"},
+		{Config{Dir: dir, URL: "https://templates.example.com"}, "exclusive"},
+		{Config{Dir: filepath.Join(dir, "missing")}, "template directory"},
+		{Config{URL: "ftp://templates.example.com"}, "invalid url"},
+		{Config{Dir: dir, Pins: map[string]string{"checkout": "v/42"}}, "invalid pin"},
+		{Config{Dir: dir, Timeout: -time.Second}, "timeout"},
+	}
+	for _, test := range tests {
+		assert.ErrorContains(t, test.config.Validate(), test.err)
+	}
+
+	_, isHTTP := Config{URL: "https://templates.example.com"}.Source().(*httpSource)
+	assert.True(t, isHTTP)
+	_, isDir := Config{Dir: dir}.Source().(*dirSource)
+	assert.True(t, isDir)
+}